@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/fabiolb/fabio/proxy"
+)
+
+// CaptureHandler provides a fetch and update handler for the settings
+// of the body capture of the routes with the 'capturebody' option.
+type CaptureHandler struct {
+	Capture *proxy.BodyCapture
+}
+
+func (h *CaptureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Capture == nil {
+		http.Error(w, "body capture not configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, r, h.Capture.Settings())
+
+	case "PUT":
+		// fields which are not provided keep their values
+		s := h.Capture.Settings()
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := h.Capture.Update(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, h.Capture.Settings())
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
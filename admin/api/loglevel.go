@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/logger"
+)
+
+// LogLevel is the request and response body of the log level handler.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler provides a fetch and update handler for the log level.
+// The new level applies to all log messages immediately and is reset to
+// the configured log.level on restart.
+type LogLevelHandler struct {
+	Log *logger.LevelWriter
+}
+
+func (h *LogLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Log == nil {
+		http.Error(w, "log level not configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, r, LogLevel{Level: h.Log.Level()})
+
+	case "PUT":
+		var l LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		prev := h.Log.Level()
+		if !h.Log.SetLevel(l.Level) {
+			http.Error(w, "invalid log level "+l.Level, http.StatusBadRequest)
+			return
+		}
+		// logged as warning so that the change is visible
+		// unless the new level is ERROR or FATAL.
+		log.Printf("[WARN] Log level changed from %s to %s", prev, strings.ToUpper(l.Level))
+		writeJSON(w, r, LogLevel{Level: h.Log.Level()})
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
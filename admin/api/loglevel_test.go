@@ -0,0 +1,53 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/logger"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	lw := logger.NewLevelWriter(ioutil.Discard, "INFO", "")
+	h := &LogLevelHandler{Log: lw}
+
+	tests := []struct {
+		desc, method, body string
+		code               int
+		resp, level        string
+	}{
+		{"get", "GET", "", 200, `{"level":"INFO"}`, "INFO"},
+		{"set", "PUT", `{"level":"debug"}`, 200, `{"level":"DEBUG"}`, "DEBUG"},
+		{"invalid level", "PUT", `{"level":"verbose"}`, 400, "invalid log level verbose\n", "DEBUG"},
+		{"invalid json", "PUT", `level=trace`, 400, "", "DEBUG"},
+		{"method", "POST", `{"level":"trace"}`, 405, "not allowed\n", "DEBUG"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/log/level", bytes.NewBufferString(tt.body)))
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got code %d want %d", got, want)
+			}
+			if tt.resp != "" {
+				if got, want := strings.TrimSpace(rec.Body.String()), strings.TrimSpace(tt.resp); got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+			}
+			if got, want := lw.Level(), tt.level; got != want {
+				t.Fatalf("got level %s want %s", got, want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	(&LogLevelHandler{}).ServeHTTP(rec, httptest.NewRequest("GET", "/api/log/level", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+}
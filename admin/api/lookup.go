@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+// LookupHandler returns the target which the proxy would route a
+// request for the given URL to without sending a request (dry run).
+//
+//	GET /api/lookup?url=http://example.com/foo
+//
+// Headers which are used for routing decisions can be added as
+// additional query parameters with the 'header.' prefix, e.g.
+// header.Accept=application/json.
+type LookupHandler struct {
+	Config    *config.Config
+	GlobCache *route.GlobCache
+}
+
+type apiLookup struct {
+	Service  string `json:"service"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	Strategy string `json:"strategy"`
+}
+
+func (h *LookupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	u, err := url.Parse(q.Get("url"))
+	if err != nil || u.Host == "" {
+		http.Error(w, "url parameter must be an absolute URL", http.StatusBadRequest)
+		return
+	}
+
+	req := &http.Request{Method: "GET", Host: u.Host, URL: u, Header: http.Header{}}
+	for k, v := range q {
+		if strings.HasPrefix(k, "header.") {
+			req.Header[http.CanonicalHeaderKey(strings.TrimPrefix(k, "header."))] = v
+		}
+	}
+
+	strategy := h.Config.Proxy.Strategy
+	pick := route.RequestPicker(strategy, req)
+	match := route.Matcher[h.Config.Proxy.Matcher]
+	if pick == nil || match == nil {
+		http.Error(w, "invalid strategy or matcher", http.StatusInternalServerError)
+		return
+	}
+
+	t := route.GetTable().Lookup(req, "", pick, match, h.GlobCache, h.Config.GlobMatchingDisabled)
+	if t == nil {
+		http.Error(w, "no route", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, apiLookup{
+		Service:  t.Service,
+		Src:      t.Prefix,
+		Dst:      t.URL.String(),
+		Strategy: strategy,
+	})
+}
@@ -21,7 +21,8 @@ type manual struct {
 func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// we need this for testing.
 	// under normal circumstances this is never nil
-	if registry.Default == nil {
+	be := registry.Default()
+	if be == nil {
 		return
 	}
 
@@ -29,7 +30,7 @@ func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "GET":
-		value, version, err := registry.Default.ReadManual(path)
+		value, version, err := be.ReadManual(path)
 		if err != nil {
 			log.Print("[ERROR] ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -47,7 +48,7 @@ func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		defer r.Body.Close()
 
-		ok, err := registry.Default.WriteManual(path, m.Value, m.Version)
+		ok, err := be.WriteManual(path, m.Value, m.Version)
 		if err != nil {
 			log.Print("[ERROR] ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -15,13 +15,14 @@ type ManualPathsHandler struct {
 func (h *ManualPathsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// we need this for testing.
 	// under normal circumstances this is never nil
-	if registry.Default == nil {
+	be := registry.Default()
+	if be == nil {
 		return
 	}
 
 	switch r.Method {
 	case "GET":
-		paths, err := registry.Default.ManualPaths()
+		paths, err := be.ManualPaths()
 		if err != nil {
 			log.Print("[ERROR] ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -23,6 +23,10 @@ type apiRoute struct {
 	Cmd     string   `json:"cmd"`
 	Rate1   float64  `json:"rate1"`
 	Pct99   float64  `json:"pct99"`
+
+	// Inactive is true for the manual overrides which do
+	// not match a route, e.g. since the service is down.
+	Inactive bool `json:"inactive,omitempty"`
 }
 
 func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +35,13 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if _, ok := r.URL.Query()["raw"]; ok {
 		w.Header().Set("Content-Type", "text/plain")
 		fmt.Fprintln(w, t.String())
+		for _, d := range route.InactiveOverrides() {
+			fmt.Fprintf(w, "# inactive: %s %s %s weight %.4f", d.Cmd, d.Service, d.Src, d.Weight)
+			if len(d.Tags) > 0 {
+				fmt.Fprintf(w, " tags %q", strings.Join(d.Tags, ","))
+			}
+			fmt.Fprintln(w)
+		}
 		return
 	}
 
@@ -66,5 +77,15 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	for _, d := range route.InactiveOverrides() {
+		routes = append(routes, apiRoute{
+			Service:  d.Service,
+			Src:      d.Src,
+			Weight:   d.Weight,
+			Tags:     d.Tags,
+			Cmd:      string(d.Cmd),
+			Inactive: true,
+		})
+	}
 	writeJSON(w, r, routes)
 }
@@ -10,7 +10,9 @@ import (
 	"github.com/fabiolb/fabio/admin/ui"
 	_ "github.com/fabiolb/fabio/admin/ui/statik"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/route"
 	"github.com/rakyll/statik/fs"
 )
 
@@ -22,6 +24,14 @@ type Server struct {
 	Version  string
 	Commands string
 	Cfg      *config.Config
+
+	// Capture is the body capture which is controlled
+	// through /api/capture in read-write mode.
+	Capture *proxy.BodyCapture
+
+	// Log is the log output whose level is controlled
+	// through /api/log/level in read-write mode.
+	Log *logger.LevelWriter
 }
 
 // ListenAndServe starts the admin server.
@@ -35,6 +45,8 @@ func (s *Server) handler() http.Handler {
 	switch s.Access {
 	case "ro":
 		mux.HandleFunc("/api/paths", forbidden)
+		mux.HandleFunc("/api/capture", forbidden)
+		mux.HandleFunc("/api/log/level", forbidden)
 		mux.HandleFunc("/api/manual", forbidden)
 		mux.HandleFunc("/api/manual/", forbidden)
 		mux.HandleFunc("/manual", forbidden)
@@ -44,6 +56,8 @@ func (s *Server) handler() http.Handler {
 		// but Consul treats all KV paths without a leading slash.
 		pathsPrefix := strings.TrimPrefix(s.Cfg.Registry.Consul.KVPath, "/")
 		mux.Handle("/api/paths", &api.ManualPathsHandler{Prefix: pathsPrefix})
+		mux.Handle("/api/capture", &api.CaptureHandler{Capture: s.Capture})
+		mux.Handle("/api/log/level", &api.LogLevelHandler{Log: s.Log})
 		mux.Handle("/api/manual", &api.ManualHandler{BasePath: "/api/manual"})
 		mux.Handle("/api/manual/", &api.ManualHandler{BasePath: "/api/manual"})
 		mux.Handle("/manual", &ui.ManualHandler{
@@ -64,6 +78,7 @@ func (s *Server) handler() http.Handler {
 
 	mux.Handle("/api/config", &api.ConfigHandler{Config: s.Cfg})
 	mux.Handle("/api/routes", &api.RoutesHandler{})
+	mux.Handle("/api/lookup", &api.LookupHandler{Config: s.Cfg, GlobCache: route.NewGlobCache(s.Cfg.GlobCacheSize)})
 	mux.Handle("/api/version", &api.VersionHandler{Version: s.Version})
 	mux.Handle("/routes", &ui.RoutesHandler{Color: s.Color, Title: s.Title, Version: s.Version})
 	mux.HandleFunc("/health", handleHealth)
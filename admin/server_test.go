@@ -1,11 +1,14 @@
 package admin
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/proxy"
 )
 
 func TestAdminServerAccess(t *testing.T) {
@@ -16,7 +19,9 @@ func TestAdminServerAccess(t *testing.T) {
 
 	testAccess := func(access string, tests []test) {
 		srv := &Server{
-			Access: access,
+			Access:  access,
+			Capture: &proxy.BodyCapture{},
+			Log:     logger.NewLevelWriter(ioutil.Discard, "INFO", ""),
 			Cfg: &config.Config{
 				Registry: config.Registry{
 					Consul: config.Consul{
@@ -49,8 +54,11 @@ func TestAdminServerAccess(t *testing.T) {
 	roTests := []test{
 		{"/api/manual", 403},
 		{"/api/paths", 403},
+		{"/api/capture", 403},
+		{"/api/log/level", 403},
 		{"/api/config", 200},
 		{"/api/routes", 200},
+		{"/api/lookup", 400},
 		{"/api/version", 200},
 		{"/manual", 403},
 		{"/routes", 200},
@@ -63,8 +71,11 @@ func TestAdminServerAccess(t *testing.T) {
 	rwTests := []test{
 		{"/api/manual", 200},
 		{"/api/paths", 200},
+		{"/api/capture", 200},
+		{"/api/log/level", 200},
 		{"/api/config", 200},
 		{"/api/routes", 200},
+		{"/api/lookup", 400},
 		{"/api/version", 200},
 		{"/manual", 200},
 		{"/routes", 200},
@@ -0,0 +1,67 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+)
+
+// ClientCAs manages the pool of CA certificates which verifies the
+// client certificates of a TLS server config. The pool is loaded from
+// the certificate source with Reload and applied to all new handshakes
+// without affecting the established connections.
+type ClientCAs struct {
+	// Source provides the CA certificates with LoadClientCAs.
+	Source Source
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+}
+
+// Add verifies the client certificates of the config with the current
+// pool if the config requires client certificates. The pool of the
+// config is used until the first reload.
+func (c *ClientCAs) Add(cfg *tls.Config) {
+	if cfg == nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		return
+	}
+
+	c.mu.Lock()
+	if c.pool == nil {
+		c.pool = cfg.ClientCAs
+	}
+	c.mu.Unlock()
+
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		x := cfg.Clone()
+		x.ClientCAs = c.Pool()
+		return x, nil
+	}
+}
+
+// Pool returns the current pool of CA certificates.
+func (c *ClientCAs) Pool() *x509.CertPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pool
+}
+
+// Reload replaces the pool with the CA certificates from the source.
+// The current pool is kept if the certificates cannot be loaded or if
+// the source provides none since an empty pool would reject all
+// clients.
+func (c *ClientCAs) Reload() error {
+	pool, err := c.Source.LoadClientCAs()
+	if err != nil {
+		return err
+	}
+	if pool == nil {
+		return errors.New("cert: no client CA certificates")
+	}
+
+	c.mu.Lock()
+	c.pool = pool
+	c.mu.Unlock()
+	return nil
+}
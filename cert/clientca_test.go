@@ -0,0 +1,112 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientCAs(t *testing.T) {
+	// newClient creates a self-signed client certificate
+	newClient := func(name string) (tls.Certificate, *x509.CertPool) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+	}
+
+	// handshake connects with the client certificate to the server
+	handshake := func(srvcfg *tls.Config, cert tls.Certificate) error {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		errc := make(chan error, 1)
+		go func() {
+			c, err := l.Accept()
+			if err != nil {
+				errc <- err
+				return
+			}
+			srv := tls.Server(c, srvcfg)
+			errc <- srv.Handshake()
+			srv.Close()
+		}()
+		c, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}})
+		if err == nil {
+			defer c.Close()
+		}
+		return <-errc
+	}
+
+	partner, partnerPool := newClient("partner")
+	other, otherPool := newClient("other")
+
+	srvcfg := &tls.Config{
+		Certificates: []tls.Certificate{makeCert("localhost", time.Minute)},
+		ClientCAs:    partnerPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	cas := &ClientCAs{Source: StaticSource{pool: otherPool}}
+	cas.Add(srvcfg)
+
+	if err := handshake(srvcfg, partner); err != nil {
+		t.Fatalf("got %v before reload", err)
+	}
+	if err := handshake(srvcfg, other); err == nil {
+		t.Fatal("client of unknown CA was accepted before reload")
+	}
+
+	if err := cas.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(srvcfg, other); err != nil {
+		t.Fatalf("got %v after reload", err)
+	}
+	if err := handshake(srvcfg, partner); err == nil {
+		t.Fatal("client of removed CA was accepted after reload")
+	}
+
+	cas.Source = StaticSource{}
+	if err := cas.Reload(); err == nil {
+		t.Fatal("empty client CA pool was loaded")
+	}
+	if got, want := cas.Pool(), otherPool; got != want {
+		t.Fatal("client CA pool was replaced by empty pool")
+	}
+
+	cfg := &tls.Config{}
+	cas.Add(cfg)
+	if cfg.GetConfigForClient != nil {
+		t.Fatal("client CAs were added to config without client certificates")
+	}
+}
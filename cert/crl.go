@@ -0,0 +1,133 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+// CRL rejects client certificates which have been revoked by their
+// issuer. The certificate revocation lists are loaded from a file and
+// reloaded with Reload. The signature of a list is verified with the
+// issuer in the verified chain of the client certificate. Lists of
+// other issuers are ignored.
+type CRL struct {
+	// Path is the path to a file with one or more PEM or a single DER
+	// encoded certificate revocation list.
+	Path string
+
+	mu    sync.RWMutex
+	lists []*x509.RevocationList
+}
+
+// Add rejects the revoked client certificates for the config if it
+// verifies client certificates.
+func (c *CRL) Add(cfg *tls.Config) {
+	if cfg == nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		return
+	}
+	cfg.VerifyConnection = c.VerifyConnection
+}
+
+// Reload replaces the revocation lists with the lists from the file.
+// The current lists are kept if the file cannot be loaded.
+func (c *CRL) Reload() error {
+	b, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+	lists, err := parseCRLs(b)
+	if err != nil {
+		return fmt.Errorf("cert: invalid CRL file %s. %s", c.Path, err)
+	}
+	for _, l := range lists {
+		if !l.NextUpdate.IsZero() && time.Now().After(l.NextUpdate) {
+			log.Printf("[WARN] cert: CRL of %s in %s expired on %s", l.Issuer, c.Path, l.NextUpdate.Format(time.RFC3339))
+		}
+	}
+
+	c.mu.Lock()
+	c.lists = lists
+	c.mu.Unlock()
+	return nil
+}
+
+// VerifyConnection returns an error if a certificate in one of the
+// verified chains of the client has been revoked. The TLS handshake is
+// then aborted with a bad certificate alert. Unlike the verification of
+// the peer certificate it is also called for resumed sessions.
+func (c *CRL) VerifyConnection(cs tls.ConnectionState) error {
+	c.mu.RLock()
+	lists := c.lists
+	c.mu.RUnlock()
+
+	for _, chain := range cs.VerifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			cert, issuer := chain[i], chain[i+1]
+			if err := revoked(lists, cert, issuer); err != nil {
+				log.Printf("[INFO] cert: Rejecting client certificate %q. %s", cert.Subject, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// revoked returns an error if one of the lists of the issuer contains
+// the serial number of the certificate.
+func revoked(lists []*x509.RevocationList, cert, issuer *x509.Certificate) error {
+	for _, l := range lists {
+		if !bytes.Equal(l.RawIssuer, cert.RawIssuer) {
+			continue
+		}
+		if err := l.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		for _, e := range l.RevokedCertificateEntries {
+			if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %s was revoked on %s", cert.SerialNumber, e.RevocationTime.Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
+
+// parseCRLs parses the PEM blocks of type "X509 CRL" in b or b as a
+// single DER encoded list if it does not contain PEM data.
+func parseCRLs(b []byte) ([]*x509.RevocationList, error) {
+	var lists []*x509.RevocationList
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		l, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	if len(lists) > 0 {
+		return lists, nil
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, errors.New("no revocation list found")
+	}
+	l, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.RevocationList{l}, nil
+}
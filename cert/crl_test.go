@@ -0,0 +1,115 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCRL(t *testing.T) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	newCert := func(tmpl, parent *x509.Certificate, pub, priv interface{}) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	newCRL := func(ca *x509.Certificate, key *ecdsa.PrivateKey, serials ...int64) []byte {
+		tmpl := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}
+		for _, n := range serials {
+			tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{SerialNumber: big.NewInt(n), RevocationTime: time.Now()})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caKey, otherKey := newKey(), newKey()
+	ca := newCert(caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	other := newCert(caTmpl, caTmpl, &otherKey.PublicKey, otherKey)
+
+	client := func(serial int64) tls.ConnectionState {
+		key := newKey()
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "client"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		return tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{newCert(tmpl, ca, &key.PublicKey, caKey), ca}}}
+	}
+	good, revoked := client(2), client(3)
+
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "clients.crl")
+
+	// the list of the other CA has the same issuer name but is
+	// not signed by the issuer of the client certificates.
+	writeFile(path, append(newCRL(ca, caKey, 3), newCRL(other, otherKey, 2)...))
+	crl := &CRL{Path: path}
+	if err := crl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.VerifyConnection(good); err != nil {
+		t.Fatalf("got %v for valid certificate", err)
+	}
+	if err := crl.VerifyConnection(revoked); err == nil {
+		t.Fatal("revoked certificate was accepted")
+	}
+
+	writeFile(path, newCRL(ca, caKey))
+	if err := crl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.VerifyConnection(revoked); err != nil {
+		t.Fatalf("got %v after reload", err)
+	}
+
+	writeFile(path, []byte("invalid"))
+	if err := crl.Reload(); err == nil {
+		t.Fatal("invalid CRL file was loaded")
+	}
+
+	cfg := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+	crl.Add(cfg)
+	if cfg.VerifyConnection == nil {
+		t.Fatal("CRL was not added to config with client certificates")
+	}
+	cfg = &tls.Config{}
+	crl.Add(cfg)
+	if cfg.VerifyConnection != nil {
+		t.Fatal("CRL was added to config without client certificates")
+	}
+}
@@ -27,6 +27,6 @@ func (s HTTPSource) LoadClientCAs() (*x509.CertPool, error) {
 
 func (s HTTPSource) Certificates() chan []tls.Certificate {
 	ch := make(chan []tls.Certificate, 1)
-	go watch(ch, s.Refresh, s.CertURL, loadURL)
+	go watch(ch, s.Refresh, s.CertURL, loadURL, nil)
 	return ch
 }
@@ -104,10 +104,20 @@ func loadPath(root string) (pemBlocks map[string][]byte, err error) {
 	return pemBlocks, nil
 }
 
+// loadCertificates creates the certificates from the PEM files.
+// Certificates in <name>-cert.pem files use the key from the
+// <name>-key.pem file. Other .pem files contain either the certificate
+// and the key or only one of them. A certificate without a key is
+// paired with a key file in the same directory which matches its
+// public key, e.g. fullchain.pem and privkey.pem from Let's Encrypt.
+// Certificates without a matching key, e.g. CA chains, are skipped.
 func loadCertificates(pemBlocks map[string][]byte) ([]tls.Certificate, error) {
 	var n []string
 	x := map[string]tls.Certificate{}
 
+	// .pem files which contain only a certificate or only a key
+	var certOnly, keyOnly []string
+
 	for name := range pemBlocks {
 		var certFile, keyFile string
 		switch {
@@ -116,6 +126,15 @@ func loadCertificates(pemBlocks map[string][]byte) ([]tls.Certificate, error) {
 		case strings.HasSuffix(name, "-key.pem"):
 			certFile, keyFile = replaceSuffix(name, "-key.pem", "-cert.pem"), name
 		case strings.HasSuffix(name, ".pem"):
+			hasCert, hasKey := pemTypes(pemBlocks[name])
+			switch {
+			case hasCert && !hasKey:
+				certOnly = append(certOnly, name)
+				continue
+			case hasKey && !hasCert:
+				keyOnly = append(keyOnly, name)
+				continue
+			}
 			certFile, keyFile = name, name
 		default:
 			continue
@@ -139,6 +158,37 @@ func loadCertificates(pemBlocks map[string][]byte) ([]tls.Certificate, error) {
 		n = append(n, certFile)
 	}
 
+	// pair the certificates with the keys in the same directory. If
+	// several certificates match the same key, e.g. cert.pem and
+	// fullchain.pem, the one with the longest chain is used.
+	sort.Strings(certOnly)
+	paired := map[string]string{}
+	for _, certFile := range certOnly {
+		matched := false
+		for _, keyFile := range keyOnly {
+			if filepath.Dir(keyFile) != filepath.Dir(certFile) {
+				continue
+			}
+			c, err := tls.X509KeyPair(pemBlocks[certFile], pemBlocks[keyFile])
+			if err != nil {
+				continue
+			}
+			matched = true
+			if prev, ok := paired[keyFile]; ok && len(x[prev].Certificate) >= len(c.Certificate) {
+				break
+			}
+			paired[keyFile] = certFile
+			x[certFile] = c
+			break
+		}
+		if !matched {
+			log.Printf("[DEBUG] cert: No key for certificate %s", certFile)
+		}
+	}
+	for _, certFile := range paired {
+		n = append(n, certFile)
+	}
+
 	// append certificates in alphabetical order of the
 	// cert filenames. This determines which certificate
 	// becomes the default certificate (the first one)
@@ -151,6 +201,24 @@ func loadCertificates(pemBlocks map[string][]byte) ([]tls.Certificate, error) {
 	return certs, nil
 }
 
+// pemTypes returns whether the PEM data contains
+// a certificate and whether it contains a private key.
+func pemTypes(data []byte) (hasCert, hasKey bool) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			hasCert = true
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			hasKey = true
+		}
+	}
+}
+
 // base returns the rawurl with the last element of the path
 // removed. http://foo.com/x/y becomes http://foo.com/x
 func base(rawurl string) (string, error) {
@@ -3,7 +3,9 @@ package cert
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestBase(t *testing.T) {
@@ -106,3 +108,38 @@ n/iFVG4Y6zyXQY2RzTt+ZB2VPR72X4wqS9fBeQ==
 		t.Fatalf("got %v want %v", got, want)
 	}
 }
+
+func TestLoadCertificatesKeyPairing(t *testing.T) {
+	certPEM, keyPEM := makePEM("example.com", time.Minute)
+	chainPEM, _ := makePEM("ca.example.com", time.Minute)
+	otherPEM, otherKeyPEM := makePEM("other.com", time.Minute)
+
+	// the Let's Encrypt layout with the key for the other
+	// certificate in a different directory
+	pemBlocks := map[string][]byte{
+		"le/example.com/cert.pem":      certPEM,
+		"le/example.com/chain.pem":     chainPEM,
+		"le/example.com/fullchain.pem": append(append([]byte{}, certPEM...), chainPEM...),
+		"le/example.com/privkey.pem":   keyPEM,
+		"le/other.com/fullchain.pem":   otherPEM,
+		"le/privkey.pem":               otherKeyPEM,
+	}
+
+	certs, err := loadCertificates(pemBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(certs), 1; got != want {
+		t.Fatalf("got %d certificates want %d", got, want)
+	}
+	if got, want := len(certs[0].Certificate), 2; got != want {
+		t.Fatalf("got chain of %d certificates want %d", got, want)
+	}
+	leaf, err := x509.ParseCertificate(certs[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := leaf.DNSNames, []string{"example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+// +build linux
+
+package cert
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the events which indicate that
+// a file in the watched directory has changed.
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// notify returns a channel which receives a value when a file in the
+// directory tree below root is created, modified, renamed or removed.
+// Directories which are created later are watched as well.
+func notify(root string) (<-chan struct{}, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unix.InotifyAddWatch(fd, root, inotifyMask); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	addDirs(fd, root)
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				log.Printf("[WARN] cert: Stopped watching %s for changes. %v", root, err)
+				return
+			}
+
+			// watch new sub directories. Adding a watch for an
+			// already watched directory does not create a new one.
+			addDirs(fd, root)
+
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// addDirs adds inotify watches for the directories below root.
+func addDirs(fd int, root string) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == root {
+			return nil
+		}
+		if _, err := unix.InotifyAddWatch(fd, path, inotifyMask); err != nil {
+			log.Printf("[WARN] cert: Cannot watch %s for changes. %s", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package cert
+
+// notify returns a nil channel since change notifications are only
+// supported on Linux. The path source then only checks for changes
+// every refresh interval.
+func notify(root string) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -3,6 +3,7 @@ package cert
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"log"
 	"path/filepath"
 	"time"
 )
@@ -25,10 +26,24 @@ func (s PathSource) LoadClientCAs() (*x509.CertPool, error) {
 	return newCertPool(path, s.CAUpgradeCN, loadPath)
 }
 
+// Certificates loads the certificates from the directory tree below
+// the cert path and reloads them when files are added, modified or
+// removed. Changes are detected with inotify on Linux and by checking
+// the files every refresh interval. A refresh interval of zero loads
+// the certificates only once.
 func (s PathSource) Certificates() chan []tls.Certificate {
 	path := makePath(s.Path, s.CertPath, DefaultCertPath)
 	ch := make(chan []tls.Certificate, 1)
-	go watch(ch, s.Refresh, path, loadPath)
+
+	var changed <-chan struct{}
+	if s.Refresh > 0 {
+		var err error
+		if changed, err = notify(path); err != nil {
+			log.Printf("[WARN] cert: Cannot watch %s for changes. Checking every %s. %s", path, s.Refresh, err)
+		}
+	}
+
+	go watch(ch, s.Refresh, path, loadPath, changed)
 	return ch
 }
 
@@ -0,0 +1,219 @@
+package cert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// Secrets resolves secret references of the form <source>/<name> to
+// the content of the file or key <name> in the certificate source
+// <source>. This allows secrets like HMAC keys to be stored and
+// rotated alongside the certificates.
+//
+// The entries of a source are loaded on first use and then refreshed
+// with the refresh interval of the source. Consul sources are watched
+// for changes. Sources which cannot be loaded return the same error
+// until they are loaded again after a backoff.
+type Secrets struct {
+	// Sources contains the certificate sources by name.
+	Sources map[string]config.CertSource
+
+	mu     sync.Mutex
+	loaded map[string]*secretSource
+	failed map[string]*secretFailure
+}
+
+// secretRetry is the time after which a certificate source which could
+// not be loaded is loaded again. It is doubled for every failure up to
+// maxSecretRetry.
+var (
+	secretRetry    = time.Second
+	maxSecretRetry = time.Minute
+)
+
+// secretFailure is the cached error of a certificate source which
+// could not be loaded.
+type secretFailure struct {
+	err   error
+	wait  time.Duration
+	retry time.Time
+}
+
+// Get returns the secret for the reference. Leading and trailing
+// whitespace of the secret is removed.
+func (s *Secrets) Get(ref string) ([]byte, error) {
+	p := strings.SplitN(ref, "/", 2)
+	if len(p) != 2 || p[0] == "" || p[1] == "" {
+		return nil, fmt.Errorf("cert: secret reference should be in the form <source>/<name>. Got: %s", ref)
+	}
+
+	src, err := s.source(p[0])
+	if err != nil {
+		return nil, err
+	}
+	return src.get(p[1])
+}
+
+// source returns the loaded certificate source with the given name.
+func (s *Secrets) source(name string) (*secretSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if src := s.loaded[name]; src != nil {
+		return src, nil
+	}
+
+	f := s.failed[name]
+	if f != nil && time.Now().Before(f.retry) {
+		return nil, f.err
+	}
+
+	cfg, ok := s.Sources[name]
+	if !ok {
+		return nil, fmt.Errorf("cert: unknown certificate source %q", name)
+	}
+
+	src, err := newSecretSource(cfg)
+	if err != nil {
+		wait := secretRetry
+		if f != nil {
+			wait = f.wait * 2
+			if wait > maxSecretRetry {
+				wait = maxSecretRetry
+			}
+		}
+		if s.failed == nil {
+			s.failed = map[string]*secretFailure{}
+		}
+		s.failed[name] = &secretFailure{err: err, wait: wait, retry: time.Now().Add(wait)}
+		return nil, err
+	}
+	delete(s.failed, name)
+	if s.loaded == nil {
+		s.loaded = map[string]*secretSource{}
+	}
+	s.loaded[name] = src
+	return src, nil
+}
+
+// secretSource contains the entries of a certificate source by their
+// base name.
+type secretSource struct {
+	name string
+
+	mu      sync.RWMutex
+	entries map[string][]byte
+}
+
+func newSecretSource(cfg config.CertSource) (*secretSource, error) {
+	var loadFn func(path string) (map[string][]byte, error)
+	var watchFn func(src *secretSource)
+
+	switch cfg.Type {
+	case "file":
+		loadFn = func(path string) (map[string][]byte, error) {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return map[string][]byte{path: b}, nil
+		}
+
+	case "path":
+		loadFn = loadPath
+
+	case "http":
+		loadFn = loadURL
+
+	case "consul":
+		apicfg, key, err := parseConsulURL(cfg.CertPath)
+		if err != nil {
+			return nil, err
+		}
+		client, err := api.NewClient(apicfg)
+		if err != nil {
+			return nil, err
+		}
+		loadFn = func(key string) (map[string][]byte, error) {
+			entries, _, err := getCerts(client, key, 0)
+			return entries, err
+		}
+		watchFn = func(src *secretSource) {
+			ch := make(chan map[string][]byte, 1)
+			go watchKV(client, key, ch)
+			for entries := range ch {
+				src.set(entries)
+			}
+		}
+
+	case "vault":
+		vs := &VaultSource{Client: NewVaultClient(cfg.VaultFetchToken)}
+		loadFn = vs.load
+
+	default:
+		return nil, fmt.Errorf("cert: certificate source %q of type %q cannot provide secrets", cfg.Name, cfg.Type)
+	}
+
+	entries, err := loadFn(cfg.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("cert: cannot load secrets from %s. %s", cfg.Name, err)
+	}
+
+	src := &secretSource{name: cfg.Name}
+	src.set(entries)
+
+	switch {
+	case watchFn != nil:
+		go watchFn(src)
+
+	case cfg.Refresh > 0:
+		refresh := cfg.Refresh
+		// do not refresh more often than once a second to prevent busy loops
+		if refresh < time.Second {
+			refresh = time.Second
+		}
+		go func() {
+			for range time.Tick(refresh) {
+				entries, err := loadFn(cfg.CertPath)
+				if err != nil {
+					log.Printf("[ERROR] cert: Cannot reload secrets from %s. %s", cfg.Name, err)
+					continue
+				}
+				src.set(entries)
+			}
+		}()
+	}
+	return src, nil
+}
+
+// set replaces the entries of the source. The entries are stored by
+// the base name of their path or URL.
+func (s *secretSource) set(entries map[string][]byte) {
+	m := map[string][]byte{}
+	for k, v := range entries {
+		m[path.Base(k)] = bytes.TrimSpace(v)
+	}
+	s.mu.Lock()
+	s.entries = m
+	s.mu.Unlock()
+}
+
+func (s *secretSource) get(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := s.entries[name]
+	if len(b) == 0 {
+		return nil, errors.New("cert: secret " + name + " not found in " + s.name)
+	}
+	return b, nil
+}
@@ -0,0 +1,103 @@
+package cert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-secrets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("origin.pem", "secret1\n")
+
+	s := &Secrets{Sources: map[string]config.CertSource{
+		"cs":   {Name: "cs", Type: "path", CertPath: dir, Refresh: time.Second},
+		"file": {Name: "file", Type: "file", CertPath: filepath.Join(dir, "origin.pem")},
+		"pki":  {Name: "pki", Type: "vault-pki"},
+	}}
+
+	get := func(ref string) string {
+		b, err := s.Get(ref)
+		if err != nil {
+			return "error"
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		ref, want string
+	}{
+		{"cs/origin.pem", "secret1"},
+		{"file/origin.pem", "secret1"},
+		{"cs/missing.pem", "error"},
+		{"unknown/origin.pem", "error"},
+		{"pki/origin.pem", "error"},
+		{"cs", "error"},
+	}
+	for _, tt := range tests {
+		if got, want := get(tt.ref), tt.want; got != want {
+			t.Fatalf("%s: got %q want %q", tt.ref, got, want)
+		}
+	}
+
+	// the secret is rotated with the refresh interval of the source
+	write("origin.pem", "secret2\n")
+	for i := 0; i < 30; i++ {
+		if get("cs/origin.pem") == "secret2" {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("got %q want %q", get("cs/origin.pem"), "secret2")
+}
+
+func TestSecretsRetry(t *testing.T) {
+	defer func(d time.Duration) { secretRetry = d }(secretRetry)
+	secretRetry = 200 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "fabio-secrets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "origin.pem")
+	s := &Secrets{Sources: map[string]config.CertSource{
+		"file": {Name: "file", Type: "file", CertPath: file},
+	}}
+
+	if _, err := s.Get("file/origin.pem"); err == nil {
+		t.Fatal("got secret from missing file")
+	}
+
+	// the failure is cached until the source is loaded again
+	if err := ioutil.WriteFile(file, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("file/origin.pem"); err == nil {
+		t.Fatal("source was loaded again before the backoff")
+	}
+
+	time.Sleep(secretRetry)
+	b, err := s.Get("file/origin.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "secret"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"log"
 
 	"github.com/fabiolb/fabio/config"
 	"golang.org/x/sync/singleflight"
@@ -108,6 +109,7 @@ func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, ciph
 		GetCertificate: func(clientHello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
 			cert, err = getCertificate(store.certstore(), clientHello, strictMatch)
 			if cert != nil {
+				log.Printf("[DEBUG] cert: Selected certificate %q for server name %q", certName(cert), clientHello.ServerName)
 				return
 			}
 
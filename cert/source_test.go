@@ -19,6 +19,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -208,6 +209,60 @@ func TestPathSource(t *testing.T) {
 	testSource(t, PathSource{CertPath: dir}, makeCertPool(certPEM), 10*time.Millisecond)
 }
 
+func TestPathSourceNotify(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("change notifications are only supported on Linux")
+	}
+
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	certPEM, keyPEM := makePEM("localhost", time.Minute)
+	saveCert(dir, "localhost", certPEM, keyPEM)
+
+	// the refresh interval is too long for the test
+	// so that only change notifications trigger a reload
+	ch := PathSource{CertPath: dir, Refresh: time.Hour}.Certificates()
+	names := func() []string {
+		select {
+		case certs := <-ch:
+			var names []string
+			for _, c := range certs {
+				x, err := x509.ParseCertificate(c.Certificate[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, x.DNSNames...)
+			}
+			return names
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for certificates")
+			return nil
+		}
+	}
+	if got, want := names(), []string{"localhost"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// add a certificate in a new sub directory
+	sub := filepath.Join(dir, "example.com")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM = makePEM("example.com", time.Minute)
+	writeFile(filepath.Join(sub, "fullchain.pem"), certPEM)
+	writeFile(filepath.Join(sub, "privkey.pem"), keyPEM)
+	if got, want := names(), []string{"example.com", "localhost"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// remove the first certificate
+	os.Remove(filepath.Join(dir, "localhost-cert.pem"))
+	os.Remove(filepath.Join(dir, "localhost-key.pem"))
+	if got, want := names(), []string{"example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
 func TestHTTPSource(t *testing.T) {
 	dir := tempDir()
 	defer os.RemoveAll(dir)
@@ -85,7 +85,8 @@ type certstore struct {
 
 // BuildNameToCertificate parses Certificates and builds NameToCertificate
 // from the CommonName and SubjectAlternateName fields of each of the leaf
-// certificates.
+// certificates. The names are stored in lower case since server names are
+// matched case-insensitively.
 func (c *certstore) BuildNameToCertificate() {
 	c.NameToCertificate = make(map[string]*tls.Certificate)
 	for i := range c.Certificates {
@@ -94,11 +95,27 @@ func (c *certstore) BuildNameToCertificate() {
 		if err != nil {
 			continue
 		}
+		cert.Leaf = x509Cert
 		if len(x509Cert.Subject.CommonName) > 0 {
-			c.NameToCertificate[x509Cert.Subject.CommonName] = cert
+			c.NameToCertificate[strings.ToLower(x509Cert.Subject.CommonName)] = cert
 		}
 		for _, san := range x509Cert.DNSNames {
-			c.NameToCertificate[san] = cert
+			c.NameToCertificate[strings.ToLower(san)] = cert
 		}
 	}
 }
+
+// certName returns the common name or the first subject alternative
+// name of the leaf certificate for logging.
+func certName(cert *tls.Certificate) string {
+	switch {
+	case cert.Leaf == nil:
+		return "<unknown>"
+	case cert.Leaf.Subject.CommonName != "":
+		return cert.Leaf.Subject.CommonName
+	case len(cert.Leaf.DNSNames) > 0:
+		return cert.Leaf.DNSNames[0]
+	default:
+		return "<unknown>"
+	}
+}
@@ -12,6 +12,7 @@ func TestGetCertificate(t *testing.T) {
 	fooCert := makeCert("foo.com", time.Minute)
 	barCert := makeCert("bar.com", time.Minute)
 	wildBarCert := makeCert("*.bar.com", time.Minute)
+	upperCert := makeCert("Upper.Com", time.Minute)
 
 	tests := []struct {
 		desc   string
@@ -82,6 +83,13 @@ func TestGetCertificate(t *testing.T) {
 			strict: true,
 			err:    nil,
 		},
+		{
+			desc:  "upper case cert name",
+			certs: []tls.Certificate{fooCert, upperCert},
+			hello: &tls.ClientHelloInfo{ServerName: "upper.com"},
+			cert:  &upperCert,
+			err:   nil,
+		},
 		{
 			desc:  "wildcard cert",
 			certs: []tls.Certificate{fooCert, wildBarCert},
@@ -0,0 +1,132 @@
+package cert
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TicketKeys manages the session ticket keys of TLS server configs.
+//
+// Without a key file a new random key is generated on every rotation
+// and the previous key is kept for the resumption of existing sessions.
+// With a key file the keys are loaded from the file on every rotation.
+// This allows multiple instances to resume each other's sessions when
+// the file is distributed to all of them.
+type TicketKeys struct {
+	// Rotation is the interval in which the keys are rotated.
+	Rotation time.Duration
+
+	// KeyFile is the path to a file with one key per line. A key is
+	// either 32 bytes in hex or base64 encoding. The first key is used
+	// to create new tickets and all keys are used to resume sessions.
+	KeyFile string
+
+	mu   sync.Mutex
+	keys [][32]byte
+	cfgs []*tls.Config
+}
+
+// Add sets the current session ticket keys on the config and updates
+// them on every rotation.
+func (k *TicketKeys) Add(cfg *tls.Config) {
+	if cfg == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.cfgs = append(k.cfgs, cfg)
+	if len(k.keys) > 0 {
+		cfg.SetSessionTicketKeys(k.keys)
+	}
+}
+
+// Rotate replaces the session ticket keys of all configs either with
+// the keys from the key file or with a new random key and the previous
+// key.
+func (k *TicketKeys) Rotate() error {
+	var keys [][32]byte
+	if k.KeyFile != "" {
+		b, err := ioutil.ReadFile(k.KeyFile)
+		if err != nil {
+			return err
+		}
+		if keys, err = parseTicketKeys(b); err != nil {
+			return fmt.Errorf("cert: invalid ticket key file %s. %s", k.KeyFile, err)
+		}
+	} else {
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		keys = [][32]byte{key}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.KeyFile == "" && len(k.keys) > 0 {
+		keys = append(keys, k.keys[0])
+	}
+	k.keys = keys
+	for _, cfg := range k.cfgs {
+		cfg.SetSessionTicketKeys(keys)
+	}
+	return nil
+}
+
+// Run rotates the keys in the configured interval until done is closed.
+func (k *TicketKeys) Run(done <-chan struct{}) {
+	t := time.NewTicker(k.Rotation)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if err := k.Rotate(); err != nil {
+				log.Printf("[ERROR] cert: Cannot rotate session ticket keys. %s", err)
+			}
+		}
+	}
+}
+
+// parseTicketKeys parses a list of hex or base64 encoded 32 byte keys
+// with one key per line. Empty lines and lines starting with '#' are
+// ignored.
+func parseTicketKeys(b []byte) ([][32]byte, error) {
+	var keys [][32]byte
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, err := hex.DecodeString(line)
+		if err != nil {
+			v, err = base64.StdEncoding.DecodeString(line)
+		}
+		if err != nil || len(v) != 32 {
+			return nil, errors.New("keys must be 32 bytes in hex or base64 encoding")
+		}
+		var key [32]byte
+		copy(key[:], v)
+		keys = append(keys, key)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTicketKeys(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+
+	keys, err := parseTicketKeys([]byte("# comment\n" + hex.EncodeToString(key) + "\n\n" + base64.StdEncoding.EncodeToString(key) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(keys), 2; got != want {
+		t.Fatalf("got %d keys want %d", got, want)
+	}
+	if string(keys[0][:]) != string(key) || string(keys[1][:]) != string(key) {
+		t.Fatalf("got keys %x want %x", keys, key)
+	}
+
+	for _, s := range []string{"", "# comment", "abcd", hex.EncodeToString(key[:16])} {
+		if _, err := parseTicketKeys([]byte(s)); err == nil {
+			t.Fatalf("%q: got nil want error", s)
+		}
+	}
+}
+
+func TestTicketKeysRotate(t *testing.T) {
+	k := &TicketKeys{}
+	for i := 0; i < 3; i++ {
+		prev := k.keys
+		if err := k.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && len(k.keys) != 1 {
+			t.Fatalf("got %d keys want 1", len(k.keys))
+		}
+		if i > 0 && (len(k.keys) != 2 || k.keys[1] != prev[0] || k.keys[0] == prev[0]) {
+			t.Fatalf("new key not prepended to the previous key: %x -> %x", prev, k.keys)
+		}
+	}
+}
+
+// TestTicketKeysResumption verifies that a session of one server
+// can be resumed by another server with the same key file.
+func TestTicketKeysResumption(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "keys")
+	writeFile(keyFile, []byte(hex.EncodeToString([]byte(strings.Repeat("k", 32)))+"\n"))
+
+	cert := makeCert("localhost", time.Minute)
+	listen := func() string {
+		cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+		k := &TicketKeys{KeyFile: keyFile}
+		k.Add(cfg)
+		if err := k.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			defer l.Close()
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("x"))
+			c.Close()
+		}()
+		return l.Addr().String()
+	}
+
+	clientCfg := &tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		ServerName:         "localhost",
+	}
+	dial := func(addr string) bool {
+		c, err := tls.Dial("tcp", addr, clientCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		// read the response to receive the session ticket
+		if _, err := ioutil.ReadAll(c); err != nil {
+			if _, ok := err.(net.Error); !ok {
+				t.Fatal(err)
+			}
+		}
+		return c.ConnectionState().DidResume
+	}
+
+	if dial(listen()) {
+		t.Fatal("first connection resumed a session")
+	}
+	if !dial(listen()) {
+		t.Fatal("session was not resumed by the second server")
+	}
+}
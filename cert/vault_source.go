@@ -37,7 +37,7 @@ func (s *VaultSource) LoadClientCAs() (*x509.CertPool, error) {
 
 func (s *VaultSource) Certificates() chan []tls.Certificate {
 	ch := make(chan []tls.Certificate, 1)
-	go watch(ch, s.Refresh, s.CertPath, s.load)
+	go watch(ch, s.Refresh, s.CertPath, s.load, nil)
 	return ch
 }
 
@@ -7,8 +7,15 @@ import (
 	"time"
 )
 
+// settleTime is the time to wait after a change notification before
+// the certificates are loaded so that related changes, e.g. of the
+// certificate and the key file, are picked up together.
+var settleTime = 100 * time.Millisecond
+
 // watch monitors the result of the loadFn function for changes.
-func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn func(path string) (map[string][]byte, error)) {
+// The result is checked every refresh interval and whenever the
+// changed channel receives a value. changed can be nil.
+func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn func(path string) (map[string][]byte, error), changed <-chan struct{}) {
 	once := refresh <= 0
 
 	// do not refresh more often than once a second to prevent busy loops
@@ -21,18 +28,19 @@ func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn
 		next, err := loadFn(path)
 		if err != nil {
 			log.Printf("[ERROR] cert: Cannot load certificates from %s. %s", path, err)
-			time.Sleep(refresh)
+			wait(refresh, changed)
 			continue
 		}
 
 		if reflect.DeepEqual(next, last) {
-			time.Sleep(refresh)
+			wait(refresh, changed)
 			continue
 		}
 
 		certs, err := loadCertificates(next)
 		if err != nil {
 			log.Printf("[ERROR] cert: Cannot make certificates: %s", err)
+			wait(refresh, changed)
 			continue
 		}
 
@@ -44,3 +52,15 @@ func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn
 		}
 	}
 }
+
+// wait blocks until the refresh interval has passed or
+// the changed channel has received a value.
+func wait(refresh time.Duration, changed <-chan struct{}) {
+	t := time.NewTimer(refresh)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-changed:
+		time.Sleep(settleTime)
+	}
+}
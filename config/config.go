@@ -20,6 +20,27 @@ type Config struct {
 	Insecure             bool
 	GlobMatchingDisabled bool
 	GlobCacheSize        int
+	Routing              Routing
+}
+
+type Routing struct {
+	MaxRoutes     int
+	ConflictMode  string
+	TrailingSlash string
+	GeoIP         GeoIP
+	Snapshot      Snapshot
+}
+
+type GeoIP struct {
+	Database string
+	Header   string
+}
+
+// Snapshot configures the file to which the routing table is written
+// periodically and from which it is loaded on startup.
+type Snapshot struct {
+	File     string
+	Interval time.Duration
 }
 
 type CertSource struct {
@@ -35,19 +56,34 @@ type CertSource struct {
 }
 
 type Listen struct {
-	Addr               string
-	Proto              string
-	ReadTimeout        time.Duration
-	WriteTimeout       time.Duration
-	IdleTimeout        time.Duration
-	CertSource         CertSource
-	StrictMatch        bool
-	TLSMinVersion      uint16
-	TLSMaxVersion      uint16
-	TLSCiphers         []uint16
-	ProxyProto         bool
-	ProxyHeaderTimeout time.Duration
-	Refresh            time.Duration
+	Addr                string
+	Proto               string
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	IdleTimeout         time.Duration
+	ReadHeaderTimeout   time.Duration
+	ReadBodyTimeout     time.Duration
+	ReadBodyMinRate     int64
+	TLSHandshakeTimeout time.Duration
+	CertSource          CertSource
+	StrictMatch         bool
+	TLSMinVersion       uint16
+	TLSMaxVersion       uint16
+	TLSCiphers          []uint16
+	ProxyProto          bool
+	ProxyHeaderTimeout  time.Duration
+	ProxyProtoTrusted   []string
+	ProxyProtoXFF       string
+	Refresh             time.Duration
+	ALPN                []ALPN
+	NoRouteRateLimit    float64
+}
+
+// ALPN maps a protocol which was negotiated with ALPN during the TLS
+// handshake to the handler of the connection, i.e. "http" or "grpc".
+type ALPN struct {
+	Proto   string
+	Handler string
 }
 
 type UI struct {
@@ -55,27 +91,95 @@ type UI struct {
 	Color  string
 	Title  string
 	Access string
+
+	// OverridesFile is the file in which the manual overrides of the
+	// admin API are stored instead of the registry backend.
+	OverridesFile string
 }
 
 type Proxy struct {
 	Strategy              string
+	LoadHeader            string
 	Matcher               string
 	NoRouteStatus         int
+	NoRouteLog            bool
 	MaxConn               int
 	ShutdownWait          time.Duration
 	DialTimeout           time.Duration
 	ResponseHeaderTimeout time.Duration
 	KeepAliveTimeout      time.Duration
+	Expect100             bool
+	Expect100Timeout      time.Duration
 	FlushInterval         time.Duration
 	GlobalFlushInterval   time.Duration
 	LocalIP               string
+	LocalAddr             []string
+	DrainDecay            time.Duration
 	ClientIPHeader        string
+	HeaderSanitize        []string
+	HeaderSanitizeTrusted []string
 	TLSHeader             string
 	TLSHeaderValue        string
 	GZIPContentTypes      *regexp.Regexp
 	RequestID             string
+	RequestIDFormat       string
+	TraceIDHeader         string
 	STSHeader             STSHeader
+	ResponseHeaders       []ResponseHeader
 	AuthSchemes           map[string]AuthScheme
+	GRPCMaxConn           int
+	GRPCMaxStreams        int
+	GRPCReflection        string
+	Outlier               Outlier
+	Latency               Latency
+	Capture               Capture
+	Tap                   Tap
+	TLSTicketRotation     time.Duration
+	TLSTicketKeyFile      string
+	TLSClientCRL          string
+	TLSSessionCacheSize   int
+	TLSMaxHandshakes      int
+	TLSHandshakeQueue     int
+	TLSHandshakeMetrics   bool
+	JA3                   bool
+	JA3Header             string
+	CertSources           map[string]CertSource
+	SignHeader            string
+	SignDateHeader        string
+	SignFields            []string
+	RetryOn               []string
+	RetryAttempts         int
+	RetryMaxBody          int
+	AggregateMaxBody      int
+	FallbackMaxBody       int
+	TransformMaxBody      int
+	BufferMaxBody         int
+	FallbackDir           string
+	TenantHeader          string
+	TenantMaxPools        int
+	TenantAllow           []string
+	MinConnsMax           int
+	MethodOverrideHeader  string
+	Connect               bool
+	TCPDialAttempts       int
+	TCPMaxConnPerIP       int
+	ServerTiming          bool
+	PreserveKeepAlive     bool
+	DeadlinePropagate     bool
+	HeaderSizeWarn        int
+	AutoOptions           bool
+	QuotaFile             string
+	QuotaSyncInterval     time.Duration
+	MaxConcurrent         int
+	MaxConcurrentQueue    int
+	MaxConcurrentTimeout  time.Duration
+	SNIClientHelloTimeout time.Duration
+	SNIMaxClientHelloSize int
+	BreakerCacheSize      int
+	BreakerCacheMaxBody   int
+	StrictFraming         bool
+	PickerSeed            int64
+	RequestFilterEnabled  bool
 }
 
 type STSHeader struct {
@@ -84,6 +188,51 @@ type STSHeader struct {
 	Preload    bool
 }
 
+// Outlier configures the ejection of targets which return
+// consecutive 5xx responses.
+type Outlier struct {
+	Consecutive5xx     int
+	BaseEjectionTime   time.Duration
+	MaxEjectionTime    time.Duration
+	MaxEjectionPercent int
+}
+
+// Latency configures the weighting of the targets by their response
+// latency for the adaptive-latency strategy.
+type Latency struct {
+	Smoothing float64
+	Interval  time.Duration
+	MinFactor float64
+	MaxFactor float64
+}
+
+// Capture configures the logging of the request and response bodies
+// of the routes with the 'capturebody' option. The capture is enabled
+// through the admin API.
+type Capture struct {
+	MaxBody    int
+	SampleRate float64
+	Target     string
+}
+
+// Tap configures the sink for the request metadata of the routes
+// with the 'tap' option.
+type Tap struct {
+	Sink       string
+	BufferSize int
+	MaxBody    int
+	Timeout    time.Duration
+}
+
+// ResponseHeader is a header which is added to all responses.
+// Headers which are set by the upstream server are only replaced
+// if Force is true.
+type ResponseHeader struct {
+	Name  string
+	Value string
+	Force bool
+}
+
 type Runtime struct {
 	GOGC       int
 	GOMAXPROCS int
@@ -99,32 +248,76 @@ type Circonus struct {
 }
 
 type Log struct {
-	AccessFormat string
-	AccessTarget string
-	RoutesFormat string
-	Level        string
+	AccessFormat     string
+	AccessTarget     string
+	AccessBufferSize int
+	AccessOverflow   string
+	AccessTargets    map[string]AccessLogTarget
+	AccessOTLP       AccessOTLP
+	TCPFormat        string
+	TCPTarget        string
+	RoutesFormat     string
+	Level            string
+}
+
+// AccessOTLP configures the export of the access log
+// with log.access.target=otlp.
+type AccessOTLP struct {
+	Endpoint      string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// AccessLogTarget is a named destination for the access log entries
+// of the routes with the 'logtarget' option.
+type AccessLogTarget struct {
+	Name string
+	Type string
+
+	// Path is the name of the log file for the 'file' type.
+	Path string
+
+	// Network and Addr are the address of the syslog server for the
+	// 'syslog' type. The local syslog server is used if Addr is empty.
+	Network string
+	Addr    string
+
+	// Tag is the syslog tag.
+	Tag string
 }
 
 type Metrics struct {
-	Target       string
-	Prefix       string
-	Names        string
-	Interval     time.Duration
-	Timeout      time.Duration
-	Retry        time.Duration
-	GraphiteAddr string
-	StatsDAddr   string
-	Circonus     Circonus
+	Target        string
+	Prefix        string
+	Names         string
+	TagNames      string
+	StatusCodes   string
+	Exclude       string
+	ExcludeTotals bool
+	Interval      time.Duration
+	Timeout       time.Duration
+	Retry         time.Duration
+	GraphiteAddr  string
+	StatsDAddr    string
+	Circonus      Circonus
 }
 
 type Registry struct {
-	Backend string
-	Static  Static
-	File    File
-	Consul  Consul
-	Custom  Custom
-	Timeout time.Duration
-	Retry   time.Duration
+	Backend    string
+	Static     Static
+	File       File
+	Consul     Consul
+	Custom     Custom
+	Kubernetes Kubernetes
+	Timeout    time.Duration
+	Retry      time.Duration
+
+	// StartupFailMode is either 'exit' or 'serve-degraded' and
+	// defines what happens when the registry is not available
+	// within StartupTimeout on startup.
+	StartupFailMode string
+	StartupTimeout  time.Duration
+	StartupRoutes   string
 }
 
 type Static struct {
@@ -144,6 +337,7 @@ type Consul struct {
 	KVPath             string
 	NoRouteHTMLPath    string
 	TagPrefix          string
+	Datacenters        []string
 	Register           bool
 	ServiceAddr        string
 	ServiceName        string
@@ -157,6 +351,9 @@ type Consul struct {
 	ServiceMonitors    int
 	TLS                ConsulTlS
 	PollInterval       time.Duration
+	WatchWaitTime      time.Duration
+	WatchMaxBackoff    time.Duration
+	WatchResyncAfter   time.Duration
 }
 
 type Custom struct {
@@ -170,6 +367,17 @@ type Custom struct {
 	Timeout            time.Duration
 }
 
+type Kubernetes struct {
+	Addr             string
+	Namespace        string
+	TokenPath        string
+	CAPath           string
+	TLSSkipVerify    bool
+	AnnotationPrefix string
+	Resync           time.Duration
+	NoRouteHTML      string
+}
+
 type Tracing struct {
 	TracingEnabled bool
 	CollectorType  string
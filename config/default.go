@@ -13,6 +13,11 @@ var defaultValues = struct {
 	ReadTimeout           time.Duration
 	WriteTimeout          time.Duration
 	IdleTimeout           time.Duration
+	ReadHeaderTimeout     time.Duration
+	ReadBodyTimeout       time.Duration
+	ReadBodyMinRate       int64
+	TLSHandshakeTimeout   time.Duration
+	NoRouteRateLimit      float64
 	UIListenerValue       string
 	GZIPContentTypesValue string
 }{
@@ -23,49 +28,110 @@ var defaultValues = struct {
 var defaultConfig = &Config{
 	ProfilePath: os.TempDir(),
 	Log: Log{
-		AccessFormat: "common",
-		RoutesFormat: "delta",
-		Level:        "INFO",
+		AccessFormat:   "common",
+		AccessOverflow: "block",
+		TCPFormat:      "$time_rfc3339 client=$remote_addr sni=$server_name upstream=$upstream_addr in=$bytes_received out=$bytes_sent duration=$duration_ms",
+		RoutesFormat:   "delta",
+		Level:          "INFO",
+		AccessOTLP: AccessOTLP{
+			BatchSize:     100,
+			FlushInterval: time.Second,
+		},
 	},
 	Metrics: Metrics{
-		Prefix:   "{{clean .Hostname}}.{{clean .Exec}}",
-		Names:    "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}",
-		Interval: 30 * time.Second,
-		Timeout:  10 * time.Second,
-		Retry:    500 * time.Millisecond,
+		Prefix:      "{{clean .Hostname}}.{{clean .Exec}}",
+		Names:       "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}",
+		TagNames:    "{{clean .Name}}_{{clean .Value}}",
+		StatusCodes: "class",
+		Interval:    30 * time.Second,
+		Timeout:     10 * time.Second,
+		Retry:       500 * time.Millisecond,
 		Circonus: Circonus{
 			APIApp: "fabio",
 		},
 	},
 	Proxy: Proxy{
-		MaxConn:             10000,
-		Strategy:            "rnd",
-		Matcher:             "prefix",
-		NoRouteStatus:       404,
-		DialTimeout:         30 * time.Second,
-		FlushInterval:       time.Second,
-		GlobalFlushInterval: 0,
-		LocalIP:             LocalIPString(),
-		AuthSchemes:         map[string]AuthScheme{},
+		MaxConn:               10000,
+		Strategy:              "rnd",
+		LoadHeader:            "X-Backend-Load",
+		Matcher:               "prefix",
+		NoRouteStatus:         404,
+		DialTimeout:           30 * time.Second,
+		Expect100:             false,
+		Expect100Timeout:      time.Second,
+		FlushInterval:         time.Second,
+		GlobalFlushInterval:   0,
+		LocalIP:               LocalIPString(),
+		DrainDecay:            time.Minute,
+		JA3Header:             "X-TLS-JA3",
+		RequestIDFormat:       "uuid",
+		TraceIDHeader:         "X-Trace-Id",
+		AuthSchemes:           map[string]AuthScheme{},
+		CertSources:           map[string]CertSource{},
+		SignHeader:            "X-Fabio-Signature",
+		SignDateHeader:        "X-Fabio-Date",
+		SignFields:            []string{"method", "path", "date"},
+		RetryAttempts:         1,
+		RetryMaxBody:          1 << 20,
+		AggregateMaxBody:      1 << 20,
+		FallbackMaxBody:       1 << 20,
+		TransformMaxBody:      1 << 20,
+		BufferMaxBody:         1 << 20,
+		TenantMaxPools:        100,
+		MinConnsMax:           100,
+		TCPDialAttempts:       1,
+		SNIClientHelloTimeout: 10 * time.Second,
+		SNIMaxClientHelloSize: 16384,
+		TLSHandshakeQueue:     1000,
+		MaxConcurrentQueue:    1000,
+		BreakerCacheSize:      1000,
+		StrictFraming:         true,
+		PreserveKeepAlive:     true,
+		BreakerCacheMaxBody:   1 << 20,
+		MaxConcurrentTimeout:  time.Second,
+		QuotaSyncInterval:     10 * time.Second,
+		Outlier: Outlier{
+			BaseEjectionTime:   30 * time.Second,
+			MaxEjectionTime:    300 * time.Second,
+			MaxEjectionPercent: 10,
+		},
+		Latency: Latency{
+			Smoothing: 0.3,
+			Interval:  10 * time.Second,
+			MinFactor: 0.2,
+			MaxFactor: 5,
+		},
+		Capture: Capture{
+			MaxBody:    4096,
+			SampleRate: 1,
+			Target:     "stderr",
+		},
+		Tap: Tap{
+			BufferSize: 10000,
+			Timeout:    5 * time.Second,
+		},
 	},
 	Registry: Registry{
 		Backend: "consul",
 		Consul: Consul{
-			Addr:            "localhost:8500",
-			Scheme:          "http",
-			KVPath:          "/fabio/config",
-			NoRouteHTMLPath: "/fabio/noroute.html",
-			TagPrefix:       "urlprefix-",
-			Register:        true,
-			ServiceAddr:     ":9998",
-			ServiceName:     "fabio",
-			ServiceStatus:   []string{"passing"},
-			ServiceMonitors: 1,
-			CheckInterval:   time.Second,
-			CheckTimeout:    3 * time.Second,
-			CheckScheme:     "http",
-			ChecksRequired:  "one",
-			PollInterval:    0,
+			Addr:             "localhost:8500",
+			Scheme:           "http",
+			KVPath:           "/fabio/config",
+			NoRouteHTMLPath:  "/fabio/noroute.html",
+			TagPrefix:        "urlprefix-",
+			Register:         true,
+			ServiceAddr:      ":9998",
+			ServiceName:      "fabio",
+			ServiceStatus:    []string{"passing"},
+			ServiceMonitors:  1,
+			CheckInterval:    time.Second,
+			CheckTimeout:     3 * time.Second,
+			CheckScheme:      "http",
+			ChecksRequired:   "one",
+			PollInterval:     0,
+			WatchWaitTime:    5 * time.Minute,
+			WatchMaxBackoff:  30 * time.Second,
+			WatchResyncAfter: time.Minute,
 		},
 		Custom: Custom{
 			Host:               "",
@@ -77,8 +143,15 @@ var defaultConfig = &Config{
 			Path:               "",
 			QueryParams:        "",
 		},
-		Timeout: 10 * time.Second,
-		Retry:   500 * time.Millisecond,
+		Kubernetes: Kubernetes{
+			TokenPath:        "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			CAPath:           "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+			AnnotationPrefix: "fabio.io/",
+			Resync:           5 * time.Minute,
+		},
+		Timeout:         10 * time.Second,
+		Retry:           500 * time.Millisecond,
+		StartupFailMode: "exit",
 	},
 	Runtime: Runtime{
 		GOGC:       100,
@@ -106,4 +179,11 @@ var defaultConfig = &Config{
 	},
 
 	GlobCacheSize: 1000,
+	Routing: Routing{
+		ConflictMode:  "merge",
+		TrailingSlash: "strict",
+		Snapshot: Snapshot{
+			Interval: 30 * time.Second,
+		},
+	},
 }
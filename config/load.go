@@ -6,9 +6,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -116,42 +119,139 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	var certSourcesValue string
 	var authSchemesValue string
 	var readTimeout, writeTimeout time.Duration
+	var readHeaderTimeout, readBodyTimeout time.Duration
+	var readBodyMinRate int64
+	var tlsHandshakeTimeout time.Duration
+	var noRouteRateLimit float64
 	var gzipContentTypesValue string
+	var responseHeadersValue string
+	var accessTargetsValue string
 
 	var obsoleteStr string
 
 	f.BoolVar(&cfg.Insecure, "insecure", defaultConfig.Insecure, "allow fabio to run as root when set to true")
 	f.IntVar(&cfg.Proxy.MaxConn, "proxy.maxconn", defaultConfig.Proxy.MaxConn, "maximum number of cached connections")
 	f.StringVar(&cfg.Proxy.Strategy, "proxy.strategy", defaultConfig.Proxy.Strategy, "load balancing strategy")
+	f.Int64Var(&cfg.Proxy.PickerSeed, "proxy.picker.seed", defaultConfig.Proxy.PickerSeed, "seed for a reproducible random target selection. 0 uses a non-deterministic source")
+	f.StringVar(&cfg.Proxy.LoadHeader, "proxy.loadheader", defaultConfig.Proxy.LoadHeader, "response header with the backend load for the adaptiveload strategy")
 	f.StringVar(&cfg.Proxy.Matcher, "proxy.matcher", defaultConfig.Proxy.Matcher, "path matching algorithm")
 	f.IntVar(&cfg.Proxy.NoRouteStatus, "proxy.noroutestatus", defaultConfig.Proxy.NoRouteStatus, "status code for invalid route. Must be three digits")
+	f.BoolVar(&cfg.Proxy.NoRouteLog, "proxy.noroute.log", defaultConfig.Proxy.NoRouteLog, "log the requests without a route in the access log")
 	f.DurationVar(&cfg.Proxy.ShutdownWait, "proxy.shutdownwait", defaultConfig.Proxy.ShutdownWait, "time for graceful shutdown")
 	f.DurationVar(&cfg.Proxy.DialTimeout, "proxy.dialtimeout", defaultConfig.Proxy.DialTimeout, "connection timeout for backend connections")
 	f.DurationVar(&cfg.Proxy.ResponseHeaderTimeout, "proxy.responseheadertimeout", defaultConfig.Proxy.ResponseHeaderTimeout, "response header timeout")
 	f.DurationVar(&cfg.Proxy.KeepAliveTimeout, "proxy.keepalivetimeout", defaultConfig.Proxy.KeepAliveTimeout, "keep-alive timeout")
+	f.BoolVar(&cfg.Proxy.Expect100, "proxy.expect100.enabled", defaultConfig.Proxy.Expect100, "wait for 100 Continue from the upstream server before sending the request body")
+	f.DurationVar(&cfg.Proxy.Expect100Timeout, "proxy.expect100.timeout", defaultConfig.Proxy.Expect100Timeout, "time to wait for 100 Continue from the upstream server")
 	f.StringVar(&cfg.Proxy.LocalIP, "proxy.localip", defaultConfig.Proxy.LocalIP, "fabio address in Forward headers")
+	f.StringSliceVar(&cfg.Proxy.LocalAddr, "proxy.localaddr", defaultConfig.Proxy.LocalAddr, "host names and IP addresses of the fabio node which are avoided by routes with 'antiaffinity=node'. Defaults to proxy.localip")
 	f.StringVar(&cfg.Proxy.ClientIPHeader, "proxy.header.clientip", defaultConfig.Proxy.ClientIPHeader, "header for the request ip")
+	f.StringSliceVar(&cfg.Proxy.HeaderSanitize, "proxy.headersanitize", defaultConfig.Proxy.HeaderSanitize, "request headers which are removed for untrusted clients before fabio sets its own values")
+	f.StringSliceVar(&cfg.Proxy.HeaderSanitizeTrusted, "proxy.headersanitize.trusted", defaultConfig.Proxy.HeaderSanitizeTrusted, "networks in CIDR notation of the clients whose headers are kept")
 	f.StringVar(&cfg.Proxy.TLSHeader, "proxy.header.tls", defaultConfig.Proxy.TLSHeader, "header for TLS connections")
 	f.StringVar(&cfg.Proxy.TLSHeaderValue, "proxy.header.tls.value", defaultConfig.Proxy.TLSHeaderValue, "value for TLS connection header")
 	f.StringVar(&cfg.Proxy.RequestID, "proxy.header.requestid", defaultConfig.Proxy.RequestID, "header for reqest id")
+	f.StringVar(&cfg.Proxy.RequestIDFormat, "proxy.requestid.format", defaultConfig.Proxy.RequestIDFormat, "format of generated request ids, one of [uuid, hex]")
+	f.StringVar(&cfg.Proxy.TraceIDHeader, "proxy.header.traceid", defaultConfig.Proxy.TraceIDHeader, "header for the trace id of error responses")
 	f.IntVar(&cfg.Proxy.STSHeader.MaxAge, "proxy.header.sts.maxage", defaultConfig.Proxy.STSHeader.MaxAge, "enable and set the max-age value for HSTS")
 	f.BoolVar(&cfg.Proxy.STSHeader.Subdomains, "proxy.header.sts.subdomains", defaultConfig.Proxy.STSHeader.Subdomains, "direct HSTS to include subdomains")
 	f.BoolVar(&cfg.Proxy.STSHeader.Preload, "proxy.header.sts.preload", defaultConfig.Proxy.STSHeader.Preload, "direct HSTS to pass the preload directive")
+	f.StringVar(&responseHeadersValue, "proxy.responseheaders", "", "headers which are added to all responses, e.g. 'X-Content-Type-Options=nosniff,X-Frame-Options=DENY;force=true'")
 	f.StringVar(&gzipContentTypesValue, "proxy.gzip.contenttype", defaultValues.GZIPContentTypesValue, "regexp of content types to compress")
 	f.StringVar(&listenerValue, "proxy.addr", defaultValues.ListenerValue, "listener config")
 	f.StringVar(&certSourcesValue, "proxy.cs", defaultValues.CertSourcesValue, "certificate sources")
 	f.DurationVar(&readTimeout, "proxy.readtimeout", defaultValues.ReadTimeout, "read timeout for incoming requests")
 	f.DurationVar(&writeTimeout, "proxy.writetimeout", defaultValues.WriteTimeout, "write timeout for outgoing responses")
+	f.DurationVar(&readHeaderTimeout, "proxy.readheadertimeout", defaultValues.ReadHeaderTimeout, "read timeout for the headers of incoming requests. 0 uses proxy.readtimeout")
+	f.DurationVar(&readBodyTimeout, "proxy.readbodytimeout", defaultValues.ReadBodyTimeout, "interval in which the minimum body rate of incoming requests is enforced. 0 disables the check")
+	f.Int64Var(&readBodyMinRate, "proxy.readbodyminrate", defaultValues.ReadBodyMinRate, "minimum rate in bytes per second for the body of incoming requests")
+	f.Float64Var(&noRouteRateLimit, "proxy.noroute.ratelimit", defaultValues.NoRouteRateLimit, "maximum number of requests per second without a route per listener. 0 disables the limit")
 	f.DurationVar(&cfg.Proxy.FlushInterval, "proxy.flushinterval", defaultConfig.Proxy.FlushInterval, "flush interval for streaming responses")
+	f.DurationVar(&cfg.Proxy.DrainDecay, "proxy.draindecay", defaultConfig.Proxy.DrainDecay, "time in which the weight of targets with 'draining=true' decreases to zero")
 	f.DurationVar(&cfg.Proxy.GlobalFlushInterval, "proxy.globalflushinterval", defaultConfig.Proxy.GlobalFlushInterval, "flush interval for non-streaming responses")
+	f.IntVar(&cfg.Proxy.GRPCMaxConn, "proxy.grpc.maxconn", defaultConfig.Proxy.GRPCMaxConn, "maximum number of concurrent gRPC client connections")
+	f.IntVar(&cfg.Proxy.GRPCMaxStreams, "proxy.grpc.maxconcurrentstreams", defaultConfig.Proxy.GRPCMaxStreams, "maximum number of concurrent streams per gRPC client connection")
+	f.StringVar(&cfg.Proxy.GRPCReflection, "proxy.grpc.reflection", defaultConfig.Proxy.GRPCReflection, "path of the gRPC route which receives the server reflection requests without a route")
+	f.IntVar(&cfg.Proxy.Outlier.Consecutive5xx, "proxy.outlier.consecutive5xx", defaultConfig.Proxy.Outlier.Consecutive5xx, "number of consecutive 5xx responses after which a target is ejected. 0 disables outlier detection")
+	f.DurationVar(&cfg.Proxy.Outlier.BaseEjectionTime, "proxy.outlier.baseejectiontime", defaultConfig.Proxy.Outlier.BaseEjectionTime, "base ejection time which grows with repeated ejections")
+	f.DurationVar(&cfg.Proxy.Outlier.MaxEjectionTime, "proxy.outlier.maxejectiontime", defaultConfig.Proxy.Outlier.MaxEjectionTime, "maximum ejection time")
+	f.DurationVar(&tlsHandshakeTimeout, "proxy.tls.handshaketimeout", defaultValues.TLSHandshakeTimeout, "maximum time for the TLS handshake of incoming connections. 0 disables the timeout")
+	f.DurationVar(&cfg.Proxy.TLSTicketRotation, "proxy.tls.ticketrotation", defaultConfig.Proxy.TLSTicketRotation, "interval for rotating the TLS session ticket keys. 0 uses the Go defaults")
+	f.StringVar(&cfg.Proxy.TLSTicketKeyFile, "proxy.tls.ticketkeyfile", defaultConfig.Proxy.TLSTicketKeyFile, "path to a file with shared TLS session ticket keys")
+	f.StringVar(&cfg.Proxy.TLSClientCRL, "proxy.tls.clientcrl", defaultConfig.Proxy.TLSClientCRL, "path to a file with certificate revocation lists for client certificates. Reloaded on SIGHUP")
+	f.IntVar(&cfg.Proxy.TLSMaxHandshakes, "proxy.tls.maxconcurrenthandshakes", defaultConfig.Proxy.TLSMaxHandshakes, "maximum number of concurrent TLS handshakes of incoming connections. 0 disables the limit")
+	f.IntVar(&cfg.Proxy.TLSHandshakeQueue, "proxy.tls.handshakequeue", defaultConfig.Proxy.TLSHandshakeQueue, "maximum number of TLS handshakes which wait for proxy.tls.maxconcurrenthandshakes")
+	f.BoolVar(&cfg.Proxy.TLSHandshakeMetrics, "proxy.tls.handshakemetrics", defaultConfig.Proxy.TLSHandshakeMetrics, "record the duration and the failures of the TLS handshakes of incoming connections per listener")
+	f.BoolVar(&cfg.Proxy.JA3, "proxy.tls.ja3", defaultConfig.Proxy.JA3, "compute the JA3 fingerprints of the TLS clients for the proxy.header.ja3 header and 'match=ja3:...' routes")
+	f.StringVar(&cfg.Proxy.JA3Header, "proxy.header.ja3", defaultConfig.Proxy.JA3Header, "header for the JA3 fingerprint of the TLS client if proxy.tls.ja3 is enabled")
+	f.IntVar(&cfg.Proxy.TLSSessionCacheSize, "proxy.tls.sessioncachesize", defaultConfig.Proxy.TLSSessionCacheSize, "size of the TLS session cache for upstream connections. 0 disables the cache")
+	f.IntVar(&cfg.Proxy.Outlier.MaxEjectionPercent, "proxy.outlier.maxejectionpercent", defaultConfig.Proxy.Outlier.MaxEjectionPercent, "maximum percentage of the targets of a route which can be ejected")
+	f.Float64Var(&cfg.Proxy.Latency.Smoothing, "proxy.latency.smoothing", defaultConfig.Proxy.Latency.Smoothing, "smoothing factor of the moving average of the response latency for the adaptive-latency strategy")
+	f.DurationVar(&cfg.Proxy.Latency.Interval, "proxy.latency.interval", defaultConfig.Proxy.Latency.Interval, "interval for recomputing the target weights for the adaptive-latency strategy")
+	f.Float64Var(&cfg.Proxy.Latency.MinFactor, "proxy.latency.minfactor", defaultConfig.Proxy.Latency.MinFactor, "minimum factor of the target weights for the adaptive-latency strategy")
+	f.Float64Var(&cfg.Proxy.Latency.MaxFactor, "proxy.latency.maxfactor", defaultConfig.Proxy.Latency.MaxFactor, "maximum factor of the target weights for the adaptive-latency strategy")
+	f.IntVar(&cfg.Proxy.Capture.MaxBody, "proxy.capture.maxbody", defaultConfig.Proxy.Capture.MaxBody, "number of bytes of the request and response bodies which are logged for routes with 'capturebody=true'")
+	f.Float64Var(&cfg.Proxy.Capture.SampleRate, "proxy.capture.samplerate", defaultConfig.Proxy.Capture.SampleRate, "fraction of the requests of routes with 'capturebody=true' whose bodies are logged")
+	f.StringVar(&cfg.Proxy.Capture.Target, "proxy.capture.target", defaultConfig.Proxy.Capture.Target, "capture log target: stdout, stderr or a file name")
+	f.StringVar(&cfg.Proxy.Tap.Sink, "proxy.tap.sink", defaultConfig.Proxy.Tap.Sink, "http(s) or kafka://host:port/topic URL which receives the request metadata of routes with the 'tap' option")
+	f.IntVar(&cfg.Proxy.Tap.BufferSize, "proxy.tap.buffersize", defaultConfig.Proxy.Tap.BufferSize, "number of tap events which are buffered before new events are dropped")
+	f.IntVar(&cfg.Proxy.Tap.MaxBody, "proxy.tap.maxbody", defaultConfig.Proxy.Tap.MaxBody, "number of bytes of the request body which are included in the tap events")
+	f.DurationVar(&cfg.Proxy.Tap.Timeout, "proxy.tap.timeout", defaultConfig.Proxy.Tap.Timeout, "timeout for sending the tap events to the sink")
 	f.StringVar(&authSchemesValue, "proxy.auth", defaultValues.AuthSchemesValue, "auth schemes")
+	f.StringVar(&cfg.Proxy.SignHeader, "proxy.sign.header", defaultConfig.Proxy.SignHeader, "header for the signature of signed upstream requests")
+	f.StringVar(&cfg.Proxy.SignDateHeader, "proxy.sign.dateheader", defaultConfig.Proxy.SignDateHeader, "header for the date of signed upstream requests")
+	f.StringSliceVar(&cfg.Proxy.SignFields, "proxy.sign.fields", defaultConfig.Proxy.SignFields, "request fields which are signed, any of [method, host, path, query, date]")
+	f.StringSliceVar(&cfg.Proxy.RetryOn, "proxy.retry.on", defaultConfig.Proxy.RetryOn, "conditions for retrying upstream requests, any of [connect-failure, refused-stream, goaway, reset, unexpected, 5xx status code]")
+	f.IntVar(&cfg.Proxy.RetryAttempts, "proxy.retry.attempts", defaultConfig.Proxy.RetryAttempts, "maximum number of retries of an upstream request")
+	f.IntVar(&cfg.Proxy.RetryMaxBody, "proxy.retry.maxbody", defaultConfig.Proxy.RetryMaxBody, "maximum size in bytes of the request body which is buffered for retries. Requests with larger bodies are not retried")
+	f.IntVar(&cfg.Proxy.AggregateMaxBody, "proxy.aggregate.maxbody", defaultConfig.Proxy.AggregateMaxBody, "maximum size in bytes of the request body for routes with the 'aggregate' option. Larger requests are rejected")
+	f.StringVar(&cfg.Proxy.FallbackDir, "proxy.fallback.dir", defaultConfig.Proxy.FallbackDir, "directory with the files of the 'emptyfallback' and 'breakerfallback' options")
+	f.IntVar(&cfg.Proxy.BufferMaxBody, "proxy.buffer.maxbody", defaultConfig.Proxy.BufferMaxBody, "maximum size in bytes of the request bodies of routes with the 'buffer=true' option")
+	f.IntVar(&cfg.Proxy.TransformMaxBody, "proxy.transform.maxbody", defaultConfig.Proxy.TransformMaxBody, "maximum size in bytes of the request and response bodies of routes with the 'transform' option")
+	f.IntVar(&cfg.Proxy.FallbackMaxBody, "proxy.fallback.maxbody", defaultConfig.Proxy.FallbackMaxBody, "maximum size in bytes of the request body which is buffered for the 'fallback' target. Requests with larger bodies are only sent to the target")
+	f.StringVar(&cfg.Proxy.TenantHeader, "proxy.tenant.header", defaultConfig.Proxy.TenantHeader, "header with the tenant key for separate upstream connection pools per tenant")
+	f.IntVar(&cfg.Proxy.TenantMaxPools, "proxy.tenant.maxpools", defaultConfig.Proxy.TenantMaxPools, "maximum number of tenant connection pools. The least recently used pool is closed")
+	f.StringSliceVar(&cfg.Proxy.TenantAllow, "proxy.tenant.allow", defaultConfig.Proxy.TenantAllow, "tenants which get separate upstream connection pools")
+	f.IntVar(&cfg.Proxy.TCPDialAttempts, "proxy.tcp.dialattempts", defaultConfig.Proxy.TCPDialAttempts, "maximum number of upstream connection attempts of the TCP proxies")
+	f.IntVar(&cfg.Proxy.TCPMaxConnPerIP, "proxy.tcp.maxconnperip", defaultConfig.Proxy.TCPMaxConnPerIP, "maximum number of active connections per client IP of the TCP proxy. 0 disables the limit")
+	f.DurationVar(&cfg.Proxy.SNIClientHelloTimeout, "proxy.tcp.sni.clienthellotimeout", defaultConfig.Proxy.SNIClientHelloTimeout, "maximum time for reading the TLS ClientHello in the SNI proxy. 0 disables the timeout")
+	f.IntVar(&cfg.Proxy.SNIMaxClientHelloSize, "proxy.tcp.sni.maxclienthellosize", defaultConfig.Proxy.SNIMaxClientHelloSize, "maximum size of the TLS ClientHello in the SNI proxy in bytes")
+	f.IntVar(&cfg.Proxy.MaxConcurrent, "proxy.maxconcurrent", defaultConfig.Proxy.MaxConcurrent, "maximum number of concurrent upstream requests shared by the routes. 0 disables the limit")
+	f.BoolVar(&cfg.Proxy.StrictFraming, "proxy.strictframing", defaultConfig.Proxy.StrictFraming, "reject requests with ambiguous or malformed Content-Length and Transfer-Encoding")
+	f.BoolVar(&cfg.Proxy.RequestFilterEnabled, "proxy.requestfilter.enabled", defaultConfig.Proxy.RequestFilterEnabled, "reject request paths with null bytes, control characters or path traversal")
+	f.IntVar(&cfg.Proxy.BreakerCacheSize, "proxy.breakerfallback.cachesize", defaultConfig.Proxy.BreakerCacheSize, "maximum number of responses kept for routes with breakerfallback=cache")
+	f.IntVar(&cfg.Proxy.BreakerCacheMaxBody, "proxy.breakerfallback.maxbody", defaultConfig.Proxy.BreakerCacheMaxBody, "maximum body size in bytes of the responses kept for routes with breakerfallback=cache")
+	f.IntVar(&cfg.Proxy.MaxConcurrentQueue, "proxy.maxconcurrent.queue", defaultConfig.Proxy.MaxConcurrentQueue, "maximum number of requests which wait for proxy.maxconcurrent")
+	f.DurationVar(&cfg.Proxy.MaxConcurrentTimeout, "proxy.maxconcurrent.timeout", defaultConfig.Proxy.MaxConcurrentTimeout, "maximum time a request waits for proxy.maxconcurrent")
+	f.BoolVar(&cfg.Proxy.ServerTiming, "proxy.servertiming", defaultConfig.Proxy.ServerTiming, "add the Server-Timing header with the upstream and the proxy time to the responses")
+	f.StringVar(&cfg.Proxy.QuotaFile, "proxy.quota.file", defaultConfig.Proxy.QuotaFile, "file in which the counters of the routes with the 'quota' option are kept across restarts")
+	f.DurationVar(&cfg.Proxy.QuotaSyncInterval, "proxy.quota.syncinterval", defaultConfig.Proxy.QuotaSyncInterval, "interval in which the quota counters are written to proxy.quota.file")
+	f.BoolVar(&cfg.Proxy.AutoOptions, "proxy.autooptions", defaultConfig.Proxy.AutoOptions, "answer OPTIONS requests which are not CORS preflight requests with the Allow header of the route instead of forwarding them")
+	f.IntVar(&cfg.Proxy.HeaderSizeWarn, "proxy.headersize.warn", defaultConfig.Proxy.HeaderSizeWarn, "log a sampled warning for requests whose request or response header is larger than this number of bytes. 0 disables the warning")
+	f.BoolVar(&cfg.Proxy.DeadlinePropagate, "proxy.deadline.propagate", defaultConfig.Proxy.DeadlinePropagate, "limit the upstream request to the grpc-timeout or X-Request-Timeout of the client and pass the remaining time to the upstream server")
+	f.BoolVar(&cfg.Proxy.PreserveKeepAlive, "proxy.preserveclientkeepalive", defaultConfig.Proxy.PreserveKeepAlive, "keep the client connection open when the upstream server closes the connection with 'Connection: close'")
+	f.BoolVar(&cfg.Proxy.Connect, "proxy.connect", defaultConfig.Proxy.Connect, "tunnel CONNECT requests to the destinations with a 'connect:<host>:<port>' route")
+	f.StringVar(&cfg.Proxy.MethodOverrideHeader, "proxy.methodoverride.header", defaultConfig.Proxy.MethodOverrideHeader, "header with the method for POST requests, e.g. X-HTTP-Method-Override. Only PUT, PATCH and DELETE are allowed")
+	f.IntVar(&cfg.Proxy.MinConnsMax, "proxy.minconns.max", defaultConfig.Proxy.MinConnsMax, "maximum number of warm connections per upstream host for the minconns route option")
 	f.StringVar(&cfg.Log.AccessFormat, "log.access.format", defaultConfig.Log.AccessFormat, "access log format")
 	f.StringVar(&cfg.Log.AccessTarget, "log.access.target", defaultConfig.Log.AccessTarget, "access log target")
+	f.IntVar(&cfg.Log.AccessBufferSize, "log.access.buffersize", defaultConfig.Log.AccessBufferSize, "number of buffered access log entries. 0 writes synchronously")
+	f.StringVar(&cfg.Log.AccessOverflow, "log.access.overflow", defaultConfig.Log.AccessOverflow, "behavior when the access log buffer is full: drop or block")
+	f.StringVar(&cfg.Log.AccessOTLP.Endpoint, "log.access.otlp.endpoint", defaultConfig.Log.AccessOTLP.Endpoint, "URL of the OTLP/HTTP logs endpoint for log.access.target=otlp")
+	f.IntVar(&cfg.Log.AccessOTLP.BatchSize, "log.access.otlp.batchsize", defaultConfig.Log.AccessOTLP.BatchSize, "maximum number of access log records per OTLP request")
+	f.DurationVar(&cfg.Log.AccessOTLP.FlushInterval, "log.access.otlp.flushinterval", defaultConfig.Log.AccessOTLP.FlushInterval, "maximum time an access log record waits before it is sent to the OTLP endpoint")
+	f.StringVar(&accessTargetsValue, "log.access.targets", "", "named access log destinations for the routes with the 'logtarget' option, e.g. 'name=teamA;type=file;path=/var/log/fabio/teamA.log'")
+	f.StringVar(&cfg.Log.TCPFormat, "log.tcp.format", defaultConfig.Log.TCPFormat, "connection log format of the TCP and SNI proxies")
+	f.StringVar(&cfg.Log.TCPTarget, "log.tcp.target", defaultConfig.Log.TCPTarget, "connection log target of the TCP and SNI proxies")
 	f.StringVar(&cfg.Log.RoutesFormat, "log.routes.format", defaultConfig.Log.RoutesFormat, "log format of routing table updates")
 	f.StringVar(&cfg.Log.Level, "log.level", defaultConfig.Log.Level, "log level: TRACE, DEBUG, INFO, WARN, ERROR, FATAL")
 	f.StringVar(&cfg.Metrics.Target, "metrics.target", defaultConfig.Metrics.Target, "metrics backend")
 	f.StringVar(&cfg.Metrics.Prefix, "metrics.prefix", defaultConfig.Metrics.Prefix, "prefix for reported metrics")
 	f.StringVar(&cfg.Metrics.Names, "metrics.names", defaultConfig.Metrics.Names, "route metric name template")
+	f.StringVar(&cfg.Metrics.TagNames, "metrics.tagnames", defaultConfig.Metrics.TagNames, "route metric tag name template")
+	f.StringVar(&cfg.Metrics.StatusCodes, "metrics.statuscodes", defaultConfig.Metrics.StatusCodes, "per-route counters of the response status codes: class, code or none")
+	f.StringVar(&cfg.Metrics.Exclude, "metrics.exclude", defaultConfig.Metrics.Exclude, "comma separated list of tag:<tag> and name:<glob> patterns of routes without per-route metrics")
+	f.BoolVar(&cfg.Metrics.ExcludeTotals, "metrics.exclude.totals", defaultConfig.Metrics.ExcludeTotals, "exclude the requests of the routes in metrics.exclude from the global metrics")
 	f.DurationVar(&cfg.Metrics.Interval, "metrics.interval", defaultConfig.Metrics.Interval, "metrics reporting interval")
 	f.DurationVar(&cfg.Metrics.Timeout, "metrics.timeout", defaultConfig.Metrics.Timeout, "timeout for metrics to become available")
 	f.DurationVar(&cfg.Metrics.Retry, "metrics.retry", defaultConfig.Metrics.Retry, "retry interval during startup")
@@ -163,9 +263,12 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Metrics.Circonus.BrokerID, "metrics.circonus.brokerid", defaultConfig.Metrics.Circonus.BrokerID, "Circonus Broker ID")
 	f.StringVar(&cfg.Metrics.Circonus.CheckID, "metrics.circonus.checkid", defaultConfig.Metrics.Circonus.CheckID, "Circonus Check ID")
 	f.StringVar(&cfg.Metrics.Circonus.SubmissionURL, "metrics.circonus.submissionurl", defaultConfig.Metrics.Circonus.SubmissionURL, "Circonus Check SubmissionURL")
-	f.StringVar(&cfg.Registry.Backend, "registry.backend", defaultConfig.Registry.Backend, "registry backend")
+	f.StringVar(&cfg.Registry.Backend, "registry.backend", defaultConfig.Registry.Backend, "registry backend: consul, static, file, custom, kubernetes")
 	f.DurationVar(&cfg.Registry.Timeout, "registry.timeout", defaultConfig.Registry.Timeout, "timeout for registry to become available")
 	f.DurationVar(&cfg.Registry.Retry, "registry.retry", defaultConfig.Registry.Retry, "retry interval during startup")
+	f.StringVar(&cfg.Registry.StartupFailMode, "registry.startup.failmode", defaultConfig.Registry.StartupFailMode, "behavior when the registry is not available on startup: exit or serve-degraded")
+	f.DurationVar(&cfg.Registry.StartupTimeout, "registry.startup.timeout", defaultConfig.Registry.StartupTimeout, "time to wait for the registry on startup before serving the degraded routing table. 0 uses registry.timeout")
+	f.StringVar(&cfg.Registry.StartupRoutes, "registry.startup.routes", defaultConfig.Registry.StartupRoutes, "path to a file with route commands which are served in degraded mode if there is no routing table snapshot")
 	f.StringVar(&cfg.Registry.File.RoutesPath, "registry.file.path", defaultConfig.Registry.File.RoutesPath, "path to file based routing table")
 	f.StringVar(&cfg.Registry.File.NoRouteHTMLPath, "registry.file.noroutehtmlpath", defaultConfig.Registry.File.NoRouteHTMLPath, "path to file for HTML returned when no route is found")
 	f.StringVar(&cfg.Registry.Static.Routes, "registry.static.routes", defaultConfig.Registry.Static.Routes, "static routes")
@@ -175,6 +278,7 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Registry.Consul.KVPath, "registry.consul.kvpath", defaultConfig.Registry.Consul.KVPath, "consul KV path for manual overrides")
 	f.StringVar(&cfg.Registry.Consul.NoRouteHTMLPath, "registry.consul.noroutehtmlpath", defaultConfig.Registry.Consul.NoRouteHTMLPath, "consul KV path for HTML returned when no route is found")
 	f.StringVar(&cfg.Registry.Consul.TagPrefix, "registry.consul.tagprefix", defaultConfig.Registry.Consul.TagPrefix, "prefix for consul tags")
+	f.StringSliceVar(&cfg.Registry.Consul.Datacenters, "registry.consul.datacenters", defaultConfig.Registry.Consul.Datacenters, "datacenters whose services are routed in order of priority. The local datacenter is always preferred")
 	f.StringVar(&cfg.Registry.Consul.TLS.KeyFile, "registry.consul.tls.keyfile", defaultConfig.Registry.Consul.TLS.KeyFile, "path to consul key file")
 	f.StringVar(&cfg.Registry.Consul.TLS.CertFile, "registry.consul.tls.certfile", defaultConfig.Registry.Consul.TLS.CertFile, "path to consul cert file")
 	f.StringVar(&cfg.Registry.Consul.TLS.CAFile, "registry.consul.tls.cafile", defaultConfig.Registry.Consul.TLS.CAFile, "path to consul CA file")
@@ -192,12 +296,16 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Registry.Consul.ChecksRequired, "registry.consul.checksRequired", defaultConfig.Registry.Consul.ChecksRequired, "number of checks which must pass: one or all")
 	f.IntVar(&cfg.Registry.Consul.ServiceMonitors, "registry.consul.serviceMonitors", defaultConfig.Registry.Consul.ServiceMonitors, "concurrency for route updates")
 	f.DurationVar(&cfg.Registry.Consul.PollInterval, "registry.consul.pollinterval", defaultConfig.Registry.Consul.PollInterval, "poll interval for route updates")
+	f.DurationVar(&cfg.Registry.Consul.WatchWaitTime, "registry.consul.watch.waittime", defaultConfig.Registry.Consul.WatchWaitTime, "maximum duration of a blocking query for route updates")
+	f.DurationVar(&cfg.Registry.Consul.WatchMaxBackoff, "registry.consul.watch.maxbackoff", defaultConfig.Registry.Consul.WatchMaxBackoff, "maximum delay between retries of failed queries for route updates")
+	f.DurationVar(&cfg.Registry.Consul.WatchResyncAfter, "registry.consul.watch.resyncafter", defaultConfig.Registry.Consul.WatchResyncAfter, "duration of failed queries after which the route updates are resynced")
 	f.IntVar(&cfg.Runtime.GOGC, "runtime.gogc", defaultConfig.Runtime.GOGC, "sets runtime.GOGC")
 	f.IntVar(&cfg.Runtime.GOMAXPROCS, "runtime.gomaxprocs", defaultConfig.Runtime.GOMAXPROCS, "sets runtime.GOMAXPROCS")
 	f.StringVar(&cfg.UI.Access, "ui.access", defaultConfig.UI.Access, "access mode, one of [ro, rw]")
 	f.StringVar(&uiListenerValue, "ui.addr", defaultValues.UIListenerValue, "Address the UI/API is listening on")
 	f.StringVar(&cfg.UI.Color, "ui.color", defaultConfig.UI.Color, "background color of the UI")
 	f.StringVar(&cfg.UI.Title, "ui.title", defaultConfig.UI.Title, "optional title for the UI")
+	f.StringVar(&cfg.UI.OverridesFile, "admin.overrides.file", defaultConfig.UI.OverridesFile, "path to the file for the manual overrides instead of the registry backend")
 	f.StringVar(&cfg.ProfileMode, "profile.mode", defaultConfig.ProfileMode, "enable profiling mode, one of [cpu, mem, mutex, block, trace]")
 	f.StringVar(&cfg.ProfilePath, "profile.path", defaultConfig.ProfilePath, "path to profile dump file")
 	f.BoolVar(&cfg.Tracing.TracingEnabled, "tracing.TracingEnabled", defaultConfig.Tracing.TracingEnabled, "Enable/Disable OpenTrace, one of [true, false]")
@@ -210,6 +318,13 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Tracing.SpanHost, "tracing.SpanHost", defaultConfig.Tracing.SpanHost, "Host:Port info to add to spans")
 	f.BoolVar(&cfg.Tracing.TraceID128Bit, "tracing.TraceID128Bit", defaultConfig.Tracing.TraceID128Bit, "Generate 128 bit trace IDs")
 	f.BoolVar(&cfg.GlobMatchingDisabled, "glob.matching.disabled", defaultConfig.GlobMatchingDisabled, "Disable Glob Matching on routes, one of [true, false]")
+	f.IntVar(&cfg.Routing.MaxRoutes, "routing.maxroutes", defaultConfig.Routing.MaxRoutes, "maximum number of routes in the routing table. 0 disables the limit")
+	f.StringVar(&cfg.Routing.ConflictMode, "routing.conflictmode", defaultConfig.Routing.ConflictMode, "handling of routes with targets of more than one service, one of [merge, warn, reject]")
+	f.StringVar(&cfg.Routing.TrailingSlash, "routing.trailingslash", defaultConfig.Routing.TrailingSlash, "handling of request paths which match a route only with the trailing slash added or removed, one of [strict, redirect, ignore]")
+	f.StringVar(&cfg.Routing.GeoIP.Database, "routing.geoip.database", defaultConfig.Routing.GeoIP.Database, "path to a MaxMind DB for the 'match=geo:country=...' route option. Reloaded on SIGHUP")
+	f.StringVar(&cfg.Routing.GeoIP.Header, "routing.geoip.header", defaultConfig.Routing.GeoIP.Header, "header for the country code of the client. Requires routing.geoip.database")
+	f.StringVar(&cfg.Routing.Snapshot.File, "routing.snapshot.file", defaultConfig.Routing.Snapshot.File, "path to the file for the routing table snapshot which is loaded on startup")
+	f.DurationVar(&cfg.Routing.Snapshot.Interval, "routing.snapshot.interval", defaultConfig.Routing.Snapshot.Interval, "interval for writing the routing table snapshot")
 	f.IntVar(&cfg.GlobCacheSize, "glob.cache.size", defaultConfig.GlobCacheSize, "sets the size of the glob cache")
 
 	f.StringVar(&cfg.Registry.Custom.Host, "registry.custom.host", defaultConfig.Registry.Custom.Host, "custom back end hostname/port")
@@ -220,6 +335,14 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.DurationVar(&cfg.Registry.Custom.PollInterval, "registry.custom.pollinterval", defaultConfig.Registry.Custom.PollInterval, "poll interval for API request to custom back end")
 	f.StringVar(&cfg.Registry.Custom.Path, "registry.custom.path", defaultConfig.Registry.Custom.Path, "custom back end path in the URL")
 	f.StringVar(&cfg.Registry.Custom.QueryParams, "registry.custom.queryparams", defaultConfig.Registry.Custom.QueryParams, "custom back end query parameters in the URL")
+	f.StringVar(&cfg.Registry.Kubernetes.Addr, "registry.kubernetes.addr", defaultConfig.Registry.Kubernetes.Addr, "address of the kubernetes API server. Uses the in-cluster config if empty")
+	f.StringVar(&cfg.Registry.Kubernetes.Namespace, "registry.kubernetes.namespace", defaultConfig.Registry.Kubernetes.Namespace, "kubernetes namespace to watch. Watches all namespaces if empty")
+	f.StringVar(&cfg.Registry.Kubernetes.TokenPath, "registry.kubernetes.tokenpath", defaultConfig.Registry.Kubernetes.TokenPath, "path to the kubernetes service account token")
+	f.StringVar(&cfg.Registry.Kubernetes.CAPath, "registry.kubernetes.capath", defaultConfig.Registry.Kubernetes.CAPath, "path to the CA certificate of the kubernetes API server")
+	f.BoolVar(&cfg.Registry.Kubernetes.TLSSkipVerify, "registry.kubernetes.tlsskipverify", defaultConfig.Registry.Kubernetes.TLSSkipVerify, "disable certificate validation for the kubernetes API server")
+	f.StringVar(&cfg.Registry.Kubernetes.AnnotationPrefix, "registry.kubernetes.annotationprefix", defaultConfig.Registry.Kubernetes.AnnotationPrefix, "prefix for service annotations which define routes")
+	f.DurationVar(&cfg.Registry.Kubernetes.Resync, "registry.kubernetes.resync", defaultConfig.Registry.Kubernetes.Resync, "interval for rebuilding the routes from the kubernetes services")
+	f.StringVar(&cfg.Registry.Kubernetes.NoRouteHTML, "registry.kubernetes.noroutehtml", defaultConfig.Registry.Kubernetes.NoRouteHTML, "HTML which is returned when no route is found")
 
 	// deprecated flags
 	var proxyLogRoutes string
@@ -244,6 +367,7 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	if err != nil {
 		return nil, err
 	}
+	cfg.Proxy.CertSources = certSources
 
 	authSchemes, err := parseAuthSchemes(authSchemesValue)
 
@@ -253,6 +377,16 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 
 	cfg.Proxy.AuthSchemes = authSchemes
 
+	cfg.Proxy.ResponseHeaders, err = parseResponseHeaders(responseHeadersValue)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Log.AccessTargets, err = parseAccessLogTargets(accessTargetsValue)
+	if err != nil {
+		return nil, err
+	}
+
 	if uiListenerValue != "" {
 		kvs, err := parseKVSlice(uiListenerValue)
 		if err != nil {
@@ -261,13 +395,41 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		if len(kvs) != 1 {
 			return nil, fmt.Errorf("ui.addr must contain only one listener")
 		}
-		cfg.UI.Listen, err = parseListen(kvs[0], certSources, 0, 0)
+		cfg.UI.Listen, err = parseListen(kvs[0], certSources, Listen{})
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	cfg.Listen, err = parseListeners(listenerValue, certSources, readTimeout, writeTimeout)
+	if noRouteRateLimit < 0 {
+		return nil, fmt.Errorf("proxy.noroute.ratelimit must not be negative")
+	}
+
+	if readHeaderTimeout < 0 {
+		return nil, fmt.Errorf("proxy.readheadertimeout must not be negative")
+	}
+
+	if readBodyTimeout < 0 {
+		return nil, fmt.Errorf("proxy.readbodytimeout must not be negative")
+	}
+
+	if readBodyMinRate < 0 {
+		return nil, fmt.Errorf("proxy.readbodyminrate must not be negative")
+	}
+
+	if tlsHandshakeTimeout < 0 {
+		return nil, fmt.Errorf("proxy.tls.handshaketimeout must not be negative")
+	}
+
+	cfg.Listen, err = parseListeners(listenerValue, certSources, Listen{
+		ReadTimeout:         readTimeout,
+		WriteTimeout:        writeTimeout,
+		ReadHeaderTimeout:   readHeaderTimeout,
+		ReadBodyTimeout:     readBodyTimeout,
+		ReadBodyMinRate:     readBodyMinRate,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		NoRouteRateLimit:    noRouteRateLimit,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -305,14 +467,295 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		}
 	}
 
-	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" {
+	switch cfg.Proxy.Strategy {
+	case "rr", "rnd", "adaptiveload", "adaptive-latency", "hash:path", "hash:uri":
+		// ok
+	default:
 		return nil, fmt.Errorf("invalid proxy.strategy: %s", cfg.Proxy.Strategy)
 	}
 
+	for _, s := range cfg.Proxy.HeaderSanitizeTrusted {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return nil, fmt.Errorf("invalid proxy.headersanitize.trusted: %s", s)
+		}
+	}
+
 	if cfg.Proxy.Matcher != "prefix" && cfg.Proxy.Matcher != "glob" && cfg.Proxy.Matcher != "iprefix" {
 		return nil, fmt.Errorf("invalid proxy.matcher: %s", cfg.Proxy.Matcher)
 	}
 
+	if cfg.Proxy.RequestIDFormat != "uuid" && cfg.Proxy.RequestIDFormat != "hex" {
+		return nil, fmt.Errorf("invalid proxy.requestid.format: %s", cfg.Proxy.RequestIDFormat)
+	}
+
+	if cfg.Proxy.Expect100 && cfg.Proxy.Expect100Timeout <= 0 {
+		return nil, fmt.Errorf("proxy.expect100.timeout must be greater than zero")
+	}
+
+	if cfg.Routing.MaxRoutes < 0 {
+		return nil, fmt.Errorf("routing.maxroutes must not be negative")
+	}
+
+	switch cfg.Routing.ConflictMode {
+	case "merge", "warn", "reject":
+	default:
+		return nil, fmt.Errorf("invalid routing.conflictmode: %s", cfg.Routing.ConflictMode)
+	}
+
+	switch cfg.Metrics.StatusCodes {
+	case "class", "code", "none":
+	default:
+		return nil, fmt.Errorf("invalid metrics.statuscodes: %s", cfg.Metrics.StatusCodes)
+	}
+
+	switch cfg.Routing.TrailingSlash {
+	case "strict", "redirect", "ignore":
+	default:
+		return nil, fmt.Errorf("invalid routing.trailingslash: %s", cfg.Routing.TrailingSlash)
+	}
+
+	if cfg.Routing.GeoIP.Header != "" && cfg.Routing.GeoIP.Database == "" {
+		return nil, fmt.Errorf("routing.geoip.header requires routing.geoip.database")
+	}
+
+	if cfg.Routing.Snapshot.Interval <= 0 {
+		return nil, fmt.Errorf("routing.snapshot.interval must be positive")
+	}
+
+	if cfg.Log.AccessBufferSize < 0 {
+		return nil, fmt.Errorf("log.access.buffersize must not be negative")
+	}
+
+	if cfg.Log.AccessOverflow != "drop" && cfg.Log.AccessOverflow != "block" {
+		return nil, fmt.Errorf("invalid log.access.overflow: %s", cfg.Log.AccessOverflow)
+	}
+
+	if cfg.Log.AccessTarget == "otlp" && cfg.Log.AccessOTLP.Endpoint == "" {
+		return nil, fmt.Errorf("log.access.otlp.endpoint is required for log.access.target=otlp")
+	}
+
+	if cfg.Log.AccessTarget == "otlp" && cfg.Log.AccessBufferSize == 0 {
+		return nil, fmt.Errorf("log.access.buffersize is required for log.access.target=otlp")
+	}
+
+	if cfg.Log.AccessOTLP.BatchSize <= 0 {
+		return nil, fmt.Errorf("log.access.otlp.batchsize must be positive")
+	}
+
+	if cfg.Proxy.GRPCMaxConn < 0 {
+		return nil, fmt.Errorf("proxy.grpc.maxconn must not be negative")
+	}
+
+	if cfg.Proxy.GRPCMaxStreams < 0 {
+		return nil, fmt.Errorf("proxy.grpc.maxconcurrentstreams must not be negative")
+	}
+
+	if cfg.Proxy.GRPCReflection != "" && !strings.HasPrefix(cfg.Proxy.GRPCReflection, "/") {
+		return nil, fmt.Errorf("proxy.grpc.reflection must start with /: %s", cfg.Proxy.GRPCReflection)
+	}
+
+	if cfg.Registry.Consul.WatchWaitTime <= 0 {
+		return nil, fmt.Errorf("registry.consul.watch.waittime must be greater than zero")
+	}
+
+	if cfg.Registry.Consul.WatchMaxBackoff <= 0 {
+		return nil, fmt.Errorf("registry.consul.watch.maxbackoff must be greater than zero")
+	}
+
+	if cfg.Proxy.SignHeader == "" || cfg.Proxy.SignDateHeader == "" {
+		return nil, fmt.Errorf("proxy.sign.header and proxy.sign.dateheader must not be empty")
+	}
+
+	if len(cfg.Proxy.SignFields) == 0 {
+		return nil, fmt.Errorf("proxy.sign.fields must not be empty")
+	}
+	for _, field := range cfg.Proxy.SignFields {
+		switch field {
+		case "method", "host", "path", "query", "date":
+		default:
+			return nil, fmt.Errorf("invalid proxy.sign.fields: %s", field)
+		}
+	}
+
+	for _, cond := range cfg.Proxy.RetryOn {
+		switch cond {
+		case "connect-failure", "refused-stream", "goaway", "reset", "unexpected":
+		default:
+			if code, err := strconv.Atoi(cond); err != nil || code < 500 || code > 599 {
+				return nil, fmt.Errorf("invalid proxy.retry.on: %s", cond)
+			}
+		}
+	}
+
+	if cfg.Proxy.RetryAttempts < 0 {
+		return nil, fmt.Errorf("proxy.retry.attempts must not be negative")
+	}
+
+	if cfg.Proxy.RetryMaxBody < 0 {
+		return nil, fmt.Errorf("proxy.retry.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.AggregateMaxBody < 0 {
+		return nil, fmt.Errorf("proxy.aggregate.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.BufferMaxBody < 0 {
+		return nil, fmt.Errorf("proxy.buffer.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.TransformMaxBody < 0 {
+		return nil, fmt.Errorf("proxy.transform.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.FallbackMaxBody < 0 {
+		return nil, fmt.Errorf("proxy.fallback.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.MaxConcurrent < 0 {
+		return nil, fmt.Errorf("proxy.maxconcurrent must not be negative")
+	}
+
+	if cfg.Proxy.BreakerCacheSize < 0 {
+		return nil, fmt.Errorf("proxy.breakerfallback.cachesize must not be negative")
+	}
+
+	if cfg.Proxy.BreakerCacheMaxBody < 0 {
+		return nil, fmt.Errorf("proxy.breakerfallback.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.MaxConcurrentQueue < 0 {
+		return nil, fmt.Errorf("proxy.maxconcurrent.queue must not be negative")
+	}
+
+	if cfg.Proxy.MaxConcurrentTimeout <= 0 {
+		return nil, fmt.Errorf("proxy.maxconcurrent.timeout must be greater than zero")
+	}
+
+	if cfg.Proxy.TCPDialAttempts < 1 {
+		return nil, fmt.Errorf("proxy.tcp.dialattempts must be at least 1")
+	}
+
+	switch cfg.Registry.StartupFailMode {
+	case "exit", "serve-degraded":
+	default:
+		return nil, fmt.Errorf("invalid registry.startup.failmode: %s", cfg.Registry.StartupFailMode)
+	}
+
+	if cfg.Registry.StartupTimeout < 0 {
+		return nil, fmt.Errorf("registry.startup.timeout must not be negative")
+	}
+
+	if cfg.Proxy.QuotaSyncInterval <= 0 {
+		return nil, fmt.Errorf("proxy.quota.syncinterval must be greater than zero")
+	}
+
+	if cfg.Proxy.HeaderSizeWarn < 0 {
+		return nil, fmt.Errorf("proxy.headersize.warn must not be negative")
+	}
+
+	if cfg.Proxy.TCPMaxConnPerIP < 0 {
+		return nil, fmt.Errorf("proxy.tcp.maxconnperip must not be negative")
+	}
+
+	if cfg.Proxy.SNIClientHelloTimeout < 0 {
+		return nil, fmt.Errorf("proxy.tcp.sni.clienthellotimeout must not be negative")
+	}
+
+	if cfg.Proxy.SNIMaxClientHelloSize <= 0 {
+		return nil, fmt.Errorf("proxy.tcp.sni.maxclienthellosize must be positive")
+	}
+
+	if cfg.Proxy.TenantMaxPools <= 0 {
+		return nil, fmt.Errorf("proxy.tenant.maxpools must be positive")
+	}
+
+	if cfg.Proxy.TenantHeader != "" && len(cfg.Proxy.TenantAllow) == 0 {
+		return nil, fmt.Errorf("proxy.tenant.allow must not be empty if proxy.tenant.header is set")
+	}
+
+	if cfg.Proxy.MinConnsMax <= 0 {
+		return nil, fmt.Errorf("proxy.minconns.max must be positive")
+	}
+
+	if cfg.Proxy.TLSTicketRotation < 0 {
+		return nil, fmt.Errorf("proxy.tls.ticketrotation must not be negative")
+	}
+
+	if cfg.Proxy.TLSSessionCacheSize < 0 {
+		return nil, fmt.Errorf("proxy.tls.sessioncachesize must not be negative")
+	}
+
+	if cfg.Proxy.TLSMaxHandshakes < 0 {
+		return nil, fmt.Errorf("proxy.tls.maxconcurrenthandshakes must not be negative")
+	}
+
+	if cfg.Proxy.TLSHandshakeQueue < 0 {
+		return nil, fmt.Errorf("proxy.tls.handshakequeue must not be negative")
+	}
+
+	if cfg.Proxy.Outlier.Consecutive5xx < 0 {
+		return nil, fmt.Errorf("proxy.outlier.consecutive5xx must not be negative")
+	}
+
+	if cfg.Proxy.Outlier.BaseEjectionTime <= 0 {
+		return nil, fmt.Errorf("proxy.outlier.baseejectiontime must be greater than zero")
+	}
+
+	if cfg.Proxy.Outlier.MaxEjectionTime < cfg.Proxy.Outlier.BaseEjectionTime {
+		return nil, fmt.Errorf("proxy.outlier.maxejectiontime must not be less than proxy.outlier.baseejectiontime")
+	}
+
+	if cfg.Proxy.Outlier.MaxEjectionPercent < 0 || cfg.Proxy.Outlier.MaxEjectionPercent > 100 {
+		return nil, fmt.Errorf("proxy.outlier.maxejectionpercent must be between 0 and 100")
+	}
+
+	if cfg.Proxy.Latency.Smoothing <= 0 || cfg.Proxy.Latency.Smoothing > 1 {
+		return nil, fmt.Errorf("proxy.latency.smoothing must be greater than 0 and at most 1")
+	}
+
+	if cfg.Proxy.Latency.Interval <= 0 {
+		return nil, fmt.Errorf("proxy.latency.interval must be greater than zero")
+	}
+
+	if cfg.Proxy.Latency.MinFactor <= 0 || cfg.Proxy.Latency.MinFactor > 1 || cfg.Proxy.Latency.MaxFactor < 1 {
+		return nil, fmt.Errorf("proxy.latency.minfactor must be between 0 and 1 and proxy.latency.maxfactor at least 1")
+	}
+
+	if cfg.Proxy.Capture.MaxBody < 0 {
+		return nil, fmt.Errorf("proxy.capture.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.Capture.SampleRate < 0 || cfg.Proxy.Capture.SampleRate > 1 {
+		return nil, fmt.Errorf("proxy.capture.samplerate must be between 0 and 1")
+	}
+
+	if cfg.Proxy.Capture.Target == "" {
+		return nil, fmt.Errorf("proxy.capture.target must not be empty")
+	}
+
+	if cfg.Proxy.Tap.Sink != "" {
+		u, err := url.Parse(cfg.Proxy.Tap.Sink)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "kafka") || u.Host == "" {
+			return nil, fmt.Errorf("proxy.tap.sink must be an http, https or kafka URL")
+		}
+	}
+
+	if cfg.Proxy.DrainDecay < 0 {
+		return nil, fmt.Errorf("proxy.draindecay must not be negative")
+	}
+
+	if cfg.Proxy.Tap.BufferSize <= 0 {
+		return nil, fmt.Errorf("proxy.tap.buffersize must be positive")
+	}
+
+	if cfg.Proxy.Tap.MaxBody < 0 {
+		return nil, fmt.Errorf("proxy.tap.maxbody must not be negative")
+	}
+
+	if cfg.Proxy.Tap.Timeout <= 0 {
+		return nil, fmt.Errorf("proxy.tap.timeout must be positive")
+	}
+
 	if cfg.UI.Access != "ro" && cfg.UI.Access != "rw" {
 		return nil, fmt.Errorf("invalid ui.access: %s", cfg.UI.Access)
 	}
@@ -357,10 +800,12 @@ func parseScheme(s string) (scheme, addr string) {
 	return
 }
 
-func parseListeners(cfgs string, cs map[string]CertSource, readTimeout, writeTimeout time.Duration) (listen []Listen, err error) {
+// parseListeners parses the listener configurations. Values which are
+// not configured for a listener are taken from defaults.
+func parseListeners(cfgs string, cs map[string]CertSource, defaults Listen) (listen []Listen, err error) {
 	kvs, err := parseKVSlice(cfgs)
 	for _, cfg := range kvs {
-		l, err := parseListen(cfg, cs, readTimeout, writeTimeout)
+		l, err := parseListen(cfg, cs, defaults)
 		if err != nil {
 			return nil, err
 		}
@@ -369,11 +814,8 @@ func parseListeners(cfgs string, cs map[string]CertSource, readTimeout, writeTim
 	return
 }
 
-func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, writeTimeout time.Duration) (l Listen, err error) {
-	l = Listen{
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
-	}
+func parseListen(cfg map[string]string, cs map[string]CertSource, defaults Listen) (l Listen, err error) {
+	l = defaults
 
 	var csName string
 	for k, v := range cfg {
@@ -385,7 +827,7 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 		case "proto":
 			l.Proto = v
 			switch l.Proto {
-			case "tcp", "tcp+sni", "tcp-dynamic", "http", "https", "grpc", "grpcs", "https+tcp+sni":
+			case "tcp", "tcp+sni", "tcp-dynamic", "http", "https", "grpc", "grpcs", "https+tcp+sni", "https+alpn":
 				// ok
 			default:
 				return Listen{}, fmt.Errorf("unknown protocol %q", v)
@@ -402,7 +844,7 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 				return Listen{}, err
 			}
 			l.WriteTimeout = d
-		case "it": // idle timeout
+		case "it", "idletimeout": // idle timeout
 			d, err := time.ParseDuration(v)
 			if err != nil {
 				return Listen{}, err
@@ -446,12 +888,44 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 				return Listen{}, err
 			}
 			l.ProxyHeaderTimeout = d
+		case "pxytrust":
+			for _, s := range strings.Split(v, ",") {
+				s = strings.TrimSpace(s)
+				if _, _, err := net.ParseCIDR(s); err != nil {
+					return Listen{}, fmt.Errorf("pxytrust should be a list of CIDR networks. Got: %s", s)
+				}
+				l.ProxyProtoTrusted = append(l.ProxyProtoTrusted, s)
+			}
+		case "pxyxff":
+			switch v {
+			case "append", "replace", "dedup":
+				l.ProxyProtoXFF = v
+			default:
+				return Listen{}, fmt.Errorf("pxyxff should be append, replace or dedup. Got: %s", v)
+			}
 		case "refresh":
 			d, err := time.ParseDuration(v)
 			if err != nil {
 				return Listen{}, err
 			}
 			l.Refresh = d
+		case "alpn":
+			for _, s := range strings.Split(v, "|") {
+				p := strings.SplitN(s, ":", 2)
+				if len(p) != 2 || p[0] == "" || (p[1] != "http" && p[1] != "grpc") {
+					return Listen{}, fmt.Errorf("alpn should be in the form <proto>:<http|grpc>. Got: %s", s)
+				}
+				l.ALPN = append(l.ALPN, ALPN{Proto: p[0], Handler: p[1]})
+			}
+		case "noroutelimit":
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return Listen{}, err
+			}
+			if n < 0 {
+				return Listen{}, fmt.Errorf("noroutelimit must not be negative")
+			}
+			l.NoRouteRateLimit = n
 		}
 	}
 
@@ -461,8 +935,8 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	if l.Addr == "" {
 		return Listen{}, fmt.Errorf("need listening host:port")
 	}
-	if csName != "" && l.Proto != "https" && l.Proto != "tcp" && l.Proto != "tcp-dynamic" && l.Proto != "grpcs" && l.Proto != "https+tcp+sni" {
-		return Listen{}, fmt.Errorf("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'")
+	if csName != "" && l.Proto != "https" && l.Proto != "tcp" && l.Proto != "tcp-dynamic" && l.Proto != "grpcs" && l.Proto != "https+tcp+sni" && l.Proto != "https+alpn" {
+		return Listen{}, fmt.Errorf("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'https+alpn', or 'grpcs'")
 	}
 	if csName == "" && l.Proto == "https" {
 		return Listen{}, fmt.Errorf("proto 'https' requires cert source")
@@ -470,6 +944,12 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	if csName == "" && l.Proto == "grpcs" {
 		return Listen{}, fmt.Errorf("proto 'grpcs' requires cert source")
 	}
+	if csName == "" && l.Proto == "https+alpn" {
+		return Listen{}, fmt.Errorf("proto 'https+alpn' requires cert source")
+	}
+	if len(l.ALPN) > 0 && l.Proto != "https+alpn" {
+		return Listen{}, fmt.Errorf("alpn requires proto 'https+alpn'")
+	}
 	if cs[csName].Type == "vault-pki" && !l.StrictMatch {
 		// Without StrictMatch the first issued certificate is used for all
 		// subsequent requests, even if the common name doesn't match.
@@ -628,6 +1108,94 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 	return
 }
 
+// parseResponseHeaders parses a list of response headers in the form
+//
+//	name=value;name=value;force=true,name=value
+//
+// The force option applies to all headers of the same group.
+func parseResponseHeaders(cfgs string) (hdrs []ResponseHeader, err error) {
+	kvs, err := parseKVSlice(cfgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range kvs {
+		var force bool
+		if v, ok := cfg["force"]; ok {
+			if force, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid 'force' in proxy.responseheaders: %s", v)
+			}
+		}
+
+		var names []string
+		for k := range cfg {
+			switch k {
+			case "force":
+				continue
+			case "":
+				return nil, fmt.Errorf("missing header name in proxy.responseheaders: %s", cfg[k])
+			}
+			names = append(names, k)
+		}
+		if len(names) == 0 {
+			return nil, errors.New("missing header in proxy.responseheaders")
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			hdrs = append(hdrs, ResponseHeader{Name: name, Value: cfg[name], Force: force})
+		}
+	}
+	return hdrs, nil
+}
+
+// parseAccessLogTargets parses a list of access log destinations in the form
+//
+//	name=a;type=file;path=/var/log/a.log,name=b;type=syslog;addr=udp://host:514;tag=fabio
+func parseAccessLogTargets(cfgs string) (map[string]AccessLogTarget, error) {
+	kvs, err := parseKVSlice(cfgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	targets := map[string]AccessLogTarget{}
+	for _, cfg := range kvs {
+		t := AccessLogTarget{Name: cfg["name"], Type: cfg["type"], Tag: cfg["tag"]}
+		if t.Name == "" {
+			return nil, errors.New("missing 'name' in log.access.targets")
+		}
+		if _, ok := targets[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate name %q in log.access.targets", t.Name)
+		}
+		switch t.Type {
+		case "stdout":
+		case "file":
+			t.Path = cfg["path"]
+			if t.Path == "" {
+				return nil, fmt.Errorf("missing 'path' for log target %q", t.Name)
+			}
+		case "syslog":
+			if addr := cfg["addr"]; addr != "" {
+				p := strings.SplitN(addr, "://", 2)
+				if len(p) != 2 || (p[0] != "udp" && p[0] != "tcp") || p[1] == "" {
+					return nil, fmt.Errorf("invalid 'addr' for log target %q: %s", t.Name, addr)
+				}
+				t.Network, t.Addr = p[0], p[1]
+			}
+			if t.Tag == "" {
+				t.Tag = "fabio"
+			}
+		case "":
+			return nil, fmt.Errorf("missing 'type' for log target %q", t.Name)
+		default:
+			return nil, fmt.Errorf("invalid 'type' for log target %q: %s", t.Name, t.Type)
+		}
+		targets[t.Name] = t
+	}
+	return targets, nil
+}
+
 func parseAuthSchemes(cfgs string) (as map[string]AuthScheme, err error) {
 	kvs, err := parseKVSlice(cfgs)
 	if err != nil {
@@ -150,12 +150,24 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.addr with idle timeouts per listener",
+			args: []string{"-proxy.addr", `:5555;idletimeout=5s,:6666;proto=tcp;idletimeout=5m`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{Addr: ":5555", Proto: "http", IdleTimeout: 5 * time.Second},
+					{Addr: ":6666", Proto: "tcp", IdleTimeout: 5 * time.Minute},
+				}
+				return cfg
+			},
+		},
 		{
 			desc: "-proxy.addr with file cert source",
 			args: []string{"-proxy.addr", ":5555;cs=name", "-proxy.cs", "cs=name;type=file;cert=value"},
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "file", CertPath: "value"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -165,6 +177,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "path", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -174,6 +187,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "http", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -183,6 +197,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "consul", CertPath: "value"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -192,6 +207,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -205,6 +221,7 @@ func TestLoad(t *testing.T) {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/value", Refresh: 3 * time.Second}
 				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -218,6 +235,7 @@ func TestLoad(t *testing.T) {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/value", Refresh: 3 * time.Second}
 				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -241,6 +259,7 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -264,6 +283,31 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with proto https+alpn",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=https+alpn;alpn=x-grpc:grpc|h2:http", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:  ":5555",
+						Proto: "https+alpn",
+						ALPN: []ALPN{
+							{Proto: "x-grpc", Handler: "grpc"},
+							{Proto: "h2", Handler: "http"},
+						},
+						CertSource: CertSource{
+							Name:     "name",
+							Type:     "path",
+							CertPath: "foo",
+							Refresh:  3 * time.Second,
+						},
+					},
+				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -318,6 +362,7 @@ func TestLoad(t *testing.T) {
 					Type:     "consul",
 					CertPath: "http://localhost:8500/v1/kv/ssl?token=token",
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"consul-cs": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -328,6 +373,25 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.localaddr", "10.0.0.1, node-a"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.LocalAddr = []string{"10.0.0.1", "node-a"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.draindecay", "5m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.DrainDecay = 5 * time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.draindecay", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.draindecay must not be negative"),
+		},
 		{
 			args: []string{"-proxy.strategy", "rnd"},
 			cfg: func(cfg *Config) *Config {
@@ -342,6 +406,34 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.strategy", "adaptiveload"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "adaptiveload"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "hash:path"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "hash:path"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "hash:uri"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "hash:uri"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.loadheader", "X-Load"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.LoadHeader = "X-Load"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.matcher", "prefix"},
 			cfg: func(cfg *Config) *Config {
@@ -370,6 +462,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.noroute.log", "true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.NoRouteLog = true
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.shutdownwait", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -412,6 +511,61 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.readheadertimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", ReadHeaderTimeout: 5 * time.Millisecond}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.handshaketimeout", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", TLSHandshakeTimeout: 5 * time.Second}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.readbodytimeout", "5s", "-proxy.readbodyminrate", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", ReadBodyTimeout: 5 * time.Second, ReadBodyMinRate: 1024}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.noroute.ratelimit", "50"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", NoRouteRateLimit: 50}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.noroute.ratelimit", "50", "-proxy.addr", ":5555;noroutelimit=2.5,:6666"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{Addr: ":5555", Proto: "http", NoRouteRateLimit: 2.5},
+					{Addr: ":6666", Proto: "http", NoRouteRateLimit: 50},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with trusted PROXY protocol sources",
+			args: []string{"-proxy.addr", `:5555;pxyproto=true;pxytrust="10.0.0.0/8, 192.168.1.0/24";pxyxff=dedup`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:               ":5555",
+						Proto:              "http",
+						ProxyProto:         true,
+						ProxyHeaderTimeout: 250 * time.Millisecond,
+						ProxyProtoTrusted:  []string{"10.0.0.0/8", "192.168.1.0/24"},
+						ProxyProtoXFF:      "dedup",
+					},
+				}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.flushinterval", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -433,6 +587,133 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.grpc.maxconn", "100"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.GRPCMaxConn = 100
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.grpc.maxconcurrentstreams", "50"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.GRPCMaxStreams = 50
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.grpc.reflection", "/my.pkg.Service"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.GRPCReflection = "/my.pkg.Service"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.grpc.reflection", "my.pkg.Service"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.grpc.reflection must start with /: my.pkg.Service"),
+		},
+		{
+			args: []string{"-proxy.tls.ticketrotation", "12h"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSTicketRotation = 12 * time.Hour
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.ticketkeyfile", "/etc/fabio/ticketkeys"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSTicketKeyFile = "/etc/fabio/ticketkeys"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.clientcrl", "/etc/fabio/clients.crl"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSClientCRL = "/etc/fabio/clients.crl"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.maxconcurrenthandshakes", "64", "-proxy.tls.handshakequeue", "500"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSMaxHandshakes = 64
+				cfg.Proxy.TLSHandshakeQueue = 500
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.handshakemetrics"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSHandshakeMetrics = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.ja3", "-proxy.header.ja3", "X-JA3"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.JA3 = true
+				cfg.Proxy.JA3Header = "X-JA3"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.sessioncachesize", "1000"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSSessionCacheSize = 1000
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.consecutive5xx", "5"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Outlier.Consecutive5xx = 5
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.baseejectiontime", "10s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Outlier.BaseEjectionTime = 10 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.maxejectiontime", "1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Outlier.MaxEjectionTime = time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.maxejectionpercent", "50"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Outlier.MaxEjectionPercent = 50
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "adaptive-latency", "-proxy.latency.smoothing", "0.5", "-proxy.latency.interval", "5s", "-proxy.latency.minfactor", "0.5", "-proxy.latency.maxfactor", "2"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "adaptive-latency"
+				cfg.Proxy.Latency = Latency{Smoothing: 0.5, Interval: 5 * time.Second, MinFactor: 0.5, MaxFactor: 2}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.capture.maxbody", "1024", "-proxy.capture.samplerate", "0.1", "-proxy.capture.target", "/var/log/fabio/capture.log"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Capture = Capture{MaxBody: 1024, SampleRate: 0.1, Target: "/var/log/fabio/capture.log"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tap.sink", "kafka://kafka1:9092,kafka2:9092/requests", "-proxy.tap.buffersize", "100", "-proxy.tap.maxbody", "512", "-proxy.tap.timeout", "1s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Tap = Tap{Sink: "kafka://kafka1:9092,kafka2:9092/requests", BufferSize: 100, MaxBody: 512, Timeout: time.Second}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.header.clientip", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -440,6 +721,14 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.headersanitize", "X-Forwarded-For,X-Real-Ip", "-proxy.headersanitize.trusted", "10.0.0.0/8,192.168.0.0/16"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.HeaderSanitize = []string{"X-Forwarded-For", "X-Real-Ip"}
+				cfg.Proxy.HeaderSanitizeTrusted = []string{"10.0.0.0/8", "192.168.0.0/16"}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.header.tls", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -461,6 +750,20 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.header.traceid", ""},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TraceIDHeader = ""
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.requestid.format", "hex"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.RequestIDFormat = "hex"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.header.sts.maxage", "31536000"},
 			cfg: func(cfg *Config) *Config {
@@ -482,6 +785,27 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.responseheaders", `X-Content-Type-Options=nosniff,X-Frame-Options=DENY;force=true,Content-Security-Policy="default-src 'self'; img-src *"`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ResponseHeaders = []ResponseHeader{
+					{Name: "X-Content-Type-Options", Value: "nosniff"},
+					{Name: "X-Frame-Options", Value: "DENY", Force: true},
+					{Name: "Content-Security-Policy", Value: "default-src 'self'; img-src *"},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.responseheaders", "force=true"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing header in proxy.responseheaders"),
+		},
+		{
+			args: []string{"-proxy.responseheaders", "X-Frame-Options=DENY;force=yes"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid 'force' in proxy.responseheaders: yes"),
+		},
 		{
 			args: []string{"-proxy.gzip.contenttype", `^text/.*$`},
 			cfg: func(cfg *Config) *Config {
@@ -524,6 +848,25 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.startup.failmode", "serve-degraded", "-registry.startup.timeout", "3s", "-registry.startup.routes", "/etc/fabio/routes.txt"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.StartupFailMode = "serve-degraded"
+				cfg.Registry.StartupTimeout = 3 * time.Second
+				cfg.Registry.StartupRoutes = "/etc/fabio/routes.txt"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.startup.failmode", "ignore"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.startup.failmode: ignore"),
+		},
+		{
+			args: []string{"-registry.startup.timeout", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("registry.startup.timeout must not be negative"),
+		},
 		{
 			args: []string{"-registry.file.path", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -612,6 +955,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.consul.datacenters", "dc1, dc2"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.Datacenters = []string{"dc1", "dc2"}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-registry.consul.register.enabled=false"},
 			cfg: func(cfg *Config) *Config {
@@ -675,6 +1025,27 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.kubernetes.namespace", "fabio"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Kubernetes.Namespace = "fabio"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.kubernetes.annotationprefix", "example.com/"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Kubernetes.AnnotationPrefix = "example.com/"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.kubernetes.resync", "1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Kubernetes.Resync = time.Minute
+				return cfg
+			},
+		},
 		{
 			args: []string{"-registry.custom.host", "localhost:8080"},
 			cfg: func(cfg *Config) *Config {
@@ -732,54 +1103,206 @@ func TestLoad(t *testing.T) {
 			},
 		},
 		{
-			args: []string{"-log.access.format", "foobar"},
+			args: []string{"-registry.consul.watch.waittime", "1m"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.AccessFormat = "foobar"
+				cfg.Registry.Consul.WatchWaitTime = time.Minute
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.access.target", "foobar"},
+			args: []string{"-registry.consul.watch.maxbackoff", "5s"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.AccessTarget = "foobar"
+				cfg.Registry.Consul.WatchMaxBackoff = 5 * time.Second
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.routes.format", "foobar"},
+			args: []string{"-registry.consul.watch.resyncafter", "5m"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.RoutesFormat = "foobar"
+				cfg.Registry.Consul.WatchResyncAfter = 5 * time.Minute
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.level", "foobar"},
+			args: []string{"-log.access.format", "foobar"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.Level = "foobar"
+				cfg.Log.AccessFormat = "foobar"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.target", "some-target"},
+			args: []string{"-log.access.target", "foobar"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Target = "some-target"
+				cfg.Log.AccessTarget = "foobar"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.prefix", "some-prefix"},
+			args: []string{"-proxy.expect100.enabled=true"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Prefix = "some-prefix"
+				cfg.Proxy.Expect100 = true
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.names", "some names"},
+			args: []string{"-proxy.expect100.timeout", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Expect100Timeout = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.expect100.enabled=true", "-proxy.expect100.timeout", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.expect100.timeout must be greater than zero"),
+		},
+		{
+			args: []string{"-log.access.buffersize", "1000"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessBufferSize = 1000
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.buffersize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.buffersize must not be negative"),
+		},
+		{
+			args: []string{"-log.access.overflow", "drop"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessOverflow = "drop"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.overflow", "foobar"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.access.overflow: foobar"),
+		},
+		{
+			args: []string{"-log.access.target", "otlp", "-log.access.otlp.endpoint", "http://localhost:4318/v1/logs", "-log.access.otlp.batchsize", "500", "-log.access.otlp.flushinterval", "5s", "-log.access.buffersize", "1000"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessTarget = "otlp"
+				cfg.Log.AccessBufferSize = 1000
+				cfg.Log.AccessOTLP.Endpoint = "http://localhost:4318/v1/logs"
+				cfg.Log.AccessOTLP.BatchSize = 500
+				cfg.Log.AccessOTLP.FlushInterval = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.target", "otlp"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.otlp.endpoint is required for log.access.target=otlp"),
+		},
+		{
+			args: []string{"-log.access.target", "otlp", "-log.access.otlp.endpoint", "http://localhost:4318/v1/logs"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.buffersize is required for log.access.target=otlp"),
+		},
+		{
+			args: []string{"-log.access.otlp.batchsize", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.otlp.batchsize must be positive"),
+		},
+		{
+			args: []string{"-log.access.targets", "name=a;type=file;path=/var/log/a.log,name=b;type=syslog;addr=udp://1.2.3.4:514,name=c;type=syslog;tag=c"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessTargets = map[string]AccessLogTarget{
+					"a": {Name: "a", Type: "file", Path: "/var/log/a.log"},
+					"b": {Name: "b", Type: "syslog", Network: "udp", Addr: "1.2.3.4:514", Tag: "fabio"},
+					"c": {Name: "c", Type: "syslog", Tag: "c"},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.targets", "name=a;type=file"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`missing 'path' for log target "a"`),
+		},
+		{
+			args: []string{"-log.access.targets", "name=a;type=syslog;addr=1.2.3.4:514"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid 'addr' for log target "a": 1.2.3.4:514`),
+		},
+		{
+			args: []string{"-log.access.targets", "name=a;type=stdout,name=a;type=stdout"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`duplicate name "a" in log.access.targets`),
+		},
+		{
+			args: []string{"-log.tcp.format", "$remote_addr $bytes_sent"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.TCPFormat = "$remote_addr $bytes_sent"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.tcp.target", "stdout"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.TCPTarget = "stdout"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.routes.format", "foobar"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.RoutesFormat = "foobar"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.level", "foobar"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Level = "foobar"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.target", "some-target"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Target = "some-target"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prefix", "some-prefix"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prefix = "some-prefix"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.names", "some names"},
 			cfg: func(cfg *Config) *Config {
 				cfg.Metrics.Names = "some names"
 				return cfg
 			},
 		},
+		{
+			args: []string{"-metrics.tagnames", "{{.Value}}"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.TagNames = "{{.Value}}"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.statuscodes", "code"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.StatusCodes = "code"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.exclude", "tag:internal,name:/health*", "-metrics.exclude.totals"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Exclude = "tag:internal,name:/health*"
+				cfg.Metrics.ExcludeTotals = true
+				return cfg
+			},
+		},
 		{
 			args: []string{"-metrics.interval", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -904,6 +1427,7 @@ func TestLoad(t *testing.T) {
 				cfg.UI.Listen.CertSource.CertPath = "value"
 				cfg.Registry.Consul.CheckScheme = "https"
 				cfg.Registry.Consul.ServiceAddr = ":9998"
+				cfg.Proxy.CertSources = map[string]CertSource{"ui": cfg.UI.Listen.CertSource}
 				return cfg
 			},
 		},
@@ -1058,13 +1582,79 @@ func TestLoad(t *testing.T) {
 			desc: "-proxy.addr with cert source and proto 'http' requires proto 'https', 'tcp', or 'grpcs'",
 			args: []string{"-proxy.addr", ":5555;cs=name;proto=http", "-proxy.cs", "cs=name;type=path;cert=value"},
 			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'"),
+			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'https+alpn', or 'grpcs'"),
 		},
 		{
 			desc: "-proxy.addr with cert source and proto 'tcp+sni' requires proto 'https', 'tcp' or 'grpcs'",
 			args: []string{"-proxy.addr", ":5555;cs=name;proto=tcp+sni", "-proxy.cs", "cs=name;type=path;cert=value"},
 			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'"),
+			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'https+alpn', or 'grpcs'"),
+		},
+		{
+			desc: "-proxy.addr with proto 'https+alpn' requires cert source",
+			args: []string{"-proxy.addr", ":5555;proto=https+alpn"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'https+alpn' requires cert source"),
+		},
+		{
+			desc: "-proxy.addr with alpn requires proto 'https+alpn'",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=https;alpn=h2:grpc", "-proxy.cs", "cs=name;type=path;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("alpn requires proto 'https+alpn'"),
+		},
+		{
+			desc: "-proxy.addr with invalid alpn handler",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=https+alpn;alpn=h2:tcp", "-proxy.cs", "cs=name;type=path;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("alpn should be in the form <proto>:<http|grpc>. Got: h2:tcp"),
+		},
+		{
+			desc: "-proxy.addr with negative noroutelimit",
+			args: []string{"-proxy.addr", ":5555;noroutelimit=-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("noroutelimit must not be negative"),
+		},
+		{
+			desc: "-proxy.addr with invalid pxytrust",
+			args: []string{"-proxy.addr", ":5555;pxyproto=true;pxytrust=10.0.0.1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("pxytrust should be a list of CIDR networks. Got: 10.0.0.1"),
+		},
+		{
+			desc: "-proxy.addr with invalid pxyxff",
+			args: []string{"-proxy.addr", ":5555;pxyproto=true;pxyxff=keep"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("pxyxff should be append, replace or dedup. Got: keep"),
+		},
+		{
+			desc: "-proxy.noroute.ratelimit negative",
+			args: []string{"-proxy.noroute.ratelimit", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.noroute.ratelimit must not be negative"),
+		},
+		{
+			desc: "-proxy.readheadertimeout negative",
+			args: []string{"-proxy.readheadertimeout", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.readheadertimeout must not be negative"),
+		},
+		{
+			desc: "-proxy.readbodytimeout negative",
+			args: []string{"-proxy.readbodytimeout", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.readbodytimeout must not be negative"),
+		},
+		{
+			desc: "-proxy.tls.handshaketimeout negative",
+			args: []string{"-proxy.tls.handshaketimeout", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tls.handshaketimeout must not be negative"),
+		},
+		{
+			desc: "-proxy.readbodyminrate negative",
+			args: []string{"-proxy.readbodyminrate", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.readbodyminrate must not be negative"),
 		},
 		{
 			desc: "-proxy.noroutestatus too small",
@@ -1096,6 +1686,491 @@ func TestLoad(t *testing.T) {
 			cfg:  func(cfg *Config) *Config { return nil },
 			err:  errors.New("missing 'file' in auth 'foo'"),
 		},
+		{
+			args: []string{"-proxy.requestid.format", "foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.requestid.format: foo"),
+		},
+		{
+			args: []string{"-proxy.grpc.maxconn", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.grpc.maxconn must not be negative"),
+		},
+		{
+			args: []string{"-proxy.grpc.maxconcurrentstreams", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.grpc.maxconcurrentstreams must not be negative"),
+		},
+		{
+			args: []string{"-registry.consul.watch.waittime", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("registry.consul.watch.waittime must be greater than zero"),
+		},
+		{
+			args: []string{"-registry.consul.watch.maxbackoff", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("registry.consul.watch.maxbackoff must be greater than zero"),
+		},
+		{
+			args: []string{"-proxy.tls.ticketrotation", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tls.ticketrotation must not be negative"),
+		},
+		{
+			args: []string{"-proxy.tls.maxconcurrenthandshakes", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tls.maxconcurrenthandshakes must not be negative"),
+		},
+		{
+			args: []string{"-proxy.tls.handshakequeue", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tls.handshakequeue must not be negative"),
+		},
+		{
+			args: []string{"-proxy.tls.sessioncachesize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tls.sessioncachesize must not be negative"),
+		},
+		{
+			args: []string{"-proxy.outlier.consecutive5xx", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.outlier.consecutive5xx must not be negative"),
+		},
+		{
+			args: []string{"-proxy.outlier.baseejectiontime", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.outlier.baseejectiontime must be greater than zero"),
+		},
+		{
+			args: []string{"-proxy.outlier.maxejectiontime", "10s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.outlier.maxejectiontime must not be less than proxy.outlier.baseejectiontime"),
+		},
+		{
+			args: []string{"-proxy.outlier.maxejectionpercent", "101"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.outlier.maxejectionpercent must be between 0 and 100"),
+		},
+		{
+			args: []string{"-proxy.latency.smoothing", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.latency.smoothing must be greater than 0 and at most 1"),
+		},
+		{
+			args: []string{"-proxy.headersanitize.trusted", "10.0.0.1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.headersanitize.trusted: 10.0.0.1"),
+		},
+		{
+			args: []string{"-proxy.latency.interval", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.latency.interval must be greater than zero"),
+		},
+		{
+			args: []string{"-proxy.latency.maxfactor", "0.5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.latency.minfactor must be between 0 and 1 and proxy.latency.maxfactor at least 1"),
+		},
+		{
+			args: []string{"-proxy.capture.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.capture.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.capture.samplerate", "1.5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.capture.samplerate must be between 0 and 1"),
+		},
+		{
+			args: []string{"-proxy.tap.sink", "udp://analytics:514"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tap.sink must be an http, https or kafka URL"),
+		},
+		{
+			args: []string{"-proxy.tap.buffersize", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tap.buffersize must be positive"),
+		},
+		{
+			args: []string{"-proxy.tap.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tap.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.sign.header", "X-Sig", "-proxy.sign.dateheader", "Date", "-proxy.sign.fields", "method,host,path,query,date"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.SignHeader = "X-Sig"
+				cfg.Proxy.SignDateHeader = "Date"
+				cfg.Proxy.SignFields = []string{"method", "host", "path", "query", "date"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.sign.header", ""},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.sign.header and proxy.sign.dateheader must not be empty"),
+		},
+		{
+			args: []string{"-proxy.sign.fields", "method,body"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.sign.fields: body"),
+		},
+		{
+			args: []string{"-proxy.retry.on", "connect-failure,refused-stream,goaway,reset,unexpected,503", "-proxy.retry.attempts", "2", "-proxy.retry.maxbody", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.RetryOn = []string{"connect-failure", "refused-stream", "goaway", "reset", "unexpected", "503"}
+				cfg.Proxy.RetryAttempts = 2
+				cfg.Proxy.RetryMaxBody = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.retry.on", "connect-failure,404"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.retry.on: 404"),
+		},
+		{
+			args: []string{"-proxy.retry.on", "timeout"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.retry.on: timeout"),
+		},
+		{
+			args: []string{"-proxy.retry.attempts", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.retry.attempts must not be negative"),
+		},
+		{
+			args: []string{"-proxy.retry.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.retry.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.aggregate.maxbody", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AggregateMaxBody = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.aggregate.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.aggregate.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.fallback.maxbody", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.FallbackMaxBody = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.fallback.dir", "/var/www/fallback"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.FallbackDir = "/var/www/fallback"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.buffer.maxbody", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.BufferMaxBody = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.buffer.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.buffer.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.transform.maxbody", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TransformMaxBody = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.transform.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.transform.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.fallback.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.fallback.maxbody must not be negative"),
+		},
+		{
+			args: []string{"-proxy.tenant.header", "X-Tenant", "-proxy.tenant.maxpools", "10", "-proxy.tenant.allow", "a,b"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TenantHeader = "X-Tenant"
+				cfg.Proxy.TenantMaxPools = 10
+				cfg.Proxy.TenantAllow = []string{"a", "b"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tenant.header", "X-Tenant"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tenant.allow must not be empty if proxy.tenant.header is set"),
+		},
+		{
+			args: []string{"-proxy.tenant.maxpools", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tenant.maxpools must be positive"),
+		},
+		{
+			args: []string{"-proxy.methodoverride.header", "X-HTTP-Method-Override"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MethodOverrideHeader = "X-HTTP-Method-Override"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.connect"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Connect = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxconcurrent", "100", "-proxy.maxconcurrent.queue", "50", "-proxy.maxconcurrent.timeout", "500ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxConcurrent = 100
+				cfg.Proxy.MaxConcurrentQueue = 50
+				cfg.Proxy.MaxConcurrentTimeout = 500 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.breakerfallback.cachesize", "10", "-proxy.breakerfallback.maxbody", "4096"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.BreakerCacheSize = 10
+				cfg.Proxy.BreakerCacheMaxBody = 4096
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strictframing=false"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.StrictFraming = false
+				return cfg
+			},
+		},
+		{
+			args: []string{"-admin.overrides.file", "/var/lib/fabio/overrides.json"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.OverridesFile = "/var/lib/fabio/overrides.json"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.requestfilter.enabled=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.RequestFilterEnabled = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.breakerfallback.cachesize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.breakerfallback.cachesize must not be negative"),
+		},
+		{
+			args: []string{"-proxy.maxconcurrent", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.maxconcurrent must not be negative"),
+		},
+		{
+			args: []string{"-proxy.maxconcurrent.queue", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.maxconcurrent.queue must not be negative"),
+		},
+		{
+			args: []string{"-proxy.maxconcurrent.timeout", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.maxconcurrent.timeout must be greater than zero"),
+		},
+		{
+			args: []string{"-proxy.servertiming"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ServerTiming = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.quota.file", "/var/lib/fabio/quota.json", "-proxy.quota.syncinterval", "1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.QuotaFile = "/var/lib/fabio/quota.json"
+				cfg.Proxy.QuotaSyncInterval = time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.quota.syncinterval", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.quota.syncinterval must be greater than zero"),
+		},
+		{
+			args: []string{"-proxy.autooptions"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AutoOptions = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.headersize.warn", "16384"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.HeaderSizeWarn = 16384
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.headersize.warn", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.headersize.warn must not be negative"),
+		},
+		{
+			args: []string{"-proxy.deadline.propagate"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.DeadlinePropagate = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.picker.seed", "42"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.PickerSeed = 42
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.preserveclientkeepalive=false"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.PreserveKeepAlive = false
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tcp.dialattempts", "3"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TCPDialAttempts = 3
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tcp.dialattempts", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tcp.dialattempts must be at least 1"),
+		},
+		{
+			args: []string{"-proxy.tcp.maxconnperip", "10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TCPMaxConnPerIP = 10
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tcp.maxconnperip", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tcp.maxconnperip must not be negative"),
+		},
+		{
+			args: []string{"-proxy.tcp.sni.clienthellotimeout", "3s", "-proxy.tcp.sni.maxclienthellosize", "65536"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.SNIClientHelloTimeout = 3 * time.Second
+				cfg.Proxy.SNIMaxClientHelloSize = 65536
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tcp.sni.clienthellotimeout", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tcp.sni.clienthellotimeout must not be negative"),
+		},
+		{
+			args: []string{"-proxy.tcp.sni.maxclienthellosize", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.tcp.sni.maxclienthellosize must be positive"),
+		},
+		{
+			args: []string{"-proxy.minconns.max", "10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MinConnsMax = 10
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.minconns.max", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.minconns.max must be positive"),
+		},
+		{
+			args: []string{"-routing.maxroutes", "10000"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Routing.MaxRoutes = 10000
+				return cfg
+			},
+		},
+		{
+			args: []string{"-routing.maxroutes", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("routing.maxroutes must not be negative"),
+		},
+		{
+			args: []string{"-routing.conflictmode", "reject"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Routing.ConflictMode = "reject"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-routing.conflictmode", "foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid routing.conflictmode: foo"),
+		},
+		{
+			args: []string{"-routing.trailingslash", "redirect"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Routing.TrailingSlash = "redirect"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.statuscodes", "foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid metrics.statuscodes: foo"),
+		},
+		{
+			args: []string{"-routing.trailingslash", "foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid routing.trailingslash: foo"),
+		},
+		{
+			args: []string{"-routing.geoip.database", "/path/to/country.mmdb", "-routing.geoip.header", "X-Client-Country"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Routing.GeoIP.Database = "/path/to/country.mmdb"
+				cfg.Routing.GeoIP.Header = "X-Client-Country"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-routing.geoip.header", "X-Client-Country"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("routing.geoip.header requires routing.geoip.database"),
+		},
+		{
+			args: []string{"-routing.snapshot.file", "/var/lib/fabio/routes.snapshot", "-routing.snapshot.interval", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Routing.Snapshot.File = "/var/lib/fabio/routes.snapshot"
+				cfg.Routing.Snapshot.Interval = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-routing.snapshot.interval", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("routing.snapshot.interval must be positive"),
+		},
 		{
 			args: []string{"-glob.cache.size", "1000"},
 			cfg: func(cfg *Config) *Config {
@@ -16,9 +16,8 @@ var quit = make(chan bool)
 
 // Listen registers an exit handler which is called on
 // SIGINT/SIGTERM or when Exit/Fatal/Fatalf is called.
-// SIGHUP is ignored since that is usually used for
-// triggering a reload of configuration which isn't
-// supported but shouldn't kill the process either.
+// SIGHUP does not kill the process since it is used for
+// triggering a reload. See OnReload.
 func Listen(fn func(os.Signal)) {
 	wg.Add(1)
 	go func() {
@@ -32,7 +31,9 @@ func Listen(fn func(os.Signal)) {
 			case sig = <-sigchan:
 				switch sig {
 				case syscall.SIGHUP:
-					log.Print("[INFO] Caught SIGHUP. Ignoring")
+					if !hasReload() {
+						log.Print("[INFO] Caught SIGHUP. Ignoring")
+					}
 					continue
 				case os.Interrupt:
 					log.Print("[INFO] Caught SIGINT. Exiting")
@@ -52,6 +53,43 @@ func Listen(fn func(os.Signal)) {
 	}()
 }
 
+var (
+	// reloadMu guards reloadFns which contains
+	// the handlers which are called on SIGHUP.
+	reloadMu  sync.Mutex
+	reloadFns []func()
+)
+
+// OnReload registers a handler which is called on SIGHUP.
+func OnReload(fn func()) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadFns = append(reloadFns, fn)
+	if len(reloadFns) > 1 {
+		return
+	}
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGHUP)
+	go func() {
+		for range sigchan {
+			log.Print("[INFO] Caught SIGHUP. Reloading")
+			reloadMu.Lock()
+			fns := append([]func(){}, reloadFns...)
+			reloadMu.Unlock()
+			for _, fn := range fns {
+				fn()
+			}
+		}
+	}()
+}
+
+func hasReload() bool {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return len(reloadFns) > 0
+}
+
 // stubbed out for testing
 var osExit = os.Exit
 
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestExit(t *testing.T) {
@@ -49,3 +51,24 @@ func TestExit(t *testing.T) {
 		t.Errorf("signal handlers not completed")
 	}
 }
+
+func TestOnReload(t *testing.T) {
+	called := make(chan bool, 2)
+	OnReload(func() { called <- true })
+	OnReload(func() { called <- true })
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-called:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: reload handler not called", i)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package geoip resolves client addresses to country codes
+// with a MaxMind DB like GeoLite2-Country.
+package geoip
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DB resolves ip addresses to ISO 3166-1 country codes
+// with the database in the MaxMind DB format from Path.
+type DB struct {
+	// Path is the path to the database file.
+	Path string
+
+	mu sync.RWMutex
+	r  *reader
+
+	noDB, noCountry sync.Once
+}
+
+// Reload reads the database from Path. The previous database
+// is kept if the file cannot be read.
+func (db *DB) Reload() error {
+	buf, err := ioutil.ReadFile(db.Path)
+	if err != nil {
+		return err
+	}
+	r, err := newReader(buf)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	db.r = r
+	db.mu.Unlock()
+	return nil
+}
+
+// Country returns the country code for the ip address or an empty
+// string if it cannot be resolved. Private addresses and addresses
+// which are not in the database cannot be resolved. The first failure
+// of each kind is logged.
+func (db *DB) Country(ip net.IP) string {
+	db.mu.RLock()
+	r := db.r
+	db.mu.RUnlock()
+
+	if r == nil {
+		db.noDB.Do(func() {
+			log.Printf("[WARN] geoip: No database loaded from %s. Using default routing", db.Path)
+		})
+		return ""
+	}
+
+	var country string
+	v, err := r.lookup(ip)
+	if err == nil {
+		country = countryCode(v)
+	}
+	if country == "" {
+		db.noCountry.Do(func() {
+			if err != nil {
+				log.Printf("[WARN] geoip: Cannot resolve country of %s. %s. Using default routing. Further failures are not logged", ip, err)
+				return
+			}
+			log.Printf("[WARN] geoip: Cannot resolve country of %s. Using default routing. Further failures are not logged", ip)
+		})
+	}
+	return country
+}
+
+// RequestCountry returns the country code of the remote address
+// of the request or an empty string if it cannot be resolved.
+func (db *DB) RequestCountry(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return db.Country(ip)
+}
+
+// countryCode returns the iso code of the country or the registered
+// country of a GeoIP2 or GeoLite2 record.
+func countryCode(v interface{}) string {
+	m, _ := v.(map[string]interface{})
+	for _, k := range []string{"country", "registered_country"} {
+		c, _ := m[k].(map[string]interface{})
+		if code, _ := c["iso_code"].(string); code != "" {
+			return strings.ToUpper(code)
+		}
+	}
+	return ""
+}
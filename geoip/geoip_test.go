@@ -0,0 +1,226 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDB builds a MaxMind DB with the given ip version and record size
+// which maps the networks to records with the country code.
+func testDB(t *testing.T, ipVersion, recordSize int, networks map[string]string) []byte {
+	t.Helper()
+
+	type node struct {
+		child [2]*node
+		data  int // offset in the data section + 1 for leaves
+	}
+
+	// the data section contains one record per country. The
+	// records after the first one refer to the "country" key
+	// of the first record with a pointer.
+	var data bytes.Buffer
+	offsets := map[string]int{}
+	keyOff := -1
+	for _, country := range networks {
+		if _, ok := offsets[country]; ok {
+			continue
+		}
+		offsets[country] = data.Len()
+		data.WriteByte(7<<5 | 1) // map with one entry
+		if keyOff < 0 {
+			keyOff = data.Len()
+			writeString(&data, "country")
+		} else {
+			data.Write([]byte{1 << 5, byte(keyOff)}) // pointer
+		}
+		data.WriteByte(7<<5 | 1)
+		writeString(&data, "iso_code")
+		writeString(&data, country)
+	}
+
+	root := &node{}
+	for cidr, country := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := n.IP
+		ones, _ := n.Mask.Size()
+		if ip4 := ip.To4(); ip4 != nil && ipVersion == 6 {
+			ip = append(make(net.IP, 12), ip4...)
+			ones += 96
+		}
+		p := root
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if p.child[bit] == nil {
+				p.child[bit] = &node{}
+			}
+			p = p.child[bit]
+		}
+		p.data = offsets[country] + 1
+	}
+
+	// number the inner nodes in breadth first order
+	var nodes []*node
+	index := map[*node]int{}
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		p := queue[0]
+		index[p] = len(nodes)
+		nodes = append(nodes, p)
+		for _, c := range p.child {
+			if c != nil && c.data == 0 {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	var tree bytes.Buffer
+	nodeCount := len(nodes)
+	for _, p := range nodes {
+		var rec [2]uint32
+		for i, c := range p.child {
+			switch {
+			case c == nil:
+				rec[i] = uint32(nodeCount)
+			case c.data > 0:
+				rec[i] = uint32(nodeCount + 16 + c.data - 1)
+			default:
+				rec[i] = uint32(index[c])
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(rec[0] >> 16), byte(rec[0] >> 8), byte(rec[0]), byte(rec[1] >> 16), byte(rec[1] >> 8), byte(rec[1])})
+		case 28:
+			tree.Write([]byte{byte(rec[0] >> 16), byte(rec[0] >> 8), byte(rec[0]), byte(rec[0]>>20)&0xF0 | byte(rec[1]>>24)&0x0F, byte(rec[1] >> 16), byte(rec[1] >> 8), byte(rec[1])})
+		case 32:
+			binary.Write(&tree, binary.BigEndian, rec)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(tree.Bytes())
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.Write(metadataMarker)
+	buf.WriteByte(7<<5 | 4)
+	writeString(&buf, "node_count")
+	buf.WriteByte(6<<5 | 4)
+	binary.Write(&buf, binary.BigEndian, uint32(nodeCount))
+	writeString(&buf, "record_size")
+	buf.WriteByte(5<<5 | 2)
+	binary.Write(&buf, binary.BigEndian, uint16(recordSize))
+	writeString(&buf, "ip_version")
+	buf.WriteByte(5<<5 | 2)
+	binary.Write(&buf, binary.BigEndian, uint16(ipVersion))
+	writeString(&buf, "database_type")
+	writeString(&buf, "Test-Country")
+	return buf.Bytes()
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	b.WriteByte(2<<5 | byte(len(s)))
+	b.WriteString(s)
+}
+
+var testNetworks = map[string]string{
+	"1.2.3.0/24":    "DE",
+	"5.6.0.0/16":    "fr",
+	"2001:db8::/32": "IT",
+}
+
+func TestDBCountry(t *testing.T) {
+	tests := []struct {
+		ip, country string
+	}{
+		{"1.2.3.4", "DE"},
+		{"1.2.4.4", ""},
+		{"5.6.7.8", "FR"},
+		{"10.0.0.1", ""},
+		{"2001:db8::1", "IT"},
+		{"2001:db9::1", ""},
+	}
+
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			networks := map[string]string{}
+			for k, v := range testNetworks {
+				if ipVersion == 4 && net.ParseIP(k[:len(k)-3]).To4() == nil {
+					continue
+				}
+				networks[k] = v
+			}
+			r, err := newReader(testDB(t, ipVersion, recordSize, networks))
+			if err != nil {
+				t.Fatalf("ipv%d/%d: %s", ipVersion, recordSize, err)
+			}
+			db := &DB{r: r}
+			for _, tt := range tests {
+				want := tt.country
+				if ipVersion == 4 && tt.ip == "2001:db8::1" {
+					want = ""
+				}
+				if got := db.Country(net.ParseIP(tt.ip)); got != want {
+					t.Errorf("ipv%d/%d: %s: got %q want %q", ipVersion, recordSize, tt.ip, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDBReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "country.mmdb")
+	db := &DB{Path: path}
+	req := &http.Request{RemoteAddr: "1.2.3.4:5678"}
+
+	// a missing database resolves nothing
+	if err := db.Reload(); err == nil {
+		t.Fatal("expected error for missing database")
+	}
+	if got := db.RequestCountry(req); got != "" {
+		t.Fatalf("got %q want empty country without database", got)
+	}
+
+	write := func(networks map[string]string) {
+		if err := ioutil.WriteFile(path, testDB(t, 6, 24, networks), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(map[string]string{"1.2.3.0/24": "DE"})
+	if got, want := db.RequestCountry(req), "DE"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	write(map[string]string{"1.2.3.0/24": "AT"})
+	if got, want := db.RequestCountry(req), "AT"; got != want {
+		t.Fatalf("got %q want %q after reload", got, want)
+	}
+
+	// an invalid database keeps the previous one
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err == nil {
+		t.Fatal("expected error for invalid database")
+	}
+	if got, want := db.RequestCountry(req), "AT"; got != want {
+		t.Fatalf("got %q want %q after failed reload", got, want)
+	}
+}
@@ -0,0 +1,296 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// metadataMarker separates the search tree and the data section
+// from the metadata at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// reader is a minimal reader for the MaxMind DB format which is
+// described on https://maxmind.github.io/MaxMind-DB/
+type reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// newReader parses the metadata of the database in buf.
+func newReader(buf []byte) (*reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("invalid database: metadata not found")
+	}
+	meta := buf[i+len(metadataMarker):]
+	v, _, err := decode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %s", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+
+	r := &reader{
+		buf:        buf,
+		nodeCount:  uint(toUint(m["node_count"])),
+		recordSize: uint(toUint(m["record_size"])),
+		ipVersion:  uint(toUint(m["ip_version"])),
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("invalid database: search tree exceeds file size")
+	}
+	r.data = buf[treeSize+16 : i]
+
+	// IPv4 addresses are stored in the ::/96 subtree of IPv6 databases
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.nodeCount; j++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// lookup returns the record for the ip address or nil if there is none.
+func (r *reader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = r.record(node, bit)
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	off := node - r.nodeCount - 16
+	if off >= uint(len(r.data)) {
+		return nil, errors.New("invalid database: record pointer out of range")
+	}
+	v, _, err := decode(r.data, off)
+	return v, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of the node.
+func (r *reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b := r.buf[node*8+bit*4:]
+		return uint(binary.BigEndian.Uint32(b))
+	}
+}
+
+// data types of the MaxMind DB format
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+var errTruncated = errors.New("unexpected end of data")
+
+// decode decodes the value at offset off of the data section and
+// returns it with the offset of the next value. Maps are returned as
+// map[string]interface{}, arrays as []interface{} and unsigned integers
+// as uint64.
+func decode(data []byte, off uint) (interface{}, uint, error) {
+	if off >= uint(len(data)) {
+		return nil, 0, errTruncated
+	}
+	ctrl := data[off]
+	off++
+
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		p, next, err := decodePointer(data, ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := decode(data, p)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		if off >= uint(len(data)) {
+			return nil, 0, errTruncated
+		}
+		typ = 7 + uint(data[off])
+		off++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > uint(len(data)) {
+			return nil, 0, errTruncated
+		}
+		v := uint(0)
+		for _, b := range data[off : off+n] {
+			v = v<<8 | uint(b)
+		}
+		off += n
+		switch n {
+		case 1:
+			size = 29 + v
+		case 2:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := decode(data, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			v, next, err := decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := decode(data, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+
+	case typeBool:
+		return size != 0, off, nil
+	}
+
+	if off+size > uint(len(data)) {
+		return nil, 0, errTruncated
+	}
+	b := data[off : off+size]
+	off += size
+
+	switch typ {
+	case typeString:
+		return string(b), off, nil
+	case typeBytes:
+		return append([]byte(nil), b...), off, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), off, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if typ == typeInt32 {
+			return int64(int32(v)), off, nil
+		}
+		return v, off, nil
+	case typeUint128:
+		// not used for country lookups
+		return append([]byte(nil), b...), off, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+}
+
+// decodePointer returns the offset a pointer refers to
+// and the offset of the next value.
+func decodePointer(data []byte, ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&3 + 1
+	if off+n > uint(len(data)) {
+		return 0, 0, errTruncated
+	}
+	v := uint(0)
+	if n < 4 {
+		v = uint(ctrl & 7)
+	}
+	for _, b := range data[off : off+n] {
+		v = v<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, off + n, nil
+}
+
+// toUint returns the value of an unsigned integer or 0.
+func toUint(v interface{}) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
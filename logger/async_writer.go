@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// ErrClosed is returned when writing to a closed AsyncWriter.
+var ErrClosed = errors.New("writer closed")
+
+// AsyncWriter is an io.Writer which decouples the caller from the
+// underlying writer. Writes are queued in a bounded buffer and are
+// written to the underlying writer by a separate goroutine. When the
+// buffer is full the write either blocks or the data is dropped.
+type AsyncWriter struct {
+	w    io.Writer
+	drop bool
+
+	// dropped counts the writes which were dropped since the buffer
+	// was full.
+	dropped int64
+
+	// Dropped is updated for every dropped write if not nil.
+	Dropped metrics.Counter
+
+	mu     sync.RWMutex
+	closed bool
+	ch     chan []byte
+	done   chan struct{}
+}
+
+// NewAsyncWriter creates a new writer with a buffer for size writes
+// which writes to w. If drop is true writes are dropped when the
+// buffer is full. Otherwise, they block until there is space in the
+// buffer.
+func NewAsyncWriter(w io.Writer, size int, drop bool) *AsyncWriter {
+	aw := &AsyncWriter{
+		w:    w,
+		drop: drop,
+		ch:   make(chan []byte, size),
+		done: make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	for b := range w.ch {
+		w.w.Write(b)
+	}
+}
+
+// Write queues a copy of b for writing. It never returns an error for
+// dropped writes since they are counted separately.
+func (w *AsyncWriter) Write(b []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	p := make([]byte, len(b))
+	copy(p, b)
+
+	if !w.drop {
+		w.ch <- p
+		return len(b), nil
+	}
+
+	select {
+	case w.ch <- p:
+	default:
+		atomic.AddInt64(&w.dropped, 1)
+		if w.Dropped != nil {
+			w.Dropped.Inc(1)
+		}
+	}
+	return len(b), nil
+}
+
+// DroppedCount returns the number of dropped writes.
+func (w *AsyncWriter) DroppedCount() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// Close stops accepting new writes and waits until all
+// buffered writes have been written to the underlying writer.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.mu.Unlock()
+	<-w.done
+	return nil
+}
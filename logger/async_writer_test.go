@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// blockingWriter blocks all writes until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(b)
+}
+
+func TestAsyncWriterFlushOnClose(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	aw := NewAsyncWriter(w, 10, false)
+	for _, s := range []string{"a", "b", "c"} {
+		if _, err := aw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(w.release)
+	aw.Close()
+
+	if got, want := w.buf.String(), "abc"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if _, err := aw.Write([]byte("d")); err != ErrClosed {
+		t.Fatalf("got %v want %v", err, ErrClosed)
+	}
+}
+
+func TestAsyncWriterDrop(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	aw := NewAsyncWriter(w, 1, true)
+
+	// the first write is picked up by the writer goroutine or stays in
+	// the buffer. Either way, at most two writes are accepted and the
+	// remaining ones are dropped.
+	for i := 0; i < 5; i++ {
+		aw.Write([]byte("x"))
+	}
+	if got := aw.DroppedCount(); got < 3 {
+		t.Fatalf("got %d dropped writes want at least 3", got)
+	}
+	close(w.release)
+	aw.Close()
+
+	if got, want := int64(w.buf.Len())+aw.DroppedCount(), int64(5); got != want {
+		t.Fatalf("got %d written and dropped writes want %d", got, want)
+	}
+}
@@ -5,6 +5,11 @@
 // takes place. Text between two fields is printed verbatim. See the common
 // log file formats for an example.
 //
+//   $bytes_received          - bytes received from the client (TCP only)
+//   $bytes_sent              - bytes sent to the client (TCP only)
+//   $duration_ms             - connection duration in S.sss format (alias for $response_time_ms)
+//   $duration_us             - connection duration in S.ssssss format (alias for $response_time_us)
+//   $duration_ns             - connection duration in S.sssssssss format (alias for $response_time_ns)
 //   $header.<name>           - request http header (name: [a-zA-Z0-9-]+)
 //   $remote_addr             - host:port of remote client
 //   $remote_host             - host of remote client
@@ -12,6 +17,7 @@
 //   $request                 - request <method> <uri> <proto>
 //   $request_args            - request query parameters
 //   $request_host            - request host header (aka server name)
+//   $request_id              - request id (see proxy.header.requestid)
 //   $request_method          - request method
 //   $request_scheme          - request scheme
 //   $request_uri             - request URI
@@ -22,6 +28,7 @@
 //   $response_time_ms        - response time in S.sss format
 //   $response_time_us        - response time in S.ssssss format
 //   $response_time_ns        - response time in S.sssssssss format
+//   $server_name             - TLS server name (SNI) of the client (TCP+SNI only)
 //   $time_rfc3339            - log timestamp in YYYY-MM-DDTHH:MM:SSZ format
 //   $time_rfc3339_ms         - log timestamp in YYYY-MM-DDTHH:MM:SS.sssZ format
 //   $time_rfc3339_us         - log timestamp in YYYY-MM-DDTHH:MM:SS.ssssssZ format
@@ -30,12 +37,15 @@
 //   $time_unix_us            - log timestamp in unix epoch us
 //   $time_unix_ns            - log timestamp in unix epoch ns
 //   $time_common             - log timestamp in DD/MMM/YYYY:HH:MM:SS -ZZZZ
+//   $trace_id                - trace id (see proxy.header.traceid)
 //   $upstream_addr           - host:port of upstream server
 //   $upstream_host           - host of upstream server
 //   $upstream_port           - port of upstream server
 //   $upstream_request_scheme - upstream request scheme
 //   $upstream_request_uri    - upstream request URI
 //   $upstream_request_url    - upstream request URL
+//   $upstream_reset          - true if the upstream server reset the connection during the response
+//   $upstream_service        - name of the upstream service
 //
 package logger
 
@@ -86,6 +96,37 @@ type Event struct {
 	// UpstreamURL is the URL which was sent to the upstream server.
 	// It should only be set for HTTP log events.
 	UpstreamURL *url.URL
+
+	// UpstreamReset is true if the upstream server closed the connection
+	// after the response headers were sent and the response is truncated.
+	UpstreamReset bool
+
+	// LogTarget is the name of the log destination of the route.
+	// The event is written to the default log if it is empty.
+	// See Targets.
+	LogTarget string
+
+	// RequestID is the unique id of the request.
+	// It should only be set for HTTP log events.
+	RequestID string
+
+	// TraceID is the trace id of the request which is also sent in
+	// the error responses. It should only be set for HTTP log events.
+	TraceID string
+
+	// RemoteAddr is the address in the form of "host:port" of the
+	// client. It should only be set for TCP log events since the
+	// address of HTTP clients is taken from the request.
+	RemoteAddr string
+
+	// ServerName is the TLS server name (SNI) which the client sent.
+	// It should only be set for TCP log events.
+	ServerName string
+
+	// BytesReceived and BytesSent are the number of bytes which were
+	// received from and sent to the client. They should only be set
+	// for TCP log events.
+	BytesReceived, BytesSent int64
 }
 
 // Logger logs an event.
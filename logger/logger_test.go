@@ -83,6 +83,8 @@ func TestLog(t *testing.T) {
 		UpstreamAddr:    uurl.Host,
 		UpstreamService: "svc-a",
 		UpstreamURL:     uurl,
+		RequestID:       "f47ac10b-58cc-0372-8567-0e02b2c3d479",
+		TraceID:         "4bf92f3577b34da6a3ce929d0e0e4736",
 	}
 
 	tests := []struct {
@@ -98,6 +100,7 @@ func TestLog(t *testing.T) {
 		{"$request", "GET /?q=x HTTP/1.1\n"},
 		{"$request_args", "q=x\n"},
 		{"$request_host", "foo.com\n"}, // TODO(fs): is this correct?
+		{"$request_id", "f47ac10b-58cc-0372-8567-0e02b2c3d479\n"},
 		{"$request_method", "GET\n"},
 		{"$request_proto", "HTTP/1.1\n"},
 		{"$request_scheme", "http\n"},
@@ -116,12 +119,14 @@ func TestLog(t *testing.T) {
 		{"$time_unix_ms", "1451606400123\n"},
 		{"$time_unix_ns", "1451606400123456789\n"},
 		{"$time_unix_us", "1451606400123456\n"},
+		{"$trace_id", "4bf92f3577b34da6a3ce929d0e0e4736\n"},
 		{"$upstream_addr", "7.8.9.0:5678\n"},
 		{"$upstream_host", "7.8.9.0\n"},
 		{"$upstream_port", "5678\n"},
 		{"$upstream_request_scheme", "http\n"},
 		{"$upstream_request_uri", "/foo?q=x\n"},
 		{"$upstream_request_url", "http://7.8.9.0:5678/foo?q=x\n"},
+		{"$upstream_reset", "false\n"},
 		{"$upstream_service", "svc-a\n"},
 	}
 
@@ -142,6 +147,48 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func TestLogTCP(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Event{
+		Start:           start,
+		End:             start.Add(1500 * time.Millisecond),
+		RemoteAddr:      "2.2.2.2:666",
+		ServerName:      "foo.com",
+		BytesReceived:   123,
+		BytesSent:       4567,
+		UpstreamAddr:    "7.8.9.0:5678",
+		UpstreamService: "svc-a",
+	}
+
+	tests := []struct {
+		format string
+		out    string
+	}{
+		{"$remote_addr $remote_host $remote_port", "2.2.2.2:666 2.2.2.2 666\n"},
+		{"$server_name", "foo.com\n"},
+		{"$bytes_received $bytes_sent", "123 4567\n"},
+		{"$duration_ms", "1.500\n"},
+		{"$upstream_service $upstream_addr", "svc-a 7.8.9.0:5678\n"},
+		{CommonFormat, "2.2.2.2 - - [01/Jan/2016:00:00:01 +0000] \"\"  \n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			b := new(bytes.Buffer)
+
+			l, err := New(b, tt.format)
+			if err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+
+			l.Log(e)
+			if got, want := string(b.Bytes()), tt.out; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestAtoi(t *testing.T) {
 	tests := []struct {
 		i   int64
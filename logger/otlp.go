@@ -0,0 +1,308 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewOTLPLogger creates a logger which writes every event as an
+// OpenTelemetry log record in the OTLP/JSON encoding to w. w is usually
+// an OTLPExporter behind an AsyncWriter. The attributes of the record
+// are the fields of the access log format without the '$' prefix except
+// for the time fields, which are the timestamp of the record, the
+// $request field, which is the body of the record, and the variants of
+// $response_time_ns. Empty fields are omitted. The trace and span id of
+// the request are attached if the request carries B3 or W3C trace
+// context headers which fabio adds when tracing is enabled.
+func NewOTLPLogger(w io.Writer) Logger {
+	if w == nil {
+		return &noopLogger{}
+	}
+	return &otlpLogger{w: w}
+}
+
+type otlpLogger struct {
+	w io.Writer
+}
+
+func (l *otlpLogger) Log(e *Event) {
+	b, err := json.Marshal(otlpRecord(e))
+	if err != nil {
+		log.Print("[ERROR] Cannot encode access log record. ", err)
+		return
+	}
+	l.w.Write(b)
+}
+
+// otlpLogRecord is the JSON encoding of an OTLP LogRecord.
+type otlpLogRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber"`
+	SeverityText         string          `json:"severityText"`
+	Body                 otlpValue       `json:"body"`
+	Attributes           []otlpAttribute `json:"attributes,omitempty"`
+	TraceID              string          `json:"traceId,omitempty"`
+	SpanID               string          `json:"spanId,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is the JSON encoding of an OTLP AnyValue. 64 bit integers
+// are encoded as strings.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+// severityInfo is the OTLP severity number of INFO.
+const severityInfo = 9
+
+func otlpRecord(e *Event) *otlpLogRecord {
+	r := &otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(e.End.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber:       severityInfo,
+		SeverityText:         "INFO",
+	}
+
+	var attrs []otlpAttribute
+	str := func(k, v string) {
+		if v != "" {
+			attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{StringValue: &v}})
+		}
+	}
+	num := func(k string, n int64) {
+		v := strconv.FormatInt(n, 10)
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{IntValue: &v}})
+	}
+
+	var body string
+	addr := remoteAddr(e)
+	host, port := hostport(addr)
+	str("remote_addr", addr)
+	str("remote_host", host)
+	str("remote_port", port)
+	if req := e.Request; req != nil {
+		body = req.Method + " " + req.RequestURI + " " + req.Proto
+		str("request_method", req.Method)
+		str("request_uri", req.RequestURI)
+		str("request_proto", req.Proto)
+		str("request_host", req.Host)
+		if e.RequestURL != nil {
+			str("request_scheme", e.RequestURL.Scheme)
+			str("request_url", e.RequestURL.String())
+			str("request_args", e.RequestURL.RawQuery)
+		}
+		str("request_id", e.RequestID)
+		str("trace_id", e.TraceID)
+		r.TraceID, r.SpanID = traceContext(req.Header)
+		if tid := e.TraceID; r.TraceID == "" && tid != "" {
+			if len(tid) == 16 {
+				tid = "0000000000000000" + tid
+			}
+			if isHex(tid, 32) {
+				r.TraceID = tid
+			}
+		}
+	} else {
+		body = "TCP " + e.RemoteAddr
+		str("server_name", e.ServerName)
+		num("bytes_received", e.BytesReceived)
+		num("bytes_sent", e.BytesSent)
+	}
+	if resp := e.Response; resp != nil {
+		body += " " + strconv.Itoa(resp.StatusCode)
+		num("response_status", int64(resp.StatusCode))
+		num("response_body_size", resp.ContentLength)
+	}
+	num("response_time_ns", int64(e.End.Sub(e.Start)))
+	uhost, uport := hostport(e.UpstreamAddr)
+	str("upstream_addr", e.UpstreamAddr)
+	str("upstream_host", uhost)
+	str("upstream_port", uport)
+	str("upstream_service", e.UpstreamService)
+	if e.UpstreamURL != nil {
+		str("upstream_request_scheme", e.UpstreamURL.Scheme)
+		str("upstream_request_uri", e.UpstreamURL.RequestURI())
+		str("upstream_request_url", e.UpstreamURL.String())
+	}
+	if e.Request != nil {
+		reset := e.UpstreamReset
+		attrs = append(attrs, otlpAttribute{Key: "upstream_reset", Value: otlpValue{BoolValue: &reset}})
+	}
+
+	r.Body = otlpValue{StringValue: &body}
+	r.Attributes = attrs
+	return r
+}
+
+// traceContext returns the trace id and the span id of the B3 or W3C
+// trace context headers as hex strings. 64 bit trace ids are padded to
+// 128 bit. It returns empty strings if there is no valid trace context.
+func traceContext(h http.Header) (traceID, spanID string) {
+	if tid, sid := h.Get("X-B3-Traceid"), h.Get("X-B3-Spanid"); tid != "" && sid != "" {
+		if len(tid) == 16 {
+			tid = "0000000000000000" + tid
+		}
+		if isHex(tid, 32) && isHex(sid, 16) {
+			return strings.ToLower(tid), strings.ToLower(sid)
+		}
+		return "", ""
+	}
+	// traceparent: <version>-<trace-id>-<parent-id>-<flags>
+	p := strings.Split(h.Get("Traceparent"), "-")
+	if len(p) == 4 && isHex(p[1], 32) && isHex(p[2], 16) {
+		return strings.ToLower(p[1]), strings.ToLower(p[2])
+	}
+	return "", ""
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// OTLPExporter is an io.Writer which sends the log records of an
+// OTLP logger in batches to the OTLP/HTTP logs endpoint of a collector,
+// e.g. http://localhost:4318/v1/logs. A batch is sent when it contains
+// BatchSize records or when FlushInterval has passed since the first
+// record of the batch. Batches which cannot be sent are dropped and
+// logged since the access log must not block the proxy. Write sends a
+// full batch before it returns and should therefore be called from an
+// AsyncWriter.
+type OTLPExporter struct {
+	// Endpoint is the URL of the OTLP/HTTP logs endpoint.
+	Endpoint string
+
+	// BatchSize is the maximum number of records per request.
+	BatchSize int
+
+	// FlushInterval is the maximum time a record waits for the
+	// batch to be sent.
+	FlushInterval time.Duration
+
+	// Client sends the requests. http.DefaultClient is used if nil.
+	Client *http.Client
+
+	mu    sync.Mutex
+	batch []json.RawMessage
+	timer *time.Timer
+}
+
+// Write adds the encoded log record b to the current batch.
+func (x *OTLPExporter) Write(b []byte) (int, error) {
+	rec := make(json.RawMessage, len(b))
+	copy(rec, b)
+
+	x.mu.Lock()
+	x.batch = append(x.batch, rec)
+	var batch []json.RawMessage
+	switch {
+	case len(x.batch) >= x.BatchSize:
+		batch = x.take()
+	case len(x.batch) == 1 && x.FlushInterval > 0:
+		x.timer = time.AfterFunc(x.FlushInterval, x.flush)
+	}
+	x.mu.Unlock()
+
+	if batch != nil {
+		x.send(batch)
+	}
+	return len(b), nil
+}
+
+// Close sends the pending records.
+func (x *OTLPExporter) Close() error {
+	x.flush()
+	return nil
+}
+
+func (x *OTLPExporter) flush() {
+	x.mu.Lock()
+	batch := x.take()
+	x.mu.Unlock()
+	if batch != nil {
+		x.send(batch)
+	}
+}
+
+// take returns the current batch and starts a new one.
+// The caller must hold the lock.
+func (x *OTLPExporter) take() []json.RawMessage {
+	if x.timer != nil {
+		x.timer.Stop()
+		x.timer = nil
+	}
+	batch := x.batch
+	x.batch = nil
+	return batch
+}
+
+func (x *OTLPExporter) send(batch []json.RawMessage) {
+	if err := x.post(batch); err != nil {
+		log.Printf("[WARN] Dropped %d access log records. Cannot send them to %s. %s", len(batch), x.Endpoint, err)
+	}
+}
+
+func (x *OTLPExporter) post(batch []json.RawMessage) error {
+	host, _ := hostname()
+	body, err := json.Marshal(otlpLogsRequest(batch, host))
+	if err != nil {
+		return err
+	}
+	client := x.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(x.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpLogsRequest returns the JSON encoding of an OTLP
+// ExportLogsServiceRequest with the records.
+func otlpLogsRequest(records []json.RawMessage, host string) interface{} {
+	type kv = map[string]interface{}
+	attrs := []kv{{"key": "service.name", "value": kv{"stringValue": "fabio"}}}
+	if host != "" {
+		attrs = append(attrs, kv{"key": "host.name", "value": kv{"stringValue": host}})
+	}
+	return kv{
+		"resourceLogs": []kv{{
+			"resource": kv{"attributes": attrs},
+			"scopeLogs": []kv{{
+				"scope":      kv{"name": "fabio/accesslog"},
+				"logRecords": records,
+			}},
+		}},
+	}
+}
+
+// hostname is stubbed out for testing.
+var hostname = os.Hostname
@@ -0,0 +1,184 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPLogger(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Event{
+		Start: start,
+		End:   start.Add(123 * time.Millisecond),
+		Request: &http.Request{
+			Method:     "GET",
+			RequestURI: "/foo?x=y",
+			Proto:      "HTTP/1.1",
+			Host:       "example.com",
+			RemoteAddr: "2.2.2.2:666",
+			Header: http.Header{
+				"X-B3-Traceid": {"463ac35c9f6413ad"},
+				"X-B3-Spanid":  {"A2FB4A1D1A96D312"},
+			},
+		},
+		Response:        &http.Response{StatusCode: 200, ContentLength: 1234},
+		RequestURL:      &url.URL{Scheme: "https", Host: "example.com", Path: "/foo", RawQuery: "x=y"},
+		UpstreamAddr:    "5.6.7.8:1234",
+		UpstreamService: "svc-a",
+		RequestID:       "abc",
+	}
+
+	var buf bytes.Buffer
+	NewOTLPLogger(&buf).Log(e)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	delete(got, "observedTimeUnixNano")
+
+	str := func(k, v string) map[string]interface{} {
+		return map[string]interface{}{"key": k, "value": map[string]interface{}{"stringValue": v}}
+	}
+	num := func(k, v string) map[string]interface{} {
+		return map[string]interface{}{"key": k, "value": map[string]interface{}{"intValue": v}}
+	}
+	want := map[string]interface{}{
+		"timeUnixNano":   "1451606400123000000",
+		"severityNumber": 9.0,
+		"severityText":   "INFO",
+		"body":           map[string]interface{}{"stringValue": "GET /foo?x=y HTTP/1.1 200"},
+		"traceId":        "0000000000000000463ac35c9f6413ad",
+		"spanId":         "a2fb4a1d1a96d312",
+		"attributes": []interface{}{
+			str("remote_addr", "2.2.2.2:666"),
+			str("remote_host", "2.2.2.2"),
+			str("remote_port", "666"),
+			str("request_method", "GET"),
+			str("request_uri", "/foo?x=y"),
+			str("request_proto", "HTTP/1.1"),
+			str("request_host", "example.com"),
+			str("request_scheme", "https"),
+			str("request_url", "https://example.com/foo?x=y"),
+			str("request_args", "x=y"),
+			str("request_id", "abc"),
+			num("response_status", "200"),
+			num("response_body_size", "1234"),
+			num("response_time_ns", "123000000"),
+			str("upstream_addr", "5.6.7.8:1234"),
+			str("upstream_host", "5.6.7.8"),
+			str("upstream_port", "1234"),
+			str("upstream_service", "svc-a"),
+			map[string]interface{}{"key": "upstream_reset", "value": map[string]interface{}{"boolValue": false}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+// TestOTLPAttributes checks that the records have an attribute for
+// every field of the access log format except for the time fields,
+// the $request field and the variants of $response_time_ns.
+func TestOTLPAttributes(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []*Event{
+		{
+			Start:           start,
+			End:             start.Add(time.Second),
+			Request:         &http.Request{Method: "GET", RequestURI: "/foo?x=y", Proto: "HTTP/1.1", Host: "example.com", RemoteAddr: "2.2.2.2:666"},
+			Response:        &http.Response{StatusCode: 200},
+			RequestURL:      &url.URL{Scheme: "http", Host: "example.com", Path: "/foo", RawQuery: "x=y"},
+			UpstreamAddr:    "5.6.7.8:1234",
+			UpstreamService: "svc-a",
+			UpstreamURL:     &url.URL{Scheme: "http", Host: "5.6.7.8:1234", Path: "/foo"},
+			RequestID:       "abc",
+			TraceID:         "463ac35c9f6413ad",
+		},
+		{
+			Start:        start,
+			End:          start.Add(time.Second),
+			RemoteAddr:   "2.2.2.2:666",
+			ServerName:   "example.com",
+			UpstreamAddr: "5.6.7.8:1234",
+		},
+	}
+
+	got := map[string]bool{}
+	for _, e := range events {
+		for _, a := range otlpRecord(e).Attributes {
+			got["$"+a.Key] = true
+		}
+	}
+
+	for _, f := range Fields {
+		skip := strings.HasPrefix(f, "$time_") || strings.HasPrefix(f, "$duration_") ||
+			f == "$request" || f == "$response_time_ms" || f == "$response_time_us"
+		if got[f] == skip {
+			t.Errorf("%s: got attribute %v want %v", f, got[f], !skip)
+		}
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	tests := []struct {
+		desc          string
+		h             http.Header
+		trace, spanID string
+	}{
+		{"none", http.Header{}, "", ""},
+		{"b3 128 bit", http.Header{"X-B3-Traceid": {"80f198ee56343ba864fe8b2a57d3eff7"}, "X-B3-Spanid": {"e457b5a2e4d86bd1"}}, "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{"b3 invalid", http.Header{"X-B3-Traceid": {"xyz"}, "X-B3-Spanid": {"e457b5a2e4d86bd1"}}, "", ""},
+		{"traceparent", http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"traceparent invalid", http.Header{"Traceparent": {"00-4bf92f35-00f067aa0ba902b7-01"}}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			trace, span := traceContext(tt.h)
+			if trace != tt.trace || span != tt.spanID {
+				t.Fatalf("got %q %q want %q %q", trace, span, tt.trace, tt.spanID)
+			}
+		})
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceLogs []struct {
+				ScopeLogs []struct {
+					LogRecords []json.RawMessage `json:"logRecords"`
+				} `json:"scopeLogs"`
+			} `json:"resourceLogs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		batches = append(batches, len(req.ResourceLogs[0].ScopeLogs[0].LogRecords))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	x := &OTLPExporter{Endpoint: srv.URL, BatchSize: 2, FlushInterval: time.Hour}
+	for i := 0; i < 5; i++ {
+		x.Write([]byte(`{"body":{"stringValue":"x"}}`))
+	}
+	x.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := batches, []int{2, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got batches %v want %v", got, want)
+	}
+}
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 func init() {
+	// the connection duration of TCP events
+	fields["$duration_ms"] = fields["$response_time_ms"]
+	fields["$duration_us"] = fields["$response_time_us"]
+	fields["$duration_ns"] = fields["$response_time_ns"]
+
 	for f := range fields {
 		Fields = append(Fields, f)
 	}
@@ -40,24 +46,21 @@ type field func(b *bytes.Buffer, e *Event)
 // of strconv.Atoi/FormatInt() use the local atoi() function which does not
 // alloc.
 var fields = map[string]field{
+	"$bytes_received": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.BytesReceived, 0)
+	},
+	"$bytes_sent": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.BytesSent, 0)
+	},
 	"$remote_addr": func(b *bytes.Buffer, e *Event) {
-		if e.Request == nil {
-			return
-		}
-		b.WriteString(e.Request.RemoteAddr)
+		b.WriteString(remoteAddr(e))
 	},
 	"$remote_host": func(b *bytes.Buffer, e *Event) {
-		if e.Request == nil {
-			return
-		}
-		host, _ := hostport(e.Request.RemoteAddr)
+		host, _ := hostport(remoteAddr(e))
 		b.WriteString(host)
 	},
 	"$remote_port": func(b *bytes.Buffer, e *Event) {
-		if e.Request == nil {
-			return
-		}
-		_, port := hostport(e.Request.RemoteAddr)
+		_, port := hostport(remoteAddr(e))
 		b.WriteString(port)
 	},
 	"$request": func(b *bytes.Buffer, e *Event) {
@@ -109,6 +112,9 @@ var fields = map[string]field{
 		}
 		b.WriteString(e.RequestURL.String())
 	},
+	"$request_id": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.RequestID)
+	},
 	"$request_proto": func(b *bytes.Buffer, e *Event) {
 		if e.Request == nil {
 			return
@@ -116,9 +122,15 @@ var fields = map[string]field{
 		b.WriteString(e.Request.Proto)
 	},
 	"$response_body_size": func(b *bytes.Buffer, e *Event) {
+		if e.Response == nil {
+			return
+		}
 		atoi(b, e.Response.ContentLength, 0)
 	},
 	"$response_status": func(b *bytes.Buffer, e *Event) {
+		if e.Response == nil {
+			return
+		}
 		atoi(b, int64(e.Response.StatusCode), 0)
 	},
 	"$response_time_ms": func(b *bytes.Buffer, e *Event) {
@@ -142,6 +154,9 @@ var fields = map[string]field{
 		b.WriteRune('.')
 		atoi(b, ns, 9)
 	},
+	"$server_name": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.ServerName)
+	},
 	"$time_unix_ms": func(b *bytes.Buffer, e *Event) {
 		atoi(b, e.End.UnixNano()/int64(time.Millisecond), 0)
 	},
@@ -227,6 +242,9 @@ var fields = map[string]field{
 		atoi(b, int64(e.End.Nanosecond()), 9)
 		b.WriteRune('Z')
 	},
+	"$trace_id": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.TraceID)
+	},
 	"$upstream_addr": func(b *bytes.Buffer, e *Event) {
 		b.WriteString(e.UpstreamAddr)
 	},
@@ -256,6 +274,9 @@ var fields = map[string]field{
 		}
 		b.WriteString(e.UpstreamURL.String())
 	},
+	"$upstream_reset": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(strconv.FormatBool(e.UpstreamReset))
+	},
 	"$upstream_service": func(b *bytes.Buffer, e *Event) {
 		b.WriteString(e.UpstreamService)
 	},
@@ -277,6 +298,15 @@ var shortMonthNames = []string{
 	"Dec",
 }
 
+// remoteAddr returns the address of the client of an HTTP
+// or a TCP event.
+func remoteAddr(e *Event) string {
+	if e.Request != nil {
+		return e.Request.RemoteAddr
+	}
+	return e.RemoteAddr
+}
+
 // hostport is a simplified no-alloc version of
 // net.SplitHostPort. Since we know that the
 // address values have the correct form we can
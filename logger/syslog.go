@@ -0,0 +1,15 @@
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// NewSyslogWriter returns a writer which sends every write as a message
+// with the given tag to the syslog server at raddr. The local syslog
+// server is used if network and raddr are empty.
+func NewSyslogWriter(network, raddr, tag string) (io.WriteCloser, error) {
+	return syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_LOCAL0, tag)
+}
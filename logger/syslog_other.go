@@ -0,0 +1,14 @@
+// +build windows plan9
+
+package logger
+
+import (
+	"errors"
+	"io"
+)
+
+// NewSyslogWriter returns an error since syslog is not supported
+// on this platform.
+func NewSyslogWriter(network, raddr, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,22 @@
+package logger
+
+// Targets is a logger which sends the events with a log target to the
+// logger with the same name. Events without a log target or with an
+// unknown log target are sent to the default logger.
+type Targets struct {
+	// Default is the logger for the events without a log target.
+	Default Logger
+
+	// Named are the loggers of the log targets.
+	Named map[string]Logger
+}
+
+func (t *Targets) Log(e *Event) {
+	if l := t.Named[e.LogTarget]; l != nil {
+		l.Log(e)
+		return
+	}
+	if t.Default != nil {
+		t.Default.Log(e)
+	}
+}
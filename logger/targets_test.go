@@ -0,0 +1,29 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTargets(t *testing.T) {
+	var def, a bytes.Buffer
+	newLogger := func(b *bytes.Buffer) Logger {
+		l, err := New(b, "$upstream_service")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	l := &Targets{Default: newLogger(&def), Named: map[string]Logger{"a": newLogger(&a)}}
+
+	l.Log(&Event{UpstreamService: "x"})
+	l.Log(&Event{UpstreamService: "y", LogTarget: "a"})
+	l.Log(&Event{UpstreamService: "z", LogTarget: "unknown"})
+
+	if got, want := def.String(), "x\nz\n"; got != want {
+		t.Errorf("default: got %q want %q", got, want)
+	}
+	if got, want := a.String(), "y\n"; got != want {
+		t.Errorf("a: got %q want %q", got, want)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"github.com/fabiolb/fabio/cert"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/exit"
+	"github.com/fabiolb/fabio/geoip"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
@@ -32,6 +34,7 @@ import (
 	"github.com/fabiolb/fabio/registry/consul"
 	"github.com/fabiolb/fabio/registry/custom"
 	"github.com/fabiolb/fabio/registry/file"
+	"github.com/fabiolb/fabio/registry/kubernetes"
 	"github.com/fabiolb/fabio/registry/static"
 	"github.com/fabiolb/fabio/route"
 	"github.com/fabiolb/fabio/trace"
@@ -39,6 +42,7 @@ import (
 	grpc_proxy "github.com/mwitkow/grpc-proxy/proxy"
 	"github.com/pkg/profile"
 	dmp "github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 )
 
@@ -54,6 +58,31 @@ var version = "1.5.15"
 
 var shuttingDown int32
 
+// accessLog is the asynchronous access log writer which needs
+// to be flushed on shutdown.
+var (
+	accessLogMu sync.Mutex
+	accessLog   io.Closer
+)
+
+func setAccessLogWriter(c io.Closer) {
+	accessLogMu.Lock()
+	accessLog = c
+	accessLogMu.Unlock()
+}
+
+// closeAccessLog flushes the pending access log entries.
+func closeAccessLog() {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	if accessLog != nil {
+		accessLog.Close()
+	}
+	for _, c := range accessLogTargetClosers {
+		c.Close()
+	}
+}
+
 func main() {
 	logOutput := logger.NewLevelWriter(os.Stderr, "INFO", "2017/01/01 00:00:00 ")
 	log.SetOutput(logOutput)
@@ -111,32 +140,71 @@ func main() {
 	exit.Listen(func(s os.Signal) {
 		atomic.StoreInt32(&shuttingDown, 1)
 		proxy.Shutdown(cfg.Proxy.ShutdownWait)
+		closeAccessLog()
 		if prof != nil {
 			prof.Stop()
 		}
-		if registry.Default == nil {
-			return
+		if be := registry.Default(); be != nil {
+			be.DeregisterAll()
 		}
-		registry.Default.DeregisterAll()
 	})
 
 	// init metrics early since that create the global metric registries
 	// that are used by other parts of the code.
 	initMetrics(cfg)
 	initRuntime(cfg)
-	initBackend(cfg)
+	initRouting(cfg)
+	initGeoIP(cfg)
+	initWarmConns(cfg)
+	initBodyCapture(cfg)
+	initTap(cfg)
+	initFairQueue(cfg)
+	initBreakerCache(cfg)
+	initQuotas(cfg)
+	initAccessLogTargets(cfg)
+	connected := initBackend(cfg)
 
 	// init OpenTracing, if enabled
 	trace.InitializeTracer(&cfg.Tracing)
 
-	startAdmin(cfg)
+	startAdmin(cfg, logOutput)
 
-	go watchNoRouteHTML(cfg)
+	// the snapshot must be loaded before the first
+	// routing table from the registry is set.
+	snapshot := loadRouteSnapshot(cfg)
+
+	// serve the snapshot or the bootstrap routes until
+	// the registry backend is available.
+	degraded := false
+	select {
+	case <-connected:
+	default:
+		degraded = true
+		if !snapshot {
+			loadBootstrapRoutes(cfg)
+		}
+	}
 
 	first := make(chan bool)
-	go watchBackend(cfg, first)
-	log.Print("[INFO] Waiting for first routing table")
-	<-first
+	go func() {
+		<-connected
+		if degraded {
+			log.Print("[INFO] Registry backend is available. Leaving degraded mode")
+		}
+		go watchNoRouteHTML(cfg)
+		watchBackend(cfg, first)
+	}()
+	if cfg.Routing.Snapshot.File != "" {
+		go writeRouteSnapshots(cfg, first)
+	}
+	if !snapshot && !degraded {
+		log.Print("[INFO] Waiting for first routing table")
+		<-first
+	}
+
+	initTicketKeys(cfg)
+	initClientCRL(cfg)
+	initHandshakeLimit(cfg)
 
 	// create proxies after metrics since they use the metrics registry.
 	startServers(cfg)
@@ -157,6 +225,12 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config) []grpc.ServerOption {
 		Connect: metrics.DefaultRegistry.GetCounter("grpc.conn"),
 		Request: metrics.DefaultRegistry.GetTimer("grpc.requests"),
 		NoRoute: metrics.DefaultRegistry.GetCounter("grpc.noroute"),
+
+		MaxConn:       cfg.Proxy.GRPCMaxConn,
+		MaxStreams:    cfg.Proxy.GRPCMaxStreams,
+		ActiveConn:    metrics.DefaultRegistry.GetCounter("grpc.conn.active"),
+		ActiveStreams: metrics.DefaultRegistry.GetCounter("grpc.streams.active"),
+		Rejected:      metrics.DefaultRegistry.GetCounter("grpc.streams.rejected"),
 	}
 
 	proxyInterceptor := proxy.GrpcProxyInterceptor{
@@ -175,7 +249,25 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config) []grpc.ServerOption {
 	}
 }
 
-func newHTTPProxy(cfg *config.Config) http.Handler {
+// newTCPLogger returns the connection logger for the TCP and SNI proxies
+// or nil if connection logging is disabled.
+func newTCPLogger(cfg *config.Config) logger.Logger {
+	switch cfg.Log.TCPTarget {
+	case "":
+		return nil
+	case "stdout":
+		l, err := logger.New(os.Stdout, cfg.Log.TCPFormat)
+		if err != nil {
+			exit.Fatal("[FATAL] Invalid TCP log format: ", err)
+		}
+		return l
+	default:
+		exit.Fatal("[FATAL] Invalid TCP log target ", cfg.Log.TCPTarget)
+		return nil
+	}
+}
+
+func newHTTPProxy(cfg *config.Config, listen config.Listen) http.Handler {
 	var w io.Writer
 
 	//Init Glob Cache
@@ -187,6 +279,19 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 	case "stdout":
 		log.Printf("[INFO] Writing access log to stdout")
 		w = os.Stdout
+	case "otlp":
+		log.Printf("[INFO] Sending access log to OTLP endpoint %s", cfg.Log.AccessOTLP.Endpoint)
+		x := &logger.OTLPExporter{
+			Endpoint:      cfg.Log.AccessOTLP.Endpoint,
+			BatchSize:     cfg.Log.AccessOTLP.BatchSize,
+			FlushInterval: cfg.Log.AccessOTLP.FlushInterval,
+			Client:        &http.Client{Timeout: 10 * time.Second},
+		}
+		// send the pending records after the buffer has been flushed
+		accessLogMu.Lock()
+		accessLogTargetClosers = append(accessLogTargetClosers, x)
+		accessLogMu.Unlock()
+		w = x
 	default:
 		exit.Fatal("[FATAL] Invalid access log target ", cfg.Log.AccessTarget)
 	}
@@ -199,26 +304,56 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		format = logger.CombinedFormat
 	}
 
-	l, err := logger.New(w, format)
-	if err != nil {
+	if w != nil && cfg.Log.AccessBufferSize > 0 {
+		aw := logger.NewAsyncWriter(w, cfg.Log.AccessBufferSize, cfg.Log.AccessOverflow == "drop")
+		aw.Dropped = metrics.DefaultRegistry.GetCounter("log.access.dropped")
+		log.Printf("[INFO] Writing access log asynchronously with buffer size %d and overflow policy %q", cfg.Log.AccessBufferSize, cfg.Log.AccessOverflow)
+		setAccessLogWriter(aw)
+		w = aw
+	}
+
+	var l logger.Logger
+	var err error
+	if cfg.Log.AccessTarget == "otlp" {
+		l = logger.NewOTLPLogger(w)
+	} else if l, err = logger.New(w, format); err != nil {
 		exit.Fatal("[FATAL] Invalid log format: ", err)
 	}
+	if len(accessLogTargets) > 0 {
+		targets := &logger.Targets{Default: l, Named: map[string]logger.Logger{}}
+		for name, tw := range accessLogTargets {
+			if targets.Named[name], err = logger.New(tw, format); err != nil {
+				exit.Fatal("[FATAL] Invalid log format: ", err)
+			}
+		}
+		l = targets
+	}
 
-	pick := route.Picker[cfg.Proxy.Strategy]
 	match := route.Matcher[cfg.Proxy.Matcher]
 	notFound := metrics.DefaultRegistry.GetCounter("notfound")
 	log.Printf("[INFO] Using routing strategy %q", cfg.Proxy.Strategy)
 	log.Printf("[INFO] Using route matching %q", cfg.Proxy.Matcher)
 
+	// wait for the upstream server to accept or reject the request
+	// before sending the body of 'Expect: 100-continue' requests.
+	var expectContinueTimeout time.Duration
+	if cfg.Proxy.Expect100 {
+		expectContinueTimeout = cfg.Proxy.Expect100Timeout
+	}
+
 	newTransport := func(tlscfg *tls.Config) *http.Transport {
+		if cfg.Proxy.TLSSessionCacheSize > 0 {
+			if tlscfg == nil {
+				tlscfg = &tls.Config{}
+			}
+			tlscfg.ClientSessionCache = tls.NewLRUClientSessionCache(cfg.Proxy.TLSSessionCacheSize)
+		}
 		return &http.Transport{
 			ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
+			ExpectContinueTimeout: expectContinueTimeout,
 			MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
-			Dial: (&net.Dialer{
-				Timeout:   cfg.Proxy.DialTimeout,
-				KeepAlive: cfg.Proxy.KeepAliveTimeout,
-			}).Dial,
-			TLSClientConfig: tlscfg,
+			Dial:                  warmConns.Dial,
+			TLSClientConfig:       tlscfg,
 		}
 	}
 
@@ -228,11 +363,14 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		exit.Fatal("[FATAL] ", err)
 	}
 
-	return &proxy.HTTPProxy{
-		Config:            cfg.Proxy,
-		Transport:         newTransport(nil),
-		InsecureTransport: newTransport(&tls.Config{InsecureSkipVerify: true}),
+	p := &proxy.HTTPProxy{
+		Config:                cfg.Proxy,
+		Transport:             newTransport(nil),
+		InsecureTransport:     newTransport(&tls.Config{InsecureSkipVerify: true}),
+		AutoTransport:         proxy.NewAutoProtoTransport(newTransport(nil)),
+		InsecureAutoTransport: proxy.NewAutoProtoTransport(newTransport(&tls.Config{InsecureSkipVerify: true})),
 		Lookup: func(r *http.Request) *route.Target {
+			pick := route.RequestPicker(cfg.Proxy.Strategy, r)
 			t := route.GetTable().Lookup(r, r.Header.Get("trace"), pick, match, globCache, cfg.GlobMatchingDisabled)
 			if t == nil {
 				notFound.Inc(1)
@@ -240,12 +378,80 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 			}
 			return t
 		},
-		Requests:    metrics.DefaultRegistry.GetTimer("requests"),
-		Noroute:     metrics.DefaultRegistry.GetCounter("notfound"),
-		Logger:      l,
-		TracerCfg:   cfg.Tracing,
-		AuthSchemes: authSchemes,
+		Requests:         metrics.DefaultRegistry.GetTimer("requests"),
+		Noroute:          metrics.DefaultRegistry.GetCounter("notfound"),
+		NorouteDropped:   metrics.DefaultRegistry.GetCounter("notfound_dropped"),
+		ReadBodyTimeout:  listen.ReadBodyTimeout,
+		ReadBodyMinRate:  listen.ReadBodyMinRate,
+		ProxyProtoXFF:    listen.ProxyProtoXFF,
+		SlowBodyDropped:  metrics.DefaultRegistry.GetCounter("slowbody_dropped"),
+		FramingRejected:  metrics.DefaultRegistry.GetCounter("framing_rejected"),
+		ClientCancelled:  metrics.DefaultRegistry.GetCounter("client_cancelled"),
+		RequestFiltered:  metrics.DefaultRegistry.GetCounter("requestfilter.rejected"),
+		BackendReset:     metrics.DefaultRegistry.GetCounter("backend_reset"),
+		ResponseTooLarge: metrics.DefaultRegistry.GetCounter("response_too_large"),
+		Fallbacks:        metrics.DefaultRegistry.GetCounter("fallback"),
+		EmptyFallbacks:   metrics.DefaultRegistry.GetCounter("emptyfallback"),
+		BreakerFallbacks: metrics.DefaultRegistry.GetCounter("breakerfallback"),
+		BreakerCache:     breakerCache,
+		Quotas:           quotas,
+		QuotaExceeded:    metrics.DefaultRegistry.GetCounter("quota_exceeded"),
+		Unexpected:       metrics.DefaultRegistry.GetCounter("unexpected_response"),
+		Retries:          metrics.DefaultRegistry.GetCounter("retry"),
+		HeaderSizes:      proxy.NewHeaderSizes(metrics.DefaultRegistry, listen.Addr, cfg.Proxy.HeaderSizeWarn),
+		Logger:           l,
+		Capture:          bodyCapture,
+		Tap:              tap,
+		FairQueue:        fairQueue,
+		TracerCfg:        cfg.Tracing,
+		AuthSchemes:      authSchemes,
+		Secret:           (&cert.Secrets{Sources: cfg.Proxy.CertSources}).Get,
+	}
+	if cfg.Proxy.TenantHeader != "" {
+		newTenantTransport := func(tlscfg *tls.Config) *http.Transport {
+			tr := newTransport(tlscfg)
+			// requests in flight return their connections to the
+			// idle pool after the tenant pool has been closed.
+			tr.IdleConnTimeout = 90 * time.Second
+			return tr
+		}
+		p.Tenants = &proxy.TenantTransports{
+			Header: cfg.Proxy.TenantHeader,
+			Allow:  map[string]bool{},
+			Max:    cfg.Proxy.TenantMaxPools,
+			New: func() proxy.Transports {
+				return proxy.Transports{
+					Transport:             newTenantTransport(nil),
+					InsecureTransport:     newTenantTransport(&tls.Config{InsecureSkipVerify: true}),
+					AutoTransport:         proxy.NewAutoProtoTransport(newTenantTransport(nil)),
+					InsecureAutoTransport: proxy.NewAutoProtoTransport(newTenantTransport(&tls.Config{InsecureSkipVerify: true})),
+				}
+			},
+			Evictions: metrics.DefaultRegistry.GetCounter("tenant.evictions"),
+		}
+		for _, tenant := range cfg.Proxy.TenantAllow {
+			p.Tenants.Allow[tenant] = true
+		}
+	}
+	if listen.NoRouteRateLimit > 0 {
+		// allow bursts of one second worth of requests
+		burst := int(math.Ceil(listen.NoRouteRateLimit))
+		p.NoRouteLimit = rate.NewLimiter(rate.Limit(listen.NoRouteRateLimit), burst)
+	}
+	if cfg.Proxy.Connect {
+		// the destinations of the forward proxy have
+		// 'connect:<host>:<port>' routes.
+		pick := route.Picker[cfg.Proxy.Strategy]
+		p.ConnectLookup = func(hostport string) *route.Target {
+			return route.GetTable().LookupHost("connect:"+hostport, pick)
+		}
+		p.ConnectDenied = metrics.DefaultRegistry.GetCounter("connect.denied")
+	}
+	if geoDB != nil {
+		p.CountryHeader = cfg.Routing.GeoIP.Header
+		p.Country = geoDB.RequestCountry
 	}
+	return p
 }
 
 func lookupHostFn(cfg *config.Config) func(string) *route.Target {
@@ -261,6 +467,25 @@ func lookupHostFn(cfg *config.Config) func(string) *route.Target {
 	}
 }
 
+// lookupHostJA3Fn returns the lookup function of the SNI proxies which
+// also matches the JA3 fingerprint of the client if proxy.tls.ja3 is
+// enabled. It returns nil otherwise.
+func lookupHostJA3Fn(cfg *config.Config) func(string, string) *route.Target {
+	if !cfg.Proxy.JA3 {
+		return nil
+	}
+	pick := route.Picker[cfg.Proxy.Strategy]
+	notFound := metrics.DefaultRegistry.GetCounter("notfound")
+	return func(host, ja3 string) *route.Target {
+		t := route.GetTable().LookupHostJA3(host, ja3, pick)
+		if t == nil {
+			notFound.Inc(1)
+			log.Print("[WARN] No route for ", host)
+		}
+		return t
+	}
+}
+
 // Returns a matcher function compatible with tcpproxy Matcher from github.com/inetaf/tcpproxy
 func lookupHostMatcher(cfg *config.Config) func(context.Context, string) bool {
 	pick := route.Picker[cfg.Proxy.Strategy]
@@ -295,10 +520,274 @@ func makeTLSConfig(l config.Listen) (*tls.Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("[FATAL] Failed to create TLS config for cert source %s. %s", l.CertSource.Name, err)
 	}
+
+	// reload the client CAs on SIGHUP so that CAs can be added
+	// without dropping the established connections.
+	if tlscfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		cas := &cert.ClientCAs{Source: src}
+		cas.Add(tlscfg)
+		exit.OnReload(func() {
+			if err := cas.Reload(); err != nil {
+				log.Printf("[ERROR] Cannot reload client CAs of cert source %s. %s", l.CertSource.Name, err)
+				return
+			}
+			log.Printf("[INFO] Reloaded client CAs of cert source %s", l.CertSource.Name)
+		})
+	}
 	return tlscfg, nil
 }
 
-func startAdmin(cfg *config.Config) {
+// ticketKeys manages the TLS session ticket keys of the proxy listeners.
+// It is nil if the Go defaults are used.
+var ticketKeys *cert.TicketKeys
+
+func initTicketKeys(cfg *config.Config) {
+	if cfg.Proxy.TLSTicketRotation == 0 && cfg.Proxy.TLSTicketKeyFile == "" {
+		return
+	}
+	ticketKeys = &cert.TicketKeys{
+		Rotation: cfg.Proxy.TLSTicketRotation,
+		KeyFile:  cfg.Proxy.TLSTicketKeyFile,
+	}
+	if err := ticketKeys.Rotate(); err != nil {
+		exit.Fatal("[FATAL] Cannot create TLS session ticket keys. ", err)
+	}
+	if ticketKeys.KeyFile != "" {
+		log.Printf("[INFO] Using TLS session ticket keys from %s", ticketKeys.KeyFile)
+	}
+	if ticketKeys.Rotation > 0 {
+		log.Printf("[INFO] Rotating TLS session ticket keys every %s", ticketKeys.Rotation)
+		go ticketKeys.Run(nil)
+	}
+}
+
+func initHandshakeLimit(cfg *config.Config) {
+	proxy.HandshakeMetrics = cfg.Proxy.TLSHandshakeMetrics
+	proxy.JA3 = cfg.Proxy.JA3
+	if cfg.Proxy.JA3 {
+		route.ClientJA3 = proxy.RequestJA3
+	}
+	if cfg.Proxy.TLSMaxHandshakes == 0 {
+		return
+	}
+	proxy.HandshakeLimit = proxy.NewHandshakeLimiter(
+		cfg.Proxy.TLSMaxHandshakes,
+		cfg.Proxy.TLSHandshakeQueue,
+		metrics.DefaultRegistry.GetCounter("tls.handshake.queued"),
+		metrics.DefaultRegistry.GetCounter("tls.handshake.shed"),
+	)
+	log.Printf("[INFO] Limiting concurrent TLS handshakes to %d with a queue of %d", cfg.Proxy.TLSMaxHandshakes, cfg.Proxy.TLSHandshakeQueue)
+}
+
+// clientCRL rejects revoked client certificates on the proxy
+// listeners. It is nil if no revocation lists are configured.
+var clientCRL *cert.CRL
+
+func initClientCRL(cfg *config.Config) {
+	if cfg.Proxy.TLSClientCRL == "" {
+		return
+	}
+	clientCRL = &cert.CRL{Path: cfg.Proxy.TLSClientCRL}
+	if err := clientCRL.Reload(); err != nil {
+		exit.Fatal("[FATAL] Cannot load client certificate revocation lists. ", err)
+	}
+	log.Printf("[INFO] Using client certificate revocation lists from %s", clientCRL.Path)
+	exit.OnReload(func() {
+		if err := clientCRL.Reload(); err != nil {
+			log.Printf("[ERROR] Cannot reload client certificate revocation lists. %s", err)
+			return
+		}
+		log.Printf("[INFO] Reloaded client certificate revocation lists from %s", clientCRL.Path)
+	})
+}
+
+// warmConns keeps the established upstream connections for
+// the 'minconns' route option.
+var warmConns *proxy.WarmDialer
+
+func initWarmConns(cfg *config.Config) {
+	warmConns = &proxy.WarmDialer{
+		Dialer: &net.Dialer{
+			Timeout:   cfg.Proxy.DialTimeout,
+			KeepAlive: cfg.Proxy.KeepAliveTimeout,
+		},
+		MaxPerHost: cfg.Proxy.MinConnsMax,
+	}
+}
+
+// bodyCapture logs the bodies of the routes with the 'capturebody'
+// option while it is enabled through the admin API.
+var bodyCapture *proxy.BodyCapture
+
+func initBodyCapture(cfg *config.Config) {
+	var w io.Writer
+	switch cfg.Proxy.Capture.Target {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		// the captured bodies may contain sensitive data
+		f, err := os.OpenFile(cfg.Proxy.Capture.Target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			exit.Fatal("[FATAL] Cannot open capture log. ", err)
+		}
+		w = f
+	}
+	bodyCapture = &proxy.BodyCapture{Log: log.New(w, "[CAPTURE] ", log.LstdFlags)}
+	bodyCapture.Update(proxy.CaptureSettings{
+		MaxBody:    cfg.Proxy.Capture.MaxBody,
+		SampleRate: cfg.Proxy.Capture.SampleRate,
+	})
+}
+
+// tap sends the request metadata of the routes with the 'tap'
+// option to the sink. It is nil if no sink is configured.
+var tap *proxy.Tap
+
+func initTap(cfg *config.Config) {
+	if cfg.Proxy.Tap.Sink == "" {
+		return
+	}
+	sink, err := proxy.NewTapSink(cfg.Proxy.Tap.Sink, cfg.Proxy.Tap.Timeout)
+	if err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
+	tap = proxy.NewTap(sink, cfg.Proxy.Tap.BufferSize, cfg.Proxy.Tap.MaxBody)
+	tap.Dropped = metrics.DefaultRegistry.GetCounter("tap.dropped")
+	go tap.Run()
+	exit.Listen(func(os.Signal) { tap.Close() })
+	log.Printf("[INFO] Sending the request metadata of routes with 'tap' to %s", cfg.Proxy.Tap.Sink)
+}
+
+// fairQueue shares the concurrent upstream requests of the HTTP
+// listeners between the routes. It is nil if they are not limited.
+var fairQueue *proxy.FairQueue
+
+func initFairQueue(cfg *config.Config) {
+	if cfg.Proxy.MaxConcurrent == 0 {
+		return
+	}
+	fairQueue = &proxy.FairQueue{
+		Max:      cfg.Proxy.MaxConcurrent,
+		MaxQueue: cfg.Proxy.MaxConcurrentQueue,
+		Timeout:  cfg.Proxy.MaxConcurrentTimeout,
+	}
+	log.Printf("[INFO] Limiting concurrent upstream requests to %d", cfg.Proxy.MaxConcurrent)
+}
+
+// breakerCache keeps the responses of the routes with the
+// 'breakerfallback=cache' option for all HTTP listeners.
+var breakerCache *proxy.ResponseCache
+
+func initBreakerCache(cfg *config.Config) {
+	if cfg.Proxy.BreakerCacheSize == 0 {
+		return
+	}
+	breakerCache = &proxy.ResponseCache{
+		Max:     cfg.Proxy.BreakerCacheSize,
+		MaxBody: cfg.Proxy.BreakerCacheMaxBody,
+	}
+}
+
+// quotas counts the requests of the routes with the 'quota' option.
+// The counters are shared by all HTTP listeners.
+var quotas = &proxy.Quotas{}
+
+// initQuotas loads the quota counters from proxy.quota.file and writes
+// them to the file every proxy.quota.syncinterval and on shutdown.
+func initQuotas(cfg *config.Config) {
+	if cfg.Proxy.QuotaFile == "" {
+		return
+	}
+	quotas.Store = proxy.QuotaFile(cfg.Proxy.QuotaFile)
+	if err := quotas.Load(); err != nil {
+		log.Printf("[WARN] Cannot load quota counters. %s", err)
+	}
+	save := func() {
+		if err := quotas.Save(); err != nil {
+			log.Printf("[ERROR] Cannot save quota counters. %s", err)
+		}
+	}
+	exit.Listen(func(os.Signal) { save() })
+	go func() {
+		for {
+			time.Sleep(cfg.Proxy.QuotaSyncInterval)
+			save()
+		}
+	}()
+}
+
+// accessLogTargets are the writers of the named access log destinations
+// for the routes with the 'logtarget' option. They are shared by all
+// HTTP listeners.
+var (
+	accessLogTargets       map[string]io.Writer
+	accessLogTargetClosers []io.Closer
+)
+
+func initAccessLogTargets(cfg *config.Config) {
+	if len(cfg.Log.AccessTargets) == 0 {
+		return
+	}
+	accessLogTargets = map[string]io.Writer{}
+	for name, t := range cfg.Log.AccessTargets {
+		var w io.Writer
+		switch t.Type {
+		case "stdout":
+			w = os.Stdout
+		case "file":
+			f, err := os.OpenFile(t.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				exit.Fatalf("[FATAL] Cannot open access log file for %q. %s", name, err)
+			}
+			accessLogTargetClosers = append(accessLogTargetClosers, f)
+			w = f
+		case "syslog":
+			sw, err := logger.NewSyslogWriter(t.Network, t.Addr, t.Tag)
+			if err != nil {
+				exit.Fatalf("[FATAL] Cannot connect to syslog for %q. %s", name, err)
+			}
+			accessLogTargetClosers = append(accessLogTargetClosers, sw)
+			w = sw
+		}
+		if cfg.Log.AccessBufferSize > 0 {
+			aw := logger.NewAsyncWriter(w, cfg.Log.AccessBufferSize, cfg.Log.AccessOverflow == "drop")
+			aw.Dropped = metrics.DefaultRegistry.GetCounter("log.access.dropped")
+			// flush the buffer before the underlying writer is closed
+			accessLogTargetClosers = append([]io.Closer{aw}, accessLogTargetClosers...)
+			w = aw
+		}
+		log.Printf("[INFO] Writing access log %q to %s", name, t.Type)
+		accessLogTargets[name] = w
+	}
+}
+
+// geoDB resolves the country of clients for the 'match=geo:...'
+// route option. It is nil if no database is configured.
+var geoDB *geoip.DB
+
+func initGeoIP(cfg *config.Config) {
+	if cfg.Routing.GeoIP.Database == "" {
+		return
+	}
+	geoDB = &geoip.DB{Path: cfg.Routing.GeoIP.Database}
+	if err := geoDB.Reload(); err != nil {
+		log.Printf("[WARN] Cannot load GeoIP database. %s", err)
+	} else {
+		log.Printf("[INFO] Using GeoIP database %s", geoDB.Path)
+	}
+	exit.OnReload(func() {
+		if err := geoDB.Reload(); err != nil {
+			log.Printf("[ERROR] Cannot reload GeoIP database. %s", err)
+			return
+		}
+		log.Printf("[INFO] Reloaded GeoIP database %s", geoDB.Path)
+	})
+	route.ClientCountry = geoDB.RequestCountry
+}
+
+func startAdmin(cfg *config.Config, logOutput *logger.LevelWriter) {
 	log.Printf("[INFO] Admin server access mode %q", cfg.UI.Access)
 	log.Printf("[INFO] Admin server listening on %q", cfg.UI.Listen.Addr)
 	go func() {
@@ -314,6 +803,8 @@ func startAdmin(cfg *config.Config) {
 			Version:  version,
 			Commands: route.Commands,
 			Cfg:      cfg,
+			Capture:  bodyCapture,
+			Log:      logOutput,
 		}
 		if err := srv.ListenAndServe(l, tlscfg); err != nil {
 			exit.Fatal("[FATAL] ui: ", err)
@@ -328,6 +819,12 @@ func startServers(cfg *config.Config) {
 		if err != nil {
 			exit.Fatal("[FATAL] ", err)
 		}
+		if tlscfg != nil && ticketKeys != nil {
+			ticketKeys.Add(tlscfg)
+		}
+		if tlscfg != nil && clientCRL != nil {
+			clientCRL.Add(tlscfg)
+		}
 
 		log.Printf("[INFO] %s proxy listening on %s", strings.ToUpper(l.Proto), l.Addr)
 		if tlscfg != nil && tlscfg.ClientAuth == tls.RequireAndVerifyClientCert {
@@ -337,7 +834,7 @@ func startServers(cfg *config.Config) {
 		switch l.Proto {
 		case "http", "https":
 			go func() {
-				h := newHTTPProxy(cfg)
+				h := newHTTPProxy(cfg, l)
 				if err := proxy.ListenAndServeHTTP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
 				}
@@ -349,14 +846,32 @@ func startServers(cfg *config.Config) {
 					exit.Fatal("[FATAL] ", err)
 				}
 			}()
+		case "https+alpn":
+			go func() {
+				h := newHTTPProxy(cfg, l)
+				opts := newGrpcProxy(cfg, tlscfg)
+				if err := proxy.ListenAndServeALPN(l, h, opts, tlscfg); err != nil {
+					exit.Fatal("[FATAL] ", err)
+				}
+			}()
 		case "tcp":
 			go func() {
 				h := &tcp.Proxy{
-					DialTimeout: cfg.Proxy.DialTimeout,
-					Lookup:      lookupHostFn(cfg),
-					Conn:        metrics.DefaultRegistry.GetCounter("tcp.conn"),
-					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp.connfail"),
-					Noroute:     metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+					DialTimeout:  cfg.Proxy.DialTimeout,
+					Lookup:       lookupHostFn(cfg),
+					Conn:         metrics.DefaultRegistry.GetCounter("tcp.conn"),
+					ConnFail:     metrics.DefaultRegistry.GetCounter("tcp.connfail"),
+					Noroute:      metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+					DialAttempts: cfg.Proxy.TCPDialAttempts,
+					DialFailover: metrics.DefaultRegistry.GetCounter("tcp.dialfailover"),
+					FrameErr:     metrics.DefaultRegistry.GetCounter("tcp.frameerr"),
+					Reconnect:    metrics.DefaultRegistry.GetCounter("tcp.reconnect"),
+					Logger:       newTCPLogger(cfg),
+
+					ConnLimit:               &tcp.ConnLimiter{Max: cfg.Proxy.TCPMaxConnPerIP},
+					RejectMaxConnPerIP:      metrics.DefaultRegistry.GetCounter("tcp.reject.maxconnperip"),
+					RejectRouteMaxConnPerIP: metrics.DefaultRegistry.GetCounter("tcp.reject.route.maxconnperip"),
+					RejectMaxIPs:            metrics.DefaultRegistry.GetCounter("tcp.reject.maxips"),
 				}
 				if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -365,11 +880,19 @@ func startServers(cfg *config.Config) {
 		case "tcp+sni":
 			go func() {
 				h := &tcp.SNIProxy{
-					DialTimeout: cfg.Proxy.DialTimeout,
-					Lookup:      lookupHostFn(cfg),
-					Conn:        metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
-					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
-					Noroute:     metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					DialTimeout:  cfg.Proxy.DialTimeout,
+					Lookup:       lookupHostFn(cfg),
+					LookupJA3:    lookupHostJA3Fn(cfg),
+					Conn:         metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
+					ConnFail:     metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
+					Noroute:      metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					DialAttempts: cfg.Proxy.TCPDialAttempts,
+					DialFailover: metrics.DefaultRegistry.GetCounter("tcp_sni.dialfailover"),
+					Logger:       newTCPLogger(cfg),
+
+					ClientHelloTimeout: cfg.Proxy.SNIClientHelloTimeout,
+					MaxClientHelloSize: cfg.Proxy.SNIMaxClientHelloSize,
+					Malformed:          metrics.DefaultRegistry.GetCounter("tcp_sni.malformed"),
 				}
 				if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -412,11 +935,14 @@ func startServers(cfg *config.Config) {
 						log.Printf("[INFO] Starting dynamic TCP listener on port %s ", port)
 						go func() {
 							h := &tcp.DynamicProxy{
-								DialTimeout: cfg.Proxy.DialTimeout,
-								Lookup:      lookupHostFn(cfg),
-								Conn:        metrics.DefaultRegistry.GetCounter("tcp.conn"),
-								ConnFail:    metrics.DefaultRegistry.GetCounter("tcp.connfail"),
-								Noroute:     metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+								DialTimeout:  cfg.Proxy.DialTimeout,
+								Lookup:       lookupHostFn(cfg),
+								Conn:         metrics.DefaultRegistry.GetCounter("tcp.conn"),
+								ConnFail:     metrics.DefaultRegistry.GetCounter("tcp.connfail"),
+								Noroute:      metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+								DialAttempts: cfg.Proxy.TCPDialAttempts,
+								DialFailover: metrics.DefaultRegistry.GetCounter("tcp.dialfailover"),
+								Logger:       newTCPLogger(cfg),
 							}
 							l.Addr = port
 							if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
@@ -429,13 +955,21 @@ func startServers(cfg *config.Config) {
 			}()
 		case "https+tcp+sni":
 			go func() {
-				hp := newHTTPProxy(cfg)
+				hp := newHTTPProxy(cfg, l)
 				tp := &tcp.SNIProxy{
-					DialTimeout: cfg.Proxy.DialTimeout,
-					Lookup:      lookupHostFn(cfg),
-					Conn:        metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
-					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
-					Noroute:     metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					DialTimeout:  cfg.Proxy.DialTimeout,
+					Lookup:       lookupHostFn(cfg),
+					LookupJA3:    lookupHostJA3Fn(cfg),
+					Conn:         metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
+					ConnFail:     metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
+					Noroute:      metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					DialAttempts: cfg.Proxy.TCPDialAttempts,
+					DialFailover: metrics.DefaultRegistry.GetCounter("tcp_sni.dialfailover"),
+					Logger:       newTCPLogger(cfg),
+
+					ClientHelloTimeout: cfg.Proxy.SNIClientHelloTimeout,
+					MaxClientHelloSize: cfg.Proxy.SNIMaxClientHelloSize,
+					Malformed:          metrics.DefaultRegistry.GetCounter("tcp_sni.malformed"),
 				}
 				if err := proxy.ListenAndServeHTTPSTCPSNI(l, hp, tp, tlscfg, lookupHostMatcher(cfg)); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -474,6 +1008,61 @@ func initMetrics(cfg *config.Config) {
 	}
 }
 
+func initRouting(cfg *config.Config) {
+	route.MaxRoutes = cfg.Routing.MaxRoutes
+	route.RejectedTables = metrics.DefaultRegistry.GetCounter("routes.rejected")
+	route.TableRoutes = metrics.DefaultRegistry.GetCounter("routes.count")
+	route.TableBytes = metrics.DefaultRegistry.GetCounter("routes.bytes")
+	route.ConflictMode = cfg.Routing.ConflictMode
+	route.TrailingSlash = cfg.Routing.TrailingSlash
+	route.StatusMetrics = cfg.Metrics.StatusCodes
+	route.FallbackDir = cfg.Proxy.FallbackDir
+	exclude, err := route.ParseMetricsFilter(cfg.Metrics.Exclude)
+	if err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
+	if exclude != nil {
+		exclude.Totals = cfg.Metrics.ExcludeTotals
+	}
+	route.MetricsExclude = exclude
+	route.TableConflicts = metrics.DefaultRegistry.GetCounter("routes.conflicts")
+	route.Outliers = route.OutlierDetection{
+		Consecutive5xx:     cfg.Proxy.Outlier.Consecutive5xx,
+		BaseEjectionTime:   cfg.Proxy.Outlier.BaseEjectionTime,
+		MaxEjectionTime:    cfg.Proxy.Outlier.MaxEjectionTime,
+		MaxEjectionPercent: cfg.Proxy.Outlier.MaxEjectionPercent,
+	}
+	route.Ejections = metrics.DefaultRegistry.GetCounter("outlier.ejections")
+	route.LocalAddrs = cfg.Proxy.LocalAddr
+	if len(route.LocalAddrs) == 0 && cfg.Proxy.LocalIP != "" {
+		route.LocalAddrs = []string{cfg.Proxy.LocalIP}
+	}
+	route.AntiAffinityRepicks = metrics.DefaultRegistry.GetCounter("antiaffinity.repicks")
+	route.AntiAffinityFallbacks = metrics.DefaultRegistry.GetCounter("antiaffinity.fallbacks")
+	route.DrainDecay = cfg.Proxy.DrainDecay
+	route.CrossDatacenter = metrics.DefaultRegistry.GetCounter("crossdc.requests")
+	route.Latency = route.AdaptiveLatency{
+		Smoothing: cfg.Proxy.Latency.Smoothing,
+		Interval:  cfg.Proxy.Latency.Interval,
+		MinFactor: cfg.Proxy.Latency.MinFactor,
+		MaxFactor: cfg.Proxy.Latency.MaxFactor,
+	}
+	if cfg.Proxy.PickerSeed != 0 {
+		log.Printf("[WARN] Using deterministic target selection with seed %d", cfg.Proxy.PickerSeed)
+		route.SeedPicker(cfg.Proxy.PickerSeed)
+	}
+	if cfg.Proxy.Strategy == "adaptive-latency" {
+		log.Printf("[INFO] Adjusting target weights by response latency every %s", route.Latency.Interval)
+		go route.RunLatencyWeights(nil)
+	}
+	if route.MaxRoutes > 0 {
+		log.Printf("[INFO] Limiting the routing table to %d routes", route.MaxRoutes)
+	}
+	if route.Outliers.Consecutive5xx > 0 {
+		log.Printf("[INFO] Ejecting targets after %d consecutive 5xx responses", route.Outliers.Consecutive5xx)
+	}
+}
+
 func initRuntime(cfg *config.Config) {
 	if os.Getenv("GOGC") == "" {
 		log.Print("[INFO] Setting GOGC=", cfg.Runtime.GOGC)
@@ -490,32 +1079,45 @@ func initRuntime(cfg *config.Config) {
 	}
 }
 
-func initBackend(cfg *config.Config) {
-	var deadline = time.Now().Add(cfg.Registry.Timeout)
-	var err error
-	for {
-		switch cfg.Registry.Backend {
-		case "file":
-			registry.Default, err = file.NewBackend(&cfg.Registry.File)
-		case "static":
-			registry.Default, err = static.NewBackend(&cfg.Registry.Static)
-		case "consul":
-			registry.Default, err = consul.NewBackend(&cfg.Registry.Consul)
-		case "custom":
-			registry.Default, err = custom.NewBackend(&cfg.Registry.Custom)
-		default:
-			exit.Fatal("[FATAL] Unknown registry backend ", cfg.Registry.Backend)
-		}
+// initBackend connects to the registry backend and returns a channel
+// which is closed when the backend is available. fabio exits if the
+// backend is not available within registry.timeout. With
+// registry.startup.failmode = serve-degraded initBackend returns after
+// registry.startup.timeout instead and keeps connecting to the backend
+// in the background.
+func initBackend(cfg *config.Config) <-chan struct{} {
+	degraded := cfg.Registry.StartupFailMode == "serve-degraded"
+	timeout := cfg.Registry.Timeout
+	if degraded && cfg.Registry.StartupTimeout > 0 {
+		timeout = cfg.Registry.StartupTimeout
+	}
+	var deadline = time.Now().Add(timeout)
 
-		if err == nil {
-			if err = registry.Default.Register(nil); err == nil {
-				return
-			}
+	connected := make(chan struct{})
+	connect := func() bool {
+		if err := newBackend(cfg); err != nil {
+			log.Print("[WARN] Error initializing backend. ", err)
+			return false
 		}
-		log.Print("[WARN] Error initializing backend. ", err)
+		close(connected)
+		return true
+	}
 
+	for !connect() {
 		if time.Now().After(deadline) {
-			exit.Fatal("[FATAL] Timeout registering backend.")
+			if !degraded {
+				exit.Fatal("[FATAL] Timeout registering backend.")
+			}
+			log.Print("[WARN] Timeout registering backend. Serving degraded routing table")
+			go func() {
+				for {
+					time.Sleep(cfg.Registry.Retry)
+					if atomic.LoadInt32(&shuttingDown) > 0 || connect() {
+						return
+					}
+				}
+			}()
+			return connected
 		}
 
 		time.Sleep(cfg.Registry.Retry)
@@ -523,6 +1125,41 @@ func initBackend(cfg *config.Config) {
 			exit.Exit(1)
 		}
 	}
+	return connected
+}
+
+// newBackend creates the registry backend and registers fabio with it.
+// The default backend is only set if the backend is available.
+func newBackend(cfg *config.Config) error {
+	var be registry.Backend
+	var err error
+	switch cfg.Registry.Backend {
+	case "file":
+		be, err = file.NewBackend(&cfg.Registry.File)
+	case "static":
+		be, err = static.NewBackend(&cfg.Registry.Static)
+	case "consul":
+		consul.Stale = metrics.DefaultRegistry.GetCounter("registry.stale")
+		be, err = consul.NewBackend(&cfg.Registry.Consul)
+	case "custom":
+		be, err = custom.NewBackend(&cfg.Registry.Custom)
+	case "kubernetes":
+		be, err = kubernetes.NewBackend(&cfg.Registry.Kubernetes)
+	default:
+		exit.Fatal("[FATAL] Unknown registry backend ", cfg.Registry.Backend)
+	}
+
+	if err == nil && cfg.UI.OverridesFile != "" {
+		be, err = registry.NewOverridesFile(be, cfg.UI.OverridesFile)
+	}
+	if err != nil {
+		return err
+	}
+	if err := be.Register(nil); err != nil {
+		return err
+	}
+	registry.SetDefault(be)
+	return nil
 }
 
 func watchBackend(cfg *config.Config, first chan bool) {
@@ -540,18 +1177,20 @@ func watchBackend(cfg *config.Config, first chan bool) {
 	// custom back end receives JSON from a remote source that contains a slice of route.RouteDef
 	// the route table is created directly from that input
 	case "custom":
-		svc := registry.Default.WatchServices()
+		svc := registry.Default().WatchServices()
 		for {
 			customBE = <-svc
 			if customBE != "OK" {
 				log.Printf("[ERROR] error during update from custom back end - %s", customBE)
+			} else {
+				warmConns.Update(route.GetTable().MinConns())
 			}
 			once.Do(func() { close(first) })
 		}
 	// all other backend types
 	default:
-		svc := registry.Default.WatchServices()
-		man := registry.Default.WatchManual()
+		svc := registry.Default().WatchServices()
+		man := registry.Default().WatchManual()
 
 		for {
 			select {
@@ -572,13 +1211,18 @@ func watchBackend(cfg *config.Config, first chan bool) {
 			if err != nil {
 				log.Printf("[WARN]: %s", err)
 			}
-			registry.Default.Register(aliases)
+			registry.Default().Register(aliases)
 			t, err := route.NewTable(tableBuffer)
 			if err != nil {
 				log.Printf("[WARN] %s", err)
 				continue
 			}
-			route.SetTable(t)
+			if err := route.SetTable(t); err != nil {
+				log.Printf("[WARN] %s", err)
+				continue
+			}
+			route.SetOverrides(mancfg)
+			warmConns.Update(t.MinConns())
 			logRoutes(t, lastTable, nextTable, cfg.Log.RoutesFormat)
 			lastTable = nextTable
 			once.Do(func() { close(first) })
@@ -586,8 +1230,83 @@ func watchBackend(cfg *config.Config, first chan bool) {
 	}
 }
 
+// loadRouteSnapshot sets the routing table from the snapshot file so
+// that requests can be routed before the registry provides the first
+// routing table. It returns false if no snapshot was loaded.
+func loadRouteSnapshot(cfg *config.Config) bool {
+	file := cfg.Routing.Snapshot.File
+	if file == "" {
+		return false
+	}
+	t, err := route.ReadSnapshot(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[WARN] Cannot load routing table snapshot. %s", err)
+		}
+		return false
+	}
+	if err := route.SetTable(t); err != nil {
+		log.Printf("[WARN] Cannot load routing table snapshot. %s", err)
+		return false
+	}
+	warmConns.Update(t.MinConns())
+	log.Printf("[INFO] Loaded routing table snapshot from %s", file)
+	return true
+}
+
+// loadBootstrapRoutes sets the routing table from the route commands
+// in the registry.startup.routes file while the registry backend is
+// not available on startup.
+func loadBootstrapRoutes(cfg *config.Config) {
+	file := cfg.Registry.StartupRoutes
+	if file == "" {
+		log.Print("[WARN] No routing table snapshot or registry.startup.routes. Serving an empty routing table")
+		return
+	}
+	t, err := route.ReadSnapshot(file)
+	if err == nil {
+		err = route.SetTable(t)
+	}
+	if err != nil {
+		log.Printf("[WARN] Cannot load bootstrap routes. %s", err)
+		return
+	}
+	warmConns.Update(t.MinConns())
+	log.Printf("[INFO] Loaded bootstrap routes from %s", file)
+}
+
+// writeRouteSnapshots writes the routing table to the snapshot file
+// when it has changed. The routing table is checked every snapshot
+// interval after the registry has provided the first routing table
+// and on exit.
+func writeRouteSnapshots(cfg *config.Config, first chan bool) {
+	var (
+		mu   sync.Mutex
+		last string
+	)
+	write := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		t := route.GetTable()
+		if next := t.String(); next != last {
+			if err := route.WriteSnapshot(cfg.Routing.Snapshot.File, t); err != nil {
+				log.Printf("[ERROR] Cannot write routing table snapshot. %s", err)
+				return
+			}
+			last = next
+		}
+	}
+
+	<-first
+	exit.Listen(func(os.Signal) { write() })
+	for {
+		write()
+		time.Sleep(cfg.Routing.Snapshot.Interval)
+	}
+}
+
 func watchNoRouteHTML(cfg *config.Config) {
-	html := registry.Default.WatchNoRouteHTML()
+	html := registry.Default().WatchNoRouteHTML()
 	for {
 		next := <-html
 		if next == noroute.GetHTML() {
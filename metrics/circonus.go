@@ -96,6 +96,34 @@ func (m *cgmRegistry) GetTimer(name string) Timer {
 	return &cgmTimer{m.metrics, metricName}
 }
 
+// GetHistogram returns a histogram for the given metric name.
+func (m *cgmRegistry) GetHistogram(name string) Histogram {
+	metricName := fmt.Sprintf("%s`%s", m.prefix, name)
+	return &cgmHistogram{m.metrics, metricName}
+}
+
+// GetTaggedTimer returns a timer for the given metric name
+// with the tags as stream tags.
+func (m *cgmRegistry) GetTaggedTimer(name string, tags []Tag) Timer {
+	var ct cgm.Tags
+	for _, t := range tags {
+		ct = append(ct, cgm.Tag{Category: t.Name, Value: t.Value})
+	}
+	metricName := m.metrics.MetricNameWithStreamTags(fmt.Sprintf("%s`%s", m.prefix, name), ct)
+	return &cgmTimer{m.metrics, metricName}
+}
+
+// GetTaggedCounter returns a counter for the given metric name
+// with the tags as stream tags.
+func (m *cgmRegistry) GetTaggedCounter(name string, tags []Tag) Counter {
+	var ct cgm.Tags
+	for _, t := range tags {
+		ct = append(ct, cgm.Tag{Category: t.Name, Value: t.Value})
+	}
+	metricName := m.metrics.MetricNameWithStreamTags(fmt.Sprintf("%s`%s", m.prefix, name), ct)
+	return &cgmCounter{m.metrics, metricName}
+}
+
 type cgmCounter struct {
 	metrics *cgm.CirconusMetrics
 	name    string
@@ -127,3 +155,13 @@ func (t *cgmTimer) Update(d time.Duration) {
 func (t *cgmTimer) UpdateSince(start time.Time) {
 	t.metrics.Timing(t.name, float64(time.Since(start)))
 }
+
+type cgmHistogram struct {
+	metrics *cgm.CirconusMetrics
+	name    string
+}
+
+// Update adds the value as a sample to the histogram.
+func (h *cgmHistogram) Update(v int64) {
+	h.metrics.RecordValue(h.name, float64(v))
+}
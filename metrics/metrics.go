@@ -53,6 +53,10 @@ func NewRegistry(cfg config.Metrics) (r Registry, err error) {
 		return nil, fmt.Errorf("metrics: invalid names template. %s", err)
 	}
 
+	if tagNames, err = parseTagNames(cfg.TagNames); err != nil {
+		return nil, fmt.Errorf("metrics: invalid tag names template. %s", err)
+	}
+
 	switch cfg.Target {
 	case "stdout":
 		log.Printf("[INFO] Sending metrics to stdout")
@@ -15,6 +15,8 @@ func (p NoopRegistry) GetCounter(name string) Counter { return noopCounter }
 
 func (p NoopRegistry) GetTimer(name string) Timer { return noopTimer }
 
+func (p NoopRegistry) GetHistogram(name string) Histogram { return noopHistogram }
+
 var noopCounter = NoopCounter{}
 
 // NoopCounter is a stub implementation of the Counter interface.
@@ -34,3 +36,10 @@ func (t NoopTimer) UpdateSince(time.Time) {}
 func (t NoopTimer) Rate1() float64 { return 0 }
 
 func (t NoopTimer) Percentile(nth float64) float64 { return 0 }
+
+var noopHistogram = NoopHistogram{}
+
+// NoopHistogram is a stub implementation of the Histogram interface.
+type NoopHistogram struct{}
+
+func (h NoopHistogram) Update(int64) {}
@@ -29,6 +29,11 @@ type Registry interface {
 	// If the metric does not exist yet it should be created
 	// otherwise the existing metric should be returned.
 	GetTimer(name string) Timer
+
+	// GetHistogram returns a histogram metric for the given name.
+	// If the metric does not exist yet it should be created
+	// otherwise the existing metric should be returned.
+	GetHistogram(name string) Histogram
 }
 
 // Counter defines a metric for counting events.
@@ -52,3 +57,10 @@ type Timer interface {
 	// as the delta between 'start' and the function is called.
 	UpdateSince(start time.Time)
 }
+
+// Histogram defines a metric for the distribution of values
+// which are not durations, e.g. sizes in bytes.
+type Histogram interface {
+	// Update records the value.
+	Update(v int64)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultTagNames contains the default template for folding
+// route metric tags into the metric name.
+const DefaultTagNames = "{{clean .Name}}_{{clean .Value}}"
+
+// tagNames stores the template for folding the route metric tags
+// into the metric name for registries which do not support tags.
+var tagNames *template.Template
+
+func init() {
+	var err error
+	if tagNames, err = parseTagNames(DefaultTagNames); err != nil {
+		panic(err)
+	}
+}
+
+// Tag is a dimension of a metric, e.g. team:payments.
+type Tag struct {
+	Name, Value string
+}
+
+// TaggedRegistry is implemented by registries which support tags
+// (dimensions) for metrics.
+type TaggedRegistry interface {
+	// GetTaggedTimer returns a timer metric for the given name
+	// and tags. If the metric does not exist yet it should be
+	// created otherwise the existing metric should be returned.
+	GetTaggedTimer(name string, tags []Tag) Timer
+
+	// GetTaggedCounter returns a counter metric for the given name
+	// and tags. If the metric does not exist yet it should be
+	// created otherwise the existing metric should be returned.
+	GetTaggedCounter(name string, tags []Tag) Counter
+}
+
+// ParseTags parses a list of tags in the form
+// name:value,name:value,... The tags are sorted by name.
+func ParseTags(s string) ([]Tag, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var tags []Tag
+	seen := map[string]bool{}
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(strings.TrimSpace(kv), ":", 2)
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, fmt.Errorf("metric tag should be in the form name:value. Got: %s", kv)
+		}
+		if seen[p[0]] {
+			return nil, fmt.Errorf("duplicate metric tag %s", p[0])
+		}
+		seen[p[0]] = true
+		tags = append(tags, Tag{Name: p[0], Value: p[1]})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// GetTimer returns the timer for the given name and tags from the
+// registry. If the registry does not support tags the tags are
+// folded into the name with the tag names template. GetTimer returns
+// the name of the timer for Registry.Unregister.
+func GetTimer(r Registry, name string, tags []Tag) (Timer, string, error) {
+	if len(tags) == 0 {
+		return r.GetTimer(name), name, nil
+	}
+	if tr, ok := r.(TaggedRegistry); ok {
+		return tr.GetTaggedTimer(name, tags), name, nil
+	}
+	name, err := TaggedName(name, tags)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.GetTimer(name), name, nil
+}
+
+// GetCounter returns the counter for the given name and tags from the
+// registry. If the registry does not support tags the tags are folded
+// into the name with the tag names template. GetCounter returns the
+// name of the counter for Registry.Unregister.
+func GetCounter(r Registry, name string, tags []Tag) (Counter, string, error) {
+	if len(tags) == 0 {
+		return r.GetCounter(name), name, nil
+	}
+	if tr, ok := r.(TaggedRegistry); ok {
+		return tr.GetTaggedCounter(name, tags), name, nil
+	}
+	name, err := TaggedName(name, tags)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.GetCounter(name), name, nil
+}
+
+// TaggedName returns the name with the tags folded into it. Each tag
+// is rendered with the tag names template and appended to the name
+// separated by a dot.
+func TaggedName(name string, tags []Tag) (string, error) {
+	if tagNames == nil {
+		return name, nil
+	}
+	var b bytes.Buffer
+	b.WriteString(name)
+	for _, tag := range tags {
+		b.WriteByte('.')
+		if err := tagNames.Execute(&b, tag); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// parseTagNames parses the route metric tag names template.
+func parseTagNames(tmpl string) (*template.Template, error) {
+	funcMap := template.FuncMap{
+		"clean": clean,
+	}
+	t, err := template.New("tagnames").Funcs(funcMap).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(new(bytes.Buffer), Tag{"team", "test"}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
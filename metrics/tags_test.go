@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		tags []Tag
+		err  error
+	}{
+		{"", nil, nil},
+		{"team:payments", []Tag{{"team", "payments"}}, nil},
+		{"tier:critical, team:payments", []Tag{{"team", "payments"}, {"tier", "critical"}}, nil},
+		{"env:a:b", []Tag{{"env", "a:b"}}, nil},
+		{"team", nil, errors.New("metric tag should be in the form name:value. Got: team")},
+		{"team:", nil, errors.New("metric tag should be in the form name:value. Got: team:")},
+		{"team:a,team:b", nil, errors.New("duplicate metric tag team")},
+	}
+
+	for i, tt := range tests {
+		tags, err := ParseTags(tt.in)
+		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got error %v want %v", i, got, want)
+		}
+		if got, want := tags, tt.tags; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestGetTimer(t *testing.T) {
+	tags := []Tag{{"team", "payments"}, {"tier", "critical.1"}}
+
+	t.Run("untagged registry", func(t *testing.T) {
+		r := &nameRegistry{}
+		_, name, err := GetTimer(r, "a.b", tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := name, "a.b.team_payments.tier_critical_1"; got != want {
+			t.Fatalf("got name %q want %q", got, want)
+		}
+		if got, want := r.names, []string{name}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("tagged registry", func(t *testing.T) {
+		r := &taggedRegistry{}
+		_, name, err := GetTimer(r, "a.b", tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := name, "a.b"; got != want {
+			t.Fatalf("got name %q want %q", got, want)
+		}
+		if got, want := r.tags, tags; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		r := &taggedRegistry{}
+		_, name, err := GetTimer(r, "a.b", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := name, "a.b"; got != want {
+			t.Fatalf("got name %q want %q", got, want)
+		}
+		if r.tags != nil {
+			t.Fatalf("got tags %v want none", r.tags)
+		}
+	})
+}
+
+func TestGetCounter(t *testing.T) {
+	tags := []Tag{{"team", "payments"}, {"status", "5xx"}}
+
+	t.Run("untagged registry", func(t *testing.T) {
+		r := &nameRegistry{}
+		_, name, err := GetCounter(r, "a.b", tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := name, "a.b.team_payments.status_5xx"; got != want {
+			t.Fatalf("got name %q want %q", got, want)
+		}
+		if got, want := r.names, []string{name}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("tagged registry", func(t *testing.T) {
+		r := &taggedRegistry{}
+		_, name, err := GetCounter(r, "a.b", tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := name, "a.b"; got != want {
+			t.Fatalf("got name %q want %q", got, want)
+		}
+		if got, want := r.tags, tags; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+}
+
+type nameRegistry struct {
+	NoopRegistry
+	names []string
+}
+
+func (r *nameRegistry) GetTimer(name string) Timer {
+	r.names = append(r.names, name)
+	return noopTimer
+}
+
+func (r *nameRegistry) GetCounter(name string) Counter {
+	r.names = append(r.names, name)
+	return noopCounter
+}
+
+type taggedRegistry struct {
+	NoopRegistry
+	tags []Tag
+}
+
+func (r *taggedRegistry) GetTaggedTimer(name string, tags []Tag) Timer {
+	r.tags = tags
+	return noopTimer
+}
+
+func (r *taggedRegistry) GetTaggedCounter(name string, tags []Tag) Counter {
+	r.tags = tags
+	return noopCounter
+}
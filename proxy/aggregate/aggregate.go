@@ -0,0 +1,35 @@
+// Package aggregate provides aggregators which send a request to all
+// targets of a route and combine the responses into one response, e.g.
+// for endpoints which collect the results of several backends.
+//
+// A route uses an aggregator with the 'aggregate=<name>' option.
+// Custom aggregators can be added to the Aggregators map in an
+// init function.
+package aggregate
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// Fetch sends a copy of the client request to the target and returns
+// the response. The caller must close the response body.
+type Fetch func(t *route.Target) (*http.Response, error)
+
+// Aggregator combines the responses of the targets of a route.
+//
+// Aggregate receives the client request, the targets of the route
+// which have not been ejected by the outlier detection and the
+// function which sends the request to a target. The request body has
+// already been read and is sent with every fetch. An error is returned
+// to the client as 502 Bad Gateway.
+type Aggregator interface {
+	Aggregate(r *http.Request, targets []*route.Target, fetch Fetch) (*http.Response, error)
+}
+
+// Aggregators contains the aggregators which can be
+// referenced in the 'aggregate' option of a route.
+var Aggregators = map[string]Aggregator{
+	"jsonconcat": JSONConcat{},
+}
@@ -0,0 +1,99 @@
+package aggregate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// JSONConcat sends the request to all targets in parallel and
+// concatenates the JSON arrays of their responses in the order of
+// the targets. All targets must respond with a 2xx status code and
+// a JSON array.
+//
+//	[1,2] + [3] + [] -> [1,2,3]
+type JSONConcat struct{}
+
+func (JSONConcat) Aggregate(r *http.Request, targets []*route.Target, fetch Fetch) (*http.Response, error) {
+	results := make([][]json.RawMessage, len(targets))
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *route.Target) {
+			defer wg.Done()
+			results[i], errs[i] = fetchJSONArray(t, fetch)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var all []json.RawMessage
+	for i := range targets {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		all = append(all, results[i]...)
+	}
+	if all == nil {
+		all = []json.RawMessage{}
+	}
+
+	b, err := json.Marshal(all)
+	if err != nil {
+		return nil, err
+	}
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", strconv.Itoa(len(b)))
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+	}, nil
+}
+
+// fetchJSONArray returns the elements of the JSON array
+// in the response of the target.
+func fetchJSONArray(t *route.Target, fetch Fetch) ([]json.RawMessage, error) {
+	resp, err := fetch(t)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate: cannot fetch %s. %s", t.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("aggregate: %s responded with status %d", t.URL, resp.StatusCode)
+	}
+	if !isJSON(resp.Header.Get("Content-Type")) {
+		return nil, fmt.Errorf("aggregate: %s responded with content type %q", t.URL, resp.Header.Get("Content-Type"))
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate: cannot read response from %s. %s", t.URL, err)
+	}
+	var v []json.RawMessage
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("aggregate: response from %s is not a JSON array. %s", t.URL, err)
+	}
+	return v, nil
+}
+
+// isJSON returns true for the application/json media type
+// and media types with the +json suffix.
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,86 @@
+package aggregate
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestJSONConcat(t *testing.T) {
+	ag := Aggregators["jsonconcat"]
+
+	type response struct {
+		status      int
+		contentType string
+		body        string
+		err         error
+	}
+
+	tests := []struct {
+		desc      string
+		responses []response
+		out       string
+		err       bool
+	}{
+		{
+			"concat",
+			[]response{
+				{200, "application/json", `[1,{"a":2}]`, nil},
+				{200, "application/json; charset=utf-8", `[]`, nil},
+				{203, "application/vnd.foo+json", `["b"]`, nil},
+			},
+			`[1,{"a":2},"b"]`, false,
+		},
+		{"no elements", []response{{200, "application/json", `[]`, nil}}, `[]`, false},
+		{"no targets", nil, `[]`, false},
+		{"error status", []response{{200, "application/json", `[1]`, nil}, {500, "application/json", `[2]`, nil}}, ``, true},
+		{"not json", []response{{200, "text/plain", `[1]`, nil}}, ``, true},
+		{"not an array", []response{{200, "application/json", `{"a":1}`, nil}}, ``, true},
+		{"fetch error", []response{{err: errors.New("connection refused")}}, ``, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var targets []*route.Target
+			responses := map[*route.Target]response{}
+			for i, r := range tt.responses {
+				tg := &route.Target{URL: &url.URL{Scheme: "http", Host: "host" + string(rune('a'+i))}}
+				targets = append(targets, tg)
+				responses[tg] = r
+			}
+			fetch := func(tg *route.Target) (*http.Response, error) {
+				r := responses[tg]
+				if r.err != nil {
+					return nil, r.err
+				}
+				rec := httptest.NewRecorder()
+				rec.Header().Set("Content-Type", r.contentType)
+				rec.WriteHeader(r.status)
+				rec.WriteString(r.body)
+				return rec.Result(), nil
+			}
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			resp, err := ag.Aggregate(req, targets, fetch)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if tt.err {
+				return
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			if got, want := string(b), tt.out; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Type"), "application/json"; !strings.HasPrefix(got, want) {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"google.golang.org/grpc"
+)
+
+// defaultALPN contains the handlers for the protocols which are
+// negotiated with ALPN unless the listener configures them.
+var defaultALPN = []config.ALPN{
+	{Proto: "h2", Handler: "http"},
+	{Proto: "http/1.1", Handler: "http"},
+}
+
+// alpnHandshakeTimeout is the time a client has for the TLS handshake
+// if the listener has no read timeout.
+const alpnHandshakeTimeout = 10 * time.Second
+
+// ListenAndServeALPN serves HTTPS and gRPC on the same listener. The
+// connections are dispatched to the HTTP or the gRPC proxy by the
+// protocol which was negotiated with ALPN during the TLS handshake.
+// Connections to the HTTP proxy which use HTTP/2 can also carry gRPC
+// requests which are passed to the gRPC proxy.
+func ListenAndServeALPN(l config.Listen, h http.Handler, opts []grpc.ServerOption, cfg *tls.Config) error {
+	srv := newALPNServer(l, h, opts, cfg)
+	ln, err := ListenTCP(l, cfg)
+	if err != nil {
+		return err
+	}
+	return serve(ln, srv)
+}
+
+// newALPNServer creates the server for the listener and sets the
+// protocols which are offered during the TLS handshake on the config.
+// The protocols of the listener are preferred over the defaults.
+func newALPNServer(l config.Listen, h http.Handler, opts []grpc.ServerOption, cfg *tls.Config) *alpnServer {
+	handlers := map[string]string{}
+	var protos []string
+	for _, a := range append(l.ALPN, defaultALPN...) {
+		if _, ok := handlers[a.Proto]; ok {
+			continue
+		}
+		handlers[a.Proto] = a.Handler
+		protos = append(protos, a.Proto)
+	}
+	cfg.NextProtos = protos
+
+	timeout := l.ReadTimeout
+	if timeout <= 0 {
+		timeout = alpnHandshakeTimeout
+	}
+
+	g := grpc.NewServer(opts...)
+	return &alpnServer{
+		handlers: handlers,
+		timeout:  timeout,
+		http: &http.Server{
+			Addr:              l.Addr,
+			Handler:           grpcOrHTTP(g, h),
+			ReadTimeout:       l.ReadTimeout,
+			ReadHeaderTimeout: l.ReadHeaderTimeout,
+			WriteTimeout:      l.WriteTimeout,
+			IdleTimeout:       l.IdleTimeout,
+			ConnContext:       connContext,
+			// the http server enables HTTP/2 on its own copy
+			// of the config which is not used for handshakes.
+			TLSConfig: cfg.Clone(),
+		},
+		grpc:   g,
+		httpLn: newConnListener(),
+		grpcLn: newConnListener(),
+	}
+}
+
+// grpcOrHTTP sends gRPC requests over HTTP/2 to the gRPC server
+// and all other requests to the HTTP handler.
+func grpcOrHTTP(g *grpc.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			g.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// alpnServer completes the TLS handshake of the accepted connections
+// and passes them to the HTTP or the gRPC server depending on the
+// negotiated protocol. Connections without a negotiated protocol are
+// passed to the HTTP server.
+type alpnServer struct {
+	handlers map[string]string
+	timeout  time.Duration
+
+	http   *http.Server
+	grpc   *grpc.Server
+	httpLn *connListener
+	grpcLn *connListener
+
+	mu sync.Mutex
+	ln net.Listener
+}
+
+func (s *alpnServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	s.httpLn.addr = ln.Addr()
+	s.grpcLn.addr = ln.Addr()
+
+	go s.http.Serve(s.httpLn)
+	go s.grpc.Serve(s.grpcLn)
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.httpLn.Close()
+			s.grpcLn.Close()
+			return err
+		}
+		go s.dispatch(c)
+	}
+}
+
+func (s *alpnServer) dispatch(c net.Conn) {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		s.httpLn.put(c)
+		return
+	}
+
+	tc.SetDeadline(time.Now().Add(s.timeout))
+	if err := tc.Handshake(); err != nil {
+		log.Printf("[DEBUG] alpn: TLS handshake with %s failed. %s", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	tc.SetDeadline(time.Time{})
+
+	if s.handlers[tc.ConnectionState().NegotiatedProtocol] == "grpc" {
+		s.grpcLn.put(c)
+		return
+	}
+	s.httpLn.put(c)
+}
+
+func (s *alpnServer) closeListener() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+}
+
+func (s *alpnServer) Close() error {
+	s.closeListener()
+	s.grpc.Stop()
+	return s.http.Close()
+}
+
+func (s *alpnServer) Shutdown(ctx context.Context) error {
+	s.closeListener()
+	s.grpc.GracefulStop()
+	return s.http.Shutdown(ctx)
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+// connListener is a net.Listener which returns
+// the connections which are passed to put.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// put passes the connection to Accept or closes it
+// if the listener has been closed.
+func (l *connListener) put(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestALPNServer(t *testing.T) {
+	l := config.Listen{
+		Addr:  "127.0.0.1:57781",
+		Proto: "https+alpn",
+		ALPN:  []config.ALPN{{Proto: "x-grpc", Handler: "grpc"}},
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	opts := []grpc.ServerOption{
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			return status.Error(codes.Unavailable, "grpc")
+		}),
+	}
+	cfg := tlsServerConfig()
+
+	srv := newALPNServer(l, h, opts, cfg)
+	ln, err := ListenTCP(l, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	addr := l.Addr
+
+	t.Run("http/1.1", func(t *testing.T) {
+		clientCfg := tlsClientConfig()
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if got, want := string(body), "HTTP/1.1"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+
+	grpcCall := func(t *testing.T, protos []string) {
+		clientCfg := tlsClientConfig()
+		clientCfg.NextProtos = protos
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithBlock(), grpc.WithTransportCredentials(credentials.NewTLS(clientCfg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		err = conn.Invoke(ctx, "/test.Service/Method", &durationpb.Duration{}, &durationpb.Duration{})
+		if got, want := status.Code(err), codes.Unavailable; got != want {
+			t.Fatalf("got code %v want %v: %v", got, want, err)
+		}
+	}
+
+	t.Run("grpc via alpn", func(t *testing.T) {
+		grpcCall(t, []string{"x-grpc"})
+	})
+
+	t.Run("grpc via h2", func(t *testing.T) {
+		grpcCall(t, nil)
+	})
+
+	t.Run("next protos", func(t *testing.T) {
+		clientCfg := tlsClientConfig()
+		clientCfg.NextProtos = []string{"x-grpc", "h2", "http/1.1"}
+		c, err := tls.Dial("tcp", addr, clientCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if got, want := c.ConnectionState().NegotiatedProtocol, "x-grpc"; got != want {
+			t.Fatalf("got protocol %q want %q", got, want)
+		}
+	})
+}
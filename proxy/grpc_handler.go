@@ -3,6 +3,7 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fabiolb/fabio/config"
@@ -104,6 +106,13 @@ func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream,
 		return status.Error(codes.NotFound, "no route found")
 	}
 
+	release, err := g.StatsHandler.acquireStream(ctx, target)
+	if err != nil {
+		log.Printf("[WARN] grpc: rejecting stream for %s. %s", info.FullMethod, err)
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	defer release()
+
 	ctx = context.WithValue(ctx, targetKey{}, target)
 
 	proxyStream := proxyStream{
@@ -124,7 +133,6 @@ func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream,
 }
 
 func (g GrpcProxyInterceptor) lookup(ctx context.Context, fullMethodName string) (*route.Target, error) {
-	pick := route.Picker[g.Config.Proxy.Strategy]
 	match := route.Matcher[g.Config.Proxy.Matcher]
 
 	md, ok := metadata.FromIncomingContext(ctx)
@@ -154,20 +162,81 @@ func (g GrpcProxyInterceptor) lookup(ctx context.Context, fullMethodName string)
 		Header: headers,
 	}
 
-	return route.GetTable().Lookup(req, req.Header.Get("trace"), pick, match, g.GlobCache, g.Config.GlobMatchingDisabled), nil
+	pick := route.RequestPicker(g.Config.Proxy.Strategy, req)
+	t := route.GetTable().Lookup(req, req.Header.Get("trace"), pick, match, g.GlobCache, g.Config.GlobMatchingDisabled)
+
+	// server reflection requests without a route of their own are sent
+	// to a target of the configured route which supports reflection.
+	if t == nil && g.Config.Proxy.GRPCReflection != "" && isReflectionMethod(fullMethodName) {
+		req.URL = &url.URL{Path: g.Config.Proxy.GRPCReflection}
+		t = route.GetTable().Lookup(req, req.Header.Get("trace"), pick, match, g.GlobCache, g.Config.GlobMatchingDisabled)
+	}
+	return t, nil
+}
+
+// isReflectionMethod returns true for the methods of the
+// v1 and v1alpha gRPC server reflection services.
+func isReflectionMethod(fullMethodName string) bool {
+	return strings.HasPrefix(fullMethodName, "/grpc.reflection.v1.ServerReflection/") ||
+		strings.HasPrefix(fullMethodName, "/grpc.reflection.v1alpha.ServerReflection/")
 }
 
 type GrpcStatsHandler struct {
 	Connect metrics.Counter
 	Request metrics.Timer
 	NoRoute metrics.Counter
+
+	// MaxConn is the maximum number of concurrent client connections.
+	// Streams on connections above the limit are rejected with
+	// RESOURCE_EXHAUSTED. A value of 0 means unlimited.
+	MaxConn int
+
+	// MaxStreams is the maximum number of concurrent streams per client
+	// connection. A value of 0 means unlimited.
+	MaxStreams int
+
+	// ActiveConn tracks the number of open client connections.
+	ActiveConn metrics.Counter
+
+	// ActiveStreams tracks the number of active streams.
+	ActiveStreams metrics.Counter
+
+	// Rejected counts the streams rejected because a limit was reached.
+	Rejected metrics.Counter
+
+	// conns is the number of open client connections.
+	conns int64
+
+	// mu guards routeStreams
+	mu sync.Mutex
+
+	// routeStreams contains the number of active streams per route.
+	routeStreams map[string]int
 }
 
 type connCtxKey struct{}
 type rpcCtxKey struct{}
 
+// grpcConn stores the per connection state for the limits.
+type grpcConn struct {
+	info      *stats.ConnTagInfo
+	streams   int64
+	overLimit bool
+}
+
+var (
+	errGrpcMaxConn         = errors.New("too many connections")
+	errGrpcMaxStreams      = errors.New("too many concurrent streams on connection")
+	errGrpcMaxRouteStreams = errors.New("too many concurrent streams for route")
+)
+
 func (h *GrpcStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
-	return context.WithValue(ctx, connCtxKey{}, info)
+	c := &grpcConn{info: info}
+	n := atomic.AddInt64(&h.conns, 1)
+	if h.MaxConn > 0 && n > int64(h.MaxConn) {
+		c.overLimit = true
+	}
+	return context.WithValue(ctx, connCtxKey{}, c)
 }
 
 func (h *GrpcStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
@@ -191,23 +260,117 @@ func (h *GrpcStatsHandler) HandleRPC(ctx context.Context, rpc stats.RPCStats) {
 
 // HandleConn processes the Conn stats.
 func (h *GrpcStatsHandler) HandleConn(ctx context.Context, conn stats.ConnStats) {
-	connBegin, _ := conn.(*stats.ConnBegin)
-
-	if connBegin != nil {
+	switch conn.(type) {
+	case *stats.ConnBegin:
 		h.Connect.Inc(1)
+		if h.ActiveConn != nil {
+			h.ActiveConn.Inc(1)
+		}
+	case *stats.ConnEnd:
+		atomic.AddInt64(&h.conns, -1)
+		if h.ActiveConn != nil {
+			h.ActiveConn.Inc(-1)
+		}
+	}
+}
+
+// acquireStream reserves a stream slot for the connection in ctx and the
+// route of the target. It returns an error if one of the limits has been
+// reached. Otherwise, the returned function must be called when the stream
+// has finished.
+func (h *GrpcStatsHandler) acquireStream(ctx context.Context, t *route.Target) (release func(), err error) {
+	c, _ := ctx.Value(connCtxKey{}).(*grpcConn)
+
+	reject := func(err error) (func(), error) {
+		if h.Rejected != nil {
+			h.Rejected.Inc(1)
+		}
+		return nil, err
+	}
+
+	if c != nil && c.overLimit {
+		return reject(errGrpcMaxConn)
+	}
+
+	if c != nil && h.MaxStreams > 0 {
+		if n := atomic.AddInt64(&c.streams, 1); n > int64(h.MaxStreams) {
+			atomic.AddInt64(&c.streams, -1)
+			return reject(errGrpcMaxStreams)
+		}
+	}
+
+	if t.MaxStreams > 0 {
+		h.mu.Lock()
+		if h.routeStreams == nil {
+			h.routeStreams = map[string]int{}
+		}
+		if h.routeStreams[t.Prefix] >= t.MaxStreams {
+			h.mu.Unlock()
+			if c != nil && h.MaxStreams > 0 {
+				atomic.AddInt64(&c.streams, -1)
+			}
+			return reject(errGrpcMaxRouteStreams)
+		}
+		h.routeStreams[t.Prefix]++
+		h.mu.Unlock()
+	}
+
+	if h.ActiveStreams != nil {
+		h.ActiveStreams.Inc(1)
+	}
+
+	release = func() {
+		if h.ActiveStreams != nil {
+			h.ActiveStreams.Inc(-1)
+		}
+		if c != nil && h.MaxStreams > 0 {
+			atomic.AddInt64(&c.streams, -1)
+		}
+		if t.MaxStreams > 0 {
+			h.mu.Lock()
+			if h.routeStreams[t.Prefix]--; h.routeStreams[t.Prefix] <= 0 {
+				delete(h.routeStreams, t.Prefix)
+			}
+			h.mu.Unlock()
+		}
 	}
+	return release, nil
 }
 
+// grpcConnectionPool keeps the connections to the upstream servers of
+// the gRPC targets. The connections are keyed by the address and the TLS
+// settings of the target and not by the target itself since the targets
+// are created again whenever the routing table is rebuilt. Therefore, the
+// connections are kept across rebuilds as long as the routing table has a
+// target for them and only the connections of removed targets are closed.
 type grpcConnectionPool struct {
-	connections     map[*route.Target]*grpc.ClientConn
+	connections     map[grpcConnKey]*grpc.ClientConn
 	lock            sync.RWMutex
 	cleanupInterval time.Duration
 	tlscfg          *tls.Config
 }
 
+// grpcConnKey identifies the connection to the upstream server
+// of a target.
+type grpcConnKey struct {
+	scheme        string
+	host          string
+	tlsSkipVerify bool
+	serverName    string
+}
+
+func grpcKey(t *route.Target) grpcConnKey {
+	return grpcConnKey{
+		scheme:        t.URL.Scheme,
+		host:          t.URL.Host,
+		tlsSkipVerify: t.TLSSkipVerify,
+		serverName:    t.Opts["grpcservername"],
+	}
+}
+
 func newGrpcConnectionPool(tlscfg *tls.Config) *grpcConnectionPool {
 	cp := &grpcConnectionPool{
-		connections:     make(map[*route.Target]*grpc.ClientConn),
+		connections:     make(map[grpcConnKey]*grpc.ClientConn),
 		lock:            sync.RWMutex{},
 		cleanupInterval: time.Second * 5,
 		tlscfg:          tlscfg,
@@ -220,7 +383,7 @@ func newGrpcConnectionPool(tlscfg *tls.Config) *grpcConnectionPool {
 
 func (p *grpcConnectionPool) Get(ctx context.Context, target *route.Target) (*grpc.ClientConn, error) {
 	p.lock.RLock()
-	conn := p.connections[target]
+	conn := p.connections[grpcKey(target)]
 	p.lock.RUnlock()
 
 	if conn != nil && conn.GetState() != connectivity.Shutdown {
@@ -252,48 +415,63 @@ func (p *grpcConnectionPool) newConnection(ctx context.Context, target *route.Ta
 	conn, err := grpc.DialContext(ctx, target.URL.Host, opts...)
 
 	if err == nil {
-		p.Set(target, conn)
+		conn = p.Set(target, conn)
 	}
 
 	return conn, err
 }
 
-func (p *grpcConnectionPool) Set(target *route.Target, conn *grpc.ClientConn) {
+// Set stores the connection for the target and returns it. If another
+// request has already stored an open connection for the same upstream
+// server the new connection is closed and the stored one is returned.
+func (p *grpcConnectionPool) Set(target *route.Target, conn *grpc.ClientConn) *grpc.ClientConn {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	p.connections[target] = conn
+	key := grpcKey(target)
+	if cur := p.connections[key]; cur != nil && cur != conn && cur.GetState() != connectivity.Shutdown {
+		conn.Close()
+		return cur
+	}
+	p.connections[key] = conn
+	return conn
 }
 
 func (p *grpcConnectionPool) cleanup() {
 	for {
-		p.lock.Lock()
-		table := route.GetTable()
-		for target, cs := range p.connections {
-			if cs.GetState() == connectivity.Shutdown {
-				delete(p.connections, target)
-				continue
-			}
+		p.prune(route.GetTable())
+		time.Sleep(p.cleanupInterval)
+	}
+}
 
-			if !hasTarget(target, table) {
-				log.Println("[DEBUG] grpc: cleaning up connection to", target.URL.Host)
-				cs.Close()
-				delete(p.connections, target)
-			}
+// prune removes the closed connections and closes the connections
+// for which the routing table has no target anymore.
+func (p *grpcConnectionPool) prune(table route.Table) {
+	keys := grpcKeys(table)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, cs := range p.connections {
+		if cs.GetState() == connectivity.Shutdown {
+			delete(p.connections, key)
+			continue
+		}
+
+		if !keys[key] {
+			log.Println("[DEBUG] grpc: cleaning up connection to", key.host)
+			cs.Close()
+			delete(p.connections, key)
 		}
-		p.lock.Unlock()
-		time.Sleep(p.cleanupInterval)
 	}
 }
 
-func hasTarget(target *route.Target, table route.Table) bool {
+// grpcKeys returns the connection keys of all targets of the table.
+func grpcKeys(table route.Table) map[grpcConnKey]bool {
+	keys := map[grpcConnKey]bool{}
 	for _, routes := range table {
 		for _, r := range routes {
 			for _, t := range r.Targets {
-				if target == t {
-					return true
-				}
+				keys[grpcKey(t)] = true
 			}
 		}
 	}
-	return false
+	return keys
 }
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+func TestGrpcStatsHandlerLimits(t *testing.T) {
+	t.Run("maxconn", func(t *testing.T) {
+		h := &GrpcStatsHandler{MaxConn: 1}
+		ctx1 := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+		ctx2 := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+		tg := &route.Target{}
+
+		release, err := h.acquireStream(ctx1, tg)
+		if err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+		release()
+		if _, err := h.acquireStream(ctx2, tg); err != errGrpcMaxConn {
+			t.Fatalf("got %v want %v", err, errGrpcMaxConn)
+		}
+	})
+
+	t.Run("maxstreams", func(t *testing.T) {
+		h := &GrpcStatsHandler{MaxStreams: 1}
+		ctx := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+		tg := &route.Target{}
+
+		release, err := h.acquireStream(ctx, tg)
+		if err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+		if _, err := h.acquireStream(ctx, tg); err != errGrpcMaxStreams {
+			t.Fatalf("got %v want %v", err, errGrpcMaxStreams)
+		}
+		release()
+		if _, err := h.acquireStream(ctx, tg); err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+	})
+
+	t.Run("route maxstreams", func(t *testing.T) {
+		h := &GrpcStatsHandler{}
+		ctx1 := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+		ctx2 := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+		tg := &route.Target{Prefix: "/foo.Service", MaxStreams: 1}
+
+		release, err := h.acquireStream(ctx1, tg)
+		if err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+		if _, err := h.acquireStream(ctx2, tg); err != errGrpcMaxRouteStreams {
+			t.Fatalf("got %v want %v", err, errGrpcMaxRouteStreams)
+		}
+		release()
+		if _, err := h.acquireStream(ctx2, tg); err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+	})
+}
+
+func TestGrpcLookupReflection(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString("route add svc /my.pkg.Service grpc://127.0.0.1:5000 opts \"proto=grpc\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldTable := route.GetTable()
+	route.SetTable(tbl)
+	defer route.SetTable(oldTable)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{})
+	newInterceptor := func(reflection string) GrpcProxyInterceptor {
+		return GrpcProxyInterceptor{
+			Config:    &config.Config{Proxy: config.Proxy{Matcher: "prefix", Strategy: "rr", GRPCReflection: reflection}},
+			GlobCache: globCache,
+		}
+	}
+
+	tests := []struct {
+		desc, reflection, method string
+		found                    bool
+	}{
+		{"service", "", "/my.pkg.Service/Get", true},
+		{"reflection disabled", "", "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", false},
+		{"reflection v1alpha", "/my.pkg.Service", "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", true},
+		{"reflection v1", "/my.pkg.Service", "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", true},
+		{"other service", "/my.pkg.Service", "/other.Service/Get", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tg, err := newInterceptor(tt.reflection).lookup(ctx, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tg != nil, tt.found; got != want {
+				t.Fatalf("got target %v want %v", got, want)
+			}
+			if tg != nil && tg.URL.Host != "127.0.0.1:5000" {
+				t.Fatalf("got target %s", tg.URL)
+			}
+		})
+	}
+}
+
+func TestGrpcConnectionPoolKeepsConnectionsAcrossRebuilds(t *testing.T) {
+	mustTable := func(s string) route.Table {
+		tbl, err := route.NewTable(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+	dial := func(tg *route.Target) *grpc.ClientConn {
+		conn, err := grpc.Dial(tg.URL.Host, grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	target := func(tbl route.Table, path string) *route.Target {
+		for _, routes := range tbl {
+			for _, r := range routes {
+				if r.Path == path {
+					return r.Targets[0]
+				}
+			}
+		}
+		t.Fatalf("no route for %s", path)
+		return nil
+	}
+
+	old := mustTable("route add a /foo http://127.0.0.1:5001/ opts \"proto=grpc\"\nroute add b /bar http://127.0.0.1:5002/ opts \"proto=grpc\"")
+	foo, bar := target(old, "/foo"), target(old, "/bar")
+
+	p := &grpcConnectionPool{connections: map[grpcConnKey]*grpc.ClientConn{}}
+	fooConn := p.Set(foo, dial(foo))
+	barConn := p.Set(bar, dial(bar))
+
+	// a second connection for the same upstream server is discarded
+	if got := p.Set(foo, dial(foo)); got != fooConn {
+		t.Fatal("duplicate connection replaced the pooled one")
+	}
+
+	// the rebuilt table has new targets for /foo and no route for /bar
+	tbl := mustTable("route add a /foo http://127.0.0.1:5001/ opts \"proto=grpc\"")
+	newFoo := target(tbl, "/foo")
+	if newFoo == foo {
+		t.Fatal("expected a new target after the rebuild")
+	}
+	p.prune(tbl)
+
+	if got := p.connections[grpcKey(newFoo)]; got != fooConn {
+		t.Fatal("connection to /foo was not kept")
+	}
+	if got, want := len(p.connections), 1; got != want {
+		t.Fatalf("got %d connections want %d", got, want)
+	}
+	if got, want := barConn.GetState(), connectivity.Shutdown; got != want {
+		t.Fatalf("got state %v want %v", got, want)
+	}
+	fooConn.Close()
+}
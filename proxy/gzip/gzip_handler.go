@@ -97,6 +97,22 @@ func (grw *GzipResponseWriter) Close() {
 	}
 }
 
+// Flush sends the buffered compressed data to the client. Without it
+// streaming responses are delayed and the reverse proxy cannot force
+// the chunked encoding which is required for trailers which were not
+// announced in the response headers.
+func (grw *GzipResponseWriter) Flush() {
+	if grw.writer == nil {
+		grw.WriteHeader(http.StatusOK)
+	}
+	if grw.gzipWriter != nil {
+		grw.gzipWriter.Flush()
+	}
+	if fl, ok := grw.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
 func (grw *GzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := grw.ResponseWriter.(http.Hijacker); ok {
 		return hj.Hijack()
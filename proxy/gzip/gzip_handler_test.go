@@ -109,6 +109,33 @@ func Test_GzipHandler_NonCompressableType(t *testing.T) {
 	assertEqual(bytes, []byte{42})
 }
 
+func Test_GzipHandler_Flush(t *testing.T) {
+	server := httptest.NewServer(NewGzipHandler(test_flush_handler(), contentTypes))
+
+	assertEqual := assert.Equal(t)
+
+	r, err := http.NewRequest("GET", server.URL, nil)
+	assertEqual(err, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(r)
+	assertEqual(err, nil)
+
+	// a flushed response is sent with chunked encoding
+	// which allows the trailer to be sent.
+	assertEqual(resp.Header.Get("Content-Encoding"), "gzip")
+	assertEqual(resp.ContentLength, int64(-1))
+
+	reader, err := gzip.NewReader(resp.Body)
+	assertEqual(err, nil)
+	defer reader.Close()
+
+	bytes, err := ioutil.ReadAll(reader)
+	assertEqual(err, nil)
+	assertEqual(string(bytes), "Hello World")
+	assertEqual(resp.Trailer.Get("X-Trailer"), "foo")
+}
+
 func test_text_handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		b := []byte("Hello World")
@@ -117,6 +144,15 @@ func test_text_handler() http.Handler {
 	})
 }
 
+func test_flush_handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Hello World"))
+		w.(http.Flusher).Flush()
+		w.Header().Set(http.TrailerPrefix+"X-Trailer", "foo")
+	})
+}
+
 func test_binary_handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/jpg")
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/proxy/aggregate"
+	"github.com/fabiolb/fabio/route"
+)
+
+// serveAggregate sends the request to all targets of the route of t
+// with the aggregator of the 'aggregate' option and writes the combined
+// response. Targets which have been ejected by the outlier detection
+// are skipped unless all targets have been ejected.
+//
+// The request body is buffered up to AggregateMaxBody bytes and larger
+// requests are rejected. The upstream requests carry the headers which
+// were added for the request but not its hop-by-hop headers. They use
+// the connection pools of the proxy but not the upstream proxy, the
+// fallback, the retry and the transform options of the targets.
+func (p *HTTPProxy) serveAggregate(rw *responseWriter, r *http.Request, t *route.Target, requestURL *url.URL, requestID string) {
+	ag := aggregate.Aggregators[t.Aggregate]
+	if ag == nil {
+		log.Printf("[ERROR] Unknown aggregator %q for %s", t.Aggregate, t.URL)
+		http.Error(rw, "unknown aggregator", http.StatusInternalServerError)
+		return
+	}
+
+	body, ok, err := readBody(r, p.Config.AggregateMaxBody)
+	if err != nil {
+		http.Error(rw, "cannot read request body", http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if prior := r.Header["X-Forwarded-For"]; len(prior) > 0 {
+		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	}
+
+	var targets []*route.Target
+	for _, pt := range t.Peers() {
+		if !pt.Ejected() {
+			targets = append(targets, pt)
+		}
+	}
+	if len(targets) == 0 {
+		targets = t.Peers()
+	}
+
+	tp := p.transports(r)
+	fetch := func(pt *route.Target) (*http.Response, error) {
+		tr := tp.Transport
+		if pt.TLSSkipVerify {
+			tr = tp.InsecureTransport
+		}
+		if len(pt.Pins) > 0 {
+			tr = p.pinTransport(tr, pt.Pins)
+		}
+		out := r.Clone(r.Context())
+		out.URL = upstreamURL(r, pt.URL, pt.StripPath)
+		out.RequestURI = ""
+		for _, h := range hopHeaders {
+			out.Header.Del(h)
+		}
+		out.Header.Set("X-Forwarded-For", clientIP)
+		switch {
+		case pt.Host == "dst":
+			out.Host = out.URL.Host
+		case pt.Host != "":
+			out.Host = pt.Host
+		default:
+			out.Host = requestURL.Host
+		}
+		if body != nil {
+			out.Body = ioutil.NopCloser(bytes.NewReader(body))
+			out.ContentLength = int64(len(body))
+		}
+		// the transport decompresses the response if it
+		// has requested the compression itself.
+		out.Header.Del("Accept-Encoding")
+		return tr.RoundTrip(out)
+	}
+
+	timeNow := p.Time
+	if timeNow == nil {
+		timeNow = time.Now
+	}
+
+	start := timeNow()
+	resp, err := ag.Aggregate(r, targets, fetch)
+	if err != nil {
+		log.Printf("[WARN] Cannot aggregate responses for %s. %s", requestURL, err)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	} else {
+		for k, v := range resp.Header {
+			rw.Header()[k] = v
+		}
+		rw.WriteHeader(resp.StatusCode)
+		io.Copy(rw, resp.Body)
+		resp.Body.Close()
+	}
+	end := timeNow()
+	dur := end.Sub(start)
+
+	if p.Requests != nil {
+		p.Requests.Update(dur)
+	}
+	metrics.DefaultRegistry.GetTimer(key(rw.code)).Update(dur)
+
+	if p.Logger != nil {
+		p.Logger.Log(&logger.Event{
+			Start:   start,
+			End:     end,
+			Request: r,
+			Response: &http.Response{
+				StatusCode:    rw.code,
+				ContentLength: int64(rw.size),
+			},
+			RequestURL:      requestURL,
+			UpstreamService: t.Service,
+			RequestID:       requestID,
+			LogTarget:       t.LogTarget,
+		})
+	}
+}
+
+// hopHeaders are the hop-by-hop headers of RFC 7230 which are
+// not sent to the targets.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+	"golang.org/x/net/http2"
+)
+
+// h2cRetry is the time after which another h2c connection attempt is made
+// to an upstream server which did not support it before.
+const h2cRetry = time.Minute
+
+// autoProtoTransport is an http.RoundTripper which negotiates the protocol
+// with the upstream server. For TLS connections HTTP/2 is negotiated via
+// ALPN and for cleartext connections an HTTP/2 connection with prior
+// knowledge (h2c) is attempted. In both cases the transport falls back to
+// HTTP/1.1 if the upstream server does not support HTTP/2.
+type autoProtoTransport struct {
+	// h1 is the HTTP/1.1 transport for cleartext connections.
+	h1 *http.Transport
+
+	// tls is the transport for TLS connections which negotiates
+	// HTTP/2 via ALPN.
+	tls *http.Transport
+
+	// h2c is the HTTP/2 transport for cleartext connections.
+	h2c *http2.Transport
+
+	// mu guards h2cHosts and http1Hosts
+	mu sync.Mutex
+
+	// h2cHosts contains the upstream hosts which support h2c.
+	h2cHosts map[string]bool
+
+	// http1Hosts contains the upstream hosts which did not support h2c
+	// and the time when the next h2c connection attempt can be made.
+	http1Hosts map[string]time.Time
+}
+
+// NewAutoProtoTransport returns a transport for targets with the
+// 'proto=auto' option which is based on the given HTTP/1.1 transport.
+func NewAutoProtoTransport(tr *http.Transport) http.RoundTripper {
+	tlstr := tr.Clone()
+	tlstr.ForceAttemptHTTP2 = true
+
+	dial := func(network, addr string) (net.Conn, error) {
+		switch {
+		case tr.DialContext != nil:
+			return tr.DialContext(context.Background(), network, addr)
+		case tr.Dial != nil:
+			return tr.Dial(network, addr)
+		default:
+			return net.Dial(network, addr)
+		}
+	}
+
+	return &autoProtoTransport{
+		h1:  tr,
+		tls: tlstr,
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(network, addr)
+			},
+		},
+		h2cHosts:   map[string]bool{},
+		http1Hosts: map[string]time.Time{},
+	}
+}
+
+func (t *autoProtoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		return t.tls.RoundTrip(req)
+	}
+
+	// Requests with a body cannot be replayed on HTTP/1.1 if the h2c
+	// attempt fails. Therefore, only requests without a body are used
+	// to detect whether the upstream server supports h2c.
+	host := req.URL.Host
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if t.isHTTP1(host) || hasBody && !t.isH2C(host) {
+		return t.h1.RoundTrip(req)
+	}
+
+	resp, err := t.h2c.RoundTrip(req)
+	if err == nil {
+		t.setH2C(host)
+		return resp, nil
+	}
+	// the upstream server supports h2c if it sent a GOAWAY frame,
+	// e.g. during a graceful shutdown.
+	if hasBody || isGoAway(err) {
+		return nil, err
+	}
+	t.setHTTP1(host)
+	return t.h1.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of all transports.
+func (t *autoProtoTransport) CloseIdleConnections() {
+	t.h1.CloseIdleConnections()
+	t.tls.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+func (t *autoProtoTransport) isHTTP1(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.http1Hosts[host]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(t.http1Hosts, host)
+		return false
+	}
+	return true
+}
+
+// isH2C returns true if a previous h2c request to the host succeeded.
+func (t *autoProtoTransport) isH2C(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.h2cHosts[host]
+}
+
+func (t *autoProtoTransport) setH2C(host string) {
+	t.mu.Lock()
+	t.h2cHosts[host] = true
+	t.mu.Unlock()
+}
+
+func (t *autoProtoTransport) setHTTP1(host string) {
+	t.mu.Lock()
+	delete(t.h2cHosts, host)
+	t.http1Hosts[host] = time.Now().Add(h2cRetry)
+	t.mu.Unlock()
+}
+
+// protoRecorder records the protocol of the upstream responses
+// on the target.
+type protoRecorder struct {
+	rt http.RoundTripper
+	t  *route.Target
+}
+
+func (p protoRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.rt.RoundTrip(req)
+	if err == nil {
+		p.t.SetProto(resp.Proto)
+	}
+	return resp, err
+}
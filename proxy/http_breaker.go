@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// ResponseCache keeps the last successful responses of the routes with
+// the 'breakerfallback=cache' option which are served while all targets
+// of the route have been ejected. Only responses to GET requests without
+// credentials or cookies with status 200 and a body of at most MaxBody
+// bytes are kept. Responses with Set-Cookie, Vary or with 'Cache-Control:
+// no-store' or 'private' are not kept since they may be specific to a
+// client. At most
+// Max responses are kept and the least recently used one is removed
+// when a new response is added.
+type ResponseCache struct {
+	// Max is the maximum number of responses.
+	Max int
+
+	// MaxBody is the maximum body size of a response in bytes.
+	MaxBody int
+
+	mu    sync.Mutex
+	lru   *list.List // of *cachedResponse, most recently used first
+	byURL map[string]*list.Element
+}
+
+type cachedResponse struct {
+	url    string
+	header http.Header
+	body   []byte
+}
+
+// get returns the response for the request URL or nil.
+func (c *ResponseCache) get(url string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.byURL[url]
+	if e == nil {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedResponse)
+}
+
+// put adds or replaces the response for the request URL.
+func (c *ResponseCache) put(r *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byURL == nil {
+		c.lru, c.byURL = list.New(), map[string]*list.Element{}
+	}
+	if e := c.byURL[r.url]; e != nil {
+		e.Value = r
+		c.lru.MoveToFront(e)
+		return
+	}
+	for c.lru.Len() >= c.Max && c.lru.Len() > 0 {
+		v := c.lru.Remove(c.lru.Back()).(*cachedResponse)
+		delete(c.byURL, v.url)
+	}
+	c.byURL[r.url] = c.lru.PushFront(r)
+}
+
+// transport returns a transport which adds the responses for the
+// request URL to the cache.
+func (c *ResponseCache) transport(rt http.RoundTripper, url string) http.RoundTripper {
+	return &cacheTransport{rt: rt, cache: c, url: url}
+}
+
+type cacheTransport struct {
+	rt    http.RoundTripper
+	cache *ResponseCache
+	url   string
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || !cacheable(req, resp) {
+		return resp, err
+	}
+	resp.Body = &cacheBody{
+		ReadCloser: resp.Body,
+		max:        t.cache.MaxBody,
+		done: func(b []byte) {
+			t.cache.put(&cachedResponse{url: t.url, header: resp.Header.Clone(), body: b})
+		},
+	}
+	return resp, nil
+}
+
+func cacheable(req *http.Request, resp *http.Response) bool {
+	if req.Method != "GET" || resp.StatusCode != http.StatusOK {
+		return false
+	}
+	for _, h := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+		if req.Header.Get(h) != "" {
+			return false
+		}
+	}
+	if resp.Header.Get("Set-Cookie") != "" || resp.Header.Get("Vary") != "" {
+		return false
+	}
+	cc := strings.ToLower(resp.Header.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+// cacheBody copies the response body while it is sent to the client
+// and calls done with the body when it has been read completely.
+// Bodies larger than max are not copied.
+type cacheBody struct {
+	io.ReadCloser
+	max  int
+	buf  bytes.Buffer
+	skip bool
+	done func([]byte)
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.skip {
+		if b.buf.Len()+n > b.max {
+			b.skip = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.skip {
+		b.skip = true
+		b.done(b.buf.Bytes())
+	}
+	return n, err
+}
+
+// serveBreakerFallback sends the fallback response of the route of t
+// whose targets have all been ejected.
+func (p *HTTPProxy) serveBreakerFallback(w http.ResponseWriter, t *route.Target, url string) {
+	if p.BreakerFallbacks != nil {
+		p.BreakerFallbacks.Inc(1)
+	}
+	fb := t.BreakerFallback
+	switch {
+	case fb.Cache:
+		var r *cachedResponse
+		if p.BreakerCache != nil {
+			r = p.BreakerCache.get(url)
+		}
+		if r == nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		for k, v := range r.header {
+			w.Header()[k] = append([]string(nil), v...)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(r.body)
+	case fb.File != "":
+		serveFile(w, fb.File, http.StatusServiceUnavailable)
+	default:
+		http.Error(w, http.StatusText(fb.Status), fb.Status)
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// errRequestTooLarge is returned when the request body exceeds the
+// limit of the bufferTransport.
+var errRequestTooLarge = errors.New("request body too large")
+
+// bufferTransport reads the complete request body before the request
+// is sent upstream. The request is then sent with a Content-Length
+// header instead of a chunked body, e.g. for upstream servers which do
+// not support chunked uploads, and the upstream server does not wait
+// for slow clients. Requests with a body larger than maxBody bytes are
+// rejected with 413.
+type bufferTransport struct {
+	rt      http.RoundTripper
+	maxBody int
+}
+
+func (t *bufferTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok, err := readBody(req, t.maxBody)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		req.Body.Close()
+		return nil, errRequestTooLarge
+	}
+	if body != nil {
+		req.ContentLength = int64(len(body))
+		req.TransferEncoding = nil
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
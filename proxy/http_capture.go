@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// CaptureSettings are the settings of the body capture which can be
+// changed at runtime through the admin API.
+type CaptureSettings struct {
+	// Enabled enables the capture for the targets with the
+	// 'capturebody=true' option.
+	Enabled bool `json:"enabled"`
+
+	// MaxBody is the number of bytes of a body which are logged.
+	// Longer bodies are truncated. With zero only the sizes of the
+	// bodies are logged.
+	MaxBody int `json:"maxbody"`
+
+	// SampleRate is the fraction of the requests between 0 and 1
+	// which are captured.
+	SampleRate float64 `json:"samplerate"`
+}
+
+// Validate returns an error if the settings are invalid.
+func (s CaptureSettings) Validate() error {
+	if s.MaxBody < 0 {
+		return fmt.Errorf("invalid maxbody: %d", s.MaxBody)
+	}
+	if s.SampleRate < 0 || s.SampleRate > 1 {
+		return fmt.Errorf("invalid samplerate: %g", s.SampleRate)
+	}
+	return nil
+}
+
+// BodyCapture logs the truncated request and response bodies of the
+// targets with the 'capturebody=true' option for debugging. The capture
+// is disabled until it is enabled through the admin API and requests of
+// other targets are not affected. The bodies are logged while they are
+// streamed to the upstream server and the client.
+//
+// The captured bodies may contain passwords, tokens and personal data.
+// The capture should only be enabled briefly and the log should be
+// protected accordingly.
+type BodyCapture struct {
+	// Log receives the captured bodies. If Log is nil the standard
+	// logger is used.
+	Log *log.Logger
+
+	settings atomic.Value // CaptureSettings
+
+	// seq numbers the captured requests so that the
+	// request and the response can be matched.
+	seq uint64
+}
+
+// Settings returns the current settings.
+func (c *BodyCapture) Settings() CaptureSettings {
+	s, _ := c.settings.Load().(CaptureSettings)
+	return s
+}
+
+// Update replaces the settings.
+func (c *BodyCapture) Update(s CaptureSettings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	c.settings.Store(s)
+	if s.Enabled {
+		log.Printf("[WARN] Capturing up to %d bytes of the request and response bodies of %g of the requests of routes with 'capturebody=true'. "+
+			"The bodies may contain passwords, tokens and personal data. Disable the capture when you are done", s.MaxBody, s.SampleRate)
+	} else {
+		log.Print("[INFO] Body capture disabled")
+	}
+	return nil
+}
+
+// transport returns a transport which captures the bodies of the
+// request or nil if the request is not captured.
+func (c *BodyCapture) transport(rt http.RoundTripper) *captureTransport {
+	if c == nil {
+		return nil
+	}
+	s := c.Settings()
+	if !s.Enabled || s.SampleRate <= 0 || (s.SampleRate < 1 && rand.Float64() >= s.SampleRate) {
+		return nil
+	}
+	l := c.Log
+	if l == nil {
+		l = log.New(log.Writer(), "", log.Flags())
+	}
+	return &captureTransport{rt: rt, log: l, max: s.MaxBody, id: atomic.AddUint64(&c.seq, 1)}
+}
+
+// captureTransport logs the request and the response body of a
+// captured request when they have been read or closed.
+type captureTransport struct {
+	rt  http.RoundTripper
+	log *log.Logger
+	max int
+	id  uint64
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, u := req.Method, req.URL.String()
+	if req.Body == nil || req.Body == http.NoBody {
+		t.log.Printf("#%d > %s %s 0 bytes", t.id, method, u)
+	} else {
+		req.Body = &captureBody{rc: req.Body, max: t.max, done: func(b *captureBody) {
+			t.log.Printf("#%d > %s %s %s", t.id, method, u, b)
+		}}
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		t.log.Printf("#%d < %s %s", t.id, u, err)
+		return resp, err
+	}
+
+	// the body of an upgraded connection is not captured.
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil || resp.Body == http.NoBody {
+		t.log.Printf("#%d < %s %d 0 bytes", t.id, u, resp.StatusCode)
+		return resp, nil
+	}
+	code := resp.StatusCode
+	resp.Body = &captureBody{rc: resp.Body, max: t.max, done: func(b *captureBody) {
+		t.log.Printf("#%d < %s %d %s", t.id, u, code, b)
+	}}
+	return resp, nil
+}
+
+// captureBody copies the first max bytes of a body while it is read
+// and calls done once when the body has been read or closed.
+type captureBody struct {
+	rc   io.ReadCloser
+	max  int
+	done func(*captureBody)
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	n    int64
+	eof  bool
+	once sync.Once
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.mu.Lock()
+	b.n += int64(n)
+	if room := b.max - b.buf.Len(); room > 0 {
+		if room > n {
+			room = n
+		}
+		b.buf.Write(p[:room])
+	}
+	b.eof = b.eof || err == io.EOF
+	b.mu.Unlock()
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	err := b.rc.Close()
+	b.finish()
+	return err
+}
+
+func (b *captureBody) finish() {
+	b.once.Do(func() { b.done(b) })
+}
+
+// captured returns the captured bytes and the size of the body
+// which has been read so far.
+func (b *captureBody) captured() ([]byte, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...), b.n
+}
+
+// String returns the size and the captured bytes of the body.
+func (b *captureBody) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := fmt.Sprintf("%d bytes", b.n)
+	if !b.eof {
+		s += " (incomplete)"
+	}
+	if int64(b.buf.Len()) < b.n {
+		s += " (truncated)"
+	}
+	if b.buf.Len() > 0 {
+		s += fmt.Sprintf(": %q", b.buf.Bytes())
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBodyCapture(t *testing.T) {
+	// echo returns the request body in upper case
+	echo := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(strings.ToUpper(string(b)))),
+		}, nil
+	})
+
+	var buf bytes.Buffer
+	c := &BodyCapture{Log: log.New(&buf, "", 0)}
+
+	if c.transport(echo) != nil {
+		t.Fatal("capture enabled by default")
+	}
+	if err := c.Update(CaptureSettings{Enabled: true, MaxBody: 5, SampleRate: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if c.transport(echo) != nil {
+		t.Fatal("capture enabled with sample rate 0")
+	}
+	if err := c.Update(CaptureSettings{Enabled: true, MaxBody: 5, SampleRate: 1.5}); err == nil {
+		t.Fatal("got nil want error")
+	}
+	if err := c.Update(CaptureSettings{Enabled: true, MaxBody: 5, SampleRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := c.transport(echo)
+	if rt == nil {
+		t.Fatal("capture not enabled")
+	}
+	req, _ := http.NewRequest("POST", "http://foo.com/bar", strings.NewReader("hello world"))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// the bodies are not modified
+	if got, want := string(b), "HELLO WORLD"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	want := `#1 > POST http://foo.com/bar 11 bytes (truncated): "hello"
+#1 < http://foo.com/bar 200 11 bytes (truncated): "HELLO"
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got log\n%s\nwant\n%s", got, want)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/proxy/tcp"
+)
+
+// serveConnect handles the CONNECT requests of the forward proxy mode.
+// Tunnels are only established to the host and port of the request if
+// ConnectLookup returns a target for them and the access rules of the
+// target allow the client. The connection is made to the host of the
+// target URL and the data is relayed like for TCP routes.
+func (p *HTTPProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil || host == "" || port == "" {
+		http.Error(w, "invalid CONNECT target", http.StatusBadRequest)
+		return
+	}
+	dst := net.JoinHostPort(host, port)
+
+	t := p.ConnectLookup(dst)
+	if t == nil || t.AccessDeniedHTTP(r) {
+		if p.ConnectDenied != nil {
+			p.ConnectDenied.Inc(1)
+		}
+		log.Printf("[WARN] Denied CONNECT to %s for %s", dst, r.RemoteAddr)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "CONNECT is only supported for HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	out, err := tcp.Dial(t, t.URL.Host, p.Config.DialTimeout)
+	if err != nil {
+		log.Printf("[WARN] Cannot connect to %s for CONNECT to %s. %s", t.URL.Host, dst, err)
+		http.Error(w, "error contacting upstream server", http.StatusBadGateway)
+		return
+	}
+	defer out.Close()
+
+	in, brw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] Hijack error for CONNECT to %s. %s", dst, err)
+		http.Error(w, "hijack error", http.StatusInternalServerError)
+		return
+	}
+	defer in.Close()
+
+	// the tunnel is not subject to the timeouts of the listener
+	if err := in.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+	if _, err := io.WriteString(in, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	// the client may have sent data after the request already
+	var c net.Conn = in
+	if brw.Reader.Buffered() > 0 {
+		c = &bufferedConn{Conn: in, r: brw.Reader}
+	}
+	tcp.Relay(t, c, out, nil)
+}
+
+// bufferedConn returns the data which was read ahead from the
+// connection before reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deadlineHeaders are the request headers which carry the timeout of the
+// client in the order of precedence. The gRPC header is used for gRPC
+// requests and X-Request-Timeout for timeout-aware HTTP clients.
+var deadlineHeaders = []string{"Grpc-Timeout", "X-Request-Timeout"}
+
+// requestDeadline returns the deadline of the request from the timeout
+// which the client sent in one of the deadline headers relative to the
+// time the request was received. It returns the zero time if the
+// request has no valid timeout.
+func requestDeadline(r *http.Request, received time.Time) (deadline time.Time, header string) {
+	for _, h := range deadlineHeaders {
+		v := r.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		d, err := parseTimeout(h, v)
+		if err != nil {
+			return time.Time{}, ""
+		}
+		return received.Add(d), h
+	}
+	return time.Time{}, ""
+}
+
+func parseTimeout(header, v string) (time.Duration, error) {
+	if header == "Grpc-Timeout" {
+		return parseGRPCTimeout(v)
+	}
+	// X-Request-Timeout is either a duration like 1.5s
+	// or a number of seconds like 1.5
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs <= 0 || secs > float64(1<<63-1)/float64(time.Second) {
+		return 0, errors.New("invalid timeout")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// grpcUnits are the units of the grpc-timeout header.
+var grpcUnits = []struct {
+	unit byte
+	d    time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// parseGRPCTimeout parses the value of the grpc-timeout header which is
+// a positive integer of at most 8 digits followed by a unit, e.g. 100m.
+func parseGRPCTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, errors.New("invalid grpc-timeout")
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid grpc-timeout")
+	}
+	for _, u := range grpcUnits {
+		if u.unit == v[len(v)-1] {
+			if n > int64(1<<63-1)/int64(u.d) {
+				return 1<<63 - 1, nil
+			}
+			return time.Duration(n) * u.d, nil
+		}
+	}
+	return 0, errors.New("invalid grpc-timeout")
+}
+
+// formatGRPCTimeout returns the grpc-timeout value of d with the finest
+// unit which fits into 8 digits. The value is rounded up so that the
+// upstream server does not get a shorter deadline than fabio.
+func formatGRPCTimeout(d time.Duration) string {
+	for _, u := range grpcUnits {
+		n := (d + u.d - 1) / u.d
+		if n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + string(u.unit)
+		}
+	}
+	return "99999999H"
+}
+
+// formatTimeout returns the remaining time d in the format of the
+// timeout which the client sent in the header.
+func formatTimeout(header, orig string, d time.Duration) string {
+	if header == "Grpc-Timeout" {
+		return formatGRPCTimeout(d)
+	}
+	if _, err := strconv.ParseFloat(orig, 64); err == nil {
+		return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// deadlineTransport sets the remaining time until the deadline of the
+// client in the deadline header of every upstream request including
+// retries. The time spent in fabio is therefore deducted from the
+// timeout of the upstream request.
+type deadlineTransport struct {
+	rt       http.RoundTripper
+	deadline time.Time
+	header   string
+	orig     string
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	remaining := time.Until(t.deadline)
+	if remaining <= 0 {
+		return nil, errDeadlineExceeded
+	}
+	out := *req
+	out.Header = req.Header.Clone()
+	out.Header.Set(t.header, formatTimeout(t.header, t.orig, remaining))
+	return t.rt.RoundTrip(&out)
+}
+
+// errDeadlineExceeded is returned when the deadline of the
+// client has passed before the upstream request was sent.
+var errDeadlineExceeded = errors.New("request deadline exceeded")
+
+// isGRPC returns true for gRPC requests.
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// writeDeadlineExceeded responds to a request whose deadline has passed
+// with 504 Gateway Timeout or with the DEADLINE_EXCEEDED status for
+// gRPC requests.
+func writeDeadlineExceeded(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		// trailers-only response with grpc-status 4 (DEADLINE_EXCEEDED)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "4")
+		w.Header().Set("Grpc-Message", "deadline exceeded")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+}
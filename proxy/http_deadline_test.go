@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestParseGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		in  string
+		d   time.Duration
+		err bool
+	}{
+		{"", 0, true},
+		{"m", 0, true},
+		{"100", 0, true},
+		{"100x", 0, true},
+		{"0m", 0, true},
+		{"-1S", 0, true},
+		{"123456789m", 0, true},
+		{"100n", 100 * time.Nanosecond, false},
+		{"100u", 100 * time.Microsecond, false},
+		{"100m", 100 * time.Millisecond, false},
+		{"2S", 2 * time.Second, false},
+		{"3M", 3 * time.Minute, false},
+		{"4H", 4 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		d, err := parseGRPCTimeout(tt.in)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%q: got error %v want %v", tt.in, err, want)
+		}
+		if got, want := d, tt.d; got != want {
+			t.Fatalf("%q: got %v want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestFormatTimeout(t *testing.T) {
+	tests := []struct {
+		header, orig string
+		d            time.Duration
+		out          string
+	}{
+		{"Grpc-Timeout", "1S", 1500 * time.Nanosecond, "1500n"},
+		{"Grpc-Timeout", "1S", 150 * time.Millisecond, "150000u"},
+		{"Grpc-Timeout", "1S", 150*time.Millisecond + 1, "150001u"},
+		{"Grpc-Timeout", "1H", 30 * time.Minute, "1800000m"},
+		{"X-Request-Timeout", "2", 1500 * time.Millisecond, "1.500"},
+		{"X-Request-Timeout", "2s", 1500 * time.Millisecond, "1.5s"},
+	}
+	for _, tt := range tests {
+		if got, want := formatTimeout(tt.header, tt.orig, tt.d), tt.out; got != want {
+			t.Fatalf("%s %v: got %q want %q", tt.header, tt.d, got, want)
+		}
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		desc   string
+		header http.Header
+		d      time.Duration
+		name   string
+	}{
+		{"none", http.Header{}, 0, ""},
+		{"grpc", http.Header{"Grpc-Timeout": {"100m"}}, 100 * time.Millisecond, "Grpc-Timeout"},
+		{"seconds", http.Header{"X-Request-Timeout": {"1.5"}}, 1500 * time.Millisecond, "X-Request-Timeout"},
+		{"duration", http.Header{"X-Request-Timeout": {"250ms"}}, 250 * time.Millisecond, "X-Request-Timeout"},
+		{"grpc first", http.Header{"Grpc-Timeout": {"1S"}, "X-Request-Timeout": {"2"}}, time.Second, "Grpc-Timeout"},
+		{"invalid", http.Header{"X-Request-Timeout": {"soon"}}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			deadline, name := requestDeadline(&http.Request{Header: tt.header}, now)
+			var want time.Time
+			if tt.d > 0 {
+				want = now.Add(tt.d)
+			}
+			if !deadline.Equal(want) {
+				t.Fatalf("got deadline %v want %v", deadline, want)
+			}
+			if got, want := name, tt.name; got != want {
+				t.Fatalf("got header %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyDeadlinePropagate(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-Timeout")
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	newProxy := func(propagate bool) *httptest.Server {
+		return httptest.NewServer(&HTTPProxy{
+			Config:    config.Proxy{DeadlinePropagate: propagate},
+			Transport: &http.Transport{},
+			Lookup: func(r *http.Request) *route.Target {
+				return &route.Target{URL: mustParse(server.URL)}
+			},
+		})
+	}
+
+	do := func(url, timeout string) *http.Response {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("X-Request-Timeout", timeout)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		proxy := newProxy(false)
+		defer proxy.Close()
+		resp := do(proxy.URL+"/slow", "0.05")
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got != "0.05" {
+			t.Fatalf("got timeout %q want %q", got, "0.05")
+		}
+	})
+
+	t.Run("remaining budget", func(t *testing.T) {
+		proxy := newProxy(true)
+		defer proxy.Close()
+		resp := do(proxy.URL+"/", "10s")
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		d, err := time.ParseDuration(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d <= 0 || d > 10*time.Second {
+			t.Fatalf("got timeout %v want less than 10s", d)
+		}
+	})
+
+	t.Run("exceeded", func(t *testing.T) {
+		proxy := newProxy(true)
+		defer proxy.Close()
+		resp := do(proxy.URL+"/slow", "0.05")
+		if got, want := resp.StatusCode, http.StatusGatewayTimeout; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+	})
+}
+
+func TestWriteDeadlineExceeded(t *testing.T) {
+	r := httptest.NewRequest("POST", "/svc/Method", nil)
+	r.Header.Set("Content-Type", "application/grpc+proto")
+	w := httptest.NewRecorder()
+	writeDeadlineExceeded(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := w.Header().Get("Grpc-Status"), "4"; got != want {
+		t.Fatalf("got grpc-status %q want %q", got, want)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	writeDeadlineExceeded(w, r)
+	if got, want := w.Code, http.StatusGatewayTimeout; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+}
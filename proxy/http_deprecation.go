@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// deprecated returns true if the route of the target has been
+// deprecated with the 'deprecation' or the 'sunset' option.
+func deprecated(t *route.Target) bool {
+	return t.Deprecation != "" || t.Sunset != ""
+}
+
+// addDeprecationHeaders adds the Deprecation, Sunset and Link headers
+// of the target to the response headers h. The Deprecation and Sunset
+// headers of the upstream server are not replaced.
+func addDeprecationHeaders(h http.Header, t *route.Target) {
+	if t.Deprecation != "" && h.Get("Deprecation") == "" {
+		h.Set("Deprecation", t.Deprecation)
+	}
+	if t.Sunset != "" && h.Get("Sunset") == "" {
+		h.Set("Sunset", t.Sunset)
+	}
+	if t.DeprecationLink != "" {
+		h.Add("Link", "<"+t.DeprecationLink+`>; rel="deprecation"; type="text/html"`)
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// expectTransport checks the upstream responses against the expected
+// responses of the target. It records whether the last response did
+// not match so that the request is counted as a failure of the target.
+// The check uses the response headers and happens before the body is
+// sent to the client. Responses which do not match are still sent to
+// the client unless they are retried.
+type expectTransport struct {
+	rt     http.RoundTripper
+	expect *route.Expect
+
+	// failed is true if the last response did not match.
+	failed bool
+
+	// hits counts the responses which did not match.
+	hits metrics.Counter
+}
+
+func (t *expectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	t.failed = err == nil && !t.expect.Match(resp.StatusCode, resp.Header)
+	if t.failed {
+		if t.hits != nil {
+			t.hits.Inc(1)
+		}
+		log.Printf("[WARN] Unexpected response from %s: %d %s", req.URL.Host, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return resp, err
+}
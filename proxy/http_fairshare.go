@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// errFairQueueFull and errFairQueueTimeout are returned when
+// a request is rejected by the fair queue.
+var (
+	errFairQueueFull    = errors.New("too many queued requests")
+	errFairQueueTimeout = errors.New("timeout waiting for a free slot")
+)
+
+// FairQueue limits the number of concurrent upstream requests of all
+// routes and shares the slots between the routes under contention
+// (weighted fair queuing).
+//
+// Requests are admitted immediately while there are free slots so a
+// route can use the capacity which is not used by the other routes.
+// When all slots are taken the requests wait in a queue per route. A
+// freed slot goes to the waiting route with the lowest number of
+// active requests relative to its share, i.e. the 'fairshare' option
+// of the route. Therefore, each route gets a number of slots which is
+// proportional to its share when all routes are busy.
+type FairQueue struct {
+	// Max is the maximum number of concurrent requests.
+	Max int
+
+	// MaxQueue is the maximum number of waiting requests
+	// of all routes. Additional requests are rejected.
+	MaxQueue int
+
+	// Timeout is the maximum time a request waits for a slot.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	active  int
+	waiting int
+	routes  map[string]*fairRoute
+}
+
+// fairRoute is the state of a route in the fair queue.
+type fairRoute struct {
+	share  int
+	active int
+	queue  []*fairWaiter
+
+	// admitted and rejected count the requests of the route and
+	// queued tracks the number of waiting requests.
+	admitted, queued, rejected metrics.Counter
+}
+
+// fairWaiter is a request waiting for a slot. ready is closed
+// when the slot has been granted.
+type fairWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// acquire waits for a slot for a request of the route of t until the
+// timeout or until the context is done. The caller must call release
+// with the same target when the request is done if acquire returns no
+// error.
+func (q *FairQueue) acquire(ctx context.Context, t *route.Target) error {
+	q.mu.Lock()
+	r := q.route(t)
+	if q.active < q.Max {
+		q.grant(r)
+		q.mu.Unlock()
+		return nil
+	}
+	if q.waiting >= q.MaxQueue {
+		q.remove(t.Prefix, r)
+		q.mu.Unlock()
+		r.rejected.Inc(1)
+		return errFairQueueFull
+	}
+	w := &fairWaiter{ready: make(chan struct{})}
+	r.queue = append(r.queue, w)
+	r.queued.Inc(1)
+	q.waiting++
+	timeout := q.Timeout
+	q.mu.Unlock()
+
+	err := errFairQueueTimeout
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// the slot may have been granted in the meantime
+	if w.granted {
+		return nil
+	}
+	for i, x := range r.queue {
+		if x == w {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			break
+		}
+	}
+	r.queued.Inc(-1)
+	q.waiting--
+	r.rejected.Inc(1)
+	q.remove(t.Prefix, r)
+	return err
+}
+
+// release frees the slot of the request of the route of t and
+// hands it to the next waiting request.
+func (q *FairQueue) release(t *route.Target) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r := q.routes[t.Prefix]
+	if r == nil {
+		return
+	}
+	r.active--
+	q.active--
+
+	// pick the waiting route with the lowest active/share ratio.
+	// a/sa < b/sb is compared as a*sb < b*sa.
+	var next *fairRoute
+	for _, x := range q.routes {
+		if len(x.queue) == 0 {
+			continue
+		}
+		if next == nil || x.active*next.share < next.active*x.share {
+			next = x
+		}
+	}
+	if next != nil {
+		w := next.queue[0]
+		next.queue = next.queue[1:]
+		next.queued.Inc(-1)
+		q.waiting--
+		q.grant(next)
+		w.granted = true
+		close(w.ready)
+	}
+	q.remove(t.Prefix, r)
+}
+
+// route returns the state of the route of t.
+// The caller must hold the lock.
+func (q *FairQueue) route(t *route.Target) *fairRoute {
+	if q.routes == nil {
+		q.routes = map[string]*fairRoute{}
+	}
+	share := t.FairShare
+	if share <= 0 {
+		share = 1
+	}
+	r := q.routes[t.Prefix]
+	if r == nil {
+		name := "fairqueue." + fairQueueName.Replace(t.Prefix)
+		r = &fairRoute{
+			admitted: metrics.DefaultRegistry.GetCounter(name + ".admitted"),
+			queued:   metrics.DefaultRegistry.GetCounter(name + ".queued"),
+			rejected: metrics.DefaultRegistry.GetCounter(name + ".rejected"),
+		}
+		q.routes[t.Prefix] = r
+	}
+	r.share = share
+	return r
+}
+
+// fairQueueName replaces the characters of the route prefix
+// which are separators in the metric names.
+var fairQueueName = strings.NewReplacer(".", "_", ":", "_")
+
+// grant assigns a slot to the route. The caller must hold the lock.
+func (q *FairQueue) grant(r *fairRoute) {
+	r.active++
+	q.active++
+	r.admitted.Inc(1)
+}
+
+// remove deletes the state of an idle route.
+// The caller must hold the lock.
+func (q *FairQueue) remove(prefix string, r *fairRoute) {
+	if r.active == 0 && len(r.queue) == 0 {
+		delete(q.routes, prefix)
+	}
+}
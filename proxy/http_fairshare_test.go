@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestFairQueue(t *testing.T) {
+	a := &route.Target{Prefix: "a.com/", FairShare: 1}
+	b := &route.Target{Prefix: "b.com/", FairShare: 3}
+	q := &FairQueue{Max: 4, MaxQueue: 5, Timeout: 10 * time.Second}
+
+	waiting := func() int {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.waiting
+	}
+
+	// route a can use all slots while route b is idle
+	for i := 0; i < 4; i++ {
+		if err := q.acquire(context.Background(), a); err != nil {
+			t.Fatalf("request %d of route a: got %v want nil", i, err)
+		}
+	}
+
+	// queue three requests for route b and two for route a
+	admitted := make(chan string, 5)
+	enqueue := func(tg *route.Target, name string) {
+		n := waiting()
+		go func() {
+			if err := q.acquire(context.Background(), tg); err != nil {
+				t.Errorf("request of route %s: got %v want nil", name, err)
+			}
+			admitted <- name
+		}()
+		for waiting() == n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	enqueue(b, "b")
+	enqueue(b, "b")
+	enqueue(a, "a")
+	enqueue(b, "b")
+	enqueue(a, "a")
+
+	// the queue is full
+	if got, want := q.acquire(context.Background(), b), errFairQueueFull; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// the freed slots of route a go to route b until
+	// the active requests are proportional to the shares.
+	var got string
+	for i := 0; i < 4; i++ {
+		q.release(a)
+		got += <-admitted
+	}
+	if want := "bbba"; got != want {
+		t.Fatalf("got admission order %q want %q", got, want)
+	}
+
+	// a new request waits until it times out
+	q.mu.Lock()
+	q.Timeout = 10 * time.Millisecond
+	q.mu.Unlock()
+	if got, want := q.acquire(context.Background(), a), errFairQueueTimeout; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	q.release(a)
+	if got, want := <-admitted, "a"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestFairQueueRejected(t *testing.T) {
+	a := &route.Target{Prefix: "a.com/"}
+	b := &route.Target{Prefix: "b.com/"}
+	q := &FairQueue{Max: 1, MaxQueue: 1, Timeout: 10 * time.Second}
+
+	routes := func() int {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.routes)
+	}
+
+	if err := q.acquire(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	// the waiting request returns when its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.acquire(ctx, b) }()
+	for routes() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue is full and the rejected route is not kept
+	c := &route.Target{Prefix: "c.com/"}
+	if got, want := q.acquire(context.Background(), c), errFairQueueFull; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := routes(), 2; got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+
+	cancel()
+	if got, want := <-done, context.Canceled; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := routes(), 1; got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+
+	q.release(a)
+	if got, want := routes(), 0; got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// fallbackTransport sends the request to the fallback target of a route
+// if the primary target fails or does not send the response headers
+// within the timeout. The request body is buffered up to maxBody bytes
+// so that it can be sent to the fallback target unless stream is set.
+// Requests with larger bodies and all requests with a body if stream is
+// set are only sent to the primary target.
+type fallbackTransport struct {
+	rt       http.RoundTripper
+	fallback *url.URL
+	timeout  time.Duration
+	stream   bool
+	maxBody  int
+
+	// hits counts the requests which were sent to the fallback target.
+	hits metrics.Counter
+}
+
+// errFallbackTimeout is returned when the primary target did not send
+// the response headers within the timeout.
+type errFallbackTimeout time.Duration
+
+func (e errFallbackTimeout) Error() string {
+	return fmt.Sprintf("no response headers within %s", time.Duration(e))
+}
+
+func (e errFallbackTimeout) Timeout() bool   { return true }
+func (e errFallbackTimeout) Temporary() bool { return true }
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.stream && req.Body != nil && req.Body != http.NoBody {
+		return t.primary(req)
+	}
+
+	body, ok, err := readBody(req, t.maxBody)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return t.primary(req)
+	}
+
+	resp, err := t.primary(req)
+	if err == nil {
+		return resp, nil
+	}
+
+	// the client has gone away
+	if req.Context().Err() != nil {
+		return nil, err
+	}
+
+	if t.hits != nil {
+		t.hits.Inc(1)
+	}
+	log.Printf("[WARN] Sending %s to fallback %s: %s", req.URL, t.fallback.Host, err)
+
+	freq := req.Clone(req.Context())
+	freq.URL.Scheme = t.fallback.Scheme
+	freq.URL.Host = t.fallback.Host
+	if body != nil {
+		freq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return t.rt.RoundTrip(freq)
+}
+
+// readBody reads and closes the request body and replaces it with
+// a reader for the returned content so that the request can be sent
+// again. It returns nil if the request has no body. If max is not
+// negative and the body is larger than max bytes then the body is not
+// buffered and ok is false. The request body then still returns the
+// complete content but the request can only be sent once.
+func readBody(req *http.Request, max int) (body []byte, ok bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if max >= 0 && req.ContentLength > int64(max) {
+		return nil, false, nil
+	}
+
+	var r io.Reader = req.Body
+	if max >= 0 {
+		r = io.LimitReader(req.Body, int64(max)+1)
+	}
+	body, err = ioutil.ReadAll(r)
+	if err != nil {
+		req.Body.Close()
+		return nil, false, err
+	}
+	if max >= 0 && len(body) > max {
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false, nil
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, true, nil
+}
+
+// readCloser combines a reader with the closer of another reader.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// primary sends the request to the primary target. The timeout only
+// applies until the response headers have been received.
+func (t *fallbackTransport) primary(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.rt.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, errFallbackTimeout(t.timeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the body of an upgraded connection must remain an io.ReadWriteCloser.
+	// Its context is released when the request is complete.
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of the request
+// when the response body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// checkRequestURL returns an error if the request path contains null
+// bytes, control characters or '..' segments or if the query contains
+// null bytes. The path is checked after decoding and, to detect double
+// encoding like %252e%252e, after decoding it once more. Backslashes are
+// treated as path separators since some upstream servers do so.
+func checkRequestURL(u *url.URL) error {
+	path := u.Path
+	for i := 0; i < 2; i++ {
+		if strings.IndexFunc(path, isControl) >= 0 {
+			return errors.New("control character in path")
+		}
+		for _, seg := range strings.FieldsFunc(path, isPathSeparator) {
+			if seg == ".." {
+				return errors.New("path traversal")
+			}
+		}
+		p, err := url.PathUnescape(path)
+		if err != nil || p == path {
+			break
+		}
+		path = p
+	}
+	if q, err := url.QueryUnescape(u.RawQuery); err == nil && strings.IndexByte(q, 0) >= 0 {
+		return errors.New("null byte in query")
+	}
+	return nil
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// filterRoute rejects the requests which the 'blockpaths' and the
+// 'allowmethods' options of the route of t do not allow. OPTIONS
+// requests which serveOptions answers are always allowed. It returns
+// true if the request was rejected.
+func (p *HTTPProxy) filterRoute(w http.ResponseWriter, r *http.Request, t *route.Target) bool {
+	switch {
+	case t.PathBlocked(r.URL.Path):
+		p.rejectRequest(w, r, http.StatusForbidden, "blocked path")
+		return true
+	case !t.MethodAllowed(r.Method) && !p.autoOptions(r, t):
+		w.Header().Set("Allow", strings.Join(t.AllowMethods, ", "))
+		p.rejectRequest(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return true
+	}
+	return false
+}
+
+// defaultAllowMethods are the methods in the Allow header of the
+// automatic OPTIONS responses for routes without 'allowmethods'.
+var defaultAllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// serveOptions answers OPTIONS requests for the route of t with 204 and
+// the Allow header if the route has the 'autooptions=true' option or
+// proxy.autooptions is enabled and the route does not disable it with
+// 'autooptions=false'. The methods are those of the 'allowmethods'
+// option. CORS preflight requests are forwarded to the upstream server.
+// It must be called after filterRoute and the authorization check of the
+// route. It returns true if the request was answered.
+func (p *HTTPProxy) serveOptions(w http.ResponseWriter, r *http.Request, t *route.Target) bool {
+	if !p.autoOptions(r, t) {
+		return false
+	}
+	methods := defaultAllowMethods
+	if len(t.AllowMethods) > 0 {
+		methods = t.AllowMethods
+		if !t.MethodAllowed(http.MethodOptions) {
+			methods = append(methods[:len(methods):len(methods)], http.MethodOptions)
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// autoOptions returns true if r is an OPTIONS request which
+// serveOptions answers for the route of t.
+func (p *HTTPProxy) autoOptions(r *http.Request, t *route.Target) bool {
+	if r.Method != http.MethodOptions || isPreflight(r) {
+		return false
+	}
+	switch t.Opts["autooptions"] {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		return p.Config.AutoOptions
+	}
+}
+
+// isPreflight returns true for CORS preflight requests.
+func isPreflight(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// rejectRequest responds with the status code to a request which
+// was rejected by the request filter.
+func (p *HTTPProxy) rejectRequest(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	if p.RequestFiltered != nil {
+		p.RequestFiltered.Inc(1)
+	}
+	log.Printf("[DEBUG] Rejected request from %s for %q. %s", r.RemoteAddr, r.URL.Path, reason)
+	http.Error(w, http.StatusText(status), status)
+}
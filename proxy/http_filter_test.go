@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestCheckRequestURL(t *testing.T) {
+	tests := []struct {
+		uri string
+		ok  bool
+	}{
+		{"/", true},
+		{"/a/b.html?x=1&y=%0A", true},
+		{"/a/..b/c..", true},
+		{"/a/%2f/b", true},
+		{"/a/../b", false},
+		{"/a/%2e%2e/b", false},
+		{"/a/%2E%2E%2Fb", false},
+		{"/a/%252e%252e/b", false},
+		{"/a/..%5cb", false},
+		{"/a%00.html", false},
+		{"/a%2500.html", false},
+		{"/a%0d%0ab", false},
+		{"/a%7f", false},
+		{"/a?x=%00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			u, err := url.ParseRequestURI(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkRequestURL(u); (err == nil) != tt.ok {
+				t.Fatalf("got error %v want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProxyRequestFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	routes := "route add api /api " + server.URL + ` opts "allowmethods=GET,HEAD blockpaths=^/api/internal,\.bak$"` + "\n" +
+		"route add www / " + server.URL
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+
+	filtered := &testCounter{}
+	newProxy := func(enabled bool) *HTTPProxy {
+		return &HTTPProxy{
+			Config:          config.Proxy{RequestFilterEnabled: enabled},
+			Transport:       http.DefaultTransport,
+			RequestFiltered: filtered,
+			Lookup: func(r *http.Request) *route.Target {
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			},
+		}
+	}
+
+	tests := []struct {
+		desc    string
+		enabled bool
+		method  string
+		uri     string
+		status  int
+		allow   string
+	}{
+		{"allowed", true, "GET", "/api/users", 200, ""},
+		{"method not allowed", true, "POST", "/api/users", 405, "GET, HEAD"},
+		{"blocked path", true, "GET", "/api/internal/stats", 403, ""},
+		{"blocked suffix", true, "GET", "/api/users.bak", 403, ""},
+		{"other route", true, "POST", "/internal/stats", 200, ""},
+		{"path traversal", true, "GET", "/static/%2e%2e/%2e%2e/etc/passwd", 400, ""},
+		{"null byte", true, "GET", "/index.html%00.txt", 400, ""},
+		{"filter disabled", false, "GET", "/static/%2e%2e/etc/passwd", 200, ""},
+	}
+
+	var want int64
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newProxy(tt.enabled).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.uri, nil))
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Allow"), tt.allow; got != want {
+				t.Fatalf("got Allow %q want %q", got, want)
+			}
+		})
+		if tt.status != 200 {
+			want++
+		}
+	}
+	if got := atomic.LoadInt64(&filtered.n); got != want {
+		t.Fatalf("got %d rejected requests want %d", got, want)
+	}
+}
+
+func TestProxyAutoOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "1")
+	}))
+	defer server.Close()
+
+	routes := "route add api /api " + server.URL + ` opts "autooptions=true allowmethods=GET,HEAD blockpaths=^/api/internal"` + "\n" +
+		"route add off /off " + server.URL + ` opts "autooptions=false"` + "\n" +
+		"route add auth /auth " + server.URL + ` opts "autooptions=true auth=unknown"` + "\n" +
+		"route add www / " + server.URL
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+
+	tests := []struct {
+		desc      string
+		global    bool
+		path      string
+		preflight bool
+		status    int
+		allow     string
+	}{
+		{"route option", false, "/api", false, http.StatusNoContent, "GET, HEAD, OPTIONS"},
+		{"blocked path", false, "/api/internal", false, http.StatusForbidden, ""},
+		{"cors preflight", false, "/api", true, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"disabled", false, "/", false, http.StatusOK, ""},
+		{"global", true, "/", false, http.StatusNoContent, "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"},
+		{"disabled by route", true, "/off", false, http.StatusOK, ""},
+		{"unauthorized", false, "/auth", false, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := &HTTPProxy{
+				Config:    config.Proxy{AutoOptions: tt.global},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			}
+			req := httptest.NewRequest("OPTIONS", tt.path, nil)
+			if tt.preflight {
+				req.Header.Set("Origin", "https://example.com")
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Allow"), tt.allow; got != want {
+				t.Fatalf("got Allow %q want %q", got, want)
+			}
+		})
+	}
+}
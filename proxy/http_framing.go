@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// framingError is returned when the framing of the request body is
+// invalid. The reverse proxy responds with 400 Bad Request.
+type framingError struct {
+	err error
+}
+
+func (e *framingError) Error() string {
+	return "invalid request framing: " + e.err.Error()
+}
+
+// checkFraming returns an error if the Content-Length and the
+// Transfer-Encoding of the request are ambiguous so that an upstream
+// server could determine a different end of the request than fabio
+// (request smuggling).
+//
+// The HTTP server already removes the Content-Length header of chunked
+// requests and rejects differing duplicate Content-Length headers. The
+// checks guard against requests which have not been normalized.
+func checkFraming(r *http.Request) error {
+	te := r.TransferEncoding
+	if len(te) > 1 || (len(te) == 1 && te[0] != "chunked") {
+		return errors.New("unsupported Transfer-Encoding " + strconv.Quote(strings.Join(te, ", ")))
+	}
+	if _, ok := r.Header["Transfer-Encoding"]; ok {
+		return errors.New("unexpected Transfer-Encoding header")
+	}
+
+	cl := r.Header["Content-Length"]
+	switch {
+	case len(cl) == 0:
+		return nil
+	case len(te) > 0:
+		return errors.New("both Content-Length and Transfer-Encoding")
+	case len(cl) > 1:
+		return errors.New("multiple Content-Length headers")
+	}
+	n, err := strconv.ParseUint(cl[0], 10, 63)
+	if err != nil || int64(n) != r.ContentLength {
+		return errors.New("invalid Content-Length " + strconv.Quote(cl[0]))
+	}
+	return nil
+}
+
+// rejectFraming responds with 400 Bad Request to a request with
+// an invalid framing and closes the client connection.
+func (p *HTTPProxy) rejectFraming(w http.ResponseWriter, r *http.Request, err error) {
+	if p.FramingRejected != nil {
+		p.FramingRejected.Inc(1)
+	}
+	log.Printf("[WARN] Rejecting request from %s for %s. %s", r.RemoteAddr, r.URL, err)
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
+
+// chunkedBody turns the errors of reading a malformed chunked request
+// body into a framingError so that the request is rejected with 400 Bad
+// Request. The upstream request is aborted before the complete body has
+// been sent.
+type chunkedBody struct {
+	io.ReadCloser
+	rejected metrics.Counter
+	counted  int32
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && isChunkedError(err) {
+		if b.rejected != nil && atomic.CompareAndSwapInt32(&b.counted, 0, 1) {
+			b.rejected.Inc(1)
+		}
+		return n, &framingError{err}
+	}
+	return n, err
+}
+
+// isChunkedError returns true if err has been returned by the chunked
+// reader of the HTTP server for a malformed body. The errors are not
+// exported and are matched by their message.
+func isChunkedError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "chunk") || strings.Contains(msg, "header line too long")
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestCheckFraming(t *testing.T) {
+	tests := []struct {
+		desc   string
+		te     []string
+		header http.Header
+		cl     int64
+		ok     bool
+	}{
+		{"no body", nil, http.Header{}, 0, true},
+		{"content length", nil, http.Header{"Content-Length": {"5"}}, 5, true},
+		{"chunked", []string{"chunked"}, http.Header{}, -1, true},
+		{"content length and chunked", []string{"chunked"}, http.Header{"Content-Length": {"5"}}, -1, false},
+		{"multiple content lengths", nil, http.Header{"Content-Length": {"5", "6"}}, 5, false},
+		{"invalid content length", nil, http.Header{"Content-Length": {"+5"}}, 5, false},
+		{"content length mismatch", nil, http.Header{"Content-Length": {"6"}}, 5, false},
+		{"unsupported transfer encoding", []string{"gzip", "chunked"}, http.Header{}, -1, false},
+		{"transfer encoding header", nil, http.Header{"Transfer-Encoding": {"chunked"}}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := &http.Request{TransferEncoding: tt.te, Header: tt.header, ContentLength: tt.cl}
+			if err := checkFraming(r); (err == nil) != tt.ok {
+				t.Fatalf("got error %v want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProxyStrictFraming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL))
+	rejected := &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:          config.Proxy{StrictFraming: true},
+		Transport:       http.DefaultTransport,
+		FramingRejected: rejected,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		desc   string
+		req    string
+		status int
+		body   string
+	}{
+		{
+			desc:   "chunked",
+			req:    "POST / HTTP/1.1\r\nHost: a.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			status: http.StatusOK,
+			body:   "hello",
+		},
+		{
+			desc:   "malformed chunked",
+			req:    "POST / HTTP/1.1\r\nHost: a.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloX\r\n0\r\n\r\n",
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:   "differing content lengths",
+			req:    "POST / HTTP/1.1\r\nHost: a.com\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, err := net.Dial("tcp", proxy.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			io.WriteString(c, tt.req)
+			resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if tt.body != "" && !strings.Contains(string(body), tt.body) {
+				t.Fatalf("got body %q want %q", body, tt.body)
+			}
+		})
+	}
+
+	// only the malformed chunked request has reached the proxy
+	if got, want := atomic.LoadInt64(&rejected.n), int64(1); got != want {
+		t.Fatalf("got %d rejected requests want %d", got, want)
+	}
+}
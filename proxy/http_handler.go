@@ -2,12 +2,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,6 +45,12 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 
 	statusCode := http.StatusInternalServerError
 
+	if errors.Is(err, errDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		log.Print("[WARN] ", err)
+		writeDeadlineExceeded(w, r)
+		return
+	}
+
 	if e, ok := err.(net.Error); ok {
 		if e.Timeout() {
 			statusCode = http.StatusGatewayTimeout
@@ -53,6 +61,14 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 		statusCode = http.StatusBadGateway
 	} else if err == context.Canceled {
 		statusCode = StatusClientClosedRequest
+	} else if e, ok := err.(*transformError); ok {
+		statusCode = e.status
+	} else if fe := (*framingError)(nil); errors.As(err, &fe) {
+		statusCode = http.StatusBadRequest
+	} else if errors.Is(err, errPinMismatch) || errors.Is(err, errResponseTooLarge) {
+		statusCode = http.StatusBadGateway
+	} else if err == errRequestTooLarge {
+		statusCode = http.StatusRequestEntityTooLarge
 	}
 
 	w.WriteHeader(statusCode)
@@ -64,3 +80,57 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 
 	return
 }
+
+// resetTransport records whether the upstream server closed the
+// connection while the response body was read.
+type resetTransport struct {
+	rt    http.RoundTripper
+	reset int32
+}
+
+func (t *resetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, err
+	}
+	resp.Body = &resetBody{ReadCloser: resp.Body, req: req, t: t}
+	return resp, nil
+}
+
+// Reset returns true if reading the response body failed.
+func (t *resetTransport) Reset() bool {
+	return atomic.LoadInt32(&t.reset) == 1
+}
+
+type resetBody struct {
+	io.ReadCloser
+	req *http.Request
+	t   *resetTransport
+}
+
+func (b *resetBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	// errors caused by the client closing the connection
+	// are not upstream resets.
+	if err != nil && err != io.EOF && b.req.Context().Err() == nil {
+		atomic.StoreInt32(&b.t.reset, 1)
+	}
+	return n, err
+}
+
+// serveAbortable calls h and recovers from the http.ErrAbortHandler
+// panic which the reverse proxy raises when the response cannot be
+// completed. It returns true if the handler was aborted so that the
+// caller can record the request before aborting the client connection.
+func serveAbortable(h http.Handler, w http.ResponseWriter, r *http.Request) (aborted bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			aborted = true
+		}
+	}()
+	h.ServeHTTP(w, r)
+	return false
+}
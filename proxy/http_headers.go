@@ -5,9 +5,11 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
 )
 
 // addResponseHeaders adds/updates headers in the response
@@ -26,8 +28,44 @@ func addResponseHeaders(w http.ResponseWriter, r *http.Request, cfg config.Proxy
 	return nil
 }
 
+// responseHeaders returns the headers which are added to the responses
+// of the target. The headers of the route replace the global headers
+// with the same name. Strict-Transport-Security headers are only added
+// to responses for TLS connections.
+func responseHeaders(global []config.ResponseHeader, t *route.Target, isTLS bool) []config.ResponseHeader {
+	var hdrs []config.ResponseHeader
+	add := func(h config.ResponseHeader) {
+		if !isTLS && http.CanonicalHeaderKey(h.Name) == "Strict-Transport-Security" {
+			return
+		}
+		hdrs = append(hdrs, h)
+	}
+
+	for _, h := range global {
+		if t != nil && t.ResponseHeaders.Get(h.Name) != "" {
+			continue
+		}
+		add(h)
+	}
+
+	if t == nil {
+		return hdrs
+	}
+	var names []string
+	for name := range t.ResponseHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(config.ResponseHeader{Name: name, Value: t.ResponseHeaders.Get(name), Force: t.ForceResponseHeaders})
+	}
+	return hdrs
+}
+
 // addHeaders adds/updates headers in request
 //
+// * remove the headers listed in the Connection header
+// * remove the HeaderSanitize headers of untrusted clients
 // * add/update `Forwarded` header
 // * add X-Forwarded-Proto header, if not present
 // * add X-Real-Ip, if not present
@@ -40,6 +78,13 @@ func addHeaders(r *http.Request, cfg config.Proxy, stripPath string) error {
 		return errors.New("cannot parse " + r.RemoteAddr)
 	}
 
+	removeConnectionHeaders(r)
+	if len(cfg.HeaderSanitize) > 0 && !trustedClient(remoteIP, cfg.HeaderSanitizeTrusted) {
+		for _, h := range cfg.HeaderSanitize {
+			r.Header.Del(h)
+		}
+	}
+
 	// set configurable ClientIPHeader
 	// X-Real-Ip is set later and X-Forwarded-For is set
 	// by the Go HTTP reverse proxy.
@@ -124,6 +169,50 @@ func addHeaders(r *http.Request, cfg config.Proxy, stripPath string) error {
 	return nil
 }
 
+// removeConnectionHeaders removes the headers which are listed in the
+// Connection header of the request since they are meant for the first
+// hop only. Otherwise, a client could list a header which is set below
+// and have it removed by the reverse proxy. The close, keep-alive and
+// upgrade options are kept.
+func removeConnectionHeaders(r *http.Request) {
+	if len(r.Header["Connection"]) == 0 {
+		return
+	}
+	var opts []string
+	for _, v := range r.Header["Connection"] {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			switch strings.ToLower(s) {
+			case "":
+			case "close", "keep-alive", "upgrade":
+				opts = append(opts, s)
+			default:
+				r.Header.Del(s)
+			}
+		}
+	}
+	if len(opts) == 0 {
+		r.Header.Del("Connection")
+		return
+	}
+	r.Header.Set("Connection", strings.Join(opts, ", "))
+}
+
+// trustedClient returns whether the client ip is in one of the trusted
+// networks in CIDR notation.
+func trustedClient(ip string, trusted []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, s := range trusted {
+		if _, n, err := net.ParseCIDR(s); err == nil && n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 var tlsver = map[uint16]string{
 	tls.VersionSSL30: "ssl30",
 	tls.VersionTLS10: "tls10",
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
 	"github.com/pascaldekloe/goe/verify"
 )
 
@@ -358,6 +359,47 @@ func TestAddHeaders(t *testing.T) {
 			},
 			"",
 		},
+
+		{"remove headers listed in Connection",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Connection": {"keep-alive, X-Real-Ip", "x-foo"}, "X-Foo": {"bar"}, "X-Real-Ip": {"6.6.6.6"}}},
+			config.Proxy{},
+			"",
+			http.Header{
+				"Connection":        []string{"keep-alive"},
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"sanitize headers of untrusted client",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"X-Forwarded-For": {"6.6.6.6"}, "X-Real-Ip": {"6.6.6.6"}, "X-Forwarded-Proto": {"https"}, "Forwarded": {"for=6.6.6.6"}}},
+			config.Proxy{HeaderSanitize: []string{"X-Forwarded-For", "x-real-ip", "Forwarded", "X-Forwarded-Proto"}, HeaderSanitizeTrusted: []string{"10.0.0.0/8"}},
+			"",
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"keep headers of trusted client",
+			&http.Request{RemoteAddr: "10.1.2.3:5555", Header: http.Header{"X-Forwarded-For": {"6.6.6.6"}, "X-Real-Ip": {"6.6.6.6"}}},
+			config.Proxy{HeaderSanitize: []string{"X-Forwarded-For", "X-Real-Ip"}, HeaderSanitizeTrusted: []string{"10.0.0.0/8"}},
+			"",
+			http.Header{
+				"Forwarded":         []string{"for=10.1.2.3; proto=http"},
+				"X-Forwarded-For":   []string{"6.6.6.6"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"6.6.6.6"},
+			},
+			"",
+		},
 	}
 
 	for i, tt := range tests {
@@ -497,3 +539,48 @@ func BenchmarkUint16Base16(b *testing.B) {
 	})
 	_ = s == s // use the var to make go1.10 vet happy
 }
+
+func TestResponseHeaders(t *testing.T) {
+	global := []config.ResponseHeader{
+		{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+		{Name: "X-Frame-Options", Value: "DENY"},
+	}
+	target := &route.Target{
+		ResponseHeaders:      http.Header{"X-Frame-Options": {"SAMEORIGIN"}, "X-Foo": {"bar"}},
+		ForceResponseHeaders: true,
+	}
+
+	tests := []struct {
+		desc  string
+		t     *route.Target
+		isTLS bool
+		hdrs  []config.ResponseHeader
+	}{
+		{
+			desc: "http",
+			hdrs: []config.ResponseHeader{{Name: "X-Frame-Options", Value: "DENY"}},
+		},
+		{
+			desc:  "https",
+			isTLS: true,
+			hdrs:  global,
+		},
+		{
+			desc:  "route overrides",
+			t:     target,
+			isTLS: true,
+			hdrs: []config.ResponseHeader{
+				{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+				{Name: "X-Foo", Value: "bar", Force: true},
+				{Name: "X-Frame-Options", Value: "SAMEORIGIN", Force: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := responseHeaders(global, tt.t, tt.isTLS)
+			verify.Values(t, "", got, tt.hdrs)
+		})
+	}
+}
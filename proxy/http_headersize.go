@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// headerSizeWarnInterval is the minimum time between two warnings
+// about large headers of a listener.
+const headerSizeWarnInterval = time.Second
+
+// HeaderSizes records the sizes of the request and the response headers
+// of a listener and logs a warning for requests whose headers are larger
+// than Warn. The warnings are sampled and at most one warning is logged
+// per second.
+type HeaderSizes struct {
+	// Request and Response record the header sizes in bytes.
+	Request, Response metrics.Histogram
+
+	// Warn is the header size in bytes above which a warning is
+	// logged. 0 disables the warnings.
+	Warn int
+
+	// lastWarn is the time of the last warning in nanoseconds
+	// and suppressed the number of warnings since then which
+	// were not logged.
+	lastWarn   int64
+	suppressed int64
+}
+
+// NewHeaderSizes returns the header size metrics of the listener at addr
+// which are http.headersize.<addr>.request and http.headersize.<addr>.response.
+func NewHeaderSizes(r metrics.Registry, addr string, warn int) *HeaderSizes {
+	prefix := "http.headersize." + handshakeListenerName.Replace(addr)
+	return &HeaderSizes{
+		Request:  r.GetHistogram(prefix + ".request"),
+		Response: r.GetHistogram(prefix + ".response"),
+		Warn:     warn,
+	}
+}
+
+// request records the header size of the request.
+func (s *HeaderSizes) request(r *http.Request) {
+	n := headerSize(r.Header) + len("Host: \r\n") + len(r.Host)
+	s.Request.Update(int64(n))
+	if s.Warn > 0 && n > s.Warn {
+		s.warn(r, "request", n)
+	}
+}
+
+// response records the header size of the response h to r.
+func (s *HeaderSizes) response(r *http.Request, h http.Header) {
+	n := headerSize(h)
+	s.Response.Update(int64(n))
+	if s.Warn > 0 && n > s.Warn {
+		s.warn(r, "response", n)
+	}
+}
+
+func (s *HeaderSizes) warn(r *http.Request, kind string, n int) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&s.lastWarn)
+	if now-last < int64(headerSizeWarnInterval) || !atomic.CompareAndSwapInt64(&s.lastWarn, last, now) {
+		atomic.AddInt64(&s.suppressed, 1)
+		return
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	log.Printf("[WARN] Large %s header of %d bytes for %s from %s (%d more suppressed)",
+		kind, n, r.URL.Path, ip, atomic.SwapInt64(&s.suppressed, 0))
+}
+
+// headerSize returns the size of the header in bytes in the
+// HTTP/1.1 encoding, i.e. "Name: value\r\n" per value.
+func headerSize(h http.Header) int {
+	var n int
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(v) + len(": \r\n")
+		}
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestHeaderSize(t *testing.T) {
+	h := http.Header{"A": {"bc"}, "De": {"f", "gh"}}
+	// "A: bc\r\n" + "De: f\r\n" + "De: gh\r\n"
+	if got, want := headerSize(h), 7+7+8; got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+}
+
+func TestProxyHeaderSizes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Big", strings.Repeat("x", 100))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	reg := &testRegistry{}
+	sizes := NewHeaderSizes(reg, ":9999", 50)
+	proxy := &HTTPProxy{
+		Config:      config.Proxy{},
+		Transport:   http.DefaultTransport,
+		HeaderSizes: sizes,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header = http.Header{"X-Small": {"1"}}
+	req.Host = "example.com"
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	hreq := reg.histograms["http.headersize._9999.request"]
+	if got, want := hreq.last, int64(len("X-Small: 1\r\n")+len("Host: example.com\r\n")); got != want {
+		t.Fatalf("got request header size %d want %d", got, want)
+	}
+	hresp := reg.histograms["http.headersize._9999.response"]
+	if got, want := hresp.n, int64(1); got != want {
+		t.Fatalf("got %d response header sizes want %d", got, want)
+	}
+	if hresp.last <= 100 {
+		t.Fatalf("got response header size %d want more than 100", hresp.last)
+	}
+
+	// only the first of the large headers is logged
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if got, want := strings.Count(buf.String(), "Large response header"), 1; got != want {
+		t.Fatalf("got %d warnings want %d: %s", got, want, buf.String())
+	}
+	if !strings.Contains(buf.String(), "for /foo from 192.0.2.1") {
+		t.Fatalf("got %q want path and client IP", buf.String())
+	}
+}
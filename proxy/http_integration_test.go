@@ -3,19 +3,28 @@ package proxy
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,8 +32,11 @@ import (
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy/internal"
+	"github.com/fabiolb/fabio/proxy/tcp"
 	"github.com/fabiolb/fabio/route"
 	"github.com/pascaldekloe/goe/verify"
+	"golang.org/x/net/http2"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -90,6 +102,160 @@ func TestProxyRequestIDHeader(t *testing.T) {
 	}
 }
 
+func TestProxyRequestIDPropagation(t *testing.T) {
+	got := "not called"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-Id")
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{RequestID: "X-Request-Id"},
+		Transport: http.DefaultTransport,
+		UUID:      func() string { return "f47ac10b-58cc-0372-8567-0e02b2c3d479" },
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	t.Run("generate", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", proxy.URL, nil)
+		resp, _ := mustDo(req)
+		if want := "f47ac10b-58cc-0372-8567-0e02b2c3d479"; got != want {
+			t.Errorf("got %v, but want %v", got, want)
+		}
+		if got, want := resp.Header.Get("X-Request-Id"), ""; got != want {
+			t.Errorf("got %v, but want %v", got, want)
+		}
+	})
+
+	t.Run("preserve", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", proxy.URL, nil)
+		req.Header.Set("X-Request-Id", "abc")
+		mustDo(req)
+		if want := "abc"; got != want {
+			t.Errorf("got %v, but want %v", got, want)
+		}
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", proxy.URL+"/fail", nil)
+		req.Header.Set("X-Request-Id", "abc")
+		resp, _ := mustDo(req)
+		if got, want := resp.Header.Get("X-Request-Id"), "abc"; got != want {
+			t.Errorf("got %v, but want %v", got, want)
+		}
+	})
+}
+
+type eventLogger struct {
+	mu     sync.Mutex
+	events []*logger.Event
+}
+
+func (l *eventLogger) Log(e *logger.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func (l *eventLogger) last() *logger.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.events[len(l.events)-1]
+}
+
+func TestProxyFairQueueRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	l := &eventLogger{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{RequestID: "X-Request-Id"},
+		Transport: http.DefaultTransport,
+		FairQueue: &FairQueue{},
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{Service: "svc", URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	})
+	defer proxy.Close()
+
+	resp, _ := mustGet(proxy.URL)
+	if got, want := resp.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if resp.Header.Get("X-Request-Id") == "" {
+		t.Fatal("got no request id")
+	}
+	e := l.last()
+	if got, want := e.Response.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("got access log status %d want %d", got, want)
+	}
+	if got, want := e.UpstreamService, "svc"; got != want {
+		t.Fatalf("got access log service %q want %q", got, want)
+	}
+}
+
+func TestProxyTraceID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	l := &eventLogger{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{TraceIDHeader: "X-Trace-Id"},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	})
+	defer proxy.Close()
+
+	t.Run("error response", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", proxy.URL+"/fail", nil)
+		req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		resp, _ := mustDo(req)
+		if got, want := resp.Header.Get("X-Trace-Id"), "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+			t.Errorf("got %v, but want %v", got, want)
+		}
+		if got, want := l.last().TraceID, "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+			t.Errorf("got access log trace id %v, but want %v", got, want)
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", proxy.URL+"/fail", nil)
+		resp, _ := mustDo(req)
+		id := resp.Header.Get("X-Trace-Id")
+		if len(id) != 32 {
+			t.Fatalf("got trace id %q", id)
+		}
+		if got, want := l.last().TraceID, id; got != want {
+			t.Errorf("got access log trace id %v, but want %v", got, want)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", proxy.URL, nil)
+		resp, _ := mustDo(req)
+		if got, want := resp.Header.Get("X-Trace-Id"), ""; got != want {
+			t.Errorf("got %v, but want %v", got, want)
+		}
+		if l.last().TraceID == "" {
+			t.Error("got no access log trace id")
+		}
+	})
+}
+
 func TestProxySTSHeader(t *testing.T) {
 	server := httptest.NewServer(okHandler)
 	defer server.Close()
@@ -125,6 +291,83 @@ func TestProxySTSHeader(t *testing.T) {
 	}
 }
 
+func TestProxyResponseHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("Server", "backend")
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+
+	tbl, err := route.NewTable(bytes.NewBufferString(
+		"route add srv / " + server.URL + "\n" +
+			"route add srv /route " + server.URL + ` opts "addrespheader=X-Content-Type-Options:none|X-Frame-Options:DENY"` + "\n" +
+			"route add srv /force " + server.URL + ` opts "addrespheader=X-Frame-Options:DENY forcerespheaders=true"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config: config.Proxy{
+			ResponseHeaders: []config.ResponseHeader{
+				{Name: "X-Content-Type-Options", Value: "nosniff"},
+				{Name: "X-Frame-Options", Value: "DENY"},
+				{Name: "Server", Value: "fabio", Force: true},
+				{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+			},
+		},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		path string
+		hdrs http.Header
+	}{
+		{
+			path: "/",
+			hdrs: http.Header{
+				"X-Content-Type-Options": {"nosniff"},
+				"X-Frame-Options":        {"SAMEORIGIN"},
+				"Server":                 {"fabio"},
+			},
+		},
+		{
+			path: "/route",
+			hdrs: http.Header{
+				"X-Content-Type-Options": {"none"},
+				"X-Frame-Options":        {"SAMEORIGIN"},
+				"Server":                 {"fabio"},
+			},
+		},
+		{
+			path: "/force",
+			hdrs: http.Header{
+				"X-Content-Type-Options": {"nosniff"},
+				"X-Frame-Options":        {"DENY"},
+				"Server":                 {"fabio"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, _ := mustGet(proxy.URL + tt.path)
+			for k, v := range tt.hdrs {
+				if got, want := resp.Header[k], v; !reflect.DeepEqual(got, want) {
+					t.Errorf("got %s %q want %q", k, got, want)
+				}
+			}
+			if got := resp.Header.Get("Strict-Transport-Security"); got != "" {
+				t.Errorf("got Strict-Transport-Security %q for http response want none", got)
+			}
+		})
+	}
+}
+
 func TestProxyChecksHeaderForAccessRules(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "OK")
@@ -183,348 +426,2129 @@ func TestProxyNoRouteStatus(t *testing.T) {
 	}
 }
 
-func TestProxyStripsPath(t *testing.T) {
+func TestProxyMethodOverride(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.RequestURI {
-		case "/bar":
-			w.Write([]byte("OK"))
-		default:
-			w.WriteHeader(404)
-		}
+		fmt.Fprint(w, r.Method, " ", r.Header.Get("X-HTTP-Method-Override"))
 	}))
+	defer server.Close()
 
+	var lookupMethod string
 	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{MethodOverrideHeader: "X-HTTP-Method-Override"},
 		Transport: http.DefaultTransport,
 		Lookup: func(r *http.Request) *route.Target {
-			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock /foo/bar " + server.URL + ` opts "strip=/foo"`))
-			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			lookupMethod = r.Method
+			return &route.Target{URL: mustParse(server.URL)}
 		},
 	})
 	defer proxy.Close()
 
-	resp, body := mustGet(proxy.URL + "/foo/bar")
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		t.Fatalf("got status %d want %d", got, want)
+	tests := []struct {
+		method, override string
+		want             string
+	}{
+		{"POST", "DELETE", "DELETE "},
+		{"POST", "patch", "PATCH "},
+		{"POST", "PUT", "PUT "},
+		{"POST", "CONNECT", "POST CONNECT"},
+		{"POST", "", "POST "},
+		{"GET", "DELETE", "GET DELETE"},
 	}
-	if got, want := string(body), "OK"; got != want {
-		t.Fatalf("got body %q want %q", got, want)
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.override, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, proxy.URL, nil)
+			if tt.override != "" {
+				req.Header.Set("X-HTTP-Method-Override", tt.override)
+			}
+			_, body := mustDo(req)
+			if got, want := string(body), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+			if got, want := lookupMethod, strings.Fields(tt.want)[0]; got != want {
+				t.Fatalf("got lookup method %q want %q", got, want)
+			}
+		})
 	}
 }
 
-func TestProxyHost(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, r.Host)
-	}))
+func TestProxyNoRouteFast(t *testing.T) {
+	noroute.SetHTML("")
+	l := &eventLogger{}
+	h := &HTTPProxy{
+		Config: config.Proxy{
+			NoRouteStatus:   404,
+			RequestID:       "X-Request-Id",
+			ResponseHeaders: []config.ResponseHeader{{Name: "X-Foo", Value: "bar", Force: true}},
+		},
+		Transport: http.DefaultTransport,
+		Lookup:    func(*http.Request) *route.Target { return nil },
+		Logger:    l,
+	}
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
 
-	// create a static route table so that we can see the effect
-	// of round robin distribution. The other tests generate the
-	// route table on the fly since order does not matter to them.
-	routes := "route add mock /hostdst http://a.com/ opts \"host=dst\"\n"
-	routes += "route add mock /hostcustom http://a.com/ opts \"host=foo.com\"\n"
-	routes += "route add mock /hostcustom http://b.com/ opts \"host=bar.com\"\n"
-	routes += "route add mock / http://a.com/"
-	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+	resp, body := mustGet(proxy.URL + "/foo")
+	if got, want := resp.StatusCode, 404; got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+	if len(body) != 0 {
+		t.Fatalf("got body %q want empty body", body)
+	}
+	for _, h := range []string{"X-Request-Id", "X-Foo"} {
+		if got := resp.Header.Get(h); got != "" {
+			t.Fatalf("got header %s: %q want none", h, got)
+		}
+	}
+	if len(l.events) != 0 {
+		t.Fatal("request without route logged")
+	}
+
+	// the requests are logged if enabled
+	h.Config.NoRouteLog = true
+	mustGet(proxy.URL + "/foo")
+	e := l.last()
+	if got, want := e.Response.StatusCode, 404; got != want {
+		t.Fatalf("got access log status %d want %d", got, want)
+	}
+	if got, want := e.RequestURL.Path, "/foo"; got != want {
+		t.Fatalf("got access log path %q want %q", got, want)
+	}
+}
 
+func TestProxyNoRouteLimit(t *testing.T) {
+	noroute.SetHTML("")
+	dropped := &testCounter{}
 	proxy := httptest.NewServer(&HTTPProxy{
-		Transport: &http.Transport{
-			Dial: func(network, addr string) (net.Conn, error) {
-				addr = server.URL[len("http://"):]
-				return net.Dial(network, addr)
-			},
-		},
-		Lookup: func(r *http.Request) *route.Target {
-			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
-		},
+		Config:         config.Proxy{NoRouteStatus: 404},
+		Transport:      http.DefaultTransport,
+		Lookup:         func(*http.Request) *route.Target { return nil },
+		NoRouteLimit:   rate.NewLimiter(rate.Every(time.Hour), 2),
+		NorouteDropped: dropped,
 	})
 	defer proxy.Close()
 
-	check := func(t *testing.T, uri, host string) {
-		resp, body := mustGet(proxy.URL + uri)
-		if got, want := resp.StatusCode, http.StatusOK; got != want {
-			t.Fatalf("got status %d want %d", got, want)
+	// the client retries requests on reused connections which were closed
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if got, want := string(body), host; got != want {
-			t.Fatalf("got body %q want %q", got, want)
+		resp.Body.Close()
+		if got, want := resp.StatusCode, 404; got != want {
+			t.Fatalf("%d: got %d want %d", i, got, want)
 		}
 	}
 
-	proxyHost := proxy.URL[len("http://"):]
+	if _, err := client.Get(proxy.URL); err == nil {
+		t.Fatal("got response want dropped connection")
+	}
+	if got, want := atomic.LoadInt64(&dropped.n), int64(1); got != want {
+		t.Fatalf("got %d dropped requests want %d", got, want)
+	}
+}
 
-	// test that for 'host=dst' the Host header is set to the hostname of the
-	// target, in this case 'a.com'
-	t.Run("host eq dst", func(t *testing.T) { check(t, "/hostdst", "a.com") })
+func TestProxySlowBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		fmt.Fprint(w, n)
+	}))
+	defer server.Close()
 
-	// test that without a 'host' option no Host header is set
-	t.Run("no host", func(t *testing.T) { check(t, "/", proxyHost) })
+	dropped := &testCounter{}
+	proxy := httptest.NewUnstartedServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		ReadBodyTimeout: 100 * time.Millisecond,
+		ReadBodyMinRate: 100,
+		SlowBodyDropped: dropped,
+	})
+	proxy.Config.ConnContext = connContext
+	proxy.Start()
+	defer proxy.Close()
 
-	// 1. Test that a host header is set when the 'host' option is used.
-	//
-	// 2. Test that the host header is set per target, i.e. that different
-	//    targets can have different 'host' options.
-	//
-	//    The proxy is configured to use "rr" (round-robin) distribution
-	//    for the requests. Therefore, requests to '/hostcustom' will be
-	//    sent to the two different targets in alternating order.
-	t.Run("host is custom", func(t *testing.T) {
-		check(t, "/hostcustom", "foo.com")
-		check(t, "/hostcustom", "bar.com")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	t.Run("steady", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		go func() {
+			// 300 bytes per second over several intervals
+			for i := 0; i < 10; i++ {
+				pw.Write(bytes.Repeat([]byte("x"), 15))
+				time.Sleep(50 * time.Millisecond)
+			}
+			pw.Close()
+		}()
+		resp, err := client.Post(proxy.URL, "text/plain", pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := string(body), "150"; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	})
+
+	t.Run("trickle", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			defer pw.Close()
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(50 * time.Millisecond):
+					if _, err := pw.Write([]byte("x")); err != nil {
+						return
+					}
+				}
+			}
+		}()
+		resp, err := client.Post(proxy.URL, "text/plain", pr)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				t.Fatal("got response want dropped connection")
+			}
+		}
+		if got, want := atomic.LoadInt64(&dropped.n), int64(1); got != want {
+			t.Fatalf("got %d dropped connections want %d", got, want)
+		}
 	})
 }
 
-func TestHostRedirect(t *testing.T) {
-	routes := "route add https-redir *:80 https://$host$path opts \"redirect=301\"\n"
+func TestProxySlowBodyHTTP2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		fmt.Fprint(w, n)
+	}))
+	defer server.Close()
 
-	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+	dropped := &testCounter{}
+	proxy := httptest.NewUnstartedServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		ReadBodyTimeout: 100 * time.Millisecond,
+		ReadBodyMinRate: 100,
+		SlowBodyDropped: dropped,
+	})
+	proxy.Config.ConnContext = connContext
+	proxy.EnableHTTP2 = true
+	proxy.StartTLS()
+	defer proxy.Close()
+
+	client := proxy.Client()
+	client.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+
+	// the slow request holds the connection while the other requests
+	// are sent on other streams of the same connection
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer pw.Close()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+				if _, err := pw.Write([]byte("x")); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	slow := make(chan error, 1)
+	go func() {
+		resp, err := client.Post(proxy.URL, "text/plain", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+		slow <- err
+	}()
+
+	var conns int64
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				atomic.AddInt64(&conns, 1)
+			}
+		},
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&dropped.n) == 0 && time.Now().Before(deadline) {
+		req, _ := http.NewRequest("POST", proxy.URL, strings.NewReader("foo"))
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := resp.ProtoMajor, 2; got != want {
+			t.Fatalf("got HTTP/%d want HTTP/%d", got, want)
+		}
+		if got, want := string(body), "3"; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	<-slow
+
+	if got, want := atomic.LoadInt64(&dropped.n), int64(1); got != want {
+		t.Fatalf("got %d dropped requests want %d", got, want)
+	}
+
+	// the connection survives the dropped request
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := atomic.LoadInt64(&conns), int64(1); got != want {
+		t.Fatalf("got %d connections want %d", got, want)
+	}
+}
+
+func TestProxyTransform(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := string(body), `{"data":{"name":"foo"}}`; got != want {
+			t.Errorf("got request body %q want %q", got, want)
+		}
+		if got, want := r.ContentLength, int64(len(body)); got != want {
+			t.Errorf("got request content length %d want %d", got, want)
+		}
+		if r.URL.Path == "/fail" {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":{"id":1}}`)
+	}))
+	defer server.Close()
+
+	newProxy := func(transform string) *httptest.Server {
+		return httptest.NewServer(&HTTPProxy{
+			Config:    config.Proxy{TransformMaxBody: 1024},
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "transform=` + transform + `"`))
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			},
+		})
+	}
+
+	post := func(proxyURL, body string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("POST", proxyURL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return mustDo(req)
+	}
+
+	proxy := newProxy("jsonenvelope")
+	defer proxy.Close()
+
+	resp, body := post(proxy.URL, `{"name":"foo"}`)
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), `{"id":1}`; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	if got, want := resp.Header.Get("Content-Length"), "8"; got != want {
+		t.Fatalf("got content length %q want %q", got, want)
+	}
+
+	resp, _ = post(proxy.URL, `{`)
+	if got, want := resp.StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("got status %d want %d for invalid request", got, want)
+	}
+
+	// error responses are not transformed
+	resp, body = post(proxy.URL+"/fail", `{"name":"foo"}`)
+	if got, want := resp.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("got status %d want %d for error response", got, want)
+	}
+	if got, want := string(body), "service unavailable\n"; got != want {
+		t.Fatalf("got body %q want %q for error response", got, want)
+	}
+
+	unknown := newProxy("foo")
+	defer unknown.Close()
+
+	resp, _ = post(unknown.URL, `{"name":"foo"}`)
+	if got, want := resp.StatusCode, http.StatusInternalServerError; got != want {
+		t.Fatalf("got status %d want %d for unknown transform", got, want)
+	}
+}
+
+func TestProxyTransformCompressed(t *testing.T) {
+	// the server compresses the response although
+	// the compression has not been requested.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/br" {
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte{0x0b, 0x02, 0x80})
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		io.WriteString(gz, `{"data":{"id":1}}`)
+		gz.Close()
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{TransformMaxBody: 1024},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "transform=jsonenvelope"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", proxy.URL+path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		return mustDo(req)
+	}
+
+	resp, body := get("/", "gzip, deflate")
+	if got, want := resp.Header.Get("Content-Encoding"), "gzip"; got != want {
+		t.Fatalf("got content encoding %q want %q", got, want)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(gz)
+	if got, want := string(body), `{"id":1}`; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+
+	resp, body = get("/", "identity")
+	if got, want := resp.Header.Get("Content-Encoding"), ""; got != want {
+		t.Fatalf("got content encoding %q want %q", got, want)
+	}
+	if got, want := string(body), `{"id":1}`; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+
+	resp, _ = get("/br", "br")
+	if got, want := resp.StatusCode, http.StatusBadGateway; got != want {
+		t.Fatalf("got status %d want %d for unsupported encoding", got, want)
+	}
+}
+
+func TestProxyTransformMaxBody(t *testing.T) {
+	large := `{"data":"` + strings.Repeat("a", 2048) + `"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/large":
+			io.WriteString(w, large)
+		case "/bomb":
+			// small on the wire but larger than the limit
+			// after the decompression.
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, large)
+			gz.Close()
+		default:
+			io.WriteString(w, `{"data":{"id":1}}`)
+		}
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{TransformMaxBody: 1024},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "transform=jsonenvelope"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		path, body string
+		status     int
+	}{
+		{"/", `{"name":"foo"}`, http.StatusOK},
+		{"/", large, http.StatusRequestEntityTooLarge},
+		{"/large", "", http.StatusBadGateway},
+		{"/bomb", "", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", proxy.URL+tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := mustDo(req)
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Fatalf("%s: got status %d want %d", tt.path, got, want)
+		}
+	}
+}
+
+func TestProxyAggregate(t *testing.T) {
+	newServer := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.URL.Path, "/items"; got != want {
+				t.Errorf("got path %q want %q", got, want)
+			}
+			if got, want := r.Header.Get("X-Forwarded-For"), "127.0.0.1"; got != want {
+				t.Errorf("got X-Forwarded-For %q want %q", got, want)
+			}
+			if got, want := r.Header.Get("X-Forwarded-Proto"), "http"; got != want {
+				t.Errorf("got X-Forwarded-Proto %q want %q", got, want)
+			}
+			if v := r.Header.Get("Proxy-Authorization"); v != "" {
+				t.Errorf("got Proxy-Authorization %q want none", v)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, body)
+		}))
+	}
+	a, b := newServer(`[1,2]`), newServer(`[3]`)
+	defer a.Close()
+	defer b.Close()
+
+	newProxy := func(aggregate string) *httptest.Server {
+		return httptest.NewServer(&HTTPProxy{
+			Config:    config.Proxy{AggregateMaxBody: 4},
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable(bytes.NewBufferString(
+					"route add a /api " + a.URL + ` opts "strip=/api aggregate=` + aggregate + `"` + "\n" +
+						"route add b /api " + b.URL + ` opts "strip=/api aggregate=` + aggregate + `"`))
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			},
+		})
+	}
+
+	proxy := newProxy("jsonconcat")
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/api/items", nil)
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	resp, body := mustDo(req)
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), `[1,2,3]`; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{"foo", http.StatusOK},
+		{"foobar", http.StatusRequestEntityTooLarge},
+	} {
+		resp, err := http.Post(proxy.URL+"/api/items", "text/plain", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Fatalf("%q: got status %d want %d", tt.body, got, want)
+		}
+	}
+
+	unknown := newProxy("foo")
+	defer unknown.Close()
+
+	resp, _ = mustGet(unknown.URL + "/api/items")
+	if got, want := resp.StatusCode, http.StatusInternalServerError; got != want {
+		t.Fatalf("got status %d want %d for unknown aggregator", got, want)
+	}
+}
+
+func TestProxyServerTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	header := regexp.MustCompile(`^backend;dur=(\d+\.\d{3}), fabio;dur=\d+\.\d{3}$`)
+
+	tests := []struct {
+		desc   string
+		global bool
+		opts   string
+		want   bool
+	}{
+		{"disabled", false, "", false},
+		{"enabled", true, "", true},
+		{"enabled for route", false, "servertiming=true", true},
+		{"disabled for route", true, "servertiming=false", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{ServerTiming: tt.global},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			resp, _ := mustGet(proxy.URL)
+			v := resp.Header.Get("Server-Timing")
+			if !tt.want {
+				if v != "" {
+					t.Fatalf("got Server-Timing %q want none", v)
+				}
+				return
+			}
+			m := header.FindStringSubmatch(v)
+			if m == nil {
+				t.Fatalf("got Server-Timing %q", v)
+			}
+			if backend, _ := strconv.ParseFloat(m[1], 64); backend < 20 {
+				t.Fatalf("got backend time %v ms want at least 20 ms", backend)
+			}
+		})
+	}
+}
+
+func TestProxyBuffer(t *testing.T) {
+	var hits int64
+	var length int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		atomic.StoreInt64(&length, r.ContentLength)
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "foo" {
+			t.Errorf("got body %q want %q", body, "foo")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc   string
+		opts   string
+		hits   int64
+		length int64
+	}{
+		{"default", "", 2, -1},
+		{"buffered", "buffer=true", 2, 3},
+		{"streamed", "buffer=false", 1, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			atomic.StoreInt64(&hits, 0)
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{RetryOn: []string{"503"}, RetryAttempts: 1, RetryMaxBody: 1024, BufferMaxBody: 1024},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			// hide the length of the body so that it is sent chunked
+			body := struct{ io.Reader }{strings.NewReader("foo")}
+			req, err := http.NewRequest("PUT", proxy.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, http.StatusServiceUnavailable; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := atomic.LoadInt64(&hits), tt.hits; got != want {
+				t.Fatalf("got %d upstream requests want %d", got, want)
+			}
+			if got, want := atomic.LoadInt64(&length), tt.length; got != want {
+				t.Fatalf("got content length %d want %d", got, want)
+			}
+		})
+	}
+
+	t.Run("too large", func(t *testing.T) {
+		atomic.StoreInt64(&hits, 0)
+		proxy := httptest.NewServer(&HTTPProxy{
+			Config:    config.Proxy{BufferMaxBody: 2},
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "buffer=true"`))
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			},
+		})
+		defer proxy.Close()
+
+		body := struct{ io.Reader }{strings.NewReader("foo")}
+		req, err := http.NewRequest("PUT", proxy.URL, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusRequestEntityTooLarge; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got := atomic.LoadInt64(&hits); got != 0 {
+			t.Fatalf("got %d upstream requests want 0", got)
+		}
+	})
+}
+
+func TestProxyDeprecation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</page/2>; rel="next"`)
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc                string
+		opts                string
+		deprecation, sunset string
+		link                []string
+	}{
+		{"not deprecated", "", "", "", []string{`</page/2>; rel="next"`}},
+		{"deprecated", "deprecation=true", "true", "", []string{`</page/2>; rel="next"`}},
+		{
+			"deprecated with date, sunset and link",
+			"deprecation=2024-06-01T00:00:00Z sunset=2025-06-01T00:00:00Z deprecationlink=https://example.com/migrate",
+			"@1717200000",
+			"Sun, 01 Jun 2025 00:00:00 GMT",
+			[]string{`</page/2>; rel="next"`, `<https://example.com/migrate>; rel="deprecation"; type="text/html"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			resp, _ := mustGet(proxy.URL)
+			if got, want := resp.Header.Get("Deprecation"), tt.deprecation; got != want {
+				t.Fatalf("got Deprecation %q want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Sunset"), tt.sunset; got != want {
+				t.Fatalf("got Sunset %q want %q", got, want)
+			}
+			if got, want := resp.Header["Link"], tt.link; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got Link %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyStripsPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.RequestURI {
+		case "/bar":
+			w.Write([]byte("OK"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock /foo/bar " + server.URL + ` opts "strip=/foo"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	resp, body := mustGet(proxy.URL + "/foo/bar")
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestProxyHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+
+	// create a static route table so that we can see the effect
+	// of round robin distribution. The other tests generate the
+	// route table on the fly since order does not matter to them.
+	routes := "route add mock /hostdst http://a.com/ opts \"host=dst\"\n"
+	routes += "route add mock /hostcustom http://a.com/ opts \"host=foo.com\"\n"
+	routes += "route add mock /hostcustom http://b.com/ opts \"host=bar.com\"\n"
+	routes += "route add mock / http://a.com/"
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				addr = server.URL[len("http://"):]
+				return net.Dial(network, addr)
+			},
+		},
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	check := func(t *testing.T, uri, host string) {
+		resp, body := mustGet(proxy.URL + uri)
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := string(body), host; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	}
+
+	proxyHost := proxy.URL[len("http://"):]
+
+	// test that for 'host=dst' the Host header is set to the hostname of the
+	// target, in this case 'a.com'
+	t.Run("host eq dst", func(t *testing.T) { check(t, "/hostdst", "a.com") })
+
+	// test that without a 'host' option no Host header is set
+	t.Run("no host", func(t *testing.T) { check(t, "/", proxyHost) })
+
+	// 1. Test that a host header is set when the 'host' option is used.
+	//
+	// 2. Test that the host header is set per target, i.e. that different
+	//    targets can have different 'host' options.
+	//
+	//    The proxy is configured to use "rr" (round-robin) distribution
+	//    for the requests. Therefore, requests to '/hostcustom' will be
+	//    sent to the two different targets in alternating order.
+	t.Run("host is custom", func(t *testing.T) {
+		check(t, "/hostcustom", "foo.com")
+		check(t, "/hostcustom", "bar.com")
+	})
+}
+
+func TestHostRedirect(t *testing.T) {
+	routes := "route add https-redir *:80 https://$host$path opts \"redirect=301\"\n"
+
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			r.Host = "c.com"
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		req      string
+		wantCode int
+		wantLoc  string
+	}{
+		{req: "/baz", wantCode: 301, wantLoc: "https://c.com/baz"},
+	}
+
+	http.DefaultClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// do not follow redirects
+		return http.ErrUseLastResponse
+	}
+
+	for _, tt := range tests {
+		resp, _ := mustGet(proxy.URL + tt.req)
+		if resp.StatusCode != tt.wantCode {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, tt.wantCode)
+		}
+		gotLoc, _ := resp.Location()
+		if gotLoc.String() != tt.wantLoc {
+			t.Errorf("got location %s, want %s", gotLoc, tt.wantLoc)
+		}
+	}
+}
+
+func TestPathRedirect(t *testing.T) {
+	routes := "route add mock / http://a.com/$path opts \"redirect=301\"\n"
+	routes += "route add mock /foo http://a.com/abc opts \"redirect=301\"\n"
+	routes += "route add mock /bar http://b.com/$path opts \"redirect=302 strip=/bar\"\n"
+	routes += "route add mock /old/ https://c.com/new/$1 opts \"redirect=308 redirectmatch=^/old/(.*)$ redirectquery=keep\"\n"
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		req      string
+		wantCode int
+		wantLoc  string
+	}{
+		{req: "/", wantCode: 301, wantLoc: "http://a.com/"},
+		{req: "/aaa/bbb", wantCode: 301, wantLoc: "http://a.com/aaa/bbb"},
+		{req: "/foo", wantCode: 301, wantLoc: "http://a.com/abc"},
+		{req: "/bar", wantCode: 302, wantLoc: "http://b.com/"},
+		{req: "/bar/aaa", wantCode: 302, wantLoc: "http://b.com/aaa"},
+		{req: "/old/aaa?x=1", wantCode: 308, wantLoc: "https://c.com/new/aaa?x=1"},
+	}
+
+	http.DefaultClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// do not follow redirects
+		return http.ErrUseLastResponse
+	}
+
+	for _, tt := range tests {
+		resp, _ := mustGet(proxy.URL + tt.req)
+		if resp.StatusCode != tt.wantCode {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, tt.wantCode)
+		}
+		gotLoc, _ := resp.Location()
+		if gotLoc.String() != tt.wantLoc {
+			t.Errorf("got location %s, want %s", gotLoc, tt.wantLoc)
+		}
+	}
+}
+
+func TestProxyLogOutput(t *testing.T) {
+	t.Run("uncompressed response", func(t *testing.T) {
+		testProxyLogOutput(t, 73, config.Proxy{})
+	})
+	t.Run("compression enabled but no match", func(t *testing.T) {
+		testProxyLogOutput(t, 73, config.Proxy{
+			GZIPContentTypes: regexp.MustCompile(`^$`),
+		})
+	})
+	t.Run("compression enabled and active", func(t *testing.T) {
+		testProxyLogOutput(t, 28, config.Proxy{
+			GZIPContentTypes: regexp.MustCompile(`.*`),
+		})
+	})
+}
+
+func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
+	t.Helper()
+
+	// build a format string from all log fields and one header field
+	fields := []string{"header.X-Foo:$header.X-Foo"}
+	for _, k := range logger.Fields {
+		fields = append(fields, k[1:]+":"+k)
+	}
+	format := strings.Join(fields, ";")
+
+	// create a logger
+	var b bytes.Buffer
+	l, err := logger.New(&b, format)
+	if err != nil {
+		t.Fatal("logger.New: ", err)
+	}
+
+	// create an upstream server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "foooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooo")
+	}))
+	defer server.Close()
+
+	// create a proxy handler with mocked time
+	tm := time.Date(2016, 1, 1, 0, 0, 0, 12345678, time.UTC)
+	proxyHandler := &HTTPProxy{
+		Config: cfg,
+		Time: func() time.Time {
+			defer func() { tm = tm.Add(1111111111 * time.Nanosecond) }()
+			return tm
+		},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{
+				Service: "svc-a",
+				URL:     mustParse(server.URL),
+			}
+		},
+		Logger: l,
+	}
+
+	// start an http server with the proxy handler
+	// which captures some parameters from the request
+	var remoteAddr string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		proxyHandler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	// create the request
+	req, _ := http.NewRequest("GET", proxy.URL+"/foo?x=y", nil)
+	req.Host = "example.com"
+	req.Header.Set("X-Foo", "bar")
+	req.Header.Set("X-B3-Traceid", "463ac35c9f6413ad")
+
+	// execute the request
+	resp, _ := mustDo(req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("http.Get: want 200 got ", resp.StatusCode)
+	}
+
+	upstreamURL, _ := url.Parse(server.URL)
+	upstreamHost, upstreamPort, _ := net.SplitHostPort(upstreamURL.Host)
+	remoteHost, remotePort, _ := net.SplitHostPort(remoteAddr)
+	want := []string{
+		"bytes_received:0",
+		"bytes_sent:0",
+		"duration_ms:1.111",
+		"duration_ns:1.111111111",
+		"duration_us:1.111111",
+		"header.X-Foo:bar",
+		"remote_addr:" + remoteAddr,
+		"remote_host:" + remoteHost,
+		"remote_port:" + remotePort,
+		"request:GET /foo?x=y HTTP/1.1",
+		"request_args:x=y",
+		"request_host:example.com",
+		"request_id:",
+		"request_method:GET",
+		"request_proto:HTTP/1.1",
+		"request_scheme:http",
+		"request_uri:/foo?x=y",
+		"request_url:http://example.com/foo?x=y",
+		"response_body_size:" + strconv.Itoa(bodySize),
+		"response_status:200",
+		"response_time_ms:1.111",
+		"response_time_ns:1.111111111",
+		"response_time_us:1.111111",
+		"server_name:",
+		"time_common:01/Jan/2016:00:00:01 +0000",
+		"time_rfc3339:2016-01-01T00:00:01Z",
+		"time_rfc3339_ms:2016-01-01T00:00:01.123Z",
+		"time_rfc3339_ns:2016-01-01T00:00:01.123456789Z",
+		"time_rfc3339_us:2016-01-01T00:00:01.123456Z",
+		"time_unix_ms:1451606401123",
+		"time_unix_ns:1451606401123456789",
+		"time_unix_us:1451606401123456",
+		"trace_id:463ac35c9f6413ad",
+		"upstream_addr:" + upstreamURL.Host,
+		"upstream_host:" + upstreamHost,
+		"upstream_port:" + upstreamPort,
+		"upstream_request_scheme:" + upstreamURL.Scheme,
+		"upstream_request_uri:/foo?x=y",
+		"upstream_request_url:" + upstreamURL.String() + "/foo?x=y",
+		"upstream_reset:false",
+		"upstream_service:svc-a",
+	}
+
+	data := string(b.Bytes())
+	data = data[:len(data)-1] // strip \n
+	got := strings.Split(data, ";")
+	sort.Strings(got)
+
+	verify.Values(t, "", got, want)
+}
+
+func TestProxyHTTPSUpstream(t *testing.T) {
+	server := httptest.NewUnstartedServer(okHandler)
+	server.TLS = tlsServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{},
+		Transport: &http.Transport{TLSClientConfig: tlsClientConfig()},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + server.URL + ` opts "proto=https"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	resp, body := mustGet(proxy.URL)
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestProxyHTTPSUpstreamSkipVerify(t *testing.T) {
+	server := httptest.NewUnstartedServer(okHandler)
+	server.TLS = &tls.Config{}
+	server.StartTLS()
+	defer server.Close()
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{},
+		Transport: http.DefaultTransport,
+		InsecureTransport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + server.URL + ` opts "proto=https tlsskipverify=true"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	resp, body := mustGet(proxy.URL)
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestProxySchemeOverride(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(okHandler)
+	defer tlsServer.Close()
+	server := httptest.NewServer(okHandler)
+	defer server.Close()
+
+	tests := []struct {
+		desc string
+		dst  string
+		opts string
+	}{
+		{"https", "http://" + tlsServer.Listener.Addr().String(), "scheme=https tlsskipverify=true"},
+		{"http", "https://" + server.Listener.Addr().String(), "scheme=http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{},
+				Transport: http.DefaultTransport,
+				InsecureTransport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				},
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + tt.dst + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			resp, body := mustGet(proxy.URL)
+			if got, want := resp.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), "OK"; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyAutoProto(t *testing.T) {
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	h2server := httptest.NewUnstartedServer(protoHandler)
+	h2server.EnableHTTP2 = true
+	h2server.StartTLS()
+	defer h2server.Close()
+
+	h1server := httptest.NewServer(protoHandler)
+	defer h1server.Close()
+
+	// h2c server with prior knowledge
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: protoHandler})
+		}
+	}()
+
+	tbl, err := route.NewTable(bytes.NewBufferString(
+		"route add srv h2/ " + h2server.URL + ` opts "proto=auto tlsskipverify=true"` + "\n" +
+			"route add srv h2c/ http://" + l.Addr().String() + ` opts "proto=auto"` + "\n" +
+			"route add srv h1/ " + h1server.URL + ` opts "proto=auto"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:                config.Proxy{},
+		Transport:             http.DefaultTransport,
+		AutoTransport:         NewAutoProtoTransport(&http.Transport{}),
+		InsecureAutoTransport: NewAutoProtoTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}),
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		host  string
+		proto string
+	}{
+		{"h2", "HTTP/2.0"},
+		{"h2c", "HTTP/2.0"},
+		{"h1", "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest("GET", proxy.URL, nil)
+				req.Host = tt.host
+				resp, body := mustDo(req)
+				if got, want := resp.StatusCode, http.StatusOK; got != want {
+					t.Fatalf("got status %d want %d", got, want)
+				}
+				if got, want := string(body), tt.proto; got != want {
+					t.Fatalf("got upstream proto %q want %q", got, want)
+				}
+			}
+			if got, want := tbl[tt.host][0].Targets[0].Proto(), tt.proto; got != want {
+				t.Fatalf("got target proto %q want %q", got, want)
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func TestProxyExpectContinue(t *testing.T) {
+	// the server rejects the upload without reading the body
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		sent    bool
+	}{
+		{"enabled", 5 * time.Second, false},
+		{"disabled", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{},
+				Transport: &http.Transport{ExpectContinueTimeout: tt.timeout},
+				Lookup: func(r *http.Request) *route.Target {
+					return &route.Target{URL: mustParse(server.URL)}
+				},
+			})
+			defer proxy.Close()
+
+			body := &countingReader{r: bytes.NewReader(make([]byte, 1<<20))}
+			req, _ := http.NewRequest("POST", proxy.URL, body)
+			req.ContentLength = 1 << 20
+			req.Header.Set("Expect", "100-continue")
+
+			client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if got, want := resp.StatusCode, http.StatusRequestEntityTooLarge; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := atomic.LoadInt64(&body.n) > 0, tt.sent; got != want {
+				t.Fatalf("got body sent %v want %v", got, want)
+			}
+		})
+	}
+}
+
+type testCounter struct{ n int64 }
+
+func (c *testCounter) Inc(n int64) { atomic.AddInt64(&c.n, n) }
+
+func TestProxyBackendReset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("truncated"))
+		w.(http.Flusher).Flush()
+
+		// close the connection before the response is complete
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	var logbuf bytes.Buffer
+	l, err := logger.New(&logbuf, "$response_status $upstream_reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resets := &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:       config.Proxy{},
+		Transport:    http.DefaultTransport,
+		BackendReset: resets,
+		Logger:       l,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	// the client connection is aborted either before the response
+	// headers or during the response body depending on buffering.
+	resp, err := http.Get(proxy.URL)
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Fatal("got complete response want aborted response")
+	}
+
+	if got, want := atomic.LoadInt64(&resets.n), int64(1); got != want {
+		t.Fatalf("got %d backend resets want %d", got, want)
+	}
+	if got, want := logbuf.String(), "200 true\n"; got != want {
+		t.Fatalf("got access log %q want %q", got, want)
+	}
+}
+
+func TestProxyClientCancel(t *testing.T) {
+	cancelled := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			for {
+				w.Write([]byte("data\n"))
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+					cancelled <- r.URL.Path
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}
+		<-r.Context().Done()
+		cancelled <- r.URL.Path
+	}))
+	defer server.Close()
+
+	clientCancelled := &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:          config.Proxy{},
+		Transport:       http.DefaultTransport,
+		ClientCancelled: clientCancelled,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL + r.URL.Path)}
+		},
+	})
+	defer proxy.Close()
+
+	waitCancelled := func(t *testing.T, path string) {
+		select {
+		case got := <-cancelled:
+			if got != path {
+				t.Fatalf("got cancelled request for %s want %s", got, path)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("upstream request for %s not cancelled", path)
+		}
+	}
+
+	t.Run("waiting for response", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequest("GET", proxy.URL+"/slow", nil)
+		if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+			t.Fatal("got response want error")
+		}
+		waitCancelled(t, "/slow")
+	})
+
+	t.Run("streaming response", func(t *testing.T) {
+		resp, err := http.Get(proxy.URL + "/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resp.Body.Read(make([]byte, 5)); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		waitCancelled(t, "/stream")
+	})
+
+	// the proxy counts the request after the upstream request
+	// has been cancelled.
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&clientCancelled.n) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := atomic.LoadInt64(&clientCancelled.n), int64(2); got != want {
+		t.Fatalf("got %d cancelled requests want %d", got, want)
+	}
+}
+
+func TestProxyFallback(t *testing.T) {
+	echo := func(name string, delay time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s %s", name, r.URL.Path, body)
+		}
+	}
+	fast := httptest.NewServer(echo("primary", 0))
+	defer fast.Close()
+	slow := httptest.NewServer(echo("primary", 500*time.Millisecond))
+	defer slow.Close()
+	down := httptest.NewServer(echo("primary", 0))
+	down.Close()
+	fallback := httptest.NewServer(echo("fallback", 0))
+	defer fallback.Close()
+
+	routes := "route add svc /fast " + fast.URL + " opts \"fallback=" + fallback.URL + ",timeout=200ms\"\n"
+	routes += "route add svc /slow " + slow.URL + " opts \"fallback=" + fallback.URL + ",timeout=50ms\"\n"
+	routes += "route add svc /down " + down.URL + " opts \"fallback=" + fallback.URL + "\"\n"
+	tbl, err := route.NewTable(bytes.NewBufferString(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fallbacks := &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{FallbackMaxBody: 4},
+		Transport: http.DefaultTransport,
+		Fallbacks: fallbacks,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		path      string
+		req       string
+		status    int
+		body      string
+		fallbacks int64
+	}{
+		{"/fast", "foo", 200, "primary /fast foo", 0},
+		{"/slow", "foo", 200, "fallback /slow foo", 1},
+		{"/down", "foo", 200, "fallback /down foo", 2},
+		// the body is too large to be buffered for the fallback
+		{"/down", "foobar", 502, "", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.req, func(t *testing.T) {
+			resp, err := http.Post(proxy.URL+tt.path, "text/plain", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := atomic.LoadInt64(&fallbacks.n), tt.fallbacks; got != want {
+				t.Fatalf("got %d fallbacks want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestProxySign(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, r.Header.Get("X-Fabio-Date"), r.Header.Get("X-Fabio-Signature"))
+	}))
+	defer server.Close()
+
+	routes := "route add svc /signed " + server.URL + ` opts "sign=hmac-sha256:cs/origin.pem strip=/signed"` + "\n"
+	routes += "route add svc /missing " + server.URL + ` opts "sign=hmac-sha256:cs/missing.pem"` + "\n"
+	routes += "route add svc /plain " + server.URL + "\n"
+	tbl, err := route.NewTable(bytes.NewBufferString(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	date := "Thu, 02 Jan 2020 03:04:05 GMT"
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config: config.Proxy{
+			SignHeader:     "X-Fabio-Signature",
+			SignDateHeader: "X-Fabio-Date",
+			SignFields:     []string{"method", "path", "date"},
+		},
+		Transport: http.DefaultTransport,
+		Time:      func() time.Time { return now },
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+		Secret: func(ref string) ([]byte, error) {
+			if ref != "cs/origin.pem" {
+				return nil, fmt.Errorf("secret %s not found", ref)
+			}
+			return []byte("secret"), nil
+		},
+	})
+	defer proxy.Close()
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("GET\n/foo\n" + date))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/signed/foo", http.StatusOK, "/foo " + date + " " + sig},
+		{"/missing", http.StatusInternalServerError, "cannot sign request\n"},
+		{"/plain", http.StatusOK, "/plain  "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, body := mustGet(proxy.URL + tt.path)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyUpstreamProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	// egress is a forward proxy which records the tunneled hosts
+	// and the proxy credentials.
+	var got []string
+	egress := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Method+" "+r.Host+" "+r.Header.Get("Proxy-Authorization"))
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		out, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		in, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			out.Close()
+			return
+		}
+		io.WriteString(in, "HTTP/1.1 200 OK\r\n\r\n")
+		go func() {
+			io.Copy(out, in)
+			out.Close()
+		}()
+		io.Copy(in, out)
+		in.Close()
+	}))
+	defer egress.Close()
+
+	egressURL := mustParse(egress.URL)
+	egressURL.User = url.UserPassword("user", "pass")
+
+	routes := "route add svc /proxied " + server.URL + ` opts "upstreamproxy=` + egressURL.String() + `"` + "\n"
+	routes += "route add svc /direct " + server.URL + "\n"
+	tbl, err := route.NewTable(bytes.NewBufferString(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	for _, path := range []string{"/proxied", "/direct", "/proxied"} {
+		resp, body := mustGet(proxy.URL + path)
+		if resp.StatusCode != http.StatusOK || string(body) != path {
+			t.Fatalf("got %d %q want 200 %q", resp.StatusCode, body, path)
+		}
+	}
+
+	// the second request uses the tunnel of the first one
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	want := []string{"CONNECT " + mustParse(server.URL).Host + " " + auth}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestRemoveUpstreamProxies(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`route add svc / http://a.com/ opts "upstreamproxy=http://user:pass@a:3128"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := route.GetTable()
+	defer route.SetTable(prev)
+	route.SetTable(tbl)
+
+	p := &HTTPProxy{}
+	tr := &http.Transport{}
+	cached := func(proxy string) bool {
+		_, ok := p.upstreamProxies.Load(upstreamProxyKey{tr: tr, proxy: proxy})
+		return ok
+	}
+	for _, u := range []string{"http://user:pass@a:3128", "http://b:3128", "http://c:3128"} {
+		p.upstreamProxyTransport(tr, mustParse(u))
+	}
+	if !cached("http://user:pass@a:3128") || cached("http://b:3128") || !cached("http://c:3128") {
+		t.Fatal("unused upstream proxy not removed")
+	}
+}
+
+func TestProxyConnect(t *testing.T) {
+	// server echoes the data of the tunneled connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	addr := l.Addr().String()
+
+	tbl, err := route.NewTable(bytes.NewBufferString("route add partner connect:" + addr + " tcp://" + addr + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denied := &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup:    func(r *http.Request) *route.Target { return nil },
+		ConnectLookup: func(hostport string) *route.Target {
+			return tbl.LookupHost("connect:"+hostport, route.Picker["rr"])
+		},
+		ConnectDenied: denied,
+	})
+	defer proxy.Close()
+
+	c, err := tcp.DialConnect(mustParse(proxy.URL), addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "ping"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// destinations without a route are denied
+	_, err = tcp.DialConnect(mustParse(proxy.URL), "127.0.0.1:1", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Fatalf("got %v want 403 Forbidden", err)
+	}
+	if got, want := atomic.LoadInt64(&denied.n), int64(1); got != want {
+		t.Fatalf("got %d denied requests want %d", got, want)
+	}
+}
+
+func TestProxyEmptyFallback(t *testing.T) {
+	prev := route.Outliers
+	defer func() { route.Outliers = prev }()
+	route.Outliers = route.OutlierDetection{Consecutive5xx: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Minute, MaxEjectionPercent: 100}
+
+	failing := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	}
+	down1, down2 := failing(), failing()
+	defer down1.Close()
+	defer down2.Close()
+
+	sorry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "sorry "+r.URL.Path)
+	}))
+	defer sorry.Close()
+
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir+"/sorry.html", []byte("<p>sorry</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) { route.FallbackDir = dir }(route.FallbackDir)
+	route.FallbackDir = dir
+
+	routes := "route add svc1 /server " + down1.URL + ` opts "emptyfallback=` + sorry.URL + `"` + "\n"
+	routes += "route add svc2 /file " + down2.URL + ` opts "emptyfallback=file:///sorry.html"` + "\n"
+	routes += "route add svc3 /gone " + down1.URL + ` opts "emptyfallback=` + sorry.URL + `"` + "\n"
+	tbl, err := route.NewTable(bytes.NewBufferString(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the targets of svc3 have been deregistered
+	for _, r := range tbl[""] {
+		if r.Path == "/gone" {
+			r.Targets[0].Empty = true
+		}
+	}
 
+	hits := &testCounter{}
 	proxy := httptest.NewServer(&HTTPProxy{
-		Transport: http.DefaultTransport,
+		Transport:      http.DefaultTransport,
+		EmptyFallbacks: hits,
 		Lookup: func(r *http.Request) *route.Target {
-			r.Host = "c.com"
 			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
 		},
 	})
 	defer proxy.Close()
 
 	tests := []struct {
-		req      string
-		wantCode int
-		wantLoc  string
+		path   string
+		status int
+		ctype  string
+		body   string
 	}{
-		{req: "/baz", wantCode: 301, wantLoc: "https://c.com/baz"},
-	}
-
-	http.DefaultClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		// do not follow redirects
-		return http.ErrUseLastResponse
+		// the first requests eject the only targets
+		{"/server", http.StatusInternalServerError, "", ""},
+		{"/file", http.StatusInternalServerError, "", ""},
+		{"/server", http.StatusOK, "text/plain; charset=utf-8", "sorry /server"},
+		{"/file", http.StatusServiceUnavailable, "text/html; charset=utf-8", "<p>sorry</p>"},
+		{"/gone", http.StatusOK, "text/plain; charset=utf-8", "sorry /gone"},
 	}
-
 	for _, tt := range tests {
-		resp, _ := mustGet(proxy.URL + tt.req)
-		if resp.StatusCode != tt.wantCode {
-			t.Errorf("got status code %d, want %d", resp.StatusCode, tt.wantCode)
+		resp, body := mustGet(proxy.URL + tt.path)
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Fatalf("%s: got status %d want %d", tt.path, got, want)
 		}
-		gotLoc, _ := resp.Location()
-		if gotLoc.String() != tt.wantLoc {
-			t.Errorf("got location %s, want %s", gotLoc, tt.wantLoc)
+		if got, want := resp.Header.Get("Content-Type"), tt.ctype; want != "" && got != want {
+			t.Fatalf("%s: got content type %q want %q", tt.path, got, want)
 		}
+		if got, want := string(body), tt.body; got != want {
+			t.Fatalf("%s: got body %q want %q", tt.path, got, want)
+		}
+	}
+	if got, want := atomic.LoadInt64(&hits.n), int64(3); got != want {
+		t.Fatalf("got %d fallback hits want %d", got, want)
 	}
 }
 
-func TestPathRedirect(t *testing.T) {
-	routes := "route add mock / http://a.com/$path opts \"redirect=301\"\n"
-	routes += "route add mock /foo http://a.com/abc opts \"redirect=301\"\n"
-	routes += "route add mock /bar http://b.com/$path opts \"redirect=302 strip=/bar\"\n"
-	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+func TestProxyBreakerFallback(t *testing.T) {
+	prev := route.Outliers
+	defer func() { route.Outliers = prev }()
+	route.Outliers = route.OutlierDetection{Consecutive5xx: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Minute, MaxEjectionPercent: 100}
+
+	// the server responds successfully until it is switched to failing
+	newServer := func() (*httptest.Server, *int32) {
+		var failing int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"path":"`+r.URL.Path+`"}`)
+		}))
+		return srv, &failing
+	}
+	cached, cachedFailing := newServer()
+	defer cached.Close()
+	static, staticFailing := newServer()
+	defer static.Close()
+	status, statusFailing := newServer()
+	defer status.Close()
+
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir+"/sorry.html", []byte("<p>sorry</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) { route.FallbackDir = dir }(route.FallbackDir)
+	route.FallbackDir = dir
+
+	routes := "route add svc1 /cache " + cached.URL + ` opts "breakerfallback=cache"` + "\n"
+	routes += "route add svc2 /static " + static.URL + ` opts "breakerfallback=static:file:///sorry.html"` + "\n"
+	routes += "route add svc3 /status " + status.URL + ` opts "breakerfallback=status:429"` + "\n"
+	tbl, err := route.NewTable(bytes.NewBufferString(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	hits := &testCounter{}
 	proxy := httptest.NewServer(&HTTPProxy{
-		Transport: http.DefaultTransport,
+		Transport:        http.DefaultTransport,
+		BreakerFallbacks: hits,
+		BreakerCache:     &ResponseCache{Max: 10, MaxBody: 1024},
 		Lookup: func(r *http.Request) *route.Target {
 			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
 		},
 	})
 	defer proxy.Close()
 
+	// fill the cache
+	mustGet(proxy.URL + "/cache/a")
+	atomic.StoreInt32(cachedFailing, 1)
+	atomic.StoreInt32(staticFailing, 1)
+	atomic.StoreInt32(statusFailing, 1)
+
 	tests := []struct {
-		req      string
-		wantCode int
-		wantLoc  string
+		path   string
+		status int
+		ctype  string
+		body   string
 	}{
-		{req: "/", wantCode: 301, wantLoc: "http://a.com/"},
-		{req: "/aaa/bbb", wantCode: 301, wantLoc: "http://a.com/aaa/bbb"},
-		{req: "/foo", wantCode: 301, wantLoc: "http://a.com/abc"},
-		{req: "/bar", wantCode: 302, wantLoc: "http://b.com/"},
-		{req: "/bar/aaa", wantCode: 302, wantLoc: "http://b.com/aaa"},
-	}
-
-	http.DefaultClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		// do not follow redirects
-		return http.ErrUseLastResponse
+		// the first requests eject the only targets
+		{"/cache/a", http.StatusInternalServerError, "", ""},
+		{"/static", http.StatusInternalServerError, "", ""},
+		{"/status", http.StatusInternalServerError, "", ""},
+		{"/cache/a", http.StatusOK, "application/json", `{"path":"/cache/a"}`},
+		{"/cache/b", http.StatusServiceUnavailable, "", "Service Unavailable\n"},
+		{"/static", http.StatusServiceUnavailable, "text/html; charset=utf-8", "<p>sorry</p>"},
+		{"/status", http.StatusTooManyRequests, "", "Too Many Requests\n"},
 	}
-
 	for _, tt := range tests {
-		resp, _ := mustGet(proxy.URL + tt.req)
-		if resp.StatusCode != tt.wantCode {
-			t.Errorf("got status code %d, want %d", resp.StatusCode, tt.wantCode)
+		resp, body := mustGet(proxy.URL + tt.path)
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Fatalf("%s: got status %d want %d", tt.path, got, want)
 		}
-		gotLoc, _ := resp.Location()
-		if gotLoc.String() != tt.wantLoc {
-			t.Errorf("got location %s, want %s", gotLoc, tt.wantLoc)
+		if got, want := resp.Header.Get("Content-Type"), tt.ctype; want != "" && got != want {
+			t.Fatalf("%s: got content type %q want %q", tt.path, got, want)
+		}
+		if got, want := string(body), tt.body; got != want {
+			t.Fatalf("%s: got body %q want %q", tt.path, got, want)
 		}
 	}
+	if got, want := atomic.LoadInt64(&hits.n), int64(4); got != want {
+		t.Fatalf("got %d breaker fallbacks want %d", got, want)
+	}
 }
 
-func TestProxyLogOutput(t *testing.T) {
-	t.Run("uncompressed response", func(t *testing.T) {
-		testProxyLogOutput(t, 73, config.Proxy{})
-	})
-	t.Run("compression enabled but no match", func(t *testing.T) {
-		testProxyLogOutput(t, 73, config.Proxy{
-			GZIPContentTypes: regexp.MustCompile(`^$`),
-		})
-	})
-	t.Run("compression enabled and active", func(t *testing.T) {
-		testProxyLogOutput(t, 28, config.Proxy{
-			GZIPContentTypes: regexp.MustCompile(`.*`),
-		})
-	})
-}
-
-func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
-	t.Helper()
-
-	// build a format string from all log fields and one header field
-	fields := []string{"header.X-Foo:$header.X-Foo"}
-	for _, k := range logger.Fields {
-		fields = append(fields, k[1:]+":"+k)
+func TestResponseCacheable(t *testing.T) {
+	tests := []struct {
+		desc       string
+		method     string
+		reqHeader  string
+		status     int
+		respHeader string
+		want       bool
+	}{
+		{"ok", "GET", "", 200, "", true},
+		{"post", "POST", "", 200, "", false},
+		{"not found", "GET", "", 404, "", false},
+		{"authorization", "GET", "Authorization: Basic Zm9vOmJhcg==", 200, "", false},
+		{"proxy authorization", "GET", "Proxy-Authorization: Basic Zm9vOmJhcg==", 200, "", false},
+		{"cookie", "GET", "Cookie: session=1", 200, "", false},
+		{"set-cookie", "GET", "", 200, "Set-Cookie: session=1", false},
+		{"vary", "GET", "", 200, "Vary: Accept-Encoding", false},
+		{"private", "GET", "", 200, "Cache-Control: private, max-age=60", false},
+		{"no-store", "GET", "", 200, "Cache-Control: no-store", false},
+		{"public", "GET", "", 200, "Cache-Control: public, max-age=60", true},
 	}
-	format := strings.Join(fields, ";")
 
-	// create a logger
-	var b bytes.Buffer
-	l, err := logger.New(&b, format)
-	if err != nil {
-		t.Fatal("logger.New: ", err)
+	header := func(s string) http.Header {
+		h := http.Header{}
+		if s != "" {
+			kv := strings.SplitN(s, ": ", 2)
+			h.Set(kv[0], kv[1])
+		}
+		return h
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header = header(tt.reqHeader)
+			resp := &http.Response{StatusCode: tt.status, Header: header(tt.respHeader)}
+			if got, want := cacheable(req, resp), tt.want; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
 	}
+}
 
-	// create an upstream server
+func TestProxyExpect(t *testing.T) {
+	prev := route.Outliers
+	defer func() { route.Outliers = prev }()
+	route.Outliers = route.OutlierDetection{Consecutive5xx: 2, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Minute, MaxEjectionPercent: 100}
+
+	ctype := "application/json"
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "foooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooooo")
+		w.Header().Set("Content-Type", ctype)
+		fmt.Fprint(w, "ok")
 	}))
 	defer server.Close()
 
-	// create a proxy handler with mocked time
-	tm := time.Date(2016, 1, 1, 0, 0, 0, 12345678, time.UTC)
-	proxyHandler := &HTTPProxy{
-		Config: cfg,
-		Time: func() time.Time {
-			defer func() { tm = tm.Add(1111111111 * time.Nanosecond) }()
-			return tm
-		},
-		Transport: http.DefaultTransport,
-		Lookup: func(r *http.Request) *route.Target {
-			return &route.Target{
-				Service: "svc-a",
-				URL:     mustParse(server.URL),
-			}
-		},
-		Logger: l,
+	tbl, err := route.NewTable(bytes.NewBufferString("route add svc / " + server.URL + ` opts "expect=status:200-299,ctype:application/json"`))
+	if err != nil {
+		t.Fatal(err)
 	}
+	target := tbl.Lookup(httptest.NewRequest("GET", "/", nil), "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
 
-	// start an http server with the proxy handler
-	// which captures some parameters from the request
-	var remoteAddr string
-	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		remoteAddr = r.RemoteAddr
-		proxyHandler.ServeHTTP(w, r)
-	}))
+	hits := &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport:  http.DefaultTransport,
+		Unexpected: hits,
+		Lookup:     func(r *http.Request) *route.Target { return target },
+	})
 	defer proxy.Close()
 
-	// create the request
-	req, _ := http.NewRequest("GET", proxy.URL+"/foo?x=y", nil)
-	req.Host = "example.com"
-	req.Header.Set("X-Foo", "bar")
-
-	// execute the request
-	resp, _ := mustDo(req)
-	if resp.StatusCode != http.StatusOK {
-		t.Fatal("http.Get: want 200 got ", resp.StatusCode)
+	// an expected response resets the consecutive failures
+	for _, ct := range []string{"text/html", ctype, "text/html"} {
+		ctype = ct
+		if resp, _ := mustGet(proxy.URL); resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d want 200", resp.StatusCode)
+		}
 	}
-
-	upstreamURL, _ := url.Parse(server.URL)
-	upstreamHost, upstreamPort, _ := net.SplitHostPort(upstreamURL.Host)
-	remoteHost, remotePort, _ := net.SplitHostPort(remoteAddr)
-	want := []string{
-		"header.X-Foo:bar",
-		"remote_addr:" + remoteAddr,
-		"remote_host:" + remoteHost,
-		"remote_port:" + remotePort,
-		"request:GET /foo?x=y HTTP/1.1",
-		"request_args:x=y",
-		"request_host:example.com",
-		"request_method:GET",
-		"request_proto:HTTP/1.1",
-		"request_scheme:http",
-		"request_uri:/foo?x=y",
-		"request_url:http://example.com/foo?x=y",
-		"response_body_size:" + strconv.Itoa(bodySize),
-		"response_status:200",
-		"response_time_ms:1.111",
-		"response_time_ns:1.111111111",
-		"response_time_us:1.111111",
-		"time_common:01/Jan/2016:00:00:01 +0000",
-		"time_rfc3339:2016-01-01T00:00:01Z",
-		"time_rfc3339_ms:2016-01-01T00:00:01.123Z",
-		"time_rfc3339_ns:2016-01-01T00:00:01.123456789Z",
-		"time_rfc3339_us:2016-01-01T00:00:01.123456Z",
-		"time_unix_ms:1451606401123",
-		"time_unix_ns:1451606401123456789",
-		"time_unix_us:1451606401123456",
-		"upstream_addr:" + upstreamURL.Host,
-		"upstream_host:" + upstreamHost,
-		"upstream_port:" + upstreamPort,
-		"upstream_request_scheme:" + upstreamURL.Scheme,
-		"upstream_request_uri:/foo?x=y",
-		"upstream_request_url:" + upstreamURL.String() + "/foo?x=y",
-		"upstream_service:svc-a",
+	if target.Ejected() {
+		t.Fatal("target was ejected after one unexpected response")
 	}
 
-	data := string(b.Bytes())
-	data = data[:len(data)-1] // strip \n
-	got := strings.Split(data, ";")
-	sort.Strings(got)
-
-	verify.Values(t, "", got, want)
+	mustGet(proxy.URL)
+	if !target.Ejected() {
+		t.Fatal("target was not ejected after two unexpected responses")
+	}
+	if got, want := atomic.LoadInt64(&hits.n), int64(3); got != want {
+		t.Fatalf("got %d unexpected responses want %d", got, want)
+	}
 }
 
-func TestProxyHTTPSUpstream(t *testing.T) {
-	server := httptest.NewUnstartedServer(okHandler)
-	server.TLS = tlsServerConfig()
-	server.StartTLS()
+func TestProxyCountryHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header["X-Client-Country"])
+	}))
 	defer server.Close()
 
+	country := ""
 	proxy := httptest.NewServer(&HTTPProxy{
-		Config:    config.Proxy{},
-		Transport: &http.Transport{TLSClientConfig: tlsClientConfig()},
-		Lookup: func(r *http.Request) *route.Target {
-			tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + server.URL + ` opts "proto=https"`))
-			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
-		},
+		Transport:     http.DefaultTransport,
+		Lookup:        func(r *http.Request) *route.Target { return &route.Target{URL: mustParse(server.URL)} },
+		CountryHeader: "X-Client-Country",
+		Country:       func(r *http.Request) string { return country },
 	})
 	defer proxy.Close()
 
-	resp, body := mustGet(proxy.URL)
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		t.Fatalf("got status %d want %d", got, want)
+	tests := []struct {
+		country, header, body string
+	}{
+		{"DE", "", "[DE]"},
+		{"DE", "US", "[DE]"},
+		{"", "US", "[]"},
 	}
-	if got, want := string(body), "OK"; got != want {
-		t.Fatalf("got body %q want %q", got, want)
+
+	for _, tt := range tests {
+		t.Run(tt.country+" "+tt.header, func(t *testing.T) {
+			country = tt.country
+			req, _ := http.NewRequest("GET", proxy.URL, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Client-Country", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
 	}
 }
 
-func TestProxyHTTPSUpstreamSkipVerify(t *testing.T) {
-	server := httptest.NewUnstartedServer(okHandler)
-	server.TLS = &tls.Config{}
-	server.StartTLS()
+func TestProxyTrailers(t *testing.T) {
+	// the backend sends the grpc-status and grpc-message trailers
+	// which are announced unless the 'announce' parameter is false
+	// and the unannounced x-checksum trailer.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announce := r.URL.Query().Get("announce") == "true"
+		if announce {
+			w.Header().Set("Trailer", "grpc-status, grpc-message")
+		}
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.WriteHeader(200)
+		w.Write([]byte("data"))
+		// force chunked encoding for the unannounced trailers
+		w.(http.Flusher).Flush()
+		prefix := ""
+		if !announce {
+			prefix = http.TrailerPrefix
+		}
+		w.Header().Set(prefix+"grpc-status", "0")
+		w.Header().Set(prefix+"grpc-message", "ok")
+		w.Header().Set(http.TrailerPrefix+"x-checksum", "abc")
+	}))
 	defer server.Close()
-	proxy := httptest.NewServer(&HTTPProxy{
-		Config:    config.Proxy{},
-		Transport: http.DefaultTransport,
-		InsecureTransport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Lookup: func(r *http.Request) *route.Target {
-			tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + server.URL + ` opts "proto=https tlsskipverify=true"`))
-			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
-		},
-	})
-	defer proxy.Close()
 
-	resp, body := mustGet(proxy.URL)
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		t.Fatalf("got status %d want %d", got, want)
+	tests := []struct {
+		desc  string
+		http2 bool
+		gzip  bool
+		ctype string
+	}{
+		{"HTTP/1.1", false, false, "application/grpc"},
+		{"HTTP/2", true, false, "application/grpc"},
+		{"HTTP/1.1 with gzip handler", false, true, "application/grpc"},
+		{"HTTP/2 with gzip handler", true, true, "application/grpc"},
+		{"HTTP/1.1 with gzip compression", false, true, "text/plain"},
+		{"HTTP/2 with gzip compression", true, true, "text/plain"},
 	}
-	if got, want := string(body), "OK"; got != want {
-		t.Fatalf("got body %q want %q", got, want)
+
+	for _, announce := range []bool{true, false} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s announce=%v", tt.desc, announce), func(t *testing.T) {
+				var cfg config.Proxy
+				if tt.gzip {
+					cfg.GZIPContentTypes = regexp.MustCompile("^text/plain$")
+				}
+				proxy := httptest.NewUnstartedServer(&HTTPProxy{
+					Config:    cfg,
+					Transport: http.DefaultTransport,
+					Lookup: func(r *http.Request) *route.Target {
+						return &route.Target{URL: mustParse(server.URL)}
+					},
+				})
+				proxy.EnableHTTP2 = tt.http2
+				proxy.StartTLS()
+				defer proxy.Close()
+
+				tr := proxy.Client().Transport.(*http.Transport)
+				tr.ForceAttemptHTTP2 = tt.http2
+				tr.DisableCompression = true
+
+				req, _ := http.NewRequest("GET", proxy.URL+"/?announce="+strconv.FormatBool(announce)+"&type="+url.QueryEscape(tt.ctype), nil)
+				req.Header.Set("TE", "trailers")
+				if tt.gzip {
+					req.Header.Set("Accept-Encoding", "gzip")
+				}
+				resp, err := proxy.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				if got, want := resp.ProtoMajor == 2, tt.http2; got != want {
+					t.Fatalf("got %s want HTTP/2 %v", resp.Proto, want)
+				}
+
+				// trailers are announced before the body is read
+				var announced []string
+				for k := range resp.Trailer {
+					announced = append(announced, k)
+				}
+				sort.Strings(announced)
+				var wantAnnounced []string
+				if announce {
+					wantAnnounced = []string{"Grpc-Message", "Grpc-Status"}
+				}
+				if got, want := announced, wantAnnounced; !reflect.DeepEqual(got, want) {
+					t.Fatalf("got announced trailers %v want %v", got, want)
+				}
+
+				body := resp.Body
+				if resp.Header.Get("Content-Encoding") == "gzip" {
+					if body, err = gzip.NewReader(resp.Body); err != nil {
+						t.Fatal(err)
+					}
+				}
+				b, err := ioutil.ReadAll(body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(b), "data"; got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+				if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+					t.Fatal(err)
+				}
+
+				want := http.Header{
+					"Grpc-Status":  {"0"},
+					"Grpc-Message": {"ok"},
+					"X-Checksum":   {"abc"},
+				}
+				if got := resp.Trailer; !reflect.DeepEqual(got, want) {
+					t.Fatalf("got trailers %v want %v", got, want)
+				}
+			})
+		}
 	}
 }
 
@@ -744,3 +2768,32 @@ func BenchmarkProxyLogger(b *testing.B) {
 		proxy.ServeHTTP(httptest.NewRecorder(), req)
 	}
 }
+
+func TestProxySetHeader(t *testing.T) {
+	got := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+	}))
+	defer server.Close()
+
+	routes := "route add svc /foo " + server.URL + ` opts "strip=/foo setheader=X-Route-Key={{.Path|sha1}};X-Route={{.Service}}@{{.Route}}"`
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Route", "spoofed")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	hdr := <-got
+	if got, want := hdr.Get("X-Route-Key"), "6dbd548cc03e44b8b44b6e68e56255ce4273ae49"; got != want {
+		t.Errorf("got X-Route-Key %q want %q", got, want)
+	}
+	if got, want := hdr.Get("X-Route"), "svc@/foo"; got != want {
+		t.Errorf("got X-Route %q want %q", got, want)
+	}
+}
@@ -0,0 +1,21 @@
+package proxy
+
+import "net/http"
+
+// closeTransport closes the client connection after the response if the
+// upstream server closed the connection with 'Connection: close'. It is
+// used when proxy.preserveclientkeepalive is disabled. Otherwise, the
+// header is removed as a hop-by-hop header and the client connection is
+// kept open while the next request uses a new upstream connection.
+type closeTransport struct {
+	rt http.RoundTripper
+	w  http.ResponseWriter
+}
+
+func (t *closeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && resp.Close {
+		t.w.Header().Set("Connection", "close")
+	}
+	return resp, err
+}
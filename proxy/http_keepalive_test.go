@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyPreserveKeepAlive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/close" {
+			w.Header().Set("Connection", "close")
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc     string
+		preserve bool
+		path     string
+		close    bool
+	}{
+		{"preserve", true, "/close", false},
+		{"propagate", false, "/close", true},
+		{"propagate keep-alive", false, "/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{PreserveKeepAlive: tt.preserve},
+				Transport: &http.Transport{},
+				Lookup: func(r *http.Request) *route.Target {
+					return &route.Target{URL: mustParse(server.URL)}
+				},
+			})
+			defer proxy.Close()
+
+			resp, err := http.Get(proxy.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got, want := resp.Close, tt.close; got != want {
+				t.Fatalf("got close %v want %v", got, want)
+			}
+		})
+	}
+}
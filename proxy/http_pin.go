@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// errPinMismatch is returned when the certificate chain of the upstream
+// server contains none of the pinned public keys.
+var errPinMismatch = errors.New("no pinned public key in certificate chain")
+
+// pinKey identifies the copy of a transport which verifies the
+// public key pins of a target.
+type pinKey struct {
+	tr   *http.Transport
+	pins string
+}
+
+// pinTransport returns a copy of the transport which rejects the TLS
+// connections to upstream servers whose certificate chain does not
+// contain one of the pinned public keys. The pins are checked in
+// addition to the certificate verification and also if the verification
+// is disabled with 'tlsskipverify'. They are also checked for resumed
+// TLS sessions since the copies share the session cache with the
+// transport. The copies are cached so that they keep their connection
+// pools. The unused copies are removed when a new copy is added.
+func (p *HTTPProxy) pinTransport(tr http.RoundTripper, pins []string) http.RoundTripper {
+	htr, ok := tr.(*http.Transport)
+	if !ok {
+		log.Printf("[WARN] Cannot pin certificates with transport %T", tr)
+		return tr
+	}
+
+	key := pinKey{tr: htr, pins: strings.Join(pins, ",")}
+	if v, ok := p.pinned.Load(key); ok {
+		return v.(*http.Transport)
+	}
+	ptr := htr.Clone()
+	if ptr.TLSClientConfig == nil {
+		ptr.TLSClientConfig = &tls.Config{}
+	}
+	ptr.TLSClientConfig.VerifyConnection = verifyPins(pins, ptr.TLSClientConfig.VerifyConnection)
+	v, loaded := p.pinned.LoadOrStore(key, ptr)
+	if !loaded {
+		p.removePinned(key)
+	}
+	return v.(*http.Transport)
+}
+
+// removePinned removes the copies of the transports for the pins which
+// are not used by a target of the active routing table and the copies
+// of the transports which are no longer used, e.g. the connection pools
+// of evicted tenants, except for the copy with the key keep.
+func (p *HTTPProxy) removePinned(keep pinKey) {
+	used := map[string]bool{}
+	for _, routes := range route.GetTable() {
+		for _, r := range routes {
+			for _, t := range r.Targets {
+				if len(t.Pins) > 0 {
+					used[strings.Join(t.Pins, ",")] = true
+				}
+			}
+		}
+	}
+	transports := p.usedTransports()
+	p.pinned.Range(func(k, v interface{}) bool {
+		if key := k.(pinKey); key != keep && (!used[key.pins] || !transports[key.tr]) {
+			p.pinned.Delete(k)
+			v.(*http.Transport).CloseIdleConnections()
+		}
+		return true
+	})
+}
+
+// verifyPins returns a function for tls.Config.VerifyConnection which
+// succeeds if the SHA-256 hash of the public key of one of the peer
+// certificates matches one of the pins and next succeeds. Unlike
+// VerifyPeerCertificate it is also called for resumed sessions.
+func verifyPins(pins []string, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if next != nil {
+			if err := next(cs); err != nil {
+				return err
+			}
+		}
+		for _, cert := range cs.PeerCertificates {
+			h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			hash := base64.StdEncoding.EncodeToString(h[:])
+			for _, pin := range pins {
+				if pin == hash {
+					return nil
+				}
+			}
+		}
+		return errPinMismatch
+	}
+}
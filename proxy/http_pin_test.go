@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyPin(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	h := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(h[:])
+	const other = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		desc   string
+		opts   string
+		status int
+	}{
+		{"no pin", "tlsskipverify=true", 200},
+		{"matching pin", "tlsskipverify=true pin=sha256//" + other + ",sha256//" + pin, 200},
+		{"wrong pin", "tlsskipverify=true pin=sha256//" + other, 502},
+		{"invalid pin", "tlsskipverify=true pin=" + pin, 502},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add svc / " + server.URL + ` opts "` + tt.opts + `"`))
+			proxy := &HTTPProxy{
+				Transport:         &http.Transport{},
+				InsecureTransport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyPinResumedSession(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	const other = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	tbl, _ := route.NewTable(bytes.NewBufferString(
+		"route add svc /plain " + server.URL + ` opts "tlsskipverify=true"` + "\n" +
+			"route add svc /pinned " + server.URL + ` opts "tlsskipverify=true pin=sha256//` + other + `"`,
+	))
+
+	// the pinned transport shares the session cache of the insecure
+	// transport and resumes the session of the unpinned request
+	var resumed bool
+	insecure := &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(10),
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			resumed = resumed || cs.DidResume
+			return nil
+		},
+	}}
+	proxy := &HTTPProxy{
+		Transport:         &http.Transport{},
+		InsecureTransport: insecure,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/plain", 200},
+		{"/pinned", 502},
+	} {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if got, want := rec.Code, tt.status; got != want {
+			t.Fatalf("%s: got status %d want %d", tt.path, got, want)
+		}
+	}
+	if !resumed {
+		t.Fatal("session not resumed")
+	}
+}
+
+func TestRemovePinned(t *testing.T) {
+	const pinA, pinB = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	tbl, err := route.NewTable(bytes.NewBufferString(`route add svc / https://a.com/ opts "pin=sha256//` + pinA + `"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := route.GetTable()
+	defer route.SetTable(prev)
+	route.SetTable(tbl)
+
+	tr := &http.Transport{}
+	p := &HTTPProxy{
+		Transport: tr,
+		Tenants: &TenantTransports{
+			Allow: map[string]bool{"a": true, "b": true},
+			Max:   1,
+			New:   func() Transports { return Transports{Transport: &http.Transport{}} },
+		},
+	}
+	cached := func(tr *http.Transport, pin string) bool {
+		_, ok := p.pinned.Load(pinKey{tr: tr, pins: pin})
+		return ok
+	}
+
+	// pins which are no longer used are removed
+	p.pinTransport(tr, []string{pinA})
+	p.pinTransport(tr, []string{"unused"})
+	p.pinTransport(tr, []string{pinB})
+	if !cached(tr, pinA) || cached(tr, "unused") || !cached(tr, pinB) {
+		t.Fatal("unused pin not removed")
+	}
+
+	// the copies of the pools of evicted tenants are removed
+	ta, _ := p.Tenants.Get("a")
+	p.pinTransport(ta.Transport, []string{pinA})
+	tb, _ := p.Tenants.Get("b")
+	p.pinTransport(tb.Transport, []string{pinA})
+	if cached(ta.Transport.(*http.Transport), pinA) || !cached(tb.Transport.(*http.Transport), pinA) || !cached(tr, pinA) {
+		t.Fatal("copy of evicted tenant pool not removed")
+	}
+}
@@ -2,14 +2,20 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
+	"io/ioutil"
+	"log"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fabiolb/fabio/auth"
@@ -18,9 +24,11 @@ import (
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy/gzip"
+	"github.com/fabiolb/fabio/proxy/transform"
 	"github.com/fabiolb/fabio/route"
 	"github.com/fabiolb/fabio/trace"
 	"github.com/fabiolb/fabio/uuid"
+	"golang.org/x/time/rate"
 )
 
 // HTTPProxy is a dynamic reverse proxy for HTTP and HTTPS protocols.
@@ -41,6 +49,15 @@ type HTTPProxy struct {
 	// self-signed certs.
 	InsecureTransport http.RoundTripper
 
+	// AutoTransport is the http connection pool which negotiates
+	// HTTP/2 with the upstream server. This is used for targets
+	// with the 'proto=auto' option.
+	AutoTransport http.RoundTripper
+
+	// InsecureAutoTransport is the same as AutoTransport with
+	// InsecureSkipVerify set.
+	InsecureAutoTransport http.RoundTripper
+
 	// Lookup returns a target host for the given request.
 	// The proxy will panic if this value is nil.
 	Lookup func(*http.Request) *route.Target
@@ -48,13 +65,113 @@ type HTTPProxy struct {
 	// Requests is a timer metric which is updated for every request.
 	Requests metrics.Timer
 
+	// BackendReset counts the responses which were truncated since
+	// the upstream server closed the connection.
+	BackendReset metrics.Counter
+
+	// ResponseTooLarge counts the responses which exceeded the
+	// 'maxrespbody' limit of the route.
+	ResponseTooLarge metrics.Counter
+
+	// Fallbacks counts the requests which were sent to the
+	// fallback target of a route.
+	Fallbacks metrics.Counter
+
+	// EmptyFallbacks counts the requests which were sent to the
+	// empty fallback of a route since all targets were ejected.
+	EmptyFallbacks metrics.Counter
+
+	// BreakerFallbacks counts the requests which were answered by
+	// the breaker fallback of a route since all targets were ejected.
+	BreakerFallbacks metrics.Counter
+
+	// BreakerCache keeps the responses for the routes with the
+	// 'breakerfallback=cache' option. Responses are not cached
+	// if BreakerCache is nil.
+	BreakerCache *ResponseCache
+
+	// Unexpected counts the upstream responses which did
+	// not match the 'expect' option of the target.
+	Unexpected metrics.Counter
+
+	// Retries counts the upstream requests which were retried.
+	Retries metrics.Counter
+
 	// Noroute is a counter metric which is updated for every request
 	// where Lookup() returns nil.
 	Noroute metrics.Counter
 
+	// NoRouteLimit limits the rate of the requests where Lookup()
+	// returns nil. Requests over the limit are dropped by closing
+	// the connection without a response. If NoRouteLimit is nil
+	// the rate is not limited.
+	NoRouteLimit *rate.Limiter
+
+	// NorouteDropped counts the requests without a route
+	// which were dropped because of the NoRouteLimit.
+	NorouteDropped metrics.Counter
+
+	// ReadBodyTimeout is the interval in which the request body must
+	// be received with at least ReadBodyMinRate bytes per second.
+	// Otherwise the client connection is closed. If ReadBodyTimeout
+	// is zero the rate is not enforced.
+	ReadBodyTimeout time.Duration
+
+	// ReadBodyMinRate is the minimum rate for the request body in
+	// bytes per second. At least one byte must be received in every
+	// ReadBodyTimeout interval.
+	ReadBodyMinRate int64
+
+	// ProxyProtoXFF is the policy for the X-Forwarded-For header of
+	// requests whose client address was provided by a PROXY protocol
+	// header: "append", "replace" or "dedup". See reconcileForwardedFor.
+	ProxyProtoXFF string
+
+	// SlowBodyDropped counts the connections and HTTP/2 requests which
+	// were dropped because the request body was received too slowly.
+	SlowBodyDropped metrics.Counter
+
+	// FramingRejected counts the requests which were rejected since
+	// the framing of the body was ambiguous or malformed.
+	FramingRejected metrics.Counter
+
+	// ClientCancelled counts the requests which were cancelled
+	// since the client closed the connection before the response
+	// was complete.
+	ClientCancelled metrics.Counter
+
+	// RequestFiltered counts the requests which were rejected by
+	// the request filter or the 'blockpaths' and 'allowmethods'
+	// options of the route.
+	RequestFiltered metrics.Counter
+
 	// Logger is the access logger for the requests.
 	Logger logger.Logger
 
+	// ConnectLookup returns the target for the host and port of a
+	// CONNECT request. CONNECT requests are proxied like other requests
+	// if ConnectLookup is nil. Otherwise fabio acts as a forward proxy
+	// and tunnels the connection to the target or denies the request
+	// if there is no target.
+	ConnectLookup func(hostport string) *route.Target
+
+	// ConnectDenied counts the denied CONNECT requests.
+	ConnectDenied metrics.Counter
+
+	// FairQueue limits the number of concurrent upstream requests
+	// and shares them between the routes. If FairQueue is nil the
+	// number is not limited.
+	FairQueue *FairQueue
+
+	// Capture logs the bodies of the targets with the 'capturebody'
+	// option. If Capture is nil the bodies are not captured.
+	Capture *BodyCapture
+
+	// Tap sends the metadata of a sample of the requests of the
+	// targets with the 'tap' option to a sink. If Tap is nil the
+	// requests are not tapped.
+	Tap *Tap
+
 	// TracerCfg is the Open Tracing configuration as provided during startup
 	TracerCfg config.Tracing
 
@@ -64,50 +181,185 @@ type HTTPProxy struct {
 
 	// Auth schemes registered with the server
 	AuthSchemes map[string]auth.AuthScheme
+
+	// Secret returns the secret for a reference of the 'sign' option
+	// of a route.
+	Secret func(ref string) ([]byte, error)
+
+	// CountryHeader is the name of the header for the country code of
+	// the client which is set to the value returned by Country.
+	// Headers of the same name from the client are removed.
+	CountryHeader string
+
+	// Country returns the country code of the client of the request
+	// or an empty string if it cannot be resolved.
+	Country func(r *http.Request) string
+
+	// Quotas counts the requests of the routes with the 'quota'
+	// option. The quotas are not enforced if Quotas is nil.
+	Quotas *Quotas
+
+	// QuotaExceeded counts the requests which were rejected
+	// since the quota of the client was exhausted.
+	QuotaExceeded metrics.Counter
+
+	// HeaderSizes records the sizes of the request and response
+	// headers. The sizes are not recorded if HeaderSizes is nil.
+	HeaderSizes *HeaderSizes
+
+	// Tenants provides separate connection pools per tenant. Requests
+	// without a tenant and all requests if Tenants is nil use the
+	// transports above.
+	Tenants *TenantTransports
+
+	// upstreamProxies contains the copies of the transports for
+	// targets with an upstream proxy.
+	upstreamProxies sync.Map
+
+	// resolvers contains the copies of the transports for targets
+	// with the 'resolve' option.
+	resolvers sync.Map
+
+	// pinned contains the copies of the transports for targets
+	// with the 'pin' option.
+	pinned sync.Map
 }
 
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if p.Lookup == nil {
 		panic("no lookup function")
 	}
+	received := time.Now()
 
+	if p.HeaderSizes != nil {
+		p.HeaderSizes.request(r)
+	}
+
+	// reject requests whose body framing upstream servers
+	// could interpret differently.
+	if p.Config.StrictFraming {
+		if err := checkFraming(r); err != nil {
+			p.rejectFraming(w, r, err)
+			return
+		}
+		if len(r.TransferEncoding) > 0 {
+			r.Body = &chunkedBody{ReadCloser: r.Body, rejected: p.FramingRejected}
+		}
+	}
+
+	if r.Method == http.MethodConnect && p.ConnectLookup != nil {
+		p.serveConnect(w, r)
+		return
+	}
+
+	// reject requests for malicious paths, e.g. path traversal
+	if p.Config.RequestFilterEnabled {
+		if err := checkRequestURL(r.URL); err != nil {
+			p.rejectRequest(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	p.overrideMethod(r)
+
+	// unmatched requests are often scanner traffic for random paths.
+	// Respond to them without setting up the response writer and the
+	// trace span unless a custom noroute page is configured.
+	t := p.Lookup(r)
+	if t == nil {
+		if p.NoRouteLimit != nil && !p.NoRouteLimit.Allow() {
+			if p.NorouteDropped != nil {
+				p.NorouteDropped.Inc(1)
+			}
+			panic(http.ErrAbortHandler)
+		}
+		if noroute.GetHTML() == "" {
+			status := p.noRouteStatus()
+			w.WriteHeader(status)
+			p.logNoRoute(r, received, status, 0, "", "")
+			return
+		}
+	}
+
+	// drop clients which send the request body too slowly
+	if p.ReadBodyTimeout > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = newMinRateBody(r, p.ReadBodyTimeout, p.ReadBodyMinRate, p.SlowBodyDropped)
+	}
+
+	// preserve the request id of the client or generate a new one
+	var requestID string
 	if p.Config.RequestID != "" {
-		id := p.UUID
-		if id == nil {
-			id = uuid.NewUUID
+		requestID = r.Header.Get(p.Config.RequestID)
+		if requestID == "" {
+			requestID = p.newRequestID()
+			r.Header.Set(p.Config.RequestID, requestID)
 		}
-		r.Header.Set(p.Config.RequestID, id())
 	}
 
+	// rw captures the status code and the response size and adds
+	// the request id to error responses and the configured response
+	// headers to all responses.
+	rw := &responseWriter{
+		w:               w,
+		requestIDHeader: p.Config.RequestID,
+		requestID:       requestID,
+		respHeaders:     responseHeaders(p.Config.ResponseHeaders, nil, r.TLS != nil),
+		headerSizes:     p.HeaderSizes,
+		req:             r,
+	}
+	w = rw
+
 	//Create Span
 	span := trace.CreateSpan(r, &p.TracerCfg)
 	defer span.Finish()
+	if requestID != "" {
+		span.SetTag("http.request_id", requestID)
+	}
 
-	t := p.Lookup(r)
+	// the trace id of the span correlates the access log entry,
+	// the error response and the trace of the request. It is set
+	// even if the trace is not sampled or tracing is disabled.
+	traceID := trace.TraceID(span, r.Header)
+	rw.traceIDHeader, rw.traceID = p.Config.TraceIDHeader, traceID
+
+	if t != nil && t.ResponseHeaders != nil {
+		rw.respHeaders = responseHeaders(p.Config.ResponseHeaders, t, r.TLS != nil)
+	}
 
 	if t == nil {
-		status := p.Config.NoRouteStatus
-		if status < 100 || status > 999 {
-			status = http.StatusNotFound
-		}
-		w.WriteHeader(status)
-		html := noroute.GetHTML()
-		if html != "" {
-			io.WriteString(w, html)
-		}
+		w.WriteHeader(p.noRouteStatus())
+		io.WriteString(w, noroute.GetHTML())
+		p.logNoRoute(r, received, rw.code, rw.size, requestID, traceID)
 		return
 	}
 
+	if deprecated(t) {
+		rw.deprecated = t
+		t.CountDeprecated()
+	}
+
 	if t.AccessDeniedHTTP(r) {
 		http.Error(w, "access denied", http.StatusForbidden)
 		return
 	}
 
+	if p.filterRoute(w, r, t) {
+		return
+	}
+
 	if !t.Authorized(r, w, p.AuthSchemes) {
 		http.Error(w, "authorization failed", http.StatusUnauthorized)
 		return
 	}
 
+	if p.serveOptions(w, r, t) {
+		return
+	}
+
+	if t.Quota != nil && p.Quotas != nil && !p.checkQuota(w, r, t) {
+		return
+	}
+
 	// build the request url since r.URL will get modified
 	// by the reverse proxy and contains only the RequestURI anyway
 	requestURL := &url.URL{
@@ -122,20 +374,38 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if t.Timer != nil {
 			t.Timer.Update(0)
 		}
-		metrics.DefaultRegistry.GetTimer(key(t.RedirectCode)).Update(0)
+		t.CountStatus(t.RedirectCode)
+		if !t.NoTotals {
+			metrics.DefaultRegistry.GetTimer(key(t.RedirectCode)).Update(0)
+		}
 		return
 	}
 
-	// build the real target url that is passed to the proxy
-	targetURL := &url.URL{
-		Scheme: t.URL.Scheme,
-		Host:   t.URL.Host,
-		Path:   r.URL.Path,
+	// answer the request with the breaker fallback of the route
+	// without contacting the targets if all of them have been ejected.
+	if t.BreakerFallback != nil && t.Ejected() {
+		p.serveBreakerFallback(w, t, requestURL.String())
+		return
 	}
-	if t.URL.RawQuery == "" || r.URL.RawQuery == "" {
-		targetURL.RawQuery = t.URL.RawQuery + r.URL.RawQuery
-	} else {
-		targetURL.RawQuery = t.URL.RawQuery + "&" + r.URL.RawQuery
+
+	// send the request to the empty fallback of the route
+	// if all of its targets have been ejected or deregistered.
+	dst := t.URL
+	if t.EmptyFallback != nil && (t.Empty || t.Ejected()) {
+		if p.EmptyFallbacks != nil {
+			p.EmptyFallbacks.Inc(1)
+		}
+		if t.EmptyFallback.Scheme == "file" {
+			serveFile(w, t.EmptyFallback.Path, http.StatusServiceUnavailable)
+			return
+		}
+		dst = t.EmptyFallback
+	}
+
+	// build the real target url that is passed to the proxy
+	targetURL := upstreamURL(r, dst, t.StripPath)
+	if t.Scheme != "" && dst == t.URL {
+		targetURL.Scheme = t.Scheme
 	}
 
 	if t.Host == "dst" {
@@ -144,58 +414,180 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Host = t.Host
 	}
 
-	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
-	// TODO(fs): have found the target based on the prefix but there may be other
-	// TODO(fs): matchers which may have different rules. I'll keep this for
-	// TODO(fs): a defensive approach.
-	if t.StripPath != "" && strings.HasPrefix(r.URL.Path, t.StripPath) {
-		targetURL.Path = targetURL.Path[len(t.StripPath):]
-		// ensure absolute path after stripping to maintain compliance with
-		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
-		if !strings.HasPrefix(targetURL.Path, "/") {
-			targetURL.Path = "/" + targetURL.Path
-		}
-	}
-
+	reconcileForwardedFor(r, p.ProxyProtoXFF)
 	if err := addHeaders(r, p.Config, t.StripPath); err != nil {
 		http.Error(w, "cannot parse "+r.RemoteAddr, http.StatusInternalServerError)
 		return
 	}
 
+	if p.CountryHeader != "" && p.Country != nil {
+		r.Header.Del(p.CountryHeader)
+		if c := p.Country(r); c != "" {
+			r.Header.Set(p.CountryHeader, c)
+		}
+	}
+
+	if p.Config.JA3 && p.Config.JA3Header != "" {
+		r.Header.Del(p.Config.JA3Header)
+		if fp := RequestJA3(r); fp != "" {
+			r.Header.Set(p.Config.JA3Header, fp)
+		}
+	}
+
+	for _, h := range t.SetHeaders {
+		v, err := h.Value(route.NewHeaderData(r, t))
+		if err != nil {
+			log.Printf("[ERROR] Cannot set header %s for %s. %s", h.Name, t.URL, err)
+			http.Error(w, "cannot set request headers", http.StatusInternalServerError)
+			return
+		}
+		r.Header.Set(h.Name, v)
+	}
+
 	if err := addResponseHeaders(w, r, p.Config); err != nil {
 		http.Error(w, "cannot add response headers", http.StatusInternalServerError)
 		return
 	}
 
+	// the aggregator sends the request to all targets of the route
+	if t.Aggregate != "" {
+		p.serveAggregate(rw, r, t, requestURL, requestID)
+		return
+	}
+
+	if t.SignSecret != "" {
+		secret, err := p.secret(t.SignSecret)
+		if err != nil {
+			log.Printf("[ERROR] Cannot sign request for %s. %s", t.URL, err)
+			http.Error(w, "cannot sign request", http.StatusInternalServerError)
+			return
+		}
+		now := time.Now
+		if p.Time != nil {
+			now = p.Time
+		}
+		signRequest(r, targetURL, secret, p.Config, now())
+	}
+
 	//Add OpenTrace Headers to response
 	trace.InjectHeaders(span, r)
 
+	// the client timeout limits the upstream request
+	var deadline time.Time
+	var deadlineHeader string
+	if p.Config.DeadlinePropagate {
+		deadline, deadlineHeader = requestDeadline(r, received)
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			writeDeadlineExceeded(w, r)
+			return
+		}
+	}
+
+	tap := p.Tap.start(r, t, requestID, received)
+
 	upgrade, accept := r.Header.Get("Upgrade"), r.Header.Get("Accept")
 
-	tr := p.Transport
+	tp := p.transports(r)
+	tr := tp.Transport
 	if t.TLSSkipVerify {
-		tr = p.InsecureTransport
+		tr = tp.InsecureTransport
+	}
+	if len(t.Pins) > 0 {
+		tr = p.pinTransport(tr, t.Pins)
+	}
+	var resolveDial dialFunc
+	if t.UpstreamProxy != nil {
+		tr = p.upstreamProxyTransport(tr, t.UpstreamProxyURL())
+	} else if t.Resolve > 0 {
+		tr, resolveDial = p.resolveTransport(tr, t.Resolve, canonicalAddr(targetURL))
+	}
+
+	upstream := autoTransport(t, tr, tp)
+	stream := t.Buffer == "false"
+	if len(p.Config.RetryOn) > 0 && p.Config.RetryAttempts > 0 && !stream {
+		upstream = &retryTransport{rt: upstream, on: p.Config.RetryOn, attempts: p.Config.RetryAttempts, maxBody: p.Config.RetryMaxBody, target: t, expect: t.Expect, hits: p.Retries}
+	}
+	if t.FallbackURL != nil {
+		upstream = &fallbackTransport{rt: upstream, fallback: t.FallbackURL, timeout: t.FallbackTimeout, stream: stream, maxBody: p.Config.FallbackMaxBody, hits: p.Fallbacks}
+	}
+	if t.Buffer == "true" {
+		upstream = &bufferTransport{rt: upstream, maxBody: p.Config.BufferMaxBody}
+	}
+	var expect *expectTransport
+	if t.Expect != nil {
+		expect = &expectTransport{rt: upstream, expect: t.Expect, hits: p.Unexpected}
+		upstream = expect
+	}
+	if t.Transform != "" {
+		tf := transform.Transformers[t.Transform]
+		if tf == nil {
+			log.Printf("[ERROR] Unknown transform %q for %s", t.Transform, t.URL)
+			http.Error(w, "unknown transform", http.StatusInternalServerError)
+			return
+		}
+		upstream = &transformTransport{rt: upstream, tf: tf, maxBody: p.Config.TransformMaxBody}
+	}
+	if t.CaptureBody {
+		if ct := p.Capture.transport(upstream); ct != nil {
+			upstream = ct
+		}
+	}
+	if t.BreakerFallback != nil && t.BreakerFallback.Cache && p.BreakerCache != nil {
+		upstream = p.BreakerCache.transport(upstream, requestURL.String())
+	}
+	if serverTimingEnabled(p.Config.ServerTiming, t) {
+		rw.timing = &serverTiming{start: received}
+		upstream = &timingTransport{rt: upstream, timing: rw.timing}
+	}
+	if !deadline.IsZero() {
+		upstream = &deadlineTransport{rt: upstream, deadline: deadline, header: deadlineHeader, orig: r.Header.Get(deadlineHeader)}
+	}
+	// the Connection header is only valid for HTTP/1.x clients
+	if !p.Config.PreserveKeepAlive && r.ProtoMajor == 1 {
+		upstream = &closeTransport{rt: upstream, w: rw}
+	}
+
+	// rt detects upstream connection resets during the response
+	rt := &resetTransport{rt: upstream}
+
+	var hrt http.RoundTripper = rt
+	if t.MaxRespBody > 0 {
+		hrt = &limitTransport{rt: rt, max: t.MaxRespBody, exceeded: p.ResponseTooLarge}
 	}
 
 	var h http.Handler
 	switch {
 	case upgrade == "websocket" || upgrade == "Websocket":
 		r.URL = targetURL
-		if targetURL.Scheme == "https" || targetURL.Scheme == "wss" {
+		secure := targetURL.Scheme == "https" || targetURL.Scheme == "wss"
+		switch {
+		case t.UpstreamProxy != nil && secure:
+			tlscfg := tr.(*http.Transport).TLSClientConfig
+			if tlscfg == nil {
+				tlscfg = &tls.Config{}
+			}
+			h = newWSHandler(targetURL.Host, upstreamProxyDial(t.UpstreamProxyURL(), tlscfg, p.Config.DialTimeout))
+		case t.UpstreamProxy != nil:
+			h = newWSHandler(targetURL.Host, upstreamProxyDial(t.UpstreamProxyURL(), nil, p.Config.DialTimeout))
+		case resolveDial != nil && secure:
+			h = newWSHandler(targetURL.Host, tlsDial(resolveDial, tr.(*http.Transport).TLSClientConfig))
+		case resolveDial != nil:
+			h = newWSHandler(targetURL.Host, resolveDial)
+		case secure:
 			h = newWSHandler(targetURL.Host, func(network, address string) (net.Conn, error) {
 				return tls.Dial(network, address, tr.(*http.Transport).TLSClientConfig)
 			})
-		} else {
+		default:
 			h = newWSHandler(targetURL.Host, net.Dial)
 		}
 
 	case accept == "text/event-stream":
 		// use the flush interval for SSE (server-sent events)
 		// must be > 0s to be effective
-		h = newHTTPProxy(targetURL, tr, p.Config.FlushInterval)
+		h = newHTTPProxy(targetURL, hrt, p.Config.FlushInterval)
 
 	default:
-		h = newHTTPProxy(targetURL, tr, p.Config.GlobalFlushInterval)
+		h = newHTTPProxy(targetURL, hrt, p.Config.GlobalFlushInterval)
 	}
 
 	if p.Config.GZIPContentTypes != nil {
@@ -207,23 +599,112 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		timeNow = time.Now
 	}
 
+	if p.FairQueue != nil {
+		start := timeNow()
+		if err := p.FairQueue.acquire(r.Context(), t); err != nil {
+			log.Printf("[DEBUG] Rejected request for %s. %s", requestURL, err)
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			end := timeNow()
+			dur := end.Sub(start)
+			if p.Requests != nil && !t.NoTotals {
+				p.Requests.Update(dur)
+			}
+			t.CountStatus(rw.code)
+			if !t.NoTotals {
+				metrics.DefaultRegistry.GetTimer(key(rw.code)).Update(dur)
+			}
+			if p.Logger != nil {
+				p.Logger.Log(&logger.Event{
+					Start:   start,
+					End:     end,
+					Request: r,
+					Response: &http.Response{
+						StatusCode:    rw.code,
+						ContentLength: int64(rw.size),
+					},
+					RequestURL:      requestURL,
+					UpstreamService: t.Service,
+					RequestID:       requestID,
+					TraceID:         traceID,
+					LogTarget:       t.LogTarget,
+				})
+			}
+			return
+		}
+		defer p.FairQueue.release(t)
+	}
+
+	// The upstream request is cancelled when the client closes the
+	// connection or when the response cannot be written to the client,
+	// e.g. for streaming responses, so that the upstream server can
+	// stop working on it.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+	rw.cancel = cancel
+
+	// the deadline of the client is not a client cancellation
+	if !deadline.IsZero() {
+		dctx, dcancel := context.WithDeadline(ctx, deadline)
+		defer dcancel()
+		r = r.WithContext(dctx)
+	}
+
 	start := timeNow()
-	rw := &responseWriter{w: w}
-	h.ServeHTTP(rw, r)
+	aborted := serveAbortable(h, rw, r)
 	end := timeNow()
 	dur := end.Sub(start)
 
-	if p.Requests != nil {
+	if ctx.Err() != nil {
+		if p.ClientCancelled != nil {
+			p.ClientCancelled.Inc(1)
+		}
+		log.Printf("[DEBUG] Client cancelled request for %s after %s", requestURL, dur)
+	}
+
+	// The upstream server closed the connection after the response
+	// headers were sent. The response to the client is truncated and
+	// the client connection is aborted below.
+	reset := rt.Reset()
+	if reset {
+		if p.BackendReset != nil {
+			p.BackendReset.Inc(1)
+		}
+		log.Printf("[WARN] Upstream %s reset the connection after %d bytes of the response for %s", targetURL.Host, rw.size, requestURL)
+	}
+	if aborted {
+		defer panic(http.ErrAbortHandler)
+	}
+
+	if p.Requests != nil && !t.NoTotals {
 		p.Requests.Update(dur)
 	}
 	if t.Timer != nil {
 		t.Timer.Update(dur)
 	}
+	if p.Config.Strategy == "adaptiveload" && p.Config.LoadHeader != "" {
+		if v := rw.Header().Get(p.Config.LoadHeader); v != "" {
+			if load, err := strconv.ParseFloat(v, 64); err == nil {
+				t.UpdateLoad(load)
+			}
+		}
+	}
+	if p.Config.Strategy == "adaptive-latency" {
+		t.UpdateLatency(dur)
+	}
 	if rw.code <= 0 {
 		return
 	}
+	if expect != nil && expect.failed {
+		t.RecordResult(true)
+	} else {
+		t.RecordResponse(rw.code)
+	}
 
-	metrics.DefaultRegistry.GetTimer(key(rw.code)).Update(dur)
+	t.CountStatus(rw.code)
+	if !t.NoTotals {
+		metrics.DefaultRegistry.GetTimer(key(rw.code)).Update(dur)
+	}
 
 	// write access log
 	if p.Logger != nil {
@@ -239,10 +720,192 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			UpstreamAddr:    targetURL.Host,
 			UpstreamService: t.Service,
 			UpstreamURL:     targetURL,
+			RequestID:       requestID,
+			TraceID:         traceID,
+			UpstreamReset:   reset,
+			LogTarget:       t.LogTarget,
+		})
+	}
+
+	if tap != nil {
+		p.Tap.finish(tap, rw, dur)
+	}
+}
+
+// logNoRoute logs a request without a route in the access log
+// if this is enabled with proxy.noroute.log.
+func (p *HTTPProxy) logNoRoute(r *http.Request, start time.Time, status, size int, requestID, traceID string) {
+	if p.Config.NoRouteLog && p.Logger != nil {
+		p.Logger.Log(&logger.Event{
+			Start:   start,
+			End:     time.Now(),
+			Request: r,
+			Response: &http.Response{
+				StatusCode:    status,
+				ContentLength: int64(size),
+			},
+			RequestURL: &url.URL{
+				Scheme:   scheme(r),
+				Host:     r.Host,
+				Path:     r.URL.Path,
+				RawQuery: r.URL.RawQuery,
+			},
+			RequestID: requestID,
+			TraceID:   traceID,
 		})
 	}
 }
 
+// upstreamURL returns the URL of the request for the upstream server
+// at dst. The query strings are merged and stripPath is removed from
+// the front of the request path.
+func upstreamURL(r *http.Request, dst *url.URL, stripPath string) *url.URL {
+	u := &url.URL{
+		Scheme: dst.Scheme,
+		Host:   dst.Host,
+		Path:   r.URL.Path,
+	}
+	if dst.RawQuery == "" || r.URL.RawQuery == "" {
+		u.RawQuery = dst.RawQuery + r.URL.RawQuery
+	} else {
+		u.RawQuery = dst.RawQuery + "&" + r.URL.RawQuery
+	}
+
+	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
+	// TODO(fs): have found the target based on the prefix but there may be other
+	// TODO(fs): matchers which may have different rules. I'll keep this for
+	// TODO(fs): a defensive approach.
+	if stripPath != "" && strings.HasPrefix(r.URL.Path, stripPath) {
+		u.Path = u.Path[len(stripPath):]
+		// ensure absolute path after stripping to maintain compliance with
+		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
+		if !strings.HasPrefix(u.Path, "/") {
+			u.Path = "/" + u.Path
+		}
+	}
+	return u
+}
+
+// serveFile writes the content of the file with the given status code.
+// The content type is derived from the file extension.
+func serveFile(w http.ResponseWriter, path string, code int) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("[ERROR] Cannot read %s. %s", path, err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// autoTransport returns the protocol negotiating transport for targets
+// with the 'proto=auto' option and tr otherwise. Targets with an
+// upstream proxy or the 'resolve' option always use tr since the
+// protocol negotiation uses its own dialer.
+func autoTransport(t *route.Target, tr http.RoundTripper, tp Transports) http.RoundTripper {
+	if !t.AutoProto || t.UpstreamProxy != nil || t.Resolve > 0 || len(t.Pins) > 0 {
+		return tr
+	}
+	atr := tp.AutoTransport
+	if t.TLSSkipVerify {
+		atr = tp.InsecureAutoTransport
+	}
+	if atr == nil {
+		return tr
+	}
+	return protoRecorder{rt: atr, t: t}
+}
+
+// transports returns the connection pools for the tenant of the
+// request or the shared connection pools.
+func (p *HTTPProxy) transports(r *http.Request) Transports {
+	if p.Tenants != nil {
+		if tp, ok := p.Tenants.Get(r.Header.Get(p.Tenants.Header)); ok {
+			return tp
+		}
+	}
+	return Transports{
+		Transport:             p.Transport,
+		InsecureTransport:     p.InsecureTransport,
+		AutoTransport:         p.AutoTransport,
+		InsecureAutoTransport: p.InsecureAutoTransport,
+	}
+}
+
+// usedTransports returns the shared connection pools and the
+// connection pools of the current tenants.
+func (p *HTTPProxy) usedTransports() map[http.RoundTripper]bool {
+	used := map[http.RoundTripper]bool{}
+	tps := []Transports{{p.Transport, p.InsecureTransport, p.AutoTransport, p.InsecureAutoTransport}}
+	if p.Tenants != nil {
+		tps = append(tps, p.Tenants.all()...)
+	}
+	for _, tp := range tps {
+		for _, rt := range []http.RoundTripper{tp.Transport, tp.InsecureTransport, tp.AutoTransport, tp.InsecureAutoTransport} {
+			if rt != nil {
+				used[rt] = true
+			}
+		}
+	}
+	return used
+}
+
+// secret returns the secret for the reference of a 'sign' option.
+func (p *HTTPProxy) secret(ref string) ([]byte, error) {
+	if p.Secret == nil {
+		return nil, errors.New("no secrets configured")
+	}
+	return p.Secret(ref)
+}
+
+// overrideMethods are the methods which can be set with the method
+// override header.
+var overrideMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// overrideMethod sets the method of POST requests to the value of the
+// method override header for clients which can only send GET and POST
+// requests. The header is removed when the method has been changed.
+func (p *HTTPProxy) overrideMethod(r *http.Request) {
+	hdr := p.Config.MethodOverrideHeader
+	if hdr == "" || r.Method != http.MethodPost {
+		return
+	}
+	m := strings.ToUpper(strings.TrimSpace(r.Header.Get(hdr)))
+	if !overrideMethods[m] {
+		return
+	}
+	r.Method = m
+	r.Header.Del(hdr)
+}
+
+// noRouteStatus returns the status code for requests without a route.
+func (p *HTTPProxy) noRouteStatus() int {
+	status := p.Config.NoRouteStatus
+	if status < 100 || status > 999 {
+		return http.StatusNotFound
+	}
+	return status
+}
+
+// newRequestID returns a new request id in the configured format.
+func (p *HTTPProxy) newRequestID() string {
+	if p.UUID != nil {
+		return p.UUID()
+	}
+	if p.Config.RequestIDFormat == "hex" {
+		return uuid.NewHex()
+	}
+	return uuid.NewUUID()
+}
+
 func key(code int) string {
 	b := []byte("http.status.")
 	b = strconv.AppendInt(b, int64(code), 10)
@@ -252,10 +915,40 @@ func key(code int) string {
 // responseWriter wraps an http.ResponseWriter to capture the status code and
 // the size of the response. It also implements http.Hijacker to forward
 // hijacking the connection to the wrapped writer if supported.
+//
+// If requestIDHeader or traceIDHeader is set the request id or the trace
+// id is added to error responses unless the upstream server has already
+// provided it.
 type responseWriter struct {
 	w    http.ResponseWriter
 	code int
 	size int
+
+	requestIDHeader string
+	requestID       string
+	traceIDHeader   string
+	traceID         string
+
+	// respHeaders are added to the response unless the
+	// upstream server has already set them.
+	respHeaders []config.ResponseHeader
+
+	// timing adds the Server-Timing header to the response
+	// if it is not nil.
+	timing *serverTiming
+
+	// deprecated adds the deprecation headers of the
+	// target to the response if it is not nil.
+	deprecated *route.Target
+
+	// cancel cancels the upstream request if the response
+	// cannot be written to the client.
+	cancel context.CancelFunc
+
+	// headerSizes records the size of the response header
+	// of req if it is not nil.
+	headerSizes *HeaderSizes
+	req         *http.Request
 }
 
 func (rw *responseWriter) Header() http.Header {
@@ -263,12 +956,44 @@ func (rw *responseWriter) Header() http.Header {
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.code == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
 	n, err := rw.w.Write(b)
 	rw.size += n
+	if err != nil && rw.cancel != nil {
+		rw.cancel()
+	}
 	return n, err
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
+	// informational responses like '100 Continue' are relayed
+	// to the client but are not the final status of the response.
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		rw.w.WriteHeader(statusCode)
+		return
+	}
+	for _, h := range rw.respHeaders {
+		if h.Force || rw.w.Header().Get(h.Name) == "" {
+			rw.w.Header().Set(h.Name, h.Value)
+		}
+	}
+	if rw.timing != nil {
+		rw.w.Header().Add("Server-Timing", rw.timing.header())
+	}
+	if rw.deprecated != nil {
+		addDeprecationHeaders(rw.w.Header(), rw.deprecated)
+	}
+	if statusCode >= 400 && rw.requestIDHeader != "" && rw.requestID != "" && rw.w.Header().Get(rw.requestIDHeader) == "" {
+		rw.w.Header().Set(rw.requestIDHeader, rw.requestID)
+	}
+	if statusCode >= 400 && rw.traceIDHeader != "" && rw.traceID != "" && rw.w.Header().Get(rw.traceIDHeader) == "" {
+		rw.w.Header().Set(rw.traceIDHeader, rw.traceID)
+	}
+	if rw.headerSizes != nil {
+		rw.headerSizes.response(rw.req, rw.w.Header())
+	}
 	rw.w.WriteHeader(statusCode)
 	rw.code = statusCode
 }
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// maxQuotaKeys is the maximum number of quota counters. When the limit
+// is reached the counters of the past quota windows are removed and
+// requests with new client keys are rejected if this is not enough.
+// The counters of the current windows are never removed so that
+// random keys cannot exhaust the memory or reset the quotas of the
+// other clients.
+var maxQuotaKeys = 1000000
+
+// quotaSweepInterval is the minimum time between two scans
+// for the counters of the past quota windows.
+const quotaSweepInterval = time.Second
+
+// Quotas counts the requests of the routes with the 'quota' option per
+// client key and quota window. The counters are kept in memory and are
+// saved in the Store so that a restart does not reset the quotas. The
+// client keys are only kept as a hash.
+type Quotas struct {
+	// Store persists the counters. The counters are only kept in
+	// memory if Store is nil.
+	Store QuotaStore
+
+	mu     sync.Mutex
+	counts map[string]*QuotaCount
+	swept  time.Time
+	dirty  bool
+}
+
+// QuotaCount is the number of requests of a client key in the
+// quota window which ends at Reset.
+type QuotaCount struct {
+	N     int64     `json:"n"`
+	Reset time.Time `json:"reset"`
+}
+
+// QuotaStore loads and saves the quota counters.
+type QuotaStore interface {
+	Load() (map[string]*QuotaCount, error)
+	Save(counts map[string]*QuotaCount) error
+}
+
+// QuotaFile stores the quota counters as JSON in a file.
+type QuotaFile string
+
+// Load reads the counters from the file. A missing file
+// has no counters.
+func (f QuotaFile) Load() (map[string]*QuotaCount, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var counts map[string]*QuotaCount
+	if err := json.Unmarshal(b, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// Save replaces the file atomically with the counters.
+func (f QuotaFile) Save(counts map[string]*QuotaCount) error {
+	b, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), "."+filepath.Base(string(f)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+// Load sets the counters from the store.
+func (q *Quotas) Load() error {
+	if q.Store == nil {
+		return nil
+	}
+	counts, err := q.Store.Load()
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.counts = counts
+	q.mu.Unlock()
+	return nil
+}
+
+// Save removes the counters of the past quota windows and saves the
+// other counters in the store if they have changed.
+func (q *Quotas) Save() error {
+	if q.Store == nil {
+		return nil
+	}
+	now := time.Now()
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	counts := make(map[string]*QuotaCount, len(q.counts))
+	for k, c := range q.counts {
+		if now.Before(c.Reset) {
+			counts[k] = &QuotaCount{N: c.N, Reset: c.Reset}
+		} else {
+			delete(q.counts, k)
+		}
+	}
+	q.dirty = false
+	q.mu.Unlock()
+
+	if err := q.Store.Save(counts); err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// take counts a request of the client key for the quota of the route.
+// It returns the number of remaining requests and the end of the quota
+// window. ok is false if the quota has been exhausted or if there is no
+// room for the counter of a new client key.
+func (q *Quotas) take(routeKey, clientKey string, quota *route.Quota, now time.Time) (remaining int64, reset time.Time, ok bool) {
+	h := sha256.Sum256([]byte(clientKey))
+	key := routeKey + " " + hex.EncodeToString(h[:])
+	reset = quota.Window(now)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.counts == nil {
+		q.counts = map[string]*QuotaCount{}
+	}
+	c := q.counts[key]
+	if c == nil {
+		if len(q.counts) >= maxQuotaKeys {
+			q.sweep(now)
+		}
+		if len(q.counts) >= maxQuotaKeys {
+			return 0, reset, false
+		}
+		c = &QuotaCount{}
+		q.counts[key] = c
+	}
+	// a new window or a changed period of the quota
+	if !c.Reset.Equal(reset) {
+		c.N, c.Reset = 0, reset
+	}
+	if c.N >= quota.Limit {
+		return 0, reset, false
+	}
+	c.N++
+	q.dirty = true
+	return quota.Limit - c.N, reset, true
+}
+
+// sweep removes the counters of the past quota windows unless this
+// was done less than quotaSweepInterval ago. q.mu must be held.
+func (q *Quotas) sweep(now time.Time) {
+	if now.Sub(q.swept) < quotaSweepInterval {
+		return
+	}
+	q.swept = now
+	for k, c := range q.counts {
+		if !now.Before(c.Reset) {
+			delete(q.counts, k)
+			q.dirty = true
+		}
+	}
+}
+
+// checkQuota counts the request against the quota of the route of t and
+// adds the quota headers to the response. It responds with 429 Too Many
+// Requests and returns false if the quota of the client is exhausted.
+// Requests without the key header share one quota.
+func (p *HTTPProxy) checkQuota(w http.ResponseWriter, r *http.Request, t *route.Target) bool {
+	quota := t.Quota
+	now := time.Now()
+	remaining, reset, ok := p.Quotas.take(t.Prefix, r.Header.Get(quota.Header), quota, now)
+	secs := strconv.FormatInt(int64(math.Ceil(reset.Sub(now).Seconds())), 10)
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.FormatInt(quota.Limit, 10))
+	h.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	h.Set("X-RateLimit-Reset", secs)
+	if ok {
+		return true
+	}
+	if p.QuotaExceeded != nil {
+		p.QuotaExceeded.Inc(1)
+	}
+	h.Set("Retry-After", secs)
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestQuotasTake(t *testing.T) {
+	quota := &route.Quota{Limit: 2, Period: time.Hour}
+	now := time.Date(2020, 5, 17, 13, 45, 0, 0, time.UTC)
+	q := &Quotas{}
+
+	tests := []struct {
+		desc      string
+		key       string
+		now       time.Time
+		remaining int64
+		ok        bool
+	}{
+		{"first", "a", now, 1, true},
+		{"second", "a", now, 0, true},
+		{"exhausted", "a", now, 0, false},
+		{"other key", "b", now, 1, true},
+		{"next window", "a", now.Add(15 * time.Minute), 1, true},
+	}
+	for _, tt := range tests {
+		remaining, _, ok := q.take("/api", tt.key, quota, tt.now)
+		if remaining != tt.remaining || ok != tt.ok {
+			t.Fatalf("%s: got %d, %v want %d, %v", tt.desc, remaining, ok, tt.remaining, tt.ok)
+		}
+	}
+}
+
+func TestQuotasMaxKeys(t *testing.T) {
+	prev := maxQuotaKeys
+	defer func() { maxQuotaKeys = prev }()
+	maxQuotaKeys = 2
+
+	quota := &route.Quota{Limit: 1, Period: time.Hour}
+	now := time.Date(2020, 5, 17, 13, 45, 0, 0, time.UTC)
+	q := &Quotas{}
+
+	tests := []struct {
+		desc string
+		key  string
+		now  time.Time
+		ok   bool
+	}{
+		{"a", "a", now, true},
+		{"b", "b", now, true},
+		{"a exhausted", "a", now, false},
+		{"new key rejected", "c", now, false},
+		{"a still exhausted", "a", now, false},
+		{"b still exhausted", "b", now, false},
+		{"next window", "a", now.Add(15 * time.Minute), true},
+		{"past window of b removed", "c", now.Add(15 * time.Minute), true},
+		{"new key rejected in next window", "d", now.Add(15 * time.Minute), false},
+	}
+	for _, tt := range tests {
+		if _, _, ok := q.take("/api", tt.key, quota, tt.now); ok != tt.ok {
+			t.Fatalf("%s: got %v want %v", tt.desc, ok, tt.ok)
+		}
+	}
+	if got, want := len(q.counts), 2; got != want {
+		t.Fatalf("got %d counters want %d", got, want)
+	}
+}
+
+func TestQuotasSaveLoad(t *testing.T) {
+	file := QuotaFile(filepath.Join(t.TempDir(), "quota.json"))
+	quota := &route.Quota{Limit: 5, Period: time.Hour}
+
+	q := &Quotas{Store: file}
+	if err := q.Load(); err != nil {
+		t.Fatal(err)
+	}
+	q.take("/api", "a", quota, time.Now())
+	q.take("/api", "a", quota, time.Now())
+	// counters of past windows are not saved
+	q.take("/api", "b", quota, time.Now().Add(-2*time.Hour))
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = &Quotas{Store: file}
+	if err := q.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(q.counts), 1; got != want {
+		t.Fatalf("got %d counters want %d", got, want)
+	}
+	if remaining, _, _ := q.take("/api", "a", quota, time.Now()); remaining != 2 {
+		t.Fatalf("got %d remaining requests want 2", remaining)
+	}
+}
+
+func TestProxyQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tbl, _ := route.NewTable(bytes.NewBufferString("route add api /api " + server.URL + ` opts "quota=1/24h:header:X-Api-Key"`))
+	exceeded := &testCounter{}
+	proxy := &HTTPProxy{
+		Config:        config.Proxy{},
+		Transport:     http.DefaultTransport,
+		Quotas:        &Quotas{},
+		QuotaExceeded: exceeded,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	}
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("X-Api-Key", key)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("a")
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header().Get("X-RateLimit-Remaining"), "0"; got != want {
+		t.Fatalf("got remaining %q want %q", got, want)
+	}
+
+	rec = do("a")
+	if got, want := rec.Code, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if rec.Header().Get("Retry-After") == "" || rec.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Fatalf("got headers %v want quota headers", rec.Header())
+	}
+	if got, want := exceeded.n, int64(1); got != want {
+		t.Fatalf("got %d exceeded want %d", got, want)
+	}
+
+	if got, want := do("b").Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// resolveKey identifies the copy of a transport which re-resolves
+// the upstream hosts in an interval.
+type resolveKey struct {
+	tr       *http.Transport
+	interval time.Duration
+}
+
+// resolvingTransport is a copy of a transport which dials with
+// the resolver.
+type resolvingTransport struct {
+	tr  *http.Transport
+	res *dnsResolver
+}
+
+// resolveTransport returns a copy of the transport which resolves the
+// upstream hosts again after the interval and spreads the connections
+// round-robin over the resolved addresses, and the dial function of the
+// copy for websocket connections. The host of addr is resolved again
+// if the interval has passed so that the connections to addresses which
+// are gone are closed while the target receives requests. The copies
+// are cached so that they keep their connection pools. The unused copies
+// and hosts are removed when a new copy or host is added.
+func (p *HTTPProxy) resolveTransport(tr http.RoundTripper, interval time.Duration, addr string) (http.RoundTripper, dialFunc) {
+	htr, ok := tr.(*http.Transport)
+	if !ok {
+		log.Printf("[WARN] Cannot resolve %s with transport %T", addr, tr)
+		return tr, nil
+	}
+
+	key := resolveKey{tr: htr, interval: interval}
+	v, ok := p.resolvers.Load(key)
+	if !ok {
+		res := &dnsResolver{
+			Interval: interval,
+			Dialer:   &net.Dialer{Timeout: p.Config.DialTimeout, KeepAlive: p.Config.KeepAliveTimeout},
+		}
+		rtr := htr.Clone()
+		rtr.DialContext = nil
+		rtr.Dial = res.Dial
+		var loaded bool
+		v, loaded = p.resolvers.LoadOrStore(key, resolvingTransport{tr: rtr, res: res})
+		if !loaded {
+			p.removeResolvers(key)
+		}
+	}
+	rt := v.(resolvingTransport)
+	h, added, err := rt.res.host(addr)
+	if err == nil {
+		h.refresh(rt.res)
+	}
+	if added {
+		_, addrs := resolvedTargets()
+		rt.res.removeHosts(addrs, addr)
+	}
+	return rt.tr, rt.res.Dial
+}
+
+// removeResolvers removes the copies of the transports for the intervals
+// which are not used by a target of the active routing table and the
+// copies of the transports which are no longer used, e.g. the connection
+// pools of evicted tenants, except for the copy with the key keep.
+func (p *HTTPProxy) removeResolvers(keep resolveKey) {
+	intervals, _ := resolvedTargets()
+	transports := p.usedTransports()
+	p.pinned.Range(func(_, v interface{}) bool {
+		transports[v.(*http.Transport)] = true
+		return true
+	})
+	p.resolvers.Range(func(k, v interface{}) bool {
+		if key := k.(resolveKey); key != keep && (!intervals[key.interval] || !transports[key.tr]) {
+			p.resolvers.Delete(k)
+			v.(resolvingTransport).tr.CloseIdleConnections()
+		}
+		return true
+	})
+}
+
+// resolvedTargets returns the intervals and the addresses of the
+// targets of the active routing table with the 'resolve' option.
+func resolvedTargets() (intervals map[time.Duration]bool, addrs map[string]bool) {
+	intervals, addrs = map[time.Duration]bool{}, map[string]bool{}
+	for _, routes := range route.GetTable() {
+		for _, r := range routes {
+			for _, t := range r.Targets {
+				if t.Resolve > 0 {
+					intervals[t.Resolve] = true
+					addrs[canonicalAddr(t.URL)] = true
+				}
+			}
+		}
+	}
+	return intervals, addrs
+}
+
+// dnsResolver dials upstream hosts by name with the addresses which
+// are resolved again after the interval. New connections are spread
+// round-robin over the addresses. Connections to addresses which are
+// no longer resolved are closed. The addresses are kept if the host
+// cannot be resolved.
+type dnsResolver struct {
+	// Interval is the interval after which a host is resolved again.
+	Interval time.Duration
+
+	// Dialer establishes the connections to the resolved addresses.
+	Dialer *net.Dialer
+
+	// LookupHost resolves a host to its IPv4 and IPv6 addresses.
+	// If LookupHost is nil net.LookupHost is used.
+	LookupHost func(host string) ([]string, error)
+
+	mu    sync.Mutex
+	hosts map[string]*dnsHost
+}
+
+// Dial connects to the next resolved address of the host of addr.
+// The host is resolved again first if the interval has passed.
+func (r *dnsResolver) Dial(network, addr string) (net.Conn, error) {
+	h, _, err := r.host(addr)
+	if err != nil {
+		return nil, err
+	}
+	h.refresh(r)
+	return h.dial(r.Dialer, network)
+}
+
+// host returns the state of the host of addr and whether
+// the host was added.
+func (r *dnsResolver) host(addr string) (*dnsHost, bool, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = map[string]*dnsHost{}
+	}
+	if h := r.hosts[addr]; h != nil {
+		return h, false, nil
+	}
+	h := &dnsHost{host: host, port: port, conns: map[*dnsConn]bool{}}
+	r.hosts[addr] = h
+	return h, true, nil
+}
+
+// removeHosts removes the hosts whose address is not in used except
+// for the host of keep. The connections to the hosts are not closed
+// and are removed from the connection pool by the transport.
+func (r *dnsResolver) removeHosts(used map[string]bool, keep string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr := range r.hosts {
+		if addr != keep && !used[addr] {
+			delete(r.hosts, addr)
+		}
+	}
+}
+
+// dnsHost contains the resolved addresses of an upstream host and
+// the connections to them.
+type dnsHost struct {
+	// next is the counter for the round-robin over the addresses.
+	// It is the first field for the alignment of the atomic access.
+	next uint64
+
+	host, port string
+
+	mu        sync.Mutex
+	addrs     []string
+	resolved  time.Time
+	resolving bool
+	conns     map[*dnsConn]bool
+}
+
+// refresh resolves the host again if the interval has passed and
+// closes the connections to the addresses which are gone. Concurrent
+// callers use the current addresses while the host is resolved.
+func (h *dnsHost) refresh(r *dnsResolver) {
+	h.mu.Lock()
+	if h.resolving || (!h.resolved.IsZero() && time.Since(h.resolved) < r.Interval) {
+		h.mu.Unlock()
+		return
+	}
+	h.resolving = true
+	h.mu.Unlock()
+
+	var addrs []string
+	var err error
+	switch {
+	case net.ParseIP(h.host) != nil:
+		addrs = []string{h.host}
+	case r.LookupHost != nil:
+		addrs, err = r.LookupHost(h.host)
+	default:
+		addrs, err = net.LookupHost(h.host)
+	}
+
+	h.mu.Lock()
+	h.resolving = false
+	h.resolved = time.Now()
+	if err != nil || len(addrs) == 0 {
+		h.mu.Unlock()
+		log.Printf("[WARN] Cannot resolve %s. Using %v. %v", h.host, h.addrs, err)
+		return
+	}
+	active := map[string]bool{}
+	for _, a := range addrs {
+		active[a] = true
+	}
+	var stale []*dnsConn
+	for c := range h.conns {
+		if !active[c.addr] {
+			stale = append(stale, c)
+		}
+	}
+	if changed(h.addrs, active) {
+		log.Printf("[INFO] Resolved %s to %v", h.host, addrs)
+	}
+	h.addrs = addrs
+	h.mu.Unlock()
+
+	for _, c := range stale {
+		log.Printf("[INFO] Closing connection to %s since %s no longer resolves to it", c.addr, h.host)
+		c.Close()
+	}
+}
+
+// dial connects to the next address which accepts the connection.
+func (h *dnsHost) dial(d *net.Dialer, network string) (net.Conn, error) {
+	h.mu.Lock()
+	addrs := h.addrs
+	h.mu.Unlock()
+	if len(addrs) == 0 {
+		return d.Dial(network, net.JoinHostPort(h.host, h.port))
+	}
+
+	n := atomic.AddUint64(&h.next, 1)
+	var err error
+	for i := range addrs {
+		addr := addrs[(n+uint64(i))%uint64(len(addrs))]
+		var c net.Conn
+		c, err = d.Dial(network, net.JoinHostPort(addr, h.port))
+		if err != nil {
+			continue
+		}
+		dc := &dnsConn{Conn: c, addr: addr, host: h}
+		h.mu.Lock()
+		h.conns[dc] = true
+		h.mu.Unlock()
+		return dc, nil
+	}
+	return nil, err
+}
+
+// dnsConn is a connection to a resolved address of a host.
+type dnsConn struct {
+	net.Conn
+	addr string
+	host *dnsHost
+}
+
+func (c *dnsConn) Close() error {
+	c.host.mu.Lock()
+	delete(c.host.conns, c)
+	c.host.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// changed returns whether the addresses differ from the active
+// addresses regardless of their order.
+func changed(addrs []string, active map[string]bool) bool {
+	if len(addrs) != len(active) {
+		return true
+	}
+	for _, a := range addrs {
+		if !active[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalAddr returns the host:port of the URL with the default
+// port of the scheme if the URL has none.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https", "wss":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestDNSResolver(t *testing.T) {
+	// listen on two loopback addresses with the same port
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	_, port, _ := net.SplitHostPort(l1.Addr().String())
+	l2, err := net.Listen("tcp", "127.0.0.2:"+port)
+	if err != nil {
+		t.Skip("cannot listen on 127.0.0.2: ", err)
+	}
+	defer l2.Close()
+
+	// accept records the server side of the connections
+	accepted := map[string][]net.Conn{}
+	var mu sync.Mutex
+	accept := func(l net.Listener) {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(c.LocalAddr().String())
+			mu.Lock()
+			accepted[host] = append(accepted[host], c)
+			mu.Unlock()
+		}
+	}
+	go accept(l1)
+	go accept(l2)
+
+	var addrs []string
+	var lookups int
+	res := &dnsResolver{
+		Interval: time.Hour,
+		Dialer:   &net.Dialer{Timeout: time.Second},
+		LookupHost: func(host string) ([]string, error) {
+			if host != "backend.internal" {
+				t.Fatalf("got lookup for %s", host)
+			}
+			lookups++
+			return addrs, nil
+		},
+	}
+	addr := net.JoinHostPort("backend.internal", port)
+
+	// connections are spread over the addresses and the host
+	// is not resolved again within the interval
+	addrs = []string{"127.0.0.1", "127.0.0.2"}
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		c, err := res.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	if lookups != 1 {
+		t.Fatalf("got %d lookups want 1", lookups)
+	}
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			mu.Lock()
+			ok := cond()
+			mu.Unlock()
+			if ok {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitFor(func() bool { return len(accepted["127.0.0.1"]) == 2 && len(accepted["127.0.0.2"]) == 2 }) {
+		t.Fatalf("connections were not spread over the addresses: %v", accepted)
+	}
+
+	// the connections to the address which is gone are closed
+	// after the interval and new connections use the new address
+	addrs = []string{"127.0.0.2"}
+	res.Interval = time.Nanosecond
+	c, err := res.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if lookups != 2 {
+		t.Fatalf("got %d lookups want 2", lookups)
+	}
+	for _, c := range conns {
+		host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+		c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, err := c.Read(make([]byte, 1))
+		closed := err != nil && !err.(net.Error).Timeout()
+		if want := host == "127.0.0.1"; closed != want {
+			t.Fatalf("connection to %s: got closed %v want %v", host, closed, want)
+		}
+	}
+	if !waitFor(func() bool { return len(accepted["127.0.0.2"]) == 3 }) {
+		t.Fatalf("new connection did not use the new address: %v", accepted)
+	}
+
+	// the addresses are kept if the host cannot be resolved
+	addrs = nil
+	if _, err := res.Dial("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveResolvers(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`route add svc / http://127.0.0.1:81/ opts "resolve=dns:10s"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := route.GetTable()
+	defer route.SetTable(prev)
+	route.SetTable(tbl)
+
+	tr := &http.Transport{}
+	p := &HTTPProxy{Transport: tr}
+	cached := func(tr *http.Transport, interval time.Duration) bool {
+		_, ok := p.resolvers.Load(resolveKey{tr: tr, interval: interval})
+		return ok
+	}
+
+	// intervals and transports which are no longer used are removed
+	p.resolveTransport(tr, 10*time.Second, "127.0.0.1:81")
+	p.resolveTransport(tr, time.Minute, "127.0.0.1:81")
+	p.resolveTransport(tr, time.Hour, "127.0.0.1:81")
+	if !cached(tr, 10*time.Second) || cached(tr, time.Minute) || !cached(tr, time.Hour) {
+		t.Fatal("unused interval not removed")
+	}
+	evicted := &http.Transport{}
+	p.resolveTransport(evicted, 10*time.Second, "127.0.0.1:81")
+	p.resolveTransport(tr, 20*time.Second, "127.0.0.1:81")
+	if cached(evicted, 10*time.Second) || !cached(tr, 10*time.Second) {
+		t.Fatal("copy of unused transport not removed")
+	}
+
+	// hosts which are no longer used are removed
+	hosts := func() map[string]bool {
+		v, _ := p.resolvers.Load(resolveKey{tr: tr, interval: 10 * time.Second})
+		res := v.(resolvingTransport).res
+		res.mu.Lock()
+		defer res.mu.Unlock()
+		m := map[string]bool{}
+		for addr := range res.hosts {
+			m[addr] = true
+		}
+		return m
+	}
+	p.resolveTransport(tr, 10*time.Second, "127.0.0.2:81")
+	p.resolveTransport(tr, 10*time.Second, "127.0.0.3:81")
+	if got := hosts(); !got["127.0.0.1:81"] || got["127.0.0.2:81"] || !got["127.0.0.3:81"] {
+		t.Fatalf("got hosts %v want 127.0.0.1:81 and 127.0.0.3:81", got)
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// errResponseTooLarge is returned when the response of the upstream
+// server exceeds the 'maxrespbody' limit of the route.
+var errResponseTooLarge = errors.New("response body too large")
+
+// limitTransport enforces the maximum response body size of a target.
+// Responses whose Content-Length exceeds the limit are rejected before
+// anything is sent to the client. Other responses are truncated after
+// max bytes and the client connection is aborted.
+type limitTransport struct {
+	rt       http.RoundTripper
+	max      int64
+	exceeded metrics.Counter
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols || req.Method == "HEAD" {
+		return resp, err
+	}
+	if resp.ContentLength > t.max {
+		resp.Body.Close()
+		t.tooLarge(req)
+		return nil, errResponseTooLarge
+	}
+	resp.Body = &limitBody{ReadCloser: resp.Body, req: req, t: t}
+	return resp, nil
+}
+
+func (t *limitTransport) tooLarge(req *http.Request) {
+	if t.exceeded != nil {
+		t.exceeded.Inc(1)
+	}
+	log.Printf("[WARN] Response for %s exceeds the limit of %d bytes", req.URL, t.max)
+}
+
+// limitBody passes at most max bytes of the response body and
+// fails with errResponseTooLarge if the body is larger.
+type limitBody struct {
+	io.ReadCloser
+	req *http.Request
+	t   *limitTransport
+	n   int64
+}
+
+func (b *limitBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.n > b.t.max {
+		n -= int(b.n - b.t.max)
+		b.t.tooLarge(b.req)
+		return n, errResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyMaxRespBody(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("ok"))
+		case "/length":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(body))
+		case "/chunked":
+			for i := 0; i < 10; i++ {
+				w.Write([]byte(body[:10]))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer server.Close()
+
+	exceeded, resets := &testCounter{}, &testCounter{}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:           config.Proxy{},
+		Transport:        http.DefaultTransport,
+		ResponseTooLarge: exceeded,
+		BackendReset:     resets,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL), MaxRespBody: 50}
+		},
+	})
+	defer proxy.Close()
+
+	t.Run("below limit", func(t *testing.T) {
+		resp, err := http.Get(proxy.URL + "/small")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "ok"; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	})
+
+	t.Run("content length", func(t *testing.T) {
+		resp, err := http.Get(proxy.URL + "/length")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusBadGateway; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		resp, err := http.Get(proxy.URL + "/chunked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Fatal("got complete response want aborted response")
+		}
+		if len(b) > 50 {
+			t.Fatalf("got %d bytes want at most 50", len(b))
+		}
+	})
+
+	if got, want := atomic.LoadInt64(&exceeded.n), int64(2); got != want {
+		t.Fatalf("got %d responses too large want %d", got, want)
+	}
+	if got, want := atomic.LoadInt64(&resets.n), int64(0); got != want {
+		t.Fatalf("got %d backend resets want %d", got, want)
+	}
+}
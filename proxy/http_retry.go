@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+	"golang.org/x/net/http2"
+)
+
+// retryTransport retries upstream requests which failed with one of
+// the configured conditions:
+//
+//	connect-failure: the connection to the upstream server could not be established
+//	refused-stream:  the upstream HTTP/2 server refused the stream
+//	goaway:          the upstream HTTP/2 server sent a GOAWAY frame, e.g. during a graceful shutdown
+//	reset:           the connection was closed before the response headers were received
+//	5xx:             the upstream server returned the status code, e.g. 503
+//	unexpected:      the response does not match the 'expect' option of the target
+//
+// The request was not sent for the connect-failure and refused-stream
+// conditions and for the goaway condition if the stream was above the
+// last stream of the GOAWAY frame. They are retried for all methods.
+// The other conditions are only retried for idempotent methods since
+// the upstream server may have processed the request. The request body
+// is buffered up to maxBody bytes so that it can be sent again. Requests
+// with larger bodies are not retried. The body can also be sent again
+// by the HTTP/2 transport which retries the streams refused by a GOAWAY
+// frame on a new connection itself if it can rewind the body.
+//
+// Every retry is sent to another target of the same service as the
+// target if there is one which has not been tried yet. Otherwise, it
+// is sent to the target again.
+type retryTransport struct {
+	rt       http.RoundTripper
+	on       []string
+	attempts int
+	maxBody  int
+
+	// target is the target of the request.
+	target *route.Target
+
+	// expect describes the expected responses for the
+	// unexpected condition.
+	expect *route.Expect
+
+	// hits counts the retried requests.
+	hits metrics.Counter
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok, err := readBody(req, t.maxBody)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return t.rt.RoundTrip(req)
+	}
+
+	r := req
+	tried := []*route.Target{t.target}
+	for attempt := 1; ; attempt++ {
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+		resp, err := t.rt.RoundTrip(r)
+
+		cond := t.condition(r, resp, err)
+		if cond == "" || attempt > t.attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if t.hits != nil {
+			t.hits.Inc(1)
+		}
+		r = req.Clone(req.Context())
+		if next := nextTarget(t.target, tried); next != nil {
+			tried = append(tried, next)
+			r.URL.Host = next.URL.Host
+			if next.Host == "dst" {
+				r.Host = next.URL.Host
+			}
+		}
+		log.Printf("[WARN] Retrying %s on %s after %s (%d/%d)", req.URL, r.URL.Host, cond, attempt, t.attempts)
+	}
+}
+
+// nextTarget returns a random target of the same service and with the
+// same scheme as t which has not been tried and which is not ejected.
+// It returns nil if there is no such target.
+func nextTarget(t *route.Target, tried []*route.Target) *route.Target {
+	if t == nil {
+		return nil
+	}
+	var next []*route.Target
+	for _, pt := range t.Peers() {
+		if pt.Service != t.Service || pt.URL.Scheme != t.URL.Scheme || pt.Weight <= 0 || pt.Ejected() || containsTarget(tried, pt) {
+			continue
+		}
+		next = append(next, pt)
+	}
+	if len(next) == 0 {
+		return nil
+	}
+	return next[rand.Intn(len(next))]
+}
+
+func containsTarget(targets []*route.Target, t *route.Target) bool {
+	for _, x := range targets {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// condition returns the configured condition which matches
+// the result of the request or an empty string.
+func (t *retryTransport) condition(req *http.Request, resp *http.Response, err error) string {
+	var cond string
+	var unprocessed bool
+	switch {
+	case err == nil:
+		cond = strconv.Itoa(resp.StatusCode)
+		if !t.retryOn(cond) && !t.expect.Match(resp.StatusCode, resp.Header) {
+			cond = "unexpected"
+		}
+	case isConnectFailure(err):
+		cond = "connect-failure"
+	case isRefusedStream(err):
+		cond = "refused-stream"
+	case isGoAway(err):
+		cond = "goaway"
+		unprocessed = isGoAwayUnprocessed(err)
+	case isReset(err):
+		cond = "reset"
+	default:
+		return ""
+	}
+
+	if !t.retryOn(cond) {
+		return ""
+	}
+	safe := cond == "connect-failure" || cond == "refused-stream" || unprocessed
+	if !safe && !isIdempotent(req.Method) {
+		return ""
+	}
+	return cond
+}
+
+func (t *retryTransport) retryOn(cond string) bool {
+	for _, s := range t.on {
+		if s == cond {
+			return true
+		}
+	}
+	return false
+}
+
+// isConnectFailure returns true if the connection
+// to the upstream server could not be established.
+func isConnectFailure(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isRefusedStream returns true if the upstream HTTP/2 server refused
+// the stream before processing the request. The HTTP/2 transport of
+// the standard library does not export its error type.
+func isRefusedStream(err error) bool {
+	var streamErr http2.StreamError
+	if errors.As(err, &streamErr) {
+		return streamErr.Code == http2.ErrCodeRefusedStream
+	}
+	return strings.Contains(err.Error(), "REFUSED_STREAM")
+}
+
+// isGoAway returns true if the request failed since the upstream HTTP/2
+// server sent a GOAWAY frame. The HTTP/2 transport of the standard
+// library does not export its error types.
+func isGoAway(err error) bool {
+	var goAwayErr http2.GoAwayError
+	if errors.As(err, &goAwayErr) {
+		return true
+	}
+	return isGoAwayUnprocessed(err) || strings.Contains(err.Error(), "server sent GOAWAY")
+}
+
+// isGoAwayUnprocessed returns true if the stream of the request was
+// above the last stream of the GOAWAY frame of the upstream HTTP/2
+// server. The server has not processed the request. The error is also
+// returned when the transport cannot retry the request itself since
+// the request body has already been sent.
+func isGoAwayUnprocessed(err error) bool {
+	return strings.Contains(err.Error(), "received Server's graceful shutdown GOAWAY")
+}
+
+// isReset returns true if the upstream server closed
+// the connection before sending the response headers.
+func isReset(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// isIdempotent returns true for the idempotent methods of RFC 7231.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+	"golang.org/x/net/http2"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestRetryTransport(t *testing.T) {
+	errConnect := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	errRefused := http2.StreamError{StreamID: 1, Code: http2.ErrCodeRefusedStream}
+	errGoAway := http2.GoAwayError{LastStreamID: 3, ErrCode: http2.ErrCodeNo}
+	errGoAwayUnprocessed := errors.New("http2: Transport received Server's graceful shutdown GOAWAY")
+
+	// results are the errors or status codes of the upstream responses
+	tests := []struct {
+		desc    string
+		method  string
+		on      []string
+		results []interface{}
+		status  int
+		err     error
+		retries int64
+	}{
+		{"no failure", "GET", []string{"connect-failure"}, []interface{}{200}, 200, nil, 0},
+		{"connect-failure", "POST", []string{"connect-failure"}, []interface{}{errConnect, 200}, 200, nil, 1},
+		{"connect-failure not configured", "GET", []string{"503"}, []interface{}{errConnect, 200}, 0, errConnect, 0},
+		{"connect-failure attempts exceeded", "GET", []string{"connect-failure"}, []interface{}{errConnect, errConnect, errConnect, 200}, 0, errConnect, 2},
+		{"refused-stream", "POST", []string{"refused-stream"}, []interface{}{errRefused, 200}, 200, nil, 1},
+		{"goaway", "GET", []string{"goaway"}, []interface{}{errGoAway, 200}, 200, nil, 1},
+		{"goaway not idempotent", "POST", []string{"goaway"}, []interface{}{errGoAway, 200}, 0, errGoAway, 0},
+		{"goaway unprocessed", "POST", []string{"goaway"}, []interface{}{errGoAwayUnprocessed, 200}, 200, nil, 1},
+		{"goaway not configured", "GET", []string{"reset"}, []interface{}{errGoAway, 200}, 0, errGoAway, 0},
+		{"reset", "GET", []string{"reset"}, []interface{}{io.EOF, 200}, 200, nil, 1},
+		{"reset not idempotent", "POST", []string{"reset"}, []interface{}{io.EOF, 200}, 0, io.EOF, 0},
+		{"503", "GET", []string{"connect-failure", "503"}, []interface{}{503, 200}, 200, nil, 1},
+		{"503 not configured", "GET", []string{"connect-failure", "502"}, []interface{}{503, 200}, 503, nil, 0},
+		{"503 not idempotent", "POST", []string{"503"}, []interface{}{503, 200}, 503, nil, 0},
+		{"503 attempts exceeded", "PUT", []string{"503"}, []interface{}{503, 503, 503}, 503, nil, 2},
+		{"unexpected", "GET", []string{"unexpected"}, []interface{}{202, 200}, 200, nil, 1},
+		{"unexpected not configured", "GET", []string{"503"}, []interface{}{202, 200}, 202, nil, 0},
+		{"unexpected not idempotent", "POST", []string{"unexpected"}, []interface{}{202, 200}, 202, nil, 0},
+	}
+
+	// only 200 responses are expected
+	expect := &route.Expect{MinStatus: 200, MaxStatus: 200}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var n int
+			upstream := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				res := tt.results[n]
+				n++
+				body := ""
+				if r.Body != nil {
+					b, _ := ioutil.ReadAll(r.Body)
+					body = string(b)
+				}
+				if body != "foo" {
+					t.Fatalf("%d: got body %q want %q", n, body, "foo")
+				}
+				if err, ok := res.(error); ok {
+					return nil, err
+				}
+				rec := httptest.NewRecorder()
+				rec.WriteHeader(res.(int))
+				return rec.Result(), nil
+			})
+
+			hits := &testCounter{}
+			tr := &retryTransport{rt: upstream, on: tt.on, attempts: 2, maxBody: 1024, expect: expect, hits: hits}
+			req := httptest.NewRequest(tt.method, "http://example.com/", strings.NewReader("foo"))
+			resp, err := tr.RoundTrip(req)
+			if got, want := err, tt.err; got != want {
+				t.Fatalf("got error %v want %v", got, want)
+			}
+			if resp != nil {
+				if got, want := resp.StatusCode, tt.status; got != want {
+					t.Fatalf("got status %d want %d", got, want)
+				}
+			}
+			if got, want := atomic.LoadInt64(&hits.n), tt.retries; got != want {
+				t.Fatalf("got %d retries want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestRetryTransportMaxBody(t *testing.T) {
+	errConnect := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		desc     string
+		body     string
+		chunked  bool
+		attempts int
+	}{
+		{"small body", "foo", false, 2},
+		{"body at limit", "fooo", false, 2},
+		{"large body", "foobar", false, 1},
+		{"large chunked body", "foobar", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var attempts int
+			upstream := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				attempts++
+				b, _ := ioutil.ReadAll(r.Body)
+				if got, want := string(b), tt.body; got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+				return nil, errConnect
+			})
+
+			tr := &retryTransport{rt: upstream, on: []string{"connect-failure"}, attempts: 1, maxBody: 4}
+			req := httptest.NewRequest("POST", "http://example.com/", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			if _, err := tr.RoundTrip(req); err != errConnect {
+				t.Fatalf("got error %v want %v", err, errConnect)
+			}
+			if got, want := attempts, tt.attempts; got != want {
+				t.Fatalf("got %d attempts want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestRetryTransportNextTarget(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`
+	route add svc / http://a.com/
+	route add svc / http://b.com/ opts "host=dst"
+	route add other / http://c.com/
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := tbl[""][0].Targets
+
+	var hosts []string
+	upstream := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host+" "+r.Host)
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	})
+
+	tr := &retryTransport{rt: upstream, on: []string{"connect-failure"}, attempts: 2, target: targets[0]}
+	req := httptest.NewRequest("GET", "http://a.com/", nil)
+	req.Host = "example.com"
+	tr.RoundTrip(req)
+
+	// the second retry goes to the first target again since the
+	// target of the other service is not a candidate
+	want := []string{"a.com example.com", "b.com b.com", "a.com example.com"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("got %v want %v", hosts, want)
+	}
+}
+
+func TestRetryConditions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	req := httptest.NewRequest("GET", server.URL, nil)
+	req.RequestURI = ""
+	_, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil || !isConnectFailure(err) {
+		t.Fatalf("got %v want connect failure", err)
+	}
+	if !isRefusedStream(errors.New("http2: stream error: stream ID 3; REFUSED_STREAM")) {
+		t.Fatal("want refused stream")
+	}
+
+	// errors of the HTTP/2 transport of the standard library
+	goAway := errors.New(`http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=NO_ERROR, debug=""`)
+	if !isGoAway(goAway) || isGoAwayUnprocessed(goAway) {
+		t.Fatal("want processed GOAWAY")
+	}
+	goAway = errors.New("http2: Transport: cannot retry err [http2: Transport received Server's graceful shutdown GOAWAY] after Request.Body was written; define Request.GetBody to avoid this error")
+	if !isGoAway(goAway) || !isGoAwayUnprocessed(goAway) {
+		t.Fatal("want unprocessed GOAWAY")
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// serverTiming measures the time the upstream server needs to send
+// the response headers and the time spent in the proxy for the
+// Server-Timing header of the response.
+type serverTiming struct {
+	// start is the time the proxy received the request.
+	start time.Time
+
+	// backend is the time from sending the upstream request
+	// until the response headers have been received.
+	backend time.Duration
+}
+
+// header returns the value of the Server-Timing header. The proxy time
+// is the time since the request was received minus the backend time.
+// The durations are in milliseconds.
+func (s *serverTiming) header() string {
+	fabio := time.Since(s.start) - s.backend
+	if fabio < 0 {
+		fabio = 0
+	}
+	return "backend;dur=" + durMillis(s.backend) + ", fabio;dur=" + durMillis(fabio)
+}
+
+func durMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// serverTimingEnabled returns true if the Server-Timing header is added
+// to the responses of the target. The 'servertiming' option of the
+// target overrides the global setting.
+func serverTimingEnabled(global bool, t *route.Target) bool {
+	switch t.ServerTiming {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		return global
+	}
+}
+
+// timingTransport records the time until the response headers of the
+// upstream server have been received. Retries and fallbacks are part of
+// the backend time.
+type timingTransport struct {
+	rt     http.RoundTripper
+	timing *serverTiming
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.rt.RoundTrip(req)
+	t.timing.backend += time.Since(start)
+	return resp, err
+}
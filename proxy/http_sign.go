@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// signRequest adds an HMAC-SHA256 signature and the date of the request
+// to the upstream request so that the upstream server can verify that
+// the request was sent by fabio. The signature is computed over the
+// configured fields of the upstream request separated by newlines. The
+// date is the current time in the HTTP date format.
+func signRequest(r *http.Request, targetURL *url.URL, secret []byte, cfg config.Proxy, now time.Time) {
+	date := now.UTC().Format(http.TimeFormat)
+	r.Header.Set(cfg.SignDateHeader, date)
+
+	fields := make([]string, len(cfg.SignFields))
+	for i, f := range cfg.SignFields {
+		switch f {
+		case "method":
+			fields[i] = r.Method
+		case "host":
+			fields[i] = r.Host
+		case "path":
+			fields[i] = targetURL.EscapedPath()
+		case "query":
+			fields[i] = targetURL.RawQuery
+		case "date":
+			fields[i] = date
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	r.Header.Set(cfg.SignHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// errSlowBody is returned when reading a request body which
+// was received slower than the minimum rate.
+var errSlowBody = errors.New("request body too slow")
+
+type connKey struct{}
+
+// connContext stores the client connection in the context of the
+// requests so that it can be closed by the handler. It is used
+// as the ConnContext of the http servers.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// minRateBody enforces a minimum rate for reading a request body.
+// The body is checked in intervals of the given duration and at
+// least min bytes must be received in every interval. Only the time
+// spent waiting for the client counts so that the client is not
+// penalized when the upstream server reads the body slowly. Large
+// uploads with a steady rate are not affected while clients which
+// trickle the body are dropped by calling abort.
+type minRateBody struct {
+	rc       io.ReadCloser
+	min      int64
+	interval time.Duration
+	abort    func()
+	dropped  metrics.Counter
+
+	mu      sync.Mutex
+	aborted bool
+	gen     int           // generation of the interval timer
+	start   time.Time     // start of the current read
+	waited  time.Duration // time spent reading in the current interval
+	n       int64         // bytes received in the current interval
+}
+
+// newMinRateBody returns a body which closes the client connection
+// of the request if the body is received slower than rate bytes per
+// second in every interval. For HTTP/2 requests only the body is
+// closed which resets the stream of the request since the other
+// streams on the connection must not be affected.
+func newMinRateBody(r *http.Request, interval time.Duration, rate int64, dropped metrics.Counter) *minRateBody {
+	min := int64(interval.Seconds() * float64(rate))
+	if min < 1 {
+		min = 1
+	}
+	abort := func() {
+		log.Printf("[WARN] Dropping connection from %s since the request body for %s is too slow", r.RemoteAddr, r.URL)
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			c.Close()
+		}
+	}
+	if r.ProtoMajor == 2 {
+		body := r.Body
+		abort = func() {
+			log.Printf("[WARN] Dropping request from %s since the request body for %s is too slow", r.RemoteAddr, r.URL)
+			body.Close()
+		}
+	}
+	return &minRateBody{
+		rc:       r.Body,
+		min:      min,
+		interval: interval,
+		dropped:  dropped,
+		abort:    abort,
+	}
+}
+
+func (b *minRateBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.aborted {
+		b.mu.Unlock()
+		return 0, errSlowBody
+	}
+	b.start = time.Now()
+	b.arm(b.interval - b.waited)
+	b.mu.Unlock()
+
+	n, err := b.rc.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gen++ // stop the timer
+	if b.aborted {
+		return 0, errSlowBody
+	}
+	b.n += int64(n)
+	b.waited += time.Since(b.start)
+	if b.waited >= b.interval && err == nil {
+		if b.n < b.min {
+			b.drop()
+			return 0, errSlowBody
+		}
+		b.waited, b.n = 0, 0
+	}
+	return n, err
+}
+
+func (b *minRateBody) Close() error {
+	b.mu.Lock()
+	b.gen++
+	b.mu.Unlock()
+	return b.rc.Close()
+}
+
+// arm starts the timer which checks the rate when the interval
+// ends while waiting for the client. b.mu must be held.
+func (b *minRateBody) arm(d time.Duration) {
+	b.gen++
+	gen := b.gen
+	time.AfterFunc(d, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if gen != b.gen || b.aborted {
+			return
+		}
+		if b.n < b.min {
+			b.drop()
+			return
+		}
+		b.start, b.waited, b.n = time.Now(), 0, 0
+		b.arm(b.interval)
+	})
+}
+
+// drop aborts the request. b.mu must be held.
+func (b *minRateBody) drop() {
+	b.aborted = true
+	if b.dropped != nil {
+		b.dropped.Inc(1)
+	}
+	b.abort()
+}
@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+const (
+	// tapBatchSize is the maximum number of events which are
+	// sent to the sink at once.
+	tapBatchSize = 100
+
+	// tapFlushInterval is the maximum time an event waits
+	// for the batch to be sent.
+	tapFlushInterval = time.Second
+)
+
+// TapEvent contains the metadata of a request and its response which is
+// sent to the tap sink.
+type TapEvent struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Query     string    `json:"query,omitempty"`
+	Proto     string    `json:"proto"`
+
+	// Header is the header of the upstream request.
+	Header http.Header `json:"header"`
+
+	// RequestBody contains the first bytes of the request body up to
+	// the configured maximum. RequestSize is the size of the body
+	// which has been sent upstream.
+	RequestBody []byte `json:"request_body,omitempty"`
+	RequestSize int64  `json:"request_size"`
+
+	Service string `json:"service"`
+	Route   string `json:"route"`
+	Target  string `json:"target"`
+
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseSize   int64       `json:"response_size"`
+	DurationMS     float64     `json:"duration_ms"`
+
+	body *captureBody
+}
+
+// TapSink receives the events of the tap.
+type TapSink interface {
+	Send(events []*TapEvent) error
+}
+
+// NewTapSink returns the sink for the URL which is either an http or
+// https URL to which the events are posted or a kafka://host:port/topic
+// URL with a comma separated list of brokers.
+func NewTapSink(sink string, timeout time.Duration) (TapSink, error) {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &HTTPTapSink{URL: sink, Client: &http.Client{Timeout: timeout}}, nil
+	case "kafka":
+		topic := strings.Trim(u.Path, "/")
+		if u.Host == "" || topic == "" {
+			return nil, fmt.Errorf("kafka tap sink must be kafka://host:port[,host:port]/topic. Got: %s", sink)
+		}
+		return &KafkaTapSink{Brokers: strings.Split(u.Host, ","), Topic: topic, Timeout: timeout}, nil
+	default:
+		return nil, fmt.Errorf("unsupported tap sink %q", sink)
+	}
+}
+
+// Tap sends the metadata of a sample of the requests of the routes with
+// the 'tap' option to a sink, e.g. for real-time analytics. Unlike
+// mirroring the requests are not replayed. The events are buffered and
+// sent in batches in the background so that the requests are not
+// delayed. Events are dropped when the buffer is full or the sink
+// cannot be reached.
+//
+// The events contain the request headers and the request bodies may be
+// included. They may contain passwords, tokens and personal data and
+// the sink should be protected accordingly.
+type Tap struct {
+	// Sink receives the events.
+	Sink TapSink
+
+	// MaxBody is the number of bytes of the request body which are
+	// included in the event. With zero the body is not included.
+	MaxBody int
+
+	// Dropped counts the events which were dropped since the buffer
+	// was full or the sink returned an error.
+	Dropped metrics.Counter
+
+	events chan *TapEvent
+	quit   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewTap returns a tap which buffers up to size events.
+func NewTap(sink TapSink, size, maxBody int) *Tap {
+	return &Tap{
+		Sink:    sink,
+		MaxBody: maxBody,
+		events:  make(chan *TapEvent, size),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run sends the events to the sink until Close is called.
+func (tp *Tap) Run() {
+	defer close(tp.done)
+	ticker := time.NewTicker(tapFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*TapEvent
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := tp.Sink.Send(batch); err != nil {
+			log.Printf("[WARN] tap: Cannot send %d events. %s", len(batch), err)
+			tp.drop(len(batch))
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case e := <-tp.events:
+			if batch = append(batch, e); len(batch) >= tapBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-tp.quit:
+			for {
+				select {
+				case e := <-tp.events:
+					batch = append(batch, e)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close sends the buffered events and stops Run.
+func (tp *Tap) Close() {
+	tp.once.Do(func() { close(tp.quit) })
+	<-tp.done
+}
+
+// start returns the event for the request to target t or nil if the
+// request is not sampled. The request body is wrapped to capture the
+// first MaxBody bytes.
+func (tp *Tap) start(r *http.Request, t *route.Target, requestID string, now time.Time) *TapEvent {
+	if tp == nil || t.Tap <= 0 || (t.Tap < 1 && rand.Float64() >= t.Tap) {
+		return nil
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	e := &TapEvent{
+		Time:      now,
+		RequestID: requestID,
+		ClientIP:  ip,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Proto:     r.Proto,
+		Header:    r.Header.Clone(),
+		Service:   t.Service,
+		Route:     t.Prefix,
+		Target:    t.URL.String(),
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		e.body = &captureBody{rc: r.Body, max: tp.MaxBody, done: func(*captureBody) {}}
+		r.Body = e.body
+	}
+	return e
+}
+
+// finish adds the response to the event and queues it for the sink.
+// The event is dropped if the buffer is full.
+func (tp *Tap) finish(e *TapEvent, rw *responseWriter, dur time.Duration) {
+	e.Status = rw.code
+	e.ResponseHeader = rw.Header().Clone()
+	e.ResponseSize = int64(rw.size)
+	e.DurationMS = float64(dur) / float64(time.Millisecond)
+	if e.body != nil {
+		e.RequestBody, e.RequestSize = e.body.captured()
+	}
+	select {
+	case tp.events <- e:
+	default:
+		tp.drop(1)
+	}
+}
+
+func (tp *Tap) drop(n int) {
+	if tp.Dropped != nil {
+		tp.Dropped.Inc(int64(n))
+	}
+}
+
+// HTTPTapSink posts the events as newline delimited JSON to the URL.
+type HTTPTapSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Send posts the events in one request.
+func (s *HTTPTapSink) Send(events []*TapEvent) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	resp, err := s.Client.Post(s.URL, "application/x-ndjson", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// KafkaTapSink sends the events as JSON messages to a Kafka topic. The
+// connection to the brokers is established with the first batch and
+// again after an error.
+type KafkaTapSink struct {
+	Brokers []string
+	Topic   string
+	Timeout time.Duration
+
+	producer sarama.SyncProducer
+}
+
+// Send sends one message per event.
+func (s *KafkaTapSink) Send(events []*TapEvent) error {
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{Topic: s.Topic, Value: sarama.ByteEncoder(b)})
+	}
+	if s.producer == nil {
+		cfg := sarama.NewConfig()
+		cfg.ClientID = "fabio"
+		cfg.Net.DialTimeout = s.Timeout
+		cfg.Net.ReadTimeout = s.Timeout
+		cfg.Net.WriteTimeout = s.Timeout
+		cfg.Producer.Return.Successes = true
+		p, err := sarama.NewSyncProducer(s.Brokers, cfg)
+		if err != nil {
+			return err
+		}
+		s.producer = p
+	}
+	if err := s.producer.SendMessages(msgs); err != nil {
+		s.producer.Close()
+		s.producer = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+type testTapSink struct{ events []*TapEvent }
+
+func (s *testTapSink) Send(events []*TapEvent) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestProxyTap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer server.Close()
+
+	sink := &testTapSink{}
+	tap := NewTap(sink, 10, 4)
+	go tap.Run()
+
+	tapped := &route.Target{Service: "svc", Prefix: "/tapped", URL: mustParse(server.URL), Tap: 1}
+	plain := &route.Target{Service: "svc", Prefix: "/", URL: mustParse(server.URL)}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Tap:       tap,
+		Lookup: func(r *http.Request) *route.Target {
+			if strings.HasPrefix(r.URL.Path, "/tapped") {
+				return tapped
+			}
+			return plain
+		},
+	})
+	defer proxy.Close()
+
+	for _, path := range []string{"/tapped/a?x=1", "/b"} {
+		resp, err := http.Post(proxy.URL+path, "text/plain", strings.NewReader("hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	tap.Close()
+
+	if got, want := len(sink.events), 1; got != want {
+		t.Fatalf("got %d events want %d", got, want)
+	}
+	e := sink.events[0]
+	if got, want := e.Method+" "+e.Path+"?"+e.Query, "POST /tapped/a?x=1"; got != want {
+		t.Fatalf("got request %q want %q", got, want)
+	}
+	if got, want := e.Target, server.URL; got != want {
+		t.Fatalf("got target %q want %q", got, want)
+	}
+	if got, want := e.Status, http.StatusCreated; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(e.RequestBody), "hell"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	if got, want := e.RequestSize, int64(len("hello world")); got != want {
+		t.Fatalf("got request size %d want %d", got, want)
+	}
+	if got, want := e.ResponseSize, int64(len("created")); got != want {
+		t.Fatalf("got response size %d want %d", got, want)
+	}
+	if got, want := e.ResponseHeader.Get("X-Upstream"), "yes"; got != want {
+		t.Fatalf("got response header %q want %q", got, want)
+	}
+	if got, want := e.Header.Get("Content-Type"), "text/plain"; got != want {
+		t.Fatalf("got request header %q want %q", got, want)
+	}
+}
+
+func TestTapDropOnOverflow(t *testing.T) {
+	dropped := &testCounter{}
+	tap := NewTap(&testTapSink{}, 1, 0)
+	tap.Dropped = dropped
+
+	r := httptest.NewRequest("GET", "/", nil)
+	tg := &route.Target{URL: mustParse("http://foo.com/"), Tap: 1}
+	rw := &responseWriter{w: httptest.NewRecorder(), code: 200}
+	for i := 0; i < 3; i++ {
+		tap.finish(tap.start(r, tg, "", time.Now()), rw, 0)
+	}
+	if got, want := dropped.n, int64(2); got != want {
+		t.Fatalf("got %d dropped events want %d", got, want)
+	}
+}
+
+func TestHTTPTapSink(t *testing.T) {
+	var ct string
+	var events []*TapEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct = r.Header.Get("Content-Type")
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var e TapEvent
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Error(err)
+			}
+			events = append(events, &e)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewTapSink(server.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send([]*TapEvent{{Path: "/a"}, {Path: "/b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ct, "application/x-ndjson"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("got %d events want %d", got, want)
+	}
+	if got, want := events[1].Path, "/b"; got != want {
+		t.Fatalf("got path %q want %q", got, want)
+	}
+}
+
+func TestNewTapSink(t *testing.T) {
+	sink, err := NewTapSink("kafka://k1:9092,k2:9092/requests", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := sink.(*KafkaTapSink)
+	if got, want := strings.Join(k.Brokers, ","), "k1:9092,k2:9092"; got != want {
+		t.Fatalf("got brokers %q want %q", got, want)
+	}
+	if got, want := k.Topic, "requests"; got != want {
+		t.Fatalf("got topic %q want %q", got, want)
+	}
+	for _, s := range []string{"kafka://k1:9092", "udp://host:514"} {
+		if _, err := NewTapSink(s, 0); err == nil {
+			t.Fatalf("%s: got no error", s)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"container/list"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// Transports are the connection pools for upstream requests.
+type Transports struct {
+	Transport             http.RoundTripper
+	InsecureTransport     http.RoundTripper
+	AutoTransport         http.RoundTripper
+	InsecureAutoTransport http.RoundTripper
+}
+
+// TenantTransports maintains separate connection pools for the
+// tenants of the requests so that the slow requests of one tenant
+// do not block the connections of the others. The tenant is the
+// value of the Header of the request. Since the header is set by
+// the client only the tenants in Allow get separate pools. At most
+// Max pools are kept and the least recently used pool is closed
+// when a pool for a new tenant is needed. Requests in flight on a
+// closed pool are not affected.
+type TenantTransports struct {
+	// Header is the name of the request header with the tenant key.
+	Header string
+
+	// Allow contains the tenants which get separate pools.
+	Allow map[string]bool
+
+	// Max is the maximum number of tenant pools.
+	Max int
+
+	// New creates the connection pools for a new tenant.
+	New func() Transports
+
+	// Evictions counts the closed pools.
+	Evictions metrics.Counter
+
+	mu     sync.Mutex
+	lru    *list.List // of *tenantTransports, most recently used first
+	byName map[string]*list.Element
+}
+
+type tenantTransports struct {
+	tenant string
+	Transports
+}
+
+// Get returns the connection pools for the tenant. The second
+// return value is false if the tenant is empty or not allowed.
+func (tt *TenantTransports) Get(tenant string) (Transports, bool) {
+	if tenant == "" || !tt.Allow[tenant] {
+		return Transports{}, false
+	}
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if tt.byName == nil {
+		tt.lru, tt.byName = list.New(), map[string]*list.Element{}
+	}
+
+	if e := tt.byName[tenant]; e != nil {
+		tt.lru.MoveToFront(e)
+		return e.Value.(*tenantTransports).Transports, true
+	}
+
+	for tt.lru.Len() >= tt.Max && tt.lru.Len() > 0 {
+		e := tt.lru.Back()
+		v := tt.lru.Remove(e).(*tenantTransports)
+		delete(tt.byName, v.tenant)
+		v.closeIdleConnections()
+		if tt.Evictions != nil {
+			tt.Evictions.Inc(1)
+		}
+		log.Printf("[DEBUG] Closed upstream connection pool of tenant %q", v.tenant)
+	}
+
+	v := &tenantTransports{tenant: tenant, Transports: tt.New()}
+	tt.byName[tenant] = tt.lru.PushFront(v)
+	return v.Transports, true
+}
+
+// Len returns the number of tenant pools.
+func (tt *TenantTransports) Len() int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.lru == nil {
+		return 0
+	}
+	return tt.lru.Len()
+}
+
+// all returns the connection pools of all tenants.
+func (tt *TenantTransports) all() []Transports {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	var tps []Transports
+	if tt.lru == nil {
+		return tps
+	}
+	for e := tt.lru.Front(); e != nil; e = e.Next() {
+		tps = append(tps, e.Value.(*tenantTransports).Transports)
+	}
+	return tps
+}
+
+func (t Transports) closeIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	for _, rt := range []http.RoundTripper{t.Transport, t.InsecureTransport, t.AutoTransport, t.InsecureAutoTransport} {
+		if c, ok := rt.(closeIdler); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+type idleTransport struct {
+	http.RoundTripper
+	closed int
+}
+
+func (t *idleTransport) CloseIdleConnections() { t.closed++ }
+
+func TestTenantTransports(t *testing.T) {
+	var created []*idleTransport
+	evictions := &testCounter{}
+	tt := &TenantTransports{
+		Allow: map[string]bool{"a": true, "b": true, "c": true},
+		Max:   2,
+		New: func() Transports {
+			tr := &idleTransport{}
+			created = append(created, tr)
+			return Transports{Transport: tr}
+		},
+		Evictions: evictions,
+	}
+
+	get := func(tenant string) *idleTransport {
+		tp, ok := tt.Get(tenant)
+		if !ok {
+			t.Fatalf("got no transports for tenant %q", tenant)
+		}
+		return tp.Transport.(*idleTransport)
+	}
+
+	if _, ok := tt.Get(""); ok {
+		t.Fatal("got transports for empty tenant")
+	}
+	if _, ok := tt.Get("x"); ok {
+		t.Fatal("got transports for tenant which is not allowed")
+	}
+
+	a, b := get("a"), get("b")
+	if get("a") != a {
+		t.Fatal("got new transports for known tenant")
+	}
+
+	// b is the least recently used pool
+	c := get("c")
+	if got, want := b.closed, 1; got != want {
+		t.Fatalf("got %d closes of evicted pool want %d", got, want)
+	}
+	if a.closed != 0 || c.closed != 0 {
+		t.Fatal("closed pool which is in use")
+	}
+	if got, want := atomic.LoadInt64(&evictions.n), int64(1); got != want {
+		t.Fatalf("got %d evictions want %d", got, want)
+	}
+	if got, want := tt.Len(), 2; got != want {
+		t.Fatalf("got %d pools want %d", got, want)
+	}
+
+	// b gets a new pool
+	if get("b") == b {
+		t.Fatal("got evicted pool")
+	}
+	if got, want := len(created), 4; got != want {
+		t.Fatalf("created %d pools want %d", got, want)
+	}
+}
+
+func TestProxyTenantTransports(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var used string
+	transport := func(name string) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			used = name
+			return http.DefaultTransport.RoundTrip(r)
+		})
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: transport("shared"),
+		Tenants: &TenantTransports{
+			Header: "X-Tenant",
+			Allow:  map[string]bool{"foo": true},
+			Max:    10,
+			New:    func() Transports { return Transports{Transport: transport("tenant")} },
+		},
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	for _, tenant := range []string{"", "foo", "bar"} {
+		req, _ := http.NewRequest("GET", proxy.URL, nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		resp, _ := mustDo(req)
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		want := "shared"
+		if tenant == "foo" {
+			want = "tenant"
+		}
+		if used != want {
+			t.Fatalf("%q: got %s transport want %s", tenant, used, want)
+		}
+	}
+}
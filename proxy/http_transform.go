@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fabiolb/fabio/proxy/transform"
+)
+
+// transformError is returned when the body of the request or the
+// response could not be transformed. The reverse proxy responds
+// with the status code.
+type transformError struct {
+	status int
+	err    error
+}
+
+func (e *transformError) Error() string {
+	return e.err.Error()
+}
+
+// transformTransport rewrites the request and the response bodies
+// with the transformer of the 'transform' option of a route. The
+// bodies are buffered for the transformation. Only the bodies of 2xx
+// responses are transformed and error responses are passed through.
+// Requests with a body larger than maxBody bytes are rejected with
+// 413 and responses with 502 before or after the decompression.
+//
+// Compressed responses are decompressed before the transformation
+// and compressed again with gzip if the client accepts it.
+type transformTransport struct {
+	rt      http.RoundTripper
+	tf      transform.Transformer
+	maxBody int
+}
+
+func (t *transformTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok, err := readBody(req, t.maxBody)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		req.Body.Close()
+		return nil, &transformError{http.StatusRequestEntityTooLarge, fmt.Errorf("cannot transform request for %s. Body is larger than %d bytes", req.URL, t.maxBody)}
+	}
+	if body != nil {
+		b, ctype, err := t.tf.TransformRequest(body, req.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, &transformError{http.StatusBadRequest, fmt.Errorf("cannot transform request for %s. %s", req.URL, err)}
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+		setContentType(req.Header, ctype)
+		req.Header.Del("Content-Length")
+	}
+
+	// the transport decompresses the response if it
+	// has requested the compression itself.
+	gzipOK := acceptsEncoding(req.Header.Get("Accept-Encoding"), "gzip")
+	req.Header.Del("Accept-Encoding")
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, err
+	}
+
+	b, err := readLimited(resp.Body, t.maxBody)
+	resp.Body.Close()
+	if err == errBodyTooLarge {
+		return nil, &transformError{http.StatusBadGateway, fmt.Errorf("cannot transform response from %s. Body is larger than %d bytes", req.URL, t.maxBody)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the upstream server may compress the response
+	// although the compression has not been requested.
+	compressed := resp.Uncompressed
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		b, err = decompress(b, enc, t.maxBody)
+		if err != nil {
+			return nil, &transformError{http.StatusBadGateway, fmt.Errorf("cannot decompress response from %s. %s", req.URL, err)}
+		}
+		resp.Header.Del("Content-Encoding")
+		compressed = true
+	}
+	if len(b) == 0 {
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+		resp.Header.Del("Content-Length")
+		return resp, nil
+	}
+
+	b, ctype, err := t.tf.TransformResponse(b, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, &transformError{http.StatusBadGateway, fmt.Errorf("cannot transform response from %s. %s", req.URL, err)}
+	}
+	if compressed && gzipOK {
+		if b, err = compressGzip(b); err != nil {
+			return nil, err
+		}
+		resp.Header.Set("Content-Encoding", "gzip")
+		resp.Header.Add("Vary", "Accept-Encoding")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	setContentType(resp.Header, ctype)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	resp.TransferEncoding = nil
+	resp.Uncompressed = false
+	return resp, nil
+}
+
+func setContentType(h http.Header, ctype string) {
+	if ctype == "" {
+		h.Del("Content-Type")
+		return
+	}
+	h.Set("Content-Type", ctype)
+}
+
+// errBodyTooLarge is returned by readLimited for bodies which
+// exceed the limit.
+var errBodyTooLarge = errors.New("body too large")
+
+// readLimited reads r up to max bytes and returns errBodyTooLarge
+// if r has more data.
+func readLimited(r io.Reader, max int) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > max {
+		return nil, errBodyTooLarge
+	}
+	return b, nil
+}
+
+// decompress returns the body which has been compressed with the
+// content encoding enc. Only gzip and deflate are supported. Bodies
+// which are larger than max bytes after the decompression are
+// rejected.
+func decompress(b []byte, enc string, max int) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "identity":
+		return b, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, max)
+}
+
+func compressGzip(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// acceptsEncoding returns true if the Accept-Encoding header
+// accepts the content encoding enc.
+func acceptsEncoding(header, enc string) bool {
+	for _, v := range strings.Split(header, ",") {
+		name, params := v, ""
+		if i := strings.Index(v, ";"); i >= 0 {
+			name, params = v[:i], v[i+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != enc && name != "*" {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			q, err := strconv.ParseFloat(params[len("q="):], 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fabiolb/fabio/proxy/tcp"
+	"github.com/fabiolb/fabio/route"
+)
+
+// upstreamProxyKey identifies the copy of a transport which
+// connects through an upstream proxy.
+type upstreamProxyKey struct {
+	tr    *http.Transport
+	proxy string
+}
+
+// upstreamProxyTransport returns a copy of the transport which tunnels
+// the connections through the HTTP forward proxy at u with CONNECT.
+// Plain HTTP requests are tunneled as well instead of being forwarded
+// by the upstream proxy since forwarded requests are sent to the host
+// of the Host header which fabio does not change by default. The copies
+// are cached so that they keep their connection pools. The copies for
+// upstream proxies which are no longer used by the routing table are
+// removed when a new copy is added. The user info of u is used for
+// proxy authentication.
+func (p *HTTPProxy) upstreamProxyTransport(tr http.RoundTripper, u *url.URL) http.RoundTripper {
+	htr, ok := tr.(*http.Transport)
+	if !ok {
+		log.Printf("[WARN] Cannot use upstream proxy %s with transport %T", u.Host, tr)
+		return tr
+	}
+
+	key := upstreamProxyKey{tr: htr, proxy: u.String()}
+	if v, ok := p.upstreamProxies.Load(key); ok {
+		return v.(*http.Transport)
+	}
+	ptr := htr.Clone()
+	ptr.Proxy = nil
+	ptr.DialContext = nil
+	ptr.Dial = func(network, addr string) (net.Conn, error) {
+		return tcp.DialConnect(u, addr, p.Config.DialTimeout)
+	}
+	v, loaded := p.upstreamProxies.LoadOrStore(key, ptr)
+	if !loaded {
+		p.removeUpstreamProxies(key)
+	}
+	return v.(*http.Transport)
+}
+
+// removeUpstreamProxies removes the copies of the transports for the
+// upstream proxies which are not used by a target of the active routing
+// table except for the copy with the key keep.
+func (p *HTTPProxy) removeUpstreamProxies(keep upstreamProxyKey) {
+	used := map[string]bool{}
+	for _, routes := range route.GetTable() {
+		for _, r := range routes {
+			for _, t := range r.Targets {
+				if u := t.UpstreamProxyURL(); u != nil {
+					used[u.String()] = true
+				}
+			}
+		}
+	}
+	p.upstreamProxies.Range(func(k, v interface{}) bool {
+		if key := k.(upstreamProxyKey); key != keep && !used[key.proxy] {
+			p.upstreamProxies.Delete(k)
+			v.(*http.Transport).CloseIdleConnections()
+		}
+		return true
+	})
+}
+
+// upstreamProxyDial returns a dial function for websocket connections
+// which tunnels the connection through the HTTP forward proxy at u.
+// The TLS handshake with the upstream server is performed through the
+// tunnel if tlscfg is not nil.
+func upstreamProxyDial(u *url.URL, tlscfg *tls.Config, timeout time.Duration) dialFunc {
+	dial := func(network, address string) (net.Conn, error) {
+		return tcp.DialConnect(u, address, timeout)
+	}
+	if tlscfg == nil {
+		return dial
+	}
+	return tlsDial(dial, tlscfg)
+}
+
+// tlsDial returns a dial function which performs the TLS handshake
+// with the upstream server on the connections of dial.
+func tlsDial(dial dialFunc, tlscfg *tls.Config) dialFunc {
+	return func(network, address string) (net.Conn, error) {
+		c, err := dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if tlscfg != nil {
+			cfg = tlscfg.Clone()
+		}
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				host = address
+			}
+			cfg.ServerName = host
+		}
+		tc := tls.Client(c, cfg)
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
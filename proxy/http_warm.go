@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// warmCheckInterval is the default interval for checking the warm
+// connections.
+const warmCheckInterval = 5 * time.Second
+
+// WarmDialer keeps a minimum number of established connections to
+// upstream hosts so that requests which need a new upstream connection
+// do not have to wait for the connection setup. The connections are
+// handed to the transports by Dial and replaced in the background.
+// Connections which were closed by the upstream host are detected and
+// replaced as well.
+type WarmDialer struct {
+	// Dialer establishes the upstream connections.
+	Dialer *net.Dialer
+
+	// MaxPerHost is the maximum number of warm connections per host.
+	// If MaxPerHost is zero the number is not limited.
+	MaxPerHost int
+
+	// Interval is the interval for checking the warm connections.
+	// If Interval is zero warmCheckInterval is used.
+	Interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*warmHost
+}
+
+// Update sets the minimum number of warm connections per host:port.
+// Hosts which are not in min are closed.
+func (d *WarmDialer) Update(min map[string]int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.hosts == nil {
+		d.hosts = map[string]*warmHost{}
+	}
+
+	for addr, h := range d.hosts {
+		if min[addr] <= 0 {
+			delete(d.hosts, addr)
+			close(h.quit)
+			log.Printf("[INFO] Closing warm connections to %s", addr)
+		}
+	}
+
+	for addr, n := range min {
+		if n <= 0 {
+			continue
+		}
+		if d.MaxPerHost > 0 && n > d.MaxPerHost {
+			log.Printf("[WARN] Limiting warm connections to %s from %d to %d", addr, n, d.MaxPerHost)
+			n = d.MaxPerHost
+		}
+		h := d.hosts[addr]
+		if h == nil {
+			h = &warmHost{addr: addr, quit: make(chan struct{}), wake: make(chan struct{}, 1)}
+			d.hosts[addr] = h
+			log.Printf("[INFO] Keeping %d warm connections to %s", n, addr)
+			go d.maintain(h)
+		}
+		h.setMin(n)
+	}
+}
+
+// Dial returns a warm connection to the address if there is one
+// and establishes a new connection otherwise.
+func (d *WarmDialer) Dial(network, addr string) (net.Conn, error) {
+	if network == "tcp" {
+		d.mu.Lock()
+		h := d.hosts[addr]
+		d.mu.Unlock()
+		if h != nil {
+			for {
+				c := h.take()
+				if c == nil {
+					break
+				}
+				if alive(c) {
+					return c, nil
+				}
+				c.Close()
+			}
+		}
+	}
+	return d.Dialer.Dial(network, addr)
+}
+
+// maintain keeps the minimum number of live connections to the host
+// until the host is removed.
+func (d *WarmDialer) maintain(h *warmHost) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = warmCheckInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		h.fill(d.Dialer.Dial)
+		select {
+		case <-h.quit:
+			h.closeAll()
+			return
+		case <-h.wake:
+		case <-t.C:
+			h.check()
+		}
+	}
+}
+
+// warmHost contains the warm connections to one upstream host.
+type warmHost struct {
+	addr string
+	quit chan struct{}
+	wake chan struct{}
+
+	mu    sync.Mutex
+	min   int
+	conns []net.Conn
+}
+
+func (h *warmHost) setMin(n int) {
+	h.mu.Lock()
+	h.min = n
+	h.mu.Unlock()
+	h.notify()
+}
+
+// notify wakes up the maintain loop.
+func (h *warmHost) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes a warm connection from the pool or returns nil
+// if there is none.
+func (h *warmHost) take() net.Conn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.conns) == 0 {
+		return nil
+	}
+	c := h.conns[len(h.conns)-1]
+	h.conns = h.conns[:len(h.conns)-1]
+	h.notify()
+	return c
+}
+
+// fill establishes connections until the pool has the minimum
+// number of connections. It stops on the first error and retries
+// on the next check.
+func (h *warmHost) fill(dial func(network, addr string) (net.Conn, error)) {
+	for {
+		h.mu.Lock()
+		n := h.min - len(h.conns)
+		h.mu.Unlock()
+		if n <= 0 {
+			return
+		}
+
+		c, err := dial("tcp", h.addr)
+		if err != nil {
+			log.Printf("[WARN] Cannot establish warm connection to %s. %s", h.addr, err)
+			return
+		}
+
+		select {
+		case <-h.quit:
+			c.Close()
+			return
+		default:
+		}
+
+		h.mu.Lock()
+		if len(h.conns) >= h.min {
+			h.mu.Unlock()
+			c.Close()
+			return
+		}
+		h.conns = append(h.conns, c)
+		h.mu.Unlock()
+	}
+}
+
+// check closes the connections which were closed by the upstream
+// host and the connections over the minimum.
+func (h *warmHost) check() {
+	h.mu.Lock()
+	conns := h.conns
+	h.conns = nil
+	h.mu.Unlock()
+
+	var live []net.Conn
+	for _, c := range conns {
+		if alive(c) {
+			live = append(live, c)
+		} else {
+			c.Close()
+		}
+	}
+
+	h.mu.Lock()
+	h.conns = append(h.conns, live...)
+	for len(h.conns) > h.min {
+		h.conns[len(h.conns)-1].Close()
+		h.conns = h.conns[:len(h.conns)-1]
+	}
+	h.mu.Unlock()
+}
+
+func (h *warmHost) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.conns {
+		c.Close()
+	}
+	h.conns = nil
+}
+
+// alive returns whether an idle connection is still usable. The
+// connection is not usable if the peer has closed it or has sent
+// data since nothing was requested yet.
+func alive(c net.Conn) bool {
+	if err := c.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := c.Read(b[:])
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		return false
+	}
+	return c.SetReadDeadline(time.Time{}) == nil
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// warmServer accepts connections and records them.
+type warmServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newWarmServer(t *testing.T) *warmServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &warmServer{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *warmServer) accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *warmServer) conn(i int) net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[i]
+}
+
+func (s *warmServer) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+func waitFor(t *testing.T, desc string, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if f() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", desc)
+}
+
+func TestWarmDialer(t *testing.T) {
+	srv := newWarmServer(t)
+	defer srv.Close()
+	addr := srv.ln.Addr().String()
+
+	d := &WarmDialer{Dialer: &net.Dialer{}, MaxPerHost: 3, Interval: 10 * time.Millisecond}
+
+	// the minimum is limited to MaxPerHost
+	d.Update(map[string]int{addr: 5})
+	waitFor(t, "3 warm connections", func() bool { return srv.accepted() == 3 })
+	time.Sleep(50 * time.Millisecond)
+	if got, want := srv.accepted(), 3; got != want {
+		t.Fatalf("got %d connections want %d", got, want)
+	}
+
+	// a warm connection is used and replaced
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, "replaced connection", func() bool { return srv.accepted() == 4 })
+
+	// connections closed by the upstream host are replaced
+	srv.conn(3).Close()
+	waitFor(t, "replaced closed connection", func() bool { return srv.accepted() == 5 })
+
+	// connections are closed when the host is removed
+	// except for the one which was handed out by Dial.
+	d.Update(nil)
+	closed := 0
+	for _, i := range []int{0, 1, 2, 4} {
+		srv.conn(i).SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := srv.conn(i).Read(make([]byte, 1)); err == io.EOF {
+			closed++
+		}
+	}
+	if got, want := closed, 3; got != want {
+		t.Fatalf("got %d closed connections want %d", got, want)
+	}
+
+	// without warm connections Dial establishes a new connection
+	c2, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	waitFor(t, "new connection", func() bool { return srv.accepted() == 6 })
+}
@@ -4,10 +4,9 @@ import (
 	"crypto/tls"
 	"fmt"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
 	"net"
 	"time"
-
-	proxyproto "github.com/armon/go-proxyproto"
 )
 
 func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
@@ -27,20 +26,45 @@ func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
 
 	// enable PROXY protocol support
 	if l.ProxyProto {
-		ln = &proxyproto.Listener{
-			Listener:           ln,
-			ProxyHeaderTimeout: l.ProxyHeaderTimeout,
-		}
+		ln = newProxyProtoListener(ln, l.ProxyHeaderTimeout, l.ProxyProtoTrusted)
 	}
 
 	// enable TLS
 	if cfg != nil {
-		ln = tls.NewListener(ln, cfg)
+		ln = tlsListener(ln, l, cfg)
 	}
 
 	return &tcpListener{ln, addr, cfg}, nil
 }
 
+// HandshakeLimit bounds the number of concurrent TLS handshakes of
+// all listeners. The number is not limited if HandshakeLimit is nil.
+// It is set by the caller before the listeners are started.
+var HandshakeLimit *HandshakeLimiter
+
+// HandshakeMetrics enables the metrics for the duration and the
+// failures of the TLS handshakes of each listener. It is set by the
+// caller before the listeners are started.
+var HandshakeMetrics bool
+
+// tlsListener returns a TLS listener which enforces the handshake
+// timeout of the listener and the HandshakeLimit if there are any
+// and records the handshake metrics if HandshakeMetrics is set. The
+// JA3 fingerprints of the clients are computed if JA3 is set.
+func tlsListener(ln net.Listener, l config.Listen, cfg *tls.Config) net.Listener {
+	if JA3 {
+		ln = ja3Listener{ln}
+	}
+	if l.TLSHandshakeTimeout <= 0 && HandshakeLimit == nil && !HandshakeMetrics {
+		return tls.NewListener(ln, cfg)
+	}
+	var stats *handshakeStats
+	if HandshakeMetrics {
+		stats = newHandshakeStats(metrics.DefaultRegistry, l.Addr)
+	}
+	return newHandshakeListener(ln, cfg, l.TLSHandshakeTimeout, metrics.DefaultRegistry.GetCounter("tls.handshake.timeout"), HandshakeLimit, stats)
+}
+
 type tcpListener struct {
 	l         net.Listener
 	addr      net.Addr
@@ -2,14 +2,21 @@ package proxy
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
 )
 
@@ -82,3 +89,235 @@ func TestGracefulShutdown(t *testing.T) {
 	// note that the actual listeners have not returned yet
 	wg.Wait()
 }
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeouts := &testCounter{}
+	ln := newHandshakeListener(raw, tlsServerConfig(), 100*time.Millisecond, timeouts, nil, nil)
+	defer ln.Close()
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// a client which does not send the client hello is dropped
+	// without blocking the other clients.
+	slow, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsClientConfig()}}
+	resp, err := client.Get("https://" + raw.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, 200; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+
+	slow.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := slow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("got %v want EOF", err)
+	}
+	if got, want := atomic.LoadInt64(&timeouts.n), int64(1); got != want {
+		t.Fatalf("got %d timeouts want %d", got, want)
+	}
+
+	// the deadline of the handshake does not apply to the requests
+	time.Sleep(150 * time.Millisecond)
+	resp, err = client.Get("https://" + raw.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestHandshakeLimiter(t *testing.T) {
+	queued, shed := &testCounter{}, &testCounter{}
+	l := NewHandshakeLimiter(1, 1, queued, shed)
+
+	if ok, _ := l.acquire(time.Time{}); !ok {
+		t.Fatal("first handshake not admitted")
+	}
+
+	// the second handshake waits in the queue
+	admitted := make(chan bool)
+	go func() {
+		ok, _ := l.acquire(time.Now().Add(time.Second))
+		admitted <- ok
+	}()
+	for atomic.LoadInt64(&queued.n) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the third handshake is dropped since the queue is full
+	if ok, timedOut := l.acquire(time.Time{}); ok || timedOut {
+		t.Fatalf("got ok=%v timedOut=%v want dropped handshake", ok, timedOut)
+	}
+	if got, want := atomic.LoadInt64(&shed.n), int64(1); got != want {
+		t.Fatalf("got %d shed handshakes want %d", got, want)
+	}
+
+	l.release()
+	if !<-admitted {
+		t.Fatal("queued handshake not admitted")
+	}
+	if got, want := atomic.LoadInt64(&queued.n), int64(0); got != want {
+		t.Fatalf("got %d queued handshakes want %d", got, want)
+	}
+
+	// the queued handshake times out
+	if ok, timedOut := l.acquire(time.Now().Add(10 * time.Millisecond)); ok || !timedOut {
+		t.Fatalf("got ok=%v timedOut=%v want timeout", ok, timedOut)
+	}
+}
+
+type testTimer struct{ n int64 }
+
+func (t *testTimer) Percentile(nth float64) float64 { return 0 }
+func (t *testTimer) Rate1() float64                 { return 0 }
+func (t *testTimer) Update(time.Duration)           { atomic.AddInt64(&t.n, 1) }
+func (t *testTimer) UpdateSince(time.Time)          { atomic.AddInt64(&t.n, 1) }
+
+// testHistogram records the last value.
+type testHistogram struct{ n, last int64 }
+
+func (h *testHistogram) Update(v int64) {
+	atomic.AddInt64(&h.n, 1)
+	atomic.StoreInt64(&h.last, v)
+}
+
+// testRegistry is a metrics registry which keeps the metrics for
+// inspection by the tests.
+type testRegistry struct {
+	mu         sync.Mutex
+	counters   map[string]*testCounter
+	timers     map[string]*testTimer
+	histograms map[string]*testHistogram
+}
+
+func (r *testRegistry) Names() []string   { return nil }
+func (r *testRegistry) Unregister(string) {}
+func (r *testRegistry) UnregisterAll()    {}
+func (r *testRegistry) counter(name string) int64 {
+	return atomic.LoadInt64(&r.GetCounter(name).(*testCounter).n)
+}
+func (r *testRegistry) timer(name string) int64 {
+	return atomic.LoadInt64(&r.GetTimer(name).(*testTimer).n)
+}
+
+func (r *testRegistry) GetCounter(name string) metrics.Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counters == nil {
+		r.counters = map[string]*testCounter{}
+	}
+	if r.counters[name] == nil {
+		r.counters[name] = &testCounter{}
+	}
+	return r.counters[name]
+}
+
+func (r *testRegistry) GetTimer(name string) metrics.Timer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timers == nil {
+		r.timers = map[string]*testTimer{}
+	}
+	if r.timers[name] == nil {
+		r.timers[name] = &testTimer{}
+	}
+	return r.timers[name]
+}
+
+func (r *testRegistry) GetHistogram(name string) metrics.Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.histograms == nil {
+		r.histograms = map[string]*testHistogram{}
+	}
+	if r.histograms[name] == nil {
+		r.histograms[name] = &testHistogram{}
+	}
+	return r.histograms[name]
+}
+
+func TestTLSHandshakeMetrics(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &testRegistry{}
+	ln := newHandshakeListener(raw, tlsServerConfig(), time.Second, nil, nil, newHandshakeStats(reg, "127.0.0.1:443"))
+	defer ln.Close()
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// the second connection resumes the session of the first one
+	tlscfg := tlsClientConfig()
+	tlscfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	for i := 0; i < 2; i++ {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlscfg}}
+		resp, err := client.Get("https://" + raw.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		client.CloseIdleConnections()
+	}
+
+	// a plain HTTP request is a protocol failure
+	if resp, err := http.Get("http://" + raw.Addr().String() + "/"); err == nil {
+		resp.Body.Close()
+	}
+
+	const prefix = "tls.handshake.127_0_0_1_443"
+	wait := func(desc string, f func() int64) {
+		deadline := time.Now().Add(time.Second)
+		for f() != 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: got %d want 1", desc, f())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	wait("full", func() int64 { return reg.timer(prefix + ".full") })
+	wait("resumed", func() int64 { return reg.timer(prefix + ".resumed") })
+	wait("protocol", func() int64 { return reg.counter(prefix + ".failed.protocol") })
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{io.EOF, "closed"},
+		{errors.New("tls: no certificates configured"), "nocert"},
+		{errors.New("cert: no certificates stored"), "nocert"},
+		{errors.New("tls: client didn't provide a certificate"), "clientauth"},
+		{errors.New("tls: failed to verify client certificate: x509: certificate signed by unknown authority"), "clientauth"},
+		{errors.New("tls: client offered only unsupported versions: [301]"), "protocol"},
+		{errors.New("tls: no cipher suite supported by both client and server"), "protocol"},
+		{errors.New("tls: first record does not look like a TLS handshake"), "protocol"},
+		{errors.New("read tcp 127.0.0.1:443->127.0.0.1:1234: read: connection reset by peer"), "closed"},
+		{errors.New("tls: unexpected message"), "other"},
+	}
+	for _, tt := range tests {
+		if got := handshakeFailure(tt.err); got != tt.want {
+			t.Errorf("%q: got %s want %s", tt.err, got, tt.want)
+		}
+	}
+}
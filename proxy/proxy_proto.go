@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	proxyproto "github.com/armon/go-proxyproto"
+)
+
+// proxyProtoListener accepts the PROXY protocol header on connections
+// from trusted sources. The remote address of these connections is the
+// client address of the header if there is one. Connections from other
+// sources are returned unchanged so that their clients cannot claim an
+// arbitrary address. All sources are trusted if trusted is empty.
+type proxyProtoListener struct {
+	net.Listener
+	timeout time.Duration
+	trusted []*net.IPNet
+}
+
+func newProxyProtoListener(ln net.Listener, timeout time.Duration, trusted []string) net.Listener {
+	pl := &proxyProtoListener{Listener: ln, timeout: timeout}
+	for _, s := range trusted {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Printf("[WARN] Ignoring invalid trusted PROXY protocol source %s. %s", s, err)
+			continue
+		}
+		pl.trusted = append(pl.trusted, n)
+	}
+	return pl
+}
+
+func (ln *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !ln.trust(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &proxyProtoConn{Conn: proxyproto.NewConn(c, ln.timeout), peer: c.RemoteAddr()}, nil
+}
+
+func (ln *proxyProtoListener) trust(addr net.Addr) bool {
+	if len(ln.trusted) == 0 {
+		return true
+	}
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range ln.trusted {
+		if n.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtoConn is a connection from a trusted source which may
+// start with a PROXY protocol header.
+type proxyProtoConn struct {
+	net.Conn
+	peer net.Addr
+}
+
+// proxied returns whether the remote address of the connection
+// was provided by a PROXY protocol header.
+func (c *proxyProtoConn) proxied() bool {
+	return c.Conn.RemoteAddr().String() != c.peer.String()
+}
+
+// proxiedRequest returns whether the remote address of the request
+// was provided by a PROXY protocol header from a trusted source.
+func proxiedRequest(r *http.Request) bool {
+	c, _ := r.Context().Value(connKey{}).(net.Conn)
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if jc, ok := c.(*ja3Conn); ok {
+		c = jc.Conn
+	}
+	pc, ok := c.(*proxyProtoConn)
+	return ok && pc.proxied()
+}
+
+// reconcileForwardedFor applies the policy of the listener to the
+// X-Forwarded-For header of requests whose client address was provided
+// by a PROXY protocol header. With "replace" the headers of the previous
+// hops are removed so that the client address of the PROXY protocol
+// header is the only one. With "dedup" the client address is removed
+// from the end of the header since the reverse proxy adds it again.
+// With "append" the header is not changed.
+func reconcileForwardedFor(r *http.Request, policy string) {
+	if policy != "replace" && policy != "dedup" {
+		return
+	}
+	if !proxiedRequest(r) {
+		return
+	}
+
+	if policy == "replace" {
+		r.Header.Del("X-Forwarded-For")
+		r.Header.Del("X-Real-Ip")
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(s))
+		}
+	}
+	if len(hops) == 0 || hops[len(hops)-1] != ip {
+		return
+	}
+	hops = hops[:len(hops)-1]
+	if len(hops) == 0 {
+		r.Header.Del("X-Forwarded-For")
+		return
+	}
+	r.Header.Set("X-Forwarded-For", strings.Join(hops, ", "))
+}
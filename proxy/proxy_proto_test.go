@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyProtoForwardedFor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s", r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-Ip"))
+	}))
+	defer server.Close()
+
+	tbl, err := route.NewTable(bytes.NewBufferString("route add svc / " + server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serve starts a proxy with the given policy and trusted sources
+	serve := func(policy string, trusted []string) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &http.Server{
+			Handler: &HTTPProxy{
+				Transport:     http.DefaultTransport,
+				ProxyProtoXFF: policy,
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			},
+			ConnContext: connContext,
+		}
+		go srv.Serve(newProxyProtoListener(l, time.Second, trusted))
+		t.Cleanup(func() { srv.Close() })
+		return l.Addr().String()
+	}
+
+	// get sends a request with the given PROXY header and
+	// X-Forwarded-For and X-Real-Ip headers.
+	get := func(addr, pxy, xff, realIP string) string {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		req := pxy + "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"
+		if xff != "" {
+			req += "X-Forwarded-For: " + xff + "\r\n"
+		}
+		if realIP != "" {
+			req += "X-Real-Ip: " + realIP + "\r\n"
+		}
+		if _, err := c.Write([]byte(req + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	const pxy = "PROXY TCP4 1.2.3.4 127.0.0.1 5555 80\r\n"
+
+	tests := []struct {
+		desc    string
+		policy  string
+		trusted []string
+		pxy     string
+		xff     string
+		realIP  string
+		want    string
+	}{
+		{"append", "", nil, pxy, "1.2.3.4", "1.2.3.4", "1.2.3.4, 1.2.3.4|1.2.3.4"},
+		{"replace", "replace", nil, pxy, "9.9.9.9, 1.2.3.4", "9.9.9.9", "1.2.3.4|1.2.3.4"},
+		{"dedup", "dedup", nil, pxy, "9.9.9.9, 1.2.3.4", "", "9.9.9.9, 1.2.3.4|1.2.3.4"},
+		{"dedup other client", "dedup", nil, pxy, "5.6.7.8", "", "5.6.7.8, 1.2.3.4|1.2.3.4"},
+		{"without PROXY header", "replace", nil, "", "5.6.7.8", "", "5.6.7.8, 127.0.0.1|127.0.0.1"},
+		{"trusted source", "replace", []string{"127.0.0.0/8"}, pxy, "5.6.7.8", "", "1.2.3.4|1.2.3.4"},
+		{"untrusted source", "replace", []string{"10.0.0.0/8"}, "", "5.6.7.8", "", "5.6.7.8, 127.0.0.1|127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			addr := serve(tt.policy, tt.trusted)
+			if got, want := get(addr, tt.pxy, tt.xff, tt.realIP), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
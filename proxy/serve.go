@@ -15,7 +15,6 @@ import (
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/proxy/tcp"
 
-	"github.com/armon/go-proxyproto"
 	"github.com/inetaf/tcpproxy"
 )
 
@@ -84,12 +83,14 @@ func ListenAndServeHTTP(l config.Listen, h http.Handler, cfg *tls.Config) error
 	}
 
 	srv := &http.Server{
-		Addr:         l.Addr,
-		Handler:      h,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
+		Addr:              l.Addr,
+		Handler:           h,
+		ReadTimeout:       l.ReadTimeout,
+		ReadHeaderTimeout: l.ReadHeaderTimeout,
+		WriteTimeout:      l.WriteTimeout,
+		IdleTimeout:       l.IdleTimeout,
+		TLSConfig:         cfg,
+		ConnContext:       connContext,
 	}
 	return serve(ln, srv)
 }
@@ -123,26 +124,26 @@ func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, c
 	var tln net.Listener = tcpSNIListener
 	// enable proxy protocol on the tcp side if configured to do so
 	if pxyProto {
-		tln = &proxyproto.Listener{
-			Listener:           tln,
-			ProxyHeaderTimeout: l.ProxyHeaderTimeout,
-		}
+		tln = newProxyProtoListener(tln, l.ProxyHeaderTimeout, l.ProxyProtoTrusted)
 	}
 	tps.ServeLater(tln, &tcp.Server{
 		Addr:         l.Addr,
 		Handler:      p,
 		ReadTimeout:  l.ReadTimeout,
 		WriteTimeout: l.WriteTimeout,
+		IdleTimeout:  l.IdleTimeout,
 	})
 
 	// wrap TargetListener in a tls terminating version for HTTPS
-	tps.ServeLater(tls.NewListener(httpsListener, cfg), &http.Server{
-		Addr:         l.Addr,
-		Handler:      h,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
+	tps.ServeLater(tlsListener(httpsListener, l, cfg), &http.Server{
+		Addr:              l.Addr,
+		Handler:           h,
+		ReadTimeout:       l.ReadTimeout,
+		ReadHeaderTimeout: l.ReadHeaderTimeout,
+		WriteTimeout:      l.WriteTimeout,
+		IdleTimeout:       l.IdleTimeout,
+		TLSConfig:         cfg,
+		ConnContext:       connContext,
 	})
 
 	// tcpproxy creates its own listener from the configuration above so we can
@@ -174,6 +175,7 @@ func ListenAndServeTCP(l config.Listen, h tcp.Handler, cfg *tls.Config) error {
 		Handler:      h,
 		ReadTimeout:  l.ReadTimeout,
 		WriteTimeout: l.WriteTimeout,
+		IdleTimeout:  l.IdleTimeout,
 	}
 	return serve(ln, srv)
 }
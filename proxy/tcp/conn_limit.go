@@ -0,0 +1,109 @@
+package tcp
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// defaultMaxIPs is the number of tracked client IPs if the
+// ConnLimiter has no MaxIPs.
+const defaultMaxIPs = 100000
+
+var (
+	// errMaxConnPerIP is returned when the client has reached
+	// the limit of connections per client IP.
+	errMaxConnPerIP = errors.New("too many connections from client IP")
+
+	// errMaxIPs is returned when the connection limiter tracks
+	// the maximum number of client IPs.
+	errMaxIPs = errors.New("too many client IPs")
+)
+
+// ConnLimiter counts the active connections per client IP and refuses
+// new connections of a client which has reached the limit. A counter is
+// removed when the last connection of the client is closed. The number
+// of counters is limited to MaxIPs so that a flood of connections from
+// many different or spoofed addresses cannot exhaust the memory. The
+// connections of new clients are refused while the limit is reached.
+type ConnLimiter struct {
+	// Max is the maximum number of active connections per client IP
+	// for all routes. 0 disables the limit.
+	Max int
+
+	// MaxIPs is the maximum number of tracked client IPs. The
+	// counters for the routes with the 'maxconnperip' option count
+	// towards this limit. defaultMaxIPs is used if the value is 0.
+	MaxIPs int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// acquire adds a connection for key if there are less than max active
+// connections for it. max <= 0 means no limit.
+func (l *ConnLimiter) acquire(key string, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns == nil {
+		l.conns = map[string]int{}
+	}
+	n, ok := l.conns[key]
+	switch {
+	case n >= max:
+		return errMaxConnPerIP
+	case !ok && len(l.conns) >= l.maxIPs():
+		return errMaxIPs
+	}
+	l.conns[key] = n + 1
+	return nil
+}
+
+// release removes a connection for key which was added by acquire.
+func (l *ConnLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := l.conns[key]; n > 1 {
+		l.conns[key] = n - 1
+	} else {
+		delete(l.conns, key)
+	}
+}
+
+func (l *ConnLimiter) maxIPs() int {
+	if l.MaxIPs > 0 {
+		return l.MaxIPs
+	}
+	return defaultMaxIPs
+}
+
+// clientIP returns the IP address of the client of the connection.
+func clientIP(c net.Conn) string {
+	ip, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return ip
+}
+
+// routeMaxConnPerIP returns the value of the 'maxconnperip' option
+// of the target or 0 if the route has no limit.
+func routeMaxConnPerIP(t *route.Target) int {
+	v := t.Opts["maxconnperip"]
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("[WARN] tcp: invalid maxconnperip %q for route %s", v, t.Prefix)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,94 @@
+package tcp
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l := &ConnLimiter{MaxIPs: 2}
+
+	if err := l.acquire("1.1.1.1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire("1.1.1.1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.acquire("1.1.1.1", 2), errMaxConnPerIP; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if err := l.acquire("2.2.2.2", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.acquire("3.3.3.3", 2), errMaxIPs; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if err := l.acquire("3.3.3.3", 0); err != nil {
+		t.Fatalf("got %v want no limit", err)
+	}
+
+	// closed connections free the slot and the counter
+	l.release("1.1.1.1")
+	if err := l.acquire("1.1.1.1", 2); err != nil {
+		t.Fatal(err)
+	}
+	l.release("2.2.2.2")
+	if got, want := len(l.conns), 1; got != want {
+		t.Fatalf("got %d counters want %d", got, want)
+	}
+	if err := l.acquire("3.3.3.3", 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyConnLimit(t *testing.T) {
+	tg := &route.Target{
+		URL:    &url.URL{Scheme: "tcp", Host: "127.0.0.1:1"},
+		Prefix: ":1234",
+		Opts:   map[string]string{"maxconnperip": "1"},
+	}
+
+	tests := []struct {
+		desc         string
+		max          int
+		held         string
+		global, rtes int64
+	}{
+		{"global limit", 1, "pipe", 1, 0},
+		{"route limit", 0, ":1234 pipe", 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			global, rte := &counter{}, &counter{}
+			p := &Proxy{
+				Lookup:                  func(string) *route.Target { return tg },
+				ConnLimit:               &ConnLimiter{Max: tt.max},
+				RejectMaxConnPerIP:      global,
+				RejectRouteMaxConnPerIP: rte,
+			}
+			// a connection of the client is already active
+			if err := p.ConnLimit.acquire(tt.held, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			in, client := net.Pipe()
+			defer client.Close()
+			if err := p.ServeTCP(in); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := global.n, tt.global; got != want {
+				t.Fatalf("got %d global rejects want %d", got, want)
+			}
+			if got, want := rte.n, tt.rtes; got != want {
+				t.Fatalf("got %d route rejects want %d", got, want)
+			}
+			if got, want := p.ConnLimit.conns[tt.held], 1; got != want {
+				t.Fatalf("got %d active connections want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/route"
+)
+
+// countingConn counts the bytes which are received from and sent to
+// the client for the connection log.
+type countingConn struct {
+	net.Conn
+	start time.Time
+
+	// received and sent are accessed atomically
+	received, sent int64
+}
+
+func newCountingConn(c net.Conn) *countingConn {
+	return &countingConn{Conn: c, start: time.Now()}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.received, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.sent, int64(n))
+	return n, err
+}
+
+// logConn writes the connection log entry for the client connection
+// when it is closed. serverName is the SNI of the client and t is the
+// target the connection was sent to. Both can be empty.
+func logConn(l logger.Logger, c *countingConn, serverName string, t *route.Target) {
+	e := &logger.Event{
+		Start:         c.start,
+		End:           time.Now(),
+		RemoteAddr:    c.RemoteAddr().String(),
+		ServerName:    serverName,
+		BytesReceived: atomic.LoadInt64(&c.received),
+		BytesSent:     atomic.LoadInt64(&c.sent),
+	}
+	if t != nil {
+		e.UpstreamAddr = t.URL.Host
+		e.UpstreamService = t.Service
+	}
+	l.Log(e)
+}
@@ -0,0 +1,77 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyConnLog(t *testing.T) {
+	// upstream echoes the first line
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		c, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _, _ := bufio.NewReader(c).ReadLine()
+		c.Write(append(line, " echo"...))
+	}()
+
+	var buf bytes.Buffer
+	l, err := logger.New(&buf, "$remote_host $upstream_service $upstream_addr $bytes_received $bytes_sent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{
+		Lookup: func(string) *route.Target {
+			return &route.Target{Service: "svc", URL: &url.URL{Scheme: "tcp", Host: upstream.Addr().String()}}
+		},
+		Logger: l,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		in, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		p.ServeTCP(in)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(c, "hello\n")
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	<-done
+
+	if got, want := string(b), "hello echo"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := buf.String(), "127.0.0.1 svc "+upstream.Addr().String()+" 6 10\n"; got != want {
+		t.Fatalf("got log %q want %q", got, want)
+	}
+}
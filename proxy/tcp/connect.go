@@ -0,0 +1,116 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// Dial establishes the outbound connection to addr for the target.
+// The connection is tunneled through the upstream proxy of the
+// target if it has one.
+func Dial(t *route.Target, addr string, timeout time.Duration) (net.Conn, error) {
+	if u := t.UpstreamProxyURL(); u != nil {
+		return DialConnect(u, addr, timeout)
+	}
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// DialConnect establishes a connection to addr through the HTTP forward
+// proxy at proxyURL with the CONNECT method. The connection to the
+// proxy uses TLS if the scheme of proxyURL is https. The user info of
+// proxyURL is sent as basic auth credentials in the Proxy-Authorization
+// header. The timeout covers the connection setup and the response of
+// the proxy. A value of 0 means no timeout.
+func DialConnect(proxyURL *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	d := &net.Dialer{Deadline: deadline}
+	var c net.Conn
+	var err error
+	switch proxyURL.Scheme {
+	case "http":
+		c, err = d.Dial("tcp", proxyAddr)
+	case "https":
+		c, err = tls.DialWithDialer(d, "tcp", proxyAddr, &tls.Config{ServerName: proxyURL.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q", proxyURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("upstream proxy %s refused CONNECT to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	// the upstream server may have sent data already
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: c, r: br}, nil
+	}
+	return c, nil
+}
+
+// bufferedConn returns the data which was read ahead from the
+// connection before reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
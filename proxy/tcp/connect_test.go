@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialConnect(t *testing.T) {
+	// upstream sends a greeting before it reads from the connection
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			c, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.WriteString(c, "hello\n")
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	var auth string
+	egress := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		auth = r.Header.Get("Proxy-Authorization")
+		if r.Host != upstream.Addr().String() {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		out, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		in, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			out.Close()
+			return
+		}
+		io.WriteString(in, "HTTP/1.1 200 OK\r\n\r\n")
+		go func() {
+			io.Copy(out, in)
+			out.Close()
+		}()
+		io.Copy(in, out)
+		in.Close()
+	}))
+	defer egress.Close()
+
+	u, err := url.Parse(egress.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.User = url.UserPassword("user", "pass")
+
+	c, err := DialConnect(u, upstream.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if got, want := auth, "Basic dXNlcjpwYXNz"; got != want {
+		t.Fatalf("got auth %q want %q", got, want)
+	}
+
+	io.WriteString(c, "ping\n")
+	r := bufio.NewReader(c)
+	for _, want := range []string{"hello\n", "ping\n"} {
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	}
+
+	_, err = DialConnect(u, "127.0.0.1:1", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Fatalf("got error %v want 403 Forbidden", err)
+	}
+}
@@ -0,0 +1,72 @@
+package tcp
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// dialFailover connects to the upstream server of the target t. If the
+// connection fails the picker is run again with lookup and the next
+// target of the route which has not failed yet is tried until attempts
+// connections have been made. This is safe since no data has been sent
+// to the upstream server yet. failed is called for every failed
+// connection attempt and failover counts the switches to another target.
+// dialFailover returns the target of the established connection.
+func dialFailover(in net.Conn, t *route.Target, lookup func() *route.Target, attempts int, timeout time.Duration, failed func(t *route.Target, err error), failover metrics.Counter) (*route.Target, net.Conn, error) {
+	tried := map[*route.Target]bool{}
+	for n := 1; ; n++ {
+		out, err := Dial(t, t.URL.Host, timeout)
+		if err == nil {
+			return t, out, nil
+		}
+		failed(t, err)
+		tried[t] = true
+
+		if n >= attempts {
+			return t, nil, err
+		}
+		next := nextTarget(in, t, lookup, tried)
+		if next == nil {
+			return t, nil, err
+		}
+		if failover != nil {
+			failover.Inc(1)
+		}
+		log.Printf("[INFO] tcp: failing over from %s to %s", t.URL.Host, next.URL.Host)
+		t = next
+	}
+}
+
+// nextTarget returns the next target of the route of t which has not
+// been tried yet and which the client may connect to. The picker may
+// return the same target again so the other targets of the route are
+// used in order after as many lookups as the route has targets.
+func nextTarget(in net.Conn, t *route.Target, lookup func() *route.Target, tried map[*route.Target]bool) *route.Target {
+	usable := func(c *route.Target) bool {
+		if c == nil || tried[c] {
+			return false
+		}
+		if c.AccessDeniedTCP(in) {
+			tried[c] = true
+			return false
+		}
+		return true
+	}
+
+	peers := t.Peers()
+	for i := 0; i < len(peers); i++ {
+		if c := lookup(); usable(c) {
+			return c
+		}
+	}
+	for _, c := range peers {
+		if usable(c) {
+			return c
+		}
+	}
+	return nil
+}
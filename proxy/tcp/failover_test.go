@@ -0,0 +1,93 @@
+package tcp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestDialFailover(t *testing.T) {
+	// dead is an address which refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	live, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	go func() {
+		for {
+			c, err := live.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	tbl, err := route.NewTable(bytes.NewBufferString("route add svc :1234 tcp://" + dead + "\nroute add svc :1234 tcp://" + live.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func() *route.Target { return tbl.LookupHost(":1234", route.Picker["rr"]) }
+
+	var deadTarget *route.Target
+	for _, tg := range lookup().Peers() {
+		if tg.URL.Host == dead {
+			deadTarget = tg
+		}
+	}
+	if deadTarget == nil {
+		t.Fatal("target not found")
+	}
+
+	in, _ := net.Pipe()
+	defer in.Close()
+
+	tests := []struct {
+		desc      string
+		attempts  int
+		addr      string
+		failed    int
+		failovers int64
+	}{
+		{"no failover", 1, "", 1, 0},
+		{"failover", 3, live.Addr().String(), 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var failed int
+			failover := &counter{}
+			tg, out, err := dialFailover(in, deadTarget, lookup, tt.attempts, time.Second, func(*route.Target, error) { failed++ }, failover)
+			if tt.addr == "" {
+				if err == nil {
+					out.Close()
+					t.Fatal("got nil want error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				out.Close()
+				if got, want := tg.URL.Host, tt.addr; got != want {
+					t.Fatalf("got target %s want %s", got, want)
+				}
+			}
+			if got, want := failed, tt.failed; got != want {
+				t.Fatalf("got %d failed connections want %d", got, want)
+			}
+			if got, want := failover.n, tt.failovers; got != want {
+				t.Fatalf("got %d failovers want %d", got, want)
+			}
+		})
+	}
+}
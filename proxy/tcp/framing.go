@@ -0,0 +1,118 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// ErrFrameTooLarge is returned when a frame exceeds the maximum frame size.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// FrameInspector inspects the frames of a TCP stream without
+// interpreting their payload.
+type FrameInspector interface {
+	// Inspect is called with the data of the stream in the order
+	// in which it was received. It returns an error if the data
+	// violates the framing.
+	Inspect(b []byte) error
+}
+
+// NewFrameInspector returns a frame inspector for the given value of the
+// 'framing' route option. Frames larger than maxFrameSize bytes are
+// rejected unless maxFrameSize is 0. frames is incremented for every
+// frame. For 'none' or an empty value NewFrameInspector returns nil.
+//
+// Supported framings are:
+//
+//	none            - no framing
+//	lengthprefix:n  - frames are prefixed with their length as an n byte
+//	                  big-endian unsigned integer. n must be 1, 2, 4 or 8.
+func NewFrameInspector(framing string, maxFrameSize int64, frames metrics.Counter) (FrameInspector, error) {
+	switch {
+	case framing == "" || framing == "none":
+		return nil, nil
+
+	case strings.HasPrefix(framing, "lengthprefix:"):
+		n, err := strconv.Atoi(framing[len("lengthprefix:"):])
+		if err != nil || (n != 1 && n != 2 && n != 4 && n != 8) {
+			return nil, fmt.Errorf("invalid length prefix size in framing %q", framing)
+		}
+		return &lengthPrefixInspector{hdr: make([]byte, n), max: maxFrameSize, frames: frames}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown framing %q", framing)
+	}
+}
+
+// lengthPrefixInspector inspects frames which are prefixed with
+// their length as a big-endian unsigned integer.
+type lengthPrefixInspector struct {
+	// hdr is the buffer for the length prefix and n is the
+	// number of bytes of the prefix read so far.
+	hdr []byte
+	n   int
+
+	// remaining is the number of payload bytes of the
+	// current frame which have not been read yet.
+	remaining uint64
+
+	max    int64
+	frames metrics.Counter
+}
+
+func (f *lengthPrefixInspector) Inspect(b []byte) error {
+	for len(b) > 0 {
+		if f.remaining > 0 {
+			n := uint64(len(b))
+			if n > f.remaining {
+				n = f.remaining
+			}
+			f.remaining -= n
+			b = b[n:]
+			continue
+		}
+
+		n := copy(f.hdr[f.n:], b)
+		f.n += n
+		b = b[n:]
+		if f.n < len(f.hdr) {
+			return nil
+		}
+
+		var size uint64
+		for _, c := range f.hdr {
+			size = size<<8 | uint64(c)
+		}
+		f.n = 0
+		if f.max > 0 && size > uint64(f.max) {
+			return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrFrameTooLarge, size, f.max)
+		}
+		f.remaining = size
+		if f.frames != nil {
+			f.frames.Inc(1)
+		}
+	}
+	return nil
+}
+
+// inspectReader passes the data read from r to the frame inspector
+// and fails if the data violates the framing.
+type inspectReader struct {
+	r io.Reader
+	f FrameInspector
+}
+
+func (r *inspectReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if ferr := r.f.Inspect(p[:n]); ferr != nil {
+			return 0, ferr
+		}
+	}
+	return n, err
+}
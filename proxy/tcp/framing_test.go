@@ -0,0 +1,82 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+type counter struct{ n int64 }
+
+func (c *counter) Inc(n int64) { c.n += n }
+
+func TestNewFrameInspector(t *testing.T) {
+	tests := []struct {
+		framing string
+		nilOK   bool
+		err     bool
+	}{
+		{"", true, false},
+		{"none", true, false},
+		{"lengthprefix:1", false, false},
+		{"lengthprefix:2", false, false},
+		{"lengthprefix:4", false, false},
+		{"lengthprefix:8", false, false},
+		{"lengthprefix:3", false, true},
+		{"lengthprefix:x", false, true},
+		{"foo", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.framing, func(t *testing.T) {
+			f, err := NewFrameInspector(tt.framing, 0, nil)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := f == nil, tt.nilOK || tt.err; got != want {
+				t.Fatalf("got nil inspector %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLengthPrefixInspector(t *testing.T) {
+	// three frames with 2 byte length prefix: 3, 0 and 5 bytes
+	stream := []byte{0, 3, 'a', 'b', 'c', 0, 0, 0, 5, 'd', 'e', 'f', 'g', 'h'}
+
+	// feed the stream in chunks of all sizes to test frames
+	// and length prefixes which span multiple reads.
+	for size := 1; size <= len(stream); size++ {
+		c := &counter{}
+		f, err := NewFrameInspector("lengthprefix:2", 5, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(stream); i += size {
+			j := i + size
+			if j > len(stream) {
+				j = len(stream)
+			}
+			if err := f.Inspect(stream[i:j]); err != nil {
+				t.Fatalf("chunk size %d: got error %v", size, err)
+			}
+		}
+		if got, want := c.n, int64(3); got != want {
+			t.Fatalf("chunk size %d: got %d frames want %d", size, got, want)
+		}
+	}
+}
+
+func TestLengthPrefixInspectorMaxFrameSize(t *testing.T) {
+	f, err := NewFrameInspector("lengthprefix:4", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &inspectReader{
+		r: bytes.NewReader([]byte{0, 0, 0, 2, 'a', 'b', 0, 0, 0, 5, 'a', 'b', 'c', 'd', 'e'}),
+		f: f,
+	}
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("got error %v want %v", err, ErrFrameTooLarge)
+	}
+}
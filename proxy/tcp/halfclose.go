@@ -0,0 +1,30 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+// errNoHalfClose is returned by closeWrite if the
+// connection does not support half-close.
+var errNoHalfClose = errors.New("half-close not supported")
+
+// closeWriter is implemented by the connections which
+// support half-close, e.g. *net.TCPConn and *tls.Conn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite shuts down the writing side of the connection.
+func closeWrite(c net.Conn) error {
+	cw, ok := c.(closeWriter)
+	if !ok {
+		return errNoHalfClose
+	}
+	return cw.CloseWrite()
+}
+
+// CloseWrite shuts down the writing side of the client connection.
+func (c *countingConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestRelayHalfClose(t *testing.T) {
+	// the backend answers after the client has sent all data
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			c, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			b, _ := ioutil.ReadAll(c)
+			c.Write(append([]byte("echo:"), b...))
+			c.Close()
+		}
+	}()
+
+	// front accepts the client connections for the relay
+	front, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer front.Close()
+
+	tests := []struct {
+		desc string
+		opts map[string]string
+		want string
+	}{
+		{"halfclose", map[string]string{"halfclose": "true"}, "echo:ping"},
+		{"no halfclose", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			client, err := net.Dial("tcp", front.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			in, err := front.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			out, err := net.Dial("tcp", backend.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tg := &route.Target{URL: &url.URL{Scheme: "tcp", Host: backend.Addr().String()}, TimerName: "test", Opts: tt.opts}
+			done := make(chan error, 1)
+			go func() {
+				err := Relay(tg, newCountingConn(in), out, nil)
+				in.Close()
+				out.Close()
+				done <- err
+			}()
+
+			client.Write([]byte("ping"))
+			client.(*net.TCPConn).CloseWrite()
+			b, _ := ioutil.ReadAll(client)
+			if got, want := string(b), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package tcp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TLS extensions which are part of the JA3 fingerprint.
+const (
+	extensionSupportedGroups uint16 = 10
+	extensionPointFormats    uint16 = 11
+)
+
+// maxJA3ClientHelloSize is the maximum size of a ClientHello message
+// for which a fingerprint is computed.
+const maxJA3ClientHelloSize = 16384
+
+// JA3 returns the JA3 fingerprint of the client which sent the TLS
+// ClientHello message msg. The fingerprint is the hex encoded MD5 hash
+// of the TLS version, the cipher suites, the extensions, the supported
+// groups and the point formats of the message. GREASE values are
+// ignored. msg must contain the full handshake message including the
+// 4 byte header. ok is false if the message cannot be parsed.
+// See https://github.com/salesforce/ja3
+func JA3(msg []byte) (fp string, ok bool) {
+	s, ok := ja3String(msg)
+	if !ok {
+		return "", false
+	}
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:]), true
+}
+
+// ParseJA3 returns the JA3 fingerprint of the ClientHello message in
+// the TLS records in data. more is true if data does not contain the
+// complete message yet. fp is empty if the data is not a valid
+// ClientHello message.
+func ParseJA3(data []byte) (fp string, more bool) {
+	_, msg, err := readClientHello(bytes.NewReader(data), maxJA3ClientHelloSize)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return "", true
+	case err != nil:
+		return "", false
+	}
+	fp, _ = JA3(msg)
+	return fp, false
+}
+
+// ja3String returns the fields of the ClientHello message msg in the
+// JA3 format: version,ciphers,extensions,groups,pointformats where the
+// values of each field are separated by dashes.
+func ja3String(msg []byte) (string, bool) {
+	// handshake header, version and random
+	if len(msg) < 4+2+32+1 || msg[0] != 0x01 {
+		return "", false
+	}
+	vers := uint16(msg[4])<<8 | uint16(msg[5])
+	d := msg[4+2+32:]
+
+	// session id
+	n := int(d[0])
+	if len(d) < 1+n+2 {
+		return "", false
+	}
+	d = d[1+n:]
+
+	// cipher suites
+	n = int(d[0])<<8 | int(d[1])
+	if n%2 == 1 || len(d) < 2+n+1 {
+		return "", false
+	}
+	ciphers := uint16s(d[2 : 2+n])
+	d = d[2+n:]
+
+	// compression methods
+	n = int(d[0])
+	if len(d) < 1+n {
+		return "", false
+	}
+	d = d[1+n:]
+
+	// extensions are optional
+	var exts, groups, points []uint16
+	if len(d) >= 2 {
+		n = int(d[0])<<8 | int(d[1])
+		if len(d) != 2+n {
+			return "", false
+		}
+		d = d[2:]
+	}
+	for len(d) > 0 {
+		if len(d) < 4 {
+			return "", false
+		}
+		ext := uint16(d[0])<<8 | uint16(d[1])
+		n = int(d[2])<<8 | int(d[3])
+		if len(d) < 4+n {
+			return "", false
+		}
+		data := d[4 : 4+n]
+		d = d[4+n:]
+		exts = append(exts, ext)
+
+		switch ext {
+		case extensionSupportedGroups:
+			if len(data) < 2 || int(data[0])<<8|int(data[1]) != len(data)-2 || len(data)%2 == 1 {
+				return "", false
+			}
+			groups = uint16s(data[2:])
+		case extensionPointFormats:
+			if len(data) < 1 || int(data[0]) != len(data)-1 {
+				return "", false
+			}
+			for _, p := range data[1:] {
+				points = append(points, uint16(p))
+			}
+		}
+	}
+
+	fields := []string{
+		strconv.Itoa(int(vers)),
+		joinJA3(ciphers),
+		joinJA3(exts),
+		joinJA3(groups),
+		joinJA3(points),
+	}
+	return strings.Join(fields, ","), true
+}
+
+// uint16s returns the big endian uint16 values in b.
+func uint16s(b []byte) []uint16 {
+	v := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		v = append(v, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return v
+}
+
+// joinJA3 returns the decimal values separated by dashes
+// without the GREASE values.
+func joinJA3(v []uint16) string {
+	var s []string
+	for _, x := range v {
+		if !isGREASE(x) {
+			s = append(s, strconv.Itoa(int(x)))
+		}
+	}
+	return strings.Join(s, "-")
+}
+
+// isGREASE returns whether v is one of the reserved GREASE values
+// 0x0a0a, 0x1a1a, ..., 0xfafa which clients send to prevent servers
+// from relying on a fixed set of values (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
@@ -0,0 +1,161 @@
+package tcp
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestJA3(t *testing.T) {
+	// u16 returns the big endian bytes of the values
+	u16 := func(v ...uint16) []byte {
+		var b []byte
+		for _, x := range v {
+			b = append(b, byte(x>>8), byte(x))
+		}
+		return b
+	}
+	// ext returns an extension with the data
+	ext := func(typ uint16, data ...byte) []byte {
+		return append(u16(typ, uint16(len(data))), data...)
+	}
+	// hello returns a client hello message with the ciphers and extensions
+	hello := func(ciphers []uint16, exts ...[]byte) []byte {
+		body := u16(0x0303)
+		body = append(body, make([]byte, 32)...) // random
+		body = append(body, 0)                   // session id
+		body = append(body, u16(uint16(2*len(ciphers)))...)
+		body = append(body, u16(ciphers...)...)
+		body = append(body, 1, 0) // compression methods
+		var e []byte
+		for _, x := range exts {
+			e = append(e, x...)
+		}
+		if exts != nil {
+			body = append(body, u16(uint16(len(e)))...)
+			body = append(body, e...)
+		}
+		n := len(body)
+		return append([]byte{0x01, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+	}
+
+	tests := []struct {
+		name string
+		msg  []byte
+		ja3  string
+		ok   bool
+	}{
+		{
+			name: "grease values are ignored",
+			msg: hello([]uint16{0x0a0a, 0x1301, 0xc02f},
+				ext(0x1a1a),
+				ext(0, 0, 0),
+				ext(10, append(u16(6), u16(0x2a2a, 0x001d, 0x0017)...)...),
+				ext(11, 2, 0, 1),
+			),
+			ja3: "771,4865-49199,0-10-11,29-23,0-1",
+			ok:  true,
+		},
+		{
+			name: "no extensions",
+			msg:  hello([]uint16{0x002f}),
+			ja3:  "771,47,,,",
+			ok:   true,
+		},
+		{
+			name: "truncated extension",
+			msg:  hello([]uint16{0x002f}, ext(10, 0, 4, 0)),
+		},
+		{
+			name: "not a client hello",
+			msg:  append([]byte{0x02}, hello([]uint16{0x002f})[1:]...),
+		},
+		{
+			name: "too short",
+			msg:  []byte{0x01, 0, 0, 2, 3, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := ja3String(tt.msg)
+			if got, want := ok, tt.ok; got != want {
+				t.Fatalf("got ok %v want %v", got, want)
+			}
+			if got, want := s, tt.ja3; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+			fp, _ := JA3(tt.msg)
+			want := ""
+			if tt.ok {
+				h := md5.Sum([]byte(tt.ja3))
+				want = hex.EncodeToString(h[:])
+			}
+			if fp != want {
+				t.Fatalf("got fingerprint %q want %q", fp, want)
+			}
+		})
+	}
+}
+
+// clientHello returns the records of the ClientHello of a TLS client.
+func clientHello(t *testing.T) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, &tls.Config{ServerName: "example.com"}).Handshake()
+		c.Close()
+	}()
+	raw, _, err := readClientHello(s, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestParseJA3(t *testing.T) {
+	raw := clientHello(t)
+
+	fp, more := ParseJA3(raw[:len(raw)-1])
+	if fp != "" || !more {
+		t.Fatalf("got %q, %v for partial data want more data", fp, more)
+	}
+	fp, more = ParseJA3(raw)
+	if len(fp) != 32 || more {
+		t.Fatalf("got %q, %v want fingerprint", fp, more)
+	}
+	fp, more = ParseJA3([]byte("GET / HTTP/1.1\r\n"))
+	if fp != "" || more {
+		t.Fatalf("got %q, %v for HTTP want no fingerprint", fp, more)
+	}
+}
+
+func TestSNIProxyLookupJA3(t *testing.T) {
+	raw := clientHello(t)
+	want, _ := ParseJA3(raw)
+
+	var host, fp string
+	p := &SNIProxy{
+		Lookup: func(string) *route.Target { panic("unexpected lookup") },
+		LookupJA3: func(h, ja3 string) *route.Target {
+			host, fp = h, ja3
+			return nil
+		},
+	}
+	in, c := net.Pipe()
+	defer c.Close()
+	go c.Write(raw)
+	if err := p.ServeTCP(in); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := host, "example.com"; got != want {
+		t.Fatalf("got host %q want %q", got, want)
+	}
+	if got := fp; got != want {
+		t.Fatalf("got fingerprint %q want %q", got, want)
+	}
+}
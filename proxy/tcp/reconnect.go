@@ -0,0 +1,219 @@
+package tcp
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// maxReplay is the maximum number of bytes from the client which are
+// kept for sending them again after a reconnect. Connections whose
+// client sends more data before the upstream server responds are not
+// reconnected.
+const maxReplay = 64 * 1024
+
+// defaultReconnectDelay is the delay before the first reconnect if
+// the route has no 'tcpreconnectdelay' option.
+const defaultReconnectDelay = 100 * time.Millisecond
+
+// reconnectOpts returns the number of reconnects and the delay before
+// the first reconnect of the 'tcpreconnect' and 'tcpreconnectdelay'
+// options of the target. The delay is doubled for every further
+// reconnect. Connections of routes with the 'framing' option are not
+// reconnected since the state of the frame inspection cannot be restored
+// and connections of routes with the 'halfclose' option since the replay
+// cannot forward a half-close.
+func reconnectOpts(t *route.Target) (attempts int, delay time.Duration) {
+	v := t.Opts["tcpreconnect"]
+	if v == "" {
+		return 0, 0
+	}
+	attempts, err := strconv.Atoi(v)
+	if err != nil || attempts < 0 {
+		log.Printf("[WARN] tcp: invalid tcpreconnect %q for route %s", v, t.Prefix)
+		return 0, 0
+	}
+	if framing := t.Opts["framing"]; framing != "" && framing != "none" {
+		log.Printf("[WARN] tcp: tcpreconnect is not supported with framing for route %s", t.Prefix)
+		return 0, 0
+	}
+	if t.Opts["halfclose"] == "true" {
+		log.Printf("[WARN] tcp: tcpreconnect is not supported with halfclose for route %s", t.Prefix)
+		return 0, 0
+	}
+	delay = defaultReconnectDelay
+	if v := t.Opts["tcpreconnectdelay"]; v != "" {
+		if delay, err = time.ParseDuration(v); err != nil || delay < 0 {
+			log.Printf("[WARN] tcp: invalid tcpreconnectdelay %q for route %s", v, t.Prefix)
+			delay = defaultReconnectDelay
+		}
+	}
+	return attempts, delay
+}
+
+// RelayReconnect works like Relay but connects to the upstream server
+// again with dial if the upstream server closes the connection before
+// it has sent any data. The data the client has sent so far is sent to
+// the new connection. There are at most attempts reconnects with a delay
+// which starts at delay and is doubled after every reconnect. reconnects
+// counts the reconnects. Once the upstream server has sent data a closed
+// upstream connection ends the client connection as well.
+func RelayReconnect(t *route.Target, in, out net.Conn, attempts int, delay time.Duration, dial func() (net.Conn, error), reconnects metrics.Counter) error {
+	rx := t.Counter(".rx")
+	tx := t.Counter(".tx")
+
+	w := &replayWriter{out: out, replay: true}
+	defer w.close()
+
+	errc := make(chan error, 2)
+	go func() {
+		err := copyBuffer(w, in, tx)
+		// unblock the read from the upstream server
+		// if the client closed the connection first.
+		w.finish()
+		errc <- err
+	}()
+
+	buf := make([]byte, 32*1024)
+	for n := 0; ; n++ {
+		nr, err := out.Read(buf)
+		if nr > 0 {
+			w.stopReplay()
+			if _, err := in.Write(buf[:nr]); err != nil {
+				return err
+			}
+			rx.Inc(int64(nr))
+			break
+		}
+		if w.finished() {
+			return relayErr(<-errc)
+		}
+		if n >= attempts || !w.canReplay() {
+			return relayErr(err)
+		}
+
+		time.Sleep(delay << uint(n))
+		log.Printf("[INFO] tcp: upstream %s closed the connection before sending data. Reconnecting", t.URL.Host)
+		next, err := dial()
+		if err != nil {
+			log.Printf("[WARN] tcp: cannot reconnect to upstream %s. %s", t.URL.Host, err)
+			continue
+		}
+		if err := w.reconnect(next); err != nil {
+			log.Printf("[WARN] tcp: cannot reconnect to upstream %s. %s", t.URL.Host, err)
+			continue
+		}
+		if reconnects != nil {
+			reconnects.Inc(1)
+		}
+		out = next
+	}
+
+	go func() {
+		errc <- copyBuffer(in, out, rx)
+	}()
+	return relayErr(<-errc)
+}
+
+func relayErr(err error) error {
+	if err != nil && err != io.EOF {
+		log.Print("[WARN]: tcp:  ", err)
+		return err
+	}
+	return nil
+}
+
+// replayWriter writes the data of the client to the current upstream
+// connection and keeps a copy of it until the upstream server has sent
+// data so that it can be sent again after a reconnect. Write errors are
+// ignored while the data can be replayed since the connection is
+// replaced.
+type replayWriter struct {
+	mu       sync.Mutex
+	out      net.Conn
+	conns    []net.Conn
+	replay   bool
+	overflow bool
+	buf      []byte
+	done     bool
+}
+
+func (w *replayWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.replay && !w.overflow {
+		if len(w.buf)+len(p) > maxReplay {
+			w.overflow, w.buf = true, nil
+		} else {
+			w.buf = append(w.buf, p...)
+		}
+	}
+	n, err := w.out.Write(p)
+	if err != nil && w.replay && !w.overflow {
+		return len(p), nil
+	}
+	return n, err
+}
+
+// reconnect replaces the upstream connection and sends
+// the data of the client to it.
+func (w *replayWriter) reconnect(out net.Conn) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		if _, err := out.Write(w.buf); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	w.out.Close()
+	w.out = out
+	w.conns = append(w.conns, out)
+	return nil
+}
+
+// canReplay returns true if all data of the client has been kept.
+func (w *replayWriter) canReplay() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.overflow
+}
+
+// stopReplay stops keeping the data of the client.
+func (w *replayWriter) stopReplay() {
+	w.mu.Lock()
+	w.replay, w.buf = false, nil
+	w.mu.Unlock()
+}
+
+// finish closes the upstream connection when the client is gone.
+func (w *replayWriter) finish() {
+	w.mu.Lock()
+	w.done = true
+	if w.replay {
+		w.out.Close()
+	}
+	w.mu.Unlock()
+}
+
+func (w *replayWriter) finished() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.done
+}
+
+// close closes the upstream connections which were established
+// by reconnects.
+func (w *replayWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range w.conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package tcp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestRelayReconnect(t *testing.T) {
+	// backend serves the connections with the handlers in order
+	backend := func(t *testing.T, handlers ...func(c net.Conn)) (addr string, stop func()) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for _, h := range handlers {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				h(c)
+				c.Close()
+			}
+		}()
+		return l.Addr().String(), func() { l.Close() }
+	}
+
+	// closeAfterRead reads the ping and closes the connection
+	closeAfterRead := func(c net.Conn) {
+		io.ReadFull(c, make([]byte, 4))
+	}
+	// pong answers the ping
+	pong := func(c net.Conn) {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(c, b); err == nil && string(b) == "ping" {
+			c.Write([]byte("pong"))
+		}
+	}
+	// answerAndClose answers the ping and closes the connection
+	answerAndClose := func(c net.Conn) {
+		io.ReadFull(c, make([]byte, 4))
+		c.Write([]byte("helo"))
+	}
+
+	tests := []struct {
+		desc       string
+		handlers   []func(c net.Conn)
+		attempts   int
+		want       string
+		reconnects int64
+	}{
+		{"reconnect", []func(c net.Conn){closeAfterRead, closeAfterRead, pong}, 3, "pong", 2},
+		{"attempts exhausted", []func(c net.Conn){closeAfterRead, closeAfterRead, pong}, 1, "", 1},
+		{"data sent", []func(c net.Conn){answerAndClose, pong}, 3, "helo", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			addr, stop := backend(t, tt.handlers...)
+			defer stop()
+
+			tg := &route.Target{URL: &url.URL{Scheme: "tcp", Host: addr}, TimerName: "test"}
+			dial := func() (net.Conn, error) { return net.Dial("tcp", addr) }
+			out, err := dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+
+			in, client := net.Pipe()
+			defer client.Close()
+			reconnects := &counter{}
+			done := make(chan error, 1)
+			go func() {
+				done <- RelayReconnect(tg, in, out, tt.attempts, time.Millisecond, dial, reconnects)
+				in.Close()
+			}()
+
+			if _, err := client.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(client)
+			if string(got) != tt.want {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if got, want := reconnects.n, tt.reconnects; got != want {
+				t.Fatalf("got %d reconnects want %d", got, want)
+			}
+		})
+	}
+}
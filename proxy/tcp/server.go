@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,6 +30,10 @@ type Server struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 
+	// IdleTimeout closes connections which have neither
+	// received nor sent data for this time.
+	IdleTimeout time.Duration
+
 	mu        sync.Mutex
 	listeners []net.Listener
 	conns     map[net.Conn]bool
@@ -73,6 +78,7 @@ func (s *Server) Serve(l net.Listener) error {
 			c:            c,
 			ReadTimeout:  s.ReadTimeout,
 			WriteTimeout: s.WriteTimeout,
+			IdleTimeout:  s.IdleTimeout,
 		}
 		s.mu.Lock()
 		if s.conns == nil {
@@ -127,25 +133,53 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.closeConns()
 }
 
-// conn implements a connection which honors read and write timeouts.
+// conn implements a connection which honors read, write and idle timeouts.
 type conn struct {
 	c            net.Conn
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// lastWrite is the time of the last write in unix nanoseconds.
+	lastWrite int64
 }
 
 func (c *conn) Read(b []byte) (int, error) {
-	if c.ReadTimeout > 0 {
-		c.c.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	// the idle timeout applies if it expires before the read timeout
+	idle := c.IdleTimeout > 0 && (c.ReadTimeout <= 0 || c.IdleTimeout < c.ReadTimeout)
+	for {
+		start := time.Now()
+		switch {
+		case idle:
+			c.c.SetReadDeadline(start.Add(c.IdleTimeout))
+		case c.ReadTimeout > 0:
+			c.c.SetReadDeadline(start.Add(c.ReadTimeout))
+		}
+		n, err := c.c.Read(b)
+
+		// the connection is not idle if data has been
+		// written while waiting for data to read.
+		if idle && n == 0 && isTimeout(err) && atomic.LoadInt64(&c.lastWrite) > start.UnixNano() {
+			continue
+		}
+		return n, err
 	}
-	return c.c.Read(b)
 }
 
 func (c *conn) Write(b []byte) (int, error) {
 	if c.WriteTimeout > 0 {
 		c.c.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
 	}
-	return c.c.Write(b)
+	n, err := c.c.Write(b)
+	if c.IdleTimeout > 0 && n > 0 {
+		atomic.StoreInt64(&c.lastWrite, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
 }
 
 func (c *conn) Close() error {
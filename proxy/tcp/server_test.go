@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnIdleTimeout(t *testing.T) {
+	t.Run("idle connection", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+		c := &conn{c: a, IdleTimeout: 50 * time.Millisecond}
+		defer c.Close()
+
+		_, err := c.Read(make([]byte, 1))
+		if !isTimeout(err) {
+			t.Fatalf("got error %v want timeout", err)
+		}
+	})
+
+	t.Run("writes keep the connection open", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+		c := &conn{c: a, IdleTimeout: 50 * time.Millisecond}
+		defer c.Close()
+
+		// the peer only reads for a while before it sends data
+		go func() {
+			go io.Copy(ioutil.Discard, b)
+			time.Sleep(200 * time.Millisecond)
+			b.Write([]byte("x"))
+		}()
+		go func() {
+			for i := 0; i < 10; i++ {
+				if _, err := c.Write([]byte("y")); err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}()
+
+		buf := make([]byte, 1)
+		if _, err := c.Read(buf); err != nil {
+			t.Fatalf("got error %v want data", err)
+		}
+		if got, want := string(buf), "x"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+}
@@ -1,12 +1,12 @@
 package tcp
 
 import (
-	"bufio"
 	"io"
 	"log"
 	"net"
 	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
 )
@@ -25,6 +25,22 @@ type SNIProxy struct {
 	// The proxy will panic if this value is nil.
 	Lookup func(host string) *route.Target
 
+	// LookupJA3 returns a target host for the given server name and
+	// the JA3 fingerprint of the client. If LookupJA3 is set the
+	// fingerprint of the ClientHello is computed and LookupJA3 is used
+	// instead of Lookup.
+	LookupJA3 func(host, ja3 string) *route.Target
+
+	// DialAttempts is the maximum number of upstream connection
+	// attempts. If the connection to the target fails another
+	// target of the route is tried. Values below 2 disable the
+	// failover.
+	DialAttempts int
+
+	// DialFailover counts the connections which were tried with
+	// another target after the connection to a target failed.
+	DialFailover metrics.Counter
+
 	// Conn counts the number of connections.
 	Conn metrics.Counter
 
@@ -33,6 +49,22 @@ type SNIProxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// ClientHelloTimeout is the maximum time for reading the
+	// ClientHello message. Zero means no timeout.
+	ClientHelloTimeout time.Duration
+
+	// MaxClientHelloSize is the maximum size of the ClientHello
+	// message in bytes. Zero means the maximum record size of 16KB.
+	MaxClientHelloSize int
+
+	// Malformed counts the connections which were closed since
+	// the ClientHello message could not be parsed.
+	Malformed metrics.Counter
+
+	// Logger writes a log entry for every connection when it is
+	// closed. Connections are not logged if Logger is nil.
+	Logger logger.Logger
 }
 
 func (p *SNIProxy) ServeTCP(in net.Conn) error {
@@ -42,40 +74,32 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		p.Conn.Inc(1)
 	}
 
-	tlsReader := bufio.NewReader(in)
-	tlsHeaders, err := tlsReader.Peek(9)
-	if err != nil {
-		log.Print("[DEBUG] tcp+sni: TLS handshake failed (failed to peek data)")
-		if p.ConnFail != nil {
-			p.ConnFail.Inc(1)
-		}
-		return err
+	var host string
+	var t *route.Target
+	if p.Logger != nil {
+		c := newCountingConn(in)
+		defer func() { logConn(p.Logger, c, host, t) }()
+		in = c
 	}
 
-	bufferSize, err := clientHelloBufferSize(tlsHeaders)
+	data, msg, err := p.readClientHello(in)
 	if err != nil {
 		log.Printf("[DEBUG] tcp+sni: TLS handshake failed (%s)", err)
-		if p.ConnFail != nil {
-			p.ConnFail.Inc(1)
+		if _, ok := err.(clientHelloError); ok && p.Malformed != nil {
+			p.Malformed.Inc(1)
 		}
-		return err
-	}
-
-	data := make([]byte, bufferSize)
-	_, err = io.ReadFull(tlsReader, data)
-	if err != nil {
-		log.Printf("[DEBUG] tcp+sni: TLS handshake failed (%s)", err)
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
 		return err
 	}
 
-	// readServerName wants only the handshake message so ignore the first
-	// 5 bytes which is the TLS record header
-	host, ok := readServerName(data[5:])
+	host, ok := readServerName(msg)
 	if !ok {
 		log.Print("[DEBUG] tcp+sni: TLS handshake failed (unable to parse client hello)")
+		if p.Malformed != nil {
+			p.Malformed.Inc(1)
+		}
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
@@ -90,25 +114,32 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		return nil
 	}
 
-	t := p.Lookup(host)
+	lookup := func() *route.Target { return p.Lookup(host) }
+	if p.LookupJA3 != nil {
+		fp, _ := JA3(msg)
+		lookup = func() *route.Target { return p.LookupJA3(host, fp) }
+	}
+
+	t = lookup()
 	if t == nil {
 		if p.Noroute != nil {
 			p.Noroute.Inc(1)
 		}
 		return nil
 	}
-	addr := t.URL.Host
-
+	t = stickyTarget(in, t)
 	if t.AccessDeniedTCP(in) {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
-	if err != nil {
-		log.Print("[WARN] tcp+sni: cannot connect to upstream ", addr)
+	var out net.Conn
+	t, out, err = dialFailover(in, t, lookup, p.DialAttempts, p.DialTimeout, func(t *route.Target, err error) {
+		log.Print("[WARN] tcp+sni: cannot connect to upstream ", t.URL.Host)
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+	}, p.DialFailover)
+	if err != nil {
 		return err
 	}
 	defer out.Close()
@@ -125,7 +156,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		}
 	}
 
-	// write the data already read from the connection
+	// write the records of the ClientHello already read from the connection
 	n, err := out.Write(data)
 	if err != nil {
 		log.Print("[WARN] tcp+sni: copy client hello failed. ", err)
@@ -142,8 +173,8 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 
 	// rx measures the traffic to the upstream server (in <- out)
 	// tx measures the traffic from the upstream server (out <- in)
-	rx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".rx")
-	tx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".tx")
+	rx := t.Counter(".rx")
+	tx := t.Counter(".tx")
 
 	// we've received the ClientHello already
 	rx.Inc(int64(n))
@@ -157,3 +188,27 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 	}
 	return nil
 }
+
+// readClientHello reads the ClientHello message from the connection
+// within the ClientHelloTimeout. It returns the data which has been
+// read and the handshake message.
+func (p *SNIProxy) readClientHello(in net.Conn) (data, msg []byte, err error) {
+	max := p.MaxClientHelloSize
+	if max <= 0 {
+		max = 16384
+	}
+	if p.ClientHelloTimeout <= 0 {
+		return readClientHello(in, max)
+	}
+
+	// abort a pending read when the timeout expires. A deadline which
+	// is set before reading would be replaced by the read timeout of
+	// the listener.
+	timer := time.AfterFunc(p.ClientHelloTimeout, func() {
+		in.SetReadDeadline(time.Unix(1, 0))
+	})
+	data, msg, err = readClientHello(in, max)
+	timer.Stop()
+	in.SetReadDeadline(time.Time{})
+	return data, msg, err
+}
@@ -0,0 +1,26 @@
+package tcp
+
+import (
+	"net"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// stickyTarget returns the target for the source IP address of the
+// client if the route of t has the 'sticky=sourceip' option so that
+// reconnecting clients get the same upstream server while the targets
+// of the route do not change. Otherwise, or if all targets have been
+// ejected, t is returned. The failover to another target is not sticky.
+func stickyTarget(in net.Conn, t *route.Target) *route.Target {
+	if !t.StickySourceIP {
+		return t
+	}
+	ip, _, err := net.SplitHostPort(in.RemoteAddr().String())
+	if err != nil {
+		return t
+	}
+	if s := route.PickSticky(t, ip); s != nil {
+		return s
+	}
+	return t
+}
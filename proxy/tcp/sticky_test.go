@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// addrConn is a connection with a fixed remote address.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestStickyTarget(t *testing.T) {
+	s := `
+	route add svc :1234 tcp://1.1.1.1:1 opts "sticky=sourceip"
+	route add svc :1234 tcp://1.1.1.1:2 opts "sticky=sourceip"
+	route add svc :1234 tcp://1.1.1.1:3 opts "sticky=sourceip"
+	route add other :2345 tcp://1.1.1.1:1
+	route add other :2345 tcp://1.1.1.1:2
+	`
+	tbl, err := route.NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := func(ip string, port int) net.Conn {
+		return &addrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+	}
+
+	// reconnects from the same client get the same target
+	// regardless of the target the picker selects
+	want := stickyTarget(conn("10.0.0.1", 1000), tbl.LookupHost(":1234", route.Picker["rr"]))
+	for port := 1001; port < 1010; port++ {
+		got := stickyTarget(conn("10.0.0.1", port), tbl.LookupHost(":1234", route.Picker["rr"]))
+		if got != want {
+			t.Fatalf("got %s want %s", got.URL, want.URL)
+		}
+	}
+
+	// routes without the option use the picked target
+	tg := tbl.LookupHost(":2345", route.Picker["rr"])
+	if got := stickyTarget(conn("10.0.0.1", 1000), tg); got != tg {
+		t.Fatalf("got %s want %s", got.URL, tg.URL)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
 )
@@ -20,6 +21,16 @@ type DynamicProxy struct {
 	// The proxy will panic if this value is nil.
 	Lookup func(host string) *route.Target
 
+	// DialAttempts is the maximum number of upstream connection
+	// attempts. If the connection to the target fails another
+	// target of the route is tried. Values below 2 disable the
+	// failover.
+	DialAttempts int
+
+	// DialFailover counts the connections which were tried with
+	// another target after the connection to a target failed.
+	DialFailover metrics.Counter
+
 	// Conn counts the number of connections.
 	Conn metrics.Counter
 
@@ -28,6 +39,10 @@ type DynamicProxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// Logger writes a log entry for every connection when it is
+	// closed. Connections are not logged if Logger is nil.
+	Logger logger.Logger
 }
 
 func (p *DynamicProxy) ServeTCP(in net.Conn) error {
@@ -36,14 +51,23 @@ func (p *DynamicProxy) ServeTCP(in net.Conn) error {
 	if p.Conn != nil {
 		p.Conn.Inc(1)
 	}
+
+	var t *route.Target
+	if p.Logger != nil {
+		c := newCountingConn(in)
+		defer func() { logConn(p.Logger, c, "", t) }()
+		in = c
+	}
+
 	target := in.LocalAddr().String()
-	t := p.Lookup(target)
+	t = p.Lookup(target)
 	if t == nil {
 		if p.Noroute != nil {
 			p.Noroute.Inc(1)
 		}
 		return nil
 	}
+	t = stickyTarget(in, t)
 	addr := t.URL.Host
 	log.Printf("[DEBUG]  Connection: %s incoming %s to %s: ", in.RemoteAddr(), target, addr)
 
@@ -51,12 +75,16 @@ func (p *DynamicProxy) ServeTCP(in net.Conn) error {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
-	if err != nil {
-		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
+	var out net.Conn
+	var err error
+	lookup := func() *route.Target { return p.Lookup(target) }
+	t, out, err = dialFailover(in, t, lookup, p.DialAttempts, p.DialTimeout, func(t *route.Target, err error) {
+		log.Print("[WARN] tcp: cannot connect to upstream ", t.URL.Host)
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+	}, p.DialFailover)
+	if err != nil {
 		return err
 	}
 	defer out.Close()
@@ -68,8 +96,8 @@ func (p *DynamicProxy) ServeTCP(in net.Conn) error {
 
 	// rx measures the traffic to the upstream server (in <- out)
 	// tx measures the traffic from the upstream server (out <- in)
-	rx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".rx")
-	tx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".tx")
+	rx := t.Counter(".rx")
+	tx := t.Counter(".tx")
 
 	go cp(in, out, rx)
 	go cp(out, in, tx)
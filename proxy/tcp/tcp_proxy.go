@@ -1,11 +1,15 @@
 package tcp
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"strconv"
 	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
 )
@@ -20,6 +24,16 @@ type Proxy struct {
 	// The proxy will panic if this value is nil.
 	Lookup func(host string) *route.Target
 
+	// DialAttempts is the maximum number of upstream connection
+	// attempts. If the connection to the target fails another
+	// target of the route is tried. Values below 2 disable the
+	// failover.
+	DialAttempts int
+
+	// DialFailover counts the connections which were tried with
+	// another target after the connection to a target failed.
+	DialFailover metrics.Counter
+
 	// Conn counts the number of connections.
 	Conn metrics.Counter
 
@@ -28,6 +42,36 @@ type Proxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// FrameErr counts the connections which were closed since
+	// they violated the framing of the route.
+	FrameErr metrics.Counter
+
+	// Reconnect counts the upstream connections which were established
+	// again since the upstream server closed the connection before it
+	// sent any data. See the 'tcpreconnect' option.
+	Reconnect metrics.Counter
+
+	// ConnLimit limits the number of active connections per client IP
+	// for all routes and for the routes with the 'maxconnperip'
+	// option. Connections are not limited if ConnLimit is nil.
+	ConnLimit *ConnLimiter
+
+	// RejectMaxConnPerIP counts the connections which were refused
+	// since the client reached the limit of ConnLimit.Max.
+	RejectMaxConnPerIP metrics.Counter
+
+	// RejectRouteMaxConnPerIP counts the connections which were refused
+	// since the client reached the limit of the 'maxconnperip' option.
+	RejectRouteMaxConnPerIP metrics.Counter
+
+	// RejectMaxIPs counts the connections which were refused since
+	// ConnLimit tracks the maximum number of client IPs.
+	RejectMaxIPs metrics.Counter
+
+	// Logger writes a log entry for every connection when it is
+	// closed. Connections are not logged if Logger is nil.
+	Logger logger.Logger
 }
 
 func (p *Proxy) ServeTCP(in net.Conn) error {
@@ -37,59 +81,202 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 		p.Conn.Inc(1)
 	}
 
+	var t *route.Target
+	if p.Logger != nil {
+		c := newCountingConn(in)
+		defer func() { logConn(p.Logger, c, "", t) }()
+		in = c
+	}
+
+	var ip string
+	if p.ConnLimit != nil {
+		ip = clientIP(in)
+		if !p.limitConn(ip, p.ConnLimit.Max, p.RejectMaxConnPerIP) {
+			return nil
+		}
+		defer p.ConnLimit.release(ip)
+	}
+
 	_, port, _ := net.SplitHostPort(in.LocalAddr().String())
 	port = ":" + port
-	t := p.Lookup(port)
+	t = p.Lookup(port)
 	if t == nil {
 		if p.Noroute != nil {
 			p.Noroute.Inc(1)
 		}
 		return nil
 	}
-	addr := t.URL.Host
-
+	t = stickyTarget(in, t)
 	if t.AccessDeniedTCP(in) {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
-	if err != nil {
-		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
+	if p.ConnLimit != nil {
+		if max := routeMaxConnPerIP(t); max > 0 {
+			key := t.Prefix + " " + ip
+			if !p.limitConn(key, max, p.RejectRouteMaxConnPerIP) {
+				return nil
+			}
+			defer p.ConnLimit.release(key)
+		}
+	}
+
+	var out net.Conn
+	var err error
+	lookup := func() *route.Target { return p.Lookup(port) }
+	t, out, err = dialFailover(in, t, lookup, p.DialAttempts, p.DialTimeout, func(t *route.Target, err error) {
+		log.Print("[WARN] tcp: cannot connect to upstream ", t.URL.Host)
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+	}, p.DialFailover)
+	if err != nil {
 		return err
 	}
 	defer out.Close()
 
 	// enable PROXY protocol support on outbound connection
-	if t.ProxyProto {
-		err := WriteProxyHeader(out, in)
-		if err != nil {
-			log.Print("[WARN] tcp: write proxy protocol header failed. ", err)
-			if p.ConnFail != nil {
-				p.ConnFail.Inc(1)
+	if err := p.writeProxyHeader(t, in, out); err != nil {
+		return err
+	}
+
+	if attempts, delay := reconnectOpts(t); attempts > 0 {
+		dial := func() (net.Conn, error) {
+			out, err := Dial(t, t.URL.Host, p.DialTimeout)
+			if err != nil {
+				if p.ConnFail != nil {
+					p.ConnFail.Inc(1)
+				}
+				return nil, err
 			}
-			return err
+			if err := p.writeProxyHeader(t, in, out); err != nil {
+				out.Close()
+				return nil, err
+			}
+			return out, nil
+		}
+		return RelayReconnect(t, in, out, attempts, delay, dial, p.Reconnect)
+	}
+	return Relay(t, in, out, p.FrameErr)
+}
+
+// limitConn adds the connection to the active connections of key and
+// returns true if there are less than max of them. Otherwise, the
+// refused connection is counted in perIP or in RejectMaxIPs.
+func (p *Proxy) limitConn(key string, max int, perIP metrics.Counter) bool {
+	err := p.ConnLimit.acquire(key, max)
+	if err == nil {
+		return true
+	}
+	log.Printf("[DEBUG] tcp: refusing connection for %s. %s", key, err)
+	c := perIP
+	if err == errMaxIPs {
+		c = p.RejectMaxIPs
+	}
+	if c != nil {
+		c.Inc(1)
+	}
+	return false
+}
+
+// writeProxyHeader sends the PROXY protocol header to the upstream
+// server if the target has the 'pxyproto' option.
+func (p *Proxy) writeProxyHeader(t *route.Target, in, out net.Conn) error {
+	if !t.ProxyProto {
+		return nil
+	}
+	err := WriteProxyHeader(out, in)
+	if err != nil {
+		log.Print("[WARN] tcp: write proxy protocol header failed. ", err)
+		if p.ConnFail != nil {
+			p.ConnFail.Inc(1)
 		}
 	}
+	return err
+}
 
+// Relay copies the data between the client connection in and the
+// upstream connection out of the target until one side closes the
+// connection. The traffic is counted in the '.rx' and '.tx' metrics
+// of the target and the frames are inspected if the route has the
+// 'framing' option. frameErr counts the connections which are closed
+// because they violate the framing.
+//
+// With the 'halfclose' option the end of the data of one side is
+// forwarded to the other side with a half-close and the data of the
+// other direction is relayed until it ends as well. If a connection
+// does not support half-close both connections are closed.
+func Relay(t *route.Target, in, out net.Conn, frameErr metrics.Counter) error {
+	halfClose := t.Opts["halfclose"] == "true"
 	errc := make(chan error, 2)
-	cp := func(dst io.Writer, src io.Reader, c metrics.Counter) {
-		errc <- copyBuffer(dst, src, c)
+	cp := func(dst net.Conn, src io.Reader, c metrics.Counter) {
+		err := copyBuffer(dst, src, c)
+		if err == nil && halfClose {
+			err = closeWrite(dst)
+		}
+		errc <- err
 	}
 
 	// rx measures the traffic to the upstream server (in <- out)
 	// tx measures the traffic from the upstream server (out <- in)
-	rx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".rx")
-	tx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".tx")
+	rx := t.Counter(".rx")
+	tx := t.Counter(".tx")
+
+	// inspect the frames in both directions if the route has framing
+	var inr, outr io.Reader = in, out
+	if framing := t.Opts["framing"]; framing != "" && framing != "none" {
+		var err error
+		inr, outr, err = inspectFrames(t, in, out)
+		if err != nil {
+			log.Printf("[WARN] tcp: invalid framing for route %s. %s", t.Prefix, err)
+			return err
+		}
+	}
 
-	go cp(in, out, rx)
-	go cp(out, in, tx)
-	err = <-errc
+	go cp(in, outr, rx)
+	go cp(out, inr, tx)
+	err := <-errc
+	if halfClose && err == nil {
+		err = <-errc
+	}
+	if err == errNoHalfClose {
+		return nil
+	}
+	if errors.Is(err, ErrFrameTooLarge) {
+		log.Printf("[WARN] tcp: closing connection from %s for route %s. %s", in.RemoteAddr(), t.Prefix, err)
+		if frameErr != nil {
+			frameErr.Inc(1)
+		}
+		return err
+	}
 	if err != nil && err != io.EOF {
 		log.Print("[WARN]: tcp:  ", err)
 		return err
 	}
 	return nil
 }
+
+// inspectFrames returns readers for both connections which enforce the
+// framing of the route and count the frames in the '.rx.frames' and
+// '.tx.frames' metrics of the target.
+func inspectFrames(t *route.Target, in, out net.Conn) (inr, outr io.Reader, err error) {
+	var max int64
+	if v := t.Opts["maxframesize"]; v != "" {
+		if max, err = strconv.ParseInt(v, 10, 64); err != nil || max < 0 {
+			return nil, nil, fmt.Errorf("invalid maxframesize %q", v)
+		}
+	}
+
+	rxFrames := t.Counter(".rx.frames")
+	txFrames := t.Counter(".tx.frames")
+
+	fin, err := NewFrameInspector(t.Opts["framing"], max, txFrames)
+	if err != nil {
+		return nil, nil, err
+	}
+	fout, err := NewFrameInspector(t.Opts["framing"], max, rxFrames)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &inspectReader{r: in, f: fin}, &inspectReader{r: out, f: fout}, nil
+}
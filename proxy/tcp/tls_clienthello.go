@@ -1,48 +1,69 @@
 package tcp
 
-import "errors"
+import "io"
 
-// Determines the required size of a buffer large enough to hold
-// a client hello message including the tls record header and the
-// handshake message header.
-// The function requires at least the first 9 bytes of the tls conversation
-// in "data".
-// An error is returned if the data does not follow the
-// specification (https://tools.ietf.org/html/rfc5246) or if the client hello
-// is fragmented over multiple records.
-func clientHelloBufferSize(data []byte) (int, error) {
-	// TLS record header
-	// -----------------
-	// byte   0: rec type (should be 0x16 == Handshake)
-	// byte 1-2: version (should be 0x3000 < v < 0x3003)
-	// byte 3-4: rec len
-	if len(data) < 9 {
-		return 0, errors.New("At least 9 bytes required to determine client hello length")
-	}
+// clientHelloError describes a ClientHello message which does
+// not follow the specification.
+type clientHelloError string
 
-	if data[0] != 0x16 {
-		return 0, errors.New("Not a TLS handshake")
-	}
+func (e clientHelloError) Error() string { return string(e) }
 
-	recordLength := int(data[3])<<8 | int(data[4])
-	if recordLength <= 0 || recordLength > 16384 {
-		return 0, errors.New("Invalid TLS record length")
-	}
+// readClientHello reads the TLS records with the ClientHello message
+// from r. The message may be fragmented over multiple records which
+// are reassembled. It returns the data which has been read, i.e. the
+// records including their headers, and the handshake message including
+// the 4 byte handshake message header.
+// A clientHelloError is returned if the data does not follow the
+// specification (https://tools.ietf.org/html/rfc5246) or if the
+// handshake message is larger than max bytes.
+func readClientHello(r io.Reader, max int) (raw, msg []byte, err error) {
+	var hdr [5]byte
+	for {
+		// TLS record header
+		// -----------------
+		// byte   0: rec type (should be 0x16 == Handshake)
+		// byte 1-2: version (should be 0x3000 < v < 0x3003)
+		// byte 3-4: rec len
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, nil, err
+		}
+		if hdr[0] != 0x16 {
+			return nil, nil, clientHelloError("Not a TLS handshake")
+		}
+		recordLength := int(hdr[3])<<8 | int(hdr[4])
+		if recordLength <= 0 || recordLength > 16384 {
+			return nil, nil, clientHelloError("Invalid TLS record length")
+		}
 
-	// Handshake record header
-	// -----------------------
-	// byte   5: hs msg type (should be 0x01 == client_hello)
-	// byte 6-8: hs msg len
-	if data[5] != 0x01 {
-		return 0, errors.New("Not a client hello")
-	}
+		rec := make([]byte, 5+recordLength)
+		copy(rec, hdr[:])
+		if _, err := io.ReadFull(r, rec[5:]); err != nil {
+			return nil, nil, err
+		}
+		raw = append(raw, rec...)
+		msg = append(msg, rec[5:]...)
 
-	handshakeLength := int(data[6])<<16 | int(data[7])<<8 | int(data[8])
-	if handshakeLength <= 0 || handshakeLength > recordLength-4 {
-		return 0, errors.New("Invalid client hello length (fragmentation not implemented)")
+		// Handshake record header
+		// -----------------------
+		// byte   0: hs msg type (should be 0x01 == client_hello)
+		// byte 1-3: hs msg len
+		if len(msg) < 4 {
+			continue
+		}
+		if msg[0] != 0x01 {
+			return nil, nil, clientHelloError("Not a client hello")
+		}
+		handshakeLength := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		if handshakeLength <= 0 {
+			return nil, nil, clientHelloError("Invalid client hello length")
+		}
+		if handshakeLength+4 > max {
+			return nil, nil, clientHelloError("Client hello too large")
+		}
+		if len(msg) >= handshakeLength+4 {
+			return raw, msg[:handshakeLength+4], nil
+		}
 	}
-
-	return handshakeLength + 9, nil //9 for the header bytes
 }
 
 // readServerName returns the server name from a TLS ClientHello message which
@@ -53,6 +74,13 @@ func clientHelloBufferSize(data []byte) (int, error) {
 // message including the 4 byte header.
 // See: https://www.ietf.org/rfc/rfc5246.txt
 func readServerName(clientHelloHandshakeMsg []byte) (serverName string, ok bool) {
+	// treat a panic of the parser on malformed data as a parse error
+	defer func() {
+		if recover() != nil {
+			serverName, ok = "", false
+		}
+	}()
+
 	m := new(clientHelloMsg)
 	if !m.unmarshal(clientHelloHandshakeMsg) {
 		//println("client_hello unmarshal failed")
@@ -1,91 +1,161 @@
 package tcp
 
 import (
+	"bytes"
 	"encoding/hex"
+	"io"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
 )
 
-func TestClientHelloBufferSize(t *testing.T) {
+func TestReadClientHello(t *testing.T) {
+	// record returns a TLS handshake record with the payload
+	record := func(payload ...byte) []byte {
+		return append([]byte{0x16, 0x03, 0x01, byte(len(payload) >> 8), byte(len(payload))}, payload...)
+	}
+	join := func(b ...[]byte) []byte { return bytes.Join(b, nil) }
+
+	// client hello handshake message with 6 bytes of data
+	hello := []byte{0x01, 0x00, 0x00, 0x06, 1, 2, 3, 4, 5, 6}
+
 	tests := []struct {
-		name string
-		data []byte
-		size int
-		fail bool
+		name      string
+		data      []byte
+		max       int
+		raw, msg  []byte
+		malformed bool
+		err       error
 	}{
 		{
 			name: "valid data",
-			// Largest possible client hello message
-			//                            |- 16384 -|      |----- 16380 ----|
-			data: []byte{0x16, 0x03, 0x01, 0x40, 0x00, 0x01, 0x00, 0x3f, 0xfc},
-			size: 16384 + 5, // max record length + record header
-			fail: false,
+			data: record(hello...),
+			raw:  record(hello...),
+			msg:  hello,
+		},
+		{
+			name: "fragmented over multiple records",
+			data: join(record(hello[:2]...), record(hello[2:7]...), record(hello[7:]...)),
+			raw:  join(record(hello[:2]...), record(hello[2:7]...), record(hello[7:]...)),
+			msg:  hello,
+		},
+		{
+			name: "data after the client hello is not read",
+			data: join(record(hello...), []byte{0x17, 0x03, 0x03}),
+			raw:  record(hello...),
+			msg:  hello,
 		},
 		{
 			name: "not enough data",
-			data: []byte{0x16, 0x03, 0x01, 0x40, 0x00, 0x01, 0x00, 0x3f},
-			size: 0,
-			fail: true,
+			data: record(hello...)[:8],
+			err:  io.ErrUnexpectedEOF,
 		},
 		{
-			name: "not a TLS record",
-			data: []byte{0x15, 0x03, 0x01, 0x01, 0xF4, 0x01, 0x00, 0x01, 0xeb},
-			size: 0,
-			fail: true,
+			name: "incomplete fragmented client hello",
+			data: record(hello[:5]...),
+			err:  io.EOF,
+		},
+		{
+			name:      "not a TLS record",
+			data:      []byte{0x15, 0x03, 0x01, 0x01, 0xF4, 0x01, 0x00, 0x01, 0xeb},
+			malformed: true,
 		},
-
 		{
 			name: "TLS record too large",
-			//                             | max + 1 |
-			data: []byte{0x16, 0x03, 0x01, 0x40, 0x01, 0x01, 0x00, 0x3f, 0xfc},
-			size: 0,
-			fail: true,
+			//                                  | max + 1 |
+			data:      []byte{0x16, 0x03, 0x01, 0x40, 0x01, 0x01, 0x00, 0x3f, 0xfc},
+			malformed: true,
 		},
-
 		{
 			name: "TLS record length zero",
-			//                            |----------|
-			data: []byte{0x16, 0x03, 0x01, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xfc},
-			size: 0,
-			fail: true,
+			//                                 |----------|
+			data:      []byte{0x16, 0x03, 0x01, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xfc},
+			malformed: true,
 		},
-
 		{
-			name: "Not a client hello",
-			//                                        |----|
-			data: []byte{0x16, 0x03, 0x01, 0x40, 0x00, 0x02, 0x00, 0x3f, 0xfc},
-			size: 0,
-			fail: true,
+			name:      "Not a client hello",
+			data:      record(0x02, 0x00, 0x00, 0x01, 0x00),
+			malformed: true,
 		},
-
 		{
-			name: "Invalid handshake message record length",
-			//                                              |----- 0 --------|
-			data: []byte{0x16, 0x03, 0x01, 0x40, 0x00, 0x01, 0x00, 0x00, 0x00},
-			size: 0,
-			fail: true,
+			name:      "Invalid handshake message length",
+			data:      record(0x01, 0x00, 0x00, 0x00),
+			malformed: true,
 		},
-
 		{
-			name: "Fragmentation (handshake message larger than record)",
-			//                            |-  500 ---|      |----- 497 ------|
-			data: []byte{0x16, 0x03, 0x01, 0x01, 0xF4, 0x01, 0x00, 0x01, 0xf1},
-			size: 0,
-			fail: true,
+			name:      "client hello larger than max",
+			data:      record(hello...),
+			max:       len(hello) - 1,
+			malformed: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := clientHelloBufferSize(tt.data)
+			max := tt.max
+			if max == 0 {
+				max = 16384
+			}
+			raw, msg, err := readClientHello(bytes.NewReader(tt.data), max)
+			if _, ok := err.(clientHelloError); ok != tt.malformed {
+				t.Fatalf("got error %v want malformed %t", err, tt.malformed)
+			}
+			if !tt.malformed && err != tt.err {
+				t.Fatalf("got error %v want %v", err, tt.err)
+			}
+			if !bytes.Equal(raw, tt.raw) {
+				t.Fatalf("got data %x want %x", raw, tt.raw)
+			}
+			if !bytes.Equal(msg, tt.msg) {
+				t.Fatalf("got message %x want %x", msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestSNIProxyMalformedClientHello(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		malformed int64
+	}{
+		{"garbage", []byte("GET / HTTP/1.1\r\n\r\n"), 1},
+		{"unparseable client hello", []byte{0x16, 0x03, 0x01, 0x00, 0x05, 0x01, 0x00, 0x00, 0x01, 0x00}, 1},
+		{"timeout", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			malformed, connfail := &counter{}, &counter{}
+			p := &SNIProxy{
+				Lookup:             func(string) *route.Target { panic("unexpected lookup") },
+				ClientHelloTimeout: 50 * time.Millisecond,
+				Malformed:          malformed,
+				ConnFail:           connfail,
+			}
 
-			if tt.fail && err == nil {
-				t.Fatal("expected error, got nil")
-			} else if !tt.fail && err != nil {
-				t.Fatalf("expected error to be nil, got %s", err)
+			in, c := net.Pipe()
+			defer c.Close()
+			go c.Write(tt.data)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p.ServeTCP(in)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("connection not closed")
 			}
 
-			if want := tt.size; got != want {
-				t.Fatalf("want size %d, got %d", want, got)
+			if got, want := malformed.n, tt.malformed; got != want {
+				t.Fatalf("got %d malformed want %d", got, want)
+			}
+			if got, want := connfail.n, int64(1); got != want {
+				t.Fatalf("got %d connfail want %d", got, want)
 			}
 		})
 	}
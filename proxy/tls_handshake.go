@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// handshakeListener is a TLS listener which completes the TLS handshake
+// of the accepted connections before they are returned by Accept.
+// Connections which do not complete the handshake within the timeout
+// are closed so that slow clients cannot hold on to the resources of
+// the server. The handshakes run concurrently so that a slow client
+// does not block the other connections. The deadline for the handshake
+// is cleared afterwards and does not affect the read and write timeouts
+// of the requests.
+//
+// If a handshake limiter is set the handshakes wait for a free slot of
+// the limiter. The time in the queue counts towards the timeout. If stats
+// is set the duration and the failures of the handshakes are recorded.
+type handshakeListener struct {
+	net.Listener
+
+	cfg      *tls.Config
+	timeout  time.Duration
+	timeouts metrics.Counter
+	limit    *HandshakeLimiter
+	stats    *handshakeStats
+
+	conns chan net.Conn
+	errs  chan error
+
+	// done is closed when the underlying listener fails
+	// permanently, e.g. when it has been closed.
+	done chan struct{}
+	err  error
+	once sync.Once
+}
+
+func newHandshakeListener(ln net.Listener, cfg *tls.Config, timeout time.Duration, timeouts metrics.Counter, limit *HandshakeLimiter, stats *handshakeStats) *handshakeListener {
+	hl := &handshakeListener{
+		Listener: ln,
+		cfg:      cfg,
+		timeout:  timeout,
+		timeouts: timeouts,
+		limit:    limit,
+		stats:    stats,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go hl.run()
+	return hl
+}
+
+func (ln *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case err := <-ln.errs:
+		return nil, err
+	case <-ln.done:
+		return nil, ln.err
+	}
+}
+
+func (ln *handshakeListener) run() {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				select {
+				case ln.errs <- err:
+					continue
+				case <-ln.done:
+					return
+				}
+			}
+			ln.once.Do(func() {
+				ln.err = err
+				close(ln.done)
+			})
+			return
+		}
+		go ln.handshake(c)
+	}
+}
+
+func (ln *handshakeListener) handshake(c net.Conn) {
+	var deadline time.Time
+	if ln.timeout > 0 {
+		deadline = time.Now().Add(ln.timeout)
+	}
+
+	if ln.limit != nil {
+		ok, timedOut := ln.limit.acquire(deadline)
+		if !ok {
+			if timedOut && ln.timeouts != nil {
+				ln.timeouts.Inc(1)
+			}
+			log.Printf("[DEBUG] TLS handshake with %s dropped since too many handshakes are in progress", c.RemoteAddr())
+			c.Close()
+			return
+		}
+		defer ln.limit.release()
+	}
+
+	tc := tls.Server(c, ln.cfg)
+	tc.SetDeadline(deadline)
+	start := time.Now()
+	err := tc.Handshake()
+	ln.stats.record(tc, time.Since(start), err)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if ln.timeouts != nil {
+				ln.timeouts.Inc(1)
+			}
+			log.Printf("[DEBUG] TLS handshake with %s timed out", c.RemoteAddr())
+		} else {
+			log.Printf("[DEBUG] TLS handshake with %s failed. %s", c.RemoteAddr(), err)
+		}
+		tc.Close()
+		return
+	}
+	tc.SetDeadline(time.Time{})
+
+	select {
+	case ln.conns <- tc:
+	case <-ln.done:
+		tc.Close()
+	}
+}
+
+// handshakeStats records the duration of the full and the resumed TLS
+// handshakes of a listener and counts the failed handshakes by reason.
+type handshakeStats struct {
+	full, resumed metrics.Timer
+
+	// failed are the counters of the failure reasons.
+	failed map[string]metrics.Counter
+}
+
+// handshakeFailures are the reasons of the failed handshakes.
+var handshakeFailures = []string{"timeout", "nocert", "clientauth", "protocol", "closed", "other"}
+
+// handshakeListenerName replaces the characters of the listener
+// address which are separators in the metric names.
+var handshakeListenerName = strings.NewReplacer(".", "_", ":", "_")
+
+// newHandshakeStats returns the handshake metrics of the listener at addr
+// which are tls.handshake.<addr>.full, tls.handshake.<addr>.resumed and
+// tls.handshake.<addr>.failed.<reason>.
+func newHandshakeStats(r metrics.Registry, addr string) *handshakeStats {
+	prefix := "tls.handshake." + handshakeListenerName.Replace(addr)
+	s := &handshakeStats{
+		full:    r.GetTimer(prefix + ".full"),
+		resumed: r.GetTimer(prefix + ".resumed"),
+		failed:  map[string]metrics.Counter{},
+	}
+	for _, reason := range handshakeFailures {
+		s.failed[reason] = r.GetCounter(prefix + ".failed." + reason)
+	}
+	return s
+}
+
+// record updates the metrics for a handshake which took d.
+func (s *handshakeStats) record(tc *tls.Conn, d time.Duration, err error) {
+	switch {
+	case s == nil:
+	case err != nil:
+		s.failed[handshakeFailure(err)].Inc(1)
+	case tc.ConnectionState().DidResume:
+		s.resumed.Update(d)
+	default:
+		s.full.Update(d)
+	}
+}
+
+// handshakeFailure returns the reason of the failed handshake. The
+// errors of the tls package are not exported and are matched by their
+// messages.
+func handshakeFailure(err error) string {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return "timeout"
+	}
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "closed"
+	}
+	msg := err.Error()
+	switch {
+	// no certificate for the server name
+	case strings.Contains(msg, "no certificates"):
+		return "nocert"
+	// missing, invalid or revoked client certificate
+	case strings.Contains(msg, "certificate"):
+		return "clientauth"
+	// no common version, cipher suite, curve or application protocol
+	case strings.Contains(msg, "unsupported"),
+		strings.Contains(msg, "supported by both"),
+		strings.Contains(msg, "does not look like a TLS handshake"):
+		return "protocol"
+	case strings.Contains(msg, "connection reset"), strings.Contains(msg, "broken pipe"):
+		return "closed"
+	default:
+		return "other"
+	}
+}
+
+// HandshakeLimiter bounds the number of concurrent TLS handshakes so that
+// a flood of new connections does not starve the established connections
+// of CPU. Handshakes over the limit wait in a queue of limited size and
+// are dropped when the queue is full.
+type HandshakeLimiter struct {
+	sem   chan struct{}
+	queue int64
+
+	// waiting is the number of handshakes in the queue.
+	// It is accessed atomically.
+	waiting int64
+
+	// queued tracks the number of handshakes in the queue
+	// and shed counts the handshakes which were dropped
+	// because the queue was full.
+	queued metrics.Counter
+	shed   metrics.Counter
+}
+
+// NewHandshakeLimiter returns a limiter for max concurrent handshakes with
+// a queue for up to queue handshakes. queued is incremented and decremented
+// with the number of waiting handshakes and shed counts the dropped
+// handshakes. Both metrics can be nil.
+func NewHandshakeLimiter(max, queue int, queued, shed metrics.Counter) *HandshakeLimiter {
+	return &HandshakeLimiter{
+		sem:    make(chan struct{}, max),
+		queue:  int64(queue),
+		queued: queued,
+		shed:   shed,
+	}
+}
+
+// acquire waits for a free handshake slot until the deadline. A zero
+// deadline means no timeout. It returns false if the queue is full or
+// timedOut is true if the deadline passed while waiting.
+func (l *HandshakeLimiter) acquire(deadline time.Time) (ok, timedOut bool) {
+	select {
+	case l.sem <- struct{}{}:
+		return true, false
+	default:
+	}
+
+	if atomic.AddInt64(&l.waiting, 1) > l.queue {
+		atomic.AddInt64(&l.waiting, -1)
+		if l.shed != nil {
+			l.shed.Inc(1)
+		}
+		return false, false
+	}
+	if l.queued != nil {
+		l.queued.Inc(1)
+	}
+	defer func() {
+		atomic.AddInt64(&l.waiting, -1)
+		if l.queued != nil {
+			l.queued.Inc(-1)
+		}
+	}()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true, false
+	case <-timeout:
+		return false, true
+	}
+}
+
+// release frees the handshake slot.
+func (l *HandshakeLimiter) release() {
+	<-l.sem
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/fabiolb/fabio/proxy/tcp"
+)
+
+// JA3 enables the JA3 fingerprints of the TLS clients of the HTTPS
+// listeners. It is set by the caller before the listeners are started.
+var JA3 bool
+
+// ja3Listener returns connections which compute the JA3 fingerprint
+// of the ClientHello message of the client.
+type ja3Listener struct {
+	net.Listener
+}
+
+func (ln ja3Listener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &ja3Conn{Conn: c}, nil
+}
+
+// ja3Conn records the ClientHello message which is read by the TLS
+// server during the handshake and computes the JA3 fingerprint when
+// the message is complete. The data is only buffered until then.
+type ja3Conn struct {
+	net.Conn
+
+	mu   sync.Mutex
+	buf  []byte
+	done bool
+	fp   string
+}
+
+func (c *ja3Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	if !c.done && n > 0 {
+		c.buf = append(c.buf, b[:n]...)
+		if fp, more := tcp.ParseJA3(c.buf); !more {
+			c.fp, c.done, c.buf = fp, true, nil
+		}
+	}
+	if err != nil {
+		c.done, c.buf = true, nil
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+// JA3 returns the fingerprint of the client or an empty string
+// if the ClientHello message was not complete or invalid.
+func (c *ja3Conn) JA3() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fp
+}
+
+// RequestJA3 returns the JA3 fingerprint of the TLS client of the request
+// or an empty string if the request was not received via TLS or JA3 is
+// not enabled.
+func RequestJA3(r *http.Request) string {
+	c, _ := r.Context().Value(connKey{}).(net.Conn)
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	jc, ok := tc.NetConn().(*ja3Conn)
+	if !ok {
+		return ""
+	}
+	return jc.JA3()
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyJA3Header(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-TLS-JA3")
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewUnstartedServer(&HTTPProxy{
+		Config:    config.Proxy{JA3: true, JA3Header: "X-TLS-JA3"},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	proxy.Listener = ja3Listener{proxy.Listener}
+	proxy.Config.ConnContext = connContext
+	proxy.StartTLS()
+	defer proxy.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req.Header.Set("X-TLS-JA3", "spoofed")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(got) != 32 {
+		t.Fatalf("got fingerprint %q want md5 hash", got)
+	}
+
+	// the fingerprint is the same for the same client
+	prev := got
+	client.CloseIdleConnections()
+	resp, err = client.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != prev {
+		t.Fatalf("got fingerprint %q want %q", got, prev)
+	}
+
+	// plain HTTP requests have no fingerprint
+	plain := httptest.NewServer(proxy.Config.Handler)
+	defer plain.Close()
+	req, _ = http.NewRequest("GET", plain.URL, nil)
+	req.Header.Set("X-TLS-JA3", "spoofed")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "" {
+		t.Fatalf("got fingerprint %q want none", got)
+	}
+}
@@ -0,0 +1,56 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// JSONEnvelope wraps JSON request bodies in an object with the body
+// as the value of Key and unwraps JSON responses with this format.
+// Bodies which are not JSON are passed unchanged.
+//
+//	{"name":"foo"} <-> {"data":{"name":"foo"}}
+type JSONEnvelope struct {
+	Key string
+}
+
+func (e JSONEnvelope) TransformRequest(body []byte, contentType string) ([]byte, string, error) {
+	if !isJSON(contentType) {
+		return body, contentType, nil
+	}
+	if !json.Valid(body) {
+		return nil, "", fmt.Errorf("transform: invalid JSON request body")
+	}
+	b, err := json.Marshal(map[string]json.RawMessage{e.Key: body})
+	if err != nil {
+		return nil, "", err
+	}
+	return b, contentType, nil
+}
+
+func (e JSONEnvelope) TransformResponse(body []byte, contentType string) ([]byte, string, error) {
+	if !isJSON(contentType) {
+		return body, contentType, nil
+	}
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, "", fmt.Errorf("transform: invalid JSON response body. %s", err)
+	}
+	b, ok := v[e.Key]
+	if !ok {
+		return nil, "", fmt.Errorf("transform: JSON response body has no %q field", e.Key)
+	}
+	return b, contentType, nil
+}
+
+// isJSON returns true for the application/json media type
+// and media types with the +json suffix.
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,47 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+const soapNS = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// SOAPEnvelope wraps XML request bodies in the body of a SOAP 1.1
+// envelope and unwraps the content of the body of SOAP responses.
+// The XML declaration of the request body is removed since it is
+// not allowed within the envelope. Namespace prefixes which the
+// response declares on the envelope are not copied to the content.
+type SOAPEnvelope struct{}
+
+func (SOAPEnvelope) TransformRequest(body []byte, contentType string) ([]byte, string, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("<?xml")) {
+		i := bytes.Index(body, []byte("?>"))
+		if i < 0 {
+			return nil, "", fmt.Errorf("transform: invalid XML declaration in request body")
+		}
+		body = bytes.TrimSpace(body[i+2:])
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<soap:Envelope xmlns:soap="` + soapNS + `"><soap:Body>`)
+	b.Write(body)
+	b.WriteString(`</soap:Body></soap:Envelope>`)
+	return b.Bytes(), "text/xml; charset=utf-8", nil
+}
+
+func (SOAPEnvelope) TransformResponse(body []byte, contentType string) ([]byte, string, error) {
+	var env struct {
+		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+		Body    struct {
+			Content []byte `xml:",innerxml"`
+		} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+	}
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return nil, "", fmt.Errorf("transform: invalid SOAP response body. %s", err)
+	}
+	return bytes.TrimSpace(env.Body.Content), "application/xml; charset=utf-8", nil
+}
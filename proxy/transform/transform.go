@@ -0,0 +1,35 @@
+// Package transform provides transformers which rewrite the request
+// and response bodies for upstream servers which expect a different
+// format than the clients send, e.g. legacy SOAP services.
+//
+// A route uses a transformer with the 'transform=<name>' option.
+// Custom transformers can be added to the Transformers map in an
+// init function.
+package transform
+
+// Transformer rewrites the bodies of requests and responses.
+//
+// The methods receive the complete body and its content type and
+// return the new body and content type. A transformer which only
+// changes one direction returns the body and the content type of
+// the other direction unchanged. An error from TransformRequest is
+// returned to the client as 400 Bad Request and an error from
+// TransformResponse as 502 Bad Gateway.
+type Transformer interface {
+	// TransformRequest rewrites the body of the request
+	// before it is sent to the upstream server. It is not
+	// called for requests without a body.
+	TransformRequest(body []byte, contentType string) ([]byte, string, error)
+
+	// TransformResponse rewrites the body of the response
+	// before it is sent to the client. It is not called for
+	// responses without a body.
+	TransformResponse(body []byte, contentType string) ([]byte, string, error)
+}
+
+// Transformers contains the transformers which can be
+// referenced in the 'transform' option of a route.
+var Transformers = map[string]Transformer{
+	"jsonenvelope": JSONEnvelope{Key: "data"},
+	"soapenvelope": SOAPEnvelope{},
+}
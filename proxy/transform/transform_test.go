@@ -0,0 +1,84 @@
+package transform
+
+import (
+	"testing"
+)
+
+func TestJSONEnvelope(t *testing.T) {
+	tf := Transformers["jsonenvelope"]
+
+	tests := []struct {
+		desc, ctype, in, out string
+		request              bool
+		err                  bool
+	}{
+		{"request", "application/json", `{"name":"foo"}`, `{"data":{"name":"foo"}}`, true, false},
+		{"request json suffix", "application/vnd.foo+json; charset=utf-8", `[1,2]`, `{"data":[1,2]}`, true, false},
+		{"request not json", "text/plain", `foo`, `foo`, true, false},
+		{"request invalid json", "application/json", `{`, ``, true, true},
+		{"response", "application/json", `{"data":{"name":"foo"},"version":1}`, `{"name":"foo"}`, false, false},
+		{"response not json", "text/html", `<html>`, `<html>`, false, false},
+		{"response without key", "application/json", `{"name":"foo"}`, ``, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fn := tf.TransformResponse
+			if tt.request {
+				fn = tf.TransformRequest
+			}
+			b, ctype, err := fn([]byte(tt.in), tt.ctype)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if tt.err {
+				return
+			}
+			if got, want := string(b), tt.out; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := ctype, tt.ctype; got != want {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSOAPEnvelope(t *testing.T) {
+	tf := Transformers["soapenvelope"]
+
+	b, ctype, err := tf.TransformRequest([]byte(`<?xml version="1.0"?>
+<GetPrice><Item>foo</Item></GetPrice>`), "application/xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><GetPrice><Item>foo</Item></GetPrice></soap:Body></soap:Envelope>`
+	if got := string(b); got != want {
+		t.Fatalf("got request %q want %q", got, want)
+	}
+	if got, want := ctype, "text/xml; charset=utf-8"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+
+	b, ctype, err = tf.TransformResponse([]byte(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Header/>
+  <s:Body>
+    <GetPriceResponse><Price>1.5</Price></GetPriceResponse>
+  </s:Body>
+</s:Envelope>`), "text/xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `<GetPriceResponse><Price>1.5</Price></GetPriceResponse>`; got != want {
+		t.Fatalf("got response %q want %q", got, want)
+	}
+	if got, want := ctype, "application/xml; charset=utf-8"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+
+	if _, _, err := tf.TransformResponse([]byte(`<html></html>`), "text/html"); err == nil {
+		t.Fatal("got nil want error for response without envelope")
+	}
+}
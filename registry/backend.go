@@ -1,5 +1,7 @@
 package registry
 
+import "sync/atomic"
+
 type Backend interface {
 	// Register registers fabio as a service in the registry.
 	Register(services []string) error
@@ -35,4 +37,21 @@ type Backend interface {
 	WatchNoRouteHTML() chan string
 }
 
-var Default Backend
+// defaultBackend stores the registry backend of fabio. The backend may
+// be set in the background if the registry was not available at
+// startup.
+var defaultBackend atomic.Value
+
+// backendValue wraps the backend since atomic.Value cannot store nil.
+type backendValue struct{ Backend }
+
+// Default returns the registry backend or nil if it has not been set.
+func Default() Backend {
+	v, _ := defaultBackend.Load().(backendValue)
+	return v.Backend
+}
+
+// SetDefault sets the registry backend.
+func SetDefault(be Backend) {
+	defaultBackend.Store(backendValue{be})
+}
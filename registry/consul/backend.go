@@ -134,9 +134,21 @@ func (b *be) WatchServices() chan string {
 	log.Printf("[INFO] consul: Using dynamic routes")
 	log.Printf("[INFO] consul: Using tag prefix %q", b.cfg.TagPrefix)
 
-	m := NewServiceMonitor(b.c, b.cfg, b.dc)
 	svc := make(chan string)
-	go m.Watch(svc)
+	if len(b.cfg.Datacenters) == 0 {
+		m := NewServiceMonitor(b.c, b.cfg, b.dc)
+		go m.Watch(svc)
+		return svc
+	}
+
+	var monitors []*ServiceMonitor
+	for dc, prio := range dcPriorities(b.dc, b.cfg.Datacenters) {
+		log.Printf("[INFO] consul: Watching datacenter %q with priority %d", dc, prio)
+		m := NewServiceMonitor(b.c, b.cfg, dc)
+		m.federated, m.priority = true, prio
+		monitors = append(monitors, m)
+	}
+	go watchDatacenters(monitors, svc)
 	return svc
 }
 
@@ -0,0 +1,60 @@
+package consul
+
+import (
+	"sort"
+	"strings"
+)
+
+// dcPriorities returns the priorities of the datacenters for federated
+// routing. The local datacenter has the priority 0 even if it is not
+// listed and the remote datacenters have the priorities 1, 2, ... in the
+// configured order.
+func dcPriorities(local string, dcs []string) map[string]int {
+	prio := map[string]int{local: 0}
+	for _, dc := range dcs {
+		if _, ok := prio[dc]; !ok {
+			prio[dc] = len(prio)
+		}
+	}
+	return prio
+}
+
+// watchDatacenters runs the service monitors of the datacenters and
+// sends the combined configuration of all datacenters on every change.
+// The last configuration of a datacenter is kept while its health state
+// cannot be fetched.
+func watchDatacenters(monitors []*ServiceMonitor, svc chan string) {
+	type update struct{ dc, config string }
+	updates := make(chan update)
+	for _, m := range monitors {
+		m, ch := m, make(chan string)
+		go m.Watch(ch)
+		go func() {
+			for config := range ch {
+				updates <- update{m.dc, config}
+			}
+		}()
+	}
+
+	configs := map[string]string{}
+	for u := range updates {
+		configs[u.dc] = u.config
+		svc <- combineConfigs(configs)
+	}
+}
+
+// combineConfigs returns the route commands of the configurations in
+// reverse order like makeConfig so that the most specific routes are
+// at the top.
+func combineConfigs(configs map[string]string) string {
+	var config []string
+	for _, c := range configs {
+		for _, cmd := range strings.Split(c, "\n") {
+			if cmd != "" {
+				config = append(config, cmd)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(config)))
+	return strings.Join(config, "\n")
+}
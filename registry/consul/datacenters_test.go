@@ -0,0 +1,36 @@
+package consul
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDCPriorities(t *testing.T) {
+	tests := []struct {
+		local string
+		dcs   []string
+		prio  map[string]int
+	}{
+		{"dc1", []string{"dc1", "dc2", "dc3"}, map[string]int{"dc1": 0, "dc2": 1, "dc3": 2}},
+		{"dc2", []string{"dc1", "dc2", "dc3"}, map[string]int{"dc1": 1, "dc2": 0, "dc3": 2}},
+		{"dc1", []string{"dc3", "dc2", "dc3"}, map[string]int{"dc1": 0, "dc3": 1, "dc2": 2}},
+		{"dc2", []string{"dc1"}, map[string]int{"dc2": 0, "dc1": 1}},
+	}
+	for i, tt := range tests {
+		if got, want := dcPriorities(tt.local, tt.dcs), tt.prio; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestCombineConfigs(t *testing.T) {
+	configs := map[string]string{
+		"dc1": "route add a a/ http://1.1.1.1:1/\nroute add c c/ http://1.1.1.1:3/",
+		"dc2": "route add b b/ http://2.2.2.2:2/",
+		"dc3": "",
+	}
+	want := "route add c c/ http://1.1.1.1:3/\nroute add b b/ http://2.2.2.2:2/\nroute add a a/ http://1.1.1.1:1/"
+	if got := combineConfigs(configs); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -21,9 +21,20 @@ type routecmd struct {
 	prefix string
 
 	env map[string]string
+
+	// dc is the datacenter of the service instance and dcpriority its
+	// priority. The routes get the 'dc' and 'dcpriority' options if dc
+	// is set.
+	dc         string
+	dcpriority int
 }
 
 func (r routecmd) build() []string {
+	// instances which are about to be deregistered are marked with the
+	// 'draining=true' tag or service meta value and their routes get
+	// the 'draining=true' option.
+	draining := r.svc.ServiceMeta["draining"] == "true"
+
 	var svctags, routetags []string
 	for _, t := range r.svc.ServiceTags {
 		if strings.HasPrefix(t, r.prefix) {
@@ -31,6 +42,9 @@ func (r routecmd) build() []string {
 		} else {
 			svctags = append(svctags, t)
 		}
+		if t == "draining=true" {
+			draining = true
+		}
 	}
 
 	// generate route commands
@@ -55,8 +69,13 @@ func (r routecmd) build() []string {
 
 			var weight string
 			var ropts []string
+			drainOpt := draining
 			for _, o := range strings.Fields(opts) {
 				switch {
+				case strings.HasPrefix(o, "draining="):
+					drainOpt = false
+					ropts = append(ropts, o)
+
 				case o == "proto=tcp":
 					dst = "tcp://" + addr
 
@@ -85,6 +104,12 @@ func (r routecmd) build() []string {
 					ropts = append(ropts, o)
 				}
 			}
+			if drainOpt {
+				ropts = append(ropts, "draining=true")
+			}
+			if r.dc != "" {
+				ropts = append(ropts, "dc="+r.dc, "dcpriority="+strconv.Itoa(r.dcpriority))
+			}
 
 			cfg := "route add " + name + " " + route + " " + dst
 			if weight != "" {
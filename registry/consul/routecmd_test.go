@@ -43,6 +43,54 @@ func TestRouteCmd(t *testing.T) {
 				`route add svc-1 :1234 tcp://1.1.1.1:2222`,
 			},
 		},
+		{
+			name: "datacenter",
+			r: routecmd{
+				prefix:     "p-",
+				dc:         "dc2",
+				dcpriority: 1,
+				svc: &api.CatalogService{
+					ServiceName:    "svc-1",
+					ServiceAddress: "1.1.1.1",
+					ServicePort:    2222,
+					ServiceTags:    []string{`p-foo/bar`},
+				},
+			},
+			cfg: []string{
+				`route add svc-1 foo/bar http://1.1.1.1:2222/ opts "dc=dc2 dcpriority=1"`,
+			},
+		},
+		{
+			name: "draining meta",
+			r: routecmd{
+				prefix: "p-",
+				svc: &api.CatalogService{
+					ServiceName:    "svc-1",
+					ServiceAddress: "1.1.1.1",
+					ServicePort:    2222,
+					ServiceTags:    []string{`p-foo/bar strip=/foo`},
+					ServiceMeta:    map[string]string{"draining": "true"},
+				},
+			},
+			cfg: []string{
+				`route add svc-1 foo/bar http://1.1.1.1:2222/ opts "strip=/foo draining=true"`,
+			},
+		},
+		{
+			name: "draining tag",
+			r: routecmd{
+				prefix: "p-",
+				svc: &api.CatalogService{
+					ServiceName:    "svc-1",
+					ServiceAddress: "1.1.1.1",
+					ServicePort:    2222,
+					ServiceTags:    []string{`p-foo/bar`, `draining=true`},
+				},
+			},
+			cfg: []string{
+				`route add svc-1 foo/bar http://1.1.1.1:2222/ tags "draining=true" opts "draining=true"`,
+			},
+		},
 	}
 
 	for _, c := range cases {
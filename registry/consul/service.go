@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
 	"github.com/hashicorp/consul/api"
 )
 
@@ -17,6 +19,13 @@ type ServiceMonitor struct {
 	config *config.Consul
 	dc     string
 	strict bool
+	stale  metrics.Counter
+
+	// federated is set when several datacenters are watched. The
+	// queries are then sent to the datacenter dc and the targets
+	// get the datacenter and its priority as options.
+	federated bool
+	priority  int
 }
 
 func NewServiceMonitor(client *api.Client, config *config.Consul, dc string) *ServiceMonitor {
@@ -25,27 +34,67 @@ func NewServiceMonitor(client *api.Client, config *config.Consul, dc string) *Se
 		config: config,
 		dc:     dc,
 		strict: config.ChecksRequired == "all",
+		stale:  Stale,
 	}
 }
 
+// Stale is a gauge which is 1 while the health state cannot be fetched
+// from consul and the last routing table is kept. With several watched
+// datacenters it is the number of datacenters whose health state cannot
+// be fetched. It is set by the caller.
+var Stale metrics.Counter = metrics.NoopCounter{}
+
 // Watch monitors the consul health checks and sends a new
 // configuration to the updates channel on every change.
+//
+// Failed queries are retried with exponential backoff and the
+// last configuration is kept. If the queries have failed for
+// longer than WatchResyncAfter the next query fetches the full
+// health state instead of waiting for changes after the last index.
 func (w *ServiceMonitor) Watch(updates chan string) {
 	var lastIndex uint64
+	var lastSuccess time.Time
+	var backoff time.Duration
+	var stale bool
 	var q *api.QueryOptions
 	for {
 		if w.config.PollInterval != 0 {
 			q = &api.QueryOptions{RequireConsistent: true}
 			time.Sleep(w.config.PollInterval)
 		} else {
-			q = &api.QueryOptions{RequireConsistent: true, WaitIndex: lastIndex}
+			q = &api.QueryOptions{RequireConsistent: true, WaitIndex: lastIndex, WaitTime: w.config.WatchWaitTime}
 		}
-		checks, meta, err := w.client.Health().State("any", q)
+		if w.federated {
+			q.Datacenter = w.dc
+		}
+		checks, meta, err := w.healthState(q)
 		if err != nil {
-			log.Printf("[WARN] consul: Error fetching health state. %v", err)
-			time.Sleep(time.Second)
+			if !stale {
+				stale = true
+				w.stale.Inc(1)
+			}
+			backoff = nextBackoff(backoff, w.config.WatchMaxBackoff)
+			log.Printf("[WARN] consul: Error fetching health state. Keeping the last routing table and retrying in %s. %v", backoff, err)
+			time.Sleep(backoff)
+			if lastIndex > 0 && time.Since(lastSuccess) > w.config.WatchResyncAfter {
+				log.Printf("[INFO] consul: Resyncing health state after %s of errors", time.Since(lastSuccess).Round(time.Second))
+				lastIndex = 0
+			}
 			continue
 		}
+		backoff, lastSuccess = 0, time.Now()
+		if stale {
+			stale = false
+			w.stale.Inc(-1)
+			log.Print("[INFO] consul: Health state is up to date again")
+		}
+
+		// the index must not go backwards. If it does the state in consul
+		// was reset, e.g. by a restore from a snapshot, and the response
+		// is the full state.
+		if meta.LastIndex < lastIndex {
+			log.Printf("[INFO] consul: Health index went backwards from #%d to #%d. Resyncing", lastIndex, meta.LastIndex)
+		}
 		log.Printf("[DEBUG] consul: Health changed to #%d", meta.LastIndex)
 
 		// determine which services have passing health checks
@@ -59,6 +108,32 @@ func (w *ServiceMonitor) Watch(updates chan string) {
 	}
 }
 
+// healthState fetches the health checks of all services. Blocking
+// queries are aborted if consul does not respond within the wait
+// time and the jitter which consul adds to it so that an unresponsive
+// agent does not block the watch forever.
+func (w *ServiceMonitor) healthState(q *api.QueryOptions) ([]*api.HealthCheck, *api.QueryMeta, error) {
+	if q.WaitTime > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), q.WaitTime+q.WaitTime/16+10*time.Second)
+		defer cancel()
+		q = q.WithContext(ctx)
+	}
+	return w.client.Health().State("any", q)
+}
+
+// nextBackoff doubles the backoff starting with one second
+// up to the maximum.
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < time.Second {
+		backoff = time.Second
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
 // makeConfig determines which service instances have passing health checks
 // and then finds the ones which have tags with the right prefix to build the config from.
 func (w *ServiceMonitor) makeConfig(checks []*api.HealthCheck) string {
@@ -111,6 +186,9 @@ func (w *ServiceMonitor) serviceConfig(name string, passing map[string]bool) (co
 	}
 
 	q := &api.QueryOptions{RequireConsistent: true}
+	if w.federated {
+		q.Datacenter = w.dc
+	}
 	svcs, _, err := w.client.Catalog().Service(name, "", q)
 	if err != nil {
 		log.Printf("[WARN] consul: Error getting catalog service %s. %v", name, err)
@@ -132,6 +210,9 @@ func (w *ServiceMonitor) serviceConfig(name string, passing map[string]bool) (co
 			env:    env,
 			prefix: w.config.TagPrefix,
 		}
+		if w.federated {
+			r.dc, r.dcpriority = w.dc, w.priority
+		}
 		cmds := r.build()
 
 		config = append(config, cmds...)
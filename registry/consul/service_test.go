@@ -0,0 +1,137 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+)
+
+type staleGauge struct {
+	mu     sync.Mutex
+	v, max int64
+}
+
+func (g *staleGauge) Inc(n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.v += n
+	if g.v > g.max {
+		g.max = g.v
+	}
+}
+
+func TestServiceMonitorWatchRecovers(t *testing.T) {
+	prevStale := Stale
+	defer func() { Stale = prevStale }()
+	stale := &staleGauge{}
+	Stale = stale
+
+	// the health state is fetched successfully, then fails twice and
+	// then succeeds with an index which went backwards.
+	responses := []struct {
+		status int
+		index  string
+	}{
+		{200, "10"},
+		{500, ""},
+		{500, ""},
+		{200, "5"},
+	}
+
+	var mu sync.Mutex
+	var indexes []string
+	done := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/health/state/any":
+			mu.Lock()
+			n := len(indexes)
+			indexes = append(indexes, r.URL.Query().Get("index"))
+			mu.Unlock()
+			if n >= len(responses) {
+				<-done
+				return
+			}
+			if responses[n].status != 200 {
+				w.WriteHeader(responses[n].status)
+				return
+			}
+			w.Header().Set("X-Consul-Index", responses[n].index)
+			json.NewEncoder(w).Encode([]*api.HealthCheck{
+				{Node: "node", CheckID: "check", ServiceID: "svc-1", ServiceName: "svc", Status: "passing"},
+			})
+
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/service/svc"):
+			w.Header().Set("X-Consul-Index", "1")
+			json.NewEncoder(w).Encode([]*api.CatalogService{
+				{Node: "node", ServiceID: "svc-1", ServiceName: "svc", ServiceAddress: "1.2.3.4", ServicePort: 80, ServiceTags: []string{"urlprefix-/foo"}},
+			})
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	client, err := api.NewClient(&api.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Consul{
+		TagPrefix:        "urlprefix-",
+		ServiceStatus:    []string{"passing"},
+		ServiceMonitors:  1,
+		WatchWaitTime:    time.Minute,
+		WatchMaxBackoff:  time.Millisecond,
+		WatchResyncAfter: time.Nanosecond,
+	}
+	updates := make(chan string, 2)
+	go NewServiceMonitor(client, cfg, "dc1").Watch(updates)
+
+	want := `route add svc /foo http://1.2.3.4:80/`
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-updates:
+			if got != want {
+				t.Fatalf("%d: got config %q want %q", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: timeout waiting for update", i)
+		}
+	}
+
+	// wait for the next blocking query
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := len(indexes)
+		mu.Unlock()
+		if n > len(responses) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	got := strings.Join(indexes, ",")
+	mu.Unlock()
+	if want := ",10,,,5"; got != want {
+		t.Fatalf("got query indexes %q want %q", got, want)
+	}
+
+	stale.mu.Lock()
+	v, max := stale.v, stale.max
+	stale.mu.Unlock()
+	if v != 0 || max != 1 {
+		t.Fatalf("got stale gauge %d (max %d) want 0 (max 1)", v, max)
+	}
+}
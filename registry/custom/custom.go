@@ -72,7 +72,11 @@ func customRoutes(cfg *config.Custom, ch chan string) {
 			ch <- fmt.Sprintf("Error generating new table - %s", err.Error())
 		}
 		log.Printf("[DEBUG] Custom Registry building table complete %s \n", time.Now())
-		route.SetTable(t)
+		if err := route.SetTable(t); err != nil {
+			ch <- fmt.Sprintf("Error setting new table - %s", err.Error())
+			time.Sleep(cfg.PollInterval)
+			continue
+		}
 		log.Printf("[DEBUG] Custom Registry table set complete %s \n", time.Now())
 		ch <- "OK"
 		time.Sleep(cfg.PollInterval)
@@ -0,0 +1,154 @@
+// Package kubernetes implements a registry backend which builds the
+// routes from kubernetes services and their endpoints.
+//
+// Routes are configured with the '<prefix>urlprefix' annotation of a
+// service which has the same format as the 'urlprefix-' tag of the
+// consul backend without the 'urlprefix-' part, e.g. '/api strip=/api'.
+// Multiple routes are separated by newlines. The targets are the ready
+// addresses of the endpoint slices of the service.
+package kubernetes
+
+import (
+	"log"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+type be struct {
+	c   *client
+	cfg *config.Kubernetes
+
+	// legacy is true if the API server does not support endpoint
+	// slices and the endpoints have to be used instead.
+	legacy bool
+}
+
+func NewBackend(cfg *config.Kubernetes) (registry.Backend, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] kubernetes: Connecting to %s", c.addr)
+	return &be{c: c, cfg: cfg}, nil
+}
+
+// Register checks that the API server is reachable during startup.
+// Fabio does not register itself in kubernetes.
+func (b *be) Register(services []string) error {
+	if services != nil {
+		return nil
+	}
+	var svcs serviceList
+	return b.c.list(b.path("/api/v1", "services"), &svcs)
+}
+
+func (b *be) Deregister(serviceName string) error {
+	return nil
+}
+
+func (b *be) DeregisterAll() error {
+	return nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	return nil, nil
+}
+
+func (b *be) ReadManual(string) (value string, version uint64, err error) {
+	return "", 0, nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	return false, nil
+}
+
+func (b *be) WatchServices() chan string {
+	log.Printf("[INFO] kubernetes: Watching services in namespace %q", b.cfg.Namespace)
+	ch := make(chan string, 1)
+	go b.watchServices(ch)
+	return ch
+}
+
+func (b *be) WatchManual() chan string {
+	return make(chan string)
+}
+
+func (b *be) WatchNoRouteHTML() chan string {
+	ch := make(chan string, 1)
+	ch <- b.cfg.NoRouteHTML
+	return ch
+}
+
+// watchServices rebuilds the routes whenever the endpoints change
+// and at least every resync interval to pick up changes of the
+// service annotations.
+func (b *be) watchServices(ch chan string) {
+	var last string
+	for {
+		routes, version, err := b.load()
+		if err != nil {
+			log.Printf("[WARN] kubernetes: Error fetching services. %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if routes != last {
+			ch <- routes
+			last = routes
+		}
+
+		if err := b.c.watch(b.endpointsPath(), version, b.cfg.Resync); err != nil {
+			log.Printf("[WARN] kubernetes: Error watching endpoints. %s", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// load builds the routes from the current services and endpoints.
+// It returns the resource version of the endpoints list which can be
+// used to watch for changes.
+func (b *be) load() (routes, version string, err error) {
+	var svcs serviceList
+	if err := b.c.list(b.path("/api/v1", "services"), &svcs); err != nil {
+		return "", "", err
+	}
+
+	if !b.legacy {
+		var slices endpointSliceList
+		err := b.c.list(b.endpointsPath(), &slices)
+		switch {
+		case err == nil:
+			eps := sliceEndpoints(slices.Items)
+			return makeConfig(svcs.Items, eps, b.cfg.AnnotationPrefix), slices.Metadata.ResourceVersion, nil
+		case err == errNotFound:
+			log.Print("[INFO] kubernetes: Endpoint slices not supported. Using endpoints")
+			b.legacy = true
+		default:
+			return "", "", err
+		}
+	}
+
+	var list endpointsList
+	if err := b.c.list(b.endpointsPath(), &list); err != nil {
+		return "", "", err
+	}
+	eps := legacyEndpoints(list.Items)
+	return makeConfig(svcs.Items, eps, b.cfg.AnnotationPrefix), list.Metadata.ResourceVersion, nil
+}
+
+func (b *be) endpointsPath() string {
+	if b.legacy {
+		return b.path("/api/v1", "endpoints")
+	}
+	return b.path("/apis/discovery.k8s.io/v1", "endpointslices")
+}
+
+// path returns the API path for the resource in the configured
+// namespace or in all namespaces.
+func (b *be) path(group, resource string) string {
+	if b.cfg.Namespace == "" {
+		return group + "/" + resource
+	}
+	return group + "/namespaces/" + b.cfg.Namespace + "/" + resource
+}
@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestBackendLoad(t *testing.T) {
+	const services = `{
+		"metadata": {"resourceVersion": "1"},
+		"items": [{"metadata": {"namespace": "ns", "name": "a", "annotations": {"fabio.io/urlprefix": "/a"}}}]
+	}`
+	const slices = `{
+		"metadata": {"resourceVersion": "2"},
+		"items": [{
+			"metadata": {"namespace": "ns", "name": "a-xyz", "labels": {"kubernetes.io/service-name": "a"}},
+			"endpoints": [{"addresses": ["10.0.0.1"], "conditions": {"ready": true}}],
+			"ports": [{"name": "http", "port": 8080}]
+		}]
+	}`
+	const endpoints = `{
+		"metadata": {"resourceVersion": "3"},
+		"items": [{
+			"metadata": {"namespace": "ns", "name": "a"},
+			"subsets": [{"addresses": [{"ip": "10.0.0.2"}], "ports": [{"name": "http", "port": 8080}]}]
+		}]
+	}`
+
+	tests := []struct {
+		name          string
+		slices        bool
+		routes        string
+		version       string
+		endpointsPath string
+	}{
+		{"endpoint slices", true, "route add a /a http://10.0.0.1:8080/", "2", "/apis/discovery.k8s.io/v1/namespaces/ns/endpointslices"},
+		{"endpoints", false, "route add a /a http://10.0.0.2:8080/", "3", "/api/v1/namespaces/ns/endpoints"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.Header.Get("Authorization"), "Bearer secret"; got != want {
+					t.Errorf("got auth header %q want %q", got, want)
+				}
+				switch r.URL.Path {
+				case "/api/v1/namespaces/ns/services":
+					fmt.Fprint(w, services)
+				case "/apis/discovery.k8s.io/v1/namespaces/ns/endpointslices":
+					if !tt.slices {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprint(w, slices)
+				case "/api/v1/namespaces/ns/endpoints":
+					fmt.Fprint(w, endpoints)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			b := &be{
+				c:   &client{addr: srv.URL, token: "secret", http: srv.Client()},
+				cfg: &config.Kubernetes{Namespace: "ns", AnnotationPrefix: "fabio.io/"},
+			}
+			routes, version, err := b.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := routes, tt.routes; got != want {
+				t.Fatalf("got routes %q want %q", got, want)
+			}
+			if got, want := version, tt.version; got != want {
+				t.Fatalf("got version %q want %q", got, want)
+			}
+			if got, want := b.endpointsPath(), tt.endpointsPath; got != want {
+				t.Fatalf("got endpoints path %q want %q", got, want)
+			}
+		})
+	}
+}
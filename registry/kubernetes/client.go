@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// errNotFound is returned when the requested resource does not exist.
+var errNotFound = errors.New("not found")
+
+// client is a minimal client for the kubernetes API which supports
+// listing and watching resources.
+type client struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// newClient creates a client for the configured API server. If no
+// address is configured the in-cluster configuration is used.
+func newClient(cfg *config.Kubernetes) (*client, error) {
+	addr := cfg.Addr
+	if addr == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetes: not running in a cluster and registry.kubernetes.addr is not set")
+		}
+		addr = "https://" + net.JoinHostPort(host, port)
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+
+	var token string
+	if cfg.TokenPath != "" {
+		b, err := ioutil.ReadFile(cfg.TokenPath)
+		switch {
+		case err == nil:
+			token = strings.TrimSpace(string(b))
+		case !os.IsNotExist(err) || cfg.Addr == "":
+			return nil, fmt.Errorf("kubernetes: cannot read token: %s", err)
+		}
+	}
+
+	tlscfg := &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify}
+	if cfg.CAPath != "" {
+		pem, err := ioutil.ReadFile(cfg.CAPath)
+		switch {
+		case err == nil:
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("kubernetes: no certificates found in %s", cfg.CAPath)
+			}
+			tlscfg.RootCAs = pool
+		case !os.IsNotExist(err) || cfg.Addr == "":
+			return nil, fmt.Errorf("kubernetes: cannot read CA certificate: %s", err)
+		}
+	}
+
+	return &client{
+		addr:  strings.TrimSuffix(addr, "/"),
+		token: token,
+		http:  &http.Client{Transport: &http.Transport{TLSClientConfig: tlscfg}},
+	}, nil
+}
+
+func (c *client) do(ctx context.Context, path string, q url.Values) (*http.Response, error) {
+	u := c.addr + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errNotFound
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", path, resp.Status)
+	}
+}
+
+// list fetches the resources at path and decodes them into v.
+func (c *client) list(path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := c.do(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// watch watches the resources at path which have changed since
+// resourceVersion. It returns after the first change or when the
+// timeout has expired.
+func (c *client) watch(path, resourceVersion string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+10*time.Second)
+	defer cancel()
+
+	q := url.Values{}
+	q.Set("watch", "1")
+	q.Set("resourceVersion", resourceVersion)
+	q.Set("timeoutSeconds", fmt.Sprint(int(timeout.Seconds())))
+	resp, err := c.do(ctx, path, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// every line is a watch event. We are only interested in the
+	// fact that something has changed and not in the change itself.
+	r := bufio.NewReader(resp.Body)
+	if _, err := r.ReadBytes('\n'); err != nil && err != io.EOF && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package kubernetes
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The following types contain the subset of the kubernetes API
+// objects which is required to build the routes.
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type serviceList struct {
+	Metadata listMeta  `json:"metadata"`
+	Items    []service `json:"items"`
+}
+
+type service struct {
+	Metadata objectMeta `json:"metadata"`
+}
+
+type endpointSliceList struct {
+	Metadata listMeta        `json:"metadata"`
+	Items    []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Metadata  objectMeta      `json:"metadata"`
+	Endpoints []sliceEndpoint `json:"endpoints"`
+	Ports     []endpointPort  `json:"ports"`
+}
+
+type sliceEndpoint struct {
+	Addresses  []string `json:"addresses"`
+	Conditions struct {
+		Ready *bool `json:"ready"`
+	} `json:"conditions"`
+}
+
+type endpointsList struct {
+	Metadata listMeta    `json:"metadata"`
+	Items    []endpoints `json:"items"`
+}
+
+type endpoints struct {
+	Metadata objectMeta `json:"metadata"`
+	Subsets  []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []endpointPort `json:"ports"`
+	} `json:"subsets"`
+}
+
+type endpointPort struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// serviceNameLabel is the label of an endpoint slice which
+// contains the name of the service.
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// endpoint is a ready address of a service.
+type endpoint struct {
+	ip    string
+	ports []endpointPort
+}
+
+// key returns the key for a service in the endpoints map.
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// sliceEndpoints returns the ready endpoints of the endpoint slices
+// grouped by service.
+func sliceEndpoints(slices []endpointSlice) map[string][]endpoint {
+	m := map[string][]endpoint{}
+	for _, s := range slices {
+		name := s.Metadata.Labels[serviceNameLabel]
+		if name == "" {
+			continue
+		}
+		k := key(s.Metadata.Namespace, name)
+		for _, ep := range s.Endpoints {
+			// a nil ready condition is to be interpreted as ready
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, ip := range ep.Addresses {
+				m[k] = append(m[k], endpoint{ip: ip, ports: s.Ports})
+			}
+		}
+	}
+	return m
+}
+
+// legacyEndpoints returns the ready endpoints of the endpoints
+// objects grouped by service.
+func legacyEndpoints(eps []endpoints) map[string][]endpoint {
+	m := map[string][]endpoint{}
+	for _, e := range eps {
+		k := key(e.Metadata.Namespace, e.Metadata.Name)
+		for _, s := range e.Subsets {
+			for _, a := range s.Addresses {
+				m[k] = append(m[k], endpoint{ip: a.IP, ports: s.Ports})
+			}
+		}
+	}
+	return m
+}
+
+// makeConfig builds the routing table for the services which have
+// an '<prefix>urlprefix' annotation from their ready endpoints.
+func makeConfig(svcs []service, eps map[string][]endpoint, prefix string) string {
+	var config []string
+	for _, svc := range svcs {
+		routes := svc.Metadata.Annotations[prefix+"urlprefix"]
+		if routes == "" {
+			continue
+		}
+		portName := svc.Metadata.Annotations[prefix+"port"]
+
+		for _, ep := range eps[key(svc.Metadata.Namespace, svc.Metadata.Name)] {
+			port, ok := selectPort(ep.ports, portName)
+			if !ok {
+				log.Printf("[WARN] kubernetes: No port %q for service %s/%s", portName, svc.Metadata.Namespace, svc.Metadata.Name)
+				continue
+			}
+			addr := net.JoinHostPort(ep.ip, strconv.Itoa(port))
+			for _, r := range strings.Split(routes, "\n") {
+				if cmd := routecmd(svc.Metadata.Name, addr, r); cmd != "" {
+					config = append(config, cmd)
+				}
+			}
+		}
+	}
+
+	// sort routes in reverse order so that longer routes come first
+	sort.Sort(sort.Reverse(sort.StringSlice(config)))
+	return strings.Join(config, "\n")
+}
+
+// selectPort returns the port with the given name or number or
+// the first port if name is empty.
+func selectPort(ports []endpointPort, name string) (int, bool) {
+	if len(ports) == 0 {
+		return 0, false
+	}
+	if name == "" {
+		return ports[0].Port, true
+	}
+	for _, p := range ports {
+		if p.Name == name || strconv.Itoa(p.Port) == name {
+			return p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// routecmd builds a route command for the 'host/path[ opts]' route
+// of a service.
+func routecmd(name, addr, r string) string {
+	r = strings.TrimSpace(r)
+	if r == "" {
+		return ""
+	}
+
+	var src, opts string
+	p := strings.SplitN(r, " ", 2)
+	src = p[0]
+	if len(p) == 2 {
+		opts = p[1]
+	}
+	if i := strings.Index(src, "/"); i > 0 {
+		src = strings.ToLower(src[:i]) + src[i:]
+	}
+
+	dst := "http://" + addr + "/"
+	var weight string
+	var ropts []string
+	for _, o := range strings.Fields(opts) {
+		switch {
+		case o == "proto=tcp":
+			dst = "tcp://" + addr
+		case o == "proto=https":
+			dst = "https://" + addr
+		case o == "proto=grpcs":
+			dst = "grpcs://" + addr
+		case o == "proto=grpc":
+			dst = "grpc://" + addr
+		case strings.HasPrefix(o, "weight="):
+			weight = o[len("weight="):]
+		default:
+			ropts = append(ropts, o)
+		}
+	}
+
+	cmd := "route add " + name + " " + src + " " + dst
+	if weight != "" {
+		cmd += " weight " + weight
+	}
+	if len(ropts) > 0 {
+		cmd += " opts " + strconv.Quote(strings.Join(ropts, " "))
+	}
+	return cmd
+}
@@ -0,0 +1,75 @@
+package kubernetes
+
+import (
+	"testing"
+)
+
+func TestRouteCmd(t *testing.T) {
+	tests := []struct {
+		name string
+		r    string
+		cmd  string
+	}{
+		{"empty", "", ``},
+		{"http", "/api", `route add svc /api http://1.1.1.1:80/`},
+		{"host", "Foo.com/Api", `route add svc foo.com/Api http://1.1.1.1:80/`},
+		{"https", "/api proto=https", `route add svc /api https://1.1.1.1:80`},
+		{"tcp", ":1234 proto=tcp", `route add svc :1234 tcp://1.1.1.1:80`},
+		{"grpc", "/ proto=grpc", `route add svc / grpc://1.1.1.1:80`},
+		{"weight", "/api weight=0.5", `route add svc /api http://1.1.1.1:80/ weight 0.5`},
+		{"opts", "/api strip=/api host=dst", `route add svc /api http://1.1.1.1:80/ opts "strip=/api host=dst"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := routecmd("svc", "1.1.1.1:80", tt.r), tt.cmd; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMakeConfig(t *testing.T) {
+	ready, notReady := true, false
+
+	svcs := []service{
+		{Metadata: objectMeta{Namespace: "ns", Name: "a", Annotations: map[string]string{"fabio.io/urlprefix": "/a\n/b strip=/b"}}},
+		{Metadata: objectMeta{Namespace: "ns", Name: "b", Annotations: map[string]string{"fabio.io/urlprefix": "/c", "fabio.io/port": "http"}}},
+		{Metadata: objectMeta{Namespace: "ns", Name: "c"}},
+	}
+
+	slices := []endpointSlice{
+		{
+			Metadata: objectMeta{Namespace: "ns", Labels: map[string]string{serviceNameLabel: "a"}},
+			Ports:    []endpointPort{{Name: "web", Port: 8080}},
+		},
+		{
+			Metadata: objectMeta{Namespace: "ns", Labels: map[string]string{serviceNameLabel: "b"}},
+			Ports:    []endpointPort{{Name: "metrics", Port: 9100}, {Name: "http", Port: 8000}},
+		},
+		{
+			Metadata: objectMeta{Namespace: "ns", Labels: map[string]string{serviceNameLabel: "c"}},
+			Ports:    []endpointPort{{Port: 80}},
+		},
+	}
+	addEndpoint := func(s *endpointSlice, ip string, ready *bool) {
+		ep := sliceEndpoint{Addresses: []string{ip}}
+		ep.Conditions.Ready = ready
+		s.Endpoints = append(s.Endpoints, ep)
+	}
+	addEndpoint(&slices[0], "10.0.0.1", &ready)
+	addEndpoint(&slices[0], "10.0.0.2", &notReady)
+	addEndpoint(&slices[0], "10.0.0.3", nil)
+	addEndpoint(&slices[1], "10.0.1.1", &ready)
+	addEndpoint(&slices[2], "10.0.2.1", &ready)
+
+	got := makeConfig(svcs, sliceEndpoints(slices), "fabio.io/")
+	want := `route add b /c http://10.0.1.1:8000/
+route add a /b http://10.0.0.3:8080/ opts "strip=/b"
+route add a /b http://10.0.0.1:8080/ opts "strip=/b"
+route add a /a http://10.0.0.3:8080/
+route add a /a http://10.0.0.1:8080/`
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// overridesFile is a backend which stores the manual overrides in a
+// local file instead of the registry so that they survive a restart
+// with registry backends which cannot store them, e.g. the static
+// backend. All other methods are provided by the wrapped backend.
+type overridesFile struct {
+	Backend
+
+	file string
+
+	mu        sync.Mutex
+	overrides map[string]override
+	watch     chan string
+}
+
+// override is the value of a manual overrides path and its version
+// which is incremented on every update.
+type override struct {
+	Value   string `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+// NewOverridesFile returns a backend which stores the manual overrides
+// of be in file. The file is read on startup and is replaced atomically
+// on every update. It is created when the first override is written.
+func NewOverridesFile(be Backend, file string) (Backend, error) {
+	f := &overridesFile{Backend: be, file: file, overrides: map[string]override{}}
+	b, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, &f.overrides); err != nil {
+			return nil, errors.New("invalid overrides file " + file + ". " + err.Error())
+		}
+	}
+	return f, nil
+}
+
+func (f *overridesFile) ManualPaths() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paths(), nil
+}
+
+func (f *overridesFile) ReadManual(path string) (value string, version uint64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o := f.overrides[path]
+	return o.Value, o.Version, nil
+}
+
+func (f *overridesFile) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prev, exists := f.overrides[path]
+	if exists && prev.Version != version {
+		return false, nil
+	}
+	f.overrides[path] = override{Value: value, Version: prev.Version + 1}
+	if err := f.save(); err != nil {
+		if exists {
+			f.overrides[path] = prev
+		} else {
+			delete(f.overrides, path)
+		}
+		return false, err
+	}
+	f.notify()
+	return true, nil
+}
+
+func (f *overridesFile) WatchManual() chan string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watch = make(chan string, 1)
+	f.notify()
+	return f.watch
+}
+
+// notify pushes the current overrides of all paths ordered by path to
+// the watcher. A pending update which has not been received yet is
+// replaced. The caller must hold the lock.
+func (f *overridesFile) notify() {
+	if f.watch == nil {
+		return
+	}
+	var values []string
+	for _, p := range f.paths() {
+		values = append(values, f.overrides[p].Value)
+	}
+	select {
+	case <-f.watch:
+	default:
+	}
+	f.watch <- strings.Join(values, "\n")
+}
+
+// paths returns the sorted paths of the overrides.
+// The caller must hold the lock.
+func (f *overridesFile) paths() []string {
+	var paths []string
+	for p := range f.overrides {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// save writes the overrides to the file. The caller must hold the lock.
+func (f *overridesFile) save() error {
+	b, err := json.MarshalIndent(f.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.file), "."+filepath.Base(f.file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(b, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.file)
+}
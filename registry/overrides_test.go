@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// nopBackend is a registry backend without manual overrides.
+type nopBackend struct{ Backend }
+
+func TestOverridesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "overrides.json")
+
+	be, err := NewOverridesFile(nopBackend{}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch := be.WatchManual()
+	if got := <-watch; got != "" {
+		t.Fatalf("got overrides %q want empty", got)
+	}
+
+	write := func(path, value string, version uint64, want bool) {
+		t.Helper()
+		ok, err := be.WriteManual(path, value, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("%s: got ok %v want %v", path, ok, want)
+		}
+	}
+	write("", "route weight svc-a / weight 0.2", 0, true)
+	write("/b", "route del svc-b", 0, true)
+	write("", "route weight svc-a / weight 0.5", 0, false)
+	write("", "route weight svc-a / weight 0.5", 1, true)
+
+	if got, want := <-watch, "route weight svc-a / weight 0.5\nroute del svc-b"; got != want {
+		t.Fatalf("got overrides %q want %q", got, want)
+	}
+
+	// the overrides are loaded from the file after a restart
+	be, err = NewOverridesFile(nopBackend{}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := be.ManualPaths()
+	if got, want := paths, []string{"", "/b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got paths %v want %v", got, want)
+	}
+	value, version, _ := be.ReadManual("")
+	if value != "route weight svc-a / weight 0.5" || version != 2 {
+		t.Fatalf("got %q version %d", value, version)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewOverridesFile(nopBackend{}, file); err == nil {
+		t.Fatal("got nil want error for invalid file")
+	}
+}
@@ -0,0 +1,124 @@
+package route
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mediaRange is a media range from an Accept header with its quality value.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses the media ranges of an Accept header as described
+// in RFC 7231 section 5.3.2. Invalid media ranges are ignored.
+func parseAccept(s string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(s, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if mt == "" {
+			continue
+		}
+		// some clients send a single '*' instead of '*/*'
+		if mt == "*" {
+			mt = "*/*"
+		}
+		typ, subtype, ok := splitMediaType(mt)
+		if !ok || (typ == "*" && subtype != "*") {
+			continue
+		}
+
+		r := mediaRange{typ: typ, subtype: subtype, q: 1}
+		valid := true
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			r.q = q
+		}
+		if valid {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// splitMediaType splits a lowercase media type into its type and subtype.
+func splitMediaType(mt string) (typ, subtype string, ok bool) {
+	p := strings.SplitN(mt, "/", 2)
+	if len(p) != 2 {
+		return "", "", false
+	}
+	typ, subtype = strings.TrimSpace(p[0]), strings.TrimSpace(p[1])
+	if typ == "" || subtype == "" {
+		return "", "", false
+	}
+	return typ, subtype, true
+}
+
+// acceptQuality returns the quality value of the most specific media
+// range which matches the media type and its specificity. The
+// specificity is 2 for an exact match, 1 for 'type/*' and 0 for '*/*'.
+// If no media range matches the quality value is 0 and the specificity
+// is -1.
+func acceptQuality(ranges []mediaRange, typ, subtype string) (q float64, specificity int) {
+	specificity = -1
+	for _, r := range ranges {
+		var s int
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q, specificity
+}
+
+// negotiate returns the route with the targets which match the Accept
+// header of the request best. Targets without a 'match=accept:...' option
+// serve requests without an Accept header or with an Accept header which
+// only matches through '*/*'. If the route has no such targets then the
+// best matching targets are used for those requests as well.
+func (r *Route) negotiate(accept string) *Route {
+	if r.fallback == nil {
+		return r
+	}
+	// a request without an Accept header accepts all media types
+	if accept == "" {
+		accept = "*/*"
+	}
+
+	ranges := parseAccept(accept)
+	var best *Route
+	var bestQ float64
+	bestSpec := -1
+	for _, v := range r.negotiated {
+		q, spec := acceptQuality(ranges, v.acceptType, v.acceptSubtype)
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && spec > bestSpec) {
+			best, bestQ, bestSpec = v, q, spec
+		}
+	}
+	if best == nil || (bestSpec == 0 && len(r.fallback.Targets) > 0) {
+		return r.fallback
+	}
+	return best
+}
@@ -0,0 +1,52 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+	tests := []struct {
+		accept string
+		ranges []mediaRange
+	}{
+		{"", nil},
+		{"*", []mediaRange{{"*", "*", 1}}},
+		{"application/xml", []mediaRange{{"application", "xml", 1}}},
+		{"Application/XML;q=0.5", []mediaRange{{"application", "xml", 0.5}}},
+		{"text/html, application/*;level=1;q=0.8, */*;q=0.1", []mediaRange{{"text", "html", 1}, {"application", "*", 0.8}, {"*", "*", 0.1}}},
+		{"application/json;q=2, text/plain;q=x, */html, bogus", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got, want := parseAccept(tt.accept), tt.ranges; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept string
+		q      float64
+		spec   int
+	}{
+		{"text/html", 0, -1},
+		{"application/xml", 1, 2},
+		{"application/*;q=0.5", 0.5, 1},
+		{"*/*;q=0.1", 0.1, 0},
+		{"*/*, application/*;q=0.5, application/xml;q=0.8", 0.8, 2},
+		{"*/*, application/xml;q=0", 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			q, spec := acceptQuality(parseAccept(tt.accept), "application", "xml")
+			if q != tt.q || spec != tt.spec {
+				t.Fatalf("got q=%v spec=%d want q=%v spec=%d", q, spec, tt.q, tt.spec)
+			}
+		})
+	}
+}
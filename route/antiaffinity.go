@@ -0,0 +1,61 @@
+package route
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// LocalAddrs contains the host names and IP addresses of the node fabio
+// is running on and is set by the caller. Targets on one of these hosts
+// or on a loopback address are avoided by routes with the
+// 'antiaffinity=node' option.
+var LocalAddrs []string
+
+// AntiAffinityRepicks counts the picked targets which were replaced
+// with a target on another node and is set by the caller.
+var AntiAffinityRepicks metrics.Counter = metrics.NoopCounter{}
+
+// AntiAffinityFallbacks counts the picks of a target on the local node
+// because there was no available target on another node and is set
+// by the caller.
+var AntiAffinityFallbacks metrics.Counter = metrics.NoopCounter{}
+
+// avoidLocal replaces the picked target t of a route with the
+// 'antiaffinity=node' option with an available target on another node
+// in the same datacenter if t is on the local node. The target on
+// another node is picked with a probability proportional to its weight.
+// t is returned if there is no other available target.
+func avoidLocal(r *Route, t *Target) *Target {
+	if !t.AntiAffinity || !isLocalHost(t.URL.Hostname()) {
+		return t
+	}
+	remote := make([]*Target, 0, len(r.Targets))
+	for _, tt := range r.Targets {
+		if tt.DCPriority == t.DCPriority && !isLocalHost(tt.URL.Hostname()) {
+			remote = append(remote, tt)
+		}
+	}
+	if tt := pickWeighted(remote, time.Now()); tt != nil {
+		AntiAffinityRepicks.Inc(1)
+		return tt
+	}
+	AntiAffinityFallbacks.Inc(1)
+	return t
+}
+
+// isLocalHost returns whether host is one of the LocalAddrs or
+// a loopback address.
+func isLocalHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, a := range LocalAddrs {
+		if strings.EqualFold(a, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestAvoidLocal(t *testing.T) {
+	prevAddrs, prevRepicks, prevFallbacks := LocalAddrs, AntiAffinityRepicks, AntiAffinityFallbacks
+	prevOutliers, prevEjections := Outliers, Ejections
+	defer func() {
+		LocalAddrs, AntiAffinityRepicks, AntiAffinityFallbacks = prevAddrs, prevRepicks, prevFallbacks
+		Outliers, Ejections = prevOutliers, prevEjections
+	}()
+	repicks, fallbacks := &testCounter{}, &testCounter{}
+	LocalAddrs = []string{"10.0.0.1", "Node-A"}
+	AntiAffinityRepicks, AntiAffinityFallbacks = repicks, fallbacks
+	Outliers = OutlierDetection{Consecutive5xx: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Hour, MaxEjectionPercent: 100}
+	Ejections = &testCounter{}
+
+	s := `
+	route add svc /aa http://10.0.0.1:1/ opts "antiaffinity=node"
+	route add svc /aa http://node-a:2/ opts "antiaffinity=node"
+	route add svc /aa http://127.0.0.1:3/ opts "antiaffinity=node"
+	route add svc /aa http://10.0.0.2:4/ opts "antiaffinity=node"
+	route add svc /plain http://10.0.0.1:1/
+	route add svc /plain http://10.0.0.2:2/
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aa, plain *Route
+	for _, r := range tbl[""] {
+		switch r.Path {
+		case "/aa":
+			aa = r
+		case "/plain":
+			plain = r
+		}
+	}
+
+	// local targets are replaced with the remote target
+	for _, tg := range aa.Targets[:3] {
+		if got, want := avoidLocal(aa, tg), aa.Targets[3]; got != want {
+			t.Fatalf("%s: got %s want %s", tg.URL, got.URL, want.URL)
+		}
+	}
+	if got, want := repicks.n, int64(3); got != want {
+		t.Fatalf("got %d repicks want %d", got, want)
+	}
+
+	// remote targets and targets without the option are kept
+	if got, want := avoidLocal(aa, aa.Targets[3]), aa.Targets[3]; got != want {
+		t.Fatalf("got %s want %s", got.URL, want.URL)
+	}
+	if got, want := avoidLocal(plain, plain.Targets[0]), plain.Targets[0]; got != want {
+		t.Fatalf("got %s want %s", got.URL, want.URL)
+	}
+
+	// local target is used if the remote target is not available
+	aa.Targets[3].RecordResult(true)
+	if got, want := avoidLocal(aa, aa.Targets[0]), aa.Targets[0]; got != want {
+		t.Fatalf("got %s want %s", got.URL, want.URL)
+	}
+	if got, want := fallbacks.n, int64(1); got != want {
+		t.Fatalf("got %d fallbacks want %d", got, want)
+	}
+	if got, want := repicks.n, int64(3); got != want {
+		t.Fatalf("got %d repicks want %d", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package route
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BreakerFallback is the response of a route whose circuit breaker is
+// open, i.e. all of its targets have been ejected by the outlier
+// detection. The response is sent without contacting the targets.
+// Exactly one of the fields is set.
+type BreakerFallback struct {
+	// Cache serves the last successful response for the request URL.
+	Cache bool
+
+	// File is the path of the file within FallbackDir which is
+	// served with 503 Service Unavailable.
+	File string
+
+	// Status is the status code of the response.
+	Status int
+}
+
+// ParseBreakerFallback parses the value of a 'breakerfallback' option
+// which is either 'cache', 'static:file://<path>' or 'status:<code>'.
+func ParseBreakerFallback(s string) (*BreakerFallback, error) {
+	switch {
+	case s == "cache":
+		return &BreakerFallback{Cache: true}, nil
+	case strings.HasPrefix(s, "static:"):
+		u, err := url.Parse(s[len("static:"):])
+		if err != nil || u.Scheme != "file" {
+			return nil, fmt.Errorf("breakerfallback=static should be a file URL. Got: %s", s)
+		}
+		path, err := fallbackFile(u)
+		if err != nil {
+			return nil, fmt.Errorf("breakerfallback: %s", err)
+		}
+		return &BreakerFallback{File: path}, nil
+	case strings.HasPrefix(s, "status:"):
+		code, err := strconv.Atoi(s[len("status:"):])
+		if err != nil || code < 200 || code > 599 {
+			return nil, fmt.Errorf("breakerfallback=status should be a status code. Got: %s", s)
+		}
+		return &BreakerFallback{Status: code}, nil
+	default:
+		return nil, fmt.Errorf("breakerfallback should be cache, static:file://<path> or status:<code>. Got: %s", s)
+	}
+}
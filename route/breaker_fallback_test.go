@@ -0,0 +1,39 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBreakerFallback(t *testing.T) {
+	defer func(dir string) { FallbackDir = dir }(FallbackDir)
+	FallbackDir = "/var/www"
+
+	tests := []struct {
+		in   string
+		want *BreakerFallback
+		err  bool
+	}{
+		{"cache", &BreakerFallback{Cache: true}, false},
+		{"static:file:///sorry.html", &BreakerFallback{File: "/var/www/sorry.html"}, false},
+		{"static:file:///../etc/passwd", nil, true},
+		{"status:503", &BreakerFallback{Status: 503}, false},
+		{"static:http://sorry.example.com/", nil, true},
+		{"static:file://", nil, true},
+		{"status:abc", nil, true},
+		{"status:600", nil, true},
+		{"file:///var/www/sorry.html", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBreakerFallback(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package route
+
+import (
+	"log"
+	"sort"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// ConflictMode determines how a route with targets of more than one
+// service is handled.
+//
+//	merge:  the targets of all services are added to the route
+//	warn:   only the targets of the service with the lowest name are kept
+//	reject: only the targets of the service which was added first are kept
+//
+// A conflict is logged in the warn and reject mode. In all modes the
+// conflicting services are shown in the route dump.
+var ConflictMode = "merge"
+
+// TableConflicts is a gauge of the number of routes of the active
+// routing table with targets of more than one service. It is set
+// by the caller.
+var TableConflicts metrics.Counter = metrics.NoopCounter{}
+
+// tableConflicts is the current value of the TableConflicts gauge.
+var tableConflicts int64
+
+// allowService returns whether a target of the service can be added
+// to the route according to the ConflictMode. Conflicts are recorded
+// on the route.
+func (r *Route) allowService(service string) bool {
+	if len(r.Targets) == 0 {
+		return true
+	}
+	for _, t := range r.Targets {
+		if t.Service == service {
+			return true
+		}
+	}
+
+	owner := r.Targets[0].Service
+	isNew := r.addConflict(owner, service)
+	switch ConflictMode {
+	case "reject":
+		if isNew {
+			log.Printf("[WARN] route: Rejecting targets of service %s for %s%s which is already used by service %s", service, r.Host, r.Path, owner)
+		}
+		return false
+
+	case "warn":
+		if service > owner {
+			if isNew {
+				log.Printf("[WARN] route: Ignoring targets of service %s for %s%s which is also used by service %s", service, r.Host, r.Path, owner)
+			}
+			return false
+		}
+		if isNew {
+			log.Printf("[WARN] route: Replacing targets of service %s for %s%s with the targets of service %s", owner, r.Host, r.Path, service)
+		}
+		r.Targets = nil
+		return true
+
+	default:
+		return true
+	}
+}
+
+// addConflict records the conflicting services of the route and
+// returns whether the service had not been recorded before.
+func (r *Route) addConflict(owner, service string) bool {
+	isNew := !contains(r.conflicts, []string{service})
+	for _, s := range []string{owner, service} {
+		if !contains(r.conflicts, []string{s}) {
+			r.conflicts = append(r.conflicts, s)
+		}
+	}
+	sort.Strings(r.conflicts)
+	return isNew
+}
+
+// numConflicts returns the number of routes with conflicts.
+func (t Table) numConflicts() int {
+	n := 0
+	for _, routes := range t {
+		for _, r := range routes {
+			if len(r.conflicts) > 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestConflictMode(t *testing.T) {
+	s := `
+	route add svc-b / http://bbb.com/
+	route add svc-a / http://aaa.com/
+	route add svc-b / http://bbb.com:2222/
+	route add svc-a /a http://aaa.com/
+	`
+
+	tests := []struct {
+		mode      string
+		cfg       []string
+		conflicts []string
+	}{
+		{
+			mode: "merge",
+			cfg: []string{
+				`route add svc-a /a http://aaa.com/ weight 1.0000`,
+				`route add svc-b / http://bbb.com/ weight 0.3333`,
+				`route add svc-a / http://aaa.com/ weight 0.3333`,
+				`route add svc-b / http://bbb.com:2222/ weight 0.3333`,
+			},
+			conflicts: []string{"svc-a", "svc-b"},
+		},
+		{
+			mode: "warn",
+			cfg: []string{
+				`route add svc-a /a http://aaa.com/ weight 1.0000`,
+				`route add svc-a / http://aaa.com/ weight 1.0000`,
+			},
+			conflicts: []string{"svc-a", "svc-b"},
+		},
+		{
+			mode: "reject",
+			cfg: []string{
+				`route add svc-a /a http://aaa.com/ weight 1.0000`,
+				`route add svc-b / http://bbb.com/ weight 0.5000`,
+				`route add svc-b / http://bbb.com:2222/ weight 0.5000`,
+			},
+			conflicts: []string{"svc-a", "svc-b"},
+		},
+	}
+
+	defer func(mode string) { ConflictMode = mode }(ConflictMode)
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ConflictMode = tt.mode
+			tbl, err := NewTable(bytes.NewBufferString(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tbl.config(true), tt.cfg; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got\n%v\nwant\n%v", got, want)
+			}
+			if got, want := tbl[""].find("/").conflicts, tt.conflicts; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got conflicts %v want %v", got, want)
+			}
+			if got, want := tbl.numConflicts(), 1; got != want {
+				t.Fatalf("got %d conflicting routes want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestConflictMode_Dump(t *testing.T) {
+	s := `
+	route add svc-a / http://aaa.com/
+	route add svc-b / http://bbb.com/
+	`
+
+	defer func(mode string) { ConflictMode = mode }(ConflictMode)
+	ConflictMode = "reject"
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `+-- host=
+    +-- path=/ conflicts=svc-a,svc-b
+        +-- addr=aaa.com weight 1.00 slots 1/1
+`
+	if got := tbl.Dump(); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
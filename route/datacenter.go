@@ -0,0 +1,46 @@
+package route
+
+import (
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// CrossDatacenter counts the requests which were routed to a target in
+// a remote datacenter and is set by the caller.
+var CrossDatacenter metrics.Counter = metrics.NoopCounter{}
+
+// preferDatacenter replaces the picked target t with an available
+// target of the datacenter with the highest priority, i.e. the lowest
+// 'dcpriority' value. The local datacenter has priority 0 and the
+// targets in the remote datacenters are only used when there is no
+// available target in the local datacenter. The replacement is picked
+// with a probability proportional to its weight. t is returned if
+// there is no available target.
+func preferDatacenter(r *Route, t *Target) *Target {
+	if t.DCPriority == 0 {
+		return t
+	}
+	now := time.Now()
+	best := -1
+	for _, tt := range r.Targets {
+		if tt.Weight*tt.drainFactor(now) > 0 && !tt.Ejected() && (best < 0 || tt.DCPriority < best) {
+			best = tt.DCPriority
+		}
+	}
+	if best >= 0 && best != t.DCPriority {
+		tier := make([]*Target, 0, len(r.Targets))
+		for _, tt := range r.Targets {
+			if tt.DCPriority == best {
+				tier = append(tier, tt)
+			}
+		}
+		if tt := pickWeighted(tier, now); tt != nil {
+			t = tt
+		}
+	}
+	if t.DCPriority > 0 {
+		CrossDatacenter.Inc(1)
+	}
+	return t
+}
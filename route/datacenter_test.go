@@ -0,0 +1,64 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPreferDatacenter(t *testing.T) {
+	prevOutliers, prevEjections, prevCross := Outliers, Ejections, CrossDatacenter
+	defer func() { Outliers, Ejections, CrossDatacenter = prevOutliers, prevEjections, prevCross }()
+	Outliers = OutlierDetection{Consecutive5xx: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Hour, MaxEjectionPercent: 100}
+	Ejections = &testCounter{}
+	cross := &testCounter{}
+	CrossDatacenter = cross
+
+	s := `
+	route add dc-svc /dc http://10.1.0.1:1/ opts "dc=dc1 dcpriority=0"
+	route add dc-svc /dc http://10.2.0.1:1/ opts "dc=dc2 dcpriority=1"
+	route add dc-svc /dc http://10.3.0.1:1/ opts "dc=dc3 dcpriority=2"
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tbl[""][0]
+	var dc1, dc2, dc3 *Target
+	for _, tg := range r.Targets {
+		switch tg.Datacenter {
+		case "dc1":
+			dc1 = tg
+		case "dc2":
+			dc2 = tg
+		case "dc3":
+			dc3 = tg
+		}
+	}
+	if dc3.DCPriority != 2 {
+		t.Fatalf("got priority %d want 2", dc3.DCPriority)
+	}
+
+	// the local datacenter is preferred
+	for _, tg := range r.Targets {
+		if got, want := preferDatacenter(r, tg), dc1; got != want {
+			t.Fatalf("%s: got %s want %s", tg.URL, got.URL, want.URL)
+		}
+	}
+	if got, want := cross.n, int64(0); got != want {
+		t.Fatalf("got %d cross datacenter requests want %d", got, want)
+	}
+
+	// the remote datacenters are used in the order of their priority
+	dc1.RecordResult(true)
+	if got, want := preferDatacenter(r, dc3), dc2; got != want {
+		t.Fatalf("got %s want %s", got.URL, want.URL)
+	}
+	dc2.RecordResult(true)
+	if got, want := preferDatacenter(r, dc2), dc3; got != want {
+		t.Fatalf("got %s want %s", got.URL, want.URL)
+	}
+	if got, want := cross.n, int64(2); got != want {
+		t.Fatalf("got %d cross datacenter requests want %d", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+package route
+
+import (
+	"sync"
+	"time"
+)
+
+// DrainDecay is the time in which the weight of a target with the
+// 'draining=true' option decreases linearly from its configured weight
+// to zero. Draining targets receive no requests immediately if it is
+// zero. It is set by the caller.
+var DrainDecay time.Duration
+
+// drains contains the time when the targets started draining by
+// outlierKey. The time is kept across routing tables so that a new
+// routing table does not restart the decay.
+var drains sync.Map
+
+// drainStartFor returns the time when the target started draining.
+func drainStartFor(t *Target) time.Time {
+	v, _ := drains.LoadOrStore(outlierKey(t), time.Now())
+	return v.(time.Time)
+}
+
+// syncDrains removes the start times of the targets which are no
+// longer draining or no longer part of the routing table.
+func syncDrains(t Table) {
+	active := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.Draining {
+					active[outlierKey(tg)] = true
+				}
+			}
+		}
+	}
+	drains.Range(func(k, _ interface{}) bool {
+		if !active[k.(string)] {
+			drains.Delete(k)
+		}
+		return true
+	})
+}
+
+// drainFactor returns the factor between 0 and 1 for the weight of the
+// target at time now. It is 1 for targets which are not draining.
+func (t *Target) drainFactor(now time.Time) float64 {
+	if !t.Draining {
+		return 1
+	}
+	if DrainDecay <= 0 {
+		return 0
+	}
+	f := 1 - float64(now.Sub(t.drainStart))/float64(DrainDecay)
+	switch {
+	case f < 0:
+		return 0
+	case f > 1:
+		return 1
+	}
+	return f
+}
+
+// drained returns whether a pick of a draining target is rejected. The
+// pick is kept with the probability of the drain factor so that the
+// share of the target decreases with its weight.
+func (t *Target) drained(now time.Time) bool {
+	if !t.Draining {
+		return false
+	}
+	return float64(randIntn(1e6))/1e6 >= t.drainFactor(now)
+}
@@ -0,0 +1,125 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDrainFactor(t *testing.T) {
+	prev := DrainDecay
+	defer func() { DrainDecay = prev }()
+	DrainDecay = 100 * time.Second
+
+	start := time.Now()
+	tg := &Target{Draining: true, drainStart: start}
+	tests := []struct {
+		d    time.Duration
+		want float64
+	}{
+		{0, 1},
+		{25 * time.Second, 0.75},
+		{50 * time.Second, 0.5},
+		{100 * time.Second, 0},
+		{time.Hour, 0},
+	}
+	for _, tt := range tests {
+		if got := tg.drainFactor(start.Add(tt.d)); got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.d, got, tt.want)
+		}
+	}
+	if got, want := (&Target{}).drainFactor(start.Add(time.Hour)), 1.0; got != want {
+		t.Errorf("not draining: got %v want %v", got, want)
+	}
+
+	DrainDecay = 0
+	if got, want := tg.drainFactor(start), 0.0; got != want {
+		t.Errorf("no decay: got %v want %v", got, want)
+	}
+}
+
+func TestPickDraining(t *testing.T) {
+	prevDecay, prevRand := DrainDecay, randIntn
+	defer func() { DrainDecay, randIntn = prevDecay, prevRand }()
+	DrainDecay = 100 * time.Second
+
+	s := `
+	route add drain-svc /drain http://1.1.3.1:1 tags "a" opts "draining=true"
+	route add drain-svc /drain http://1.1.3.1:2 tags "b"
+	route add drain-svc /drain http://1.1.3.1:3 tags "c"
+	route weight drain-svc /drain weight 0.5 tags "a"
+	route weight drain-svc /drain weight 0.3 tags "b"
+	route weight drain-svc /drain weight 0.2 tags "c"
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tbl[""][0]
+	a, b, c := r.Targets[0], r.Targets[1], r.Targets[2]
+	if !a.Draining || b.Draining {
+		t.Fatal("draining option not parsed")
+	}
+	now := time.Now()
+	a.drainStart = now.Add(-50 * time.Second)
+
+	// the weight of the draining target is halved
+	counts := map[*Target]int{}
+	for i := 0; i < 1000; i++ {
+		randIntn = func(int) int { return i * 1000 }
+		counts[pickWeighted(r.Targets, now)]++
+	}
+	if got, want := counts, map[*Target]int{a: 334, b: 400, c: 266}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// picks of the draining target are kept with the drain factor
+	randIntn = func(int) int { return 499999 }
+	if a.drained(now) {
+		t.Fatal("pick rejected below the drain factor")
+	}
+	randIntn = func(int) int { return 500000 }
+	if !a.drained(now) {
+		t.Fatal("pick kept above the drain factor")
+	}
+	if b.drained(now) {
+		t.Fatal("pick of target which is not draining rejected")
+	}
+
+	// a fully drained target is used if no other target is available
+	a.drainStart = now.Add(-time.Hour)
+	only := &Route{Targets: []*Target{a}}
+	if got := pickAvailable(only, func(*Route) *Target { return a }); got != a {
+		t.Fatalf("got %s want %s", got.URL, a.URL)
+	}
+}
+
+func TestSyncDrains(t *testing.T) {
+	s := `route add sync-svc /sync http://1.1.4.1:1 opts "draining=true"`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := tbl[""][0].Targets[0].drainStart
+
+	// the decay continues in the next routing table
+	syncDrains(tbl)
+	tbl, err = NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tbl[""][0].Targets[0].drainStart; !got.Equal(start) {
+		t.Fatalf("got drain start %s want %s", got, start)
+	}
+
+	// the decay restarts after the target was not draining
+	key := outlierKey(tbl[""][0].Targets[0])
+	if _, ok := drains.Load(key); !ok {
+		t.Fatal("drain start not stored")
+	}
+	syncDrains(Table{})
+	if _, ok := drains.Load(key); ok {
+		t.Fatal("drain start not removed")
+	}
+}
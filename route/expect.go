@@ -0,0 +1,81 @@
+package route
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Expect describes the responses which are expected from a target
+// with the 'expect' option, e.g. 'status:200-299,ctype:application/json'.
+// Responses which do not match are counted as failures of the target
+// even if their status code signals success.
+type Expect struct {
+	// MinStatus and MaxStatus are the range of the expected
+	// status codes. Both are 0 if the status code is not checked.
+	MinStatus, MaxStatus int
+
+	// ContentTypes are the expected media types of the response.
+	// The content type is not checked if the list is empty.
+	ContentTypes []string
+}
+
+// ParseExpect parses the value of an 'expect' option which is a comma
+// separated list of 'status:<code>[-<code>]' and 'ctype:<type>[|<type>]'
+// conditions.
+func ParseExpect(s string) (*Expect, error) {
+	e := &Expect{}
+	for _, cond := range strings.Split(s, ",") {
+		p := strings.SplitN(strings.TrimSpace(cond), ":", 2)
+		if len(p) != 2 || p[1] == "" {
+			return nil, fmt.Errorf("expect should be a list of status:<range> or ctype:<type> conditions. Got: %s", cond)
+		}
+		switch p[0] {
+		case "status":
+			r := strings.SplitN(p[1], "-", 2)
+			if len(r) == 1 {
+				r = append(r, r[0])
+			}
+			min, err1 := strconv.Atoi(r[0])
+			max, err2 := strconv.Atoi(r[1])
+			if err1 != nil || err2 != nil || min < 100 || max > 599 || min > max {
+				return nil, fmt.Errorf("expect status should be a status code or a range of status codes. Got: %s", p[1])
+			}
+			e.MinStatus, e.MaxStatus = min, max
+		case "ctype":
+			for _, t := range strings.Split(p[1], "|") {
+				e.ContentTypes = append(e.ContentTypes, strings.ToLower(strings.TrimSpace(t)))
+			}
+		default:
+			return nil, fmt.Errorf("expect should be a list of status:<range> or ctype:<type> conditions. Got: %s", cond)
+		}
+	}
+	return e, nil
+}
+
+// Match returns whether the status code and the content type
+// of a response meet the expectation. The parameters of the
+// content type are ignored.
+func (e *Expect) Match(code int, h http.Header) bool {
+	if e == nil {
+		return true
+	}
+	if e.MaxStatus > 0 && (code < e.MinStatus || code > e.MaxStatus) {
+		return false
+	}
+	if len(e.ContentTypes) == 0 {
+		return true
+	}
+	ctype, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range e.ContentTypes {
+		if ctype == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseExpect(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *Expect
+		err  bool
+	}{
+		{"status:200", &Expect{MinStatus: 200, MaxStatus: 200}, false},
+		{"status:200-299,ctype:application/json", &Expect{MinStatus: 200, MaxStatus: 299, ContentTypes: []string{"application/json"}}, false},
+		{"ctype:Application/JSON|application/problem+json", &Expect{ContentTypes: []string{"application/json", "application/problem+json"}}, false},
+		{"status:299-200", nil, true},
+		{"status:2xx", nil, true},
+		{"status:600", nil, true},
+		{"ctype:", nil, true},
+		{"body:ok", nil, true},
+		{"application/json", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseExpect(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectMatch(t *testing.T) {
+	e := &Expect{MinStatus: 200, MaxStatus: 299, ContentTypes: []string{"application/json"}}
+
+	tests := []struct {
+		code  int
+		ctype string
+		want  bool
+	}{
+		{200, "application/json", true},
+		{204, "application/json; charset=utf-8", true},
+		{200, "text/html", false},
+		{200, "", false},
+		{500, "application/json", false},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{"Content-Type": []string{tt.ctype}}
+		if got, want := e.Match(tt.code, h), tt.want; got != want {
+			t.Errorf("%d %q: got %v want %v", tt.code, tt.ctype, got, want)
+		}
+	}
+
+	var none *Expect
+	if !none.Match(500, http.Header{}) {
+		t.Fatal("nil expectation should match all responses")
+	}
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FallbackDir is the directory with the files of the 'emptyfallback'
+// and 'breakerfallback' options. The paths of the file URLs are
+// relative to the directory. File fallbacks are rejected if the value
+// is empty. It is set by the caller.
+var FallbackDir string
+
+// errFallbackDir is returned for file fallbacks without FallbackDir.
+var errFallbackDir = errors.New("file fallbacks require proxy.fallback.dir")
+
+// fallbackFile returns the path of the file of the file URL u within
+// FallbackDir. Paths which leave the directory are rejected since the
+// options are set by the registered services.
+func fallbackFile(u *url.URL) (string, error) {
+	if FallbackDir == "" {
+		return "", errFallbackDir
+	}
+	if u.Host != "" || u.Path == "" {
+		return "", fmt.Errorf("fallback file should be file:///<path>. Got: %s", u)
+	}
+	for _, s := range strings.Split(u.Path, "/") {
+		if s == ".." {
+			return "", fmt.Errorf("fallback file must not contain '..'. Got: %s", u)
+		}
+	}
+	return filepath.Join(FallbackDir, filepath.Clean(filepath.FromSlash(u.Path))), nil
+}
+
+// keepEmptyFallbacks adds the routes of the previous table with an
+// 'emptyfallback' option which are missing from t since all of their
+// targets have been deregistered or are unhealthy. The route gets a
+// single target which sends the requests to the fallback. The route is
+// replaced when the service registers again.
+func keepEmptyFallbacks(t, prev Table) {
+	for host, routes := range prev {
+		for _, r := range routes {
+			if t[host].find(r.Path) != nil {
+				continue
+			}
+			var fb *Target
+			for _, tg := range r.Targets {
+				if tg.EmptyFallback != nil {
+					fb = tg
+					break
+				}
+			}
+			if fb == nil {
+				continue
+			}
+
+			u, err := url.Parse(fb.Opts["emptyfallback"])
+			if err != nil {
+				continue
+			}
+			nr := &Route{Host: r.Host, Path: r.Path, Glob: r.Glob}
+			nr.addTarget(fb.Service, u, 0, 0, nil, map[string]string{"emptyfallback": fb.Opts["emptyfallback"]})
+			if len(nr.Targets) == 0 || nr.Targets[0].EmptyFallback == nil {
+				continue
+			}
+			nr.Targets[0].Empty = true
+			if !fb.Empty {
+				log.Printf("[INFO] route: Sending the requests for %s%s to the emptyfallback since service %s has no targets", r.Host, r.Path, fb.Service)
+			}
+			t[host] = append(t[host], nr)
+			sort.Sort(t[host])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package route
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+func TestFallbackFile(t *testing.T) {
+	defer func(dir string) { FallbackDir = dir }(FallbackDir)
+
+	tests := []struct {
+		dir, in, want string
+		err           bool
+	}{
+		{"/var/www", "file:///sorry.html", "/var/www/sorry.html", false},
+		{"/var/www", "file:///errors/sorry.html", "/var/www/errors/sorry.html", false},
+		{"/var/www", "file:///errors/../sorry.html", "", true},
+		{"/var/www", "file:///../../etc/passwd", "", true},
+		{"/var/www", "file://sorry.html", "", true},
+		{"/var/www", "file://", "", true},
+		{"", "file:///sorry.html", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			FallbackDir = tt.dir
+			u, err := url.Parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := fallbackFile(u)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepEmptyFallbacks(t *testing.T) {
+	defer func(dir string) { FallbackDir = dir }(FallbackDir)
+	FallbackDir = "/var/www"
+
+	mustTable := func(s string) Table {
+		tbl, err := NewTable(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+
+	prev := mustTable(`
+		route add svc-a /a http://1.2.3.4/ opts "emptyfallback=http://sorry/"
+		route add svc-b /b http://1.2.3.4/ opts "emptyfallback=file:///sorry.html"
+		route add svc-c /c http://1.2.3.4/
+		route add svc-d /d http://1.2.3.4/ opts "emptyfallback=http://sorry/"
+	`)
+	tbl := mustTable(`route add svc-d /d http://5.6.7.8/`)
+	keepEmptyFallbacks(tbl, prev)
+
+	for _, path := range []string{"/a", "/b"} {
+		r := tbl[""].find(path)
+		if r == nil {
+			t.Fatalf("%s: route missing", path)
+		}
+		if len(r.Targets) != 1 || !r.Targets[0].Empty || r.Targets[0].EmptyFallback == nil {
+			t.Fatalf("%s: got targets %v want one empty fallback target", path, r.Targets)
+		}
+	}
+	if got, want := tbl[""].find("/b").Targets[0].EmptyFallback.Path, "/var/www/sorry.html"; got != want {
+		t.Fatalf("got fallback file %q want %q", got, want)
+	}
+	if tbl[""].find("/c") != nil {
+		t.Fatal("route /c without emptyfallback was kept")
+	}
+	if got, want := tbl[""].find("/d").Targets[0].URL.Host, "5.6.7.8"; got != want {
+		t.Fatalf("got target %s of registered route want %s", got, want)
+	}
+	if got, want := tbl.String(), "route add svc-d /d http://5.6.7.8/"; got != want {
+		t.Fatalf("got config %q want %q", got, want)
+	}
+
+	// the fallback is kept until the service registers again
+	next := mustTable("")
+	keepEmptyFallbacks(next, tbl)
+	if next[""].find("/a") == nil {
+		t.Fatal("route /a was not kept")
+	}
+	next = mustTable(`route add svc-a /a http://1.2.3.4/`)
+	keepEmptyFallbacks(next, tbl)
+	if r := next[""].find("/a"); len(r.Targets) != 1 || r.Targets[0].Empty {
+		t.Fatal("route /a was not replaced")
+	}
+}
@@ -0,0 +1,66 @@
+package route
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ParseAllowMethods parses the value of the 'allowmethods' option which
+// is a comma separated list of HTTP methods, e.g. "GET,HEAD,POST". The
+// methods are returned in upper case.
+func ParseAllowMethods(s string) ([]string, error) {
+	var methods []string
+	for _, m := range strings.Split(s, ",") {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m == "" || strings.ContainsAny(m, " \t()<>@;:\\\"/[]?={}") {
+			return nil, errors.New("invalid method " + m)
+		}
+		methods = append(methods, m)
+	}
+	return methods, nil
+}
+
+// ParseBlockPaths parses the value of the 'blockpaths' option which is
+// a comma separated list of regular expressions. A literal comma within
+// an expression has to be written as \x2c.
+func ParseBlockPaths(s string) ([]*regexp.Regexp, error) {
+	var paths []*regexp.Regexp
+	for _, p := range strings.Split(s, ",") {
+		if p == "" {
+			return nil, errors.New("empty expression")
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, re)
+	}
+	return paths, nil
+}
+
+// MethodAllowed returns true if the target accepts requests with the
+// method. All methods are allowed if the route has no 'allowmethods'
+// option.
+func (t *Target) MethodAllowed(method string) bool {
+	if len(t.AllowMethods) == 0 {
+		return true
+	}
+	for _, m := range t.AllowMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// PathBlocked returns true if the path matches one of the expressions
+// of the 'blockpaths' option of the target.
+func (t *Target) PathBlocked(path string) bool {
+	for _, re := range t.BlockPaths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAllowMethods(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"GET", []string{"GET"}, false},
+		{"get, Post ,HEAD", []string{"GET", "POST", "HEAD"}, false},
+		{"GET,,POST", nil, true},
+		{"GET,PO/ST", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAllowMethods(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetRequestFilter(t *testing.T) {
+	methods, err := ParseAllowMethods("GET,HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := ParseBlockPaths(`^/admin,\.php$,^/a\x2cb`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseBlockPaths("(,)"); err == nil {
+		t.Fatal("got nil want error")
+	}
+
+	tg := &Target{AllowMethods: methods, BlockPaths: paths}
+	for m, want := range map[string]bool{"GET": true, "HEAD": true, "POST": false, "DELETE": false} {
+		if got := tg.MethodAllowed(m); got != want {
+			t.Fatalf("%s: got %v want %v", m, got, want)
+		}
+	}
+	for p, want := range map[string]bool{"/admin/users": true, "/index.php": true, "/a,b": true, "/": false, "/api/admin": false, "/ab.phpx": false} {
+		if got := tg.PathBlocked(p); got != want {
+			t.Fatalf("%s: got %v want %v", p, got, want)
+		}
+	}
+
+	var all Target
+	if !all.MethodAllowed("PATCH") || all.PathBlocked("/admin") {
+		t.Fatal("target without options should not filter requests")
+	}
+}
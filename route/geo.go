@@ -0,0 +1,108 @@
+package route
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ClientCountry returns the country code of the client of the request
+// or an empty string if it cannot be resolved. Targets with a
+// 'match=geo:country=...' option are not used when it is nil.
+var ClientCountry func(r *http.Request) string
+
+// parseGeoMatch parses the value of a 'match=geo:country=<codes>' option
+// and returns the upper case country codes.
+func parseGeoMatch(m string) ([]string, bool) {
+	s := strings.TrimPrefix(m, "geo:country=")
+	if s == m {
+		return nil, false
+	}
+	var codes []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			return nil, false
+		}
+		codes = append(codes, c)
+	}
+	return codes, true
+}
+
+// weighGeo groups the targets by the countries of their
+// 'match=geo:country=...' option and weighs the targets of each group
+// separately. It returns false if there are no such targets.
+func (r *Route) weighGeo() bool {
+	r.geo, r.geoFallback = nil, nil
+
+	var defaults []*Target
+	groups := map[string][]*Target{}
+	for _, t := range r.Targets {
+		if len(t.GeoCountries) == 0 {
+			defaults = append(defaults, t)
+			continue
+		}
+		for _, c := range t.GeoCountries {
+			groups[c] = append(groups[c], t)
+		}
+	}
+	if len(groups) == 0 {
+		return false
+	}
+
+	r.geo = map[string]*Route{}
+	for c, targets := range groups {
+		v := &Route{Host: r.Host, Path: r.Path, Targets: targets}
+		v.weighAccept()
+		r.geo[c] = v
+	}
+	r.geoFallback = &Route{Host: r.Host, Path: r.Path, Targets: defaults}
+	r.geoFallback.weighAccept()
+	r.negotiated, r.fallback, r.wTargets = nil, nil, nil
+	return true
+}
+
+// locate returns the route with the targets for the country of the
+// client. Requests from other countries and requests for which the
+// country cannot be resolved are routed to the targets without a
+// 'match=geo:...' option.
+func (r *Route) locate(country func() string) *Route {
+	if r.geoFallback == nil {
+		return r
+	}
+	if c := country(); c != "" {
+		if v := r.geo[c]; v != nil {
+			return v
+		}
+	}
+	return r.geoFallback
+}
+
+// weighGroups returns the routes which contain the weighted targets of
+// the route. These are the route itself or the routes for the fingerprints,
+// countries and media types of the 'match=...' options of the targets.
+func (r *Route) weighGroups() []*Route {
+	switch {
+	case r.ja3Fallback != nil:
+		var groups []*Route
+		for _, g := range r.ja3Groups() {
+			groups = append(groups, g.weighGroups()...)
+		}
+		return groups
+	case r.geoFallback != nil:
+		var countries []string
+		for c := range r.geo {
+			countries = append(countries, c)
+		}
+		sort.Strings(countries)
+		var groups []*Route
+		for _, c := range countries {
+			groups = append(groups, r.geo[c].weighGroups()...)
+		}
+		return append(groups, r.geoFallback.weighGroups()...)
+	case r.fallback != nil:
+		return append(append([]*Route{}, r.negotiated...), r.fallback)
+	default:
+		return []*Route{r}
+	}
+}
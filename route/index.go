@@ -0,0 +1,262 @@
+package route
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gobwas/glob"
+)
+
+// activeIndex stores the lookup index of the active routing table.
+// It is built by SetTable and is never nil.
+var activeIndex atomic.Value
+
+// tableIndex is the compiled lookup structure of a routing table. It
+// finds the hosts which match a request and the routes whose path is a
+// prefix of the request path without testing every host and route of
+// the table. The index returns the same candidates in the same order as
+// the linear search, and the candidates are then matched like before,
+// so the precedence and the matching rules do not change.
+//
+// The index is only used for the active routing table which must not
+// be modified after SetTable. Lookups on other tables use the linear
+// search.
+type tableIndex struct {
+	// table is the address of the indexed table t.
+	table uintptr
+	t     Table
+
+	// hosts maps the normalized host patterns of the table to the
+	// patterns for plain HTTP and for TLS requests. The patterns differ
+	// from the normalized value if they contain the default port.
+	hosts, tlsHosts map[string][]string
+
+	// globs contains the host patterns with glob characters which
+	// are matched against every request.
+	globs []string
+
+	// paths and ipaths contain the radix trees of the route paths
+	// of every host for the prefix and the iprefix matcher. They are
+	// built on first use.
+	paths, ipaths         map[string]*pathNode
+	pathsOnce, ipathsOnce sync.Once
+}
+
+// newTableIndex returns the index of the routing table.
+func newTableIndex(t Table) *tableIndex {
+	idx := &tableIndex{
+		table:    tablePtr(t),
+		hosts:    map[string][]string{},
+		tlsHosts: map[string][]string{},
+		t:        t,
+	}
+	for pattern := range t {
+		h, th := normalizeHost(pattern, false), normalizeHost(pattern, true)
+		idx.hosts[h] = append(idx.hosts[h], pattern)
+		idx.tlsHosts[th] = append(idx.tlsHosts[th], pattern)
+		if isGlob(pattern) {
+			idx.globs = append(idx.globs, pattern)
+		}
+	}
+	return idx
+}
+
+// index returns the lookup index of the table or nil if the table
+// is not the active routing table.
+func (t Table) index() *tableIndex {
+	idx, _ := activeIndex.Load().(*tableIndex)
+	if idx == nil || idx.table != tablePtr(t) {
+		return nil
+	}
+	return idx
+}
+
+func tablePtr(t Table) uintptr {
+	return reflect.ValueOf(t).Pointer()
+}
+
+// isGlob returns whether the host pattern contains characters which
+// have a special meaning for the glob matcher. Other patterns only
+// match the identical host.
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[]{}\!`)
+}
+
+// matchingHosts returns the same hosts as Table.matchingHosts.
+func (idx *tableIndex) matchingHosts(req *http.Request, globCache *GlobCache) (hosts []string) {
+	tls := req.TLS != nil
+	host := normalizeHost(req.Host, tls)
+	exact := idx.hosts
+	if tls {
+		exact = idx.tlsHosts
+	}
+	for _, pattern := range exact[host] {
+		if !isGlob(pattern) {
+			hosts = append(hosts, pattern)
+		}
+	}
+	for _, pattern := range idx.globs {
+		normpat := normalizeHost(pattern, tls)
+		g, err := globCache.Get(normpat)
+		if err != nil {
+			log.Print("[Error] Compiling glob - ", err)
+			g = glob.MustCompile(normpat)
+		}
+		if g.Match(host) {
+			hosts = append(hosts, pattern)
+		}
+	}
+	return sortHostsReverseHostPort(hosts)
+}
+
+// matchingHostNoGlob returns the same hosts as Table.matchingHostNoGlob.
+func (idx *tableIndex) matchingHostNoGlob(req *http.Request) (hosts []string) {
+	tls := req.TLS != nil
+	exact := idx.hosts
+	if tls {
+		exact = idx.tlsHosts
+	}
+	for _, pattern := range exact[normalizeHostNoLower(req.Host, tls)] {
+		hosts = append(hosts, strings.ToLower(pattern))
+	}
+	return sortHostsReverseHostPort(hosts)
+}
+
+// candidates returns the routes of the host in their order whose path
+// can match the path or its alternative with the added or removed
+// trailing slash. ok is false if the matcher cannot be indexed and all
+// routes of the host must be tested.
+func (idx *tableIndex) candidates(host, path, alt string, match matcher) (routes Routes, ok bool) {
+	var trees map[string]*pathNode
+	switch reflect.ValueOf(match).Pointer() {
+	case prefixMatcherPtr:
+		idx.pathsOnce.Do(func() { idx.paths = idx.buildPaths(false) })
+		trees = idx.paths
+	case iPrefixMatcherPtr:
+		idx.ipathsOnce.Do(func() { idx.ipaths = idx.buildPaths(true) })
+		trees = idx.ipaths
+		path, alt = strings.ToLower(path), strings.ToLower(alt)
+	default:
+		return nil, false
+	}
+
+	tree := trees[host]
+	if tree == nil {
+		return nil, true
+	}
+	pos := tree.prefixes(path, nil)
+	if alt != "" {
+		pos = tree.prefixes(alt, pos)
+	}
+	sort.Ints(pos)
+	all := idx.t[host]
+	for i, p := range pos {
+		if i > 0 && p == pos[i-1] {
+			continue
+		}
+		routes = append(routes, all[p])
+	}
+	return routes, true
+}
+
+var (
+	prefixMatcherPtr  = reflect.ValueOf(prefixMatcher).Pointer()
+	iPrefixMatcherPtr = reflect.ValueOf(iPrefixMatcher).Pointer()
+)
+
+// buildPaths returns the radix trees of the route paths of all hosts.
+// The paths are lower cased for the iprefix matcher.
+func (idx *tableIndex) buildPaths(lower bool) map[string]*pathNode {
+	trees := make(map[string]*pathNode, len(idx.t))
+	for host, routes := range idx.t {
+		tree := &pathNode{}
+		for i, r := range routes {
+			p := r.Path
+			if lower {
+				p = strings.ToLower(p)
+			}
+			tree.insert(p, i)
+		}
+		trees[host] = tree
+	}
+	return trees
+}
+
+// pathNode is a node of a radix tree of route paths. The key of a node
+// is the concatenation of the prefixes from the root to the node.
+type pathNode struct {
+	prefix   string
+	children []*pathNode
+
+	// routes contains the positions of the routes of the host
+	// whose path is the key of the node.
+	routes []int
+}
+
+// insert adds the position of the route with the path to the tree.
+func (n *pathNode) insert(path string, pos int) {
+	for {
+		// path is the remainder of the path below n
+		if path == "" {
+			n.routes = append(n.routes, pos)
+			return
+		}
+		var next *pathNode
+		for _, c := range n.children {
+			if c.prefix[0] == path[0] {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			n.children = append(n.children, &pathNode{prefix: path, routes: []int{pos}})
+			return
+		}
+
+		// split the child if it shares only part of its prefix
+		l := commonPrefixLen(next.prefix, path)
+		if l < len(next.prefix) {
+			split := &pathNode{prefix: next.prefix[:l], children: []*pathNode{next}}
+			next.prefix = next.prefix[l:]
+			for i, c := range n.children {
+				if c == next {
+					n.children[i] = split
+				}
+			}
+			next = split
+		}
+		n, path = next, path[l:]
+	}
+}
+
+// prefixes appends the positions of the routes whose path is a prefix
+// of path to pos.
+func (n *pathNode) prefixes(path string, pos []int) []int {
+	for {
+		pos = append(pos, n.routes...)
+		var next *pathNode
+		for _, c := range n.children {
+			if strings.HasPrefix(path, c.prefix) {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return pos
+		}
+		n, path = next, path[len(next.prefix):]
+	}
+}
+
+func commonPrefixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
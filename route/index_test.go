@@ -0,0 +1,119 @@
+package route
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPathNode(t *testing.T) {
+	paths := []string{"/", "/foo", "/foo/", "/foo/bar", "/fob", "/f", "", "/zzz/"}
+	tree := &pathNode{}
+	for i, p := range paths {
+		tree.insert(p, i)
+	}
+
+	tests := []struct {
+		path string
+		want []int
+	}{
+		{"", []int{6}},
+		{"/", []int{0, 6}},
+		{"/f", []int{0, 5, 6}},
+		{"/fo", []int{0, 5, 6}},
+		{"/foo/bar/baz", []int{0, 1, 2, 3, 5, 6}},
+		{"/fob", []int{0, 4, 5, 6}},
+		{"/zzz", []int{0, 6}},
+		{"x", []int{6}},
+	}
+	for _, tt := range tests {
+		got := tree.prefixes(tt.path, nil)
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestTableIndex verifies that the lookups with the index of the
+// active routing table return the same targets as the lookups
+// without it.
+func TestTableIndex(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:1/
+	route add svc /foo http://foo.com:2/
+	route add svc /foo/ http://foo.com:3/
+	route add svc /foo/bar http://foo.com:4/
+	route add svc /Foo/Baz http://foo.com:5/
+	route add svc /fob http://foo.com:6/ opts "priority=10"
+	route add svc /api/ http://foo.com:7/ opts "trailingslash=ignore"
+	route add svc /doc/ http://foo.com:8/ opts "trailingslash=strict"
+	route add svc /red/ http://foo.com:9/ opts "trailingslash=redirect"
+	route add svc abc.com/ http://abc.com:1/
+	route add svc abc.com/foo http://abc.com:2/
+	route add svc abc.com:80/foo/bar http://abc.com:3/
+	route add svc abc.com:443/foo/bar http://abc.com:4/
+	route add svc *.abc.com/ http://abc.com:5/
+	route add svc *.a.abc.com/foo http://abc.com:6/
+	route add svc x?z.com/ http://xyz.com:1/
+	route add svc :1234 tcp://tcp.com:1/
+	`
+	hosts := []string{"", "abc.com", "ABC.com", "abc.com:80", "abc.com:443", "www.abc.com", "b.a.abc.com", "xyz.com", "xz.com", "other.com", ":1234"}
+	paths := []string{"", "/", "/f", "/foo", "/foo/", "/FOO/baz", "/foo/bar/x", "/fob/x", "/api", "/api/", "/doc", "/red", "/x"}
+
+	indexed, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linear, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := GetTable()
+	defer SetTable(prev)
+	if err := SetTable(indexed); err != nil {
+		t.Fatal(err)
+	}
+	if indexed.index() == nil || linear.index() != nil {
+		t.Fatal("index of the active table only")
+	}
+
+	first := func(r *Route) *Target { return r.Targets[0] }
+	target := func(tg *Target) string {
+		if tg == nil {
+			return "<nil>"
+		}
+		if tg.RedirectCode != 0 {
+			return fmt.Sprintf("%d %s", tg.RedirectCode, tg.URL)
+		}
+		return tg.URL.String()
+	}
+	for _, name := range []string{"prefix", "iprefix", "glob"} {
+		match := Matcher[name]
+		for _, globDisabled := range []bool{false, true} {
+			for _, secure := range []bool{false, true} {
+				for _, host := range hosts {
+					for _, path := range paths {
+						req := func() *http.Request {
+							req := &http.Request{Host: host, URL: mustParse("http://" + host + path), Header: http.Header{}}
+							req.URL.Path = path
+							if secure {
+								req.TLS = &tls.ConnectionState{}
+							}
+							return req
+						}
+						got := indexed.Lookup(req(), "", first, match, globCache, globDisabled)
+						want := linear.Lookup(req(), "", first, match, globCache, globDisabled)
+						if target(got) != target(want) {
+							t.Errorf("%s glob disabled=%v tls=%v %s%s: got %s want %s", name, globDisabled, secure, host, path, target(got), target(want))
+						}
+					}
+				}
+			}
+		}
+	}
+}
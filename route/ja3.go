@@ -0,0 +1,102 @@
+package route
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ClientJA3 returns the JA3 fingerprint of the TLS client of the request
+// or an empty string if it is not known. Targets with a 'match=ja3:...'
+// option are not used when it is nil.
+var ClientJA3 func(r *http.Request) string
+
+// parseJA3Match parses the value of a 'match=ja3:<hash>,<hash>' option
+// and returns the lower case fingerprints.
+func parseJA3Match(m string) ([]string, bool) {
+	s := strings.TrimPrefix(m, "ja3:")
+	if s == m {
+		return nil, false
+	}
+	var fps []string
+	for _, fp := range strings.Split(s, ",") {
+		fp = strings.ToLower(strings.TrimSpace(fp))
+		if len(fp) != 32 || strings.Trim(fp, "0123456789abcdef") != "" {
+			return nil, false
+		}
+		fps = append(fps, fp)
+	}
+	return fps, true
+}
+
+// weighJA3 groups the targets by the fingerprints of their
+// 'match=ja3:...' option and weighs the targets of each group
+// separately. It returns false if there are no such targets.
+func (r *Route) weighJA3() bool {
+	r.ja3, r.ja3Fallback = nil, nil
+
+	var defaults []*Target
+	groups := map[string][]*Target{}
+	for _, t := range r.Targets {
+		if len(t.JA3Match) == 0 {
+			defaults = append(defaults, t)
+			continue
+		}
+		for _, fp := range t.JA3Match {
+			groups[fp] = append(groups[fp], t)
+		}
+	}
+	if len(groups) == 0 {
+		return false
+	}
+
+	r.ja3 = map[string]*Route{}
+	for fp, targets := range groups {
+		v := &Route{Host: r.Host, Path: r.Path, Targets: targets}
+		if !v.weighGeo() {
+			v.weighAccept()
+		}
+		r.ja3[fp] = v
+	}
+	r.ja3Fallback = &Route{Host: r.Host, Path: r.Path, Targets: defaults}
+	if !r.ja3Fallback.weighGeo() {
+		r.ja3Fallback.weighAccept()
+	}
+	r.geo, r.geoFallback = nil, nil
+	r.negotiated, r.fallback, r.wTargets = nil, nil, nil
+	return true
+}
+
+// fingerprint returns the route with the targets for the JA3 fingerprint
+// of the client. Requests with other fingerprints and requests without a
+// fingerprint are routed to the targets without a 'match=ja3:...' option.
+func (r *Route) fingerprint(ja3 func() string) *Route {
+	if r.ja3Fallback == nil {
+		return r
+	}
+	if fp := ja3(); fp != "" {
+		if v := r.ja3[fp]; v != nil {
+			return v
+		}
+	}
+	return r.ja3Fallback
+}
+
+// ja3Groups returns the routes for the fingerprints of the
+// 'match=ja3:...' options in a stable order followed by the
+// route for the other targets.
+func (r *Route) ja3Groups() []*Route {
+	var fps []string
+	for fp := range r.ja3 {
+		fps = append(fps, fp)
+	}
+	sort.Strings(fps)
+	var groups []*Route
+	for _, fp := range fps {
+		groups = append(groups, r.ja3[fp])
+	}
+	return append(groups, r.ja3Fallback)
+}
+
+// noJA3 is used for lookups without a client fingerprint.
+func noJA3() string { return "" }
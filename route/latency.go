@@ -0,0 +1,190 @@
+package route
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// AdaptiveLatency configures the weighting of the targets by their
+// response latency for the adaptive-latency strategy.
+type AdaptiveLatency struct {
+	// Smoothing is the factor between 0 and 1 of the moving average
+	// of the response latency. Higher values follow changes faster.
+	Smoothing float64
+
+	// Interval is the interval in which the weights are recomputed.
+	Interval time.Duration
+
+	// MinFactor and MaxFactor bound the factor with which the weight
+	// of a target is scaled. This keeps slow targets from starving and
+	// dampens the oscillation of the weights.
+	MinFactor, MaxFactor float64
+}
+
+// Latency configures the adaptive latency weighting and is set by the
+// caller.
+var Latency = AdaptiveLatency{
+	Smoothing: 0.3,
+	Interval:  10 * time.Second,
+	MinFactor: 0.2,
+	MaxFactor: 5,
+}
+
+// latencyMinSamples is the number of responses after which the latency
+// of a target is used for its weight.
+const latencyMinSamples = 10
+
+// latencies contains the latency averages of the targets by outlierKey.
+// They are kept across routing tables so that a new routing table does
+// not reset the weights.
+var latencies sync.Map
+
+// latencyFor returns the latency average of the target.
+func latencyFor(t *Target) *latencyAvg {
+	l, _ := latencies.LoadOrStore(outlierKey(t), &latencyAvg{})
+	return l.(*latencyAvg)
+}
+
+// syncLatencies removes the latency averages of the targets
+// which are no longer part of the routing table.
+func syncLatencies(t Table) {
+	deleteInactive(&latencies, t)
+}
+
+// latencyAvg is an exponentially weighted moving average of the
+// response latency of a target and the factor for its weight which
+// is derived from it.
+type latencyAvg struct {
+	mu      sync.Mutex
+	value   float64
+	samples int
+	factor  float64
+}
+
+// update adds the latency d to the average.
+func (l *latencyAvg) update(d time.Duration, alpha float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v := d.Seconds()
+	if l.samples == 0 {
+		l.value = v
+	} else {
+		l.value += alpha * (v - l.value)
+	}
+	l.samples++
+}
+
+// get returns the average and whether it is based on enough samples.
+func (l *latencyAvg) get() (float64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.value, l.samples >= latencyMinSamples
+}
+
+func (l *latencyAvg) setFactor(f float64) {
+	l.mu.Lock()
+	l.factor = f
+	l.mu.Unlock()
+}
+
+func (l *latencyAvg) getFactor() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.factor == 0 {
+		return 1
+	}
+	return l.factor
+}
+
+// UpdateLatency records the response latency of the target.
+// It is used by the adaptive latency picker.
+func (t *Target) UpdateLatency(d time.Duration) {
+	if t.latency == nil || d < 0 {
+		return
+	}
+	t.latency.update(d, Latency.Smoothing)
+}
+
+// LatencyFactor returns the factor with which the weight of the target
+// is scaled by the adaptive latency picker. It is 1 until the weights
+// have been adjusted.
+func (t *Target) LatencyFactor() float64 {
+	if t.latency == nil {
+		return 1
+	}
+	return t.latency.getFactor()
+}
+
+// adjustLatencyWeights sets the weight factors of the targets of the
+// route inversely proportional to their average latency relative to the
+// mean latency of the route. Targets without enough samples keep the
+// factor 1 and all targets are weighted equally unless at least two
+// targets have enough samples.
+func (r *Route) adjustLatencyWeights(cfg AdaptiveLatency) {
+	avgs := make([]float64, len(r.Targets))
+	var sum float64
+	var n int
+	for i, t := range r.Targets {
+		avgs[i] = -1
+		if t.latency == nil {
+			continue
+		}
+		if avg, ok := t.latency.get(); ok {
+			avgs[i] = avg
+			sum += avg
+			n++
+		}
+	}
+
+	for i, t := range r.Targets {
+		if t.latency == nil {
+			continue
+		}
+		f := 1.0
+		switch {
+		case n < 2 || avgs[i] < 0:
+		case avgs[i] == 0:
+			f = cfg.MaxFactor
+		default:
+			f = sum / float64(n) / avgs[i]
+		}
+		if f < cfg.MinFactor {
+			f = cfg.MinFactor
+		}
+		if f > cfg.MaxFactor {
+			f = cfg.MaxFactor
+		}
+		t.latency.setFactor(f)
+	}
+}
+
+// AdjustLatencyWeights recomputes the weight factors of the targets of
+// all routes of the table for the adaptive latency picker.
+func AdjustLatencyWeights(t Table) {
+	cfg := Latency
+	for _, routes := range t {
+		for _, r := range routes {
+			r.adjustLatencyWeights(cfg)
+		}
+	}
+}
+
+// RunLatencyWeights recomputes the weight factors of the targets of the
+// active routing table in the configured interval until done is closed.
+func RunLatencyWeights(done <-chan struct{}) {
+	if Latency.Interval <= 0 {
+		log.Print("[ERROR] route: Interval for the adaptive latency weights must be positive")
+		return
+	}
+	t := time.NewTicker(Latency.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			AdjustLatencyWeights(GetTable())
+		}
+	}
+}
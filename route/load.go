@@ -0,0 +1,94 @@
+package route
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// loadAlpha is the smoothing factor for the moving average of the
+	// reported backend load.
+	loadAlpha = 0.3
+
+	// loadDecay is the time constant with which the reported load of a
+	// target decays towards zero when the target no longer reports it.
+	// This ensures that targets which have been avoided because of a high
+	// load eventually receive traffic again.
+	loadDecay = 10 * time.Second
+)
+
+// loads contains the load averages of the targets by outlierKey.
+// They are kept across routing tables so that a new routing table
+// does not discard the reported load.
+var loads sync.Map
+
+// loadFor returns the load average of the target.
+func loadFor(t *Target) *loadAvg {
+	l, _ := loads.LoadOrStore(outlierKey(t), &loadAvg{})
+	return l.(*loadAvg)
+}
+
+// syncLoads removes the load averages of the targets
+// which are no longer part of the routing table.
+func syncLoads(t Table) {
+	deleteInactive(&loads, t)
+}
+
+// loadAvg is an exponentially weighted moving average of the load
+// reported by a backend.
+type loadAvg struct {
+	mu      sync.Mutex
+	value   float64
+	updated time.Time
+}
+
+// update adds the reported load v at time now to the average.
+func (l *loadAvg) update(v float64, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.updated.IsZero() {
+		l.value = v
+	} else {
+		cur := l.decayed(now)
+		l.value = cur + loadAlpha*(v-cur)
+	}
+	l.updated = now
+}
+
+// get returns the decayed average at time now.
+func (l *loadAvg) get(now time.Time) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.decayed(now)
+}
+
+func (l *loadAvg) decayed(now time.Time) float64 {
+	if l.updated.IsZero() {
+		return 0
+	}
+	age := now.Sub(l.updated)
+	if age <= 0 {
+		return l.value
+	}
+	return l.value * math.Exp(-float64(age)/float64(loadDecay))
+}
+
+// UpdateLoad records the load reported by the backend of the target.
+// It is used by the adaptive load picker.
+func (t *Target) UpdateLoad(v float64) {
+	if t.load == nil || math.IsNaN(v) || v < 0 {
+		return
+	}
+	t.load.update(v, time.Now())
+}
+
+// Load returns the moving average of the load reported by the backend
+// of the target. The value decays towards zero if the backend has not
+// reported a load recently.
+func (t *Target) Load() float64 {
+	if t.load == nil {
+		return 0
+	}
+	return t.load.get(time.Now())
+}
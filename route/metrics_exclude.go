@@ -0,0 +1,75 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// MetricsExclude contains the routes whose targets do not record
+// per-route metrics. It is set by the caller and evaluated when the
+// targets are added to the routing table.
+var MetricsExclude *MetricsFilter
+
+// MetricsFilter matches the targets which are excluded from the metrics.
+type MetricsFilter struct {
+	// Totals is true if the requests of the excluded targets are
+	// also not counted in the global metrics.
+	Totals bool
+
+	tags  []string
+	names []glob.Glob
+}
+
+// ParseMetricsFilter parses the value of metrics.exclude which is a
+// comma separated list of 'tag:<tag>' and 'name:<glob>' patterns. A tag
+// pattern matches targets with the tag. A name pattern matches targets
+// whose service name, route path or host and path match the glob, e.g.
+// 'name:/health*'. An empty value returns nil.
+func ParseMetricsFilter(s string) (*MetricsFilter, error) {
+	var f *MetricsFilter
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if f == nil {
+			f = &MetricsFilter{}
+		}
+		switch {
+		case strings.HasPrefix(p, "tag:") && len(p) > len("tag:"):
+			f.tags = append(f.tags, p[len("tag:"):])
+		case strings.HasPrefix(p, "name:") && len(p) > len("name:"):
+			g, err := glob.Compile(p[len("name:"):])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metrics exclude pattern %q: %s", p, err)
+			}
+			f.names = append(f.names, g)
+		default:
+			return nil, fmt.Errorf("invalid metrics exclude pattern %q. Must be tag:<tag> or name:<glob>", p)
+		}
+	}
+	return f, nil
+}
+
+// Match returns true if the target of the service with the tags for
+// the route host and path is excluded.
+func (f *MetricsFilter) Match(service string, tags []string, host, path string) bool {
+	if f == nil {
+		return false
+	}
+	for _, t := range f.tags {
+		for _, tag := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	for _, g := range f.names {
+		if g.Match(service) || g.Match(path) || g.Match(host+path) {
+			return true
+		}
+	}
+	return false
+}
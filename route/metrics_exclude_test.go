@@ -0,0 +1,106 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetricsFilter(t *testing.T) {
+	tests := []struct {
+		in  string
+		err bool
+		nil bool
+	}{
+		{"", false, true},
+		{" , ", false, true},
+		{"tag:internal", false, false},
+		{"tag:internal,name:/health*", false, false},
+		{"tag:", true, false},
+		{"name:", true, false},
+		{"name:[", true, false},
+		{"internal", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			f, err := ParseMetricsFilter(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !tt.err && (f == nil) != tt.nil {
+				t.Fatalf("got filter %v want nil %v", f, tt.nil)
+			}
+		})
+	}
+}
+
+func TestMetricsFilterMatch(t *testing.T) {
+	f, err := ParseMetricsFilter("tag:internal,name:/health*,name:admin-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc       string
+		service    string
+		tags       []string
+		host, path string
+		want       bool
+	}{
+		{"tag", "svc", []string{"a", "internal"}, "", "/foo", true},
+		{"other tag", "svc", []string{"a"}, "", "/foo", false},
+		{"path", "svc", nil, "", "/healthz", true},
+		{"path with host", "svc", nil, "example.com", "/health/live", true},
+		{"service", "admin-api", nil, "", "/", true},
+		{"no match", "svc", nil, "example.com", "/api", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := f.Match(tt.service, tt.tags, tt.host, tt.path), tt.want; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+
+	var nilFilter *MetricsFilter
+	if nilFilter.Match("svc", []string{"internal"}, "", "/health") {
+		t.Fatal("nil filter should not match")
+	}
+}
+
+func TestMetricsExclude(t *testing.T) {
+	oldRegistry, oldExclude := ServiceRegistry, MetricsExclude
+	ServiceRegistry = newStubRegistry()
+	defer func() { ServiceRegistry, MetricsExclude = oldRegistry, oldExclude }()
+
+	var err error
+	MetricsExclude, err = ParseMetricsFilter("tag:internal,name:/health*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tbl := make(Table)
+	tbl.addRoute(&RouteDef{Service: "svc-x", Src: "/aaa", Dst: "http://localhost:4321", Weight: 1})
+	tbl.addRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:1234", Weight: 1, Tags: []string{"internal"}})
+	tbl.addRoute(&RouteDef{Service: "svc-c", Src: "/health", Dst: "http://localhost:1234", Weight: 1})
+	for _, r := range tbl[""] {
+		r.Targets[0].CountStatus(200)
+	}
+
+	want := []string{
+		"svc-x._./aaa.localhost_4321",
+		"svc-x._./aaa.localhost_4321.status_2xx",
+	}
+	if got := ServiceRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	for _, r := range tbl[""] {
+		if got, want := r.Targets[0].NoMetrics, r.Path != "/aaa"; got != want {
+			t.Fatalf("%s: got no metrics %v want %v", r.Path, got, want)
+		}
+		if r.Targets[0].NoTotals {
+			t.Fatalf("%s: got no totals want totals", r.Path)
+		}
+	}
+}
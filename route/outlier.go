@@ -0,0 +1,204 @@
+package route
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// OutlierDetection configures the ejection of targets which return
+// consecutive 5xx responses. Ejected targets are skipped by the pickers
+// until the ejection time has expired.
+type OutlierDetection struct {
+	// Consecutive5xx is the number of consecutive 5xx responses after
+	// which a target is ejected. A value of 0 disables the detection.
+	Consecutive5xx int
+
+	// BaseEjectionTime is the ejection time which is multiplied by the
+	// number of times the target has been ejected in a row.
+	BaseEjectionTime time.Duration
+
+	// MaxEjectionTime is the maximum ejection time.
+	MaxEjectionTime time.Duration
+
+	// MaxEjectionPercent is the maximum percentage of the targets of a
+	// route which can be ejected at the same time. One target can always
+	// be ejected.
+	MaxEjectionPercent int
+}
+
+// Outliers configures the outlier detection and is set by the caller.
+var Outliers OutlierDetection
+
+// Ejections counts the targets which were ejected by the outlier
+// detection and is set by the caller.
+var Ejections metrics.Counter = metrics.NoopCounter{}
+
+// outliers contains the outlier state of the targets by outlierKey.
+// The state is kept across routing tables so that a new routing table
+// does not reinstate the ejected targets.
+var outliers sync.Map
+
+// outlier tracks the consecutive failures and the ejections of a target.
+type outlier struct {
+	mu           sync.Mutex
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// ejected returns whether the target is ejected at time now.
+func (o *outlier) ejected(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return now.Before(o.ejectedUntil)
+}
+
+// remaining returns the remaining ejection time at time now.
+func (o *outlier) remaining(now time.Time) time.Duration {
+	if o == nil {
+		return 0
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !now.Before(o.ejectedUntil) {
+		return 0
+	}
+	return o.ejectedUntil.Sub(now)
+}
+
+// record records a response of the target at time now and returns
+// whether the target has reached the number of consecutive failures
+// for an ejection. Responses of ejected targets are not counted.
+func (o *outlier) record(failed bool, now time.Time, cfg OutlierDetection) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if now.Before(o.ejectedUntil) {
+		return false
+	}
+	if !failed {
+		o.failures = 0
+		return false
+	}
+	o.failures++
+	return o.failures >= cfg.Consecutive5xx
+}
+
+// eject ejects the target at time now and returns the ejection time.
+// The ejection time grows with every ejection up to the maximum and is
+// reset once the target has not been ejected for the maximum ejection
+// time.
+func (o *outlier) eject(now time.Time, cfg OutlierDetection) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.ejectedUntil.IsZero() && now.Sub(o.ejectedUntil) > cfg.MaxEjectionTime {
+		o.ejections = 0
+	}
+	o.ejections++
+	d := cfg.BaseEjectionTime * time.Duration(o.ejections)
+	if d > cfg.MaxEjectionTime || d <= 0 {
+		d = cfg.MaxEjectionTime
+	}
+	o.failures = 0
+	o.ejectedUntil = now.Add(d)
+	return d
+}
+
+// outlierKey returns the key of the outlier state of the target.
+func outlierKey(t *Target) string {
+	return t.Service + " " + t.Prefix + " " + t.URL.String()
+}
+
+// outlierFor returns the outlier state for the target.
+func outlierFor(t *Target) *outlier {
+	o, _ := outliers.LoadOrStore(outlierKey(t), &outlier{})
+	return o.(*outlier)
+}
+
+// syncOutliers removes the outlier state of the targets
+// which are no longer part of the routing table.
+func syncOutliers(t Table) {
+	active := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				active[outlierKey(tg)] = true
+			}
+		}
+	}
+	outliers.Range(func(k, _ interface{}) bool {
+		if !active[k.(string)] {
+			outliers.Delete(k)
+		}
+		return true
+	})
+}
+
+// deleteInactive removes the entries of the targets which are no
+// longer part of the routing table from m which is keyed by outlierKey.
+func deleteInactive(m *sync.Map, t Table) {
+	active := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				active[outlierKey(tg)] = true
+			}
+		}
+	}
+	m.Range(func(k, _ interface{}) bool {
+		if !active[k.(string)] {
+			m.Delete(k)
+		}
+		return true
+	})
+}
+
+// Ejected returns whether the target has been ejected
+// by the outlier detection.
+func (t *Target) Ejected() bool {
+	return t.outlier != nil && t.outlier.ejected(time.Now())
+}
+
+// RecordResponse records the status code of a response of the target
+// for the outlier detection. Responses with a 5xx status code are
+// failures.
+func (t *Target) RecordResponse(code int) {
+	t.RecordResult(code >= 500)
+}
+
+// RecordResult records the result of a request of the target for the
+// outlier detection. The target is ejected after the configured number
+// of consecutive failures unless this would eject more than the maximum
+// percentage of the targets of its route. One target of a route can
+// always be ejected.
+func (t *Target) RecordResult(failed bool) {
+	cfg := Outliers
+	if cfg.Consecutive5xx <= 0 || t.outlier == nil {
+		return
+	}
+
+	now := time.Now()
+	if !t.outlier.record(failed, now, cfg) {
+		return
+	}
+
+	ejected := 1
+	for _, p := range t.peers {
+		if p != t && p.outlier != nil && p.outlier.ejected(now) {
+			ejected++
+		}
+	}
+	max := cfg.MaxEjectionPercent * len(t.peers) / 100
+	if max < 1 {
+		max = 1
+	}
+	if ejected > max {
+		return
+	}
+
+	d := t.outlier.eject(now, cfg)
+	Ejections.Inc(1)
+	log.Printf("[INFO] Ejecting %s on %s for %s after %d consecutive failures", t.Service, t.URL, d, cfg.Consecutive5xx)
+}
@@ -0,0 +1,153 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutlierEjectionTime(t *testing.T) {
+	cfg := OutlierDetection{Consecutive5xx: 2, BaseEjectionTime: 10 * time.Second, MaxEjectionTime: 25 * time.Second}
+	now := time.Now()
+
+	o := &outlier{}
+	for i, want := range []time.Duration{10 * time.Second, 20 * time.Second, 25 * time.Second, 25 * time.Second} {
+		if o.record(true, now, cfg) {
+			t.Fatalf("%d: ejected after one failure", i)
+		}
+		if !o.record(true, now, cfg) {
+			t.Fatalf("%d: not ejected after two failures", i)
+		}
+		if got := o.eject(now, cfg); got != want {
+			t.Fatalf("%d: got ejection time %s want %s", i, got, want)
+		}
+		if !o.ejected(now) || o.record(true, now, cfg) {
+			t.Fatalf("%d: responses of ejected targets must not be counted", i)
+		}
+		now = now.Add(want)
+		if o.ejected(now) {
+			t.Fatalf("%d: target not reinstated", i)
+		}
+	}
+
+	// a success resets the consecutive failures
+	o.record(true, now, cfg)
+	o.record(false, now, cfg)
+	if o.record(true, now, cfg) {
+		t.Fatal("ejected after non-consecutive failures")
+	}
+
+	// the ejection time is reset once the target has not been
+	// ejected for the maximum ejection time
+	now = now.Add(time.Minute)
+	if got, want := o.eject(now, cfg), 10*time.Second; got != want {
+		t.Fatalf("got ejection time %s want %s", got, want)
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	prevOutliers, prevEjections := Outliers, Ejections
+	defer func() { Outliers, Ejections = prevOutliers, prevEjections }()
+
+	ejections := &testCounter{}
+	Ejections = ejections
+	Outliers = OutlierDetection{Consecutive5xx: 3, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Hour, MaxEjectionPercent: 50}
+
+	s := `
+	route add outlier-svc /outlier http://1.1.1.1:1
+	route add outlier-svc /outlier http://1.1.1.1:2
+	route add outlier-svc /outlier http://1.1.1.1:3
+	route add outlier-svc /outlier http://1.1.1.1:4
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := tbl[""][0].Targets
+
+	fail := func(tg *Target, n int) {
+		for i := 0; i < n; i++ {
+			tg.RecordResponse(502)
+		}
+	}
+
+	fail(targets[0], 2)
+	targets[0].RecordResponse(200)
+	fail(targets[0], 2)
+	if targets[0].Ejected() {
+		t.Fatal("target ejected after non-consecutive 5xx responses")
+	}
+	fail(targets[0], 1)
+	fail(targets[1], 3)
+	if !targets[0].Ejected() || !targets[1].Ejected() {
+		t.Fatal("targets not ejected")
+	}
+
+	// ejecting a third target would exceed 50%
+	fail(targets[2], 5)
+	if targets[2].Ejected() {
+		t.Fatal("ejected more than the maximum percentage of targets")
+	}
+	if got, want := ejections.n, int64(2); got != want {
+		t.Fatalf("got %d ejections want %d", got, want)
+	}
+
+	for i := 0; i < 100; i++ {
+		req := &http.Request{Host: "abc.com", URL: mustParse("/outlier"), Header: http.Header{}}
+		tg := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globEnabled)
+		if tg == nil {
+			t.Fatal("no target")
+		}
+		if tg == targets[0] || tg == targets[1] {
+			t.Fatalf("picked ejected target %s", tg.URL)
+		}
+	}
+
+	// the state is kept for a new routing table with the same targets
+	tbl2, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tbl2[""][0].Targets[0].Ejected() {
+		t.Fatal("target reinstated by new routing table")
+	}
+
+	if got := tbl.Dump(); !strings.Contains(got, "addr=1.1.1.1:1 weight 0.25 slots 1/4 ejected=1m0s\n") {
+		t.Fatalf("Dump() output does not contain the ejection:\n%s", got)
+	}
+}
+
+func TestOutlierDetectionFewTargets(t *testing.T) {
+	prevOutliers, prevEjections := Outliers, Ejections
+	defer func() { Outliers, Ejections = prevOutliers, prevEjections }()
+
+	Ejections = &testCounter{}
+	Outliers = OutlierDetection{Consecutive5xx: 3, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Hour, MaxEjectionPercent: 10}
+
+	s := `
+	route add few-svc /few http://1.1.1.2:1
+	route add few-svc /few http://1.1.1.2:2
+	route add few-svc /few http://1.1.1.2:3
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := tbl[""][0].Targets
+	for _, tg := range targets[:2] {
+		for i := 0; i < 3; i++ {
+			tg.RecordResponse(503)
+		}
+	}
+
+	// 10% of three targets rounds down to zero but one target
+	// can always be ejected
+	if !targets[0].Ejected() {
+		t.Fatal("first target not ejected")
+	}
+	if targets[1].Ejected() {
+		t.Fatal("ejected more than one target")
+	}
+}
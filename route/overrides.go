@@ -0,0 +1,58 @@
+package route
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+)
+
+// overrides contains the manual overrides of the active routing table.
+var overrides atomic.Value
+
+// SetOverrides records the manual overrides, i.e. the route commands
+// which are applied on top of the routes from the registry.
+func SetOverrides(s string) {
+	overrides.Store(s)
+}
+
+// InactiveOverrides returns the 'route weight' commands of the manual
+// overrides which do not match any target of the active routing table,
+// e.g. since the service is currently not registered. The commands
+// become active again when the service returns.
+func InactiveOverrides() []*RouteDef {
+	s, _ := overrides.Load().(string)
+	return inactiveOverrides(GetTable(), s)
+}
+
+func inactiveOverrides(t Table, s string) []*RouteDef {
+	var inactive []*RouteDef
+	for _, line := range strings.Split(s, "\n") {
+		defs, err := Parse(bytes.NewBufferString(line))
+		if err != nil || len(defs) != 1 || defs[0].Cmd != RouteWeightCmd {
+			continue
+		}
+		if !t.matchesWeight(defs[0]) {
+			inactive = append(inactive, defs[0])
+		}
+	}
+	return inactive
+}
+
+// matchesWeight returns true if the 'route weight'
+// command d matches a target of the table.
+func (t Table) matchesWeight(d *RouteDef) bool {
+	host, path := hostpath(d.Src)
+	if t[host] == nil {
+		return false
+	}
+	r := t[host].find(path)
+	if r == nil {
+		return false
+	}
+	for _, tg := range r.Targets {
+		if (d.Service == "" || tg.Service == d.Service) && (len(d.Tags) == 0 || contains(tg.Tags, d.Tags)) {
+			return true
+		}
+	}
+	return false
+}
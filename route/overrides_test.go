@@ -0,0 +1,38 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInactiveOverrides(t *testing.T) {
+	routes := `
+	route add svc-a /a http://1.1.1.1:1/ tags "blue"
+	route add svc-a /a http://1.1.1.1:2/ tags "green"
+	route add svc-b /b http://1.1.1.1:3/
+	`
+	overrides := `
+	route weight svc-a /a weight 0.2 tags "green"
+	route weight svc-a /a weight 0.2 tags "red"
+	route weight svc-c /c weight 0.5
+	route weight /a weight 0.5 tags "blue"
+	route weight /b weight 1.0 tags "x"
+	route del svc-d
+	`
+
+	// the unmatched weights do not prevent the table from loading
+	tbl, err := NewTable(bytes.NewBufferString(routes + overrides))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range inactiveOverrides(tbl, overrides) {
+		got = append(got, d.Service+" "+d.Src)
+	}
+	want := []string{"svc-a /a", "svc-c /c", " /b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 var (
@@ -29,10 +30,13 @@ route add <svc> <src> <dst>[ weight <w>][ tags "<t1>,<t2>,..."][ opts "k1=v1 k2=
 	  proto=tcp          : upstream service is TCP, dst is ':port'
 	  proto=https        : upstream service is HTTPS
 	  tlsskipverify=true : disable TLS cert validation for HTTPS upstream
+	  scheme=https       : connect to the upstream service via TLS regardless of the scheme of dst
 	  host=name          : set the Host header to 'name'. If 'name == "dst"' then the 'Host' header will be set to the registered upstream host name
 	  register=name      : register fabio as new service 'name'. Useful for registering hostnames for host specific routes.
       auth=name          : name of the auth scheme to use (defined in proxy.auth)
 
+    A backslash escapes a double quote, a backslash or whitespace within an option.
+
 route del <svc>[ <src>[ <dst>]]
   - Remove route matching svc, src and/or dst
 
@@ -134,19 +138,20 @@ func ParseAliases(in string) (names []string, err error) {
 
 // route add <svc> <src> <dst>[ weight <w>][ tags "<t1>,<t2>,..."][ opts "k=v k=v ..."]
 // 1: service 2: src 3: dst 4: weight expr 5: weight val 6: tags expr 7: tags val 8: opts expr 9: opts val
-var reAdd = mustCompileWithFlexibleSpace(`^route add (\S+) (\S+) (\S+)( weight (\S+))?( tags "([^"]*)")?( opts "([^"]*)")?$`)
+var reAdd = mustCompileWithFlexibleSpace(`^route add (\S+) (\S+) (\S+)( weight (\S+))?( tags "([^"]*)")?( opts "((?:[^"\\]|\\.)*)")?$`)
 
 func parseRouteAdd(s string) (*RouteDef, error) {
 	if m := reAdd.FindStringSubmatch(s); m != nil {
-		w, err := parseWeight(m[5])
+		w, rw, err := parseWeight(m[5])
 		return &RouteDef{
-			Cmd:     RouteAddCmd,
-			Service: m[1],
-			Src:     m[2],
-			Dst:     m[3],
-			Weight:  w,
-			Tags:    parseTags(m[7]),
-			Opts:    parseOpts(m[9]),
+			Cmd:            RouteAddCmd,
+			Service:        m[1],
+			Src:            m[2],
+			Dst:            m[3],
+			Weight:         w,
+			RelativeWeight: rw,
+			Tags:           parseTags(m[7]),
+			Opts:           parseOpts(m[9]),
 		}, err
 	}
 	return nil, errors.New("syntax error: 'route add' invalid")
@@ -187,7 +192,7 @@ var reWeightSrc = mustCompileWithFlexibleSpace(`^route weight (\S+) weight (\S+)
 
 func parseRouteWeight(s string) (*RouteDef, error) {
 	if m := reWeightSvc.FindStringSubmatch(s); m != nil {
-		w, err := parseWeight(m[3])
+		w, err := parseFixedWeight(m[3])
 		return &RouteDef{
 			Cmd:     RouteWeightCmd,
 			Service: m[1],
@@ -197,7 +202,7 @@ func parseRouteWeight(s string) (*RouteDef, error) {
 		}, err
 	}
 	if m := reWeightSrc.FindStringSubmatch(s); m != nil {
-		w, err := parseWeight(m[2])
+		w, err := parseFixedWeight(m[2])
 		return &RouteDef{
 			Cmd:    RouteWeightCmd,
 			Src:    m[1],
@@ -212,10 +217,26 @@ func mustCompileWithFlexibleSpace(re string) *regexp.Regexp {
 	return regexp.MustCompile(strings.Replace(re, " ", "\\s+", -1))
 }
 
-func parseWeight(s string) (float64, error) {
+// parseWeight parses a weight which is either a fraction like 0.25 or
+// a positive integer like 3. Integers without a decimal point are
+// relative to the other integer weights of the same service and are
+// returned as the relative weight instead of the weight. Therefore,
+// 'weight 1' is a relative weight and a fixed share of 100% has to be
+// written as 'weight 1.0'.
+func parseWeight(s string) (float64, int, error) {
 	if s == "" {
-		return 0, nil
+		return 0, 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return 0, n, nil
 	}
+	f, err := parseFixedWeight(s)
+	return f, 0, err
+}
+
+// parseFixedWeight parses the weight of a 'route weight' command which
+// does not support relative weights.
+func parseFixedWeight(s string) (float64, error) {
 	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
 		return 0.0, errors.New("syntax error: weight value invalid")
@@ -234,12 +255,16 @@ func parseTags(s string) []string {
 	return tags
 }
 
+// parseOpts parses the options of a 'route add' command which are
+// separated by whitespace. A backslash escapes a double quote, a
+// backslash or whitespace within an option. Other backslashes are
+// part of the option, e.g. in regular expressions.
 func parseOpts(s string) map[string]string {
 	if s == "" {
 		return nil
 	}
 	m := make(map[string]string)
-	for _, f := range strings.Fields(s) {
+	for _, f := range splitOpts(s) {
 		p := strings.SplitN(f, "=", 2)
 		if len(p) == 1 {
 			m[f] = ""
@@ -249,3 +274,52 @@ func parseOpts(s string) map[string]string {
 	}
 	return m
 }
+
+// splitOpts splits s at the whitespace which is not escaped
+// and removes the escapes.
+func splitOpts(s string) []string {
+	var fields []string
+	var b strings.Builder
+	var field bool
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		switch r := rs[i]; {
+		case r == '\\' && i+1 < len(rs) && optEscaped(rs[i+1]):
+			i++
+			b.WriteRune(rs[i])
+			field = true
+		case unicode.IsSpace(r):
+			if field {
+				fields = append(fields, b.String())
+				b.Reset()
+				field = false
+			}
+		default:
+			b.WriteRune(r)
+			field = true
+		}
+	}
+	if field {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+// escapeOpt escapes the double quotes and the whitespace of an option
+// and the backslashes which would be read as an escape by splitOpts.
+func escapeOpt(s string) string {
+	var b strings.Builder
+	rs := []rune(s)
+	for i, r := range rs {
+		if r == '"' || unicode.IsSpace(r) || (r == '\\' && (i+1 == len(rs) || optEscaped(rs[i+1]))) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// optEscaped returns whether a backslash before r is an escape.
+func optEscaped(r rune) bool {
+	return r == '"' || r == '\\' || unicode.IsSpace(r)
+}
@@ -20,6 +20,7 @@ func TestParse(t *testing.T) {
 		{"FailRouteNoCmd", `route x`, nil, true},
 		{"FailRouteAddNoService", `route add`, nil, true},
 		{"FailRouteAddNoSrc", `route add svc`, nil, true},
+		{"FailRouteAddWeight", `route add svc /prefix http://1.2.3.4/ weight abc`, nil, true},
 
 		// happy flows
 		{
@@ -42,6 +43,16 @@ func TestParse(t *testing.T) {
 			in:   `route add svc /prefix http://1.2.3.4/ weight 1.2`,
 			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Weight: 1.2}},
 		},
+		{
+			desc: "RouteAddServiceRelativeWeight",
+			in:   `route add svc /prefix http://1.2.3.4/ weight 3`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", RelativeWeight: 3}},
+		},
+		{
+			desc: "RouteAddServiceFixedWeightWithDecimalPoint",
+			in:   `route add svc /prefix http://1.2.3.4/ weight 1.0`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Weight: 1}},
+		},
 		{
 			desc: "RouteAddServiceWeightTags",
 			in:   `route add svc /prefix http://1.2.3.4/ weight 1.2 tags "a,b"`,
@@ -77,6 +88,11 @@ func TestParse(t *testing.T) {
 			in:   `route add svc /prefix http://1.2.3.4/ opts "foo=bar baz=bang blimp"`,
 			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Opts: map[string]string{"foo": "bar", "baz": "bang", "blimp": ""}}},
 		},
+		{
+			desc: "RouteAddEscapedOpts",
+			in:   `route add svc /prefix http://1.2.3.4/ opts "foo=a\ \"b\" re=^/(\d+)\\ end=x\\"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Opts: map[string]string{"foo": `a "b"`, "re": `^/(\d+)\`, "end": `x\`}}},
+		},
 		{
 			desc: "RouteDelTags",
 			in:   `route del tags "a,b"`,
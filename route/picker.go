@@ -1,6 +1,11 @@
 package route
 
 import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -11,8 +16,32 @@ type picker func(r *Route) *Target
 // Picker contains the available picker functions.
 // Update config/load.go#load after updating.
 var Picker = map[string]picker{
-	"rnd": rndPicker,
-	"rr":  rrPicker,
+	"rnd":              rndPicker,
+	"rr":               rrPicker,
+	"adaptiveload":     adaptiveLoadPicker,
+	"adaptive-latency": adaptiveLatencyPicker,
+
+	// the hash strategies need a request. Lookups without
+	// a request, e.g. for TCP routes, pick a random target.
+	"hash:path": rndPicker,
+	"hash:uri":  rndPicker,
+}
+
+// HashKey contains the functions which return the key of a request
+// for the hash strategies.
+var HashKey = map[string]func(*http.Request) string{
+	"hash:path": func(r *http.Request) string { return r.URL.Path },
+	"hash:uri":  func(r *http.Request) string { return r.URL.RequestURI() },
+}
+
+// RequestPicker returns the picker for the strategy and the request.
+// For the hash strategies the picker maps the key of the request onto
+// the targets. Otherwise, it returns the picker of the strategy.
+func RequestPicker(strategy string, r *http.Request) picker {
+	if key := HashKey[strategy]; key != nil {
+		return hashPicker(key(r))
+	}
+	return Picker[strategy]
 }
 
 // rndPicker picks a random target from the list of targets.
@@ -27,6 +56,186 @@ func rrPicker(r *Route) *Target {
 	return u
 }
 
+// adaptiveLoadPicker picks two distinct targets with a positive weight
+// at random and selects the one with the lower load as reported by the
+// backends (power of two choices). Targets which have not reported a
+// load are considered idle.
+func adaptiveLoadPicker(r *Route) *Target {
+	var n int
+	for _, t := range r.Targets {
+		if t.Weight > 0 {
+			n++
+		}
+	}
+	if n < 2 {
+		return rndPicker(r)
+	}
+	i := randIntn(n)
+	j := (i + 1 + randIntn(n-1)) % n
+	a, b := nthWeighted(r, i), nthWeighted(r, j)
+	if b.Load() < a.Load() {
+		return b
+	}
+	return a
+}
+
+// nthWeighted returns the n-th target of the route with a positive weight.
+func nthWeighted(r *Route, n int) *Target {
+	for _, t := range r.Targets {
+		if t.Weight <= 0 {
+			continue
+		}
+		if n == 0 {
+			return t
+		}
+		n--
+	}
+	return nil
+}
+
+// adaptiveLatencyPicker picks a random target with a probability which
+// is proportional to its weight scaled by its latency factor. Without
+// latency data the targets are picked according to their weight.
+func adaptiveLatencyPicker(r *Route) *Target {
+	var total float64
+	for _, t := range r.Targets {
+		if t.Weight > 0 {
+			total += t.Weight * t.LatencyFactor()
+		}
+	}
+	if total <= 0 {
+		return rndPicker(r)
+	}
+	x := total * float64(randIntn(1e6)) / 1e6
+	var last *Target
+	for _, t := range r.Targets {
+		if t.Weight <= 0 {
+			continue
+		}
+		w := t.Weight * t.LatencyFactor()
+		if x < w {
+			return t
+		}
+		x -= w
+		last = t
+	}
+	return last
+}
+
+// hashPicker returns a picker which maps the key consistently onto the
+// targets of a route with weighted rendezvous hashing. Every target
+// gets a score from the hash of the key and its URL and the target
+// with the highest score wins. When a target is added or removed only
+// the keys of that target move. Ejected targets are skipped unless all
+// targets are ejected.
+func hashPicker(key string) picker {
+	return func(r *Route) *Target {
+		best, bestAvail := rendezvous(r.Targets, key)
+		if bestAvail != nil {
+			return bestAvail
+		}
+		if best != nil {
+			return best
+		}
+		return rndPicker(r)
+	}
+}
+
+// PickSticky maps the key, e.g. the source IP address of a client,
+// consistently onto the targets of the route of t with weighted
+// rendezvous hashing like the hash strategies. The same key gets the
+// same target as long as the set of available targets does not change.
+// PickSticky returns nil if all targets have been ejected so that the
+// caller can fall back to the regular target selection.
+func PickSticky(t *Target, key string) *Target {
+	_, bestAvail := rendezvous(t.Peers(), key)
+	return bestAvail
+}
+
+// rendezvous returns the target with the highest score for the key and
+// the target with the highest score which has not been ejected.
+func rendezvous(targets []*Target, key string) (best, bestAvail *Target) {
+	var score, scoreAvail float64
+	for _, t := range targets {
+		if t.Weight <= 0 {
+			continue
+		}
+		s := hashScore(key, t)
+		if best == nil || s > score {
+			best, score = t, s
+		}
+		if !t.Ejected() && (bestAvail == nil || s > scoreAvail) {
+			bestAvail, scoreAvail = t, s
+		}
+	}
+	return best, bestAvail
+}
+
+// hashScore returns the weighted rendezvous score of the target
+// for the key.
+func hashScore(key string, t *Target) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(t.URL.String()))
+	// map the hash to (0,1) and weigh it so that
+	// targets win in proportion to their weight.
+	x := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	return -t.Weight / math.Log(x)
+}
+
+// pickAvailable picks a target which has not been ejected by the outlier
+// detection. If the picker returns an ejected target, one of the available
+// targets is picked with a probability proportional to its weight. The
+// share of the ejected targets is therefore redistributed over the
+// available targets according to their weights, e.g. targets with the
+// weights 0.5/0.3/0.2 get 0.6/0.4 of the requests while the first one is
+// ejected. If all targets are ejected the picked target is returned.
+//
+// Draining targets are kept with the probability of their drain factor
+// and their weight is reduced by the same factor when another target is
+// picked so that their share decreases linearly over the drain decay.
+func pickAvailable(r *Route, pick picker) *Target {
+	now := time.Now()
+	t := pick(r)
+	if !t.Ejected() && !t.drained(now) {
+		return t
+	}
+	if tt := pickWeighted(r.Targets, now); tt != nil {
+		return tt
+	}
+	return t
+}
+
+// pickWeighted picks a random target which has not been ejected with a
+// probability proportional to its weight. The weights are evaluated for
+// every pick so that ejections, their expiry and the decay of draining
+// targets take effect immediately. It returns nil if there is no
+// available target.
+func pickWeighted(targets []*Target, now time.Time) *Target {
+	var total float64
+	avail := make([]*Target, 0, len(targets))
+	weights := make([]float64, 0, len(targets))
+	for _, t := range targets {
+		if w := t.Weight * t.drainFactor(now); w > 0 && !t.Ejected() {
+			avail = append(avail, t)
+			weights = append(weights, w)
+			total += w
+		}
+	}
+	if len(avail) == 0 {
+		return nil
+	}
+	x := total * float64(randIntn(1e6)) / 1e6
+	for i, t := range avail {
+		if x < weights[i] {
+			return t
+		}
+		x -= weights[i]
+	}
+	return avail[len(avail)-1]
+}
+
 // stubbed out for testing
 // we implement the randIntN function using the nanosecond time counter
 // since it is 15x faster than using the pseudo random number generator
@@ -41,3 +250,20 @@ var randIntn = func(n int) int {
 	}
 	return int(time.Now().UnixNano()/int64(time.Microsecond)) % n
 }
+
+// SeedPicker replaces the random source of the pickers with a
+// pseudo-random generator with the seed so that the sequence of picked
+// targets can be reproduced for the same sequence of requests, e.g. for
+// load tests. It must be called before the first request.
+func SeedPicker(seed int64) {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	randIntn = func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Intn(n)
+	}
+}
@@ -1,9 +1,14 @@
 package route
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var (
@@ -21,8 +26,8 @@ func mustParse(rawurl string) *url.URL {
 
 func TestRndPicker(t *testing.T) {
 	r := &Route{Host: "www.bar.com", Path: "/foo"}
-	r.addTarget("svc", fooDotCom, 0, nil, nil)
-	r.addTarget("svc", barDotCom, 0, nil, nil)
+	r.addTarget("svc", fooDotCom, 0, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, 0, nil, nil)
 
 	tests := []struct {
 		rnd       int
@@ -45,8 +50,8 @@ func TestRndPicker(t *testing.T) {
 
 func TestRRPicker(t *testing.T) {
 	r := &Route{Host: "www.bar.com", Path: "/foo"}
-	r.addTarget("svc", fooDotCom, 0, nil, nil)
-	r.addTarget("svc", barDotCom, 0, nil, nil)
+	r.addTarget("svc", fooDotCom, 0, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, 0, nil, nil)
 
 	tests := []*url.URL{fooDotCom, barDotCom, fooDotCom, barDotCom, fooDotCom, barDotCom}
 
@@ -56,3 +61,350 @@ func TestRRPicker(t *testing.T) {
 		}
 	}
 }
+
+func TestAdaptiveLoadPicker(t *testing.T) {
+	syncLoads(Table{})
+	defer syncLoads(Table{})
+
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", fooDotCom, 0, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, 0, nil, nil)
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+	randIntn = func(int) int { return 0 }
+
+	// no load reported: first choice wins
+	if got, want := adaptiveLoadPicker(r).URL, fooDotCom; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	r.Targets[0].UpdateLoad(0.9)
+	r.Targets[1].UpdateLoad(0.1)
+	if got, want := adaptiveLoadPicker(r).URL, barDotCom; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// the two choices are distinct targets with a positive weight
+	// even if a target has most of the slots
+	r.addTarget("svc", mustParse("http://baz.com/"), 0, 0, nil, nil)
+	r.Targets[0].Weight, r.Targets[1].Weight, r.Targets[2].Weight = 0.9, 0, 0.1
+	r.Targets[2].UpdateLoad(0.5)
+	for i := 0; i < 10; i++ {
+		randIntn = func(n int) int { return i % n }
+		if got, want := adaptiveLoadPicker(r).URL.Host, "baz.com"; got != want {
+			t.Fatalf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestAdaptiveLatencyPicker(t *testing.T) {
+	syncLatencies(Table{})
+	defer syncLatencies(Table{})
+
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", mustParse("http://a.com/"), 0, 0, nil, nil)
+	r.addTarget("svc", mustParse("http://b.com/"), 0, 0, nil, nil)
+	r.addTarget("svc", mustParse("http://c.com/"), 0, 0, nil, nil)
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+
+	// pick counts the picked targets for evenly spread random values
+	pick := func() map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			randIntn = func(int) int { return i * 1000 }
+			counts[adaptiveLatencyPicker(r).URL.Host]++
+		}
+		return counts
+	}
+	cfg := AdaptiveLatency{Smoothing: 0.3, MinFactor: 0.2, MaxFactor: 5}
+
+	// not enough samples: equal weights
+	r.Targets[2].UpdateLatency(time.Second)
+	r.adjustLatencyWeights(cfg)
+	if got, want := pick(), map[string]int{"a.com": 334, "b.com": 333, "c.com": 333}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// a is twice as fast as the mean and b is slower
+	// c has not enough samples and keeps its weight
+	for i := 0; i < latencyMinSamples; i++ {
+		r.Targets[0].UpdateLatency(10 * time.Millisecond)
+		r.Targets[1].UpdateLatency(30 * time.Millisecond)
+	}
+	r.adjustLatencyWeights(cfg)
+	if got, want := pick(), map[string]int{"a.com": 546, "b.com": 182, "c.com": 272}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// the factors are bounded
+	r.adjustLatencyWeights(AdaptiveLatency{MinFactor: 0.9, MaxFactor: 1.1})
+	for i, want := range []float64{1.1, 0.9, 1} {
+		if got := r.Targets[i].LatencyFactor(); got != want {
+			t.Fatalf("%d: got factor %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestSyncLatencies(t *testing.T) {
+	s := `route add sync-svc /sync http://1.1.4.2:1`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < latencyMinSamples; i++ {
+		tbl[""][0].Targets[0].UpdateLatency(10 * time.Millisecond)
+	}
+
+	// the average is kept for the next routing table
+	syncLatencies(tbl)
+	tbl, err = NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tbl[""][0].Targets[0].latency.samples, latencyMinSamples; got != want {
+		t.Fatalf("got %d samples want %d", got, want)
+	}
+
+	// and removed when the target is gone
+	key := outlierKey(tbl[""][0].Targets[0])
+	syncLatencies(Table{})
+	if _, ok := latencies.Load(key); ok {
+		t.Fatal("latency average not removed")
+	}
+}
+
+func TestSyncLoads(t *testing.T) {
+	s := `route add sync-svc /sync http://1.1.4.3:1`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl[""][0].Targets[0].UpdateLoad(0.5)
+
+	// the load is kept for the next routing table
+	syncLoads(tbl)
+	tbl, err = NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tbl[""][0].Targets[0].Load(); got <= 0 {
+		t.Fatalf("got load %v want > 0", got)
+	}
+
+	// and removed when the target is gone
+	key := outlierKey(tbl[""][0].Targets[0])
+	syncLoads(Table{})
+	if _, ok := loads.Load(key); ok {
+		t.Fatal("load average not removed")
+	}
+}
+
+func TestHashPicker(t *testing.T) {
+	newRoute := func(targets ...string) *Route {
+		r := &Route{Host: "www.bar.com", Path: "/"}
+		for _, u := range targets {
+			r.addTarget("svc", mustParse(u), 0, 0, nil, nil)
+		}
+		return r
+	}
+
+	urls := []string{"http://a.com/", "http://b.com/", "http://c.com/", "http://d.com/"}
+	r3 := newRoute(urls[:3]...)
+	r4 := newRoute(urls...)
+
+	var moved int
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("/obj/%d", i)
+		t3 := hashPicker(key)(r3).URL.String()
+		if got := hashPicker(key)(r3).URL.String(); got != t3 {
+			t.Fatalf("%s: got %s and %s", key, t3, got)
+		}
+		counts[t3]++
+
+		// adding a target only moves keys to the new target
+		t4 := hashPicker(key)(r4).URL.String()
+		if t4 != t3 {
+			if t4 != urls[3] {
+				t.Fatalf("%s: moved from %s to %s", key, t3, t4)
+			}
+			moved++
+		}
+	}
+	for _, u := range urls[:3] {
+		if counts[u] < 800 || counts[u] > 1200 {
+			t.Fatalf("got uneven distribution %v", counts)
+		}
+	}
+	if moved < 600 || moved > 900 {
+		t.Fatalf("got %d moved keys want about 750", moved)
+	}
+}
+
+func TestPickSticky(t *testing.T) {
+	prevOutliers, prevEjections := Outliers, Ejections
+	defer func() { Outliers, Ejections = prevOutliers, prevEjections }()
+	Ejections = &testCounter{}
+	Outliers = OutlierDetection{Consecutive5xx: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Hour, MaxEjectionPercent: 100}
+
+	s := `
+	route add sticky-svc :1234 tcp://1.1.1.1:1 opts "sticky=sourceip"
+	route add sticky-svc :1234 tcp://1.1.1.1:2 opts "sticky=sourceip"
+	route add sticky-svc :1234 tcp://1.1.1.1:3 opts "sticky=sourceip"
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := tbl.LookupHost(":1234", Picker["rr"])
+	if !tg.StickySourceIP {
+		t.Fatal("sticky=sourceip not set")
+	}
+
+	// the same client gets the same target and the clients
+	// are spread over all targets
+	counts := map[*Target]int{}
+	for i := 0; i < 300; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		a := PickSticky(tg, ip)
+		if b := PickSticky(tg, ip); a != b {
+			t.Fatalf("%s: got %s and %s", ip, a.URL, b.URL)
+		}
+		counts[a]++
+	}
+	if len(counts) != 3 {
+		t.Fatalf("got %d targets want 3", len(counts))
+	}
+
+	// an ejected target is skipped and nil is
+	// returned when all targets are ejected
+	a := PickSticky(tg, "10.1.1.1")
+	a.RecordResult(true)
+	b := PickSticky(tg, "10.1.1.1")
+	if b == nil || b == a {
+		t.Fatalf("got %v want another target than %s", b, a.URL)
+	}
+	for _, p := range tg.Peers() {
+		p.RecordResult(true)
+	}
+	if got := PickSticky(tg, "10.1.1.1"); got != nil {
+		t.Fatalf("got %s want nil", got.URL)
+	}
+}
+
+func TestRequestPicker(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/"}
+	for i := 0; i < 10; i++ {
+		r.addTarget("svc", mustParse(fmt.Sprintf("http://host-%d.com/", i)), 0, 0, nil, nil)
+	}
+	pick := func(strategy, uri string) string {
+		req := &http.Request{URL: mustParse(uri)}
+		return RequestPicker(strategy, req)(r).URL.String()
+	}
+
+	for i := 0; i < 10; i++ {
+		if got, want := pick("hash:path", "/a?x=1"), pick("hash:path", "/a?x=2"); got != want {
+			t.Fatalf("hash:path: got %s want %s", got, want)
+		}
+	}
+
+	// with the query different keys should map to different targets
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		seen[pick("hash:uri", fmt.Sprintf("/a?x=%d", i))] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("hash:uri: all keys map to %v", seen)
+	}
+}
+
+func TestLoadAvg(t *testing.T) {
+	var l loadAvg
+	start := time.Now()
+
+	l.update(1, start)
+	if got, want := l.get(start), 1.0; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	l.update(0, start)
+	if got, want := l.get(start), 1-loadAlpha; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// stale values decay towards zero
+	if got := l.get(start.Add(10 * loadDecay)); got > 0.001 {
+		t.Fatalf("got %v want ~0", got)
+	}
+}
+
+func TestPickAvailable(t *testing.T) {
+	prevOutliers, prevEjections := Outliers, Ejections
+	defer func() { Outliers, Ejections = prevOutliers, prevEjections }()
+	Ejections = &testCounter{}
+	Outliers = OutlierDetection{Consecutive5xx: 1, BaseEjectionTime: time.Minute, MaxEjectionTime: time.Hour, MaxEjectionPercent: 100}
+
+	s := `
+	route add avail-svc /avail http://1.1.2.1:1 tags "a"
+	route add avail-svc /avail http://1.1.2.1:2 tags "b"
+	route add avail-svc /avail http://1.1.2.1:3 tags "c"
+	route weight avail-svc /avail weight 0.5 tags "a"
+	route weight avail-svc /avail weight 0.3 tags "b"
+	route weight avail-svc /avail weight 0.2 tags "c"
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tbl[""][0]
+	a, b, c := r.Targets[0], r.Targets[1], r.Targets[2]
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+
+	// the picker always returns the ejected target
+	a.RecordResult(true)
+	pickA := func(*Route) *Target { return a }
+
+	counts := map[*Target]int{}
+	for i := 0; i < 1000; i++ {
+		randIntn = func(int) int { return i * 1000 }
+		counts[pickAvailable(r, pickA)]++
+	}
+	if got, want := counts, map[*Target]int{b: 600, c: 400}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// all targets ejected
+	b.RecordResult(true)
+	c.RecordResult(true)
+	if got := pickAvailable(r, pickA); got != a {
+		t.Fatalf("got %s want %s", got.URL, a.URL)
+	}
+}
+
+func TestSeedPicker(t *testing.T) {
+	prev := randIntn
+	defer func() { randIntn = prev }()
+
+	r := &Route{wTargets: []*Target{{URL: fooDotCom}, {URL: barDotCom}, {URL: mustParse("http://baz.com/")}}}
+	picks := func(seed int64) []string {
+		SeedPicker(seed)
+		var urls []string
+		for i := 0; i < 20; i++ {
+			urls = append(urls, rndPicker(r).URL.Host)
+		}
+		return urls
+	}
+
+	a, b := picks(42), picks(42)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("got %v and %v for the same seed", a, b)
+	}
+	if c := picks(43); reflect.DeepEqual(a, c) {
+		t.Fatalf("got %v for different seeds", a)
+	}
+}
@@ -0,0 +1,28 @@
+package route
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ParsePins parses the value of a 'pin' option which is a comma
+// separated list of SHA-256 hashes of the public keys of the upstream
+// certificates in the form sha256//<base64 hash>. The format is the
+// same as for HTTP Public Key Pinning (RFC 7469).
+func ParsePins(s string) ([]string, error) {
+	var pins []string
+	for _, p := range strings.Split(s, ",") {
+		h := strings.TrimPrefix(strings.TrimSpace(p), "sha256//")
+		if h == p || h == "" {
+			return nil, fmt.Errorf("pin should be in the form sha256//<base64 hash>. Got: %s", p)
+		}
+		b, err := base64.StdEncoding.DecodeString(h)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("pin should be a base64 encoded SHA-256 hash. Got: %s", h)
+		}
+		pins = append(pins, h)
+	}
+	return pins, nil
+}
@@ -0,0 +1,37 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePins(t *testing.T) {
+	const (
+		a = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+		b = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	)
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"sha256//" + a, []string{a}, false},
+		{"sha256//" + a + ",sha256//" + b, []string{a, b}, false},
+		{a, nil, true},
+		{"sha256//", nil, true},
+		{"sha256//abc", nil, true},
+		{"sha256//" + a + ",", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePins(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
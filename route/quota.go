@@ -0,0 +1,51 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quota is the total number of requests per client key which the route
+// accepts within a period. The periods are aligned to multiples of the
+// period since the zero time, i.e. a 24h period ends at midnight UTC.
+type Quota struct {
+	// Limit is the number of requests per period and key.
+	Limit int64
+
+	// Period is the length of the quota window.
+	Period time.Duration
+
+	// Header is the canonical name of the request header
+	// whose value is the client key, e.g. X-Api-Key.
+	Header string
+}
+
+// ParseQuota parses the value of a 'quota' option which has the form
+// <limit>/<period>:header:<name>, e.g. 1000000/24h:header:X-Api-Key.
+func ParseQuota(s string) (*Quota, error) {
+	p := strings.SplitN(s, ":", 3)
+	if len(p) != 3 || p[1] != "header" || p[2] == "" {
+		return nil, fmt.Errorf("quota should be in the form <limit>/<period>:header:<name>. Got: %s", s)
+	}
+	lp := strings.SplitN(p[0], "/", 2)
+	if len(lp) != 2 {
+		return nil, fmt.Errorf("quota should be in the form <limit>/<period>:header:<name>. Got: %s", s)
+	}
+	limit, err := strconv.ParseInt(lp[0], 10, 64)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("quota limit should be a positive number. Got: %s", s)
+	}
+	period, err := time.ParseDuration(lp[1])
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("quota period should be a positive duration. Got: %s", s)
+	}
+	return &Quota{Limit: limit, Period: period, Header: http.CanonicalHeaderKey(p[2])}, nil
+}
+
+// Window returns the end of the quota window which contains t.
+func (q *Quota) Window(t time.Time) time.Time {
+	return t.Truncate(q.Period).Add(q.Period)
+}
@@ -0,0 +1,66 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		in  string
+		q   *Quota
+		err bool
+	}{
+		{"1000000/24h:header:x-api-key", &Quota{Limit: 1000000, Period: 24 * time.Hour, Header: "X-Api-Key"}, false},
+		{"10/1m:header:Authorization", &Quota{Limit: 10, Period: time.Minute, Header: "Authorization"}, false},
+		{"", nil, true},
+		{"10/1m", nil, true},
+		{"10/1m:ip", nil, true},
+		{"10/1m:header:", nil, true},
+		{"10:header:X-Api-Key", nil, true},
+		{"0/1m:header:X-Api-Key", nil, true},
+		{"x/1m:header:X-Api-Key", nil, true},
+		{"10/0s:header:X-Api-Key", nil, true},
+		{"10/day:header:X-Api-Key", nil, true},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuota(tt.in)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%q: got error %v want %v", tt.in, err, want)
+		}
+		if got, want := q, tt.q; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q: got %+v want %+v", tt.in, got, want)
+		}
+	}
+}
+
+func TestQuotaWindow(t *testing.T) {
+	q := &Quota{Period: 24 * time.Hour}
+	now := time.Date(2020, 5, 17, 13, 45, 0, 0, time.UTC)
+	if got, want := q.Window(now), time.Date(2020, 5, 18, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestInvalidQuotaDropsTarget(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+	route add svc /api http://a.com/ opts "quota=1000/24h:header:X-Api-Key"
+	route add svc /api http://b.com/ opts "quota=1000/day:header:X-Api-Key"
+	route add svc /other http://c.com/ opts "quota=abc"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(tbl[""]), 1; got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+	targets := tbl[""][0].Targets
+	if got, want := len(targets), 1; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+	if got, want := targets[0].URL.Host, "a.com"; got != want {
+		t.Fatalf("got target %s want %s", got, want)
+	}
+}
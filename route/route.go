@@ -3,11 +3,15 @@ package route
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/gobwas/glob"
@@ -40,16 +44,51 @@ type Route struct {
 
 	// Glob represents compiled pattern.
 	Glob glob.Glob
+
+	// negotiated contains one route per media type for the targets
+	// with a 'match=accept:<type>' option and fallback contains the
+	// other targets. Both are nil if there are no such targets.
+	negotiated []*Route
+	fallback   *Route
+
+	// acceptType and acceptSubtype contain the media type
+	// of the targets of a negotiated route.
+	acceptType, acceptSubtype string
+
+	// geo contains one route per country for the targets with a
+	// 'match=geo:country=...' option and geoFallback contains the
+	// other targets. Both are nil if there are no such targets.
+	geo         map[string]*Route
+	geoFallback *Route
+
+	// ja3 contains one route per fingerprint for the targets with a
+	// 'match=ja3:...' option and ja3Fallback contains the other
+	// targets. Both are nil if there are no such targets.
+	ja3         map[string]*Route
+	ja3Fallback *Route
+
+	// conflicts contains the names of the services which
+	// registered targets for the same route.
+	conflicts []string
+
+	// Priority is the highest 'priority' option of the targets.
+	// Routes with a higher priority are matched first. Routes with
+	// the same priority are matched from the most to the least
+	// specific path.
+	Priority int
 }
 
-func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, tags []string, opts map[string]string) {
+func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, relWeight int, tags []string, opts map[string]string) {
 	if fixedWeight < 0 {
 		fixedWeight = 0
 	}
+	if relWeight < 0 {
+		relWeight = 0
+	}
 
 	// de-dup existing target
 	for _, t := range r.Targets {
-		if t.Service == service && t.URL.String() == targetURL.String() && t.FixedWeight == fixedWeight && reflect.DeepEqual(t.Tags, tags) {
+		if t.Service == service && t.URL.String() == targetURL.String() && t.RelativeWeight == relWeight && (relWeight > 0 || t.ServiceWeight > 0 || t.FixedWeight == fixedWeight) && reflect.DeepEqual(t.Tags, tags) {
 			return
 		}
 	}
@@ -60,21 +99,88 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		name = "unknown"
 	}
 
+	metricTags, err := metrics.ParseTags(opts["metrictags"])
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+	}
+	var timer metrics.Timer = metrics.NoopTimer{}
+	var status *statusCounters
+	noMetrics := MetricsExclude.Match(service, tags, r.Host, r.Path)
+	if !noMetrics {
+		status = statusFor(name, metricTags)
+		timer, name, err = metrics.GetTimer(ServiceRegistry, name, metricTags)
+		if err != nil {
+			log.Printf("[ERROR] Invalid metrics tag name: %s", err)
+			timer, name = ServiceRegistry.GetTimer("unknown"), "unknown"
+		}
+	}
+
 	t := &Target{
-		Service:     service,
-		Tags:        tags,
-		Opts:        opts,
-		URL:         targetURL,
-		FixedWeight: fixedWeight,
-		Timer:       ServiceRegistry.GetTimer(name),
-		TimerName:   name,
+		Service:        service,
+		Tags:           tags,
+		Opts:           opts,
+		URL:            targetURL,
+		FixedWeight:    fixedWeight,
+		RelativeWeight: relWeight,
+		Timer:          timer,
+		TimerName:      name,
+		NoMetrics:      noMetrics,
+		NoTotals:       noMetrics && MetricsExclude.Totals,
+		Prefix:         r.Host + r.Path,
+		status:         status,
+		proto:          &atomic.Value{},
 	}
+	t.outlier = outlierFor(t)
+	t.load = loadFor(t)
+	t.latency = latencyFor(t)
 
 	if opts != nil {
 		t.StripPath = opts["strip"]
 		t.TLSSkipVerify = opts["tlsskipverify"] == "true"
 		t.Host = opts["host"]
 		t.ProxyProto = opts["pxyproto"] == "true"
+		t.AutoProto = opts["proto"] == "auto"
+		t.ForceResponseHeaders = opts["forcerespheaders"] == "true"
+
+		if opts["addrespheader"] != "" {
+			t.ResponseHeaders = http.Header{}
+			for _, h := range strings.Split(opts["addrespheader"], "|") {
+				p := strings.SplitN(h, ":", 2)
+				if len(p) != 2 || p[0] == "" {
+					log.Printf("[ERROR] addrespheader should be in the form name:value. Got: %s", h)
+					continue
+				}
+				t.ResponseHeaders.Set(p[0], p[1])
+			}
+		}
+
+		switch s := opts["scheme"]; s {
+		case "", "http", "https":
+			t.Scheme = s
+		default:
+			log.Printf("[ERROR] scheme should be either http or https. Got: %s", s)
+		}
+
+		switch s := opts["antiaffinity"]; s {
+		case "":
+		case "node":
+			t.AntiAffinity = true
+		default:
+			log.Printf("[ERROR] antiaffinity should be 'node'. Got: %s", s)
+		}
+
+		if s := opts["maxrespbody"]; s != "" {
+			if t.MaxRespBody, err = ParseSize(s); err != nil {
+				log.Printf("[ERROR] maxrespbody should be a size like 1048576 or 50MB. Got: %s", s)
+			}
+		}
+
+		if s := opts["setheader"]; s != "" {
+			t.SetHeaders, err = ParseHeaderTemplates(s)
+			if err != nil {
+				log.Printf("[ERROR] setheader should be in the form <name>=<template>;... Got: %s. %s", s, err)
+			}
+		}
 
 		if opts["redirect"] != "" {
 			t.RedirectCode, err = strconv.Atoi(opts["redirect"])
@@ -86,16 +192,310 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 			}
 		}
 
+		if opts["fallback"] != "" {
+			p := strings.Split(opts["fallback"], ",")
+			t.FallbackURL, err = url.Parse(p[0])
+			if err != nil || t.FallbackURL.Host == "" {
+				t.FallbackURL = nil
+				log.Printf("[ERROR] fallback should be in the form <url>[,timeout=<duration>]. Got: %s", opts["fallback"])
+			}
+			for _, kv := range p[1:] {
+				if !strings.HasPrefix(kv, "timeout=") {
+					log.Printf("[ERROR] invalid fallback option. Got: %s", kv)
+					continue
+				}
+				t.FallbackTimeout, err = time.ParseDuration(kv[len("timeout="):])
+				if err != nil || t.FallbackTimeout < 0 {
+					t.FallbackTimeout = 0
+					log.Printf("[ERROR] fallback timeout should be a positive duration. Got: %s", kv)
+				}
+			}
+		}
+
+		if opts["expect"] != "" {
+			t.Expect, err = ParseExpect(opts["expect"])
+			if err != nil {
+				log.Printf("[ERROR] %s", err)
+			}
+		}
+
+		if opts["emptyfallback"] != "" {
+			u, err := url.Parse(opts["emptyfallback"])
+			switch {
+			case err != nil:
+			case u.Scheme == "file":
+				path, ferr := fallbackFile(u)
+				if ferr != nil {
+					log.Printf("[ERROR] emptyfallback: %s", ferr)
+					break
+				}
+				t.EmptyFallback = &url.URL{Scheme: "file", Path: path}
+			case (u.Scheme == "http" || u.Scheme == "https") && u.Host != "":
+				t.EmptyFallback = u
+			}
+			if t.EmptyFallback == nil && (err != nil || u.Scheme != "file") {
+				log.Printf("[ERROR] emptyfallback should be an http, https or file URL. Got: %s", opts["emptyfallback"])
+			}
+		}
+
+		if s := opts["tap"]; s != "" {
+			t.Tap, err = strconv.ParseFloat(s, 64)
+			if err != nil || t.Tap < 0 || t.Tap > 1 {
+				log.Printf("[ERROR] tap should be a fraction between 0 and 1. Got: %s", s)
+				t.Tap = 0
+			}
+		}
+
+		t.Datacenter = opts["dc"]
+		if s := opts["dcpriority"]; s != "" {
+			t.DCPriority, err = strconv.Atoi(s)
+			if err != nil || t.DCPriority < 0 {
+				log.Printf("[ERROR] dcpriority should be a non-negative number. Got: %s", s)
+				t.DCPriority = 0
+			}
+		}
+
+		switch s := opts["draining"]; s {
+		case "", "false":
+		case "true":
+			t.Draining = true
+			t.drainStart = drainStartFor(t)
+		default:
+			log.Printf("[ERROR] draining should be either true or false. Got: %s", s)
+		}
+
+		if s := opts["quota"]; s != "" {
+			t.Quota, err = ParseQuota(s)
+			if err != nil {
+				log.Printf("[ERROR] %s", err)
+			}
+		}
+
+		if opts["breakerfallback"] != "" {
+			t.BreakerFallback, err = ParseBreakerFallback(opts["breakerfallback"])
+			if err != nil {
+				log.Printf("[ERROR] %s", err)
+			}
+		}
+
+		if opts["redirectmatch"] != "" {
+			t.RedirectMatch, err = regexp.Compile(opts["redirectmatch"])
+			if err != nil {
+				log.Printf("[ERROR] redirectmatch should be a valid regular expression. Got: %s", opts["redirectmatch"])
+			}
+		}
+
+		if s := opts["allowmethods"]; s != "" {
+			t.AllowMethods, err = ParseAllowMethods(s)
+			if err != nil {
+				log.Printf("[ERROR] allowmethods should be a comma separated list of HTTP methods. Got: %s", s)
+			}
+		}
+
+		if s := opts["blockpaths"]; s != "" {
+			t.BlockPaths, err = ParseBlockPaths(s)
+			if err != nil {
+				log.Printf("[ERROR] blockpaths should be a comma separated list of regular expressions. Got: %s", s)
+			}
+		}
+
+		switch opts["redirectquery"] {
+		case "", "keep", "drop":
+			t.RedirectQuery = opts["redirectquery"]
+		default:
+			log.Printf("[ERROR] redirectquery should be keep or drop. Got: %s", opts["redirectquery"])
+		}
+
+		switch opts["trailingslash"] {
+		case "", "strict", "redirect", "ignore":
+			t.TrailingSlash = opts["trailingslash"]
+		default:
+			log.Printf("[ERROR] trailingslash should be strict, redirect or ignore. Got: %s", opts["trailingslash"])
+		}
+
+		switch opts["servertiming"] {
+		case "", "true", "false":
+			t.ServerTiming = opts["servertiming"]
+		default:
+			log.Printf("[ERROR] servertiming should be true or false. Got: %s", opts["servertiming"])
+		}
+
+		switch opts["buffer"] {
+		case "", "true", "false":
+			t.Buffer = opts["buffer"]
+		default:
+			log.Printf("[ERROR] buffer should be true or false. Got: %s", opts["buffer"])
+		}
+
+		switch opts["sticky"] {
+		case "":
+		case "sourceip":
+			t.StickySourceIP = true
+		default:
+			log.Printf("[ERROR] sticky should be sourceip. Got: %s", opts["sticky"])
+		}
+
+		if d := opts["deprecation"]; d != "" && d != "false" {
+			if d == "true" {
+				t.Deprecation = d
+			} else if tm, err := time.Parse(time.RFC3339, d); err == nil {
+				t.Deprecation = "@" + strconv.FormatInt(tm.Unix(), 10)
+			} else {
+				log.Printf("[ERROR] deprecation should be true, false or an RFC3339 date. Got: %s", d)
+			}
+		}
+
+		if s := opts["sunset"]; s != "" {
+			if tm, err := time.Parse(time.RFC3339, s); err == nil {
+				t.Sunset = tm.UTC().Format(http.TimeFormat)
+			} else {
+				log.Printf("[ERROR] sunset should be an RFC3339 date. Got: %s", s)
+			}
+		}
+
+		if s := opts["deprecationlink"]; s != "" {
+			if u, err := url.Parse(s); err == nil && u.Host != "" {
+				t.DeprecationLink = u.String()
+			} else {
+				log.Printf("[ERROR] deprecationlink should be an absolute URL. Got: %s", s)
+			}
+		}
+
+		if opts["grpcmaxstreams"] != "" {
+			t.MaxStreams, err = strconv.Atoi(opts["grpcmaxstreams"])
+			if err != nil || t.MaxStreams < 0 {
+				t.MaxStreams = 0
+				log.Printf("[ERROR] grpcmaxstreams should be a positive number. Got: %s", opts["grpcmaxstreams"])
+			}
+		}
+
+		if opts["serviceweight"] != "" {
+			t.ServiceWeight, err = strconv.ParseFloat(opts["serviceweight"], 64)
+			if err != nil || t.ServiceWeight <= 0 || t.ServiceWeight > 1 {
+				t.ServiceWeight = 0
+				log.Printf("[ERROR] serviceweight should be a number between 0 and 1. Got: %s", opts["serviceweight"])
+			}
+		}
+
+		if opts["fairshare"] != "" {
+			t.FairShare, err = strconv.Atoi(opts["fairshare"])
+			if err != nil || t.FairShare <= 0 {
+				t.FairShare = 0
+				log.Printf("[ERROR] fairshare should be a positive number. Got: %s", opts["fairshare"])
+			}
+		}
+
 		if err = t.ProcessAccessRules(); err != nil {
 			log.Printf("[ERROR] failed to process access rules: %s",
 				err.Error())
 		}
 
+		if opts["minconns"] != "" {
+			t.MinConns, err = strconv.Atoi(opts["minconns"])
+			if err != nil || t.MinConns < 0 {
+				t.MinConns = 0
+				log.Printf("[ERROR] minconns should be a positive number. Got: %s", opts["minconns"])
+			}
+		}
+
+		if s := opts["upstreamproxy"]; s != "" {
+			u, err := url.Parse(s)
+			switch {
+			case err != nil:
+				log.Print("[ERROR] upstreamproxy should be an http or https URL")
+			case u.Host == "" || (u.Scheme != "http" && u.Scheme != "https"):
+				log.Printf("[ERROR] upstreamproxy should be an http or https URL. Got: %s", u.Redacted())
+			default:
+				// keep the credentials out of the routing table,
+				// the UI and the API
+				t.upstreamProxyUser, u.User = u.User, nil
+				t.UpstreamProxy = u
+				if t.upstreamProxyUser != nil {
+					t.Opts = make(map[string]string, len(opts))
+					for k, v := range opts {
+						t.Opts[k] = v
+					}
+					t.Opts["upstreamproxy"] = u.String()
+				}
+			}
+		}
+
+		if s := opts["resolve"]; s != "" {
+			d, err := time.ParseDuration(strings.TrimPrefix(s, "dns:"))
+			if err != nil || d <= 0 || !strings.HasPrefix(s, "dns:") {
+				log.Printf("[ERROR] resolve should be in the form dns:<interval>. Got: %s", s)
+			} else {
+				t.Resolve = d
+			}
+		}
+
 		t.AuthScheme = opts["auth"]
+		t.Transform = opts["transform"]
+		t.Aggregate = opts["aggregate"]
+		t.CaptureBody = opts["capturebody"] == "true"
+		t.LogTarget = opts["logtarget"]
+
+		if s := opts["priority"]; s != "" {
+			if t.Priority, err = strconv.Atoi(s); err != nil {
+				log.Printf("[ERROR] priority should be a number. Got: %s", s)
+			}
+		}
+
+		if s := opts["pin"]; s != "" {
+			if t.Pins, err = ParsePins(s); err != nil {
+				log.Printf("[ERROR] %s", err)
+				// reject the connections instead of disabling the pinning
+				t.Pins = []string{""}
+			}
+		}
+
+		if s := opts["sign"]; s != "" {
+			t.SignSecret, err = parseSign(s)
+			if err != nil {
+				log.Printf("[ERROR] %s", err)
+			}
+		}
+
+		if m := opts["match"]; strings.HasPrefix(m, "geo:") {
+			if codes, ok := parseGeoMatch(m); ok {
+				t.GeoCountries = codes
+			} else {
+				log.Printf("[ERROR] match should be in the form geo:country=<code>,<code>,... Got: %s", m)
+			}
+		} else if strings.HasPrefix(m, "ja3:") {
+			if fps, ok := parseJA3Match(m); ok {
+				t.JA3Match = fps
+			} else {
+				log.Printf("[ERROR] match should be in the form ja3:<md5>,<md5>,... Got: %s", m)
+			}
+		} else if m != "" {
+			mt := strings.TrimPrefix(m, "accept:")
+			typ, subtype, ok := splitMediaType(strings.ToLower(mt))
+			switch {
+			case mt == m:
+				log.Printf("[ERROR] match should be in the form accept:type/subtype, geo:country=<codes> or ja3:<md5>. Got: %s", m)
+			case !ok || typ == "*" || subtype == "*":
+				log.Printf("[ERROR] match=accept requires a media type without wildcards. Got: %s", mt)
+			default:
+				t.AcceptMatch = typ + "/" + subtype
+			}
+		}
 	}
 
 	r.Targets = append(r.Targets, t)
 	r.weighTargets()
+	r.setPriority()
+}
+
+// setPriority sets the priority of the route to the highest
+// priority of its targets.
+func (r *Route) setPriority() {
+	r.Priority = 0
+	for i, t := range r.Targets {
+		if i == 0 || t.Priority > r.Priority {
+			r.Priority = t.Priority
+		}
+	}
 }
 
 func (r *Route) filter(skip func(t *Target) bool) {
@@ -108,6 +508,7 @@ func (r *Route) filter(skip func(t *Target) bool) {
 	}
 	r.Targets = clone
 	r.weighTargets()
+	r.setPriority()
 }
 
 func (r *Route) setWeight(service string, weight float64, tags []string) int {
@@ -122,6 +523,8 @@ func (r *Route) setWeight(service string, weight float64, tags []string) int {
 			}
 			n++
 			t.FixedWeight = w
+			t.RelativeWeight = 0
+			t.ServiceWeight = 0
 		}
 		return n
 	}
@@ -158,11 +561,23 @@ func contains(src, dst []string) bool {
 }
 
 func (r *Route) TargetConfig(t *Target, addWeight bool) string {
-	s := fmt.Sprintf("route add %s %s %s", t.Service, r.Host+r.Path, t.URL)
+	var w string
 	if addWeight {
-		s += fmt.Sprintf(" weight %2.4f", t.Weight)
-	} else if t.FixedWeight > 0 {
-		s += fmt.Sprintf(" weight %.4f", t.FixedWeight)
+		w = fmt.Sprintf("%2.4f", t.Weight)
+	} else if t.RelativeWeight > 0 {
+		w = strconv.Itoa(t.RelativeWeight)
+	} else if t.FixedWeight > 0 && t.ServiceWeight == 0 {
+		w = fmt.Sprintf("%.4f", t.FixedWeight)
+	}
+	return r.targetConfig(t, w)
+}
+
+// targetConfig returns the route command for the target with the weight w
+// which is omitted if w is empty.
+func (r *Route) targetConfig(t *Target, w string) string {
+	s := fmt.Sprintf("route add %s %s %s", t.Service, r.Host+r.Path, t.URL)
+	if w != "" {
+		s += " weight " + w
 	}
 	if len(t.Tags) > 0 {
 		s += fmt.Sprintf(" tags %q", strings.Join(t.Tags, ","))
@@ -176,7 +591,7 @@ func (r *Route) TargetConfig(t *Target, addWeight bool) string {
 
 		var vals []string
 		for _, k := range keys {
-			vals = append(vals, k+"="+t.Opts[k])
+			vals = append(vals, escapeOpt(k+"="+t.Opts[k]))
 		}
 		s += fmt.Sprintf(" opts \"%s\"", strings.Join(vals, " "))
 	}
@@ -188,7 +603,7 @@ func (r *Route) TargetConfig(t *Target, addWeight bool) string {
 func (r *Route) config(addWeight bool) []string {
 	var cfg []string
 	for _, t := range r.Targets {
-		if t.Weight <= 0 {
+		if t.Weight <= 0 || t.Empty {
 			continue
 		}
 		cfg = append(cfg, r.TargetConfig(t, addWeight))
@@ -202,15 +617,84 @@ func (r *Route) config(addWeight bool) []string {
 // serve a single route. maxSlots must be a power of ten.
 const maxSlots = 1e4 // 10000
 
-// weighTargets computes the share of traffic each target receives based
+// weighTargets groups the targets by the 'match=...' options and
+// computes the share of traffic each target receives within its group.
+func (r *Route) weighTargets() {
+	if r.weighJA3() || r.weighGeo() {
+		return
+	}
+	r.weighAccept()
+}
+
+// weighAccept groups the targets by the media type of their
+// 'match=accept:<type>' option and computes the share of traffic
+// each target receives within its group.
+func (r *Route) weighAccept() {
+	r.negotiated, r.fallback = nil, nil
+
+	var defaults []*Target
+	groups := map[string][]*Target{}
+	for _, t := range r.Targets {
+		if t.AcceptMatch == "" {
+			defaults = append(defaults, t)
+			continue
+		}
+		groups[t.AcceptMatch] = append(groups[t.AcceptMatch], t)
+	}
+	if len(groups) == 0 {
+		r.weigh()
+		return
+	}
+
+	var types []string
+	for mt := range groups {
+		types = append(types, mt)
+	}
+	sort.Strings(types)
+
+	for _, mt := range types {
+		v := &Route{Host: r.Host, Path: r.Path, Targets: groups[mt]}
+		v.acceptType, v.acceptSubtype, _ = splitMediaType(mt)
+		v.weigh()
+		r.negotiated = append(r.negotiated, v)
+	}
+	r.fallback = &Route{Host: r.Host, Path: r.Path, Targets: defaults}
+	r.fallback.weigh()
+	r.wTargets = nil
+}
+
+// weigh computes the share of traffic each target receives based
 // on its weight and the weight of the other targets.
 //
+// Integer weights are converted to fixed weights first by dividing them by
+// the sum of the integer weights of the targets of the same service.
+// Service weights are split evenly between the targets of the service
+// and take precedence over the weights of the targets.
+//
 // Traffic is first distributed to targets with a fixed weight. If the sum of
 // all fixed weights exceeds 100% then they are normalized to 100%.
 //
 // Targets with a dynamic weight will receive an equal share of the remaining
 // traffic if there is any left.
-func (r *Route) weighTargets() {
+func (r *Route) weigh() {
+	relSum := map[string]int{}
+	svcCount := map[string]int{}
+	for _, t := range r.Targets {
+		t.peers = r.Targets
+		relSum[t.Service] += t.RelativeWeight
+		if t.ServiceWeight > 0 {
+			svcCount[t.Service]++
+		}
+	}
+	for _, t := range r.Targets {
+		switch {
+		case t.ServiceWeight > 0:
+			t.FixedWeight = t.ServiceWeight / float64(svcCount[t.Service])
+		case t.RelativeWeight > 0:
+			t.FixedWeight = float64(t.RelativeWeight) / float64(relSum[t.Service])
+		}
+	}
+
 	// how big is the fixed weighted traffic?
 	var nFixed int
 	var sumFixed float64
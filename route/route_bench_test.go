@@ -130,3 +130,49 @@ func benchmarkGet(t Table, match matcher, pick picker, pb *testing.PB) {
 		n++
 	}
 }
+
+// makeLargeTable returns a routing table with the given number of hosts
+// and routes per host and requests for the deepest routes.
+func makeLargeTable(hosts, routes int) (Table, []*http.Request) {
+	var b bytes.Buffer
+	var reqs []*http.Request
+	for i := 0; i < hosts; i++ {
+		host := fmt.Sprintf("www.host-%d.com", i)
+		for j := 0; j < routes; j++ {
+			path := fmt.Sprintf("/api/v%d/resource-%d/", j%10, j)
+			fmt.Fprintf(&b, "route add svc %s%s http://10.0.%d.%d:8080/\n", host, path, i%256, j%256)
+			reqs = append(reqs, &http.Request{Host: host, URL: mustParse("http://" + host + path + "item/42"), Header: http.Header{}})
+		}
+	}
+	t, err := NewTable(&b)
+	if err != nil {
+		panic(err)
+	}
+	return t, reqs
+}
+
+func benchmarkLargeTable(b *testing.B, hosts, routes int, indexed bool) {
+	t, reqs := makeLargeTable(hosts, routes)
+	if indexed {
+		prev := GetTable()
+		defer SetTable(prev)
+		if err := SetTable(t); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// build the path index before the timer starts
+	t.Lookup(reqs[0], "", rrPicker, prefixMatcher, globCache, globEnabled)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if t.Lookup(reqs[i%len(reqs)], "", rrPicker, prefixMatcher, globCache, globEnabled) == nil {
+			b.Fatal("no target")
+		}
+	}
+}
+
+func BenchmarkLookupLinear100x100Routes(b *testing.B)  { benchmarkLargeTable(b, 100, 100, false) }
+func BenchmarkLookupIndexed100x100Routes(b *testing.B) { benchmarkLargeTable(b, 100, 100, true) }
+func BenchmarkLookupLinear10x1000Routes(b *testing.B)  { benchmarkLargeTable(b, 10, 1000, false) }
+func BenchmarkLookupIndexed10x1000Routes(b *testing.B) { benchmarkLargeTable(b, 10, 1000, true) }
+func BenchmarkLookupLinear1000x10Routes(b *testing.B)  { benchmarkLargeTable(b, 1000, 10, false) }
+func BenchmarkLookupIndexed1000x10Routes(b *testing.B) { benchmarkLargeTable(b, 1000, 10, true) }
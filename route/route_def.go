@@ -16,4 +16,9 @@ type RouteDef struct {
 	Weight  float64           `json:"weight"`
 	Tags    []string          `json:"tags,omitempty"`
 	Opts    map[string]string `json:"opts,omitempty"`
+
+	// RelativeWeight is the integer weight of the target relative to
+	// the other targets of the same service. It takes precedence
+	// over Weight.
+	RelativeWeight int `json:"relativeWeight,omitempty"`
 }
@@ -13,7 +13,13 @@ func (rt Routes) find(path string) *Route {
 	return nil
 }
 
-// sort by path in reverse order (most to least specific)
-func (rt Routes) Len() int           { return len(rt) }
-func (rt Routes) Swap(i, j int)      { rt[i], rt[j] = rt[j], rt[i] }
-func (rt Routes) Less(i, j int) bool { return rt[j].Path < rt[i].Path }
+// sort by priority in descending order and then by path in
+// reverse order (most to least specific)
+func (rt Routes) Len() int      { return len(rt) }
+func (rt Routes) Swap(i, j int) { rt[i], rt[j] = rt[j], rt[i] }
+func (rt Routes) Less(i, j int) bool {
+	if rt[i].Priority != rt[j].Priority {
+		return rt[i].Priority > rt[j].Priority
+	}
+	return rt[j].Path < rt[i].Path
+}
@@ -0,0 +1,122 @@
+package route
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// HeaderTemplate is a header of the 'setheader' option whose value is
+// computed for every request from a template. The template has access
+// to the request attributes in HeaderData and to the functions in
+// headerFuncs, e.g. {{.Path|sha1}}.
+type HeaderTemplate struct {
+	// Name is the canonical name of the header.
+	Name string
+
+	tmpl *template.Template
+}
+
+// headerFuncs are the functions of the header templates.
+var headerFuncs = template.FuncMap{
+	"md5": func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	},
+	"sha1": func(s string) string {
+		h := sha1.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	},
+	"sha256": func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	},
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// ParseHeaderTemplates parses the value of the 'setheader' option which
+// is a list of <name>=<template> pairs separated by ';'. The templates
+// are checked against an empty request so that references to unknown
+// attributes are reported when the routing table is built.
+func ParseHeaderTemplates(s string) ([]*HeaderTemplate, error) {
+	var hdrs []*HeaderTemplate
+	for _, h := range strings.Split(s, ";") {
+		p := strings.SplitN(h, "=", 2)
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, errors.New("header should be in the form <name>=<template>")
+		}
+		tmpl, err := template.New(p[0]).Funcs(headerFuncs).Parse(p[1])
+		if err != nil {
+			return nil, err
+		}
+		hdr := &HeaderTemplate{Name: http.CanonicalHeaderKey(p[0]), tmpl: tmpl}
+		if _, err := hdr.Value(&HeaderData{}); err != nil {
+			return nil, err
+		}
+		hdrs = append(hdrs, hdr)
+	}
+	return hdrs, nil
+}
+
+// Value returns the value of the header for the request.
+func (h *HeaderTemplate) Value(d *HeaderData) (string, error) {
+	var b strings.Builder
+	if err := h.tmpl.Execute(&b, d); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// HeaderData are the request attributes of the header templates.
+type HeaderData struct {
+	// Method, Path and Host are the method, the path and the host
+	// of the request before the path has been stripped.
+	Method, Path, Host string
+
+	// ClientIP is the IP address of the client.
+	ClientIP string
+
+	// Service is the name of the service of the target and Route
+	// is the host/path prefix of the matched route.
+	Service, Route string
+
+	req *http.Request
+}
+
+// NewHeaderData returns the template data for the request to the target.
+func NewHeaderData(r *http.Request, t *Target) *HeaderData {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return &HeaderData{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Host:     r.Host,
+		ClientIP: ip,
+		Service:  t.Service,
+		Route:    t.Prefix,
+		req:      r,
+	}
+}
+
+// Country returns the country code of the client or an empty string
+// if there is no GeoIP database. The lookup is only made for templates
+// which use the country.
+func (d *HeaderData) Country() string {
+	if d.req == nil || ClientCountry == nil {
+		return ""
+	}
+	return ClientCountry(d.req)
+}
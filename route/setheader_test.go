@@ -0,0 +1,60 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseHeaderTemplates(t *testing.T) {
+	tests := []struct {
+		in  string
+		err bool
+	}{
+		{"X-Route-Key={{.Path|sha1}}", false},
+		{"X-A={{.Method}};X-B={{.Country|lower}}", false},
+		{"X-A", true},
+		{"={{.Path}}", true},
+		{"X-A=", true},
+		{"X-A={{.Path", true},
+		{"X-A={{.Unknown}}", true},
+		{"X-A={{.Path|nope}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := ParseHeaderTemplates(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestHeaderTemplateValue(t *testing.T) {
+	defer func() { ClientCountry = nil }()
+	ClientCountry = func(r *http.Request) string { return "DE" }
+
+	hdrs, err := ParseHeaderTemplates("x-route-key={{.Path|sha1}};X-Req={{.Method}} {{.Host}} {{.ClientIP}};X-Route={{.Service}}@{{.Route}};X-Country={{.Country|lower}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{Method: "GET", Host: "example.com", RemoteAddr: "1.2.3.4:5678", URL: &url.URL{Path: "/foo"}}
+	tg := &Target{Service: "svc", Prefix: "example.com/foo"}
+
+	want := map[string]string{
+		"X-Route-Key": "6dbd548cc03e44b8b44b6e68e56255ce4273ae49",
+		"X-Req":       "GET example.com 1.2.3.4",
+		"X-Route":     "svc@example.com/foo",
+		"X-Country":   "de",
+	}
+	for _, h := range hdrs {
+		got, err := h.Value(NewHeaderData(r, tg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want[h.Name] {
+			t.Errorf("%s: got %q want %q", h.Name, got, want[h.Name])
+		}
+	}
+}
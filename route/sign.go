@@ -0,0 +1,18 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseSign parses the value of a 'sign' option in the form
+// hmac-sha256:<source>/<name> and returns the secret reference
+// <source>/<name>.
+func parseSign(s string) (string, error) {
+	ref := strings.TrimPrefix(s, "hmac-sha256:")
+	p := strings.SplitN(ref, "/", 2)
+	if ref == s || len(p) != 2 || p[0] == "" || p[1] == "" {
+		return "", fmt.Errorf("sign should be in the form hmac-sha256:<source>/<name>. Got: %s", s)
+	}
+	return ref, nil
+}
@@ -0,0 +1,49 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseSign(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"hmac-sha256:cs/origin.pem", "cs/origin.pem", false},
+		{"cs/origin.pem", "", true},
+		{"hmac-sha256:origin.pem", "", true},
+		{"hmac-sha256:/origin.pem", "", true},
+		{"hmac-sha256:cs/", "", true},
+		{"hmac-sha1:cs/origin.pem", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSign(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidSignDropsTarget(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+	route add svc /api http://a.com/ opts "sign=hmac-sha256:cs/origin.pem"
+	route add svc /api http://b.com/ opts "sign=cs/origin.pem"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := tbl[""][0].Targets
+	if got, want := len(targets), 1; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+	if got, want := targets[0].SignSecret, "cs/origin.pem"; got != want {
+		t.Fatalf("got sign secret %q want %q", got, want)
+	}
+}
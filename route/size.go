@@ -0,0 +1,36 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the multipliers of the size suffixes.
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes with an optional KB, MB or GB suffix,
+// e.g. 512, 64KB or 50MB. The suffixes are multiples of 1024 and are not
+// case sensitive. The size must be positive.
+func ParseSize(s string) (int64, error) {
+	v, mul := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mul = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 || n > (1<<63-1)/mul {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mul, nil
+}
@@ -0,0 +1,35 @@
+package route
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"64KB", 64 << 10, false},
+		{"50MB", 50 << 20, false},
+		{"2gb", 2 << 30, false},
+		{"", 0, true},
+		{"0", 0, true},
+		{"-1MB", 0, true},
+		{"1.5MB", 0, true},
+		{"10TB", 0, true},
+		{"9999999999GB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSize(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
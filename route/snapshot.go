@@ -0,0 +1,79 @@
+package route
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// snapshotHeader is written at the top of a snapshot file.
+const snapshotHeader = "# fabio routing table snapshot\n"
+
+// ReadSnapshot reads a routing table from a snapshot file which
+// was written by WriteSnapshot.
+func ReadSnapshot(file string) (Table, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewTable(bytes.NewBuffer(b))
+}
+
+// WriteSnapshot writes the routing table to the file in the format
+// of the route commands. Unlike String() the snapshot contains all
+// targets with their exact weights so that ReadSnapshot restores the
+// same routing table. The file is replaced atomically so that it
+// always contains a complete routing table.
+func WriteSnapshot(file string, t Table) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(snapshotHeader + t.snapshot() + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// snapshot returns the route commands for all targets of the routing
+// table except for the empty fallbacks which are restored from the
+// previous routing table.
+func (t Table) snapshot() string {
+	var cfg []string
+	for _, host := range t.configHosts() {
+		for _, r := range t[host] {
+			for _, tg := range r.Targets {
+				if !tg.Empty {
+					cfg = append(cfg, r.targetConfig(tg, snapshotWeight(tg)))
+				}
+			}
+		}
+	}
+	return strings.Join(cfg, "\n")
+}
+
+// snapshotWeight returns the weight of the route command for the
+// target. Fixed weights keep their precision and always contain a
+// decimal point since integers are relative weights.
+func snapshotWeight(t *Target) string {
+	switch {
+	case t.RelativeWeight > 0:
+		return strconv.Itoa(t.RelativeWeight)
+	case t.FixedWeight > 0 && t.ServiceWeight == 0:
+		w := strconv.FormatFloat(t.FixedWeight, 'f', -1, 64)
+		if !strings.Contains(w, ".") {
+			w += ".0"
+		}
+		return w
+	}
+	return ""
+}
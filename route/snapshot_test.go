@@ -0,0 +1,108 @@
+package route
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "routes.snapshot")
+	if _, err := ReadSnapshot(file); !os.IsNotExist(err) {
+		t.Fatalf("got %v want not exist error", err)
+	}
+
+	in := `route add svc-a /foo http://1.2.3.4:5000/ weight 0.2500 tags "a,b"
+route add svc-a /foo http://1.2.3.4:5001/ opts "strip=/foo"
+route add svc-b example.com/ http://1.2.3.5:5000/`
+	tbl, err := NewTable(bytes.NewBufferString(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := WriteSnapshot(file, tbl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "# fabio routing table snapshot\n") {
+		t.Fatalf("got snapshot without header %q", b)
+	}
+
+	got, err := ReadSnapshot(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := got.String(), tbl.String(); got != want {
+		t.Fatalf("got routing table\n%s\nwant\n%s", got, want)
+	}
+
+	// no temporary files are left behind
+	files, _ := ioutil.ReadDir(dir)
+	if got, want := len(files), 1; got != want {
+		t.Fatalf("got %d files want %d", got, want)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	in := `route add svc-a /foo http://1.2.3.4:5000/ weight 0.123456789
+route add svc-a /foo http://1.2.3.4:5001/ weight 1.0
+route add svc-b /foo http://1.2.3.5:5000/
+route add svc-c /bar http://1.2.3.6:5000/ weight 3 tags "a,b"
+route add svc-c /bar http://1.2.3.6:5001/ weight 1
+route add svc-d /baz http://1.2.3.7:5000/ opts "serviceweight=0.3"
+route add svc-e /baz http://1.2.3.8:5000/ opts "csp=default-src\ 'self' q=\"x\" re=^/(\d+)\\ strip=/baz"
+route add svc-f example.com/ http://1.2.3.9:5000/
+route weight svc-f example.com/ weight 0.25`
+	tbl, err := NewTable(bytes.NewBufferString(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewTable(bytes.NewBufferString(tbl.snapshot()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type target struct {
+		Route, Service, URL                string
+		Weight, FixedWeight, ServiceWeight float64
+		RelativeWeight                     int
+		Tags                               []string
+		Opts                               map[string]string
+	}
+	targets := func(tbl Table) []target {
+		var ts []target
+		for _, host := range tbl.configHosts() {
+			for _, r := range tbl[host] {
+				for _, t := range r.Targets {
+					ts = append(ts, target{r.Host + r.Path, t.Service, t.URL.String(), t.Weight, t.FixedWeight, t.ServiceWeight, t.RelativeWeight, t.Tags, t.Opts})
+				}
+			}
+		}
+		return ts
+	}
+	if got, want := targets(got), targets(tbl); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got targets\n%+v\nwant\n%+v", got, want)
+	}
+
+	// the target without weight is part of the snapshot
+	if r := tbl[""].find("/foo"); r.Targets[2].Weight > 0 {
+		t.Fatalf("got weight %v want 0", r.Targets[2].Weight)
+	}
+}
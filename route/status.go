@@ -0,0 +1,121 @@
+package route
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// StatusMetrics configures the per-route counters of the response
+// status codes. "class" counts the responses per status class like
+// 2xx, "code" per status code and "none" disables the counters.
+// It is set by the caller.
+var StatusMetrics = "class"
+
+// statusClasses are the names of the status classes.
+var statusClasses = [...]string{"", "1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// statusCounters are the counters of the response status codes of a
+// target. They are shared by the targets with the same metric name of
+// successive routing tables so that the counters are not reset.
+type statusCounters struct {
+	name string
+	tags []metrics.Tag
+
+	// counters maps the status class or code to its counter.
+	counters sync.Map
+
+	mu    sync.Mutex
+	names []string
+}
+
+// statuses contains the status counters of the active targets.
+var statuses sync.Map
+
+// statusFor returns the status counters for the metric name and tags
+// of a target.
+func statusFor(name string, tags []metrics.Tag) *statusCounters {
+	key := name
+	for _, t := range tags {
+		key += " " + t.Name + ":" + t.Value
+	}
+	s, _ := statuses.LoadOrStore(key, &statusCounters{name: name, tags: tags})
+	return s.(*statusCounters)
+}
+
+// counter returns the counter for the status class or code. The counter
+// has the tags of the target and a 'status' tag. The tags are folded into
+// the name for registries which do not support tags, e.g.
+// <name>.status_5xx.
+func (s *statusCounters) counter(status string) metrics.Counter {
+	if c, ok := s.counters.Load(status); ok {
+		return c.(metrics.Counter)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters.Load(status); ok {
+		return c.(metrics.Counter)
+	}
+	tags := append(append([]metrics.Tag(nil), s.tags...), metrics.Tag{Name: "status", Value: status})
+	c, name, err := metrics.GetCounter(ServiceRegistry, s.name, tags)
+	if err != nil {
+		log.Printf("[ERROR] Invalid metrics tag name: %s", err)
+		c, name = metrics.NoopCounter{}, ""
+	}
+	if name != "" {
+		s.names = append(s.names, name)
+	}
+	s.counters.Store(status, c)
+	return c
+}
+
+// registered returns the names of the counters in the registry.
+func (s *statusCounters) registered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.names...)
+}
+
+// CountStatus counts the response status code of the target in the
+// counter of its class or of the code depending on StatusMetrics.
+func (t *Target) CountStatus(code int) {
+	if t.status == nil || code < 100 || code > 999 {
+		return
+	}
+	var status string
+	switch StatusMetrics {
+	case "class":
+		if code/100 >= len(statusClasses) {
+			return
+		}
+		status = statusClasses[code/100]
+	case "code":
+		status = strconv.Itoa(code)
+	default:
+		return
+	}
+	t.status.counter(status).Inc(1)
+}
+
+// syncStatuses removes the status counters of the targets which are
+// no longer part of the routing table. The counters are unregistered
+// by syncRegistry.
+func syncStatuses(t Table) {
+	active := map[*statusCounters]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				active[tg.status] = true
+			}
+		}
+	}
+	statuses.Range(func(k, v interface{}) bool {
+		if !active[v.(*statusCounters)] {
+			statuses.Delete(k)
+		}
+		return true
+	})
+}
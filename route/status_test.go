@@ -0,0 +1,55 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCountStatus(t *testing.T) {
+	oldRegistry, oldMode := ServiceRegistry, StatusMetrics
+	ServiceRegistry = newStubRegistry()
+	defer func() { ServiceRegistry, StatusMetrics = oldRegistry, oldMode }()
+
+	newTable := func() Table {
+		tbl := make(Table)
+		tbl.addRoute(&RouteDef{Service: "svc-a", Src: "/aaa", Dst: "http://localhost:1234", Weight: 1})
+		return tbl
+	}
+	target := func(tbl Table) *Target { return tbl[""][0].Targets[0] }
+
+	tbl := newTable()
+	StatusMetrics = "class"
+	target(tbl).CountStatus(200)
+	target(tbl).CountStatus(204)
+	target(tbl).CountStatus(503)
+	StatusMetrics = "code"
+	target(tbl).CountStatus(404)
+	StatusMetrics = "none"
+	target(tbl).CountStatus(500)
+
+	want := []string{
+		"svc-a._./aaa.localhost_1234",
+		"svc-a._./aaa.localhost_1234.status_2xx",
+		"svc-a._./aaa.localhost_1234.status_404",
+		"svc-a._./aaa.localhost_1234.status_5xx",
+	}
+	if got := ServiceRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// the counters of the targets of the next table are kept
+	tbl = newTable()
+	syncRegistry(tbl)
+	syncStatuses(tbl)
+	if got := ServiceRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// and removed with the target
+	tbl = make(Table)
+	syncRegistry(tbl)
+	syncStatuses(tbl)
+	if got := ServiceRegistry.Names(); len(got) != 0 {
+		t.Fatalf("got %v want none", got)
+	}
+}
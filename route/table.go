@@ -12,6 +12,8 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/gobwas/glob"
@@ -29,7 +31,9 @@ var ServiceRegistry metrics.Registry = metrics.NoopRegistry{}
 
 // init initializes the routing table.
 func init() {
-	table.Store(make(Table))
+	t := make(Table)
+	table.Store(t)
+	activeIndex.Store(newTableIndex(t))
 }
 
 // GetTable returns the active routing table. The function
@@ -39,26 +43,75 @@ func GetTable() Table {
 	return table.Load().(Table)
 }
 
-// mu guards table and registry in SetTable.
+// MaxRoutes is the maximum number of targets of a routing table.
+// SetTable rejects routing tables with more targets. A value of 0
+// disables the limit.
+var MaxRoutes int
+
+// Metrics of the routing table which are set by the caller.
+var (
+	// RejectedTables counts the routing tables which were
+	// rejected since they exceeded MaxRoutes.
+	RejectedTables metrics.Counter = metrics.NoopCounter{}
+
+	// TableRoutes is a gauge of the number of targets
+	// of the active routing table.
+	TableRoutes metrics.Counter = metrics.NoopCounter{}
+
+	// TableBytes is a gauge of the estimated memory footprint
+	// of the active routing table in bytes.
+	TableBytes metrics.Counter = metrics.NoopCounter{}
+)
+
+// mu guards table, registry and the table gauges in SetTable.
 var mu sync.Mutex
 
+// tableRoutes and tableBytes are the current values of the
+// table gauges.
+var tableRoutes, tableBytes int64
+
 // SetTable sets the active routing table. A nil value
-// logs a warning and is ignored. The function is safe
-// to be called from multiple goroutines.
-func SetTable(t Table) {
+// logs a warning and is ignored. A table with more than
+// MaxRoutes targets is rejected and the active table is
+// kept. The function is safe to be called from multiple
+// goroutines.
+func SetTable(t Table) error {
 	if t == nil {
 		log.Print("[WARN] Ignoring nil routing table")
-		return
+		return nil
+	}
+
+	n := t.numTargets()
+	if MaxRoutes > 0 && n > MaxRoutes {
+		RejectedTables.Inc(1)
+		log.Printf("[ERROR] Rejecting routing table with %d routes which exceeds the limit of %d. Keeping the active routing table", n, MaxRoutes)
+		return fmt.Errorf("route: table has %d routes which exceeds the limit of %d", n, MaxRoutes)
 	}
+
 	mu.Lock()
+	keepEmptyFallbacks(t, GetTable())
+	size := t.estimateSize()
+	conflicts := int64(t.numConflicts())
+	idx := newTableIndex(t)
 	table.Store(t)
+	activeIndex.Store(idx)
 	syncRegistry(t)
+	syncOutliers(t)
+	syncDrains(t)
+	syncLoads(t)
+	syncLatencies(t)
+	syncStatuses(t)
+	TableRoutes.Inc(int64(n) - tableRoutes)
+	TableBytes.Inc(size - tableBytes)
+	TableConflicts.Inc(conflicts - tableConflicts)
+	tableRoutes, tableBytes, tableConflicts = int64(n), size, conflicts
 	mu.Unlock()
+	return nil
 }
 
-// syncRegistry unregisters all inactive timers.
-// It assumes that all timers of the table have
-// already been registered.
+// syncRegistry unregisters all inactive timers and
+// status counters. It assumes that all timers of the
+// table have already been registered.
 func syncRegistry(t Table) {
 	timers := map[string]bool{}
 
@@ -75,6 +128,12 @@ func syncRegistry(t Table) {
 		for _, r := range routes {
 			for _, tg := range r.Targets {
 				timers[tg.TimerName] = true
+				timers[tg.TimerName+".deprecated"] = true
+				if tg.status != nil {
+					for _, name := range tg.status.registered() {
+						timers[name] = true
+					}
+				}
 			}
 		}
 	}
@@ -88,6 +147,72 @@ func syncRegistry(t Table) {
 	}
 }
 
+// numTargets returns the number of targets of all routes.
+func (t Table) numTargets() int {
+	n := 0
+	for _, routes := range t {
+		for _, r := range routes {
+			n += len(r.Targets)
+		}
+	}
+	return n
+}
+
+// MinConns returns the number of warm connections per upstream
+// host:port for the targets with the 'minconns' option. If several
+// targets have the same host the largest value is used.
+func (t Table) MinConns() map[string]int {
+	m := map[string]int{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.MinConns <= 0 {
+					continue
+				}
+				addr := tg.URL.Host
+				if tg.URL.Port() == "" {
+					port := "80"
+					if tg.URL.Scheme == "https" && tg.Scheme == "" || tg.Scheme == "https" {
+						port = "443"
+					}
+					addr = net.JoinHostPort(tg.URL.Hostname(), port)
+				}
+				if tg.MinConns > m[addr] {
+					m[addr] = tg.MinConns
+				}
+			}
+		}
+	}
+	return m
+}
+
+// estimateSize returns a rough estimate of the memory footprint of
+// the table in bytes. It accounts for the routes, targets and their
+// strings but not for the metrics and the overhead of the maps.
+func (t Table) estimateSize() int64 {
+	var n int64
+	for host, routes := range t {
+		n += int64(len(host)) + int64(cap(routes))*int64(unsafe.Sizeof(&Route{}))
+		for _, r := range routes {
+			n += int64(unsafe.Sizeof(*r)) + int64(len(r.Host)+len(r.Path))
+			n += int64(cap(r.Targets)+cap(r.wTargets)) * int64(unsafe.Sizeof(&Target{}))
+			for _, tg := range r.Targets {
+				n += int64(unsafe.Sizeof(*tg)) + int64(len(tg.Service)+len(tg.StripPath)+len(tg.Host)+len(tg.TimerName)+len(tg.Prefix))
+				if tg.URL != nil {
+					n += int64(unsafe.Sizeof(*tg.URL)) + int64(len(tg.URL.String()))
+				}
+				for _, tag := range tg.Tags {
+					n += int64(unsafe.Sizeof(tag)) + int64(len(tag))
+				}
+				for k, v := range tg.Opts {
+					n += int64(2*unsafe.Sizeof(k)) + int64(len(k)+len(v))
+				}
+			}
+		}
+	}
+	return n
+}
+
 // Table contains a set of routes grouped by host.
 // The host routes are sorted from most to least specific
 // by sorting the routes in reverse order by path.
@@ -123,7 +248,12 @@ func NewTable(b *bytes.Buffer) (t Table, err error) {
 		case RouteDelCmd:
 			err = t.delRoute(d)
 		case RouteWeightCmd:
-			err = t.weighRoute(d)
+			// weights of services which are not registered are
+			// kept in the manual overrides and are applied again
+			// when the service returns. See InactiveOverrides.
+			if err = t.weighRoute(d); err == errNoMatch {
+				err = nil
+			}
 		default:
 			err = fmt.Errorf("route: invalid command: %s", d.Cmd)
 		}
@@ -173,6 +303,11 @@ func (t Table) addRoute(d *RouteDef) error {
 		return fmt.Errorf("route: invalid target. %s", err)
 	}
 
+	if err := checkTargetOpts(d.Opts); err != nil {
+		log.Printf("[ERROR] route: Dropping target %s for %s. %s", d.Dst, d.Src, err)
+		return nil
+	}
+
 	switch {
 	// add new host
 	case t[host] == nil:
@@ -181,7 +316,7 @@ func (t Table) addRoute(d *RouteDef) error {
 			return err
 		}
 		r := &Route{Host: host, Path: path, Glob: g}
-		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		r.addTarget(d.Service, targetURL, d.Weight, d.RelativeWeight, d.Tags, d.Opts)
 		t[host] = Routes{r}
 
 	// add new route to existing host
@@ -191,13 +326,18 @@ func (t Table) addRoute(d *RouteDef) error {
 			return err
 		}
 		r := &Route{Host: host, Path: path, Glob: g}
-		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		r.addTarget(d.Service, targetURL, d.Weight, d.RelativeWeight, d.Tags, d.Opts)
 		t[host] = append(t[host], r)
 		sort.Sort(t[host])
 
 	// add new target to existing route
 	default:
-		t[host].find(path).addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		r := t[host].find(path)
+		if r.allowService(d.Service) {
+			r.addTarget(d.Service, targetURL, d.Weight, d.RelativeWeight, d.Tags, d.Opts)
+			// the target may have changed the priority
+			sort.Sort(t[host])
+		}
 	}
 
 	return nil
@@ -220,6 +360,23 @@ func (t Table) weighRoute(d *RouteDef) error {
 	return nil
 }
 
+// checkTargetOpts returns an error if one of the options which protect
+// the upstream service is invalid. The target is then dropped since it
+// would receive the requests without the protection.
+func checkTargetOpts(opts map[string]string) error {
+	if s := opts["quota"]; s != "" {
+		if _, err := ParseQuota(s); err != nil {
+			return err
+		}
+	}
+	if s := opts["sign"]; s != "" {
+		if _, err := parseSign(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // delRoute removes one or more routes depending on the arguments.
 // If service, prefix and target are provided then only this route
 // is removed. Are only service and prefix provided then all routes
@@ -271,7 +428,8 @@ func (t Table) delRoute(d *RouteDef) error {
 		})
 	}
 
-	// remove all routes without targets
+	// remove all routes without targets and restore the order
+	// since the priority of the routes may have changed
 	for host, routes := range t {
 		var clone Routes
 		for _, r := range routes {
@@ -280,6 +438,7 @@ func (t Table) delRoute(d *RouteDef) error {
 			}
 			clone = append(clone, r)
 		}
+		sort.Sort(clone)
 		t[host] = clone
 	}
 
@@ -421,18 +580,46 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 	// and add "no host" as the fallback option
 	// if globDisabled then match without Glob
 	// Issue 548
-	if globDisabled {
+	idx := t.index()
+	switch {
+	case globDisabled && idx != nil:
+		hosts = idx.matchingHostNoGlob(req)
+	case globDisabled:
 		hosts = t.matchingHostNoGlob(req)
-	} else {
+	case idx != nil:
+		hosts = idx.matchingHosts(req, globCache)
+	default:
 		hosts = t.matchingHosts(req, globCache)
 	}
 
 	if trace != "" {
 		log.Printf("[TRACE] %s Matching hosts: %v", trace, hosts)
 	}
+	// the country of the client is only resolved
+	// for routes with 'match=geo:...' targets.
+	var country string
+	var resolved bool
+	clientCountry := func() string {
+		if !resolved && ClientCountry != nil {
+			country, resolved = ClientCountry(req), true
+		}
+		return country
+	}
+
+	// the fingerprint of the client is only resolved
+	// for routes with 'match=ja3:...' targets.
+	var fp string
+	var fpResolved bool
+	clientJA3 := func() string {
+		if !fpResolved && ClientJA3 != nil {
+			fp, fpResolved = ClientJA3(req), true
+		}
+		return fp
+	}
+
 	hosts = append(hosts, "")
 	for _, h := range hosts {
-		if target = t.lookup(h, req.URL.Path, trace, pick, match); target != nil {
+		if target = t.lookup(h, req.URL.Path, req.Header.Get("Accept"), clientCountry, clientJA3, trace, pick, match); target != nil {
 			if target.RedirectCode != 0 {
 				req.URL.Host = req.Host
 				target.BuildRedirectURL(req.URL) // build redirect url and cache in target
@@ -455,13 +642,72 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 }
 
 func (t Table) LookupHost(host string, pick picker) *Target {
-	return t.lookup(host, "/", "", pick, prefixMatcher)
+	return t.lookup(host, "/", "", noCountry, noJA3, "", pick, prefixMatcher)
+}
+
+// LookupHostJA3 returns the target for the host like LookupHost and
+// also matches the JA3 fingerprint ja3 of the TLS client with the
+// 'match=ja3:...' options of the targets.
+func (t Table) LookupHostJA3(host, ja3 string, pick picker) *Target {
+	return t.lookup(host, "/", "", noCountry, func() string { return ja3 }, "", pick, prefixMatcher)
 }
 
-func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Target {
+// noCountry is used for lookups without a client country.
+func noCountry() string { return "" }
+
+func (t Table) lookup(host, path, accept string, country, ja3 func() string, trace string, pick picker, match matcher) *Target {
 	host = strings.ToLower(host) // routes are always added lowercase
-	for _, r := range t[host] {
-		if match(path, r) {
+	alt := toggleSlash(path)
+
+	// only test the routes whose path can match
+	// if the table has a lookup index.
+	routes := t[host]
+	if idx := t.index(); idx != nil {
+		if rs, ok := idx.candidates(host, path, alt, match); ok {
+			routes = rs
+		}
+	}
+	for _, r := range routes {
+		// try the path with the trailing slash added or removed
+		// if the route is not strict about it.
+		matched, slashed := match(path, r), false
+		if !matched && alt != "" && r.trailingSlash() != "strict" {
+			matched, slashed = match(alt, r), true
+		}
+		if matched {
+			// continue with the next route if there are
+			// no targets for the fingerprint of the client
+			if nr := r.fingerprint(ja3); nr != r {
+				if len(nr.Targets) == 0 {
+					if trace != "" {
+						log.Printf("[TRACE] %s No ja3 match %s%s", trace, r.Host, r.Path)
+					}
+					continue
+				}
+				r = nr
+			}
+			// continue with the next route if there are
+			// no targets for the country of the client
+			if nr := r.locate(country); nr != r {
+				if len(nr.Targets) == 0 {
+					if trace != "" {
+						log.Printf("[TRACE] %s No geo match %s%s", trace, r.Host, r.Path)
+					}
+					continue
+				}
+				r = nr
+			}
+			// continue with the next route if none of the
+			// targets accepts the media types of the request
+			if nr := r.negotiate(accept); nr != r {
+				if len(nr.Targets) == 0 {
+					if trace != "" {
+						log.Printf("[TRACE] %s No accept match %s%s", trace, r.Host, r.Path)
+					}
+					continue
+				}
+				r = nr
+			}
 			n := len(r.Targets)
 			if n == 0 {
 				return nil
@@ -471,11 +717,23 @@ func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Tar
 			if n == 1 {
 				target = r.Targets[0]
 			} else {
-				target = pick(r)
+				target = avoidLocal(r, preferDatacenter(r, pickAvailable(r, pick)))
+			}
+			// continue with the next route if the path
+			// does not match the regular expression of
+			// the redirect
+			if target.RedirectCode != 0 && target.RedirectMatch != nil && !target.RedirectMatch.MatchString(path) {
+				if trace != "" {
+					log.Printf("[TRACE] %s No redirect match %s%s", trace, r.Host, r.Path)
+				}
+				continue
 			}
 			if trace != "" {
 				log.Printf("[TRACE] %s Match %s%s", trace, r.Host, r.Path)
 			}
+			if slashed && r.trailingSlash() == "redirect" {
+				return slashRedirect(target, alt)
+			}
 			return target
 		}
 		if trace != "" {
@@ -486,6 +744,17 @@ func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Tar
 }
 
 func (t Table) config(addWeight bool) []string {
+	var cfg []string
+	for _, host := range t.configHosts() {
+		for _, routes := range t[host] {
+			cfg = append(cfg, routes.config(addWeight)...)
+		}
+	}
+	return cfg
+}
+
+// configHosts returns the hosts of the table in the order of the config.
+func (t Table) configHosts() []string {
 	var hosts []string
 	for host := range t {
 		if host != "" {
@@ -495,15 +764,7 @@ func (t Table) config(addWeight bool) []string {
 	sort.Sort(sort.Reverse(sort.StringSlice(hosts)))
 
 	// entries without host come last
-	hosts = append(hosts, "")
-
-	var cfg []string
-	for _, host := range hosts {
-		for _, routes := range t[host] {
-			cfg = append(cfg, routes.config(addWeight)...)
-		}
-	}
-	return cfg
+	return append(hosts, "")
 }
 
 // String returns the routing table as config file which can
@@ -540,16 +801,31 @@ func (t Table) Dump() string {
 				p1 = "+-- "
 			}
 
-			fmt.Fprintf(w, "%s%spath=%s\n", p0, p1, r.Path)
-
-			m := map[*Target]int{}
-			for _, t := range r.wTargets {
-				m[t] += 1
+			conflicts := ""
+			if len(r.conflicts) > 0 {
+				conflicts = " conflicts=" + strings.Join(r.conflicts, ",")
+			}
+			fmt.Fprintf(w, "%s%spath=%s%s\n", p0, p1, r.Path, conflicts)
+
+			// targets with a 'match=...' option are weighted within
+			// the group of targets with the same country or media type.
+			// Targets in more than one group show the slots of the first.
+			type slots struct{ n, total int }
+			m := map[*Target]slots{}
+			for _, g := range r.weighGroups() {
+				seen := map[*Target]bool{}
+				for _, t := range g.wTargets {
+					if _, ok := m[t]; ok && !seen[t] {
+						continue
+					}
+					seen[t] = true
+					m[t] = slots{m[t].n + 1, len(g.wTargets)}
+				}
 			}
 
-			total := len(r.wTargets)
 			k := 0
-			for t, n := range m {
+			for t, s := range m {
+				n, total := s.n, s.total
 				p1 := "|    "
 				if last(j, len(routes)) {
 					p1 = "    "
@@ -559,7 +835,32 @@ func (t Table) Dump() string {
 					p2 = "+-- "
 				}
 				weight := float64(n) / float64(total)
-				fmt.Fprintf(w, "%s%s%saddr=%s weight %2.2f slots %d/%d\n", p0, p1, p2, t.URL.Host, weight, n, total)
+				proto := ""
+				if t.AutoProto {
+					proto = " proto=auto"
+					if p := t.Proto(); p != "" {
+						proto = " proto=" + p
+					}
+				}
+				if d := t.outlier.remaining(time.Now()); d > 0 {
+					proto += " ejected=" + d.Round(time.Second).String()
+				}
+				if t.Datacenter != "" {
+					proto += " dc=" + t.Datacenter
+				}
+				if t.Draining {
+					proto += fmt.Sprintf(" draining=%d%%", int(100*t.drainFactor(time.Now())))
+				}
+				if t.AcceptMatch != "" {
+					proto += " accept=" + t.AcceptMatch
+				}
+				if len(t.GeoCountries) > 0 {
+					proto += " geo=" + strings.Join(t.GeoCountries, ",")
+				}
+				if len(t.JA3Match) > 0 {
+					proto += " ja3=" + strings.Join(t.JA3Match, ",")
+				}
+				fmt.Fprintf(w, "%s%s%saddr=%s weight %2.2f slots %d/%d%s\n", p0, p1, p2, t.URL.Host, weight, n, total, proto)
 				k++
 			}
 		}
@@ -16,7 +16,8 @@ func TestSyncRegistry(t *testing.T) {
 	tbl := make(Table)
 	tbl.addRoute(&RouteDef{Service: "svc-a", Src: "/aaa", Dst: "http://localhost:1234", Weight: 1})
 	tbl.addRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678", Weight: 1})
-	if got, want := ServiceRegistry.Names(), []string{"svc-a._./aaa.localhost_1234", "svc-b._./bbb.localhost_5678"}; !reflect.DeepEqual(got, want) {
+	tbl[""].find("/bbb").Targets[0].CountDeprecated()
+	if got, want := ServiceRegistry.Names(), []string{"svc-a._./aaa.localhost_1234", "svc-b._./bbb.localhost_5678", "svc-b._./bbb.localhost_5678.deprecated"}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v want %v", got, want)
 	}
 
@@ -61,3 +62,8 @@ func (p *stubRegistry) GetTimer(name string) metrics.Timer {
 	p.names[name] = true
 	return metrics.NoopTimer{}
 }
+
+func (p *stubRegistry) GetHistogram(name string) metrics.Histogram {
+	p.names[name] = true
+	return metrics.NoopHistogram{}
+}
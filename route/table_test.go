@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -306,6 +307,84 @@ func TestTableParse(t *testing.T) {
 			},
 		},
 
+		{"weigh relative weights -> normalize relative to the sum of the service",
+			[]string{
+				`route add svc / http://bar:111/ weight 3`,
+				`route add svc / http://bar:222/ weight 7`,
+			},
+			[]string{
+				`route add svc / http://bar:111/ weight 0.3000`,
+				`route add svc / http://bar:222/ weight 0.7000`,
+			},
+		},
+
+		{"weigh fixed weights with decimal point -> not relative",
+			[]string{
+				`route add svca / http://bar:111/ weight 1.0`,
+				`route add svca / http://bar:222/ weight 1.0`,
+				`route add svcb / http://bar:333/ weight 4.0`,
+			},
+			[]string{
+				`route add svca / http://bar:111/ weight 0.1667`,
+				`route add svca / http://bar:222/ weight 0.1667`,
+				`route add svcb / http://bar:333/ weight 0.6667`,
+			},
+		},
+
+		{"weigh relative weights of multiple services -> normalize per service",
+			[]string{
+				`route add svca / http://bar:111/ weight 1`,
+				`route add svca / http://bar:222/ weight 1`,
+				`route add svcb / http://bar:333/ weight 4`,
+			},
+			[]string{
+				`route add svca / http://bar:111/ weight 0.2500`,
+				`route add svca / http://bar:222/ weight 0.2500`,
+				`route add svcb / http://bar:333/ weight 0.5000`,
+			},
+		},
+
+		{"weigh relative weights and fractional weights -> normalize to 100%",
+			[]string{
+				`route add svca / http://bar:111/ weight 1`,
+				`route add svca / http://bar:222/ weight 3`,
+				`route add svcb / http://bar:333/ weight 0.25`,
+			},
+			[]string{
+				`route add svca / http://bar:111/ weight 0.2000`,
+				`route add svca / http://bar:222/ weight 0.6000`,
+				`route add svcb / http://bar:333/ weight 0.2000`,
+			},
+		},
+
+		{"weigh service weights -> split between the targets of the service",
+			[]string{
+				`route add v1 / http://bar:111/ opts "serviceweight=0.9"`,
+				`route add v1 / http://bar:222/ opts "serviceweight=0.9"`,
+				`route add v1 / http://bar:333/ opts "serviceweight=0.9"`,
+				`route add v2 / http://bar:444/ opts "serviceweight=0.1"`,
+			},
+			[]string{
+				`route add v1 / http://bar:111/ weight 0.3000 opts "serviceweight=0.9"`,
+				`route add v1 / http://bar:222/ weight 0.3000 opts "serviceweight=0.9"`,
+				`route add v1 / http://bar:333/ weight 0.3000 opts "serviceweight=0.9"`,
+				`route add v2 / http://bar:444/ weight 0.1000 opts "serviceweight=0.1"`,
+			},
+		},
+
+		{"weigh service weights without targets of a service -> redistribute",
+			[]string{
+				`route add v1 / http://bar:111/ opts "serviceweight=0.6"`,
+				`route add v2 / http://bar:222/ opts "serviceweight=0.3"`,
+				`route add v2 / http://bar:333/ opts "serviceweight=0.3"`,
+			},
+			[]string{
+				`route add v1 / http://bar:111/ weight 0.6667 opts "serviceweight=0.6"`,
+				`route add v2 / http://bar:222/ weight 0.1667 opts "serviceweight=0.3"`,
+				`route add v2 / http://bar:333/ weight 0.1667 opts "serviceweight=0.3"`,
+			},
+		},
+
 		{"weigh dynamic weight matched on service name",
 			[]string{
 				`route add svca / http://bar:111/`,
@@ -645,6 +724,217 @@ func TestTableLookup(t *testing.T) {
 	}
 }
 
+func TestTableLookup_Accept(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800
+	route add svc / http://foo.com:900 opts "match=accept:application/xml"
+	route add svc / http://foo.com:1000 opts "match=accept:application/json"
+	route add svc /xml http://foo.com:1100 opts "match=accept:application/xml"
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		accept string
+		dst    string
+	}{
+		{"/", "", "http://foo.com:800"},
+		{"/", "text/html", "http://foo.com:800"},
+		{"/", "*/*", "http://foo.com:800"},
+		{"/", "text/html, */*;q=0.8", "http://foo.com:800"},
+		{"/", "application/xml", "http://foo.com:900"},
+		{"/", "application/json", "http://foo.com:1000"},
+		{"/", "application/xml;q=0.5, application/json", "http://foo.com:1000"},
+		{"/", "application/*, application/json;q=0.9", "http://foo.com:900"},
+		{"/", "application/json;q=0, application/*", "http://foo.com:900"},
+		{"/", "text/html, application/xml;q=0.9", "http://foo.com:900"},
+
+		// routes without a default target use the best match
+		// and fall through to the next route otherwise
+		{"/xml", "", "http://foo.com:1100"},
+		{"/xml", "*/*", "http://foo.com:1100"},
+		{"/xml", "text/html", "http://foo.com:800"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			req := &http.Request{Host: "abc.com", URL: mustParse(tt.path), Header: http.Header{}}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			target := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globEnabled)
+			if target == nil {
+				t.Fatal("no target")
+			}
+			if got, want := target.URL.String(), tt.dst; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTableLookup_Geo(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800
+	route add svc / http://foo.com:900 opts "match=geo:country=DE,fr"
+	route add svc / http://foo.com:1000 opts "match=geo:country=IT"
+	route add svc /eu http://foo.com:1100 opts "match=geo:country=DE"
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countries := map[string]string{"1.1.1.1": "DE", "2.2.2.2": "FR", "3.3.3.3": "IT", "4.4.4.4": "US"}
+	defer func() { ClientCountry = nil }()
+	ClientCountry = func(r *http.Request) string {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return countries[host]
+	}
+
+	tests := []struct {
+		path   string
+		remote string
+		dst    string
+	}{
+		{"/", "1.1.1.1", "http://foo.com:900"},
+		{"/", "2.2.2.2", "http://foo.com:900"},
+		{"/", "3.3.3.3", "http://foo.com:1000"},
+		{"/", "4.4.4.4", "http://foo.com:800"},
+
+		// unresolved clients use the default targets
+		{"/", "10.0.0.1", "http://foo.com:800"},
+
+		// routes without a default target fall through
+		// to the next route for other countries
+		{"/eu", "1.1.1.1", "http://foo.com:1100"},
+		{"/eu", "3.3.3.3", "http://foo.com:1000"},
+		{"/eu", "10.0.0.1", "http://foo.com:800"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.remote, func(t *testing.T) {
+			req := &http.Request{Host: "abc.com", URL: mustParse(tt.path), Header: http.Header{}, RemoteAddr: tt.remote + ":1234"}
+			target := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globEnabled)
+			if target == nil {
+				t.Fatal("no target")
+			}
+			if got, want := target.URL.String(), tt.dst; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+
+	// without a country resolver the default targets are used
+	ClientCountry = nil
+	req := &http.Request{Host: "abc.com", URL: mustParse("/"), Header: http.Header{}, RemoteAddr: "1.1.1.1:1234"}
+	if got, want := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globEnabled).URL.String(), "http://foo.com:800"; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestTableLookup_JA3(t *testing.T) {
+	bot := "e7d705a3286e19ea42f587b344ee6865"
+	s := `
+	route add svc / http://foo.com:800
+	route add svc / http://foo.com:900 opts "match=ja3:` + strings.ToUpper(bot) + `"
+	route add svc /bots http://foo.com:1000 opts "match=ja3:` + bot + `"
+	route add svc /geo http://foo.com:1100 opts "match=ja3:` + bot + `"
+	route add svc /geo http://foo.com:1200 opts "match=geo:country=DE"
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { ClientJA3, ClientCountry = nil, nil }()
+	ClientJA3 = func(r *http.Request) string { return r.Header.Get("Fp") }
+	ClientCountry = func(r *http.Request) string { return "DE" }
+
+	tests := []struct {
+		path string
+		fp   string
+		dst  string
+	}{
+		{"/", bot, "http://foo.com:900"},
+		{"/", "", "http://foo.com:800"},
+		{"/", "00000000000000000000000000000000", "http://foo.com:800"},
+
+		// routes without a default target fall
+		// through to the next route for other clients
+		{"/bots", bot, "http://foo.com:1000"},
+		{"/bots", "", "http://foo.com:800"},
+
+		// the other targets are matched by country
+		{"/geo", bot, "http://foo.com:1100"},
+		{"/geo", "", "http://foo.com:1200"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.fp, func(t *testing.T) {
+			req := &http.Request{Host: "abc.com", URL: mustParse(tt.path), Header: http.Header{"Fp": {tt.fp}}}
+			target := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globEnabled)
+			if target == nil {
+				t.Fatal("no target")
+			}
+			if got, want := target.URL.String(), tt.dst; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+
+	if got, want := tbl.LookupHostJA3("", bot, rrPicker).URL.String(), "http://foo.com:900"; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := tbl.LookupHost("", rrPicker).URL.String(), "http://foo.com:800"; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestTableLookup_RedirectMatch(t *testing.T) {
+	s := `
+	route add svc /old/ http://bar.com/new/$1 opts "redirect=308 redirectmatch=^/old/([0-9]+)$"
+	route add svc / http://foo.com:800
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		req  string
+		code int
+		dst  string
+	}{
+		{"/old/123", 308, "http://bar.com/new/123"},
+		{"/old/abc", 0, "http://foo.com:800"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.req, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com"+tt.req, nil)
+			target := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globEnabled)
+			if target == nil {
+				t.Fatal("no target")
+			}
+			got := target.URL.String()
+			if target.RedirectCode != 0 {
+				got = target.RedirectURL.String()
+			}
+			if got != tt.dst || target.RedirectCode != tt.code {
+				t.Fatalf("got %d %s want %d %s", target.RedirectCode, got, tt.code, tt.dst)
+			}
+		})
+	}
+}
+
 func TestTableLookup_656(t *testing.T) {
 	// A typical HTTPS redirect
 	s := `
@@ -735,6 +1025,17 @@ func TestNewTableCustom(t *testing.T) {
 	}
 }
 
+func TestTable_StringRelativeWeight(t *testing.T) {
+	s := "route add svc / http://bar:111/ weight 3\nroute add svc / http://bar:222/ weight 0.5000"
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tbl.String(), s; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
 func TestTable_Dump(t *testing.T) {
 	s := `
 	route add svc / http://foo.com:800
@@ -763,3 +1064,143 @@ func TestTable_Dump(t *testing.T) {
 		t.Errorf("Unexpected Dump() output:\nwant:\n%s\ngot:\n%s\n", want, got)
 	}
 }
+
+func TestTable_DumpProto(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800 opts "proto=auto"
+	route add svc / http://foo.com:900 opts "proto=auto"
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl[""][0].Targets[1].SetProto("HTTP/2.0")
+
+	got := tbl.Dump()
+	for _, want := range []string{
+		"addr=foo.com:800 weight 0.50 slots 1/2 proto=auto\n",
+		"addr=foo.com:900 weight 0.50 slots 1/2 proto=HTTP/2.0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Dump() output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+type testCounter struct{ n int64 }
+
+func (c *testCounter) Inc(n int64) { c.n += n }
+
+func TestSetTableMaxRoutes(t *testing.T) {
+	prevTable, prevMax := GetTable(), MaxRoutes
+	prevRejected, prevRoutes, prevBytes := RejectedTables, TableRoutes, TableBytes
+	defer func() {
+		SetTable(prevTable)
+		MaxRoutes = prevMax
+		RejectedTables, TableRoutes, TableBytes = prevRejected, prevRoutes, prevBytes
+	}()
+
+	rejected, routes, size := &testCounter{}, &testCounter{}, &testCounter{}
+	RejectedTables, TableRoutes, TableBytes = rejected, routes, size
+	MaxRoutes = 2
+
+	mustTable := func(s string) Table {
+		tbl, err := NewTable(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+
+	small := mustTable("route add svc / http://foo.com:800\nroute add svc /foo http://foo.com:900")
+	if err := SetTable(small); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := routes.n, int64(2); got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+	if size.n <= 0 {
+		t.Fatalf("got table size %d want > 0", size.n)
+	}
+
+	large := mustTable("route add svc / http://foo.com:800\nroute add svc /foo http://foo.com:900\nroute add svc /bar http://foo.com:1000")
+	if err := SetTable(large); err == nil {
+		t.Fatal("got nil want error")
+	}
+	if got, want := rejected.n, int64(1); got != want {
+		t.Fatalf("got %d rejected tables want %d", got, want)
+	}
+	if got, want := GetTable().numTargets(), 2; got != want {
+		t.Fatalf("got active table with %d routes want %d", got, want)
+	}
+	if got, want := routes.n, int64(2); got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+}
+
+func TestTableMinConns(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc-a / http://1.2.3.4:5000/ opts "minconns=2"
+route add svc-a /foo http://1.2.3.4:5000/ opts "minconns=5"
+route add svc-b / https://example.com/ opts "minconns=1"
+route add svc-c / http://5.6.7.8:5000/
+route add svc-d / http://example.com/ opts "minconns=-1"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"1.2.3.4:5000": 5, "example.com:443": 1}
+	if got := tbl.MinConns(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestTableLookupPriority(t *testing.T) {
+	s := `
+	route add svc /api/ http://foo.com:800 opts "priority=10"
+	route add svc /api/v1/ http://foo.com:900
+	route add svc /api/v2/ http://foo.com:1000
+	route add svc /api/v2/ http://foo.com:1100 opts "priority=20"
+	route add svc /other http://foo.com:1200 opts "priority=-1"
+	route add svc / http://foo.com:1300
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(path string) string {
+		req := &http.Request{Host: "abc.com", URL: mustParse(path)}
+		tg := tbl.Lookup(req, "", func(r *Route) *Target { return r.Targets[0] }, prefixMatcher, globCache, globEnabled)
+		if tg == nil {
+			return ""
+		}
+		return tg.URL.String()
+	}
+
+	tests := []struct {
+		path, dst string
+	}{
+		// the higher priority wins over the more specific path
+		{"/api/v1/users", "http://foo.com:800"},
+		// the priority of a route is the highest priority of its targets
+		{"/api/v2/users", "http://foo.com:1000"},
+		// a negative priority is matched after the routes without one
+		{"/other", "http://foo.com:1300"},
+	}
+	for _, tt := range tests {
+		if got, want := lookup(tt.path), tt.dst; got != want {
+			t.Errorf("%s: got %s want %s", tt.path, got, want)
+		}
+	}
+
+	// the order is restored when the target with the priority is removed
+	if err := tbl.delRoute(&RouteDef{Service: "svc", Src: "/api/", Dst: "http://foo.com:800"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lookup("/api/v1/users"), "http://foo.com:900"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
@@ -1,8 +1,13 @@
 package route
 
 import (
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/fabiolb/fabio/metrics"
 )
@@ -25,6 +30,16 @@ type Target struct {
 	// TLS connections.
 	TLSSkipVerify bool
 
+	// Scheme overrides the scheme of the URL of the target for the
+	// upstream request, e.g. to connect to a target which has been
+	// registered with http:// via TLS. The value is either 'http',
+	// 'https' or empty.
+	Scheme string
+
+	// AntiAffinity avoids the targets of the route which are on the
+	// node fabio is running on. See LocalAddrs.
+	AntiAffinity bool
+
 	// Host signifies what the proxy will set the Host header to.
 	// The proxy does not modify the Host header by default.
 	// When Host is set to 'dst' the proxy will use the host name
@@ -42,10 +57,101 @@ type Target struct {
 	// This is cached here to prevent multiple generations per request.
 	RedirectURL *url.URL
 
+	// FallbackURL is the target which receives the request if this
+	// target fails or does not respond within FallbackTimeout.
+	FallbackURL *url.URL
+
+	// Expect describes the expected responses of the target. Other
+	// responses are counted as failures. If the value is nil all
+	// responses are accepted.
+	Expect *Expect
+
+	// EmptyFallback is the sorry server or the file which receives
+	// the requests of the route when all of its targets have been
+	// ejected by the outlier detection or deregistered. The scheme is
+	// either http, https or file. The path of a file URL is within
+	// FallbackDir.
+	EmptyFallback *url.URL
+
+	// Empty is true for the target of a route whose targets have all
+	// been deregistered. The requests are sent to the EmptyFallback.
+	Empty bool
+
+	// BreakerFallback is the response which is sent without contacting
+	// the targets when all targets of the route have been ejected. It
+	// takes precedence over the EmptyFallback.
+	BreakerFallback *BreakerFallback
+
+	// FallbackTimeout is the time the target has to send the
+	// response headers before the request is sent to FallbackURL.
+	// A value of 0 means no timeout.
+	FallbackTimeout time.Duration
+
+	// RedirectMatch is matched against the request path of a redirect.
+	// Its capture groups replace $1 to $9 and ${n} in the redirect URL.
+	// Requests which do not match are not redirected.
+	RedirectMatch *regexp.Regexp
+
+	// RedirectQuery determines whether the query string of the request
+	// is added to the redirect URL ("keep") or not ("drop"). By default
+	// the query string is only kept for redirect URLs with $path.
+	RedirectQuery string
+
+	// AllowMethods are the HTTP methods of the requests which are
+	// forwarded to the target. Requests with other methods are
+	// rejected with 405 Method Not Allowed. All methods are allowed
+	// if the list is empty.
+	AllowMethods []string
+
+	// BlockPaths are matched against the request path. Requests
+	// with a matching path are rejected with 403 Forbidden.
+	BlockPaths []*regexp.Regexp
+
+	// TrailingSlash overrides the global trailing slash policy for the
+	// route of the target. See TrailingSlash.
+	TrailingSlash string
+
+	// ServerTiming overrides the global proxy.servertiming setting
+	// for the target with "true" or "false". The Server-Timing header
+	// is added according to the global setting if the value is empty.
+	ServerTiming string
+
+	// Buffer overrides whether the request bodies of the target are
+	// buffered with "true" or "false". With "false" the body is streamed
+	// to the target and the requests are not retried. Requests with a
+	// body are also not sent to the fallback target. With "true" the
+	// body is always buffered. The body is only buffered when needed if
+	// the value is empty. The 'transform' option always buffers the body.
+	Buffer string
+
+	// Deprecation is the value of the Deprecation header which is added
+	// to the responses of the target. It is either "true" or the date
+	// of the deprecation in the form @<unix timestamp>.
+	Deprecation string
+
+	// Sunset is the value of the Sunset header, i.e. the HTTP date
+	// after which the route will be removed.
+	Sunset string
+
+	// DeprecationLink is the URL of the documentation of the deprecation
+	// which is added to the responses as Link header with rel="deprecation".
+	DeprecationLink string
+
 	// FixedWeight is the weight assigned to this target.
 	// If the value is 0 the targets weight is dynamic.
 	FixedWeight float64
 
+	// RelativeWeight is the integer weight of this target relative to
+	// the other targets of the same service on the route. If the value
+	// is > 0 then FixedWeight is computed from it.
+	RelativeWeight int
+
+	// ServiceWeight is the share of the traffic of the route for all
+	// targets of the same service. If the value is > 0 then FixedWeight
+	// is computed from it by dividing it by the number of targets of the
+	// service on the route.
+	ServiceWeight float64
+
 	// Weight is the actual weight for this service in percent.
 	Weight float64
 
@@ -55,6 +161,11 @@ type Target struct {
 	// TimerName is the name of the timer in the metrics registry
 	TimerName string
 
+	// NoMetrics is true if the target is excluded from the per-route
+	// metrics with metrics.exclude. NoTotals is true if it is also
+	// excluded from the global metrics.
+	NoMetrics, NoTotals bool
+
 	// accessRules is map of access information for the target.
 	accessRules map[string][]interface{}
 
@@ -63,6 +174,210 @@ type Target struct {
 
 	// ProxyProto enables PROXY Protocol on upstream connection
 	ProxyProto bool
+
+	// StickySourceIP maps the clients of the TCP route of the target
+	// to the targets by their source IP address. See PickSticky.
+	StickySourceIP bool
+
+	// Prefix is the host/path prefix of the route this target
+	// belongs to.
+	Prefix string
+
+	// MaxStreams is the maximum number of concurrent gRPC streams
+	// for the route of this target. A value of 0 means unlimited.
+	MaxStreams int
+
+	// FairShare is the share of the route of this target of the
+	// concurrent upstream requests when the routes compete for the
+	// proxy.maxconcurrent slots. A value of 0 means a share of 1.
+	FairShare int
+
+	// MinConns is the number of established connections which are
+	// kept to the host of the target so that requests do not have to
+	// wait for the connection setup. A value of 0 keeps none.
+	MinConns int
+
+	// UpstreamProxy is the HTTP forward proxy through which the
+	// connections to the target are tunneled with CONNECT. If the
+	// value is nil the target is connected directly. The URL has no
+	// user info so that the credentials are not shown. See
+	// UpstreamProxyURL.
+	UpstreamProxy *url.URL
+
+	// Resolve is the interval in which the host of the target URL is
+	// resolved again with the 'resolve=dns:<interval>' option. The
+	// connections are spread over the resolved addresses. The host is
+	// resolved by the dialer of the transport if Resolve is zero.
+	Resolve time.Duration
+
+	// load is the moving average of the load reported by the backend.
+	load *loadAvg
+
+	// MaxRespBody is the maximum size of the response bodies of the
+	// target in bytes. The size is not limited if it is zero.
+	MaxRespBody int64
+
+	// Tap is the fraction of the requests between 0 and 1 whose
+	// metadata is sent to the tap sink. 0 disables the tap.
+	Tap float64
+
+	// Datacenter is the name of the datacenter of the target and
+	// DCPriority its priority. Targets in a datacenter with a higher
+	// value are only used when there is no available target in a
+	// datacenter with a lower value. The local datacenter has the
+	// priority 0.
+	Datacenter string
+	DCPriority int
+
+	// Draining is set for targets which are about to be deregistered.
+	// Their weight decreases linearly to zero over DrainDecay which
+	// starts with the first routing table which contains the target
+	// as draining.
+	Draining   bool
+	drainStart time.Time
+
+	// Quota limits the total number of requests per client key
+	// of the route. The requests are not limited if it is nil.
+	Quota *Quota
+
+	// ResponseHeaders are added to the responses of the target.
+	// They replace global response headers with the same name.
+	ResponseHeaders http.Header
+
+	// SetHeaders are added to the upstream requests of the target.
+	// Their values are computed from the request. See HeaderTemplate.
+	SetHeaders []*HeaderTemplate
+
+	// ForceResponseHeaders replaces the ResponseHeaders which were
+	// set by the upstream server.
+	ForceResponseHeaders bool
+
+	// AutoProto enables the negotiation of the upstream protocol.
+	// HTTP/2 is used if the upstream server supports it and HTTP/1.1
+	// otherwise.
+	AutoProto bool
+
+	// Pins are the base64 encoded SHA-256 hashes of the public keys
+	// of which one must be in the certificate chain of the upstream
+	// server. Certificates are not pinned if the list is empty.
+	Pins []string
+
+	// SignSecret is the reference of the secret for the HMAC-SHA256
+	// signature of upstream requests in the form <source>/<name>.
+	// Requests are not signed if the value is empty.
+	SignSecret string
+
+	// Transform is the name of the transformer which rewrites
+	// the request and response bodies. See proxy/transform.
+	Transform string
+
+	// Aggregate is the name of the aggregator which sends the
+	// requests to all targets of the route and combines the
+	// responses. See proxy/aggregate.
+	Aggregate string
+
+	// Priority is the value of the 'priority' option.
+	// See Route.Priority.
+	Priority int
+
+	// LogTarget is the name of the access log destination
+	// of the target. See log.access.targets.
+	LogTarget string
+
+	// CaptureBody is true if the request and response bodies of the
+	// target are logged to the capture log while the body capture is
+	// enabled in the admin API.
+	CaptureBody bool
+
+	// AcceptMatch is the media type of the 'match=accept:<type>' option.
+	// The target only receives requests which accept this media type.
+	AcceptMatch string
+
+	// GeoCountries are the country codes of the 'match=geo:country=...'
+	// option. The target only receives requests from clients in these
+	// countries.
+	GeoCountries []string
+
+	// JA3Match are the fingerprints of the 'match=ja3:...' option. The
+	// target only receives requests from TLS clients with one of these
+	// JA3 fingerprints.
+	JA3Match []string
+
+	// latency is the average response latency of the target.
+	latency *latencyAvg
+
+	// status counts the response status codes of the target.
+	status *statusCounters
+
+	// outlier is the outlier detection state of the target.
+	outlier *outlier
+
+	// peers are the targets of the route this target belongs to
+	// including the target itself.
+	peers []*Target
+
+	// proto is the protocol of the last response from the target.
+	proto *atomic.Value
+
+	// upstreamProxyUser contains the credentials of the upstream proxy.
+	upstreamProxyUser *url.Userinfo
+}
+
+// UpstreamProxyURL returns the URL of the upstream proxy including the
+// credentials for the proxy authentication or nil if the target is
+// connected directly.
+func (t *Target) UpstreamProxyURL() *url.URL {
+	if t.UpstreamProxy == nil || t.upstreamProxyUser == nil {
+		return t.UpstreamProxy
+	}
+	u := *t.UpstreamProxy
+	u.User = t.upstreamProxyUser
+	return &u
+}
+
+// Peers returns the targets of the route this target belongs to
+// including the target itself.
+func (t *Target) Peers() []*Target {
+	return t.peers
+}
+
+// Counter returns the per-route counter of the target with the suffix,
+// e.g. ".rx". It returns a counter which discards the values if the
+// target is excluded from the metrics.
+func (t *Target) Counter(suffix string) metrics.Counter {
+	if t.NoMetrics {
+		return metrics.NoopCounter{}
+	}
+	return metrics.DefaultRegistry.GetCounter(t.TimerName + suffix)
+}
+
+// CountDeprecated counts the requests to the deprecated target in the
+// <target>.deprecated counter which is unregistered with the timer of
+// the target.
+func (t *Target) CountDeprecated() {
+	if t.NoMetrics {
+		return
+	}
+	ServiceRegistry.GetCounter(t.TimerName + ".deprecated").Inc(1)
+}
+
+// SetProto records the protocol negotiated with the target,
+// e.g. "HTTP/2.0".
+func (t *Target) SetProto(proto string) {
+	if t.proto == nil {
+		return
+	}
+	t.proto.Store(proto)
+}
+
+// Proto returns the protocol negotiated with the target or an
+// empty string if no request has been made yet.
+func (t *Target) Proto() string {
+	if t.proto == nil {
+		return ""
+	}
+	s, _ := t.proto.Load().(string)
+	return s
 }
 
 func (t *Target) BuildRedirectURL(requestURL *url.URL) {
@@ -111,4 +426,58 @@ func (t *Target) BuildRedirectURL(requestURL *url.URL) {
 	if strings.Contains(t.RedirectURL.Host, "$host") {
 		t.RedirectURL.Host = strings.Replace(t.RedirectURL.Host, "$host", requestURL.Host, 1)
 	}
+	if t.RedirectMatch != nil {
+		m := t.RedirectMatch.FindStringSubmatch(requestURL.Path)
+		t.RedirectURL.Host = expandCaptures(t.RedirectURL.Host, m)
+		t.RedirectURL.Path = expandCaptures(t.RedirectURL.Path, m)
+		t.RedirectURL.RawPath = expandCaptures(t.RedirectURL.RawPath, m)
+	}
+	switch t.RedirectQuery {
+	case "keep":
+		t.RedirectURL.RawQuery = t.URL.RawQuery
+		if t.RedirectURL.RawQuery == "" {
+			t.RedirectURL.RawQuery = requestURL.RawQuery
+		} else if requestURL.RawQuery != "" {
+			t.RedirectURL.RawQuery += "&" + requestURL.RawQuery
+		}
+	case "drop":
+		t.RedirectURL.RawQuery = t.URL.RawQuery
+	}
+}
+
+// expandCaptures replaces $1 to $9 and ${n} in s with the
+// corresponding capture groups in m. References to capture
+// groups which do not exist are replaced with an empty string.
+func expandCaptures(s string, m []string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		var ref string
+		switch {
+		case s[i+1] >= '1' && s[i+1] <= '9':
+			ref = s[i+1 : i+2]
+			i++
+		case s[i+1] == '{' && strings.IndexByte(s[i:], '}') > 2:
+			end := i + strings.IndexByte(s[i:], '}')
+			ref = s[i+2 : end]
+			if _, err := strconv.Atoi(ref); err != nil {
+				b.WriteByte(s[i])
+				continue
+			}
+			i = end
+		default:
+			b.WriteByte(s[i])
+			continue
+		}
+		if n, _ := strconv.Atoi(ref); n < len(m) {
+			b.WriteString(m[n])
+		}
+	}
+	return b.String()
 }
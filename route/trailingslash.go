@@ -0,0 +1,59 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrailingSlash determines how a request is handled whose path matches
+// a route only with the trailing slash added or removed, e.g. '/app'
+// for the route '/app/'.
+//
+//	strict:   the request does not match the route
+//	redirect: the request is redirected with 308 to the path which
+//	          matches the route
+//	ignore:   the request matches the route and is forwarded unchanged
+//
+// Routes can override the policy with the 'trailingslash' option.
+var TrailingSlash = "strict"
+
+// trailingSlash returns the trailing slash policy of the route which
+// is the first 'trailingslash' option of its targets or TrailingSlash.
+func (r *Route) trailingSlash() string {
+	for _, t := range r.Targets {
+		if t.TrailingSlash != "" {
+			return t.TrailingSlash
+		}
+	}
+	return TrailingSlash
+}
+
+// toggleSlash returns the path with the trailing slash added or
+// removed. It returns an empty string for the root path.
+func toggleSlash(path string) string {
+	switch {
+	case path == "" || path == "/":
+		return ""
+	case strings.HasSuffix(path, "/"):
+		return path[:len(path)-1]
+	default:
+		return path + "/"
+	}
+}
+
+// slashRedirect returns a target which redirects the requests for the
+// target to the path. The query string of the request is kept.
+func slashRedirect(t *Target, path string) *Target {
+	return &Target{
+		Service:       t.Service,
+		Tags:          t.Tags,
+		URL:           &url.URL{Path: path},
+		RedirectCode:  http.StatusPermanentRedirect,
+		RedirectQuery: "keep",
+		Timer:         t.Timer,
+		TimerName:     t.TimerName,
+		NoMetrics:     t.NoMetrics,
+		NoTotals:      t.NoTotals,
+	}
+}
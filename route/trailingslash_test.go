@@ -0,0 +1,63 @@
+package route
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTableLookup_TrailingSlash(t *testing.T) {
+	s := `
+	route add svc /app/ http://foo.com:1000
+	route add svc /api/ http://foo.com:2000 opts "trailingslash=ignore"
+	route add svc /doc/ http://foo.com:3000 opts "trailingslash=strict"
+	route add svc /files http://foo.com:4000
+	route add svc / http://foo.com:800
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy string
+		req    string
+		match  matcher
+		code   int
+		dst    string
+	}{
+		{"strict", "/app", prefixMatcher, 0, "http://foo.com:800"},
+		{"strict", "/app/", prefixMatcher, 0, "http://foo.com:1000"},
+		{"strict", "/api", prefixMatcher, 0, "http://foo.com:2000"},
+		{"redirect", "/app", prefixMatcher, 308, "/app/"},
+		{"redirect", "/app?a=b", prefixMatcher, 308, "/app/?a=b"},
+		{"redirect", "/app/x", prefixMatcher, 0, "http://foo.com:1000"},
+		{"redirect", "/api", prefixMatcher, 0, "http://foo.com:2000"},
+		{"redirect", "/doc", prefixMatcher, 0, "http://foo.com:800"},
+		{"redirect", "/", prefixMatcher, 0, "http://foo.com:800"},
+		{"redirect", "/files/", globMatcher, 308, "/files"},
+		{"ignore", "/app", prefixMatcher, 0, "http://foo.com:1000"},
+		{"ignore", "/doc", prefixMatcher, 0, "http://foo.com:800"},
+		{"ignore", "/files/", globMatcher, 0, "http://foo.com:4000"},
+	}
+
+	defer func() { TrailingSlash = "strict" }()
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+tt.req, func(t *testing.T) {
+			TrailingSlash = tt.policy
+			req := httptest.NewRequest("GET", "http://example.com"+tt.req, nil)
+			target := tbl.Lookup(req, "", rrPicker, tt.match, globCache, globEnabled)
+			if target == nil {
+				t.Fatal("no target")
+			}
+			got := target.URL.String()
+			if target.RedirectCode != 0 {
+				got = target.RedirectURL.String()
+			}
+			if got != tt.dst || target.RedirectCode != tt.code {
+				t.Fatalf("got %d %s want %d %s", target.RedirectCode, got, tt.code, tt.dst)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package trace
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"text/template"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/uuid"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
@@ -89,6 +91,33 @@ func CreateSpan(r *http.Request, cfg *config.Tracing) opentracing.Span {
 	return span // caller must defer span.finish()
 }
 
+// TraceID returns the trace id of the span as a hex string. Spans of the
+// zipkin tracer have a trace id even if the trace is not sampled. If
+// tracing is disabled the trace id of the B3 or the W3C trace context
+// headers of the request is used or a new id is generated so that the
+// access log entry and the error response of the request can still be
+// correlated.
+func TraceID(span opentracing.Span, h http.Header) string {
+	if span != nil {
+		if ctx, ok := span.Context().(zipkin.SpanContext); ok && !ctx.TraceID.Empty() {
+			return ctx.TraceID.ToHex()
+		}
+	}
+	if id := h.Get("X-B3-Traceid"); (len(id) == 16 || len(id) == 32) && isHex(id) {
+		return strings.ToLower(id)
+	}
+	// traceparent: <version>-<trace-id>-<parent-id>-<flags>
+	if p := strings.Split(h.Get("Traceparent"), "-"); len(p) == 4 && len(p[1]) == 32 && isHex(p[1]) {
+		return strings.ToLower(p[1])
+	}
+	return uuid.NewHex()
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
 // InitializeTracer initializes OpenTracing support if Tracing.TracingEnabled
 // is set in the config.
 func InitializeTracer(traceConfig *config.Tracing) {
@@ -147,3 +147,41 @@ func TestSpanName(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTraceID(t *testing.T) {
+	// unsampled spans of the zipkin tracer have a trace id
+	tracer, _ := zipkin.NewTracer(nil, zipkin.WithSampler(func(uint64) bool { return false }))
+	opentracing.SetGlobalTracer(tracer)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("X-B3-Traceid", "00000000000004d200000000000010e1")
+	req.Header.Set("X-B3-Spanid", "0000000000003039")
+	req.Header.Set("X-B3-Sampled", "0")
+	span := CreateSpan(req, &config.Tracing{ServiceName: "fabiolb-test"})
+	if got, want := TraceID(span, http.Header{}), "00000000000004d200000000000010e1"; got != want {
+		t.Errorf("zipkin: got %q want %q", got, want)
+	}
+
+	// ids of the request headers if tracing is disabled
+	noop := opentracing.NoopTracer{}.StartSpan("test")
+	tests := []struct {
+		name, value, want string
+	}{
+		{"X-B3-Traceid", "00000000000004D2", "00000000000004d2"},
+		{"Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set(tt.name, tt.value)
+		if got := TraceID(noop, h); got != tt.want {
+			t.Errorf("%s: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// new id otherwise
+	h := http.Header{}
+	h.Set("X-B3-Traceid", "invalid")
+	a, b := TraceID(noop, h), TraceID(nil, http.Header{})
+	if len(a) != 32 || a == b {
+		t.Errorf("got ids %q and %q", a, b)
+	}
+}
@@ -1,6 +1,8 @@
 package uuid
 
 import (
+	"encoding/hex"
+
 	"github.com/rogpeppe/fastuuid"
 )
 
@@ -10,3 +12,9 @@ var generator = fastuuid.MustNewGenerator()
 func NewUUID() string {
 	return ToString(generator.Next())
 }
+
+// NewHex returns a unique id as 32 hex characters without dashes.
+func NewHex() string {
+	u := generator.Next()
+	return hex.EncodeToString(u[:16])
+}